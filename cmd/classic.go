@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/classic"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Returns true if args start with a classic mode's subcommand.
+func isClassic(args []string) bool {
+	return len(args) > 0 && (args[0] == "ping" || args[0] == "trace")
+}
+
+// Runs "vasily ping" or "vasily trace", which print a line at a time like
+// ping and traceroute instead of starting the UI. Their flags are modeled on
+// those commands' rather than vasily's. Returns the exit status.
+func runClassic(args []string) int {
+	mode, args := args[0], args[1:]
+	fs := pflag.NewFlagSet("vasily "+mode, pflag.ContinueOnError)
+	fs.BoolVarP(&lookup.NumericMode, "numeric", "n", false, "Only display numeric IP addresses.")
+	iface := fs.StringP("interface", "I", "", "Network interface to send from.")
+	interval := fs.DurationP("interval", "i", time.Second,
+		fmt.Sprintf("Interval between probes. May not be less than %v.", maxPingInterval))
+	var (
+		pingOpts  classic.PingOptions
+		traceOpts classic.TraceOptions
+		src       *string
+		be        *backend.Name
+		deadline  *time.Duration
+	)
+	if mode == "ping" {
+		be = backend.FlagSetP(fs, "protocol", "P", "icmp", "Protocol to use for pings.")
+		src = fs.StringP("source", "S", "", "Source address to send from.")
+		fs.IntVarP(&pingOpts.Count, "count", "c", 0, "Stop after sending this many pings.")
+		fs.DurationVarP(&pingOpts.Timeout, "timeout", "W", time.Second, "Time to wait for each reply.")
+		deadline = fs.DurationP("deadline", "w", 0, "Stop after this long, however many pings have been sent.")
+		fs.IntVarP(&pingOpts.PayloadSize, "size", "s", 56,
+			fmt.Sprintf("Number of data bytes to send in each ping. May not be more than %d for IPv4 or %d for IPv6.",
+				pinger.MaxPayloadSize(util.IPv4), pinger.MaxPayloadSize(util.IPv6)))
+		fs.BoolVarP(&pingOpts.Quiet, "quiet", "q", false, "Only print the summary.")
+	} else {
+		be = backend.FlagSetP(fs, "protocol", "P", "udp", "Protocol to use for the trace.")
+		src = fs.StringP("source", "s", "", "Source address to send from.")
+		fs.IntVarP(&traceOpts.MaxTTL, "max_ttl", "m", 64, "Maximum path length to trace.")
+		fs.IntVarP(&traceOpts.Queries, "queries", "q", 3, "Number of probes to send to each hop.")
+		fs.BoolVar(&traceOpts.Paris, "paris", false, "Keep probes in a single flow so load balancers send them all along the same path.")
+		fs.IntVarP(&traceOpts.Port, "port", "p", 33434, "Destination port of the first probe with --protocol=udp.")
+		fs.BoolVar(&traceOpts.FixedPort, "fixed_port", false, "Send every probe to --port. Implies --paris.")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: vasily %s [flags] host\n", mode)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return classic.ExitOK
+		}
+		return classic.ExitUsage
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return classic.ExitUsage
+	}
+	host := fs.Arg(0)
+
+	// As in main, this is just for user-friendliness. The backends enforce
+	// the rate limit.
+	if *interval < maxPingInterval {
+		fmt.Fprintf(os.Stderr, "Interval may not be less than %v.\n", maxPingInterval)
+		return classic.ExitUsage
+	}
+	if mode == "ping" && (pingOpts.Count < 0 || pingOpts.Timeout <= 0) {
+		fmt.Fprintf(os.Stderr, "Count may not be negative, and timeout must be positive.\n")
+		return classic.ExitUsage
+	}
+	if mode == "trace" && (traceOpts.MaxTTL < 1 || traceOpts.MaxTTL > tracer.MaxTTL || traceOpts.Queries < 1) {
+		fmt.Fprintf(os.Stderr, "Max TTL must be between 1 and %d, and queries at least 1.\n", tracer.MaxTTL)
+		return classic.ExitUsage
+	}
+	if mode == "trace" && (traceOpts.Port < 1 || traceOpts.Port > backend.MaxPort) {
+		fmt.Fprintf(os.Stderr, "Port must be between 1 and %d.\n", backend.MaxPort)
+		return classic.ExitUsage
+	}
+	if mode == "trace" && traceOpts.FixedPort && *be != "udp" {
+		fmt.Fprintf(os.Stderr, "--fixed_port requires --protocol=udp.\n")
+		return classic.ExitUsage
+	}
+	if mode == "trace" && *be == "udp" && !traceOpts.FixedPort && traceOpts.Port+traceOpts.MaxTTL-1 > backend.MaxPort {
+		fmt.Fprintf(os.Stderr, "Probes up to --max_ttl would go past port %d. Lower --port or --max_ttl.\n", backend.MaxPort)
+		return classic.ExitUsage
+	}
+	if pingOpts.PayloadSize < 0 || pingOpts.PayloadSize > pinger.MaxPayloadSize(util.IPv6) {
+		fmt.Fprintf(os.Stderr, "Payload size must be between 0 and %d.\n", pinger.MaxPayloadSize(util.IPv6))
+		return classic.ExitUsage
+	}
+	srcOpt := backend.SourceOption{Interface: *iface}
+	if *iface != "" {
+		if _, err := net.InterfaceByName(*iface); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --interface: %v\n", err)
+			return classic.ExitUsage
+		}
+	}
+	if *src != "" {
+		srcOpt.Addr = net.ParseIP(*src)
+		if srcOpt.Addr == nil {
+			fmt.Fprintf(os.Stderr, "Bad --source: invalid IP address %q\n", *src)
+			return classic.ExitUsage
+		}
+	}
+
+	// Problems sending are logged rather than returned, and ping prints them
+	// as they happen.
+	logging.SetLevel(logging.Warn)
+	logging.SetOutput(os.Stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if deadline != nil && *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *deadline)
+		defer cancel()
+	}
+
+	var status int
+	var err error
+	if mode == "ping" {
+		pingOpts.Backend = *be
+		pingOpts.Interval = *interval
+		pingOpts.Source = srcOpt
+		status, err = classic.Ping(ctx, os.Stdout, host, &pingOpts)
+	} else {
+		traceOpts.Backend = *be
+		traceOpts.Interval = *interval
+		traceOpts.Source = srcOpt
+		status, err = classic.Trace(ctx, os.Stdout, host, &traceOpts)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vasily %s: %v\n", mode, err)
+	}
+	return status
+}
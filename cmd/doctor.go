@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/pflag"
+
+	"github.com/pcekm/vasily/internal/classic"
+	"github.com/pcekm/vasily/internal/doctor"
+	"github.com/pcekm/vasily/internal/logging"
+)
+
+// Returns true if args start with the doctor subcommand.
+func isDoctor(args []string) bool {
+	return len(args) > 0 && args[0] == "doctor"
+}
+
+// Runs "vasily doctor", which checks that everything pings need is in place,
+// and explains how to fix what isn't. Returns the exit status, which is 1 if
+// any check failed.
+func runDoctor(args []string) int {
+	fs := pflag.NewFlagSet("vasily doctor", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: vasily doctor\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return classic.ExitUsage
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return classic.ExitUsage
+	}
+
+	logging.SetLevel(logging.Warn)
+	logging.SetOutput(os.Stderr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if doctor.Run(ctx, os.Stdout) > 0 {
+		return 1
+	}
+	return 0
+}
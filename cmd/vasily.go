@@ -1,55 +1,247 @@
 // Command vasily is a ping utility that displays pings to multiple hosts in
 // a concise bar chart format. It can also ping the entire path to a remote host
 // with the --path flag.
+//
+// "vasily ping host" and "vasily trace host" instead print a line at a time,
+// like ping and traceroute, and exit with ping's status codes.
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"log"
+	"maps"
+	"net"
 	"os"
 	"path"
+	"runtime"
 	"runtime/debug"
+	"slices"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/pflag"
 
+	"github.com/pcekm/vasily/internal/alert"
 	"github.com/pcekm/vasily/internal/backend"
+	_ "github.com/pcekm/vasily/internal/backend/arp"
+	"github.com/pcekm/vasily/internal/backend/dns"
+	_ "github.com/pcekm/vasily/internal/backend/http"
 	_ "github.com/pcekm/vasily/internal/backend/icmp"
 	_ "github.com/pcekm/vasily/internal/backend/udp"
+	"github.com/pcekm/vasily/internal/baseline"
+	"github.com/pcekm/vasily/internal/capture"
+	"github.com/pcekm/vasily/internal/config"
+	"github.com/pcekm/vasily/internal/control"
+	"github.com/pcekm/vasily/internal/gateway"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
 	"github.com/pcekm/vasily/internal/privsep"
+	"github.com/pcekm/vasily/internal/report"
+	"github.com/pcekm/vasily/internal/session"
+	"github.com/pcekm/vasily/internal/store"
+	"github.com/pcekm/vasily/internal/targetlist"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tracer"
 	"github.com/pcekm/vasily/internal/tui"
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/uistate"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
+	"github.com/pcekm/vasily/internal/web"
 )
 
-const maxPingInterval = time.Second
+const (
+	maxPingInterval = time.Second
+
+	// Payload size for --pattern without --size. The same as ping's default.
+	defaultPatternSize = 56
+
+	// Most pings per burst. Matches the burst allowed by the per-connection
+	// rate limit.
+	maxBurst = 5
+
+	// How often to check --targets for changes.
+	targetListInterval = 2 * time.Second
+)
 
 var Version = "(unknown)" // Set via -ldflags
 
 // Flags.
 var (
 	pingPath     = pflag.Bool("path", false, "Ping complete path.")
+	gateways     = pflag.Bool("gateway", false, "Also ping the default gateways, to tell local network problems from ones further out.")
+	wellKnown    = pflag.Bool("well_known", false, "Also ping the default gateways, the system's DNS resolvers and the --anchors, for quick triage.")
+	anchors      = pflag.StringSlice("anchors", []string{"1.1.1.1", "8.8.8.8"}, "Well-known hosts pinged with --well_known.")
 	logfile      = pflag.String("logfile", "/dev/null", "File to output logs.")
+	logLevel     = pflag.String("log_level", "info", "Least important log messages to record: debug, info, warn or error. Press L to see recent ones.")
 	pingInterval = pflag.DurationP("interval", "i", time.Second,
 		fmt.Sprintf("Interval between pings to a single host. May not be less than %v.", maxPingInterval))
-	queries       = pflag.IntP("queries", "q", 3, "Number of times to query each TTL during a traceroute.")
+	count = pflag.IntP("count", "c", 0,
+		"Stop after sending this many pings to each host, and show when they're all done. On exit, the status is 0 if every host answered every ping, 1 if some were lost, 2 if a host couldn't be resolved, 3 if one never answered, and 4 if the run failed.")
+	deadline = pflag.DurationP("deadline", "w", 0,
+		"Stop pinging after this long, and show that the run has finished. The exit status is the same as with --count.")
+	quitWhenDone = pflag.Bool("quit_when_done", false,
+		"Exit once a run with --count or --deadline has finished, instead of waiting for a key. Always done when stdin or stdout isn't a terminal.")
+	adaptive = pflag.Bool("adaptive", false,
+		"Adjust the interval for each host based on latency and loss. The --interval flag sets the minimum.")
+	flood = pflag.Bool("flood", false,
+		"Send pings as fast as replies come back, or 100 times a second, whichever is more. Requires root, and asks for confirmation on the terminal.")
+	floodConfirm = pflag.Bool("flood_confirm", false,
+		"Don't ask for confirmation of --flood. For scripts, which may have no terminal to ask on.")
+	floodMaxPPS = pflag.Int("flood_max_pps", 0, "Maximum pings per second to each host with --flood. Zero for no limit.")
+	burst       = pflag.Int("burst", 1,
+		fmt.Sprintf("Number of pings to send to each host per interval, shown as one result. May not be more than %d, and the interval must allow %v per ping.", maxBurst, maxPingInterval))
+	burstLatency = pflag.String("burst_latency", "min", "Latency to show for a burst: min or median.")
+	smoke        = pflag.Int("smoke", 0,
+		"Like Smokeping, send this many pings to each host per interval, graph their median, and color the graph by how spread out the replies were. Sets --burst and --burst_latency.")
+	outageThreshold = pflag.Int("outage_threshold", 3,
+		"Number of consecutive lost pings that count as an outage. Press i to see a host's most recent outage.")
+	payloadSize = pflag.IntP("size", "s", 0,
+		fmt.Sprintf("Number of data bytes to send in each ping. May not be more than %d for IPv4 or %d for IPv6.",
+			pinger.MaxPayloadSize(util.IPv4), pinger.MaxPayloadSize(util.IPv6)))
+	payloadPattern = pflag.BytesHex("pattern", nil,
+		fmt.Sprintf("Hex bytes to fill ping payloads with. Random if unset. Implies --size=%d unless --size is set.", defaultPatternSize))
+	verifyPayload = pflag.Bool("verify_payload", false,
+		"Put a timestamp and checksum in each ping's payload, and count replies that don't echo them intact as corrupted. Needs --protocol=icmp.")
+	payloadTimestamp = pflag.Bool("payload_timestamp", false,
+		"Put the send time in each ping's payload, and time replies from it. More accurate for sub-millisecond latencies. Needs --protocol=icmp.")
+	queries       = pflag.IntP("queries", "q", 3, "Number of times to query each TTL during a traceroute. Ignored with --trace_continuous.")
 	traceInterval = pflag.Duration("trace_interval", time.Second,
 		fmt.Sprintf("Interval between traceroute probes. May not be less than %v.", maxPingInterval))
 	pingBackend  = backend.FlagP("protocol", "P", "icmp", "Protocol to use for pings.")
+	probe        = pflag.String("probe", "echo", "ICMP request to ping IPv4 hosts with: echo, timestamp or mask. Timestamp replies give clock offsets. Needs raw sockets.")
+	flowLabel    = pflag.Uint32("flow_label", 0, "IPv6 flow label for pings. Needs --protocol=icmp.")
+	dnsName      = pflag.String("dns_name", "", "Name to query with --protocol=dns. Defaults to each host's reverse DNS name.")
+	dnsType      = pflag.String("dns_type", "PTR", "Record type to query with --protocol=dns, like A or AAAA.")
+	httpURL      = pflag.String("http_url", "", "URL to fetch from each host with --protocol=http. Its host name is sent in the request, but connections go to each host. Defaults to http://<host>/.")
+	httpInsecure = pflag.Bool("http_insecure", false, "Skip verifying TLS certificates with --protocol=http.")
+	recvBuffer   = pflag.Int("recv_buffer", 0, "Size in bytes of the socket buffer replies wait in to be read. A bigger one drops fewer under heavy load. On Linux, drops are shown in the detail view. Zero for the system default.")
 	traceBackend = backend.FlagP("trace_protocol", "T", "udp", "Protocol to use for traceroutes.")
+	traceCont    = pflag.Bool("trace_continuous", false, "Keep re-tracing paths and update hops as routes change. Hop statistics come from the trace probes.")
+	paris        = pflag.Bool("paris", false, "Keep traceroute probes in a single flow so load balancers send them all along the same path.")
+	udpPort      = pflag.Int("udp_port", 33434, "Destination port of the first probe in a traceroute with --trace_protocol=udp.")
+	udpStride    = pflag.Int("udp_port_stride", 1, "Difference between the destination ports of consecutive traceroute probes with --trace_protocol=udp.")
+	udpFixedPort = pflag.Bool("udp_fixed_port", false, "Send every traceroute probe to --udp_port, for getting through firewalls that only let one port through. Implies --paris. Needs --trace_protocol=udp.")
+	returnPath   = pflag.Bool("return_path", false, "Estimate the length of each hop's return path from the TTLs of its replies, and flag hops whose replies seem to come back another way. Press i to see a hop's estimate.")
 	maxTTL       = pflag.Int("max_ttl", 64, "Maximum path length to trace.")
 	printVersion = pflag.BoolP("version", "v", false, "Output the version number.")
+	showP95      = pflag.Bool("p95", false, "Show the 95th percentile latency.")
+	showMinMax   = pflag.Bool("minmax", false, "Show the minimum and maximum latencies.")
+	showLast     = pflag.Bool("last", false, "Show the latest latency.")
+	showStdDev   = pflag.Bool("stddev", false, "Show the standard deviation of latencies.")
+	pathMTU      = pflag.Bool("pmtu", false, "Discover the path MTU to each host. Needs --protocol=icmp.")
+	showASN      = pflag.Bool("asn", false, "Look up the autonomous system of each host.")
+	showHops     = pflag.Bool("hops", false, "Show each host's distance in hops, estimated from reply TTLs.")
+	srcInterface = pflag.StringP("interface", "I", "", "Network interface to send from.")
+	srcAddr      = pflag.String("source", "",
+		"Source address to send from. Only used for hosts of the same IP version.")
+	fwmark = pflag.Uint32("fwmark", 0,
+		"Firewall mark (SO_MARK) for probes, to steer them with Linux policy routing. Needs --protocol and --trace_protocol of icmp or udp, and CAP_NET_ADMIN or root.")
+	netns = pflag.String("netns", "",
+		"Network namespace to send from, as created by \"ip netns add\". Hosts may use another with netns=NAME. Needs --protocol and --trace_protocol of icmp or udp, and CAP_SYS_ADMIN or root.")
+	nat64 = pflag.String("nat64", "",
+		"Reach IPv4-only hosts through NAT64 from an IPv6-only network. Either auto, to find the prefix with DNS64, or a prefix like 64:ff9b::/96.")
+	xlat = pflag.Bool("xlat", false,
+		"464XLAT testing: also ping hosts reached through --nat64 at their IPv4 address, through the local CLAT, to compare the paths.")
+	recordFile  = pflag.String("record", "", "Record the session to a file for later replay.")
+	captureFile = pflag.String("capture", "",
+		"Write the packets sent and received to a pcap file for Wireshark. They're reconstructed from what the protocol reports, so all appear as ICMP.")
+	storeFile = pflag.String("store", "",
+		"SQLite database to store ping results in. Results from earlier sessions are kept, so it can be queried later.")
+	storeRetention = pflag.Duration("store_retention", store.DefaultRetention,
+		"How long --store keeps each ping result. Older results are kept as aggregates.")
+	storeRollup = pflag.Duration("store_rollup", store.DefaultRollupPeriod,
+		"Length of time each aggregate kept by --store covers.")
+	storeRollupRetention = pflag.Duration("store_rollup_retention", store.DefaultRollupRetention,
+		"How long --store keeps aggregates.")
+	replayFile = pflag.String("replay", "", "Replay a session recorded with --record instead of pinging.")
+	targetFile = pflag.String("targets", "",
+		"File to read hosts from, one per line, optionally followed by ping, trace, interval=DUR, protocol=NAME or move=follow|report. Reloaded when it changes. - reads from stdin.")
+	summaryJSON = pflag.String("summary_json", "",
+		"Write a JSON summary of each target and the exit status to this file on exit. - writes to stdout.")
+	reportFormat = pflag.String("report", "",
+		"Print a summary of each target on exit: text, json or markdown. If hosts were switched between names and addresses in the table, they're shown that way. With --replay, summarizes the recording instead of showing it.")
+	baselineName = pflag.String("baseline", "",
+		"Compare each target's latency against a baseline saved with --save_baseline, in a Delta column.")
+	saveBaseline = pflag.String("save_baseline", "",
+		"Save each target's average and 95th percentile latency on exit as a baseline with this name.")
+	baselineThreshold = pflag.Float64("baseline_threshold", 100*baseline.DefaultThreshold,
+		"Percent rise in latency over --baseline that's highlighted as a regression.")
+	replaySpeed = pflag.Float64("replay_speed", 1, "Playback speed multiplier for --replay.")
+	alertRules  = pflag.StringArray("alert", nil,
+		"Alert threshold, like loss>5%, latency>150ms/30 or example.com=loss>20%/50. May be repeated.")
+	alertCommand = pflag.String("alert_command", "",
+		"Shell command to run when an alert fires or resolves. See VASILY_ALERT_* environment variables.")
+	alertWebhook = pflag.String("alert_webhook", "", "URL to POST a JSON description of alerts to.")
+	reResolve    = pflag.Duration("reresolve_interval", 0,
+		"Look up host names again this often, to catch them moving to a new address. 0 never looks them up again.")
+	onMove = pflag.String("on_move", "follow",
+		"What to do when a host's name resolves to a new address: follow, or report it and keep pinging the old one.")
+	controlAddr = pflag.String("control", "",
+		"Serve the JSON-RPC control API on a unix socket path, or a loopback TCP address like localhost:7070. Other local users can connect over TCP, so targets added that way may not set interface, netns or protocol.")
+	webAddr = pflag.String("web", "",
+		"Serve a live dashboard over HTTP on an address like localhost:8080. It's read-only, but shows the results to anyone who can connect.")
+	lookupURL = pflag.String("lookup_url", tui.DefaultLookupURL,
+		"Web page opened by the o key to look up the selected row's address, with %s where the address goes.")
+	tabPerTrace = pflag.Bool("tab_per_trace", false,
+		"Show each traced path in a tab of its own, switched between with the number keys.")
+	graphScale = pflag.String("graph_scale", "linear", "Latency graph scale: linear, log or auto.")
+	graphMax   = pflag.Duration("graph_max", table.DefaultGraphMax,
+		"Latency at which the graph displays at full height. Ignored by --graph_scale=auto.")
+	graphStyle = pflag.String("graph_style", "bars",
+		"Latency graph characters: bars, or braille or quadrant to fit two pings in each character.")
+	ascii = pflag.Bool("ascii", false,
+		"Draw with ASCII characters only, for dumb terminals and logs. Colors are still set by $NO_COLOR and the terminal.")
+	statsWindow = pflag.Duration("stats_window", 0,
+		"Show statistics for the last 1m, 5m or 15m instead of all time. Press w to cycle through them.")
+	configFile = pflag.String("config", "",
+		"Config file to read, in a restricted subset of TOML. Defaults to vasily/config.toml in $XDG_CONFIG_HOME or ~/.config.")
+	themeName = pflag.String("theme", "default",
+		"UI theme: default, dark, light, high-contrast, mono, or the path to a theme file. Press t to cycle through them.")
+	sortOrder = pflag.String("sort", "",
+		"Initial sort order, like loss,-avgms. Columns are named by their titles. A leading - reverses.")
 )
 
+// Values for --probe.
+var probeTypes = map[string]backend.PacketType{
+	"echo":      backend.PacketRequest,
+	"timestamp": backend.PacketTimestampRequest,
+	"mask":      backend.PacketAddressMaskRequest,
+}
+
 // FlagVars.
 func init() {
 	pflag.BoolVarP(&lookup.NumericMode, "numeric", "n", false, "Only display numeric IP addresses.")
 }
 
 func main() {
+	os.Exit(int(run()))
+}
+
+// Runs vasily, and returns the status to exit with, which reflects the
+// targets only for runs with --count or --deadline. Exits early on errors.
+func run() report.Status {
 	privsepCleanup := privsep.Initialize()
 	defer privsepCleanup()
 
+	if isClassic(os.Args[1:]) {
+		status := runClassic(os.Args[1:])
+		privsepCleanup()
+		os.Exit(status)
+	}
+	if isDoctor(os.Args[1:]) {
+		status := runDoctor(os.Args[1:])
+		privsepCleanup()
+		os.Exit(status)
+	}
+
 	pflag.Parse()
 
 	if *printVersion {
@@ -57,10 +249,37 @@ func main() {
 		os.Exit(0)
 	}
 
-	if len(pflag.Args()) == 0 {
+	cfg := loadConfig()
+	state := loadState(cfg)
+	specs, err := cfg.Targets(pflag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad host: %v\n", err)
+		os.Exit(1)
+	}
+	var hosts []targetlist.Entry
+	for _, s := range specs {
+		h, err := targetlist.ParseSpec(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad host %q: %v\n", s, err)
+			os.Exit(1)
+		}
+		hosts = append(hosts, h)
+	}
+	if *gateways {
+		hosts = append(hosts, gatewayHosts()...)
+	}
+	if *wellKnown {
+		hosts = appendNew(hosts, wellKnownHosts()...)
+	}
+
+	if len(hosts) == 0 && *replayFile == "" && *targetFile == "" {
 		pflag.Usage()
 		os.Exit(1)
 	}
+	if *replayFile != "" && *targetFile != "" {
+		fmt.Fprintf(os.Stderr, "--targets can't be used with --replay.\n")
+		os.Exit(1)
+	}
 
 	// This is just for user-friendliness. The important check is the rate
 	// limiter in the backend, since that gets applied in the privsep server.
@@ -69,30 +288,775 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *flood {
+		confirmFlood(len(hosts))
+	} else if *floodMaxPPS != 0 || *floodConfirm {
+		fmt.Fprintf(os.Stderr, "--flood_max_pps and --flood_confirm require --flood.\n")
+		os.Exit(1)
+	}
+
+	request, ok := probeTypes[*probe]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Bad --probe: %q is not echo, timestamp or mask.\n", *probe)
+		os.Exit(1)
+	}
+	if request != backend.PacketRequest && *pingBackend != "icmp" {
+		fmt.Fprintf(os.Stderr, "--probe=%s requires --protocol=icmp.\n", *probe)
+		os.Exit(1)
+	}
+
+	if *smoke != 0 {
+		if pflag.CommandLine.Changed("burst") || pflag.CommandLine.Changed("burst_latency") {
+			fmt.Fprintf(os.Stderr, "--smoke can't be used with --burst or --burst_latency.\n")
+			os.Exit(1)
+		}
+		*burst = *smoke
+		*burstLatency = pinger.BurstMedian.String()
+	}
+	if *flood && *burst != 1 {
+		fmt.Fprintf(os.Stderr, "--burst can't be used with --flood.\n")
+		os.Exit(1)
+	}
+	if *burst < 1 || *burst > maxBurst {
+		fmt.Fprintf(os.Stderr, "Burst must be between 1 and %d.\n", maxBurst)
+		os.Exit(1)
+	}
+	if *pingInterval < time.Duration(*burst)*maxPingInterval {
+		fmt.Fprintf(os.Stderr, "Ping interval may not be less than %v with --burst=%d.\n",
+			time.Duration(*burst)*maxPingInterval, *burst)
+		os.Exit(1)
+	}
+	burstLat, err := pinger.ParseBurstLatency(*burstLatency)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --burst_latency: %v\n", err)
+		os.Exit(1)
+	}
+	if *count < 0 {
+		fmt.Fprintf(os.Stderr, "Count may not be negative.\n")
+		os.Exit(1)
+	}
+	if *deadline < 0 {
+		fmt.Fprintf(os.Stderr, "Deadline may not be negative.\n")
+		os.Exit(1)
+	}
+	if *outageThreshold < 1 {
+		fmt.Fprintf(os.Stderr, "Outage threshold must be at least 1.\n")
+		os.Exit(1)
+	}
+
+	if *flowLabel > backend.MaxFlowLabel {
+		fmt.Fprintf(os.Stderr, "Flow label may not be more than %d.\n", backend.MaxFlowLabel)
+		os.Exit(1)
+	}
+	if *flowLabel != 0 && *pingBackend != "icmp" {
+		fmt.Fprintf(os.Stderr, "--flow_label requires --protocol=icmp.\n")
+		os.Exit(1)
+	}
+	if *verifyPayload && *pingBackend != "icmp" {
+		fmt.Fprintf(os.Stderr, "--verify_payload requires --protocol=icmp.\n")
+		os.Exit(1)
+	}
+	if *payloadTimestamp && *pingBackend != "icmp" {
+		fmt.Fprintf(os.Stderr, "--payload_timestamp requires --protocol=icmp.\n")
+		os.Exit(1)
+	}
+	if *pathMTU && *pingBackend != "icmp" {
+		fmt.Fprintf(os.Stderr, "--pmtu requires --protocol=icmp.\n")
+		os.Exit(1)
+	}
+	if *recvBuffer < 0 || *recvBuffer > backend.MaxRecvBuffer {
+		fmt.Fprintf(os.Stderr, "Receive buffer size must be between 0 and %d.\n", backend.MaxRecvBuffer)
+		os.Exit(1)
+	}
+	if *udpPort < 1 || *udpPort > backend.MaxPort || *udpStride < 1 {
+		fmt.Fprintf(os.Stderr, "UDP port must be between 1 and %d, and its stride at least 1.\n", backend.MaxPort)
+		os.Exit(1)
+	}
+	if *udpFixedPort && *traceBackend != "udp" {
+		fmt.Fprintf(os.Stderr, "--udp_fixed_port requires --trace_protocol=udp.\n")
+		os.Exit(1)
+	}
+	if *maxTTL < 1 || *maxTTL > tracer.MaxTTL {
+		fmt.Fprintf(os.Stderr, "--max_ttl must be between 1 and %d.\n", tracer.MaxTTL)
+		os.Exit(1)
+	}
+	// The tracer only wraps ports around between passes, so the first has
+	// to fit.
+	if *traceBackend == "udp" && !*udpFixedPort && *udpPort+(*maxTTL-1)**udpStride > backend.MaxPort {
+		fmt.Fprintf(os.Stderr, "UDP probes up to --max_ttl would go past port %d. Lower --udp_port, --udp_port_stride or --max_ttl.\n", backend.MaxPort)
+		os.Exit(1)
+	}
+	linuxFlags := []struct {
+		name string
+		set  bool
+	}{
+		{"fwmark", *fwmark != 0},
+		{"netns", *netns != ""},
+	}
+	for _, f := range linuxFlags {
+		if !f.set {
+			continue
+		}
+		if runtime.GOOS != "linux" {
+			fmt.Fprintf(os.Stderr, "Bad --%s: not supported on %s.\n", f.name, runtime.GOOS)
+			os.Exit(1)
+		}
+		for _, be := range []backend.Name{*pingBackend, *traceBackend} {
+			if be != "icmp" && be != "udp" {
+				fmt.Fprintf(os.Stderr, "--%s requires --protocol and --trace_protocol of icmp or udp.\n", f.name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	dnsQType, err := dns.ParseType(*dnsType)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --dns_type: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *storeRetention <= 0 || *storeRollup <= 0 || *storeRollupRetention <= 0 {
+		fmt.Fprintf(os.Stderr, "--store durations must be positive.\n")
+		os.Exit(1)
+	}
+
+	if *replaySpeed <= 0 {
+		fmt.Fprintf(os.Stderr, "Replay speed must be positive.\n")
+		os.Exit(1)
+	}
+
+	if *payloadSize < 0 || *payloadSize > pinger.MaxPayloadSize(util.IPv6) {
+		fmt.Fprintf(os.Stderr, "Payload size must be between 0 and %d.\n", pinger.MaxPayloadSize(util.IPv6))
+		os.Exit(1)
+	}
+	if len(*payloadPattern) > 0 && !pflag.CommandLine.Changed("size") {
+		*payloadSize = defaultPatternSize
+	}
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --log_level: %v\n", err)
+		os.Exit(1)
+	}
+	logging.SetLevel(level)
+	logging.SetOutput(os.Stderr)
 	if *logfile != "" {
-		logf, err := tea.LogToFile(*logfile, "")
+		logf, err := os.OpenFile(*logfile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
 		if err != nil {
 			log.Fatalf("Error opening output log: %v", err)
 		}
 		defer logf.Close()
+		logging.SetOutput(logf)
+	}
+	// Whatever is still logged through the log package ends up in the same
+	// place.
+	log.SetFlags(0)
+	log.SetOutput(logging.Default().Writer())
+
+	var reportFmt report.Format
+	if *reportFormat != "" {
+		reportFmt, err = report.ParseFormat(*reportFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --report: %v\n", err)
+			os.Exit(1)
+		}
+		if *replayFile != "" {
+			printRecordingReport(*replayFile, reportFmt)
+			os.Exit(0)
+		}
+	}
+
+	baselineDir, err := baseline.DefaultDir()
+	if err != nil && (*baselineName != "" || *saveBaseline != "") {
+		fmt.Fprintf(os.Stderr, "Error finding baseline directory: %v\n", err)
+		os.Exit(1)
+	}
+	var base *baseline.Baseline
+	if *baselineName != "" {
+		base, err = baseline.Load(baselineDir, *baselineName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --baseline: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *baselineThreshold < 0 {
+		fmt.Fprintf(os.Stderr, "Bad --baseline_threshold: %v is negative\n", *baselineThreshold)
+		os.Exit(1)
+	}
+
+	scale, err := table.ParseScale(*graphScale)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --graph_scale: %v\n", err)
+		os.Exit(1)
+	}
+	if *graphMax <= 0 {
+		fmt.Fprintf(os.Stderr, "--graph_max must be positive.\n")
+		os.Exit(1)
+	}
+
+	src := backend.SourceOption{Interface: *srcInterface, Mark: *fwmark, Netns: *netns}
+	if err := targets.CheckSource(src, *pingBackend, *traceBackend); err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --interface, --netns or --fwmark: %v\n", err)
+		os.Exit(1)
+	}
+	if *srcAddr != "" {
+		src.Addr = net.ParseIP(*srcAddr)
+		if src.Addr == nil {
+			fmt.Fprintf(os.Stderr, "Bad --source: invalid IP address %q\n", *srcAddr)
+			os.Exit(1)
+		}
+	}
+
+	style, err := table.ParseGraphStyle(*graphStyle)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --graph_style: %v\n", err)
+		os.Exit(1)
+	}
+	if *statsWindow != 0 && !slices.Contains(pinger.StatsWindows, *statsWindow) {
+		fmt.Fprintf(os.Stderr, "Bad --stats_window: %v is not 1m, 5m or 15m.\n", *statsWindow)
+		os.Exit(1)
+	}
+
+	thm, err := theme.Find(*themeName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --theme: %v\n", err)
+		os.Exit(1)
+	}
+
+	sortCols := cfg.Sort
+	if pflag.CommandLine.Changed("sort") {
+		sortCols, err = table.ParseSort(*sortOrder)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --sort: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var clatPrefix *net.IPNet
+	if *nat64 != "" {
+		prefix, err := lookup.ParseNAT64(*nat64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --nat64: %v\n", err)
+			os.Exit(1)
+		}
+		log.Printf("Using NAT64 prefix %v", prefix)
+		lookup.NAT64Prefix = prefix
+		if *xlat {
+			clatPrefix = prefix
+		}
+	} else if *xlat {
+		fmt.Fprintf(os.Stderr, "--xlat requires --nat64.\n")
+		os.Exit(1)
+	}
+
+	var rules []alert.Rule
+	for _, s := range *alertRules {
+		r, err := alert.ParseRule(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --alert: %v\n", err)
+			os.Exit(1)
+		}
+		rules = append(rules, r)
+	}
+
+	movePolicy, err := targetlist.ParseMovePolicy(*onMove)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bad --on_move: %v\n", err)
+		os.Exit(1)
+	}
+
+	targetOpts := &targets.Options{
+		Trace:             *pingPath,
+		PingInterval:      *pingInterval,
+		Count:             *count,
+		Deadline:          *deadline,
+		AdaptiveInterval:  *adaptive,
+		Flood:             *flood,
+		FloodMaxPPS:       *floodMaxPPS,
+		ProbesPerInterval: *burst,
+		BurstLatency:      burstLat,
+		OutageThreshold:   *outageThreshold,
+		PingBackend:       *pingBackend,
+		PingRequest:       request,
+		FlowLabel:         *flowLabel,
+		DNSQuery:          backend.DNSQueryOption{Name: *dnsName, Type: dnsQType},
+		HTTP:              backend.HTTPOption{URL: *httpURL, Insecure: *httpInsecure},
+		RecvBuffer:        *recvBuffer,
+		CLATPrefix:        clatPrefix,
+		TraceInterval:     *traceInterval,
+		TraceBackend:      *traceBackend,
+		TraceMaxTTL:       *maxTTL,
+		ProbesPerHop:      *queries,
+		ContinuousTrace:   *traceCont,
+		ParisTrace:        *paris,
+		TraceBasePort:     *udpPort,
+		TracePortStride:   *udpStride,
+		TraceFixedPort:    *udpFixedPort,
+		ReturnPath:        *returnPath,
+		PayloadSize:       *payloadSize,
+		PayloadPattern:    *payloadPattern,
+		VerifyPayload:     *verifyPayload,
+		PayloadTimestamp:  *payloadTimestamp,
+		Source:            src,
+		AlertRules:        rules,
+		AlertNotifier:     &alert.Notifier{Command: *alertCommand, Webhook: *alertWebhook},
+		ReResolveInterval: *reResolve,
+		OnMove:            movePolicy,
+		Labels:            labels(cfg, state),
+	}
+	if *recordFile != "" {
+		f, err := os.Create(*recordFile)
+		if err != nil {
+			log.Fatalf("Error creating recording: %v", err)
+		}
+		defer f.Close()
+		targetOpts.Recorder = session.NewRecorder(f)
+	}
+	if *captureFile != "" {
+		f, err := os.Create(*captureFile)
+		if err != nil {
+			log.Fatalf("Error creating capture: %v", err)
+		}
+		defer f.Close()
+		w, err := capture.NewWriter(f)
+		if err != nil {
+			log.Fatalf("Error creating capture: %v", err)
+		}
+		backend.UseWrapper(w.Wrap)
+	}
+	if *storeFile != "" {
+		st, err := store.Open(*storeFile, &store.Options{
+			Retention:       *storeRetention,
+			RollupPeriod:    *storeRollup,
+			RollupRetention: *storeRollupRetention,
+		})
+		if err != nil {
+			log.Fatalf("Error opening --store: %v", err)
+		}
+		defer st.Close()
+		targetOpts.Store = st
+	}
+	mgr := targets.New(targetOpts)
+	defer mgr.Close()
+
+	teaOpts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithMouseCellMotion()}
+	if *targetFile == "-" {
+		list := targetlist.New(mgr)
+		go func() {
+			if err := list.Read(os.Stdin); err != nil {
+				log.Printf("Error reading targets: %v", err)
+			}
+		}()
+		// Stdin is taken, so keys come from the terminal.
+		teaOpts = append(teaOpts, tea.WithInputTTY())
+	} else if *targetFile != "" {
+		list := targetlist.New(mgr)
+		if err := list.LoadFile(*targetFile); err != nil {
+			log.Fatalf("Error reading --targets: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go list.WatchFile(ctx, *targetFile, targetListInterval)
 	}
 
 	opts := &tui.Options{
-		Trace:         *pingPath,
-		PingInterval:  *pingInterval,
-		PingBackend:   *pingBackend,
-		TraceInterval: *traceInterval,
-		TraceBackend:  *traceBackend,
-		TraceMaxTTL:   *maxTTL,
-		ProbesPerHop:  *queries,
+		Targets:     mgr,
+		GraphScale:  scale,
+		GraphMax:    *graphMax,
+		GraphStyle:  style,
+		Smoke:       *smoke != 0,
+		Theme:       thm,
+		Sort:        sortCols,
+		Columns:     cfg.Columns,
+		PathMTU:     *pathMTU,
+		ASN:         *showASN,
+		LookupURL:   *lookupURL,
+		TabPerTrace: *tabPerTrace,
+		Labels:      state.Labels,
+
+		Baseline:          base,
+		BaselineThreshold: *baselineThreshold / 100,
+		StatsWindow:       *statsWindow,
+	}
+	if path, err := configPath(); err == nil {
+		opts.SaveColumns = func(cols []table.ColumnID) error { return config.SaveColumns(path, cols) }
 	}
-	tbl, err := tui.New(pflag.Args(), opts)
+	if *showP95 {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColP95)
+	}
+	if *showMinMax {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColMinMs, table.ColMaxMs)
+	}
+	if *showLast {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColLast)
+	}
+	if *showStdDev {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColStdDev)
+	}
+	if *pathMTU {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColPathMTU)
+	}
+	if *showASN {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColASN)
+	}
+	if *showHops {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColHops)
+	}
+	if base != nil {
+		opts.ShowColumns = append(opts.ShowColumns, table.ColDelta)
+	}
+	if *replayFile != "" {
+		f, err := os.Open(*replayFile)
+		if err != nil {
+			log.Fatalf("Error opening recording: %v", err)
+		}
+		defer f.Close()
+		go mgr.Replay(session.NewPlayer(f, *replaySpeed))
+		// The recording replaces the hosts.
+		hosts = nil
+	}
+	caps := termcap.Detect()
+	caps.ASCII = *ascii
+	termcap.Set(caps)
+	tbl, err := tui.New(hosts, opts)
 	if err != nil {
 		log.Fatalf("Error initializing UI: %v", err)
 	}
 
-	prog := tea.NewProgram(tbl, tea.WithAltScreen())
-	prog.Run()
+	if *controlAddr != "" {
+		srv, err := control.Listen(*controlAddr, control.TargetsHandler(mgr))
+		if err != nil {
+			log.Fatalf("Error starting control API: %v", err)
+		}
+		defer srv.Close()
+		go func() {
+			if err := srv.Serve(); err != nil {
+				log.Printf("Control API error: %v", err)
+			}
+		}()
+	}
+	if *webAddr != "" {
+		srv, err := web.Listen(*webAddr, mgr, &web.Options{
+			View: &view.Options{Baseline: base, BaselineThreshold: *baselineThreshold / 100},
+		})
+		if err != nil {
+			log.Fatalf("Error starting dashboard: %v", err)
+		}
+		defer srv.Close()
+		log.Printf("Serving dashboard at http://%v/", srv.Addr())
+		go func() {
+			if err := srv.Serve(); err != nil {
+				log.Printf("Dashboard error: %v", err)
+			}
+		}()
+	}
+	if !haveTerminal() {
+		// Run without the UI, so that scripts still get the exit status and
+		// summary. Options that come later take precedence.
+		teaOpts = append(teaOpts, tea.WithInput(nil), tea.WithoutRenderer())
+	}
+	prog := tea.NewProgram(tbl, teaOpts...)
+	if (*count > 0 || *deadline > 0) && (*quitWhenDone || !interactive()) {
+		go func() {
+			<-mgr.Finished()
+			prog.Quit()
+		}()
+	}
+	if _, err := prog.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running UI: %v\n", err)
+		return report.Failed
+	}
+	saveState(state, tbl.State())
+
+	rows := report.FromTargets(mgr.Targets())
+	if *reportFormat != "" {
+		if err := writeReport(rows, reportFmt, tbl); err != nil {
+			log.Printf("Error writing report: %v", err)
+		}
+	}
+	if *saveBaseline != "" {
+		b := baseline.FromTargets(*saveBaseline, mgr.Targets(), time.Now())
+		if err := baseline.Save(baselineDir, b); err != nil {
+			log.Printf("Error saving baseline: %v", err)
+		}
+	}
+	if *summaryJSON != "" {
+		writeSummary(*summaryJSON, rows, tbl.Unresolved())
+	}
+	if *count == 0 && *deadline == 0 {
+		return report.Healthy
+	}
+	return report.StatusOf(rows, tbl.Unresolved())
+}
+
+// Returns true if stdin and stdout are both terminals, so that someone may be
+// watching and can press a key to quit.
+func interactive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// Returns true if there's a terminal to show the UI on and read keys from.
+// Keys come from the controlling terminal when stdin isn't one.
+func haveTerminal() bool {
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		return false
+	}
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		return true
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+// Writes the --summary_json file, or to stdout for -.
+func writeSummary(path string, rows []report.Row, unresolved []string) {
+	if path == "-" {
+		if err := report.WriteSummary(os.Stdout, rows, unresolved); err != nil {
+			log.Printf("Error writing summary: %v", err)
+		}
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("Error creating summary: %v", err)
+		return
+	}
+	defer f.Close()
+	if err := report.WriteSummary(f, rows, unresolved); err != nil {
+		log.Printf("Error writing summary: %v", err)
+	}
+}
+
+// Returns the default gateways as hosts to ping. Exits on errors, or if there
+// aren't any.
+func gatewayHosts() []targetlist.Entry {
+	gws, err := gateway.Default()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding default gateways: %v\n", err)
+		os.Exit(1)
+	}
+	if len(gws) == 0 {
+		fmt.Fprintf(os.Stderr, "No default gateway found.\n")
+		os.Exit(1)
+	}
+	var res []targetlist.Entry
+	for _, gw := range gws {
+		res = append(res, targetlist.Entry{Host: gw.String()})
+	}
+	return res
+}
+
+// Returns the default gateways, DNS resolvers and --anchors as hosts to ping.
+// Since they're only a starting point, gateways and resolvers that can't be
+// found are just reported.
+func wellKnownHosts() []targetlist.Entry {
+	var ips []net.IP
+	gws, err := gateway.Default()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding default gateways: %v\n", err)
+	}
+	ips = append(ips, gws...)
+	resolvers, err := lookup.Resolvers()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error finding DNS resolvers: %v\n", err)
+	}
+	ips = append(ips, resolvers...)
+
+	var res []targetlist.Entry
+	for _, ip := range ips {
+		res = appendNew(res, targetlist.Entry{Host: ip.String()})
+	}
+	for _, a := range *anchors {
+		res = appendNew(res, targetlist.Entry{Host: a})
+	}
+	return res
+}
+
+// Appends the hosts that aren't already in hosts.
+func appendNew(hosts []targetlist.Entry, add ...targetlist.Entry) []targetlist.Entry {
+	for _, h := range add {
+		if !slices.ContainsFunc(hosts, func(o targetlist.Entry) bool { return o.Host == h.Host }) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// Writes the report for --report to stdout. Hosts are shown the usual way
+// unless they were switched between names and addresses in the table.
+func writeReport(rows []report.Row, f report.Format, m *tui.Model) error {
+	if hosts, changed := m.HostDisplay(); changed {
+		return report.WriteHosts(os.Stdout, rows, f, hosts)
+	}
+	return report.Write(os.Stdout, rows, f)
+}
+
+// Prints a report summarizing a recording. Exits on errors.
+func printRecordingReport(path string, f report.Format) {
+	r, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening recording: %v\n", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+	if err := report.Write(os.Stdout, report.FromRecording(r), f); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Reads the UI state saved by earlier runs and uses it for any settings that
+// weren't set on the command line or in the config file. Problems with the
+// state aren't fatal, since it's only a convenience.
+func loadState(cfg *config.Config) *uistate.State {
+	path, err := uistate.DefaultPath()
+	if err != nil {
+		return &uistate.State{}
+	}
+	state, err := uistate.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring saved UI state: %v\n", err)
+		return &uistate.State{}
+	}
+	settings := map[string]string{
+		"theme": state.Theme,
+		"sort":  table.FormatSort(state.Sort),
+	}
+	// The config file's theme has already been set on its flag, but its sort
+	// order is kept apart.
+	if len(cfg.Sort) > 0 {
+		delete(settings, "sort")
+	}
+	for name, val := range settings {
+		if val == "" || pflag.CommandLine.Changed(name) {
+			continue
+		}
+		if err := pflag.Set(name, val); err != nil {
+			fmt.Fprintf(os.Stderr, "Ignoring saved %s: %v\n", name, err)
+		}
+	}
+	return state
+}
+
+// Returns the target labels from the config file, with those edited in the
+// UI in earlier runs taking precedence.
+func labels(cfg *config.Config, state *uistate.State) map[string]string {
+	res := maps.Clone(cfg.Labels)
+	if res == nil {
+		res = make(map[string]string)
+	}
+	maps.Copy(res, state.Labels)
+	return res
+}
+
+// Saves the settings changed in the UI for the next run, along with those
+// saved before.
+func saveState(state, changes *uistate.State) {
+	path, err := uistate.DefaultPath()
+	if err != nil {
+		return
+	}
+	state.Update(changes)
+	if err := uistate.Save(path, state); err != nil {
+		log.Printf("Error saving UI state: %v", err)
+	}
+}
+
+// Reads the config file and uses its settings for any flags that weren't set on
+// the command line. Exits on errors.
+func loadConfig() *config.Config {
+	var cfg *config.Config
+	var err error
+	if *configFile != "" {
+		cfg, err = config.Load(*configFile)
+	} else {
+		cfg, err = config.LoadDefault()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	settings := map[string]string{
+		"protocol": string(cfg.Protocol),
+		"theme":    cfg.Theme,
+	}
+	if cfg.GraphStyle != nil {
+		settings["graph_style"] = cfg.GraphStyle.String()
+	}
+	if cfg.Interval != 0 {
+		settings["interval"] = cfg.Interval.String()
+	}
+	if len(cfg.Anchors) > 0 {
+		settings["anchors"] = strings.Join(cfg.Anchors, ",")
+	}
+	for name, val := range settings {
+		if val == "" || pflag.CommandLine.Changed(name) {
+			continue
+		}
+		if err := pflag.Set(name, val); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad %s in config: %v\n", name, err)
+			os.Exit(1)
+		}
+	}
+	return cfg
+}
+
+// Returns the path of the config file in use.
+func configPath() (string, error) {
+	if *configFile != "" {
+		return *configFile, nil
+	}
+	return config.DefaultPath()
+}
+
+// Checks the --flood flag and asks the user to confirm it, unless
+// --flood_confirm already has. Exits unless they do. The question is asked on
+// the controlling terminal, since stdin may be a pipe, such as the one
+// --targets=- reads from. As with --interval, the root check is just for
+// user-friendliness. The backends enforce it.
+func confirmFlood(nHosts int) {
+	if os.Getuid() != 0 {
+		fmt.Fprintf(os.Stderr, "--flood requires root.\n")
+		os.Exit(1)
+	}
+	if *adaptive {
+		fmt.Fprintf(os.Stderr, "--flood and --adaptive may not be used together.\n")
+		os.Exit(1)
+	}
+	if *floodMaxPPS < 0 {
+		fmt.Fprintf(os.Stderr, "--flood_max_pps may not be negative.\n")
+		os.Exit(1)
+	}
+	if *floodConfirm {
+		return
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--flood asks for confirmation on the terminal, and there isn't one. Use --flood_confirm to skip it.\n")
+		os.Exit(1)
+	}
+	defer tty.Close()
+	if *targetFile != "" {
+		fmt.Fprintf(tty, "Flood mode pings the hosts in --targets, and any others given, as fast as\n")
+		fmt.Fprintf(tty, "they reply. This can overwhelm networks and hosts, so only flood ones\n")
+		fmt.Fprintf(tty, "you're responsible for.\n")
+	} else {
+		fmt.Fprintf(tty, "Flood mode pings %d host(s) as fast as they reply. This can overwhelm\n", nHosts)
+		fmt.Fprintf(tty, "networks and hosts, so only flood ones you're responsible for.\n")
+	}
+	fmt.Fprintf(tty, "Continue? [y/N] ")
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		fmt.Fprintf(os.Stderr, "Not flooding.\n")
+		os.Exit(1)
+	}
 }
 
 func printVersionInfo() {
@@ -0,0 +1,160 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Set in the environment of child processes that run vasily itself, since
+// it exits when it's done.
+const childEnv = "VASILY_CMD_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(childEnv) != "" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// The parts of a --summary_json summary checked here.
+type summary struct {
+	Status     int      `json:"status"`
+	StatusName string   `json:"status_name"`
+	Unresolved []string `json:"unresolved"`
+	Targets    []struct {
+		Addr string `json:"addr"`
+		Sent int    `json:"sent"`
+		Lost int    `json:"lost"`
+	} `json:"targets"`
+}
+
+// Runs vasily with args in a new session, so that it has no controlling
+// terminal, and with stdin left open like the end of a pipe. Returns its exit
+// status and the summary it writes to stdout.
+func runNoTerminal(t *testing.T, args ...string) (int, summary) {
+	t.Helper()
+	dir := t.TempDir()
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe error: %v", err)
+	}
+	defer stdin.Close()
+	defer w.Close()
+
+	cmd := exec.Command(os.Args[0], append([]string{"--summary_json=-"}, args...)...)
+	cmd.Env = append(os.Environ(), childEnv+"=1", "HOME="+dir, "XDG_CONFIG_HOME="+dir, "XDG_STATE_HOME="+dir)
+	cmd.Stdin = stdin
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	cmd.WaitDelay = time.Second
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+			cmd.Process.Kill()
+		}
+	}()
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("Error running vasily: %v", err)
+	}
+	var sum summary
+	if err := json.Unmarshal(out, &sum); err != nil {
+		t.Fatalf("Error parsing summary %q: %v", out, err)
+	}
+	return cmd.ProcessState.ExitCode(), sum
+}
+
+// Starts a web server to ping with --protocol=http. Returns the flags that
+// ping it.
+func httpTarget(t *testing.T) []string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(srv.Close)
+	return []string{"--protocol=http", "--http_url=" + srv.URL + "/"}
+}
+
+func TestNoTerminal_Unresolved(t *testing.T) {
+	status, sum := runNoTerminal(t, "-c", "3", "nonexistent.invalid")
+	if status != 2 {
+		t.Errorf("Exit status = %d (want 2)", status)
+	}
+	if sum.Status != 2 || sum.StatusName != "unresolved" {
+		t.Errorf("Summary status = %d %q (want 2 %q)", sum.Status, sum.StatusName, "unresolved")
+	}
+	if diff := cmp.Diff([]string{"nonexistent.invalid"}, sum.Unresolved); diff != "" {
+		t.Errorf("Wrong unresolved hosts (-want, +got):\n%v", diff)
+	}
+}
+
+func TestNoTerminal_Bounded(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{name: "Count", args: []string{"-c", "2"}},
+		{name: "Deadline", args: []string{"-w", "2s"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args := append(httpTarget(t), c.args...)
+			status, sum := runNoTerminal(t, append(args, "127.0.0.1")...)
+			if status != 0 {
+				t.Errorf("Exit status = %d (want 0)", status)
+			}
+			if sum.Status != 0 || len(sum.Targets) != 1 {
+				t.Fatalf("Summary = %+v (want status 0 with 1 target)", sum)
+			}
+			if tgt := sum.Targets[0]; tgt.Sent == 0 || tgt.Lost != 0 {
+				t.Errorf("Target = %+v (want some sent and none lost)", tgt)
+			}
+		})
+	}
+}
+
+// Without a terminal to ask on, --flood needs --flood_confirm.
+func TestNoTerminal_Flood(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("--flood requires root")
+	}
+	t.Run("Unconfirmed", func(t *testing.T) {
+		dir := t.TempDir()
+		cmd := exec.Command(os.Args[0], "--flood", "-c", "2", "127.0.0.1")
+		cmd.Env = append(os.Environ(), childEnv+"=1", "HOME="+dir, "XDG_CONFIG_HOME="+dir, "XDG_STATE_HOME="+dir)
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			t.Errorf("Run error = %v (want exit status 1)", err)
+		}
+		if !strings.Contains(stderr.String(), "--flood_confirm") {
+			t.Errorf("Stderr = %q (want mention of --flood_confirm)", stderr.String())
+		}
+	})
+	t.Run("Confirmed", func(t *testing.T) {
+		args := append(httpTarget(t), "--flood", "--flood_confirm", "-c", "2", "127.0.0.1")
+		status, sum := runNoTerminal(t, args...)
+		if status != 0 {
+			t.Errorf("Exit status = %d (want 0)", status)
+		}
+		if len(sum.Targets) != 1 || sum.Targets[0].Sent == 0 {
+			t.Errorf("Summary = %+v (want 1 target with some sent)", sum)
+		}
+	})
+}
@@ -0,0 +1,297 @@
+// Package alert evaluates ping results against user-defined thresholds.
+//
+// A rule looks like:
+//
+//	[TARGET=]METRIC>THRESHOLD[/SAMPLES]
+//
+// Where METRIC is "loss" with a percentage threshold, or "latency" with a
+// duration threshold that's compared to the average latency of successful
+// pings. The rule is evaluated over the most recent SAMPLES results (10 by
+// default). If TARGET is given, the rule only applies to that host. For
+// example:
+//
+//	loss>5%                  More than 5% loss in the last 10 pings to any host
+//	latency>150ms/30         Average latency over 150ms in the last 30 pings
+//	example.com=loss>20%/50  More than 20% loss to example.com in the last 50
+package alert
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+const defaultSamples = 10
+
+// Metric is a measurement that a rule checks.
+type Metric int
+
+// Metric values.
+const (
+	// Loss is the fraction of pings without a successful reply.
+	Loss Metric = iota
+
+	// Latency is the average latency of successful pings.
+	Latency
+)
+
+func (m Metric) String() string {
+	switch m {
+	case Loss:
+		return "loss"
+	case Latency:
+		return "latency"
+	default:
+		return fmt.Sprintf("(unknown:%d)", m)
+	}
+}
+
+// Rule is an alert threshold.
+type Rule struct {
+	// Target is the host the rule applies to. Empty for all hosts.
+	Target string
+
+	// Metric is the measurement to check.
+	Metric Metric
+
+	// MaxLoss is the highest fraction of loss allowed for the [Loss] metric.
+	MaxLoss float64
+
+	// MaxLatency is the highest average latency allowed for the [Latency]
+	// metric.
+	MaxLatency time.Duration
+
+	// Samples is the number of recent results to evaluate.
+	Samples int
+}
+
+// ParseRule parses a rule in the format described in the package
+// documentation.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	spec := s
+	if t, rest, ok := strings.Cut(s, "="); ok {
+		r.Target, spec = t, rest
+	}
+	metric, rest, ok := strings.Cut(spec, ">")
+	if !ok {
+		return Rule{}, fmt.Errorf("bad alert rule %q: missing '>'", s)
+	}
+	threshold, samples, ok := strings.Cut(rest, "/")
+	r.Samples = defaultSamples
+	if ok {
+		n, err := strconv.Atoi(samples)
+		if err != nil || n <= 0 {
+			return Rule{}, fmt.Errorf("bad alert rule %q: sample count must be a positive integer", s)
+		}
+		r.Samples = n
+	}
+	switch metric {
+	case "loss":
+		r.Metric = Loss
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(threshold, "%"), 64)
+		if err != nil || pct < 0 || pct >= 100 {
+			return Rule{}, fmt.Errorf("bad alert rule %q: loss must be a percentage from 0 to less than 100", s)
+		}
+		r.MaxLoss = pct / 100
+	case "latency":
+		r.Metric = Latency
+		d, err := time.ParseDuration(threshold)
+		if err != nil || d <= 0 {
+			return Rule{}, fmt.Errorf("bad alert rule %q: latency must be a positive duration", s)
+		}
+		r.MaxLatency = d
+	default:
+		return Rule{}, fmt.Errorf("bad alert rule %q: unknown metric %q", s, metric)
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	var sb strings.Builder
+	if r.Target != "" {
+		fmt.Fprintf(&sb, "%s=", r.Target)
+	}
+	fmt.Fprintf(&sb, "%v>%s/%d", r.Metric, r.formatValue(r.threshold()), r.Samples)
+	return sb.String()
+}
+
+// Matches returns true if the rule applies to a host known by any of the
+// given names.
+func (r Rule) Matches(names ...string) bool {
+	if r.Target == "" {
+		return true
+	}
+	for _, n := range names {
+		if n == r.Target {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) threshold() float64 {
+	if r.Metric == Latency {
+		return float64(r.MaxLatency)
+	}
+	return r.MaxLoss
+}
+
+func (r Rule) formatValue(v float64) string {
+	if r.Metric == Latency {
+		return time.Duration(v).Round(time.Millisecond).String()
+	}
+	return strconv.FormatFloat(100*v, 'f', -1, 64) + "%"
+}
+
+// Evaluates the rule over a window of results, most recent last. Returns false
+// if there isn't enough data.
+func (r Rule) eval(window []pinger.PingResult) (float64, bool) {
+	if len(window) < r.Samples {
+		return 0, false
+	}
+	var n, failures int
+	var total time.Duration
+	for _, res := range window[len(window)-r.Samples:] {
+		n++
+		if res.Type != pinger.Success {
+			failures++
+			continue
+		}
+		total += res.Latency
+	}
+	switch r.Metric {
+	case Loss:
+		return float64(failures) / float64(n), true
+	case Latency:
+		if n == failures {
+			return 0, false
+		}
+		return float64(total) / float64(n-failures), true
+	}
+	return 0, false
+}
+
+// Event is sent when an alert starts or stops firing.
+type Event struct {
+	// Target describes the host the alert is for.
+	Target string
+
+	// Label is the user's label for the host, or empty if it has none.
+	Label string
+
+	// Rule is the rule that changed state.
+	Rule Rule
+
+	// Firing is true when the threshold was exceeded, and false when things
+	// returned to normal.
+	Firing bool
+
+	// Value is the measured value that triggered the change.
+	Value string
+
+	// Time is when the change happened.
+	Time time.Time
+}
+
+func (e Event) String() string {
+	state := "resolved"
+	if e.Firing {
+		state = "FIRING"
+	}
+	target := e.Target
+	if e.Label != "" {
+		target = fmt.Sprintf("%s [%s]", target, e.Label)
+	}
+	return fmt.Sprintf("Alert %s for %s: %v (now %s)", state, target, e.Rule, e.Value)
+}
+
+// Evaluator checks a single host's results against a set of rules.
+type Evaluator struct {
+	target string
+	rules  []Rule
+	notify func(Event)
+	maxN   int
+
+	mu     sync.Mutex
+	label  string
+	window []pinger.PingResult
+	firing []bool
+}
+
+// NewEvaluator creates an evaluator for target. Notify is called each time a
+// rule starts or stops firing.
+func NewEvaluator(target string, rules []Rule, notify func(Event)) *Evaluator {
+	e := &Evaluator{
+		target: target,
+		rules:  rules,
+		notify: notify,
+		firing: make([]bool, len(rules)),
+	}
+	for _, r := range rules {
+		e.maxN = max(e.maxN, r.Samples)
+	}
+	return e
+}
+
+// SetLabel sets the label included in the host's events.
+func (e *Evaluator) SetLabel(label string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.label = label
+}
+
+// Add evaluates a new ping result. It's meant to be called from
+// [pinger.Options.OnResult].
+func (e *Evaluator) Add(res pinger.PingResult) {
+	// Duplicates, gaps and late replies say nothing new about the host.
+	switch res.Type {
+	case pinger.Waiting, pinger.Duplicate, pinger.Gap, pinger.Late:
+		return
+	}
+
+	var events []Event
+	e.mu.Lock()
+	e.window = append(e.window, res)
+	if len(e.window) > e.maxN {
+		e.window = e.window[len(e.window)-e.maxN:]
+	}
+	for i, r := range e.rules {
+		v, ok := r.eval(e.window)
+		if !ok {
+			continue
+		}
+		if firing := v > r.threshold(); firing != e.firing[i] {
+			e.firing[i] = firing
+			events = append(events, Event{
+				Target: e.target,
+				Label:  e.label,
+				Rule:   r,
+				Firing: firing,
+				Value:  r.formatValue(v),
+				Time:   time.Now(),
+			})
+		}
+	}
+	e.mu.Unlock()
+
+	for _, ev := range events {
+		e.notify(ev)
+	}
+}
+
+// Firing returns true if any rule is currently firing.
+func (e *Evaluator) Firing() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, f := range e.firing {
+		if f {
+			return true
+		}
+	}
+	return false
+}
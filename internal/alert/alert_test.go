@@ -0,0 +1,141 @@
+package alert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		In      string
+		Want    Rule
+		WantErr bool
+	}{
+		{In: "loss>5%", Want: Rule{Metric: Loss, MaxLoss: 0.05, Samples: 10}},
+		{In: "loss>12.5/20", Want: Rule{Metric: Loss, MaxLoss: 0.125, Samples: 20}},
+		{In: "latency>150ms/30", Want: Rule{Metric: Latency, MaxLatency: 150 * time.Millisecond, Samples: 30}},
+		{In: "example.com=loss>20%/50", Want: Rule{Target: "example.com", Metric: Loss, MaxLoss: 0.2, Samples: 50}},
+		{In: "loss", WantErr: true},
+		{In: "loss>100%", WantErr: true},
+		{In: "loss>-1%", WantErr: true},
+		{In: "loss>5%/0", WantErr: true},
+		{In: "loss>5%/x", WantErr: true},
+		{In: "latency>150", WantErr: true},
+		{In: "jitter>1ms", WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.In, func(t *testing.T) {
+			got, err := ParseRule(c.In)
+			if (err != nil) != c.WantErr {
+				t.Fatalf("ParseRule(%q) unexpected error: %v", c.In, err)
+			}
+			if diff := cmp.Diff(c.Want, got, cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+				t.Errorf("ParseRule(%q) wrong result (-want, +got):\n%v", c.In, diff)
+			}
+		})
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	for _, s := range []string{"loss>5%/10", "latency>150ms/30", "example.com=loss>20%/50"} {
+		r, err := ParseRule(s)
+		if err != nil {
+			t.Fatalf("ParseRule(%q) error: %v", s, err)
+		}
+		if got := r.String(); got != s {
+			t.Errorf("String() = %q (want %q)", got, s)
+		}
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	all := Rule{}
+	one := Rule{Target: "example.com"}
+	if !all.Matches("anything") {
+		t.Errorf("Rule without target should match everything.")
+	}
+	if !one.Matches("192.0.2.1", "example.com") {
+		t.Errorf("Rule should match by any name.")
+	}
+	if one.Matches("example.org") {
+		t.Errorf("Rule shouldn't match other hosts.")
+	}
+}
+
+func TestEvaluator(t *testing.T) {
+	ms := time.Millisecond
+	rules := []Rule{
+		{Metric: Loss, MaxLoss: 0.25, Samples: 4},
+		{Metric: Latency, MaxLatency: 100 * ms, Samples: 2},
+	}
+	var got []Event
+	e := NewEvaluator("example.com", rules, func(ev Event) { got = append(got, ev) })
+
+	ok := func(lat time.Duration) pinger.PingResult {
+		return pinger.PingResult{Type: pinger.Success, Latency: lat}
+	}
+	drop := pinger.PingResult{Type: pinger.Dropped}
+
+	steps := []struct {
+		Res        pinger.PingResult
+		WantEvents []Event
+		WantFiring bool
+	}{
+		{Res: ok(10 * ms)},
+		{Res: drop},
+		// Ignored.
+		{Res: pinger.PingResult{Type: pinger.Duplicate}},
+		{Res: pinger.PingResult{Type: pinger.Gap}},
+		{Res: ok(10 * ms)},
+		{
+			Res:        drop,
+			WantEvents: []Event{{Target: "example.com", Rule: rules[0], Firing: true, Value: "50%"}},
+			WantFiring: true,
+		},
+		{
+			Res:        ok(300 * ms),
+			WantEvents: []Event{{Target: "example.com", Rule: rules[1], Firing: true, Value: "300ms"}},
+			WantFiring: true,
+		},
+		{
+			// Exactly at the threshold isn't over it.
+			Res:        ok(10 * ms),
+			WantEvents: []Event{{Target: "example.com", Rule: rules[0], Firing: false, Value: "25%"}},
+			WantFiring: true,
+		},
+		{
+			Res:        ok(10 * ms),
+			WantEvents: []Event{{Target: "example.com", Rule: rules[1], Firing: false, Value: "10ms"}},
+		},
+		{Res: ok(10 * ms)},
+	}
+	for i, s := range steps {
+		got = nil
+		e.Add(s.Res)
+		if diff := cmp.Diff(s.WantEvents, got, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+			t.Errorf("Step %d: wrong events (-want, +got):\n%v", i, diff)
+		}
+		if f := e.Firing(); f != s.WantFiring {
+			t.Errorf("Step %d: Firing() = %v (want %v)", i, f, s.WantFiring)
+		}
+	}
+}
+
+func TestEvaluator_Label(t *testing.T) {
+	rules := []Rule{{Metric: Loss, MaxLoss: 0.25, Samples: 1}}
+	var got []Event
+	e := NewEvaluator("example.com", rules, func(ev Event) { got = append(got, ev) })
+	e.SetLabel("office router")
+	e.Add(pinger.PingResult{Type: pinger.Dropped})
+	want := []Event{{Target: "example.com", Label: "office router", Rule: rules[0], Firing: true, Value: "100%"}}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Event{}, "Time")); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+	if s, want := got[0].String(), "Alert FIRING for example.com [office router]: loss>25%/1 (now 100%)"; s != want {
+		t.Errorf("String() = %q (want %q)", s, want)
+	}
+}
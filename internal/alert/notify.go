@@ -0,0 +1,163 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Maximum time to let an alert command or webhook run.
+const actionTimeout = 30 * time.Second
+
+// Notifier takes action when alerts change state. Every event is logged.
+// Commands and webhooks run in the background so they don't hold up pinging.
+// Each gets the events one at a time, in the order they happened, so that an
+// alert that resolves quickly isn't left looking like it's still firing.
+type Notifier struct {
+	// Command, if set, is run with "sh -c". The event is described in the
+	// environment variables VASILY_ALERT_TARGET, VASILY_ALERT_LABEL,
+	// VASILY_ALERT_RULE, VASILY_ALERT_STATE (firing or resolved) and
+	// VASILY_ALERT_VALUE.
+	Command string
+
+	// Webhook, if set, is a URL that the event is POSTed to as JSON.
+	Webhook string
+
+	// Client is used for webhooks. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	commands queue
+	webhooks queue
+}
+
+// Notify handles an event. Safe to call on a nil Notifier, which only logs.
+func (n *Notifier) Notify(ev Event) {
+	log.Print(ev)
+	if n == nil {
+		return
+	}
+	if n.Command != "" {
+		n.commands.push(ev, func(ev Event) {
+			if err := n.runCommand(ev); err != nil {
+				log.Printf("Alert command failed: %v", err)
+			}
+		})
+	}
+	if n.Webhook != "" {
+		n.webhooks.push(ev, func(ev Event) {
+			if err := n.postWebhook(ev); err != nil {
+				log.Printf("Alert webhook failed: %v", err)
+			}
+		})
+	}
+}
+
+// Events waiting to be handled in order. A worker runs while there are any.
+type queue struct {
+	mu      sync.Mutex
+	pending []Event
+	running bool
+}
+
+// Adds an event, and starts a worker that passes the events to handle unless
+// one is already running.
+func (q *queue) push(ev Event, handle func(Event)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, ev)
+	if !q.running {
+		q.running = true
+		go q.work(handle)
+	}
+}
+
+// Handles events until there are none left.
+func (q *queue) work(handle func(Event)) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		ev := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		handle(ev)
+	}
+}
+
+func (n *Notifier) runCommand(ev Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", n.Command)
+	cmd.Env = append(os.Environ(),
+		"VASILY_ALERT_TARGET="+ev.Target,
+		"VASILY_ALERT_LABEL="+ev.Label,
+		"VASILY_ALERT_RULE="+ev.Rule.String(),
+		"VASILY_ALERT_STATE="+state(ev),
+		"VASILY_ALERT_VALUE="+ev.Value,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+// The JSON body sent to webhooks.
+type webhookBody struct {
+	Target string    `json:"target"`
+	Label  string    `json:"label,omitempty"`
+	Rule   string    `json:"rule"`
+	State  string    `json:"state"`
+	Value  string    `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+func (n *Notifier) postWebhook(ev Event) error {
+	body, err := json.Marshal(webhookBody{
+		Target: ev.Target,
+		Label:  ev.Label,
+		Rule:   ev.Rule.String(),
+		State:  state(ev),
+		Value:  ev.Value,
+		Time:   ev.Time,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), actionTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", n.Webhook, resp.Status)
+	}
+	return nil
+}
+
+func state(ev Event) string {
+	if ev.Firing {
+		return "firing"
+	}
+	return "resolved"
+}
@@ -0,0 +1,129 @@
+package alert
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testEvent = Event{
+	Target: "example.com",
+	Label:  "office router",
+	Rule:   Rule{Metric: Loss, MaxLoss: 0.05, Samples: 10},
+	Firing: true,
+	Value:  "20%",
+	Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+}
+
+func TestRunCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	n := &Notifier{
+		Command: `echo "$VASILY_ALERT_TARGET ($VASILY_ALERT_LABEL) $VASILY_ALERT_RULE $VASILY_ALERT_STATE $VASILY_ALERT_VALUE" > ` + out,
+	}
+	if err := n.runCommand(testEvent); err != nil {
+		t.Fatalf("runCommand() error: %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Error reading output: %v", err)
+	}
+	if diff := cmp.Diff("example.com (office router) loss>5%/10 firing 20%\n", string(got)); diff != "" {
+		t.Errorf("Wrong command output (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRunCommand_Error(t *testing.T) {
+	n := &Notifier{Command: "exit 3"}
+	if err := n.runCommand(testEvent); err == nil {
+		t.Errorf("runCommand() succeeded (want error)")
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	var got webhookBody
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("Wrong method: %v", r.Method)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("Error decoding body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	n := &Notifier{Webhook: srv.URL, Client: srv.Client()}
+	if err := n.postWebhook(testEvent); err != nil {
+		t.Fatalf("postWebhook() error: %v", err)
+	}
+	want := webhookBody{
+		Target: "example.com",
+		Label:  "office router",
+		Rule:   "loss>5%/10",
+		State:  "firing",
+		Value:  "20%",
+		Time:   testEvent.Time,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong webhook body (-want, +got):\n%v", diff)
+	}
+}
+
+func TestPostWebhook_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	n := &Notifier{Webhook: srv.URL, Client: srv.Client()}
+	if err := n.postWebhook(testEvent); err == nil {
+		t.Errorf("postWebhook() succeeded (want error)")
+	}
+}
+
+func TestNotify_InOrder(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	received := make(chan struct{}, 3)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body webhookBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Error decoding body: %v", err)
+		}
+		mu.Lock()
+		got = append(got, body.State)
+		mu.Unlock()
+		// A slow answer to the first would let the next overtake it if they
+		// were sent at once.
+		if body.State == "firing" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	n := &Notifier{Webhook: srv.URL, Client: srv.Client()}
+	resolved := testEvent
+	resolved.Firing = false
+	n.Notify(testEvent)
+	n.Notify(resolved)
+	n.Notify(testEvent)
+	for range 3 {
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for webhooks.")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"firing", "resolved", "firing"}, got); diff != "" {
+		t.Errorf("Wrong webhook order (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,266 @@
+// Package asn maps IP addresses to the autonomous systems (AS) that announce
+// them.
+//
+// Lookups use Team Cymru's IP to ASN mapping service over DNS. An address's
+// origin is a TXT record under origin.asn.cymru.com (or origin6 for IPv6)
+// with the address's octets (or nibbles) reversed, much like a PTR lookup:
+//
+//	$ dig +short 8.8.8.8.origin.asn.cymru.com TXT
+//	"15169 | 8.8.8.0/24 | US | arin | 2023-12-28"
+//
+// The AS name is a TXT record under asn.cymru.com:
+//
+//	$ dig +short AS15169.asn.cymru.com TXT
+//	"15169 | US | arin | 2000-03-30 | GOOGLE, US"
+//
+// See https://www.team-cymru.com/ip-asn-mapping for details.
+package asn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	originV4Zone = "origin.asn.cymru.com"
+	originV6Zone = "origin6.asn.cymru.com"
+	nameZone     = "asn.cymru.com"
+)
+
+var (
+	// ErrNotFound is returned when an address isn't announced by any AS.
+	// This includes private and other non-global addresses, which are never
+	// looked up.
+	ErrNotFound = errors.New("no AS found")
+
+	defaultResolver = NewResolver()
+)
+
+// Info describes the AS that announces an address.
+type Info struct {
+	// ASN is the AS number.
+	ASN uint32
+
+	// Prefix is the announced prefix containing the address.
+	Prefix netip.Prefix
+
+	// Country is the two letter country code the AS is registered in.
+	Country string
+
+	// Registry is the regional internet registry the prefix was allocated
+	// by.
+	Registry string
+
+	// Name is the name of the AS's organization. Empty if unknown.
+	Name string
+}
+
+func (i Info) String() string {
+	if i.Name == "" {
+		return fmt.Sprintf("AS%d", i.ASN)
+	}
+	return fmt.Sprintf("AS%d %s", i.ASN, i.Name)
+}
+
+// Resolver looks up and caches AS information. Results are cached for the
+// life of the resolver, on the theory that routing changes are rare compared
+// to how long anyone will stare at a traceroute.
+type Resolver struct {
+	// For testing.
+	lookupTXT func(string) ([]string, error)
+
+	mu       sync.Mutex
+	prefixes []Info
+	missing  map[netip.Addr]bool
+	names    map[uint32]string
+}
+
+// NewResolver creates a new resolver.
+func NewResolver() *Resolver {
+	return &Resolver{
+		lookupTXT: net.LookupTXT,
+		missing:   make(map[netip.Addr]bool),
+		names:     make(map[uint32]string),
+	}
+}
+
+// Lookup finds the AS that announces addr. Failure to find the AS's name isn't
+// an error; the name is just left blank.
+func (r *Resolver) Lookup(addr net.Addr) (Info, error) {
+	ip, err := toAddr(addr)
+	if err != nil {
+		return Info{}, err
+	}
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return Info{}, ErrNotFound
+	}
+	if info, ok, err := r.cached(ip); ok || err != nil {
+		return info, err
+	}
+
+	info, err := r.lookupOrigin(ip)
+	if errors.Is(err, ErrNotFound) {
+		r.mu.Lock()
+		r.missing[ip] = true
+		r.mu.Unlock()
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info.Name = r.lookupName(info.ASN)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes = append(r.prefixes, info)
+	return info, nil
+}
+
+// Checks the cache for an address. Returns ok=false if the address needs to be
+// looked up.
+func (r *Resolver) cached(ip netip.Addr) (info Info, ok bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.missing[ip] {
+		return Info{}, true, ErrNotFound
+	}
+	var best Info
+	for _, p := range r.prefixes {
+		if p.Prefix.Contains(ip) && p.Prefix.Bits() > best.Prefix.Bits() {
+			best = p
+		}
+	}
+	return best, best.Prefix.IsValid(), nil
+}
+
+func (r *Resolver) lookupOrigin(ip netip.Addr) (Info, error) {
+	recs, err := r.lookupTXT(originName(ip))
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, err
+	}
+
+	// There can be several records when an address is covered by multiple
+	// announcements. The most specific one is the one that gets routed.
+	var best Info
+	for _, rec := range recs {
+		info, err := parseOrigin(rec)
+		if err != nil {
+			return Info{}, err
+		}
+		if info.Prefix.Contains(ip) && info.Prefix.Bits() > best.Prefix.Bits() {
+			best = info
+		}
+	}
+	if !best.Prefix.IsValid() {
+		return Info{}, ErrNotFound
+	}
+	return best, nil
+}
+
+// Looks up the name of an AS. Returns an empty string if it can't be found.
+func (r *Resolver) lookupName(asn uint32) string {
+	r.mu.Lock()
+	name, ok := r.names[asn]
+	r.mu.Unlock()
+	if ok {
+		return name
+	}
+	recs, err := r.lookupTXT(fmt.Sprintf("AS%d.%s", asn, nameZone))
+	if err != nil || len(recs) == 0 {
+		return ""
+	}
+	fields := splitRecord(recs[0])
+	if len(fields) < 5 {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.names[asn] = fields[4]
+	return fields[4]
+}
+
+// Lookup calls [Resolver.Lookup] on the default resolver.
+func Lookup(addr net.Addr) (Info, error) {
+	return defaultResolver.Lookup(addr)
+}
+
+// Returns the DNS name to query for an address's origin.
+func originName(ip netip.Addr) string {
+	var sb strings.Builder
+	if ip.Is4() {
+		b := ip.As4()
+		for i := len(b) - 1; i >= 0; i-- {
+			fmt.Fprintf(&sb, "%d.", b[i])
+		}
+		sb.WriteString(originV4Zone)
+		return sb.String()
+	}
+	b := ip.As16()
+	for i := len(b) - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%x.%x.", b[i]&0xf, b[i]>>4)
+	}
+	sb.WriteString(originV6Zone)
+	return sb.String()
+}
+
+// Parses an origin TXT record.
+func parseOrigin(rec string) (Info, error) {
+	fields := splitRecord(rec)
+	if len(fields) < 4 {
+		return Info{}, fmt.Errorf("malformed origin record %q", rec)
+	}
+	// Prefixes announced by multiple ASes list them all, separated by spaces.
+	// Just use the first.
+	asnStr, _, _ := strings.Cut(fields[0], " ")
+	asn, err := strconv.ParseUint(asnStr, 10, 32)
+	if err != nil {
+		return Info{}, fmt.Errorf("bad AS number in origin record %q: %v", rec, err)
+	}
+	prefix, err := netip.ParsePrefix(fields[1])
+	if err != nil {
+		return Info{}, fmt.Errorf("bad prefix in origin record %q: %v", rec, err)
+	}
+	return Info{
+		ASN:      uint32(asn),
+		Prefix:   prefix.Masked(),
+		Country:  fields[2],
+		Registry: fields[3],
+	}, nil
+}
+
+// Splits a TXT record into its pipe separated fields.
+func splitRecord(rec string) []string {
+	fields := strings.Split(rec, "|")
+	for i, f := range fields {
+		fields[i] = strings.TrimSpace(f)
+	}
+	return fields
+}
+
+func toAddr(addr net.Addr) (netip.Addr, error) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		ip = addr.IP
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.IPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, fmt.Errorf("unsupported address type %T", addr)
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("invalid IP %v", ip)
+	}
+	return a.Unmap(), nil
+}
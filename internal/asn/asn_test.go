@@ -0,0 +1,158 @@
+package asn
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeDNS struct {
+	recs    map[string][]string
+	queries []string
+}
+
+func (f *fakeDNS) LookupTXT(name string) ([]string, error) {
+	f.queries = append(f.queries, name)
+	if recs, ok := f.recs[name]; ok {
+		return recs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func newTestResolver(dns *fakeDNS) *Resolver {
+	r := NewResolver()
+	r.lookupTXT = dns.LookupTXT
+	return r
+}
+
+func TestOriginName(t *testing.T) {
+	cases := []struct {
+		IP   string
+		Want string
+	}{
+		{IP: "8.8.4.4", Want: "4.4.8.8.origin.asn.cymru.com"},
+		{IP: "192.0.2.1", Want: "1.2.0.192.origin.asn.cymru.com"},
+		{
+			IP:   "2001:db8::567:89ab",
+			Want: "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.origin6.asn.cymru.com",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.IP, func(t *testing.T) {
+			if got := originName(netip.MustParseAddr(c.IP)); got != c.Want {
+				t.Errorf("originName(%v) = %q (want %q)", c.IP, got, c.Want)
+			}
+		})
+	}
+}
+
+func TestParseOrigin(t *testing.T) {
+	cases := []struct {
+		Rec     string
+		Want    Info
+		WantErr bool
+	}{
+		{
+			Rec: "15169 | 8.8.8.0/24 | US | arin | 2023-12-28",
+			Want: Info{
+				ASN:      15169,
+				Prefix:   netip.MustParsePrefix("8.8.8.0/24"),
+				Country:  "US",
+				Registry: "arin",
+			},
+		},
+		{
+			Rec: "64500 64501 | 2001:db8::/32 | ZZ | ripencc |",
+			Want: Info{
+				ASN:      64500,
+				Prefix:   netip.MustParsePrefix("2001:db8::/32"),
+				Country:  "ZZ",
+				Registry: "ripencc",
+			},
+		},
+		{Rec: "15169 | 8.8.8.0/24", WantErr: true},
+		{Rec: "AS15169 | 8.8.8.0/24 | US | arin", WantErr: true},
+		{Rec: "15169 | 8.8.8.0 | US | arin", WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Rec, func(t *testing.T) {
+			got, err := parseOrigin(c.Rec)
+			if (err != nil) != c.WantErr {
+				t.Fatalf("parseOrigin(%q) unexpected error: %v", c.Rec, err)
+			}
+			if diff := cmp.Diff(c.Want, got, cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+				t.Errorf("parseOrigin(%q) wrong result (-want, +got):\n%v", c.Rec, diff)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dns := &fakeDNS{recs: map[string][]string{
+		"1.2.0.192.origin.asn.cymru.com": {
+			"64500 | 192.0.0.0/16 | ZZ | arin | 2000-01-01",
+			"64501 | 192.0.2.0/24 | ZZ | arin | 2000-01-01",
+		},
+		"AS64501.asn.cymru.com": {"64501 | ZZ | arin | 2000-01-01 | EXAMPLE-NET, ZZ"},
+	}}
+	r := newTestResolver(dns)
+
+	want := Info{
+		ASN:      64501,
+		Prefix:   netip.MustParsePrefix("192.0.2.0/24"),
+		Country:  "ZZ",
+		Registry: "arin",
+		Name:     "EXAMPLE-NET, ZZ",
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "::ffff:192.0.2.3"} {
+		got, err := r.Lookup(&net.IPAddr{IP: net.ParseIP(ip)})
+		if err != nil {
+			t.Fatalf("Lookup(%v) error: %v", ip, err)
+		}
+		if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b netip.Prefix) bool { return a == b })); diff != "" {
+			t.Errorf("Lookup(%v) wrong result (-want, +got):\n%v", ip, diff)
+		}
+	}
+	if got := want.String(); got != "AS64501 EXAMPLE-NET, ZZ" {
+		t.Errorf("String() = %q", got)
+	}
+
+	// Later addresses in the same prefix should come from the cache.
+	wantQueries := []string{"1.2.0.192.origin.asn.cymru.com", "AS64501.asn.cymru.com"}
+	if diff := cmp.Diff(wantQueries, dns.queries); diff != "" {
+		t.Errorf("Wrong queries (-want, +got):\n%v", diff)
+	}
+}
+
+func TestLookup_NotFound(t *testing.T) {
+	dns := &fakeDNS{}
+	r := newTestResolver(dns)
+
+	for _, ip := range []string{"10.0.0.1", "127.0.0.1", "fe80::1", "192.0.2.1", "192.0.2.1"} {
+		if _, err := r.Lookup(&net.IPAddr{IP: net.ParseIP(ip)}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Lookup(%v) = %v (want %v)", ip, err, ErrNotFound)
+		}
+	}
+	// Only the public address gets queried, and only once.
+	if diff := cmp.Diff([]string{"1.2.0.192.origin.asn.cymru.com"}, dns.queries); diff != "" {
+		t.Errorf("Wrong queries (-want, +got):\n%v", diff)
+	}
+}
+
+func TestLookup_NoName(t *testing.T) {
+	dns := &fakeDNS{recs: map[string][]string{
+		"1.2.0.192.origin.asn.cymru.com": {"64501 | 192.0.2.0/24 | ZZ | arin | 2000-01-01"},
+	}}
+	r := newTestResolver(dns)
+
+	got, err := r.Lookup(&net.IPAddr{IP: net.ParseIP("192.0.2.1")})
+	if err != nil {
+		t.Fatalf("Lookup() error: %v", err)
+	}
+	if got.String() != "AS64501" {
+		t.Errorf("Lookup() = %v (want AS64501)", got)
+	}
+}
@@ -0,0 +1,228 @@
+// Package arp implements pings for hosts on a local network. IPv4 hosts are
+// sent ARP requests, and IPv6 hosts neighbor solicitations. Almost every host
+// answers these, even when it drops ICMP echo requests, since it couldn't be
+// reached at all otherwise.
+//
+// Only hosts on the same link can be pinged. Each ping goes out the interface
+// with an address on the host's network, or the source interface if one is
+// set.
+//
+// Neither protocol has sequence numbers. An answer from a host is matched to
+// the latest ping sent to it, and earlier ones go unanswered.
+//
+// ARP needs AF_PACKET sockets on Linux and BPF devices on other systems, and
+// neighbor solicitations need raw ICMPv6 sockets. All of these need root, so
+// connections are opened by the privsep server when it's in use.
+package arp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+func init() {
+	backend.Register("arp", func(ipVer util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+		return New(ipVer, opts...)
+	})
+}
+
+// Sends probes and receives answers for one IP version.
+type prober interface {
+	// Asks who has dst. src is the local address on ifi.
+	probe(ifi *net.Interface, src, dst net.IP) error
+
+	// Returns the address of the next host to answer. Returns
+	// os.ErrDeadlineExceeded if ctx is done first, and os.ErrClosed or
+	// net.ErrClosed after close.
+	read(ctx context.Context) (net.IP, error)
+
+	close() error
+}
+
+// Conn is an ARP or NDP ping connection.
+type Conn struct {
+	src   backend.SourceOption
+	links func() ([]link, error) // For test injection
+	p     prober
+
+	readMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]int // Latest unanswered sequence number by address.
+}
+
+// New opens a new connection. The only supported option is
+// [backend.SourceOption]. Since probes aren't rate limited,
+// [backend.FloodOption] is ignored.
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*Conn, error) {
+	src := backend.GetSource(opts)
+	if backend.GetFlowLabel(opts) != 0 {
+		return nil, errors.New("flow labels require the icmp backend")
+	}
+	if src.Mark != 0 || src.Netns != "" {
+		return nil, errors.New("fwmarks and network namespaces require the icmp or udp backend")
+	}
+	var p prober
+	var err error
+	if ipVer == util.IPv4 {
+		p, err = newARPProber()
+	} else {
+		p, err = newNDPProber()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newConn(src, p), nil
+}
+
+func newConn(src backend.SourceOption, p prober) *Conn {
+	c := &Conn{
+		src:     src,
+		p:       p,
+		pending: make(map[string]int),
+	}
+	c.links = func() ([]link, error) { return localLinks(c.src.Interface) }
+	return c
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.p.close()
+}
+
+// WriteTo sends a probe. Only echo requests are supported, and the payload is
+// ignored. [backend.StreamOption] is accepted and ignored; other options
+// aren't supported.
+func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	_, opts, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		return fmt.Errorf("unsupported options: %v", opts)
+	}
+	if pkt.Type != backend.PacketRequest {
+		return fmt.Errorf("unsupported request type: %v", pkt.Type)
+	}
+	links, err := c.links()
+	if err != nil {
+		return err
+	}
+	dst := util.IP(dest)
+	ifi, src, err := pickLink(links, dst, zone(dest), c.src.Addr)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.pending[dst.String()] = pkt.Seq
+	c.mu.Unlock()
+	return c.p.probe(ifi, src, dst)
+}
+
+// ReadFrom receives an answer. Answers from hosts that haven't been pinged are
+// skipped.
+func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for {
+		ip, err := c.p.read(ctx)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return nil, nil, backend.ErrTimeout
+		case errors.Is(err, os.ErrClosed):
+			return nil, nil, net.ErrClosed
+		case err != nil:
+			return nil, nil, err
+		}
+		c.mu.Lock()
+		seq, ok := c.pending[ip.String()]
+		delete(c.pending, ip.String())
+		c.mu.Unlock()
+		if ok {
+			return &backend.Packet{Type: backend.PacketReply, Seq: seq}, &net.UDPAddr{IP: ip}, nil
+		}
+	}
+}
+
+// Returns an address's IPv6 zone, if it has one.
+func zone(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.Zone
+	case *net.IPAddr:
+		return addr.Zone
+	}
+	return ""
+}
+
+// A network interface and its addresses.
+type link struct {
+	ifi   net.Interface
+	addrs []net.Addr
+}
+
+// Lists the interfaces that are up, or only the named one if name isn't
+// empty.
+func localLinks(name string) ([]link, error) {
+	var ifis []net.Interface
+	if name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		ifis = []net.Interface{*ifi}
+	} else {
+		var err error
+		ifis, err = net.Interfaces()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var links []link
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link{ifi: ifi, addrs: addrs})
+	}
+	return links, nil
+}
+
+// Picks the link that dst is on, and the local address to send from. A zone
+// limits the search to the link with that name. It's the only way to tell
+// which link a link-local address is on. src, if set, is used as the local
+// address.
+func pickLink(links []link, dst net.IP, zone string, src net.IP) (*net.Interface, net.IP, error) {
+	isV4 := dst.To4() != nil
+	for _, l := range links {
+		if zone != "" && l.ifi.Name != zone {
+			continue
+		}
+		for _, a := range l.addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || (ipNet.IP.To4() != nil) != isV4 {
+				continue
+			}
+			if !ipNet.Contains(dst) {
+				continue
+			}
+			if src == nil {
+				src = ipNet.IP
+			}
+			return &l.ifi, src, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%v isn't on a local network", dst)
+}
@@ -0,0 +1,244 @@
+//go:build darwin || freebsd
+
+package arp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// The most BPF devices tried when looking for a free one.
+const maxBPFDevices = 256
+
+// Offsets of fields in struct bpf_hdr.
+var (
+	bpfCaplenOffset = unsafe.Offsetof(unix.BpfHdr{}.Caplen)
+	bpfHdrlenOffset = unsafe.Offsetof(unix.BpfHdr{}.Hdrlen)
+	bpfHdrSize      = unsafe.Sizeof(unix.BpfHdr{})
+)
+
+// Accepts ARP replies and drops everything else.
+var arpReplyFilter = []bpf.Instruction{
+	bpf.LoadAbsolute{Off: 12, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: etherTypeARP, SkipTrue: 3},
+	bpf.LoadAbsolute{Off: etherHeaderLen + 6, Size: 2},
+	bpf.JumpIf{Cond: bpf.JumpNotEqual, Val: arpOpReply, SkipTrue: 1},
+	bpf.RetConstant{Val: etherHeaderLen + arpLen},
+	bpf.RetConstant{Val: 0},
+}
+
+// Sends ARP requests and receives replies with BPF devices. A device only
+// sees one interface, so one is opened for each interface probed, and each
+// has a goroutine passing its replies to read.
+type arpProber struct {
+	answers chan net.IP
+	done    chan any
+
+	mu     sync.Mutex
+	devs   map[string]*os.File // By interface name
+	closed bool
+}
+
+func newARPProber() (*arpProber, error) {
+	return &arpProber{
+		answers: make(chan net.IP, 16),
+		done:    make(chan any),
+		devs:    make(map[string]*os.File),
+	}, nil
+}
+
+func (p *arpProber) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var errs []error
+	for _, f := range p.devs {
+		errs = append(errs, f.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Broadcasts an ARP request in an Ethernet frame.
+func (p *arpProber) probe(ifi *net.Interface, src, dst net.IP) error {
+	if len(ifi.HardwareAddr) != etherAddrLen {
+		return fmt.Errorf("%v isn't an Ethernet interface", ifi.Name)
+	}
+	f, err := p.device(ifi.Name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(marshalARPFrame(ifi.HardwareAddr, marshalARPRequest(ifi.HardwareAddr, src, dst)))
+	return err
+}
+
+func (p *arpProber) read(ctx context.Context) (net.IP, error) {
+	select {
+	case ip := <-p.answers:
+		return ip, nil
+	case <-ctx.Done():
+		return nil, os.ErrDeadlineExceeded
+	case <-p.done:
+		return nil, os.ErrClosed
+	}
+}
+
+// Returns the BPF device for an interface, opening it if necessary.
+func (p *arpProber) device(iface string) (*os.File, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, os.ErrClosed
+	}
+	if f, ok := p.devs[iface]; ok {
+		return f, nil
+	}
+	f, bufLen, err := openBPF(iface)
+	if err != nil {
+		return nil, err
+	}
+	p.devs[iface] = f
+	go p.readLoop(f, bufLen)
+	return f, nil
+}
+
+// Passes ARP replies from a BPF device to read until the device is closed.
+func (p *arpProber) readLoop(f *os.File, bufLen int) {
+	// Reads from a BPF device must use a buffer of exactly its size.
+	buf := make([]byte, bufLen)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		for pkt := range bpfPackets(buf[:n]) {
+			ip := parseARPFrame(pkt)
+			if ip == nil {
+				continue
+			}
+			select {
+			case p.answers <- ip:
+			case <-p.done:
+				return
+			}
+		}
+	}
+}
+
+// Splits a buffer read from a BPF device into packets.
+func bpfPackets(buf []byte) iter.Seq[[]byte] {
+	return func(yield func([]byte) bool) {
+		for off := 0; off+int(bpfHdrSize) <= len(buf); {
+			caplen := int(binary.NativeEndian.Uint32(buf[off+int(bpfCaplenOffset):]))
+			hdrlen := int(binary.NativeEndian.Uint16(buf[off+int(bpfHdrlenOffset):]))
+			end := off + hdrlen + caplen
+			if end > len(buf) || !yield(buf[off+hdrlen:end]) {
+				return
+			}
+			off += bpfWordAlign(hdrlen + caplen)
+		}
+	}
+}
+
+// Rounds n up to the alignment of packets in a BPF buffer.
+func bpfWordAlign(n int) int {
+	return (n + bpfAlignment - 1) &^ (bpfAlignment - 1)
+}
+
+// Opens a free BPF device, and attaches it to an interface with a filter for
+// ARP replies. Returns the device and its buffer length.
+func openBPF(iface string) (*os.File, int, error) {
+	var f *os.File
+	var err error
+	for i := range maxBPFDevices {
+		f, err = os.OpenFile(fmt.Sprintf("/dev/bpf%d", i), os.O_RDWR, 0)
+		if !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening BPF device: %v", err)
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	var bufLen int
+	var setupErr error
+	err = rc.Control(func(fd uintptr) {
+		bufLen, setupErr = setupBPF(int(fd), iface)
+	})
+	if err = errors.Join(err, setupErr); err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("error setting up BPF device for %v: %v", iface, err)
+	}
+	return f, bufLen, nil
+}
+
+// Mirrors struct ifreq, as far as BIOCSETIF uses it.
+type bpfIfreq struct {
+	Name [unix.IFNAMSIZ]byte
+	_    [16]byte
+}
+
+// Attaches a BPF device to an interface, and returns its buffer length.
+func setupBPF(fd int, iface string) (int, error) {
+	var ifr bpfIfreq
+	if len(iface) >= len(ifr.Name) {
+		return 0, fmt.Errorf("interface name too long: %q", iface)
+	}
+	copy(ifr.Name[:], iface)
+	if err := ioctl(fd, unix.BIOCSETIF, unsafe.Pointer(&ifr)); err != nil {
+		return 0, fmt.Errorf("BIOCSETIF: %v", err)
+	}
+	// Return packets as soon as they arrive, instead of when the buffer
+	// fills.
+	if err := unix.IoctlSetPointerInt(fd, unix.BIOCIMMEDIATE, 1); err != nil {
+		return 0, fmt.Errorf("BIOCIMMEDIATE: %v", err)
+	}
+	// Frames are sent with their own source address.
+	if err := unix.IoctlSetPointerInt(fd, unix.BIOCSHDRCMPLT, 1); err != nil {
+		return 0, fmt.Errorf("BIOCSHDRCMPLT: %v", err)
+	}
+	raw, err := bpf.Assemble(arpReplyFilter)
+	if err != nil {
+		return 0, err
+	}
+	insns := make([]unix.BpfInsn, len(raw))
+	for i, r := range raw {
+		insns[i] = unix.BpfInsn{Code: r.Op, Jt: r.Jt, Jf: r.Jf, K: r.K}
+	}
+	prog := unix.BpfProgram{Len: uint32(len(insns)), Insns: &insns[0]}
+	if err := ioctl(fd, unix.BIOCSETF, unsafe.Pointer(&prog)); err != nil {
+		return 0, fmt.Errorf("BIOCSETF: %v", err)
+	}
+	bufLen, err := unix.IoctlGetInt(fd, unix.BIOCGBLEN)
+	if err != nil {
+		return 0, fmt.Errorf("BIOCGBLEN: %v", err)
+	}
+	return bufLen, nil
+}
+
+// Makes an ioctl call that x/sys/unix has no wrapper for.
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
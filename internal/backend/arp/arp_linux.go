@@ -0,0 +1,92 @@
+package arp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Sends ARP requests and receives replies over an AF_PACKET socket. The
+// socket isn't bound to an interface, so it gets replies from all of them.
+type arpProber struct {
+	f *os.File
+}
+
+func newARPProber() (*arpProber, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("error opening packet socket: %v", err)
+	}
+	return &arpProber{f: os.NewFile(uintptr(fd), "arp")}, nil
+}
+
+// Converts a 16-bit value to network byte order, as packet sockets expect
+// protocol numbers.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+func (p *arpProber) close() error {
+	return p.f.Close()
+}
+
+// Broadcasts an ARP request. The kernel adds the Ethernet header.
+func (p *arpProber) probe(ifi *net.Interface, src, dst net.IP) error {
+	if len(ifi.HardwareAddr) != etherAddrLen {
+		return fmt.Errorf("%v isn't an Ethernet interface", ifi.Name)
+	}
+	msg := marshalARPRequest(ifi.HardwareAddr, src, dst)
+	sa := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  ifi.Index,
+		Halen:    etherAddrLen,
+	}
+	copy(sa.Addr[:], etherBroadcast)
+
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	err = rc.Write(func(fd uintptr) bool {
+		sendErr = unix.Sendto(int(fd), msg, 0, sa)
+		return !errors.Is(sendErr, unix.EAGAIN)
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func (p *arpProber) read(ctx context.Context) (net.IP, error) {
+	dl, _ := ctx.Deadline()
+	if err := p.f.SetReadDeadline(dl); err != nil {
+		return nil, err
+	}
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		var n int
+		var recvErr error
+		err := rc.Read(func(fd uintptr) bool {
+			n, _, recvErr = unix.Recvfrom(int(fd), buf, 0)
+			return !errors.Is(recvErr, unix.EAGAIN)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if recvErr != nil {
+			return nil, recvErr
+		}
+		if ip := parseARPReply(buf[:n]); ip != nil {
+			return ip, nil
+		}
+	}
+}
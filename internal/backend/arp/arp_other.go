@@ -0,0 +1,24 @@
+//go:build !(linux || darwin || freebsd)
+
+package arp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// ARP isn't supported here. OpenBSD has BPF devices, but its privsep sandbox
+// doesn't allow them.
+type arpProber struct{}
+
+func newARPProber() (*arpProber, error) {
+	return nil, fmt.Errorf("IPv4 arp pings aren't supported on %v", runtime.GOOS)
+}
+
+func (p *arpProber) probe(*net.Interface, net.IP, net.IP) error { return nil }
+
+func (p *arpProber) read(context.Context) (net.IP, error) { return nil, nil }
+
+func (p *arpProber) close() error { return nil }
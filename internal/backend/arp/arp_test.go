@@ -0,0 +1,226 @@
+package arp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+var (
+	hwAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	eth0   = net.Interface{Index: 2, Name: "eth0", HardwareAddr: hwAddr, Flags: net.FlagUp}
+	eth1   = net.Interface{Index: 3, Name: "eth1", HardwareAddr: hwAddr, Flags: net.FlagUp}
+)
+
+func mustCIDR(s string) *net.IPNet {
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	ipNet.IP = ip
+	return ipNet
+}
+
+var testLinks = []link{
+	{ifi: eth0, addrs: []net.Addr{mustCIDR("192.0.2.10/24"), mustCIDR("fe80::1/64")}},
+	{ifi: eth1, addrs: []net.Addr{mustCIDR("198.51.100.10/24"), mustCIDR("2001:db8::10/64"), mustCIDR("fe80::2/64")}},
+}
+
+// A prober that records probes and answers from a channel.
+type fakeProber struct {
+	probes  chan net.IP
+	answers chan net.IP
+	once    sync.Once
+	done    chan any
+}
+
+func newFakeProber() *fakeProber {
+	return &fakeProber{
+		probes:  make(chan net.IP, 10),
+		answers: make(chan net.IP, 10),
+		done:    make(chan any),
+	}
+}
+
+func (p *fakeProber) probe(_ *net.Interface, _, dst net.IP) error {
+	p.probes <- dst
+	return nil
+}
+
+func (p *fakeProber) read(ctx context.Context) (net.IP, error) {
+	select {
+	case ip := <-p.answers:
+		return ip, nil
+	case <-ctx.Done():
+		return nil, os.ErrDeadlineExceeded
+	case <-p.done:
+		return nil, os.ErrClosed
+	}
+}
+
+func (p *fakeProber) close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
+
+func newTestConn() (*Conn, *fakeProber) {
+	p := newFakeProber()
+	c := newConn(backend.SourceOption{}, p)
+	c.links = func() ([]link, error) { return testLinks, nil }
+	return c, p
+}
+
+func TestConn(t *testing.T) {
+	c, p := newTestConn()
+	defer c.Close()
+	dest := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	for seq := range 2 {
+		if err := c.WriteTo(&backend.Packet{Type: backend.PacketRequest, Seq: seq}, dest, backend.StreamOption{Stream: 1}); err != nil {
+			t.Fatalf("WriteTo error: %v", err)
+		}
+	}
+	if got := <-p.probes; !got.Equal(dest.IP) {
+		t.Errorf("Probed %v (want %v)", got, dest.IP)
+	}
+	// An unknown host, then two answers to the same request.
+	p.answers <- net.ParseIP("192.0.2.99")
+	p.answers <- dest.IP
+	p.answers <- dest.IP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	pkt, peer, err := c.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v", err)
+	}
+	if diff := cmp.Diff(&backend.Packet{Type: backend.PacketReply, Seq: 1}, pkt); diff != "" {
+		t.Errorf("Wrong packet (-want, +got):\n%v", diff)
+	}
+	if !util.IP(peer).Equal(dest.IP) {
+		t.Errorf("Wrong peer %v (want %v)", peer, dest.IP)
+	}
+	if _, _, err := c.ReadFrom(ctx); !errors.Is(err, backend.ErrTimeout) {
+		t.Errorf("ReadFrom of duplicate answer: %v (want %v)", err, backend.ErrTimeout)
+	}
+
+	c.Close()
+	if _, _, err := c.ReadFrom(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom after Close: %v (want %v)", err, net.ErrClosed)
+	}
+}
+
+func TestWriteTo_Errors(t *testing.T) {
+	c, _ := newTestConn()
+	defer c.Close()
+	local := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	if err := c.WriteTo(&backend.Packet{Type: backend.PacketTimestampRequest}, local); err == nil {
+		t.Errorf("WriteTo succeeded with a timestamp request")
+	}
+	if err := c.WriteTo(&backend.Packet{Type: backend.PacketRequest}, local, backend.TTLOption{TTL: 1}); err == nil {
+		t.Errorf("WriteTo succeeded with a TTL option")
+	}
+	if err := c.WriteTo(&backend.Packet{Type: backend.PacketRequest}, &net.UDPAddr{IP: net.ParseIP("203.0.113.1")}); err == nil {
+		t.Errorf("WriteTo succeeded with a remote host")
+	}
+}
+
+func TestPickLink(t *testing.T) {
+	cases := []struct {
+		Dst      string
+		Zone     string
+		Src      string
+		WantLink string
+		WantSrc  string
+		WantErr  bool
+	}{
+		{Dst: "192.0.2.1", WantLink: "eth0", WantSrc: "192.0.2.10"},
+		{Dst: "198.51.100.1", WantLink: "eth1", WantSrc: "198.51.100.10"},
+		{Dst: "198.51.100.1", Src: "198.51.100.20", WantLink: "eth1", WantSrc: "198.51.100.20"},
+		{Dst: "2001:db8::1", WantLink: "eth1", WantSrc: "2001:db8::10"},
+		{Dst: "fe80::9", WantLink: "eth0", WantSrc: "fe80::1"},
+		{Dst: "fe80::9", Zone: "eth1", WantLink: "eth1", WantSrc: "fe80::2"},
+		{Dst: "192.0.2.1", Zone: "eth1", WantErr: true},
+		{Dst: "203.0.113.1", WantErr: true},
+	}
+	for _, c := range cases {
+		ifi, src, err := pickLink(testLinks, net.ParseIP(c.Dst), c.Zone, net.ParseIP(c.Src))
+		if (err != nil) != c.WantErr {
+			t.Errorf("pickLink(%v, %q) error = %v (want error %v)", c.Dst, c.Zone, err, c.WantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if ifi.Name != c.WantLink || !src.Equal(net.ParseIP(c.WantSrc)) {
+			t.Errorf("pickLink(%v, %q) = %v, %v (want %v, %v)", c.Dst, c.Zone, ifi.Name, src, c.WantLink, c.WantSrc)
+		}
+	}
+}
+
+func TestARPPackets(t *testing.T) {
+	req := marshalARPRequest(hwAddr, net.ParseIP("192.0.2.10"), net.ParseIP("192.0.2.1"))
+	want := []byte{
+		0, 1, 8, 0, 6, 4, 0, 1,
+		0x02, 0, 0, 0, 0, 1, 192, 0, 2, 10,
+		0, 0, 0, 0, 0, 0, 192, 0, 2, 1,
+	}
+	if diff := cmp.Diff(want, req); diff != "" {
+		t.Errorf("Wrong request (-want, +got):\n%v", diff)
+	}
+	// A request isn't a reply.
+	if ip := parseARPReply(req); ip != nil {
+		t.Errorf("parseARPReply(request) = %v (want nil)", ip)
+	}
+
+	reply := []byte{
+		0, 1, 8, 0, 6, 4, 0, 2,
+		0x02, 0, 0, 0, 0, 2, 192, 0, 2, 1,
+		0x02, 0, 0, 0, 0, 1, 192, 0, 2, 10,
+	}
+	if ip := parseARPReply(reply); !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("parseARPReply = %v (want 192.0.2.1)", ip)
+	}
+	frame := marshalARPFrame(hwAddr, reply)
+	if diff := cmp.Diff([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x02, 0, 0, 0, 0, 1, 8, 6}, frame[:etherHeaderLen]); diff != "" {
+		t.Errorf("Wrong Ethernet header (-want, +got):\n%v", diff)
+	}
+	if ip := parseARPFrame(frame); !ip.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("parseARPFrame = %v (want 192.0.2.1)", ip)
+	}
+	if ip := parseARPReply(reply[:20]); ip != nil {
+		t.Errorf("parseARPReply(short) = %v (want nil)", ip)
+	}
+}
+
+func TestNDPPackets(t *testing.T) {
+	target := net.ParseIP("2001:db8::1")
+	ns := marshalNeighborSolicitation(hwAddr, target)
+	want := append([]byte{135, 0, 0, 0, 0, 0, 0, 0}, target...)
+	want = append(want, 1, 1, 0x02, 0, 0, 0, 0, 1)
+	if diff := cmp.Diff(want, ns); diff != "" {
+		t.Errorf("Wrong solicitation (-want, +got):\n%v", diff)
+	}
+	if len(marshalNeighborSolicitation(nil, target)) != 24 {
+		t.Errorf("Solicitation without a hardware address has options")
+	}
+
+	na := append([]byte{136, 0, 0, 0, 0x60, 0, 0, 0}, target...)
+	if got := parseNeighborAdvertisement(na); !got.Equal(target) {
+		t.Errorf("parseNeighborAdvertisement = %v (want %v)", got, target)
+	}
+	if got := parseNeighborAdvertisement(ns); got != nil {
+		t.Errorf("parseNeighborAdvertisement(solicitation) = %v (want nil)", got)
+	}
+
+	if got, want := solicitedNode(net.ParseIP("2001:db8::567:89ab")), net.ParseIP("ff02::1:ff67:89ab"); !got.Equal(want) {
+		t.Errorf("solicitedNode = %v (want %v)", got, want)
+	}
+}
@@ -0,0 +1,5 @@
+package arp
+
+// Packets in a BPF buffer are aligned to BPF_ALIGNMENT, which is the size of
+// an int32 on Darwin.
+const bpfAlignment = 4
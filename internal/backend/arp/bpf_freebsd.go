@@ -0,0 +1,7 @@
+package arp
+
+import "strconv"
+
+// Packets in a BPF buffer are aligned to BPF_ALIGNMENT, which is the size of
+// a long on FreeBSD.
+const bpfAlignment = strconv.IntSize / 8
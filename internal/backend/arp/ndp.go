@@ -0,0 +1,75 @@
+package arp
+
+import (
+	"context"
+	"net"
+
+	"github.com/pcekm/vasily/internal/logging"
+	"golang.org/x/net/ipv6"
+)
+
+// Neighbor discovery messages must be sent and received with this hop limit,
+// which proves they came from the same link (RFC 4861 section 7.1).
+const ndpHopLimit = 255
+
+// Sends IPv6 neighbor solicitations, and receives neighbor advertisements.
+type ndpProber struct {
+	conn *ipv6.PacketConn
+}
+
+func newNDPProber() (*ndpProber, error) {
+	c, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+	conn := ipv6.NewPacketConn(c)
+	if err := conn.SetMulticastHopLimit(ndpHopLimit); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetControlMessage(ipv6.FlagHopLimit, true); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeNeighborAdvertisement)
+	if err := conn.SetICMPFilter(&filter); err != nil {
+		// Only an optimization, since other messages are skipped anyway.
+		logging.Warnf("Error setting ICMPv6 filter: %v", err)
+	}
+	return &ndpProber{conn: conn}, nil
+}
+
+func (p *ndpProber) close() error {
+	return p.conn.Close()
+}
+
+// Sends a neighbor solicitation to dst's solicited-node multicast address.
+// The kernel picks the source address.
+func (p *ndpProber) probe(ifi *net.Interface, _, dst net.IP) error {
+	msg := marshalNeighborSolicitation(ifi.HardwareAddr, dst)
+	cm := &ipv6.ControlMessage{HopLimit: ndpHopLimit, IfIndex: ifi.Index}
+	_, err := p.conn.WriteTo(msg, cm, &net.IPAddr{IP: solicitedNode(dst), Zone: ifi.Name})
+	return err
+}
+
+func (p *ndpProber) read(ctx context.Context) (net.IP, error) {
+	dl, _ := ctx.Deadline()
+	if err := p.conn.SetReadDeadline(dl); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, cm, _, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if cm != nil && cm.HopLimit != ndpHopLimit {
+			continue
+		}
+		if target := parseNeighborAdvertisement(buf[:n]); target != nil {
+			return target, nil
+		}
+	}
+}
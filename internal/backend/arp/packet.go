@@ -0,0 +1,107 @@
+package arp
+
+import (
+	"encoding/binary"
+	"net"
+
+	"golang.org/x/net/ipv6"
+)
+
+// ARP constants from RFC 826.
+const (
+	arpHardwareEthernet = 1
+	arpOpRequest        = 1
+	arpOpReply          = 2
+	arpLen              = 28
+
+	etherTypeIPv4   = 0x0800
+	etherTypeARP    = 0x0806
+	etherHeaderLen  = 14
+	etherAddrLen    = 6
+	ipv4AddrLen     = 4
+	ndpTargetOffset = 8
+)
+
+var etherBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// Builds an ARP request asking who has tpa, from a host with hardware address
+// sha and IPv4 address spa.
+func marshalARPRequest(sha net.HardwareAddr, spa, tpa net.IP) []byte {
+	b := make([]byte, arpLen)
+	binary.BigEndian.PutUint16(b[0:], arpHardwareEthernet)
+	binary.BigEndian.PutUint16(b[2:], etherTypeIPv4)
+	b[4] = etherAddrLen
+	b[5] = ipv4AddrLen
+	binary.BigEndian.PutUint16(b[6:], arpOpRequest)
+	copy(b[8:14], sha)
+	copy(b[14:18], spa.To4())
+	// The target hardware address is left as zeros.
+	copy(b[24:28], tpa.To4())
+	return b
+}
+
+// Returns the sender's IPv4 address from an ARP reply, or nil if b isn't
+// one.
+func parseARPReply(b []byte) net.IP {
+	if len(b) < arpLen ||
+		binary.BigEndian.Uint16(b[0:]) != arpHardwareEthernet ||
+		binary.BigEndian.Uint16(b[2:]) != etherTypeIPv4 ||
+		b[4] != etherAddrLen || b[5] != ipv4AddrLen ||
+		binary.BigEndian.Uint16(b[6:]) != arpOpReply {
+		return nil
+	}
+	return net.IP(b[14:18]).To16()
+}
+
+// Builds an Ethernet frame holding an ARP request, broadcast from src.
+func marshalARPFrame(src net.HardwareAddr, arp []byte) []byte {
+	b := make([]byte, etherHeaderLen, etherHeaderLen+len(arp))
+	copy(b[0:6], etherBroadcast)
+	copy(b[6:12], src)
+	binary.BigEndian.PutUint16(b[12:], etherTypeARP)
+	return append(b, arp...)
+}
+
+// Returns the sender's IPv4 address from an Ethernet frame holding an ARP
+// reply, or nil if b isn't one.
+func parseARPFrame(b []byte) net.IP {
+	if len(b) < etherHeaderLen || binary.BigEndian.Uint16(b[12:]) != etherTypeARP {
+		return nil
+	}
+	return parseARPReply(b[etherHeaderLen:])
+}
+
+// Builds an ICMPv6 neighbor solicitation for target from a host with hardware
+// address sha. The checksum is left for the kernel to fill in.
+func marshalNeighborSolicitation(sha net.HardwareAddr, target net.IP) []byte {
+	b := make([]byte, ndpTargetOffset+net.IPv6len)
+	b[0] = byte(ipv6.ICMPTypeNeighborSolicitation)
+	copy(b[ndpTargetOffset:], target.To16())
+	if len(sha) == 0 {
+		return b
+	}
+	// The source link-layer address option (RFC 4861 section 4.6.1). Its
+	// length is in units of 8 bytes.
+	optLen := (2 + len(sha) + 7) / 8
+	opt := make([]byte, optLen*8)
+	opt[0] = 1
+	opt[1] = byte(optLen)
+	copy(opt[2:], sha)
+	return append(b, opt...)
+}
+
+// Returns the target address of an ICMPv6 neighbor advertisement, or nil if b
+// isn't one.
+func parseNeighborAdvertisement(b []byte) net.IP {
+	if len(b) < ndpTargetOffset+net.IPv6len || b[0] != byte(ipv6.ICMPTypeNeighborAdvertisement) || b[1] != 0 {
+		return nil
+	}
+	return net.IP(b[ndpTargetOffset : ndpTargetOffset+net.IPv6len])
+}
+
+// Returns the solicited-node multicast address for ip (RFC 4291 section
+// 2.7.1).
+func solicitedNode(ip net.IP) net.IP {
+	ip16 := ip.To16()
+	return net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, ip16[13], ip16[14], ip16[15]}
+}
@@ -7,21 +7,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
+	"maps"
 	"net"
+	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/pcekm/vasily/internal/util"
 	"github.com/spf13/pflag"
 )
 
 var (
-	registry      = make(map[Name]NewConnFunc)
-	privsepClient PrivsepClient
+	registry        = make(map[Name]NewConnFunc)
+	localBackends   = make(map[Name]bool)
+	privsepClient   PrivsepClient
+	rawSocketOpener RawSocketFunc
+	connWrapper     WrapFunc
 
 	// ErrTimeout indicates that an operation reached its timeout or deadline.
 	// TODO: This should probably be replaced with net.Error.Timeout().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrNoSocketStats is returned for connections that can't report
+	// [SocketStats].
+	ErrNoSocketStats = errors.New("socket statistics unavailable")
 )
 
 // PacketType is a type of ICMP packet.
@@ -40,6 +51,24 @@ const (
 
 	// PacketDestinationUnreachable is an ICMP destination unreachable message.
 	PacketDestinationUnreachable
+
+	// PacketTooBig is an ICMP fragmentation needed or packet too big message,
+	// or a local error saying the packet is too big for the outgoing
+	// interface. It's only expected for packets sent with
+	// [DontFragmentOption].
+	PacketTooBig
+
+	// PacketTimestampRequest is an ICMP timestamp request. IPv4 only.
+	PacketTimestampRequest
+
+	// PacketTimestampReply is an ICMP timestamp reply.
+	PacketTimestampReply
+
+	// PacketAddressMaskRequest is an ICMP address mask request. IPv4 only.
+	PacketAddressMaskRequest
+
+	// PacketAddressMaskReply is an ICMP address mask reply.
+	PacketAddressMaskReply
 )
 
 func (t PacketType) String() string {
@@ -52,11 +81,26 @@ func (t PacketType) String() string {
 		return "PacketTimeExceeded"
 	case PacketDestinationUnreachable:
 		return "PacketDestinationUnreachable"
+	case PacketTooBig:
+		return "PacketTooBig"
+	case PacketTimestampRequest:
+		return "PacketTimestampRequest"
+	case PacketTimestampReply:
+		return "PacketTimestampReply"
+	case PacketAddressMaskRequest:
+		return "PacketAddressMaskRequest"
+	case PacketAddressMaskReply:
+		return "PacketAddressMaskReply"
 	default:
 		return fmt.Sprintf("(unknown:%d)", t)
 	}
 }
 
+// IsRequest returns true for the packet types that may be sent.
+func (t PacketType) IsRequest() bool {
+	return t == PacketRequest || t == PacketTimestampRequest || t == PacketAddressMaskRequest
+}
+
 // Packet is a higher-level representation of a ping request or reply.
 type Packet struct {
 	// Type is the type of packet sent or received.
@@ -67,8 +111,188 @@ type Packet struct {
 	Seq int
 
 	// Payload contains additional raw data sent in a ping request, or
-	// received in a reply.
+	// received in a reply. Unused by timestamp and address mask packets.
 	Payload []byte
+
+	// Timestamps holds the timestamps of a timestamp request or reply. The
+	// originate timestamp of a request is filled in when it's sent.
+	Timestamps Timestamps
+
+	// AddressMask is the mask from an address mask reply.
+	AddressMask net.IPMask
+
+	// TTL is the IPv4 TTL or IPv6 hop limit a received packet arrived with.
+	// Zero if unknown.
+	TTL int
+
+	// RecvTime is when a received packet arrived, from the kernel's
+	// timestamp if the system has them, or else when it was read from its
+	// socket. Zero if unknown. It leaves out the time the packet then spent
+	// queued on its way to the caller, such as between privsep processes.
+	RecvTime time.Time
+
+	// Extensions holds the extension objects of an ICMP error, or nil if it
+	// had none.
+	Extensions *Extensions
+}
+
+// Extensions holds the extension objects that routers may append to ICMP
+// errors (RFC 4884). They're mostly seen in time exceeded messages.
+type Extensions struct {
+	// MPLSLabels is the MPLS label stack the original packet had when it
+	// arrived, from the top of the stack down (RFC 4950).
+	MPLSLabels []MPLSLabel
+
+	// Interfaces describes the interfaces involved in the error (RFC 5837).
+	Interfaces []InterfaceInfo
+}
+
+// MPLSLabel is an MPLS label stack entry.
+type MPLSLabel struct {
+	// Label is the 20-bit label value.
+	Label int
+
+	// TC is the traffic class.
+	TC int
+
+	// S is set on the bottom entry of the stack.
+	S bool
+
+	// TTL is the label's time to live.
+	TTL int
+}
+
+func (l MPLSLabel) String() string {
+	s := 0
+	if l.S {
+		s = 1
+	}
+	return fmt.Sprintf("L=%d E=%d S=%d TTL=%d", l.Label, l.TC, s, l.TTL)
+}
+
+// InterfaceRole is the part an interface played in an ICMP error.
+type InterfaceRole int
+
+// Values for InterfaceRole, numbered as in RFC 5837.
+const (
+	// IncomingInterface is the interface the original packet arrived on.
+	IncomingInterface InterfaceRole = iota
+
+	// SubIPComponent is a sub-IP component of the incoming interface, such
+	// as a member of a link aggregation group.
+	SubIPComponent
+
+	// OutgoingInterface is the interface the packet would have been sent
+	// out of.
+	OutgoingInterface
+
+	// NextHop is the IP next hop the packet would have been sent to.
+	NextHop
+)
+
+func (r InterfaceRole) String() string {
+	switch r {
+	case IncomingInterface:
+		return "incoming"
+	case SubIPComponent:
+		return "sub-IP component"
+	case OutgoingInterface:
+		return "outgoing"
+	case NextHop:
+		return "next hop"
+	default:
+		return fmt.Sprintf("(unknown:%d)", r)
+	}
+}
+
+// InterfaceInfo identifies an interface in an ICMP error. Any of the fields
+// after Role may be missing.
+type InterfaceInfo struct {
+	Role InterfaceRole
+
+	// Index is the interface's ifIndex, or zero if unknown.
+	Index int
+
+	// Addr is one of the interface's addresses, or nil if unknown.
+	Addr net.IP
+
+	// Name is the interface's name.
+	Name string
+
+	// MTU is the interface's MTU, or zero if unknown.
+	MTU int
+}
+
+func (i InterfaceInfo) String() string {
+	var parts []string
+	if i.Name != "" {
+		parts = append(parts, i.Name)
+	}
+	if i.Addr != nil {
+		parts = append(parts, i.Addr.String())
+	}
+	if i.Index != 0 {
+		parts = append(parts, fmt.Sprintf("ifindex %d", i.Index))
+	}
+	if i.MTU != 0 {
+		parts = append(parts, fmt.Sprintf("mtu %d", i.MTU))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "unidentified")
+	}
+	return fmt.Sprintf("%v: %v", i.Role, strings.Join(parts, ", "))
+}
+
+// Timestamps holds the timestamps carried by ICMP timestamp messages. Each is
+// in milliseconds since midnight UT, as described in RFC 792. Hosts that can't
+// provide that set the high bit and use some other time base.
+type Timestamps struct {
+	// Originate is the time the request was sent.
+	Originate uint32
+
+	// Receive is the time the remote host received the request.
+	Receive uint32
+
+	// Transmit is the time the remote host sent the reply.
+	Transmit uint32
+}
+
+const (
+	msPerDay       = 24 * 60 * 60 * 1000
+	nonstandardBit = 1 << 31
+)
+
+// UTMillis returns t in milliseconds since midnight UT.
+func UTMillis(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight).Milliseconds())
+}
+
+// ClockOffset estimates how far the remote host's clock is ahead of the local
+// one, given the local time the reply arrived. The estimate assumes the
+// outbound and return trips take equally long. Returns false if the remote
+// host used a nonstandard time base.
+func (ts Timestamps) ClockOffset(received time.Time) (time.Duration, bool) {
+	if ts.Receive&nonstandardBit != 0 || ts.Transmit&nonstandardBit != 0 {
+		return 0, false
+	}
+	out := msDiff(ts.Receive, ts.Originate)
+	back := msDiff(ts.Transmit, UTMillis(received))
+	return time.Duration(out+back) * time.Millisecond / 2, true
+}
+
+// Returns a - b in milliseconds, allowing for either side having passed
+// midnight.
+func msDiff(a, b uint32) int64 {
+	d := (int64(a) - int64(b)) % msPerDay
+	switch {
+	case d > msPerDay/2:
+		d -= msPerDay
+	case d <= -msPerDay/2:
+		d += msPerDay
+	}
+	return d
 }
 
 // WriteOption is an option that may be passed to WriteTo.
@@ -78,6 +302,208 @@ type TTLOption struct {
 	TTL int
 }
 
+// DontFragmentOption sends a packet with the don't fragment bit set (IPv4) or
+// with fragmentation disabled (IPv6), ignoring any cached path MTU.
+type DontFragmentOption struct{}
+
+// StreamOption identifies which of several users sharing a connection a packet
+// is sent for. Backends that rate limit their connections limit each stream
+// separately, as though it had a connection of its own. Stream must be less
+// than [MaxStreams]. Packets sent without it belong to stream 0.
+type StreamOption struct {
+	Stream int
+}
+
+// MaxStreams is the number of streams that may share a connection.
+const MaxStreams = 64
+
+// SplitStream returns the stream from a list of write options, along with the
+// remaining options. Returns an error if the stream is out of range.
+func SplitStream(opts []WriteOption) (int, []WriteOption, error) {
+	stream := 0
+	var rest []WriteOption
+	for _, o := range opts {
+		if o, ok := o.(StreamOption); ok {
+			stream = o.Stream
+			continue
+		}
+		rest = append(rest, o)
+	}
+	if stream < 0 || stream >= MaxStreams {
+		return 0, nil, fmt.Errorf("stream out of range: %d", stream)
+	}
+	return stream, rest, nil
+}
+
+// ConnOption is an option that may be passed to New.
+type ConnOption any
+
+// SourceOption binds a connection to a local network interface, a source
+// address, or both, and may mark its packets for policy routing or put it in
+// another network namespace. Binding to a VRF device puts the connection in
+// that VRF. The zero value doesn't bind to anything.
+type SourceOption struct {
+	// Interface is the name of the interface to send from. Empty for any.
+	Interface string
+
+	// Addr is the source address. Nil for any. Must match the connection's
+	// IP version.
+	Addr net.IP
+
+	// Mark is the firewall mark (SO_MARK) set on the connection's sockets,
+	// which Linux policy routing can use to pick a routing table. Zero for
+	// none. Only the icmp and udp backends support it, and only on Linux.
+	Mark uint32
+
+	// Netns is the name of a network namespace, as created by "ip netns
+	// add", to open the connection's sockets in. The interface and address
+	// are looked up there. Empty for the current one. Only the icmp and udp
+	// backends support it, and only on Linux.
+	Netns string
+}
+
+// IsZero returns true if the option doesn't bind to anything.
+func (s SourceOption) IsZero() bool {
+	return s.Interface == "" && s.Addr == nil && s.Mark == 0 && s.Netns == ""
+}
+
+// FloodOption removes a connection's built-in rate limit so that it can send
+// pings as fast as the caller likes. Backends that limit their send rate
+// refuse it unless the real user is root. Others ignore it.
+type FloodOption struct{}
+
+// GetSource returns the [SourceOption] from a list of options, or the zero
+// value if there isn't one. Panics on unsupported options.
+func GetSource(opts []ConnOption) SourceOption {
+	var src SourceOption
+	for _, o := range opts {
+		switch o := o.(type) {
+		case SourceOption:
+			src = o
+		case FloodOption:
+			// See IsFlood.
+		case FlowLabelOption:
+			// See GetFlowLabel.
+		case DNSQueryOption:
+			// See GetDNSQuery.
+		case HTTPOption:
+			// See GetHTTP.
+		case RecvBufferOption:
+			// See GetRecvBuffer.
+		default:
+			log.Panicf("Unsupported option: %#v", o)
+		}
+	}
+	return src
+}
+
+// FlowLabelOption sets the IPv6 flow label of every packet a connection
+// sends. Only the low 20 bits are used, and zero means no label. Ignored by
+// IPv4 connections. Backends that can't set flow labels refuse it.
+type FlowLabelOption struct {
+	Label uint32
+}
+
+// MaxFlowLabel is the largest IPv6 flow label.
+const MaxFlowLabel = 1<<20 - 1
+
+// GetFlowLabel returns the flow label from a list of options, or zero if
+// there isn't one.
+func GetFlowLabel(opts []ConnOption) uint32 {
+	for _, o := range opts {
+		if o, ok := o.(FlowLabelOption); ok {
+			return o.Label & MaxFlowLabel
+		}
+	}
+	return 0
+}
+
+// DNSQueryOption sets the query sent by the dns backend. Ignored by other
+// backends. The zero value asks for the PTR record of each destination's own
+// address.
+type DNSQueryOption struct {
+	// Name is the name to look up. Empty for the destination's reverse DNS
+	// name.
+	Name string
+
+	// Type is the numeric record type to ask for, like 1 for A records. Zero
+	// for PTR.
+	Type uint16
+}
+
+// GetDNSQuery returns the [DNSQueryOption] from a list of options, or the zero
+// value if there isn't one.
+func GetDNSQuery(opts []ConnOption) DNSQueryOption {
+	for _, o := range opts {
+		if o, ok := o.(DNSQueryOption); ok {
+			return o
+		}
+	}
+	return DNSQueryOption{}
+}
+
+// HTTPOption sets the requests sent by the http backend. Ignored by other
+// backends. The zero value fetches http://<destination>/.
+type HTTPOption struct {
+	// URL is fetched from every destination. Connections go to the
+	// destination's address, and the URL's host is only used for the Host
+	// header and TLS server name. Empty for the destination's root page over
+	// plain HTTP.
+	URL string
+
+	// Insecure skips verifying TLS certificates.
+	Insecure bool
+}
+
+// GetHTTP returns the [HTTPOption] from a list of options, or the zero value
+// if there isn't one.
+func GetHTTP(opts []ConnOption) HTTPOption {
+	for _, o := range opts {
+		if o, ok := o.(HTTPOption); ok {
+			return o
+		}
+	}
+	return HTTPOption{}
+}
+
+// RecvBufferOption sets the size of a connection's socket receive buffer in
+// bytes. A bigger buffer lets the kernel hold more replies while they wait to
+// be read, so fewer are dropped under heavy load. Connections that share a
+// socket get the largest size any of them asked for. Ignored by backends
+// without such a socket.
+type RecvBufferOption struct {
+	// Bytes is the size, up to MaxRecvBuffer.
+	Bytes int
+
+	// Force goes past the system's limit on Linux, which needs
+	// CAP_NET_ADMIN. Without it, the kernel quietly caps the size.
+	Force bool
+}
+
+// MaxRecvBuffer is the largest receive buffer that may be asked for.
+const MaxRecvBuffer = 64 << 20
+
+// GetRecvBuffer returns the receive buffer option from a list of options, or
+// a zero one if there isn't one.
+func GetRecvBuffer(opts []ConnOption) RecvBufferOption {
+	for _, o := range opts {
+		if o, ok := o.(RecvBufferOption); ok {
+			return o
+		}
+	}
+	return RecvBufferOption{}
+}
+
+// IsFlood returns true if a list of options contains [FloodOption].
+func IsFlood(opts []ConnOption) bool {
+	for _, o := range opts {
+		if _, ok := o.(FloodOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // Conn is the interface implemented by ping backend connections.
 type Conn interface {
 	// WriteTo writes a ping message to a remote host.
@@ -97,41 +523,153 @@ type Conn interface {
 type PortConn interface {
 	Conn
 
-	// SeqBasePort returns the base port number to which sequence numbers are
-	// added. Implementations should have a reasonable default for this number.
-	SeqBasePort() int
+	// Ports returns the mapping from sequence numbers to ports.
+	// Implementations should have a reasonable default for it.
+	Ports() PortRange
+
+	// SetPorts sets the mapping from sequence numbers to ports.
+	SetPorts(r PortRange)
+}
+
+// MaxPort is the largest port number.
+const MaxPort = 1<<16 - 1
+
+// PortRange maps sequence numbers onto port numbers.
+type PortRange struct {
+	// Base is the port for sequence 0.
+	Base int
+
+	// Stride is the difference between the ports of consecutive sequence
+	// numbers. Zero sends everything to Base, for getting through firewalls
+	// that only let one port through. Replies can't be told apart by port
+	// then, and all have sequence 0.
+	Stride int
+}
+
+// Port returns the port for a sequence number.
+func (r PortRange) Port(seq int) int {
+	return r.Base + seq*r.Stride
+}
+
+// Seq returns the sequence number for a port.
+func (r PortRange) Seq(port int) int {
+	if r.Stride == 0 {
+		return 0
+	}
+	return (port - r.Base) / r.Stride
+}
+
+// SocketStats describes the socket a connection reads replies from. Replies
+// dropped here never reach the connection, and look the same as replies lost
+// in the network.
+type SocketStats struct {
+	// RecvBuffer is the size of the receive buffer in bytes, as the kernel
+	// counts it. Linux counts its bookkeeping too, so this is about twice the
+	// size that was asked for.
+	RecvBuffer int
+
+	// Drops is the number of packets the kernel dropped because the receive
+	// buffer was full, or -1 if the system doesn't count them.
+	Drops int64
+}
+
+// StatsConn is an extended interface for connections that can describe
+// their sockets. Connections that share a socket report the same numbers.
+type StatsConn interface {
+	Conn
+
+	// SocketStats returns the socket's current statistics.
+	SocketStats() (SocketStats, error)
+}
 
-	// SetSeqBasePort sets the port number for sequence 0. Port numbers used by
-	// the backend will be pkt.Seq + this base port number.
-	SetSeqBasePort(p int)
+// GetSocketStats returns the statistics of conn's socket. Returns
+// [ErrNoSocketStats] if conn isn't a [StatsConn].
+func GetSocketStats(conn Conn) (SocketStats, error) {
+	if sc, ok := conn.(StatsConn); ok {
+		return sc.SocketStats()
+	}
+	return SocketStats{}, ErrNoSocketStats
+}
+
+// BatchConn is an extended interface for connections that can send several
+// packets at once more cheaply than one at a time.
+type BatchConn interface {
+	Conn
+
+	// WriteBatch writes ping messages to a remote host. Returns the number of
+	// packets sent, which is less than len(pkts) only on error.
+	WriteBatch(pkts []*Packet, dest net.Addr) (int, error)
+}
+
+// WriteBatch sends several ping messages to a remote host. It uses
+// [BatchConn.WriteBatch] if conn supports it, and falls back to one WriteTo per
+// packet otherwise. Returns the number of packets sent.
+func WriteBatch(conn Conn, pkts []*Packet, dest net.Addr) (int, error) {
+	if bc, ok := conn.(BatchConn); ok {
+		return bc.WriteBatch(pkts, dest)
+	}
+	for i, pkt := range pkts {
+		if err := conn.WriteTo(pkt, dest); err != nil {
+			return i, err
+		}
+	}
+	return len(pkts), nil
 }
 
 // Name is the name of a backend.
 type Name string
 
 // New creates a new connection.
-func New(name Name, ipVer util.IPVersion) (Conn, error) {
-	if privsepClient != nil {
-		return privsepClient.NewConn(name, ipVer)
+func New(name Name, ipVer util.IPVersion, opts ...ConnOption) (Conn, error) {
+	var conn Conn
+	var err error
+	if privsepClient != nil && !localBackends[name] {
+		conn, err = privsepClient.NewConn(name, ipVer, opts...)
+	} else {
+		conn, err = NewLocal(name, ipVer, opts...)
 	}
+	if err != nil || connWrapper == nil {
+		return conn, err
+	}
+	return connWrapper(conn, ipVer, opts...), nil
+}
+
+// NewLocal creates a new connection in this process, even if [UsePrivsep] was
+// called.
+func NewLocal(name Name, ipVer util.IPVersion, opts ...ConnOption) (Conn, error) {
 	nc, ok := registry[name]
 	if !ok {
 		return nil, fmt.Errorf("invalid backend %q", name)
 	}
-	return nc(ipVer)
+	return nc(ipVer, opts...)
+}
+
+// Names returns the names of the registered backends in sorted order.
+func Names() []Name {
+	names := slices.Collect(maps.Keys(registry))
+	slices.Sort(names)
+	return names
 }
 
 // NewConnFunc is a function that creates a connection.
-type NewConnFunc func(util.IPVersion) (Conn, error)
+type NewConnFunc func(util.IPVersion, ...ConnOption) (Conn, error)
 
 // Register configures a new backend.
 func Register(n Name, nc NewConnFunc) {
 	registry[n] = nc
 }
 
+// RegisterLocal configures a new backend that never needs privileges. Its
+// connections are always created in this process, even if [UsePrivsep] was
+// called.
+func RegisterLocal(n Name, nc NewConnFunc) {
+	Register(n, nc)
+	localBackends[n] = true
+}
+
 // PrivsepClient is the required interface for the privsep client.
 type PrivsepClient interface {
-	NewConn(Name, util.IPVersion) (Conn, error)
+	NewConn(Name, util.IPVersion, ...ConnOption) (Conn, error)
 }
 
 // UsePrivsep configures [New] to return connections that work via the privsep
@@ -140,6 +678,32 @@ func UsePrivsep(client PrivsepClient) {
 	privsepClient = client
 }
 
+// WrapFunc wraps a new connection, for example to watch the packets it sends
+// and receives. It's passed the options the connection was created with.
+type WrapFunc func(Conn, util.IPVersion, ...ConnOption) Conn
+
+// UseWrapper configures [New] to wrap every connection it creates with wrap.
+// Connections created with [NewLocal] aren't wrapped.
+func UseWrapper(wrap WrapFunc) {
+	connWrapper = wrap
+}
+
+// RawSocketFunc opens a raw ICMP socket.
+type RawSocketFunc func(util.IPVersion) (*os.File, error)
+
+// UseRawSockets configures backends that need raw sockets to get them from
+// open, instead of creating them. This lets an unprivileged process use sockets
+// opened by the privsep server.
+func UseRawSockets(open RawSocketFunc) {
+	rawSocketOpener = open
+}
+
+// RawSocketOpener returns the function set with [UseRawSockets], or nil if
+// there isn't one.
+func RawSocketOpener() RawSocketFunc {
+	return rawSocketOpener
+}
+
 type flagValue string
 
 func (f flagValue) String() string {
@@ -165,6 +729,11 @@ func (f *flagValue) Type() string {
 
 // FlagP returns a flag for selecting a backend.
 func FlagP(name, shorthand, value, usage string) *Name {
-	pflag.VarP((*flagValue)(&value), name, shorthand, usage)
+	return FlagSetP(pflag.CommandLine, name, shorthand, value, usage)
+}
+
+// FlagSetP is like [FlagP], but adds the flag to fs.
+func FlagSetP(fs *pflag.FlagSet, name, shorthand, value, usage string) *Name {
+	fs.VarP((*flagValue)(&value), name, shorthand, usage)
 	return (*Name)(&value)
 }
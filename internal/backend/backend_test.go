@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pcekm/vasily/internal/util"
+)
+
+func TestUTMillis(t *testing.T) {
+	loc := time.FixedZone("UTC-8", -8*60*60)
+	tm := time.Date(2024, 1, 2, 16, 0, 1, 2_500_000, loc)
+	if got, want := UTMillis(tm), uint32(1002); got != want {
+		t.Errorf("UTMillis(%v) = %d (want %d)", tm, got, want)
+	}
+}
+
+func TestClockOffset(t *testing.T) {
+	midnight := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Name     string
+		TS       Timestamps
+		Received time.Time
+		Want     time.Duration
+		WantOK   bool
+	}{
+		{
+			Name:     "InSync",
+			TS:       Timestamps{Originate: 1000, Receive: 1010, Transmit: 1010},
+			Received: midnight.Add(1020 * time.Millisecond),
+			Want:     0,
+			WantOK:   true,
+		},
+		{
+			Name:     "RemoteAhead",
+			TS:       Timestamps{Originate: 1000, Receive: 6010, Transmit: 6010},
+			Received: midnight.Add(1020 * time.Millisecond),
+			Want:     5 * time.Second,
+			WantOK:   true,
+		},
+		{
+			Name:     "RemoteBehind",
+			TS:       Timestamps{Originate: 5000, Receive: 10, Transmit: 10},
+			Received: midnight.Add(5020 * time.Millisecond),
+			Want:     -5 * time.Second,
+			WantOK:   true,
+		},
+		{
+			Name:     "AcrossMidnight",
+			TS:       Timestamps{Originate: msPerDay - 10, Receive: 0, Transmit: 0},
+			Received: midnight.Add(10 * time.Millisecond),
+			Want:     0,
+			WantOK:   true,
+		},
+		{
+			Name:     "Nonstandard",
+			TS:       Timestamps{Originate: 1000, Receive: nonstandardBit | 1010, Transmit: nonstandardBit | 1010},
+			Received: midnight.Add(1020 * time.Millisecond),
+			WantOK:   false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, ok := c.TS.ClockOffset(c.Received)
+			if got != c.Want || ok != c.WantOK {
+				t.Errorf("ClockOffset(%v) = %v, %v (want %v, %v)", c.Received, got, ok, c.Want, c.WantOK)
+			}
+		})
+	}
+}
+
+func TestSplitStream(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Opts     []WriteOption
+		Want     int
+		WantRest []WriteOption
+		WantErr  bool
+	}{
+		{Name: "None", Opts: []WriteOption{TTLOption{TTL: 3}}, Want: 0, WantRest: []WriteOption{TTLOption{TTL: 3}}},
+		{Name: "Stream", Opts: []WriteOption{StreamOption{Stream: 7}, DontFragmentOption{}}, Want: 7, WantRest: []WriteOption{DontFragmentOption{}}},
+		{Name: "TooHigh", Opts: []WriteOption{StreamOption{Stream: MaxStreams}}, WantErr: true},
+		{Name: "Negative", Opts: []WriteOption{StreamOption{Stream: -1}}, WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, rest, err := SplitStream(c.Opts)
+			if (err != nil) != c.WantErr {
+				t.Fatalf("SplitStream(%v) error = %v (want error %v)", c.Opts, err, c.WantErr)
+			}
+			if got != c.Want || !slices.Equal(rest, c.WantRest) {
+				t.Errorf("SplitStream(%v) = %v, %v (want %v, %v)", c.Opts, got, rest, c.Want, c.WantRest)
+			}
+		})
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Range PortRange
+		Seq   int
+		Port  int
+	}{
+		{Name: "Default", Range: PortRange{Base: 33434, Stride: 1}, Seq: 5, Port: 33439},
+		{Name: "Stride", Range: PortRange{Base: 33434, Stride: 3}, Seq: 5, Port: 33449},
+		{Name: "Fixed", Range: PortRange{Base: 53}, Seq: 5, Port: 53},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if got := c.Range.Port(c.Seq); got != c.Port {
+				t.Errorf("%+v.Port(%d) = %d (want %d)", c.Range, c.Seq, got, c.Port)
+			}
+			wantSeq := c.Seq
+			if c.Range.Stride == 0 {
+				wantSeq = 0
+			}
+			if got := c.Range.Seq(c.Port); got != wantSeq {
+				t.Errorf("%+v.Seq(%d) = %d (want %d)", c.Range, c.Port, got, wantSeq)
+			}
+		})
+	}
+}
+
+// A privsep client that refuses every connection.
+type refusingClient struct{}
+
+func (refusingClient) NewConn(Name, util.IPVersion, ...ConnOption) (Conn, error) {
+	return nil, errors.New("refused")
+}
+
+func TestNew_Local(t *testing.T) {
+	opened := func(name Name) NewConnFunc {
+		return func(util.IPVersion, ...ConnOption) (Conn, error) {
+			return nil, fmt.Errorf("opened %v", name)
+		}
+	}
+	Register("test-privileged", opened("test-privileged"))
+	RegisterLocal("test-local", opened("test-local"))
+	UsePrivsep(refusingClient{})
+	defer UsePrivsep(nil)
+
+	if _, err := New("test-privileged", util.IPv4); err == nil || err.Error() != "refused" {
+		t.Errorf("New(test-privileged) error = %v (want refused by privsep)", err)
+	}
+	if _, err := New("test-local", util.IPv4); err == nil || err.Error() != "opened test-local" {
+		t.Errorf("New(test-local) error = %v (want opened locally)", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	nc := func(util.IPVersion, ...ConnOption) (Conn, error) { return nil, nil }
+	Register("names-b", nc)
+	Register("names-a", nc)
+	got := Names()
+	if !slices.IsSorted(got) {
+		t.Errorf("Names() = %v (want sorted)", got)
+	}
+	for _, n := range []Name{"names-a", "names-b"} {
+		if !slices.Contains(got, n) {
+			t.Errorf("Names() = %v (want %q in it)", got, n)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package dns implements pings that time DNS queries. It's for keeping an eye
+// on resolvers: each ping is a query sent to the target's port 53, and the
+// reply is the resolver's response, whatever its answer.
+//
+// Sequence numbers are sent as query IDs. Queries ask for the PTR record of
+// the target's own address unless a [backend.DNSQueryOption] says otherwise.
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/icmpbase"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// The port queries are sent to unless the destination has one.
+	defaultPort = 53
+
+	// The largest response read. Larger ones are truncated, but only the
+	// header is needed.
+	maxResponseLen = 4096
+)
+
+func init() {
+	backend.RegisterLocal("dns", func(ipVer util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+		return New(ipVer, opts...)
+	})
+}
+
+// Conn is a DNS ping connection.
+type Conn struct {
+	query backend.DNSQueryOption
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+	conn    *net.UDPConn
+}
+
+// New opens a new connection. The supported options are
+// [backend.SourceOption] and [backend.DNSQueryOption]. Since queries aren't
+// rate limited, [backend.FloodOption] is ignored.
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*Conn, error) {
+	src := backend.GetSource(opts)
+	if backend.GetFlowLabel(opts) != 0 {
+		return nil, errors.New("flow labels require the icmp backend")
+	}
+	if src.Mark != 0 || src.Netns != "" {
+		return nil, errors.New("fwmarks and network namespaces require the icmp or udp backend")
+	}
+	query := backend.GetDNSQuery(opts)
+	if query.Name != "" {
+		if _, err := dnsmessage.NewName(fqdn(query.Name)); err != nil {
+			return nil, fmt.Errorf("bad query name %q: %v", query.Name, err)
+		}
+	}
+	network := util.Choose(ipVer, "udp4", "udp6")
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: src.Addr})
+	if err != nil {
+		return nil, err
+	}
+	if src.Interface != "" {
+		if err := bindInterface(conn, ipVer, src.Interface); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error binding to interface %q: %v", src.Interface, err)
+		}
+	}
+	return &Conn{
+		query: query,
+		conn:  conn,
+	}, nil
+}
+
+func bindInterface(conn *net.UDPConn, ipVer util.IPVersion, iface string) error {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rcErr := rawconn.Control(func(fd uintptr) {
+		err = icmpbase.BindInterface(int(fd), ipVer, iface)
+	})
+	if rcErr != nil {
+		return rcErr
+	}
+	return err
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// WriteTo sends a query. Only echo requests are supported, and the payload is
+// ignored. [backend.StreamOption] is accepted and ignored; other options
+// aren't supported.
+func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	_, opts, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		return fmt.Errorf("unsupported options: %v", opts)
+	}
+	if pkt.Type != backend.PacketRequest {
+		return fmt.Errorf("unsupported request type: %v", pkt.Type)
+	}
+	msg, err := c.queryMessage(uint16(pkt.Seq), dest)
+	if err != nil {
+		return err
+	}
+	addr := &net.UDPAddr{IP: util.IP(dest), Port: util.Port(dest)}
+	if addr.Port == 0 {
+		addr.Port = defaultPort
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.conn.WriteToUDP(msg, addr)
+	return err
+}
+
+// Builds a query to send to dest.
+func (c *Conn) queryMessage(id uint16, dest net.Addr) ([]byte, error) {
+	name := c.query.Name
+	if name == "" {
+		name = reverseName(util.IP(dest))
+	}
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	qtype := dnsmessage.Type(c.query.Type)
+	if qtype == 0 {
+		qtype = dnsmessage.TypePTR
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: qname, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	return msg.Pack()
+}
+
+// ReadFrom receives a response. Anything that isn't a DNS response is
+// skipped.
+func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	dl, _ := ctx.Deadline()
+	if err := c.conn.SetReadDeadline(dl); err != nil {
+		return nil, nil, err
+	}
+	buf := make([]byte, maxResponseLen)
+	for {
+		n, from, err := c.conn.ReadFromUDP(buf)
+		if err != nil {
+			var opErr *net.OpError
+			if errors.As(err, &opErr) && opErr.Timeout() {
+				return nil, nil, backend.ErrTimeout
+			}
+			return nil, nil, err
+		}
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf[:n])
+		if err != nil || !hdr.Response {
+			continue
+		}
+		// Any response counts as a reply, even an error like NXDOMAIN. It
+		// still shows the resolver is up.
+		pkt := &backend.Packet{Type: backend.PacketReply, Seq: int(hdr.ID)}
+		return pkt, &net.UDPAddr{IP: from.IP, Zone: from.Zone}, nil
+	}
+}
+
+// Returns the name for looking up an address's PTR record.
+func reverseName(ip net.IP) string {
+	var sb strings.Builder
+	if ip4 := ip.To4(); ip4 != nil {
+		for i := len(ip4) - 1; i >= 0; i-- {
+			sb.WriteString(strconv.Itoa(int(ip4[i])))
+			sb.WriteByte('.')
+		}
+		sb.WriteString("in-addr.arpa.")
+		return sb.String()
+	}
+	const hex = "0123456789abcdef"
+	ip16 := ip.To16()
+	for i := len(ip16) - 1; i >= 0; i-- {
+		sb.WriteByte(hex[ip16[i]&0xf])
+		sb.WriteByte('.')
+		sb.WriteByte(hex[ip16[i]>>4])
+		sb.WriteByte('.')
+	}
+	sb.WriteString("ip6.arpa.")
+	return sb.String()
+}
+
+// Adds the trailing dot that makes a name fully qualified.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// ParseType parses a record type name like AAAA, or a number.
+func ParseType(s string) (uint16, error) {
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	if strings.EqualFold(s, "ANY") {
+		return uint16(dnsmessage.TypeALL), nil
+	}
+	for _, t := range recordTypes {
+		if strings.EqualFold(s, strings.TrimPrefix(t.String(), "Type")) {
+			return uint16(t), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// Record types that may be named in ParseType.
+var recordTypes = []dnsmessage.Type{
+	dnsmessage.TypeA,
+	dnsmessage.TypeNS,
+	dnsmessage.TypeCNAME,
+	dnsmessage.TypeSOA,
+	dnsmessage.TypePTR,
+	dnsmessage.TypeMX,
+	dnsmessage.TypeTXT,
+	dnsmessage.TypeAAAA,
+	dnsmessage.TypeSRV,
+	dnsmessage.TypeOPT,
+	dnsmessage.TypeALL,
+}
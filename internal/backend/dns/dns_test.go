@@ -0,0 +1,172 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Starts a resolver on loopback that answers every query with NXDOMAIN, after
+// sending some garbage first. Returns its address and a channel of the
+// questions it's asked.
+func startResolver(t *testing.T) (*net.UDPAddr, <-chan dnsmessage.Question) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: test.LoopbackV4.IP})
+	if err != nil {
+		t.Fatalf("Error starting resolver: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	questions := make(chan dnsmessage.Question, 10)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				t.Errorf("Bad query: %v", err)
+				continue
+			}
+			questions <- msg.Questions[0]
+			msg.Header.Response = true
+			msg.Header.RCode = dnsmessage.RCodeNameError
+			resp, err := msg.Pack()
+			if err != nil {
+				t.Errorf("Error packing response: %v", err)
+				continue
+			}
+			conn.WriteToUDP([]byte("garbage"), from)
+			conn.WriteToUDP(resp, from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), questions
+}
+
+func TestPing(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Query backend.DNSQueryOption
+		Want  dnsmessage.Question
+	}{
+		{
+			Name: "Default",
+			Want: dnsmessage.Question{
+				Name:  dnsmessage.MustNewName("1.0.0.127.in-addr.arpa."),
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+		{
+			Name:  "Custom",
+			Query: backend.DNSQueryOption{Name: "example.com", Type: uint16(dnsmessage.TypeAAAA)},
+			Want: dnsmessage.Question{
+				Name:  dnsmessage.MustNewName("example.com."),
+				Type:  dnsmessage.TypeAAAA,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			addr, questions := startResolver(t)
+			conn, err := New(util.IPv4, c.Query)
+			if err != nil {
+				t.Fatalf("New error: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.WriteTo(&backend.Packet{Type: backend.PacketRequest, Seq: 1234}, addr, backend.StreamOption{Stream: 3}); err != nil {
+				t.Fatalf("WriteTo error: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			pkt, peer, err := conn.ReadFrom(ctx)
+			if err != nil {
+				t.Fatalf("ReadFrom error: %v", err)
+			}
+			if diff := cmp.Diff(&backend.Packet{Type: backend.PacketReply, Seq: 1234}, pkt); diff != "" {
+				t.Errorf("Wrong packet (-want, +got):\n%v", diff)
+			}
+			if diff := test.DiffIP(test.LoopbackV4, peer); diff != "" {
+				t.Errorf("Wrong peer (-want, +got):\n%v", diff)
+			}
+			if diff := cmp.Diff(c.Want, <-questions); diff != "" {
+				t.Errorf("Wrong question (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestReadFrom_Timeout(t *testing.T) {
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := conn.ReadFrom(ctx); !errors.Is(err, backend.ErrTimeout) {
+		t.Errorf("ReadFrom error = %v (want %v)", err, backend.ErrTimeout)
+	}
+}
+
+func TestWriteTo_Unsupported(t *testing.T) {
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteTo(&backend.Packet{Type: backend.PacketTimestampRequest}, test.LoopbackV4); err == nil {
+		t.Errorf("WriteTo succeeded with a timestamp request")
+	}
+	if err := conn.WriteTo(&backend.Packet{Type: backend.PacketRequest}, test.LoopbackV4, backend.TTLOption{TTL: 1}); err == nil {
+		t.Errorf("WriteTo succeeded with a TTL option")
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	cases := []struct {
+		IP   string
+		Want string
+	}{
+		{IP: "192.0.2.1", Want: "1.2.0.192.in-addr.arpa."},
+		{IP: "2001:db8::567:89ab", Want: "b.a.9.8.7.6.5.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	}
+	for _, c := range cases {
+		if got := reverseName(net.ParseIP(c.IP)); got != c.Want {
+			t.Errorf("reverseName(%v) = %q (want %q)", c.IP, got, c.Want)
+		}
+	}
+}
+
+func TestParseType(t *testing.T) {
+	cases := []struct {
+		In      string
+		Want    uint16
+		WantErr bool
+	}{
+		{In: "A", Want: 1},
+		{In: "aaaa", Want: 28},
+		{In: "PTR", Want: 12},
+		{In: "any", Want: 255},
+		{In: "65", Want: 65},
+		{In: "BOGUS", WantErr: true},
+		{In: "70000", WantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseType(c.In)
+		if (err != nil) != c.WantErr || got != c.Want {
+			t.Errorf("ParseType(%q) = %v, %v (want %v, error %v)", c.In, got, err, c.Want, c.WantErr)
+		}
+	}
+}
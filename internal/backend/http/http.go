@@ -0,0 +1,227 @@
+// Package http implements pings that time HTTP requests. Each ping is a GET
+// over a new connection, so its latency covers the TCP connect, any TLS
+// handshake and the wait for the first byte of the response.
+//
+// Requests run in the background, and replies are returned by ReadFrom as
+// they finish. Responses with a status below 500 are replies. Server errors,
+// refused connections and failed handshakes are reported as unreachable. A
+// request that never finishes gets no reply at all, and times out like a lost
+// ping.
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/icmpbase"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+const (
+	// How long a request may take before it's abandoned. The pinger will
+	// have long since given up on it.
+	maxRequestTime = 30 * time.Second
+
+	// Results that may wait to be read before requests start blocking.
+	resultBuffer = 64
+)
+
+func init() {
+	backend.RegisterLocal("http", func(ipVer util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+		return New(ipVer, opts...)
+	})
+}
+
+// Carries a request's destination to the dialer.
+type destKey struct{}
+
+// A finished request.
+type result struct {
+	pkt  *backend.Packet
+	peer net.Addr
+}
+
+// Conn is an HTTP ping connection.
+type Conn struct {
+	ipVer  util.IPVersion
+	url    *url.URL // Nil to use each destination's address.
+	client *nethttp.Client
+
+	results chan result
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// New opens a new connection. The supported options are
+// [backend.SourceOption] and [backend.HTTPOption]. Since requests aren't rate
+// limited, [backend.FloodOption] is ignored.
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*Conn, error) {
+	src := backend.GetSource(opts)
+	if backend.GetFlowLabel(opts) != 0 {
+		return nil, errors.New("flow labels require the icmp backend")
+	}
+	if src.Mark != 0 || src.Netns != "" {
+		return nil, errors.New("fwmarks and network namespaces require the icmp or udp backend")
+	}
+	httpOpt := backend.GetHTTP(opts)
+	var u *url.URL
+	if httpOpt.URL != "" {
+		var err error
+		u, err = url.Parse(httpOpt.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("unsupported URL scheme: %q", u.Scheme)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: maxRequestTime}
+	if src.Addr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: src.Addr}
+	}
+	if src.Interface != "" {
+		dialer.Control = func(_, _ string, rc syscall.RawConn) error {
+			var err error
+			rcErr := rc.Control(func(fd uintptr) {
+				err = icmpbase.BindInterface(int(fd), ipVer, src.Interface)
+			})
+			return errors.Join(rcErr, err)
+		}
+	}
+	network := util.Choose(ipVer, "tcp4", "tcp6")
+	transport := &nethttp.Transport{
+		// Every ping makes a new connection, so that it's timed along with
+		// the request.
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			// The URL's host is only for the Host header and TLS server
+			// name. The connection goes to the destination.
+			_, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			dest := ctx.Value(destKey{}).(net.Addr)
+			return dialer.DialContext(ctx, network, net.JoinHostPort(util.IP(dest).String(), port))
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: httpOpt.Insecure},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Conn{
+		ipVer: ipVer,
+		url:   u,
+		client: &nethttp.Client{
+			Transport: transport,
+			Timeout:   maxRequestTime,
+			// A redirect is a response, and that's all a ping needs.
+			CheckRedirect: func(*nethttp.Request, []*nethttp.Request) error {
+				return nethttp.ErrUseLastResponse
+			},
+		},
+		results: make(chan result, resultBuffer),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Close closes the connection and abandons requests in progress.
+func (c *Conn) Close() error {
+	c.cancel()
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// Returns the URL to fetch from dest.
+func (c *Conn) urlFor(dest net.Addr) string {
+	if c.url != nil {
+		u := *c.url
+		if port := util.Port(dest); port != 0 {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+		}
+		return u.String()
+	}
+	host := util.IP(dest).String()
+	if port := util.Port(dest); port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if c.ipVer == util.IPv6 {
+		host = "[" + host + "]"
+	}
+	return "http://" + host + "/"
+}
+
+// WriteTo starts a request. Only echo requests are supported, and the payload
+// is ignored. [backend.StreamOption] is accepted and ignored; other options
+// aren't supported.
+func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	_, opts, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	if len(opts) > 0 {
+		return fmt.Errorf("unsupported options: %v", opts)
+	}
+	if pkt.Type != backend.PacketRequest {
+		return fmt.Errorf("unsupported request type: %v", pkt.Type)
+	}
+	if c.ctx.Err() != nil {
+		return net.ErrClosed
+	}
+	ctx := context.WithValue(c.ctx, destKey{}, dest)
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodGet, c.urlFor(dest), nil)
+	if err != nil {
+		return err
+	}
+	go c.fetch(req, pkt.Seq, dest)
+	return nil
+}
+
+// Makes a request, and sends its result to ReadFrom.
+func (c *Conn) fetch(req *nethttp.Request, seq int, dest net.Addr) {
+	pkt := &backend.Packet{Seq: seq}
+	resp, err := c.client.Do(req)
+	switch {
+	case c.ctx.Err() != nil:
+		return
+	case err != nil:
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return
+		}
+		logging.Debugf("HTTP ping to %v failed: %v", dest, err)
+		pkt.Type = backend.PacketDestinationUnreachable
+	default:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		pkt.Type = backend.PacketReply
+		if resp.StatusCode >= 500 {
+			pkt.Type = backend.PacketDestinationUnreachable
+		}
+	}
+	select {
+	case c.results <- result{pkt: pkt, peer: &net.UDPAddr{IP: util.IP(dest)}}:
+	case <-c.ctx.Done():
+	}
+}
+
+// ReadFrom returns the result of the next request to finish.
+func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case r := <-c.results:
+		return r.pkt, r.peer, nil
+	case <-ctx.Done():
+		return nil, nil, backend.ErrTimeout
+	case <-c.ctx.Done():
+		return nil, nil, net.ErrClosed
+	}
+}
@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A request seen by a test server.
+type request struct {
+	Host string
+	Path string
+}
+
+// Starts a server that answers every request with status. Returns the
+// server's address and a channel of the requests it gets.
+func startServer(t *testing.T, useTLS bool, status int) (*httptest.Server, *net.TCPAddr, <-chan request) {
+	t.Helper()
+	reqs := make(chan request, 10)
+	h := nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		reqs <- request{Host: r.Host, Path: r.URL.Path}
+		w.WriteHeader(status)
+	})
+	srv := httptest.NewUnstartedServer(h)
+	if useTLS {
+		srv.StartTLS()
+	} else {
+		srv.Start()
+	}
+	t.Cleanup(srv.Close)
+	return srv, srv.Listener.Addr().(*net.TCPAddr), reqs
+}
+
+// Sends a ping and returns the reply.
+func ping(t *testing.T, conn *Conn, dest net.Addr) *backend.Packet {
+	t.Helper()
+	if err := conn.WriteTo(&backend.Packet{Type: backend.PacketRequest, Seq: 1234}, dest, backend.StreamOption{Stream: 3}); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pkt, peer, err := conn.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v", err)
+	}
+	if diff := test.DiffIP(test.LoopbackV4, peer); diff != "" {
+		t.Errorf("Wrong peer (-want, +got):\n%v", diff)
+	}
+	return pkt
+}
+
+func TestPing(t *testing.T) {
+	cases := []struct {
+		Name     string
+		TLS      bool
+		Opt      backend.HTTPOption
+		Status   int
+		Want     backend.PacketType
+		WantHost string // Port is appended.
+		WantPath string
+	}{
+		{
+			Name:     "Default",
+			Status:   nethttp.StatusOK,
+			Want:     backend.PacketReply,
+			WantHost: "127.0.0.1",
+			WantPath: "/",
+		},
+		{
+			Name:     "URL",
+			Opt:      backend.HTTPOption{URL: "http://example.com/health"},
+			Status:   nethttp.StatusNotFound,
+			Want:     backend.PacketReply,
+			WantHost: "example.com",
+			WantPath: "/health",
+		},
+		{
+			Name:     "TLS",
+			TLS:      true,
+			Opt:      backend.HTTPOption{URL: "https://example.com/health", Insecure: true},
+			Status:   nethttp.StatusMovedPermanently,
+			Want:     backend.PacketReply,
+			WantHost: "example.com",
+			WantPath: "/health",
+		},
+		{
+			Name:     "ServerError",
+			Status:   nethttp.StatusServiceUnavailable,
+			Want:     backend.PacketDestinationUnreachable,
+			WantHost: "127.0.0.1",
+			WantPath: "/",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, addr, reqs := startServer(t, c.TLS, c.Status)
+			conn, err := New(util.IPv4, c.Opt)
+			if err != nil {
+				t.Fatalf("New error: %v", err)
+			}
+			defer conn.Close()
+
+			pkt := ping(t, conn, addr)
+			if diff := cmp.Diff(&backend.Packet{Type: c.Want, Seq: 1234}, pkt); diff != "" {
+				t.Errorf("Wrong packet (-want, +got):\n%v", diff)
+			}
+			want := request{Host: net.JoinHostPort(c.WantHost, strconv.Itoa(addr.Port)), Path: c.WantPath}
+			if diff := cmp.Diff(want, <-reqs); diff != "" {
+				t.Errorf("Wrong request (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPing_BadCertificate(t *testing.T) {
+	_, addr, _ := startServer(t, true, nethttp.StatusOK)
+	conn, err := New(util.IPv4, backend.HTTPOption{URL: "https://example.com/"})
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	if pkt := ping(t, conn, addr); pkt.Type != backend.PacketDestinationUnreachable {
+		t.Errorf("Got %v (want %v)", pkt.Type, backend.PacketDestinationUnreachable)
+	}
+}
+
+func TestPing_Refused(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	addr := ln.Addr()
+	ln.Close()
+
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	if pkt := ping(t, conn, addr); pkt.Type != backend.PacketDestinationUnreachable {
+		t.Errorf("Got %v (want %v)", pkt.Type, backend.PacketDestinationUnreachable)
+	}
+}
+
+func TestReadFrom_Timeout(t *testing.T) {
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := conn.ReadFrom(ctx); !errors.Is(err, backend.ErrTimeout) {
+		t.Errorf("ReadFrom error = %v (want %v)", err, backend.ErrTimeout)
+	}
+}
+
+func TestReadFrom_Closed(t *testing.T) {
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	conn.Close()
+	if _, _, err := conn.ReadFrom(context.Background()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ReadFrom error = %v (want %v)", err, net.ErrClosed)
+	}
+}
+
+func TestWriteTo_Unsupported(t *testing.T) {
+	conn, err := New(util.IPv4)
+	if err != nil {
+		t.Fatalf("New error: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteTo(&backend.Packet{Type: backend.PacketTimestampRequest}, test.LoopbackV4); err == nil {
+		t.Errorf("WriteTo succeeded with a timestamp request")
+	}
+	if err := conn.WriteTo(&backend.Packet{Type: backend.PacketRequest}, test.LoopbackV4, backend.TTLOption{TTL: 1}); err == nil {
+		t.Errorf("WriteTo succeeded with a TTL option")
+	}
+}
+
+func TestNew_BadURL(t *testing.T) {
+	for _, u := range []string{"ftp://example.com/", "http://[::1"} {
+		if conn, err := New(util.IPv4, backend.HTTPOption{URL: u}); err == nil {
+			conn.Close()
+			t.Errorf("New succeeded with URL %q", u)
+		}
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	cases := []struct {
+		URL  string
+		Dest net.Addr
+		Want string
+	}{
+		{Dest: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, Want: "http://192.0.2.1/"},
+		{Dest: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8080}, Want: "http://192.0.2.1:8080/"},
+		{Dest: &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}, Want: "http://[2001:db8::1]/"},
+		{URL: "https://example.com/a?b=c", Dest: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, Want: "https://example.com/a?b=c"},
+		{URL: "https://example.com:8443/", Dest: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443}, Want: "https://example.com:443/"},
+	}
+	for _, c := range cases {
+		ipVer := util.AddrVersion(c.Dest)
+		conn, err := New(ipVer, backend.HTTPOption{URL: c.URL})
+		if err != nil {
+			t.Fatalf("New error: %v", err)
+		}
+		if got := conn.urlFor(c.Dest); got != c.Want {
+			t.Errorf("urlFor(%q, %v) = %q (want %q)", c.URL, c.Dest, got, c.Want)
+		}
+		conn.Close()
+	}
+}
@@ -7,10 +7,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/backend/icmpbase"
 	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -21,7 +23,7 @@ const (
 )
 
 func init() {
-	backend.Register("icmp", func(v util.IPVersion) (backend.Conn, error) { return New(v) })
+	backend.Register("icmp", func(v util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) { return New(v, opts...) })
 }
 
 // PingConn is a basic ping network connection. A connection may handle either
@@ -35,12 +37,12 @@ type PingConn struct {
 }
 
 // New creates a new ICMP ping connection. The network arg should be:
-func New(ipVer util.IPVersion) (*PingConn, error) {
-	return baseNew(ipVer, icmpbase.New)
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*PingConn, error) {
+	return baseNew(ipVer, icmpbase.New, opts...)
 }
 
-func baseNew(ipVer util.IPVersion, mkConn func(util.IPVersion, int, int) (*icmpbase.Conn, error)) (*PingConn, error) {
-	conn, err := mkConn(ipVer, 0, ipVer.ICMPProtoNum())
+func baseNew(ipVer util.IPVersion, mkConn func(util.IPVersion, int, int, ...backend.ConnOption) (*icmpbase.Conn, error), opts ...backend.ConnOption) (*PingConn, error) {
+	conn, err := mkConn(ipVer, 0, ipVer.ICMPProtoNum(), opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -62,25 +64,78 @@ func (p *PingConn) Close() error {
 	return p.conn.Close()
 }
 
-// WriteTo sends an ICMP echo request.
+// SocketStats returns the statistics of the socket replies are read from.
+// Implements [backend.StatsConn].
+func (p *PingConn) SocketStats() (backend.SocketStats, error) {
+	return p.conn.SocketStats()
+}
+
+// WriteTo sends an ICMP echo request, or on IPv4 a timestamp or address mask
+// request. The originate timestamp of a timestamp request is set to the current
+// time.
 func (p *PingConn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
-	if pkt.Type != backend.PacketRequest {
-		return fmt.Errorf("packet type must be %v (got %v)", backend.PacketReply, pkt.Type)
+	buf, err := p.marshal(pkt)
+	if err != nil {
+		return err
 	}
-	wm := icmp.Message{
-		Type: p.icmpType,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   p.conn.EchoID(),
-			Seq:  pkt.Seq,
-			Data: pkt.Payload,
-		},
+	return p.conn.WriteTo(buf, dest, opts...)
+}
+
+// WriteBatch sends several ICMP requests, as with WriteTo. Implements
+// [backend.BatchConn].
+func (p *PingConn) WriteBatch(pkts []*backend.Packet, dest net.Addr) (int, error) {
+	bufs := make([][]byte, len(pkts))
+	for i, pkt := range pkts {
+		buf, err := p.marshal(pkt)
+		if err != nil {
+			return 0, err
+		}
+		bufs[i] = buf
+	}
+	return p.conn.WriteBatch(bufs, dest)
+}
+
+// Marshals a request.
+func (p *PingConn) marshal(pkt *backend.Packet) ([]byte, error) {
+	if pkt.Type != backend.PacketRequest && p.ipVer != util.IPv4 {
+		return nil, fmt.Errorf("%v is only supported for IPv4", pkt.Type)
+	}
+	var wm icmp.Message
+	switch pkt.Type {
+	case backend.PacketRequest:
+		wm = icmp.Message{
+			Type: p.icmpType,
+			Body: &icmp.Echo{
+				ID:   p.conn.EchoID(),
+				Seq:  pkt.Seq,
+				Data: pkt.Payload,
+			},
+		}
+	case backend.PacketTimestampRequest:
+		wm = icmp.Message{
+			Type: ipv4.ICMPTypeTimestamp,
+			Body: &icmppkt.Timestamp{
+				ID:         p.conn.EchoID(),
+				Seq:        pkt.Seq,
+				Timestamps: backend.Timestamps{Originate: backend.UTMillis(time.Now())},
+			},
+		}
+	case backend.PacketAddressMaskRequest:
+		wm = icmp.Message{
+			Type: icmppkt.ICMPTypeAddressMask,
+			Body: &icmppkt.AddressMask{
+				ID:  p.conn.EchoID(),
+				Seq: pkt.Seq,
+			},
+		}
+	default:
+		return nil, fmt.Errorf("packet type must be a request (got %v)", pkt.Type)
 	}
 	buf, err := wm.Marshal(nil)
 	if err != nil {
-		return fmt.Errorf("marshal: %v", err)
+		return nil, fmt.Errorf("marshal: %v", err)
 	}
-	return p.conn.WriteTo(buf, dest, opts...)
+	return buf, nil
 }
 
 // Reads an ICMP echo response.
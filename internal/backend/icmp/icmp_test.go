@@ -10,16 +10,25 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/backend/icmpbase"
 	"github.com/pcekm/vasily/internal/backend/test"
 	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 var (
 	localhostV4 = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
 	localhostV6 = &net.UDPAddr{IP: net.ParseIP("::1")}
 
+	// Reply TTLs depend on the system, and receive times on the clock.
+	// They're checked by icmpbase.
+	ignoreReplyInfo = cmpopts.IgnoreFields(backend.Packet{}, "TTL", "RecvTime")
+
 	supportedOS = map[string]bool{
 		"darwin": true,
 		"linux":  true,
@@ -78,7 +87,7 @@ func TestPingConnection(t *testing.T) {
 				if err != nil {
 					t.Errorf("ReadFrom error: %v", err)
 				}
-				if diff := cmp.Diff(asReply(pkt), gotPkt); diff != "" {
+				if diff := cmp.Diff(asReply(pkt), gotPkt, ignoreReplyInfo); diff != "" {
 					t.Errorf("Wrong packet received (-want, +got):\n%v", diff)
 				}
 
@@ -90,6 +99,118 @@ func TestPingConnection(t *testing.T) {
 	}
 }
 
+func TestWriteBatch(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	for _, ipVer := range []util.IPVersion{util.IPv4, util.IPv6} {
+		t.Run(ipVer.String(), func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := baseNew(ipVer, icmpbase.NewUnlimited)
+			if err != nil {
+				t.Fatalf("Error opening connection: %v", err)
+			}
+			defer conn.Close()
+			dest := util.Choose(ipVer, localhostV4, localhostV6)
+
+			want := make(map[int]*backend.Packet)
+			var pkts []*backend.Packet
+			for seq := range 10 {
+				pkt := &backend.Packet{Seq: seq, Payload: []byte("the payload")}
+				pkts = append(pkts, pkt)
+				want[seq] = asReply(pkt)
+			}
+			if n, err := conn.WriteBatch(pkts, dest); n != len(pkts) || err != nil {
+				t.Fatalf("WriteBatch = %d, %v (want %d, nil)", n, err, len(pkts))
+			}
+
+			got := make(map[int]*backend.Packet)
+			for range pkts {
+				pkt, _, err := conn.ReadFrom(ctx)
+				if err != nil {
+					t.Fatalf("ReadFrom error: %v", err)
+				}
+				got[pkt.Seq] = pkt
+			}
+			if diff := cmp.Diff(want, got, ignoreReplyInfo); diff != "" {
+				t.Errorf("Wrong packets received (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	cases := []struct {
+		Name    string
+		IPVer   util.IPVersion
+		Pkt     *backend.Packet
+		Want    *backend.Packet
+		WantErr bool
+	}{
+		{
+			Name:  "Echo",
+			IPVer: util.IPv4,
+			Pkt:   &backend.Packet{Type: backend.PacketRequest, Seq: 1, Payload: []byte{2, 3}},
+			Want:  &backend.Packet{Type: backend.PacketRequest, Seq: 1, Payload: []byte{2, 3}},
+		},
+		{
+			Name:  "Timestamp",
+			IPVer: util.IPv4,
+			Pkt:   &backend.Packet{Type: backend.PacketTimestampRequest, Seq: 4},
+			Want:  &backend.Packet{Type: backend.PacketTimestampRequest, Seq: 4},
+		},
+		{
+			Name:  "AddressMask",
+			IPVer: util.IPv4,
+			Pkt:   &backend.Packet{Type: backend.PacketAddressMaskRequest, Seq: 5},
+			Want:  &backend.Packet{Type: backend.PacketAddressMaskRequest, Seq: 5, AddressMask: net.IPv4Mask(0, 0, 0, 0)},
+		},
+		{
+			Name:    "Timestamp/IPv6",
+			IPVer:   util.IPv6,
+			Pkt:     &backend.Packet{Type: backend.PacketTimestampRequest, Seq: 4},
+			WantErr: true,
+		},
+		{
+			Name:    "Reply",
+			IPVer:   util.IPv4,
+			Pkt:     &backend.Packet{Type: backend.PacketReply, Seq: 6},
+			WantErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			conn := &PingConn{ipVer: c.IPVer, icmpType: util.Choose[icmp.Type](c.IPVer, ipv4.ICMPTypeEcho, ipv6.ICMPTypeEchoRequest), conn: &icmpbase.Conn{}}
+			before := backend.UTMillis(time.Now())
+			buf, err := conn.marshal(c.Pkt)
+			if err != nil {
+				if !c.WantErr {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if c.WantErr {
+				t.Fatalf("No error marshalling %v", c.Pkt)
+			}
+			got, _, _, err := icmppkt.Parse(c.IPVer, buf)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			if c.Pkt.Type == backend.PacketTimestampRequest {
+				if o := got.Timestamps.Originate; o < before || o > before+1000 {
+					t.Errorf("Wrong originate timestamp: %d (want about %d)", o, before)
+				}
+				got.Timestamps = backend.Timestamps{}
+			}
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong packet (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
 func TestConnectionCountLimit(t *testing.T) {
 	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
 		t.Skipf("Unsupported OS")
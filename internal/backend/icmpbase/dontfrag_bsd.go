@@ -0,0 +1,16 @@
+//go:build darwin || freebsd
+
+package icmpbase
+
+import (
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// Returns the socket option level, name and value that disable fragmentation.
+func dontFragmentOpt(ipVer util.IPVersion) (level, opt, val int, err error) {
+	if ipVer == util.IPv6 {
+		return unix.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1, nil
+	}
+	return unix.IPPROTO_IP, unix.IP_DONTFRAG, 1, nil
+}
@@ -0,0 +1,15 @@
+package icmpbase
+
+import (
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// Returns the socket option level, name and value that disable fragmentation.
+// The probe setting sets the DF bit and ignores any cached path MTU.
+func dontFragmentOpt(ipVer util.IPVersion) (level, opt, val int, err error) {
+	if ipVer == util.IPv6 {
+		return unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_PROBE, nil
+	}
+	return unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE, nil
+}
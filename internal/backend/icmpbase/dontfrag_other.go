@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd)
+
+package icmpbase
+
+import (
+	"errors"
+
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Returns the socket option level, name and value that disable fragmentation.
+func dontFragmentOpt(ipVer util.IPVersion) (level, opt, val int, err error) {
+	return -1, -1, -1, errors.New("don't fragment is unsupported on this platform")
+}
@@ -0,0 +1,96 @@
+package icmpbase
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+const flowLabelSupported = true
+
+// Flow label constants from linux/in6.h. They're missing from x/sys/unix.
+const (
+	ipv6FlowLabelMgr  = 32
+	ipv6FlowInfoSend  = 33
+	ipv6FlActionGet   = 0
+	ipv6FlShareAny    = 255
+	ipv6FlFlagsCreate = 1
+)
+
+// Mirrors struct in6_flowlabel_req.
+type in6FlowLabelReq struct {
+	Dst     [16]byte
+	Label   uint32 // Network byte order
+	Action  uint8
+	Share   uint8
+	Flags   uint16
+	Expires uint16
+	Linger  uint16
+	_       uint32
+}
+
+// Sends an ICMP message with an IPv6 flow label. Linux only sends labels that
+// a socket has registered, so the label is registered on first use. Callers
+// must hold p.ttlMu for writing.
+func (p *internalConn) writeWithFlowLabel(buf []byte, dest net.Addr, label uint32) error {
+	var sa unix.RawSockaddrInet6
+	sa.Family = unix.AF_INET6
+	copy(sa.Addr[:], util.IP(dest).To16())
+	binary.BigEndian.PutUint32((*[4]byte)(unsafe.Pointer(&sa.Flowinfo))[:], label)
+
+	if !p.flowLabels[label] {
+		req := in6FlowLabelReq{
+			Dst:    sa.Addr,
+			Action: ipv6FlActionGet,
+			Share:  ipv6FlShareAny,
+			Flags:  ipv6FlFlagsCreate,
+		}
+		binary.BigEndian.PutUint32((*[4]byte)(unsafe.Pointer(&req.Label))[:], label)
+		if err := setsockopt(p.Fd(), unix.IPPROTO_IPV6, ipv6FlowLabelMgr, unsafe.Pointer(&req), unsafe.Sizeof(req)); err != nil {
+			return fmt.Errorf("unable to register flow label: %v", err)
+		}
+		if err := unix.SetsockoptInt(p.Fd(), unix.IPPROTO_IPV6, ipv6FlowInfoSend, 1); err != nil {
+			return fmt.Errorf("unable to enable flow labels: %v", err)
+		}
+		if p.flowLabels == nil {
+			p.flowLabels = make(map[uint32]bool)
+		}
+		p.flowLabels[label] = true
+	}
+
+	rc, err := p.conn.(syscall.Conn).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	err = rc.Write(func(fd uintptr) bool {
+		_, _, errno := unix.Syscall6(unix.SYS_SENDTO, fd,
+			uintptr(unsafe.Pointer(unsafe.SliceData(buf))), uintptr(len(buf)), 0,
+			uintptr(unsafe.Pointer(&sa)), unix.SizeofSockaddrInet6)
+		if errno == unix.EAGAIN {
+			return false
+		}
+		if errno != 0 {
+			sendErr = errno
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return sendErr
+}
+
+// Sets a socket option that x/sys/unix has no wrapper for.
+func setsockopt(fd, level, opt int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), uintptr(level), uintptr(opt), uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
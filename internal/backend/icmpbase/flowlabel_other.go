@@ -0,0 +1,15 @@
+//go:build !linux
+
+package icmpbase
+
+import (
+	"errors"
+	"net"
+)
+
+const flowLabelSupported = false
+
+// Sends an ICMP message with an IPv6 flow label, which isn't supported here.
+func (p *internalConn) writeWithFlowLabel(buf []byte, dest net.Addr, label uint32) error {
+	return errors.New("flow labels aren't supported on this system")
+}
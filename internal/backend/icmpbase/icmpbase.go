@@ -5,9 +5,12 @@ import (
 	"context"
 	"errors"
 	"net"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/util"
 	"golang.org/x/time/rate"
 )
@@ -18,54 +21,98 @@ const (
 	maxActiveConns  = 100
 )
 
-var activeConns = make(chan struct{}, 100)
+var (
+	activeConns = make(chan struct{}, 100)
+
+	// For test injection.
+	getuid = os.Getuid
+)
 
 // Conn is a basic ICMP network connection. A connection may handle either IPv4
 // or IPv6 but not both at the same time. Since this may run setuid root, the
 // total number of open connections is limited.
 type Conn struct {
-	svc      *icmpService
-	limiter  *rate.Limiter
-	echoId   int
-	proto    int
-	receiver chan readResult
+	svc       *icmpService
+	echoId    int
+	proto     int
+	flowLabel uint32
+	receiver  chan readResult
+
+	// Rate limiters for each stream. See [backend.StreamOption].
+	limitMu  sync.Mutex
+	limit    rate.Limit
+	limiters map[int]*rate.Limiter
 }
 
 // New creates a new ICMP connection. The proto and id args filter what packets
 // this will receive. Proto may be syscall.IPPROTO_ICMP, IPPROTO_ICMPV6 or
 // IPPROTO_UDP. In the latter case, the id field is the source port number of
 // the UDP packets that generate an ICMP error response (e.g. time exceeded).
-func New(ipVer util.IPVersion, id, proto int) (*Conn, error) {
+// The supported options are [backend.SourceOption], [backend.FloodOption],
+// [backend.FlowLabelOption] and [backend.RecvBufferOption]. FloodOption
+// removes the rate limit, and is only allowed if the real user is root. (The
+// effective user doesn't count, since this may run setuid.)
+func New(ipVer util.IPVersion, id, proto int, opts ...backend.ConnOption) (*Conn, error) {
+	src := backend.GetSource(opts)
+	flood := backend.IsFlood(opts)
+	if flood && getuid() != 0 {
+		return nil, errors.New("flood mode requires root")
+	}
+	var flowLabel uint32
+	if ipVer == util.IPv6 {
+		flowLabel = backend.GetFlowLabel(opts)
+	}
+	if flowLabel != 0 && !flowLabelSupported {
+		return nil, errors.New("flow labels aren't supported on this system")
+	}
+
 	select {
 	case activeConns <- struct{}{}:
 	default:
 		return nil, errors.New("too many connections")
 	}
 
-	svc, err := serviceFor(ipVer)
+	svc, err := serviceFor(ipVer, src)
 	if err != nil {
+		<-activeConns
 		return nil, err
 	}
+	if rb := backend.GetRecvBuffer(opts); rb.Bytes > 0 {
+		if err := svc.conn.growRecvBuffer(rb); err != nil {
+			logging.Warnf("Unable to set receive buffer to %d bytes: %v", rb.Bytes, err)
+		}
+	}
 	receiver := make(chan readResult)
-	id = svc.RegisterReader(id, proto, receiver)
+	id, err = svc.RegisterReader(id, proto, receiver)
+	if err != nil {
+		releaseService(svc)
+		<-activeConns
+		return nil, err
+	}
 
+	limit := rate.Every(minPingInterval)
+	if flood {
+		limit = rate.Inf
+	}
 	return &Conn{
-		svc:      svc,
-		limiter:  rate.NewLimiter(rate.Every(minPingInterval), 5),
-		echoId:   id,
-		proto:    proto,
-		receiver: receiver,
+		svc:       svc,
+		echoId:    id,
+		proto:     proto,
+		flowLabel: flowLabel,
+		receiver:  receiver,
+		limit:     limit,
+		limiters:  make(map[int]*rate.Limiter),
 	}, nil
 }
 
 // NewUnlimited creates a new ICMP ping connection with no rate limiter. This is
 // for use in tests.
-func NewUnlimited(ipVer util.IPVersion, id, proto int) (*Conn, error) {
-	c, err := New(ipVer, id, proto)
+func NewUnlimited(ipVer util.IPVersion, id, proto int, opts ...backend.ConnOption) (*Conn, error) {
+	c, err := New(ipVer, id, proto, opts...)
 	if err != nil {
 		return nil, err
 	}
-	c.limiter.SetLimit(rate.Inf)
+	c.limit = rate.Inf
 	return c, nil
 }
 
@@ -75,6 +122,7 @@ func (c *Conn) Close() error {
 	// Empty the receiver channel to avoid leaking any sender goroutines.
 	for range c.receiver {
 	}
+	releaseService(c.svc)
 	<-activeConns
 	return nil
 }
@@ -97,10 +145,55 @@ func (c *Conn) ReadFrom(ctx context.Context) (pkt *backend.Packet, peer net.Addr
 	}
 }
 
-// WriteTo implements backend.Conn.
+// SocketStats returns the statistics of the socket replies are read from.
+// Connections that share a socket report the same numbers.
+func (c *Conn) SocketStats() (backend.SocketStats, error) {
+	return c.svc.conn.socketStats()
+}
+
+// Returns true if the rate limiter for a stream allows n more messages.
+func (c *Conn) allow(stream, n int) bool {
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
+	l, ok := c.limiters[stream]
+	if !ok {
+		l = rate.NewLimiter(c.limit, 5)
+		c.limiters[stream] = l
+	}
+	return l.AllowN(time.Now(), n)
+}
+
+// WriteTo implements backend.Conn. Each [backend.StreamOption] is rate limited
+// separately.
 func (c *Conn) WriteTo(b []byte, dest net.Addr, opts ...backend.WriteOption) error {
-	if !c.limiter.Allow() {
+	stream, opts, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	if !c.allow(stream, 1) {
 		return errors.New("rate limit exceeded")
 	}
+	if c.flowLabel != 0 {
+		opts = append(opts, backend.FlowLabelOption{Label: c.flowLabel})
+	}
 	return c.svc.WriteTo(b, dest, opts...)
 }
+
+// WriteBatch sends several messages to dest, all at the default TTL and on
+// stream 0. The rate limiter must allow the entire batch, or nothing is sent.
+// Returns the number of messages sent.
+func (c *Conn) WriteBatch(bufs [][]byte, dest net.Addr) (int, error) {
+	if !c.allow(0, len(bufs)) {
+		return 0, errors.New("rate limit exceeded")
+	}
+	if c.flowLabel != 0 {
+		// Labeled packets are sent one at a time.
+		for i, b := range bufs {
+			if err := c.svc.WriteTo(b, dest, backend.FlowLabelOption{Label: c.flowLabel}); err != nil {
+				return i, err
+			}
+		}
+		return len(bufs), nil
+	}
+	return c.svc.WriteBatch(bufs, dest)
+}
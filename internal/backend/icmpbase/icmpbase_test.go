@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"slices"
 	"syscall"
 	"testing"
 	"time"
@@ -115,7 +116,15 @@ func TestPingConnection(t *testing.T) {
 					t.Fatalf("ReadFrom seq %d wanted timeout err (got %v)", seq, err)
 				}
 				if !c.wantTimeout {
+					if gotMsg.TTL <= 0 {
+						t.Errorf("Reply TTL = %d (want > 0)", gotMsg.TTL)
+					}
+					if gotMsg.RecvTime.IsZero() {
+						t.Errorf("Reply has no receive time")
+					}
 					want := asReply(msg)
+					want.TTL = gotMsg.TTL
+					want.RecvTime = gotMsg.RecvTime
 					if diff := cmp.Diff(want, gotMsg); diff != "" {
 						t.Errorf("Wrong packet received (-want, +got):\n%v", diff)
 					}
@@ -158,3 +167,119 @@ func TestConnectionCountLimit(t *testing.T) {
 		conn.Close()
 	}
 }
+
+// Overrides getuid for the duration of a test.
+func setUID(t *testing.T, uid int) {
+	orig := getuid
+	getuid = func() int { return uid }
+	t.Cleanup(func() { getuid = orig })
+}
+
+func TestNewFloodNotRoot(t *testing.T) {
+	setUID(t, 1000)
+	if conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum(), backend.FloodOption{}); err == nil {
+		t.Errorf("No error creating flood connection as non-root.")
+		conn.Close()
+	}
+}
+
+func TestWriteBatch(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	setUID(t, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum(), backend.FloodOption{})
+	if err != nil {
+		t.Fatalf("Error opening connection: %v", err)
+	}
+	defer conn.Close()
+
+	// More than the normal rate limiter's burst.
+	const n = 8
+	var bufs [][]byte
+	for seq := range n {
+		msg := &icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: conn.EchoID(), Seq: seq, Data: []byte(payload)},
+		}
+		bufs = append(bufs, marshal(t, msg))
+	}
+	if sent, err := conn.WriteBatch(bufs, test.LoopbackV4); sent != n || err != nil {
+		t.Fatalf("WriteBatch = %d, %v (want %d, nil)", sent, err, n)
+	}
+
+	var got []int
+	for range n {
+		pkt, _, err := conn.ReadFrom(ctx)
+		if err != nil {
+			t.Fatalf("ReadFrom error: %v", err)
+		}
+		got = append(got, pkt.Seq)
+	}
+	slices.Sort(got)
+	want := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong replies (-want, +got):\n%v", diff)
+	}
+}
+
+func TestWriteBatchRateLimited(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum())
+	if err != nil {
+		t.Fatalf("Error opening connection: %v", err)
+	}
+	defer conn.Close()
+
+	bufs := make([][]byte, 6)
+	for seq := range bufs {
+		msg := &icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: conn.EchoID(), Seq: seq, Data: []byte(payload)},
+		}
+		bufs[seq] = marshal(t, msg)
+	}
+	if sent, err := conn.WriteBatch(bufs, test.LoopbackV4); sent != 0 || err == nil {
+		t.Errorf("WriteBatch = %d, %v (want 0, error)", sent, err)
+	}
+}
+
+func TestStreamsRateLimitedSeparately(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum())
+	if err != nil {
+		t.Fatalf("Error opening connection: %v", err)
+	}
+	defer conn.Close()
+
+	seq := 0
+	write := func(opts ...backend.WriteOption) error {
+		msg := &icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: conn.EchoID(), Seq: seq, Data: []byte(payload)},
+		}
+		seq++
+		return conn.WriteTo(marshal(t, msg), test.LoopbackV4, opts...)
+	}
+	// Use up stream 0's burst.
+	for range 5 {
+		if err := write(); err != nil {
+			t.Fatalf("WriteTo error: %v", err)
+		}
+	}
+	if err := write(); err == nil {
+		t.Errorf("WriteTo on stream 0 wasn't rate limited")
+	}
+	if err := write(backend.StreamOption{Stream: 1}); err != nil {
+		t.Errorf("WriteTo on stream 1 error: %v", err)
+	}
+	if err := write(backend.StreamOption{Stream: backend.MaxStreams}); err == nil {
+		t.Errorf("WriteTo on out of range stream succeeded")
+	}
+}
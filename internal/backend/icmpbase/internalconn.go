@@ -8,9 +8,13 @@ import (
 	"os"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
 type internalConn struct {
@@ -25,6 +29,87 @@ type internalConn struct {
 	readMu sync.Mutex
 	conn   net.PacketConn
 	file   *os.File
+
+	// Wrappers around conn for asking for the TTL or hop limit of replies.
+	// Only the one for ipVer is set.
+	conn4 *ipv4.PacketConn
+	conn6 *ipv6.PacketConn
+
+	// Size of the control messages read with each packet.
+	oobLen int
+
+	// Flow labels registered with the kernel. Guarded by ttlMu.
+	flowLabels map[uint32]bool
+
+	// The largest receive buffer asked for. Zero for the system default.
+	recvBufMu  sync.Mutex
+	recvBuffer int
+}
+
+// Asks for the TTL or hop limit and the receive time of packets. Called
+// once while setting up the connection. Receive timestamps are only used if
+// the system has them.
+func (p *internalConn) recvControl() error {
+	p.oobLen = recvTimeSpace
+	if err := EnableRecvTimestamps(p.Fd()); err != nil {
+		logging.Debugf("No receive timestamps for %v: %v", p.ipVer, err)
+		p.oobLen = 0
+	}
+	if p.ipVer == util.IPv4 {
+		p.conn4 = ipv4.NewPacketConn(p.conn)
+		p.oobLen += len(ipv4.NewControlMessage(ipv4.FlagTTL))
+		return p.conn4.SetControlMessage(ipv4.FlagTTL, true)
+	}
+	p.conn6 = ipv6.NewPacketConn(p.conn)
+	p.oobLen += len(ipv6.NewControlMessage(ipv6.FlagHopLimit))
+	return p.conn6.SetControlMessage(ipv6.FlagHopLimit, true)
+}
+
+// Reads a packet along with the TTL or hop limit it arrived with and the time
+// it was received. The TTL is zero if the system didn't provide it. The
+// receive time is the kernel's timestamp if there is one, or else when the
+// read returned.
+func (p *internalConn) readWithControl(buf []byte) (n, ttl int, recvTime time.Time, peer net.Addr, err error) {
+	oob := make([]byte, p.oobLen)
+	var oobn int
+	switch c := p.conn.(type) {
+	case *net.UDPConn:
+		var addr *net.UDPAddr
+		n, oobn, _, addr, err = c.ReadMsgUDP(buf, oob)
+		if addr != nil {
+			peer = addr
+		}
+	case *net.IPConn:
+		var addr *net.IPAddr
+		n, oobn, _, addr, err = c.ReadMsgIP(buf, oob)
+		if addr != nil {
+			peer = addr
+		}
+		if err == nil && n > 0 && p.ipVer == util.IPv4 {
+			// Raw IPv4 sockets return the IP header too.
+			hdrLen := min(n, int(buf[0]&0x0f)<<2)
+			n = copy(buf, buf[hdrLen:n])
+		}
+	default:
+		return 0, 0, time.Time{}, nil, fmt.Errorf("unexpected connection type %T", p.conn)
+	}
+	if err != nil {
+		return n, 0, time.Time{}, peer, err
+	}
+	oob = oob[:oobn]
+	recvTime = RecvTime(oob)
+	if p.ipVer == util.IPv4 {
+		var cm ipv4.ControlMessage
+		if cm.Parse(oob) == nil {
+			ttl = cm.TTL
+		}
+	} else {
+		var cm ipv6.ControlMessage
+		if cm.Parse(oob) == nil {
+			ttl = cm.HopLimit
+		}
+	}
+	return n, ttl, recvTime, peer, nil
 }
 
 // Close closes the connection.
@@ -41,6 +126,28 @@ func (p *internalConn) Fd() int {
 	return int(p.file.Fd())
 }
 
+// Grows the socket's receive buffer to at least the size asked for.
+// Connections that share the socket may ask for different sizes, and get the
+// largest.
+func (p *internalConn) growRecvBuffer(rb backend.RecvBufferOption) error {
+	p.recvBufMu.Lock()
+	defer p.recvBufMu.Unlock()
+	n := min(rb.Bytes, backend.MaxRecvBuffer)
+	if n <= p.recvBuffer {
+		return nil
+	}
+	if err := setRecvBuffer(p.Fd(), n, rb.Force); err != nil {
+		return err
+	}
+	p.recvBuffer = n
+	return nil
+}
+
+// Returns the socket's statistics.
+func (p *internalConn) socketStats() (backend.SocketStats, error) {
+	return socketStats(p.Fd())
+}
+
 // Sets the time to live of sent packets.
 func (p *internalConn) setTTL(ttl int) error {
 	return syscall.SetsockoptInt(p.Fd(), p.ipVer.IPProtoNum(), p.ipVer.TTLSockOpt(), ttl)
@@ -54,16 +161,24 @@ func (p *internalConn) ttl() (int, error) {
 // WriteTo sends an ICMP message.
 func (p *internalConn) WriteTo(buf []byte, dest net.Addr, opts ...backend.WriteOption) error {
 	var withTTL int
+	var dontFrag bool
+	var flowLabel uint32
 	for _, o := range opts {
 		switch o := o.(type) {
 		case backend.TTLOption:
 			withTTL = o.TTL
+		case backend.DontFragmentOption:
+			dontFrag = true
+		case backend.FlowLabelOption:
+			if p.ipVer == util.IPv6 {
+				flowLabel = o.Label & backend.MaxFlowLabel
+			}
 		default:
 			log.Panicf("Unsupported option: %#v", o)
 		}
 	}
-	if withTTL != 0 {
-		return p.writeToTTL(buf, dest, withTTL)
+	if withTTL != 0 || dontFrag || flowLabel != 0 {
+		return p.writeToOpts(buf, dest, withTTL, dontFrag, flowLabel)
 	}
 	return p.writeToNormal(buf, dest)
 }
@@ -74,21 +189,58 @@ func (p *internalConn) writeToNormal(buf []byte, dest net.Addr) error {
 	return p.baseWriteTo(buf, dest)
 }
 
-// writeToTTL sends an ICMP message with a given time to live.
-func (p *internalConn) writeToTTL(buf []byte, dest net.Addr, ttl int) error {
+// WriteBatch sends several ICMP messages at the default TTL. The lock is only
+// taken once for the whole batch. Returns the number of messages sent.
+func (p *internalConn) WriteBatch(bufs [][]byte, dest net.Addr) (int, error) {
+	p.ttlMu.RLock()
+	defer p.ttlMu.RUnlock()
+	for i, buf := range bufs {
+		if err := p.baseWriteTo(buf, dest); err != nil {
+			return i, err
+		}
+	}
+	return len(bufs), nil
+}
+
+// writeToOpts sends an ICMP message with a given time to live and IPv6 flow
+// label (if nonzero) and don't fragment setting.
+func (p *internalConn) writeToOpts(buf []byte, dest net.Addr, ttl int, dontFrag bool, flowLabel uint32) error {
 	p.ttlMu.Lock()
 	defer p.ttlMu.Unlock()
-	origTTL, err := p.ttl()
-	if err != nil {
-		return fmt.Errorf("unable to get current ttl: %v", err)
+	if ttl != 0 {
+		origTTL, err := p.ttl()
+		if err != nil {
+			return fmt.Errorf("unable to get current ttl: %v", err)
+		}
+		defer func() {
+			if err := p.setTTL(origTTL); err != nil {
+				logging.Warnf("Unable to set ttl: %v", err)
+			}
+		}()
+		if err := p.setTTL(ttl); err != nil {
+			return fmt.Errorf("unable to set ttl: %v", err)
+		}
 	}
-	defer func() {
-		if err := p.setTTL(origTTL); err != nil {
-			log.Printf("Unable to set ttl: %v", err)
+	if dontFrag {
+		level, opt, val, err := dontFragmentOpt(p.ipVer)
+		if err != nil {
+			return err
+		}
+		orig, err := syscall.GetsockoptInt(p.Fd(), level, opt)
+		if err != nil {
+			return fmt.Errorf("unable to get current don't fragment setting: %v", err)
 		}
-	}()
-	if err := p.setTTL(ttl); err != nil {
-		return fmt.Errorf("unable to set ttl: %v", err)
+		defer func() {
+			if err := syscall.SetsockoptInt(p.Fd(), level, opt, orig); err != nil {
+				logging.Warnf("Unable to reset don't fragment setting: %v", err)
+			}
+		}()
+		if err := syscall.SetsockoptInt(p.Fd(), level, opt, val); err != nil {
+			return fmt.Errorf("unable to set don't fragment: %v", err)
+		}
+	}
+	if flowLabel != 0 {
+		return p.writeWithFlowLabel(buf, dest, flowLabel)
 	}
 	return p.baseWriteTo(buf, dest)
 }
@@ -7,13 +7,29 @@ import (
 	"net"
 	"os"
 
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/netns"
 	"github.com/pcekm/vasily/internal/util"
 	"golang.org/x/sys/unix"
 )
 
 // creates a new ICMP ping connection.
-func newInternalConn(ipVer util.IPVersion) (*internalConn, error) {
-	fd, err := unix.Socket(ipVer.AddressFamily(), unix.SOCK_DGRAM, ipVer.ICMPProtoNum())
+func newInternalConn(ipVer util.IPVersion, src backend.SourceOption) (*internalConn, error) {
+	var fd int
+	err := netns.Run(src.Netns, func() error {
+		var err error
+		fd, err = unix.Socket(ipVer.AddressFamily(), unix.SOCK_DGRAM, ipVer.ICMPProtoNum())
+		if err != nil {
+			return err
+		}
+		if !src.IsZero() {
+			if err := BindSource(fd, ipVer, src); err != nil {
+				unix.Close(fd)
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -37,6 +53,10 @@ func newInternalConn(ipVer util.IPVersion) (*internalConn, error) {
 		conn:  conn,
 		file:  f,
 	}
+	if err := p.recvControl(); err != nil {
+		p.Close()
+		return nil, err
+	}
 	return p, nil
 }
 
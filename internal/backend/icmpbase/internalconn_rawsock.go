@@ -7,13 +7,29 @@ import (
 	"net"
 	"os"
 
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/netns"
 	"github.com/pcekm/vasily/internal/util"
 	"golang.org/x/sys/unix"
 )
 
 // creates a new ICMP ping connection.
-func newInternalConn(ipVer util.IPVersion) (*internalConn, error) {
-	fd, err := unix.Socket(ipVer.AddressFamily(), unix.SOCK_RAW, ipVer.ICMPProtoNum())
+func newInternalConn(ipVer util.IPVersion, src backend.SourceOption) (*internalConn, error) {
+	var fd int
+	err := netns.Run(src.Netns, func() error {
+		var err error
+		fd, err = openRawSocket(ipVer)
+		if err != nil {
+			return err
+		}
+		if !src.IsZero() {
+			if err := BindSource(fd, ipVer, src); err != nil {
+				unix.Close(fd)
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -32,9 +48,28 @@ func newInternalConn(ipVer util.IPVersion) (*internalConn, error) {
 		conn:  conn,
 		file:  f,
 	}
+	if err := p.recvControl(); err != nil {
+		p.Close()
+		return nil, err
+	}
 	return p, nil
 }
 
+// Opens a raw ICMP socket, or gets one from [backend.RawSocketOpener] if it's
+// set.
+func openRawSocket(ipVer util.IPVersion) (int, error) {
+	open := backend.RawSocketOpener()
+	if open == nil {
+		return unix.Socket(ipVer.AddressFamily(), unix.SOCK_RAW, ipVer.ICMPProtoNum())
+	}
+	f, err := open(ipVer)
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+	return unix.FcntlInt(f.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+}
+
 // Core writeTo function. Callers must hold p.mu.
 func (p *internalConn) baseWriteTo(buf []byte, dest net.Addr) error {
 	if _, err := p.conn.WriteTo(buf, &net.IPAddr{IP: util.IP(dest)}); err != nil {
@@ -0,0 +1,9 @@
+package icmpbase
+
+import "golang.org/x/sys/unix"
+
+// SetMark sets a socket's firewall mark, which policy routing rules can match
+// on. It needs CAP_NET_ADMIN.
+func SetMark(fd int, mark uint32) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package icmpbase
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// SetMark sets a socket's firewall mark. Not supported on this OS.
+func SetMark(fd int, mark uint32) error {
+	return fmt.Errorf("firewall marks are not supported on %s", runtime.GOOS)
+}
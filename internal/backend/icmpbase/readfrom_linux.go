@@ -21,13 +21,10 @@ func (c *internalConn) ReadFrom() (*backend.Packet, net.Addr, listenerKey, error
 	defer c.readMu.Unlock()
 
 	buf := make([]byte, maxMTU)
-	var n int
-	var peer net.Addr
-	var err error
-	n, peer, err = c.conn.ReadFrom(buf)
+	n, ttl, recvTime, peer, err := c.readWithControl(buf)
 	if err != nil {
 		var errno unix.Errno
-		if errors.As(err, &errno) && errno == unix.EHOSTUNREACH {
+		if errors.As(err, &errno) && (errno == unix.EHOSTUNREACH || errno == unix.EMSGSIZE) {
 			return c.readErr(buf)
 		}
 		var opErr *net.OpError
@@ -37,10 +34,12 @@ func (c *internalConn) ReadFrom() (*backend.Packet, net.Addr, listenerKey, error
 		return nil, nil, listenerKey{}, err
 	}
 
-	pkt, id, proto, err := icmppkt.Parse(c.ipVer, buf[:n])
+	pkt, id, proto, err := icmppkt.ParseStrict(c.ipVer, buf[:n])
 	if err != nil {
 		return nil, nil, listenerKey{}, err
 	}
+	pkt.TTL = ttl
+	pkt.RecvTime = recvTime
 	return pkt, peer, listenerKey{ID: id, Proto: proto}, err
 }
 
@@ -53,12 +52,13 @@ func (c *internalConn) readErr(buf []byte) (*backend.Packet, net.Addr, listenerK
 
 	oob := icmppkt.OOBBytes(c.ipVer)
 	var n, oobn int
+	var dest unix.Sockaddr
 	rcErr := rawconn.Read(func(fd uintptr) bool {
 		// This returns a Sockaddr, but it always contains the original
 		// destination, and not the host that generated the error. Which makes
 		// it useless for traceroute. The actual host is at the end of oob and
 		// gets extracted by parseErr().
-		n, oobn, _, _, err = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE)
+		n, oobn, _, dest, err = unix.Recvmsg(int(fd), buf, oob, unix.MSG_ERRQUEUE)
 		return true
 	})
 	if rcErr != nil {
@@ -71,15 +71,33 @@ func (c *internalConn) readErr(buf []byte) (*backend.Packet, net.Addr, listenerK
 	if err != nil {
 		return nil, nil, listenerKey{}, err
 	}
-	pktType, peer, err := icmppkt.ParseLinuxEE(oob[:oobn])
+	pktType, peer, ttl, err := icmppkt.ParseLinuxEE(oob[:oobn])
 	if err != nil {
 		return nil, nil, listenerKey{}, err
 	}
+	if peer == nil {
+		// Locally-generated error. The original destination is as good a
+		// peer as any.
+		peer = sockaddrToAddr(dest)
+	}
 	pkt := &backend.Packet{
-		Type:    pktType,
-		Seq:     sentPkt.Seq,
-		Payload: sentPkt.Payload,
+		Type:     pktType,
+		Seq:      sentPkt.Seq,
+		Payload:  sentPkt.Payload,
+		TTL:      ttl,
+		RecvTime: RecvTime(oob[:oobn]),
 	}
 	id := util.Port(c.conn.LocalAddr())
 	return pkt, peer, listenerKey{ID: id, Proto: c.ipVer.ICMPProtoNum()}, nil
 }
+
+func sockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		return &net.UDPAddr{IP: net.IP(sa.Addr[:]), Port: sa.Port}
+	default:
+		return &net.UDPAddr{}
+	}
+}
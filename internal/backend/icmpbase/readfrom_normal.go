@@ -18,7 +18,7 @@ func (c *internalConn) ReadFrom() (*backend.Packet, net.Addr, listenerKey, error
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
 	buf := make([]byte, maxMTU)
-	n, peer, err := c.conn.ReadFrom(buf)
+	n, ttl, recvTime, peer, err := c.readWithControl(buf)
 	if err != nil {
 		var op *net.OpError
 		if errors.As(err, &op) {
@@ -29,6 +29,11 @@ func (c *internalConn) ReadFrom() (*backend.Packet, net.Addr, listenerKey, error
 		return nil, peer, listenerKey{}, fmt.Errorf("read error: %v", err)
 	}
 
-	pkt, id, proto, err := icmppkt.Parse(c.ipVer, buf[:n])
-	return pkt, peer, listenerKey{ID: id, Proto: proto}, err
+	pkt, id, proto, err := icmppkt.ParseStrict(c.ipVer, buf[:n])
+	if err != nil {
+		return nil, peer, listenerKey{}, err
+	}
+	pkt.TTL = ttl
+	pkt.RecvTime = recvTime
+	return pkt, peer, listenerKey{ID: id, Proto: proto}, nil
 }
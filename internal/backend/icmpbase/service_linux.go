@@ -9,7 +9,9 @@ import (
 	"sync"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
 )
 
 type icmpService struct {
@@ -18,8 +20,8 @@ type icmpService struct {
 	receiver chan<- readResult
 }
 
-func serviceFor(ipVer util.IPVersion) (*icmpService, error) {
-	conn, err := newInternalConn(ipVer)
+func serviceFor(ipVer util.IPVersion, src backend.SourceOption) (*icmpService, error) {
+	conn, err := newInternalConn(ipVer, src)
 	if err != nil {
 		return nil, err
 	}
@@ -31,6 +33,14 @@ func serviceFor(ipVer util.IPVersion) (*icmpService, error) {
 func (s *icmpService) Close() error {
 	return s.conn.Close()
 }
+
+// Closes the service from [serviceFor], which only one connection holds.
+func releaseService(s *icmpService) {
+	if err := s.Close(); err != nil {
+		logging.Warnf("Error closing service: %v", err)
+	}
+}
+
 func (s *icmpService) readLoop() {
 	for {
 		pkt, peer, key, err := s.conn.ReadFrom()
@@ -38,7 +48,12 @@ func (s *icmpService) readLoop() {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			log.Printf("Read error: %v", err)
+			var parseErr *icmppkt.ParseError
+			if errors.As(err, &parseErr) {
+				// Already counted. See icmppkt.ParseErrorCounts.
+				continue
+			}
+			logging.Errorf("Read error: %v", err)
 			return
 		}
 		go s.sendToReceiver(pkt, peer, key)
@@ -48,6 +63,10 @@ func (s *icmpService) readLoop() {
 func (s *icmpService) sendToReceiver(pkt *backend.Packet, peer net.Addr, key listenerKey) {
 	s.Lock()
 	defer s.Unlock()
+	// Replies may still arrive after the reader is unregistered.
+	if s.receiver == nil {
+		return
+	}
 	s.receiver <- readResult{
 		Pkt:  pkt,
 		Peer: peer,
@@ -59,8 +78,12 @@ func (s *icmpService) WriteTo(b []byte, peer net.Addr, opts ...backend.WriteOpti
 	return s.conn.WriteTo(b, peer, opts...)
 }
 
+func (s *icmpService) WriteBatch(bufs [][]byte, peer net.Addr) (int, error) {
+	return s.conn.WriteBatch(bufs, peer)
+}
+
 // Should only be called once on Linux since icmpService isn't a singleton.
-func (s *icmpService) RegisterReader(id, proto int, receiver chan<- readResult) int {
+func (s *icmpService) RegisterReader(id, proto int, receiver chan<- readResult) (int, error) {
 	s.Lock()
 	defer s.Unlock()
 	if s.receiver != nil {
@@ -72,7 +95,7 @@ func (s *icmpService) RegisterReader(id, proto int, receiver chan<- readResult)
 	id = s.conn.echoID
 	s.receiver = receiver
 	go s.readLoop()
-	return id
+	return id, nil
 }
 
 // Should only be called once on Linux since icmpService isn't a singleton.
@@ -80,4 +103,5 @@ func (s *icmpService) UnregisterReader(id, proto int) {
 	s.Lock()
 	defer s.Unlock()
 	close(s.receiver)
+	s.receiver = nil
 }
@@ -4,21 +4,47 @@ package icmpbase
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"sync"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
 )
 
 var (
 	serviceStart sync.Once
 	serviceV4    *icmpService
 	serviceV6    *icmpService
+
+	// Services bound to a specific source, created as needed and closed when
+	// their last connection is. Guards the services' refs too.
+	sourceServicesMu sync.Mutex
+	sourceServices   = make(map[sourceKey]*icmpService)
+
+	// Creates services bound to a source. For test injection.
+	newSourceService = newICMPService
 )
 
-func serviceFor(ipVer util.IPVersion) (*icmpService, error) {
+// The largest ICMP echo id, which is also the largest port. Both are 16 bits.
+const maxID = 1<<16 - 1
+
+// Identifies a service bound to a source.
+type sourceKey struct {
+	ipVer util.IPVersion
+	iface string
+	addr  string
+	mark  uint32
+	netns string
+}
+
+func serviceFor(ipVer util.IPVersion, src backend.SourceOption) (*icmpService, error) {
+	if !src.IsZero() {
+		return sourceServiceFor(ipVer, src)
+	}
 	maybeStartService()
 	switch ipVer {
 	case util.IPv4:
@@ -34,28 +60,70 @@ func serviceFor(ipVer util.IPVersion) (*icmpService, error) {
 func maybeStartService() {
 	serviceStart.Do(func() {
 		var err error
-		serviceV4, err = newICMPService(util.IPv4)
+		serviceV4, err = newICMPService(util.IPv4, backend.SourceOption{})
 		if err != nil {
 			log.Panicf("Error starting ICMPv4 service: %v", err)
 		}
-		serviceV6, err = newICMPService(util.IPv6)
+		serviceV6, err = newICMPService(util.IPv6, backend.SourceOption{})
 		if err != nil {
 			log.Panicf("Error starting ICMPv6 service: %v", err)
 		}
 	})
 }
 
+func sourceServiceFor(ipVer util.IPVersion, src backend.SourceOption) (*icmpService, error) {
+	sourceServicesMu.Lock()
+	defer sourceServicesMu.Unlock()
+	key := sourceKey{ipVer: ipVer, iface: src.Interface, addr: src.Addr.String(), mark: src.Mark, netns: src.Netns}
+	if s, ok := sourceServices[key]; ok {
+		s.refs++
+		return s, nil
+	}
+	s, err := newSourceService(ipVer, src)
+	if err != nil {
+		return nil, err
+	}
+	s.source = &key
+	s.refs = 1
+	sourceServices[key] = s
+	return s, nil
+}
+
+// Releases a connection's hold on the service from [serviceFor]. A service
+// bound to a source is closed once no connections hold it. The default ones
+// stay open.
+func releaseService(s *icmpService) {
+	if s.source == nil {
+		return
+	}
+	sourceServicesMu.Lock()
+	defer sourceServicesMu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return
+	}
+	delete(sourceServices, *s.source)
+	if err := s.Close(); err != nil {
+		logging.Warnf("Error closing %v service: %v", s.ipVer, err)
+	}
+}
+
 type icmpService struct {
 	ipVer util.IPVersion
 	conn  *internalConn
 	done  chan struct{}
 
+	// Set for services bound to a source, along with the number of
+	// connections holding them.
+	source *sourceKey
+	refs   int
+
 	sync.Mutex
 	listeners map[listenerKey]chan<- readResult
 }
 
-func newICMPService(ipVer util.IPVersion) (*icmpService, error) {
-	conn, err := newInternalConn(ipVer)
+func newICMPService(ipVer util.IPVersion, src backend.SourceOption) (*icmpService, error) {
+	conn, err := newInternalConn(ipVer, src)
 	if err != nil {
 		return nil, err
 	}
@@ -81,7 +149,12 @@ func (s *icmpService) readLoop() {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			log.Printf("Read error: %v", err)
+			var parseErr *icmppkt.ParseError
+			if errors.As(err, &parseErr) {
+				// Already counted. See icmppkt.ParseErrorCounts.
+				continue
+			}
+			logging.Errorf("Read error: %v", err)
 			return
 		}
 		go s.sendToReceiver(pkt, peer, key)
@@ -91,7 +164,7 @@ func (s *icmpService) readLoop() {
 func (s *icmpService) sendToReceiver(pkt *backend.Packet, peer net.Addr, key listenerKey) {
 	// Filter sent ICMPV6 echo requests that are also received on the same
 	// connection. (Mostly a problem for unprivileged ICMP on macOS.)
-	if pkt.Type == backend.PacketRequest {
+	if pkt.Type.IsRequest() {
 		return
 	}
 
@@ -112,17 +185,33 @@ func (s *icmpService) WriteTo(b []byte, peer net.Addr, opts ...backend.WriteOpti
 	return s.conn.WriteTo(b, peer, opts...)
 }
 
+func (s *icmpService) WriteBatch(bufs [][]byte, peer net.Addr) (int, error) {
+	return s.conn.WriteBatch(bufs, peer)
+}
+
 // RegisterReader registers a receiver for the given id and protocol number. If
-// ID is 0, an ID will be assigned. The provided or assigned id will be
-// returned.
-func (s *icmpService) RegisterReader(id, proto int, receiver chan<- readResult) int {
+// ID is 0, a free ID will be assigned. The provided or assigned id will be
+// returned. Each id is leased to one receiver at a time, since replies are
+// told apart by it alone. It's an error to ask for one that's taken.
+func (s *icmpService) RegisterReader(id, proto int, receiver chan<- readResult) (int, error) {
 	s.Lock()
 	defer s.Unlock()
 	if id == 0 {
-		id = util.GenID()
+		for range maxID {
+			cand := util.GenID() & maxID
+			if _, ok := s.listeners[listenerKey{ID: cand, Proto: proto}]; cand != 0 && !ok {
+				id = cand
+				break
+			}
+		}
+		if id == 0 {
+			return 0, errors.New("no free ICMP ids")
+		}
+	} else if _, ok := s.listeners[listenerKey{ID: id, Proto: proto}]; ok {
+		return 0, fmt.Errorf("id %d is already in use", id)
 	}
 	s.listeners[listenerKey{ID: id, Proto: proto}] = receiver
-	return id
+	return id, nil
 }
 
 func (s *icmpService) UnregisterReader(id, proto int) {
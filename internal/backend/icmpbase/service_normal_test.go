@@ -0,0 +1,107 @@
+//go:build rawsock || !linux
+
+package icmpbase
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Generates ids counting up from a start.
+type countingIDGen struct {
+	next int
+}
+
+func (g *countingIDGen) GenID() int {
+	id := g.next
+	g.next++
+	return id
+}
+
+func TestRegisterReader(t *testing.T) {
+	orig := util.IDGenerator
+	defer func() { util.IDGenerator = orig }()
+	util.IDGenerator = &countingIDGen{next: 1<<16 - 1}
+
+	s := &icmpService{listeners: make(map[listenerKey]chan<- readResult)}
+	proto := syscall.IPPROTO_ICMP
+	if id, err := s.RegisterReader(1, proto, make(chan readResult)); err != nil || id != 1 {
+		t.Fatalf("RegisterReader(1) = %v, %v (want 1, nil)", id, err)
+	}
+	if _, err := s.RegisterReader(1, proto, make(chan readResult)); err == nil {
+		t.Errorf("RegisterReader(1) again succeeded (want error)")
+	}
+	// Ids are leased separately for each protocol.
+	if _, err := s.RegisterReader(1, syscall.IPPROTO_UDP, make(chan readResult)); err != nil {
+		t.Errorf("RegisterReader(1) for UDP error: %v", err)
+	}
+
+	// Generated ids skip the one in use, and zero when they wrap around.
+	var got []int
+	for range 2 {
+		id, err := s.RegisterReader(0, proto, make(chan readResult))
+		if err != nil {
+			t.Fatalf("RegisterReader(0) error: %v", err)
+		}
+		got = append(got, id)
+	}
+	if got[0] != 1<<16-1 || got[1] != 2 {
+		t.Errorf("Generated ids %v (want [%d 2])", got, 1<<16-1)
+	}
+}
+
+func TestSourceServiceRefs(t *testing.T) {
+	orig := newSourceService
+	defer func() { newSourceService = orig }()
+	var opened []net.PacketConn
+	newSourceService = func(ipVer util.IPVersion, _ backend.SourceOption) (*icmpService, error) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, conn)
+		return &icmpService{
+			ipVer:     ipVer,
+			conn:      &internalConn{ipVer: ipVer, conn: conn},
+			done:      make(chan struct{}),
+			listeners: make(map[listenerKey]chan<- readResult),
+		}, nil
+	}
+
+	src := backend.SourceOption{Interface: "test0"}
+	s1, err := serviceFor(util.IPv4, src)
+	if err != nil {
+		t.Fatalf("serviceFor error: %v", err)
+	}
+	s2, err := serviceFor(util.IPv4, src)
+	if err != nil {
+		t.Fatalf("serviceFor error: %v", err)
+	}
+	if s1 != s2 || len(opened) != 1 {
+		t.Fatalf("Opened %d services for one source (want 1 shared)", len(opened))
+	}
+
+	releaseService(s1)
+	if _, err := opened[0].WriteTo([]byte("x"), opened[0].LocalAddr()); err != nil {
+		t.Errorf("Service closed while still held: %v", err)
+	}
+	releaseService(s2)
+	if _, err := opened[0].WriteTo([]byte("x"), opened[0].LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("WriteTo after last release = %v (want %v)", err, net.ErrClosed)
+	}
+
+	// The next connection gets a new service.
+	s3, err := serviceFor(util.IPv4, src)
+	if err != nil {
+		t.Fatalf("serviceFor error: %v", err)
+	}
+	defer releaseService(s3)
+	if len(opened) != 2 {
+		t.Errorf("Opened %d services (want 2)", len(opened))
+	}
+}
@@ -0,0 +1,36 @@
+package icmpbase
+
+import (
+	"unsafe"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"golang.org/x/sys/unix"
+)
+
+// Sets the size of a socket's receive buffer. If force is set,
+// SO_RCVBUFFORCE is tried first, which goes past net.core.rmem_max with
+// CAP_NET_ADMIN. Otherwise, or without it, SO_RCVBUF is used, which the
+// kernel quietly caps.
+func setRecvBuffer(fd, n int, force bool) error {
+	if force {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUFFORCE, n); err == nil {
+			return nil
+		}
+	}
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, n)
+}
+
+// Gets a socket's statistics from SO_MEMINFO.
+func socketStats(fd int) (backend.SocketStats, error) {
+	var mem [unix.SK_MEMINFO_VARS]uint32
+	size := uint32(unsafe.Sizeof(mem))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_SOCKET, unix.SO_MEMINFO,
+		uintptr(unsafe.Pointer(&mem)), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return backend.SocketStats{}, errno
+	}
+	return backend.SocketStats{
+		RecvBuffer: int(mem[unix.SK_MEMINFO_RCVBUF]),
+		Drops:      int64(mem[unix.SK_MEMINFO_DROPS]),
+	}, nil
+}
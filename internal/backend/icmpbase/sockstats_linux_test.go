@@ -0,0 +1,40 @@
+package icmpbase
+
+import (
+	"testing"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+func TestGrowRecvBuffer(t *testing.T) {
+	conn, err := newInternalConn(util.IPv4, backend.SourceOption{})
+	if err != nil {
+		t.Skipf("Can't open a connection: %v", err)
+	}
+	defer conn.Close()
+	before, err := conn.socketStats()
+	if err != nil {
+		t.Fatalf("socketStats() error: %v", err)
+	}
+	if before.Drops != 0 {
+		t.Errorf("Drops = %d on a new socket (want 0)", before.Drops)
+	}
+
+	// Linux doubles requested sizes, so asking for the current size grows it
+	// even without root. Asking for less afterward shouldn't shrink it.
+	want := before.RecvBuffer
+	if err := conn.growRecvBuffer(backend.RecvBufferOption{Bytes: want}); err != nil {
+		t.Fatalf("growRecvBuffer(%d) error: %v", want, err)
+	}
+	if err := conn.growRecvBuffer(backend.RecvBufferOption{Bytes: want / 4}); err != nil {
+		t.Fatalf("growRecvBuffer(%d) error: %v", want/4, err)
+	}
+	after, err := conn.socketStats()
+	if err != nil {
+		t.Fatalf("socketStats() error: %v", err)
+	}
+	if after.RecvBuffer < want {
+		t.Errorf("RecvBuffer = %d after growing to %d (want at least %d)", after.RecvBuffer, want, want)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package icmpbase
+
+import (
+	"github.com/pcekm/vasily/internal/backend"
+	"golang.org/x/sys/unix"
+)
+
+// Sets the size of a socket's receive buffer. Forcing is only on Linux.
+func setRecvBuffer(fd, n int, force bool) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, n)
+}
+
+// Gets a socket's statistics. Only Linux counts drops.
+func socketStats(fd int) (backend.SocketStats, error) {
+	n, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+	if err != nil {
+		return backend.SocketStats{}, err
+	}
+	return backend.SocketStats{RecvBuffer: n, Drops: -1}, nil
+}
@@ -0,0 +1,57 @@
+package icmpbase
+
+import (
+	"fmt"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// BindSource binds a socket to the interface and source address in src, and
+// sets its mark. An unset address binds to the wildcard address.
+func BindSource(fd int, ipVer util.IPVersion, src backend.SourceOption) error {
+	if src.Interface != "" {
+		if err := BindInterface(fd, ipVer, src.Interface); err != nil {
+			return fmt.Errorf("error binding to interface %q: %v", src.Interface, err)
+		}
+	}
+	if src.Mark != 0 {
+		if err := SetMark(fd, src.Mark); err != nil {
+			return fmt.Errorf("error setting fwmark %d: %v", src.Mark, err)
+		}
+	}
+	sa, err := sockaddr(ipVer, src)
+	if err != nil {
+		return err
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		return fmt.Errorf("error binding to %v: %v", src.Addr, err)
+	}
+	return nil
+}
+
+// Converts a source address to a sockaddr.
+func sockaddr(ipVer util.IPVersion, src backend.SourceOption) (unix.Sockaddr, error) {
+	switch ipVer {
+	case util.IPv4:
+		sa := &unix.SockaddrInet4{}
+		if src.Addr != nil {
+			ip := src.Addr.To4()
+			if ip == nil {
+				return nil, fmt.Errorf("source address %v is not IPv4", src.Addr)
+			}
+			copy(sa.Addr[:], ip)
+		}
+		return sa, nil
+	default:
+		sa := &unix.SockaddrInet6{}
+		if src.Addr != nil {
+			if src.Addr.To4() != nil {
+				return nil, fmt.Errorf("source address %v is not IPv6", src.Addr)
+			}
+			copy(sa.Addr[:], src.Addr.To16())
+		}
+		return sa, nil
+	}
+}
@@ -0,0 +1,19 @@
+package icmpbase
+
+import (
+	"net"
+
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// BindInterface restricts a socket to sending and receiving on the named
+// interface.
+func BindInterface(fd int, ipVer util.IPVersion, iface string) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	opt := util.Choose(ipVer, unix.IP_BOUND_IF, unix.IPV6_BOUND_IF)
+	return unix.SetsockoptInt(fd, ipVer.IPProtoNum(), opt, ifi.Index)
+}
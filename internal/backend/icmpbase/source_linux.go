@@ -0,0 +1,12 @@
+package icmpbase
+
+import (
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// BindInterface restricts a socket to sending and receiving on the named
+// interface.
+func BindInterface(fd int, ipVer util.IPVersion, iface string) error {
+	return unix.BindToDevice(fd, iface)
+}
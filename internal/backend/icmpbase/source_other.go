@@ -0,0 +1,16 @@
+//go:build !(linux || darwin)
+
+package icmpbase
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// BindInterface restricts a socket to sending and receiving on the named
+// interface. Not supported on this OS.
+func BindInterface(fd int, ipVer util.IPVersion, iface string) error {
+	return fmt.Errorf("binding to an interface is not supported on %s", runtime.GOOS)
+}
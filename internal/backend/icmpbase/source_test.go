@@ -0,0 +1,87 @@
+package icmpbase
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+func TestSockaddr(t *testing.T) {
+	cases := []struct {
+		Name    string
+		IPVer   util.IPVersion
+		Addr    string
+		WantErr bool
+	}{
+		{Name: "V4/Any", IPVer: util.IPv4},
+		{Name: "V4/Addr", IPVer: util.IPv4, Addr: "192.0.2.1"},
+		{Name: "V4/Mismatch", IPVer: util.IPv4, Addr: "2001:db8::1", WantErr: true},
+		{Name: "V6/Any", IPVer: util.IPv6},
+		{Name: "V6/Addr", IPVer: util.IPv6, Addr: "2001:db8::1"},
+		{Name: "V6/Mismatch", IPVer: util.IPv6, Addr: "192.0.2.1", WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			src := backend.SourceOption{Addr: net.ParseIP(c.Addr)}
+			if _, err := sockaddr(c.IPVer, src); (err != nil) != c.WantErr {
+				t.Errorf("sockaddr(%v, %v) unexpected error: %v", c.IPVer, c.Addr, err)
+			}
+		})
+	}
+}
+
+func TestPingWithSource(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	lo := map[string]string{"linux": "lo", "darwin": "lo0"}[runtime.GOOS]
+	src := backend.SourceOption{Interface: lo, Addr: test.LoopbackV4.IP}
+	conn, err := NewUnlimited(util.IPv4, 0, util.IPv4.ICMPProtoNum(), src)
+	if err != nil {
+		t.Fatalf("Error opening connection: %v", err)
+	}
+	defer conn.Close()
+
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: conn.EchoID(), Seq: 1, Data: []byte(payload)},
+	}
+	if err := conn.WriteTo(marshal(t, msg), test.LoopbackV4); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, peer, err := conn.ReadFrom(ctx); err != nil {
+		t.Errorf("ReadFrom error: %v", err)
+	} else if diff := test.DiffIP(test.LoopbackV4, peer); diff != "" {
+		t.Errorf("Wrong response peer (-want, +got):\n%v", diff)
+	}
+}
+
+func TestNewWithBadSource(t *testing.T) {
+	if !supportedOS[runtime.GOOS] && syscall.Getuid() != 0 {
+		t.Skipf("Unsupported OS")
+	}
+	src := backend.SourceOption{Addr: net.ParseIP("2001:db8::1")}
+	// Failures shouldn't count against the connection limit.
+	for range maxActiveConns + 1 {
+		if conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum(), src); err == nil {
+			conn.Close()
+			t.Fatalf("New() with mismatched source succeeded (want error)")
+		}
+	}
+	conn, err := New(util.IPv4, 0, util.IPv4.ICMPProtoNum())
+	if err != nil {
+		t.Fatalf("Error opening connection after failures: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,14 @@
+package icmpbase
+
+import "time"
+
+// RecvTime returns the time a packet was received, from the control messages
+// read along with it on a socket set up with [EnableRecvTimestamps]. Returns
+// the current time if the kernel didn't provide a timestamp, so it should be
+// called as soon as the read returns.
+func RecvTime(oob []byte) time.Time {
+	if t, ok := parseRecvTime(oob); ok {
+		return t
+	}
+	return time.Now()
+}
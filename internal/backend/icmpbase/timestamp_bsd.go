@@ -0,0 +1,40 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package icmpbase
+
+import (
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Control message space needed for a receive timestamp.
+var recvTimeSpace = unix.CmsgSpace(int(unsafe.Sizeof(unix.Timeval{})))
+
+// EnableRecvTimestamps asks the kernel to timestamp the packets a socket
+// receives, in microseconds. See [RecvTime].
+func EnableRecvTimestamps(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMP, 1)
+}
+
+// Gets the receive timestamp from control messages. Returns false if there
+// isn't one.
+func parseRecvTime(oob []byte) (time.Time, bool) {
+	scms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, scm := range scms {
+		if scm.Header.Level != unix.SOL_SOCKET || scm.Header.Type != unix.SCM_TIMESTAMP {
+			continue
+		}
+		var tv unix.Timeval
+		if _, err := binary.Decode(scm.Data, binary.NativeEndian, &tv); err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(tv.Unix()), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,38 @@
+package icmpbase
+
+import (
+	"encoding/binary"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Control message space needed for a receive timestamp.
+var recvTimeSpace = unix.CmsgSpace(int(unsafe.Sizeof(unix.Timespec{})))
+
+// EnableRecvTimestamps asks the kernel to timestamp the packets a socket
+// receives, in nanoseconds. See [RecvTime].
+func EnableRecvTimestamps(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+}
+
+// Gets the receive timestamp from control messages. Returns false if there
+// isn't one.
+func parseRecvTime(oob []byte) (time.Time, bool) {
+	scms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, scm := range scms {
+		if scm.Header.Level != unix.SOL_SOCKET || scm.Header.Type != unix.SCM_TIMESTAMPNS {
+			continue
+		}
+		var ts unix.Timespec
+		if _, err := binary.Decode(scm.Data, binary.NativeEndian, &ts); err != nil {
+			return time.Time{}, false
+		}
+		return time.Unix(ts.Unix()), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,52 @@
+package icmpbase
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/sys/unix"
+)
+
+// Makes a control message with a receive timestamp.
+func timestampCmsg(t time.Time) []byte {
+	ts := unix.NsecToTimespec(t.UnixNano())
+	data, _ := binary.Append(nil, binary.NativeEndian, ts)
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = unix.SCM_TIMESTAMPNS
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+func TestRecvTime(t *testing.T) {
+	want := time.Date(2025, 1, 2, 3, 4, 5, 6, time.UTC)
+	if got := RecvTime(timestampCmsg(want)); !got.Equal(want) {
+		t.Errorf("RecvTime() = %v (want %v)", got, want)
+	}
+}
+
+func TestRecvTime_Missing(t *testing.T) {
+	before := time.Now()
+	got := RecvTime(nil)
+	if got.Before(before) || got.After(time.Now()) {
+		t.Errorf("RecvTime(nil) = %v (want about now)", got)
+	}
+}
+
+func TestRecvTimestamps(t *testing.T) {
+	conn, err := newInternalConn(util.IPv4, backend.SourceOption{})
+	if err != nil {
+		t.Skipf("Can't open a connection: %v", err)
+	}
+	defer conn.Close()
+	v, err := unix.GetsockoptInt(conn.Fd(), unix.SOL_SOCKET, unix.SO_TIMESTAMPNS)
+	if err != nil || v == 0 {
+		t.Errorf("SO_TIMESTAMPNS = %d, %v (want set)", v, err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package icmpbase
+
+import (
+	"errors"
+	"time"
+)
+
+// Control message space needed for a receive timestamp.
+const recvTimeSpace = 0
+
+// EnableRecvTimestamps asks the kernel to timestamp the packets a socket
+// receives. Unsupported on this system.
+func EnableRecvTimestamps(fd int) error {
+	return errors.New("receive timestamps unsupported")
+}
+
+// Gets the receive timestamp from control messages. Always returns false.
+func parseRecvTime(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -33,7 +33,7 @@ func RegisterMock(conn backend.Conn) backend.Name {
 	defer mockMu.Unlock()
 	name := backend.Name(fmt.Sprintf("mock:%d", nextMockNum))
 	nextMockNum++
-	backend.Register(name, func(util.IPVersion) (backend.Conn, error) { return conn, nil })
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) { return conn, nil })
 	return name
 }
 
@@ -16,11 +16,16 @@ const (
 
 	ipv6FragmentType   = 44
 	ipv6FragmentExtLen = 8
-
-	// https://www.iana.org/assignments/service-names-port-numbers/service-names-port-numbers.xhtml?search=33434
-	defaultBasePort = 33434
 )
 
+// defaultPorts are the ports probes go to unless told otherwise: 33434 and up,
+// like traceroute's.
+//
+// https://www.iana.org/assignments/service-names-port-numbers/service-names-port-numbers.xhtml?search=33434
+var defaultPorts = backend.PortRange{Base: 33434, Stride: 1}
+
 func init() {
-	backend.Register("udp", func(ipVer util.IPVersion) (backend.Conn, error) { return New(ipVer) })
+	backend.Register("udp", func(ipVer util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+		return New(ipVer, opts...)
+	})
 }
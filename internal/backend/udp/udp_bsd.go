@@ -0,0 +1,48 @@
+//go:build freebsd || openbsd
+
+package udp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Resolves the source to bind the UDP socket and its ICMP companion to.
+// FreeBSD and OpenBSD have no way to bind a socket to an interface, so an
+// interface is replaced with its first usable address of the right version.
+// An address given along with the interface is used as is.
+func resolveSource(ipVer util.IPVersion, src backend.SourceOption) (backend.SourceOption, error) {
+	if src.Interface == "" {
+		return src, nil
+	}
+	iface := src.Interface
+	src.Interface = ""
+	if src.Addr != nil {
+		return src, nil
+	}
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return src, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return src, fmt.Errorf("error getting addresses of %q: %v", iface, err)
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != (ipVer == util.IPv4) {
+			continue
+		}
+		// A link-local source can't reach anything past the next hop, which
+		// defeats the purpose of a traceroute.
+		if ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		src.Addr = ipNet.IP
+		return src, nil
+	}
+	return src, fmt.Errorf("interface %q has no usable %v address", iface, ipVer)
+}
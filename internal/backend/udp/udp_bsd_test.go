@@ -0,0 +1,79 @@
+//go:build freebsd || openbsd
+
+package udp
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// The loopback interface. Both systems call it lo0.
+const loopback = "lo0"
+
+func TestResolveSource(t *testing.T) {
+	cases := []struct {
+		IPVer util.IPVersion
+		Src   backend.SourceOption
+		Want  net.IP
+	}{
+		{IPVer: util.IPv4, Src: backend.SourceOption{}, Want: nil},
+		{IPVer: util.IPv4, Src: backend.SourceOption{Interface: loopback}, Want: test.LoopbackV4.IP},
+		{IPVer: util.IPv6, Src: backend.SourceOption{Interface: loopback}, Want: test.LoopbackV6.IP},
+		{IPVer: util.IPv4, Src: backend.SourceOption{Interface: loopback, Addr: net.IPv4(127, 0, 0, 2)}, Want: net.IPv4(127, 0, 0, 2)},
+	}
+	for _, c := range cases {
+		got, err := resolveSource(c.IPVer, c.Src)
+		if err != nil {
+			t.Errorf("resolveSource(%v, %+v) error: %v", c.IPVer, c.Src, err)
+			continue
+		}
+		if got.Interface != "" || !got.Addr.Equal(c.Want) {
+			t.Errorf("resolveSource(%v, %+v) = %+v (want Addr %v)", c.IPVer, c.Src, got, c.Want)
+		}
+	}
+
+	if _, err := resolveSource(util.IPv4, backend.SourceOption{Interface: "nonexistent0"}); err == nil {
+		t.Errorf("resolveSource succeeded with a nonexistent interface (want error)")
+	}
+}
+
+// Sends through the loopback interface. The ICMP companion is a raw socket
+// here, so this needs root. (CI doesn't run on the BSDs; run it by hand.)
+func TestInterfaceSource(t *testing.T) {
+	if syscall.Getuid() != 0 {
+		t.Skip("Needs root")
+	}
+	for _, ipVer := range []util.IPVersion{util.IPv4, util.IPv6} {
+		t.Run(ipVer.String(), func(t *testing.T) {
+			conn, err := New(ipVer, backend.SourceOption{Interface: loopback})
+			if err != nil {
+				t.Fatalf("Error opening conn: %v", err)
+			}
+			defer conn.Close()
+
+			dest := util.Choose(ipVer, test.LoopbackV4, test.LoopbackV6)
+			if err := conn.WriteTo(&backend.Packet{Seq: 1}, dest); err != nil {
+				t.Fatalf("WriteTo: %v", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			pkt, peer, err := conn.ReadFrom(ctx)
+			if err != nil {
+				t.Fatalf("ReadFrom: %v", err)
+			}
+			if pkt.Type != backend.PacketReply || pkt.Seq != 1 {
+				t.Errorf("Got %v seq %d (want %v seq 1)", pkt.Type, pkt.Seq, backend.PacketReply)
+			}
+			if diff := test.DiffIP(dest, peer); diff != "" {
+				t.Errorf("Wrong peer (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
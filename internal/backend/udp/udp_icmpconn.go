@@ -6,14 +6,18 @@ package udp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"slices"
 	"sync"
 	"syscall"
 
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/backend/icmpbase"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/netns"
 	"github.com/pcekm/vasily/internal/util"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
@@ -24,24 +28,45 @@ type Conn struct {
 	ipVer    util.IPVersion
 	icmpConn *icmpbase.Conn
 
-	mu       sync.Mutex
-	connV4   *ipv4.PacketConn
-	connV6   *ipv6.PacketConn
-	basePort int
+	mu     sync.Mutex
+	connV4 *ipv4.PacketConn
+	connV6 *ipv6.PacketConn
+	ports  backend.PortRange
 }
 
-// New opens a new connection.
-func New(ipVer util.IPVersion) (*Conn, error) {
+// New opens a new connection. The only supported option is
+// [backend.SourceOption].
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*Conn, error) {
+	if backend.GetFlowLabel(opts) != 0 {
+		return nil, errors.New("flow labels require the icmp backend")
+	}
+	src, err := resolveSource(ipVer, backend.GetSource(opts))
+	if err != nil {
+		return nil, err
+	}
 	c := &Conn{
-		ipVer:    ipVer,
-		basePort: defaultBasePort,
+		ipVer: ipVer,
+		ports: defaultPorts,
 	}
 
 	address := util.Choose(ipVer, "udp4", "udp6")
-	conn, err := net.ListenUDP(address, nil)
+	// The socket stays in the namespace it was opened in, and the interface
+	// and address are looked up there.
+	var conn *net.UDPConn
+	err = netns.Run(src.Netns, func() error {
+		var err error
+		conn, err = net.ListenUDP(address, &net.UDPAddr{IP: src.Addr})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	if src.Interface != "" || src.Mark != 0 {
+		if err := bindSource(conn, ipVer, src); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
 	switch ipVer {
 	case util.IPv4:
 		c.connV4 = ipv4.NewPacketConn(conn)
@@ -51,7 +76,9 @@ func New(ipVer util.IPVersion) (*Conn, error) {
 		log.Panicf("Unknown IP version: %v", ipVer)
 	}
 
-	c.icmpConn, err = icmpbase.New(ipVer, util.Port(conn.LocalAddr()), syscall.IPPROTO_UDP)
+	// The resolved source replaces the original for the ICMP socket too.
+	opts = append(slices.Clip(opts), src)
+	c.icmpConn, err = icmpbase.New(ipVer, util.Port(conn.LocalAddr()), syscall.IPPROTO_UDP, opts...)
 	if err != nil {
 		conn.Close()
 		return nil, err
@@ -60,18 +87,18 @@ func New(ipVer util.IPVersion) (*Conn, error) {
 	return c, nil
 }
 
-// SeqBasePort returns the base port number that sequence numbers are added to.
-func (c *Conn) SeqBasePort() int {
+// Ports returns the mapping from sequence numbers to ports.
+func (c *Conn) Ports() backend.PortRange {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.basePort
+	return c.ports
 }
 
-// SetSeqBasePort sets the base port number to add to sequence numbers.
-func (c *Conn) SetSeqBasePort(p int) {
+// SetPorts sets the mapping from sequence numbers to ports.
+func (c *Conn) SetPorts(r backend.PortRange) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.basePort = p
+	c.ports = r
 }
 
 // WriteTo sends a request.
@@ -87,7 +114,7 @@ func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.Write
 			}
 			defer func() {
 				if err := c.setTTL(orig); err != nil {
-					log.Printf("Error setting original TTL: %v", err)
+					logging.Warnf("Error setting original TTL: %v", err)
 				}
 			}()
 			c.setTTL(o.TTL)
@@ -95,7 +122,7 @@ func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.Write
 	}
 
 	addr := *(dest.(*net.UDPAddr))
-	addr.Port = c.basePort + pkt.Seq
+	addr.Port = c.ports.Port(pkt.Seq)
 
 	switch c.ipVer {
 	case util.IPv4:
@@ -138,7 +165,9 @@ func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error)
 	if err != nil {
 		return nil, nil, err
 	}
-	pkt.Seq -= c.SeqBasePort()
+	// The ICMP connection reports the quoted destination port as the sequence
+	// number.
+	pkt.Seq = c.Ports().Seq(pkt.Seq)
 	return pkt, peer, err
 }
 
@@ -153,3 +182,29 @@ func (c *Conn) Close() error {
 		return nil
 	}
 }
+
+// Binds a UDP connection to the interface in src, and sets its mark. The
+// address was already bound when it was opened.
+func bindSource(conn *net.UDPConn, ipVer util.IPVersion, src backend.SourceOption) error {
+	rawconn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rcErr := rawconn.Control(func(fd uintptr) {
+		if src.Interface != "" {
+			if err = icmpbase.BindInterface(int(fd), ipVer, src.Interface); err != nil {
+				err = fmt.Errorf("error binding to interface %q: %v", src.Interface, err)
+				return
+			}
+		}
+		if src.Mark != 0 {
+			if err = icmpbase.SetMark(int(fd), src.Mark); err != nil {
+				err = fmt.Errorf("error setting fwmark %d: %v", src.Mark, err)
+			}
+		}
+	})
+	if rcErr != nil {
+		return rcErr
+	}
+	return err
+}
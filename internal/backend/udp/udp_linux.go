@@ -6,12 +6,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/icmpbase"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/netns"
 	"github.com/pcekm/vasily/internal/util"
 	"github.com/pcekm/vasily/internal/util/icmppkt"
 	"golang.org/x/sys/unix"
@@ -21,31 +23,64 @@ import (
 type Conn struct {
 	ipVer util.IPVersion
 
-	mu       sync.Mutex
-	basePort int
+	mu    sync.Mutex
+	ports backend.PortRange
 
 	readMu  sync.Mutex
 	writeMu sync.Mutex
 	conn    *net.UDPConn
 }
 
-// New opens a new connection.
-func New(ipVer util.IPVersion) (*Conn, error) {
+// New opens a new connection. The only supported option is
+// [backend.SourceOption].
+func New(ipVer util.IPVersion, opts ...backend.ConnOption) (*Conn, error) {
+	src := backend.GetSource(opts)
+	if backend.GetFlowLabel(opts) != 0 {
+		return nil, errors.New("flow labels require the icmp backend")
+	}
 	address := util.Choose(ipVer, "udp4", "udp6")
-	conn, err := net.ListenUDP(address, nil)
+	// The socket stays in the namespace it was opened in, and the interface
+	// and address are looked up there.
+	var conn *net.UDPConn
+	err := netns.Run(src.Netns, func() error {
+		var err error
+		conn, err = net.ListenUDP(address, &net.UDPAddr{IP: src.Addr})
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 	c := &Conn{
-		ipVer:    ipVer,
-		basePort: defaultBasePort,
-		conn:     conn,
+		ipVer: ipVer,
+		ports: defaultPorts,
+		conn:  conn,
 	}
 	reOpt := util.Choose(ipVer, unix.IP_RECVERR, unix.IPV6_RECVERR)
+	ttlOpt := util.Choose(ipVer, unix.IP_RECVTTL, unix.IPV6_RECVHOPLIMIT)
 	err = c.control(func(fd int) error {
+		if src.Interface != "" {
+			if err := icmpbase.BindInterface(fd, ipVer, src.Interface); err != nil {
+				return fmt.Errorf("error binding to interface %q: %v", src.Interface, err)
+			}
+		}
+		if src.Mark != 0 {
+			if err := icmpbase.SetMark(fd, src.Mark); err != nil {
+				return fmt.Errorf("error setting fwmark %d: %v", src.Mark, err)
+			}
+		}
+		if err := unix.SetsockoptInt(int(fd), ipVer.IPProtoNum(), ttlOpt, 1); err != nil {
+			return err
+		}
+		if err := icmpbase.EnableRecvTimestamps(fd); err != nil {
+			return err
+		}
 		return unix.SetsockoptInt(int(fd), ipVer.IPProtoNum(), reOpt, 1)
 	})
-	return c, err
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
 }
 
 // Close closes the connection.
@@ -53,24 +88,18 @@ func (c *Conn) Close() error {
 	return c.conn.Close()
 }
 
-// SeqBasePort returns the base port number that sequence numbers are added to.
-func (c *Conn) SeqBasePort() int {
+// Ports returns the mapping from sequence numbers to ports.
+func (c *Conn) Ports() backend.PortRange {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.basePort
+	return c.ports
 }
 
-// SetSeqBasePort sets the base port number to add to sequence numbers.
-func (c *Conn) SetSeqBasePort(p int) {
+// SetPorts sets the mapping from sequence numbers to ports.
+func (c *Conn) SetPorts(r backend.PortRange) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.basePort = p
-}
-
-func (c *Conn) getBasePort() int {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.basePort
+	c.ports = r
 }
 
 // Wrapper around RawConn.Control() to make things easier.
@@ -117,7 +146,7 @@ func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.Write
 			}
 			defer func() {
 				if err := c.setTTL(orig); err != nil {
-					log.Printf("Error setting original TTL: %v", err)
+					logging.Warnf("Error setting original TTL: %v", err)
 				}
 			}()
 			c.setTTL(o.TTL)
@@ -125,7 +154,7 @@ func (c *Conn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.Write
 	}
 
 	addr := *(dest.(*net.UDPAddr))
-	addr.Port = c.getBasePort() + pkt.Seq
+	addr.Port = c.Ports().Port(pkt.Seq)
 	sa := unix.SockaddrInet4{
 		Port: addr.Port,
 	}
@@ -175,15 +204,18 @@ func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error)
 	}
 
 	buf := make([]byte, maxMTU)
-	n, peer, err := c.conn.ReadFrom(buf)
+	oob := icmppkt.OOBBytes(c.ipVer)
+	n, oobn, _, from, err := c.conn.ReadMsgUDP(buf, oob)
 	if err == nil {
 		// Apparently the remote host is listening on the given port and has
 		// sent a response. That's unexpected. Deal with it as best as possible.
 		return &backend.Packet{
-			Type:    backend.PacketReply,
-			Seq:     util.Port(peer) - c.getBasePort(),
-			Payload: buf[:n],
-		}, peer, nil
+			Type:     backend.PacketReply,
+			Seq:      c.Ports().Seq(util.Port(from)),
+			Payload:  buf[:n],
+			TTL:      icmppkt.ParseTTL(oob[:oobn]),
+			RecvTime: icmpbase.RecvTime(oob[:oobn]),
+		}, from, nil
 	}
 	var opErr *net.OpError
 	if !errors.As(err, &opErr) {
@@ -193,26 +225,30 @@ func (c *Conn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error)
 		return nil, nil, backend.ErrTimeout
 	}
 
-	oob := icmppkt.OOBBytes(c.ipVer)
-	var oobn int
 	var origDest unix.Sockaddr
 	err = c.read(func(fd int) error {
 		n, oobn, _, origDest, err = unix.Recvmsg(fd, buf, oob, unix.MSG_ERRQUEUE)
 		return err
 	})
+	recvTime := icmpbase.RecvTime(oob[:oobn])
 
-	pktType, peer, err := icmppkt.ParseLinuxEE(oob[:oobn])
+	pktType, peer, ttl, err := icmppkt.ParseLinuxEE(oob[:oobn])
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var seq int
+	var port int
 	switch sa := origDest.(type) {
 	case *unix.SockaddrInet4:
-		seq = sa.Port
+		port = sa.Port
 	case *unix.SockaddrInet6:
-		seq = sa.Port
+		port = sa.Port
 	}
 
-	return &backend.Packet{Type: pktType, Seq: seq - c.getBasePort()}, peer, nil
+	pkt := &backend.Packet{Type: pktType, Seq: c.Ports().Seq(port), TTL: ttl, RecvTime: recvTime}
+	if n > 0 {
+		// As much of the original payload as the ICMP message quoted.
+		pkt.Payload = buf[:n]
+	}
+	return pkt, peer, nil
 }
@@ -0,0 +1,14 @@
+//go:build (rawsock || !linux) && !(freebsd || openbsd)
+
+package udp
+
+import (
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Resolves the source to bind the UDP socket and its ICMP companion to. The
+// sockets can be bound to an interface here, so it's used as is.
+func resolveSource(ipVer util.IPVersion, src backend.SourceOption) (backend.SourceOption, error) {
+	return src, nil
+}
@@ -92,6 +92,16 @@ func TestWriteTo(t *testing.T) {
 
 				wantPkt := *pkt
 				wantPkt.Type = c.WantType
+				if got != nil {
+					if got.TTL <= 0 {
+						t.Errorf("Reply TTL = %d (want > 0)", got.TTL)
+					}
+					wantPkt.TTL = got.TTL
+					if got.RecvTime.IsZero() {
+						t.Errorf("Reply has no receive time")
+					}
+					wantPkt.RecvTime = got.RecvTime
+				}
 				if diff := cmp.Diff(&wantPkt, got); diff != "" {
 					t.Errorf("Wrong reply (-want, +got):\n%v", diff)
 					if got != nil && len(got.Payload) > 0 {
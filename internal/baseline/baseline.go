@@ -0,0 +1,183 @@
+// Package baseline saves the latency of each target in a run under a name, so
+// that later runs can be compared against it and regressions highlighted.
+//
+// Baselines are stored as JSON files in a directory, one per name.
+package baseline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+const (
+	// DefaultThreshold is the default fraction latency must rise by before
+	// it's a regression.
+	DefaultThreshold = 0.2
+
+	// Smaller increases are never regressions, however large they are in
+	// relative terms. This keeps sub-millisecond LAN latencies from being
+	// flagged for ordinary noise.
+	minRegression = time.Millisecond
+)
+
+// Entry is the baseline for a single target.
+type Entry struct {
+	// Group and Index identify the target, as in [targets.Key].
+	Group string `json:"group"`
+	Index int    `json:"index,omitempty"`
+
+	// Addr is the target's address when the baseline was saved.
+	Addr string `json:"addr,omitempty"`
+
+	// Avg and P95 are the average and 95th percentile latencies.
+	Avg time.Duration `json:"avg_ns"`
+	P95 time.Duration `json:"p95_ns"`
+}
+
+// Key returns the key of the target the entry is for.
+func (e Entry) Key() targets.Key {
+	return targets.Key{Group: e.Group, Index: e.Index}
+}
+
+// Baseline is a named set of target latencies.
+type Baseline struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Entries []Entry   `json:"entries"`
+}
+
+// FromTargets makes a baseline from the current stats of each target. Targets
+// without any successful pings are left out.
+func FromTargets(name string, ts []targets.Target, now time.Time) *Baseline {
+	b := &Baseline{Name: name, Created: now}
+	for _, t := range ts {
+		st := t.Pinger.Stats()
+		if st.N == st.Failures {
+			continue
+		}
+		e := Entry{Group: t.Group, Index: t.Index, Avg: st.AvgLatency, P95: st.P95}
+		if ip := util.IP(t.Addr); ip != nil {
+			e.Addr = ip.String()
+		}
+		b.Entries = append(b.Entries, e)
+	}
+	return b
+}
+
+// Lookup returns the entry for a target, or nil if there isn't one. Safe to
+// call on a nil baseline.
+func (b *Baseline) Lookup(k targets.Key) *Entry {
+	if b == nil {
+		return nil
+	}
+	i := slices.IndexFunc(b.Entries, func(e Entry) bool { return e.Key() == k })
+	if i < 0 {
+		return nil
+	}
+	return &b.Entries[i]
+}
+
+// Comparison is the difference between a target's current latency and its
+// baseline.
+type Comparison struct {
+	// AvgDelta and P95Delta are the current latencies minus the baseline
+	// ones. Positive is slower.
+	AvgDelta, P95Delta time.Duration
+
+	// Regressed is true if either latency rose by more than the threshold.
+	Regressed bool
+}
+
+// Compare compares a target's current stats against its baseline. The
+// threshold is the fraction either latency must rise by to count as a
+// regression. Returns false if there are no successful pings to compare yet.
+func Compare(base *Entry, st pinger.Stats, threshold float64) (Comparison, bool) {
+	if base == nil || st.N == st.Failures {
+		return Comparison{}, false
+	}
+	c := Comparison{
+		AvgDelta: st.AvgLatency - base.Avg,
+		P95Delta: st.P95 - base.P95,
+	}
+	c.Regressed = regressed(c.AvgDelta, base.Avg, threshold) || regressed(c.P95Delta, base.P95, threshold)
+	return c, true
+}
+
+// Reports whether a change from a baseline latency is a regression.
+func regressed(delta, base time.Duration, threshold float64) bool {
+	return delta > minRegression && float64(delta) > threshold*float64(base)
+}
+
+// DefaultDir returns the directory baselines are kept in by default:
+// vasily/baselines in $XDG_DATA_HOME or ~/.local/share.
+func DefaultDir() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dir, "vasily", "baselines"), nil
+}
+
+// Returns the path of the file for a named baseline.
+func path(dir, name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("bad baseline name %q", name)
+	}
+	return filepath.Join(dir, name+".json"), nil
+}
+
+// Save writes a baseline to dir, replacing any with the same name.
+func Save(dir string, b *Baseline) error {
+	p, err := path(dir, b.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Written to a temporary file first so an interrupted save doesn't
+	// clobber the old baseline.
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Load reads a named baseline from dir.
+func Load(dir, name string) (*Baseline, error) {
+	p, err := path(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no baseline named %q in %v", name, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := &Baseline{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("error parsing baseline %v: %v", p, err)
+	}
+	return b, nil
+}
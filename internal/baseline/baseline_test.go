@@ -0,0 +1,149 @@
+package baseline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+func TestFromTargets(t *testing.T) {
+	ok := pinger.NewReplay(&pinger.Options{})
+	for i, lat := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond} {
+		ok.Replay(i, pinger.PingResult{Type: pinger.Success, Time: time.Unix(int64(1000+i), 0), Latency: lat})
+	}
+	lost := pinger.NewReplay(&pinger.Options{})
+	lost.Replay(0, pinger.PingResult{Type: pinger.Dropped, Time: time.Unix(1000, 0)})
+	ts := []targets.Target{
+		{Key: targets.Key{Group: "a.example"}, Addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, Pinger: ok},
+		{Key: targets.Key{Group: "b.example", Index: 2}, Pinger: lost},
+	}
+	now := time.Unix(2000, 0)
+
+	got := FromTargets("home", ts, now)
+	st := ok.Stats()
+	want := &Baseline{
+		Name:    "home",
+		Created: now,
+		Entries: []Entry{{Group: "a.example", Addr: "192.0.2.1", Avg: 20 * time.Millisecond, P95: st.P95}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong baseline (-want, +got):\n%v", diff)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	base := &Entry{Avg: 20 * time.Millisecond, P95: 40 * time.Millisecond}
+	stats := func(avg, p95 time.Duration) pinger.Stats {
+		return pinger.Stats{N: 10, AvgLatency: avg, P95: p95}
+	}
+	cases := []struct {
+		Name  string
+		Base  *Entry
+		Stats pinger.Stats
+		Want  Comparison
+		OK    bool
+	}{
+		{
+			Name:  "Same",
+			Base:  base,
+			Stats: stats(20*time.Millisecond, 40*time.Millisecond),
+			OK:    true,
+		},
+		{
+			Name:  "Faster",
+			Base:  base,
+			Stats: stats(10*time.Millisecond, 20*time.Millisecond),
+			Want:  Comparison{AvgDelta: -10 * time.Millisecond, P95Delta: -20 * time.Millisecond},
+			OK:    true,
+		},
+		{
+			Name:  "UnderThreshold",
+			Base:  base,
+			Stats: stats(23*time.Millisecond, 47*time.Millisecond),
+			Want:  Comparison{AvgDelta: 3 * time.Millisecond, P95Delta: 7 * time.Millisecond},
+			OK:    true,
+		},
+		{
+			Name:  "AvgRegressed",
+			Base:  base,
+			Stats: stats(25*time.Millisecond, 40*time.Millisecond),
+			Want:  Comparison{AvgDelta: 5 * time.Millisecond, Regressed: true},
+			OK:    true,
+		},
+		{
+			Name:  "P95Regressed",
+			Base:  base,
+			Stats: stats(20*time.Millisecond, 50*time.Millisecond),
+			Want:  Comparison{P95Delta: 10 * time.Millisecond, Regressed: true},
+			OK:    true,
+		},
+		{
+			Name:  "TinyLatencies",
+			Base:  &Entry{Avg: 200 * time.Microsecond, P95: 300 * time.Microsecond},
+			Stats: stats(time.Millisecond, time.Millisecond),
+			Want:  Comparison{AvgDelta: 800 * time.Microsecond, P95Delta: 700 * time.Microsecond},
+			OK:    true,
+		},
+		{
+			Name:  "NoBaseline",
+			Stats: stats(20*time.Millisecond, 40*time.Millisecond),
+		},
+		{
+			Name:  "NoReplies",
+			Base:  base,
+			Stats: pinger.Stats{N: 3, Failures: 3},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, ok := Compare(c.Base, c.Stats, DefaultThreshold)
+			if ok != c.OK {
+				t.Errorf("Compare ok = %v (want %v)", ok, c.OK)
+			}
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong comparison (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	b := &Baseline{
+		Name:    "office",
+		Created: time.Unix(2000, 0).UTC(),
+		Entries: []Entry{
+			{Group: "a.example", Addr: "192.0.2.1", Avg: 20 * time.Millisecond, P95: 30 * time.Millisecond},
+			{Group: "b.example", Index: 3, Addr: "2001:db8::1", Avg: 5 * time.Millisecond, P95: 9 * time.Millisecond},
+		},
+	}
+	if err := Save(dir, b); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	got, err := Load(dir, "office")
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if diff := cmp.Diff(b, got); diff != "" {
+		t.Errorf("Wrong baseline (-want, +got):\n%v", diff)
+	}
+	if e := got.Lookup(targets.Key{Group: "b.example", Index: 3}); e == nil || e.Addr != "2001:db8::1" {
+		t.Errorf("Lookup(b.example, 3) = %v (want entry for 2001:db8::1)", e)
+	}
+	if e := got.Lookup(targets.Key{Group: "c.example"}); e != nil {
+		t.Errorf("Lookup(c.example) = %v (want nil)", e)
+	}
+
+	if _, err := Load(dir, "missing"); err == nil {
+		t.Errorf("Load(missing) succeeded (want error)")
+	}
+	for _, name := range []string{"", "../x", ".hidden", `a\b`} {
+		if err := Save(dir, &Baseline{Name: name}); err == nil {
+			t.Errorf("Save with name %q succeeded (want error)", name)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+// Package capture writes the packets that ping connections send and receive
+// to a pcap file, so that a session can be inspected in Wireshark or tcpdump.
+//
+// Packets aren't captured from the wire. They're reconstructed from what the
+// backends report, so every probe appears as the ICMP message it stands for,
+// whatever protocol actually carried it. Addresses, TTLs, sequence numbers,
+// payloads and extensions match what was sent and received. Error codes,
+// which backends don't report, are zero, and the local address is unspecified
+// unless connections are bound to one.
+package capture
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// Magic number for a pcap file with nanosecond timestamps.
+	pcapMagic = 0xa1b23c4d
+
+	// LINKTYPE_RAW: each packet starts with its IPv4 or IPv6 header.
+	linkTypeRaw = 101
+
+	snapLen = 65535
+
+	// TTL for sent packets that don't set one.
+	defaultTTL = 64
+
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+
+	// Maximum number of sequence numbers a connection remembers the
+	// destination of.
+	maxDests = 4096
+)
+
+// Writer writes packets to a pcap file. It's safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	nextID int
+	failed bool
+
+	// For testing.
+	now func() time.Time
+}
+
+// NewWriter creates a writer that writes to w, starting with the pcap file
+// header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2) // Major version
+	binary.LittleEndian.PutUint16(hdr[6:], 4) // Minor version
+	binary.LittleEndian.PutUint32(hdr[16:], snapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("error writing pcap header: %v", err)
+	}
+	return &Writer{w: w, now: time.Now}, nil
+}
+
+// Wrap returns a connection that writes the packets conn sends and receives.
+// It has the signature of [backend.WrapFunc], so that it can be passed to
+// [backend.UseWrapper].
+func (w *Writer) Wrap(conn backend.Conn, ipVer util.IPVersion, opts ...backend.ConnOption) backend.Conn {
+	w.mu.Lock()
+	// Echo IDs aren't reported by backends. One per connection keeps replies
+	// matched to the right requests.
+	id := w.nextID
+	w.nextID = (w.nextID + 1) & 0xffff
+	w.mu.Unlock()
+
+	local := backend.GetSource(opts).Addr
+	if local == nil {
+		local = util.Choose(ipVer, net.IPv4zero, net.IPv6unspecified)
+	}
+	c := &captureConn{
+		Conn:  conn,
+		w:     w,
+		ipVer: ipVer,
+		local: local,
+		id:    id,
+		dests: make(map[int]net.IP),
+	}
+	if _, ok := conn.(backend.PortConn); ok {
+		return &capturePortConn{c}
+	}
+	return c
+}
+
+// Writes a single packet record.
+func (w *Writer) writePacket(data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed {
+		return
+	}
+	now := w.now()
+	hdr := make([]byte, 16, 16+len(data))
+	binary.LittleEndian.PutUint32(hdr[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(now.Nanosecond()))
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(data)))
+	if _, err := w.w.Write(append(hdr, data...)); err != nil {
+		// Only complain once. The rest are sure to fail the same way.
+		log.Printf("Error writing capture; capture stopped: %v", err)
+		w.failed = true
+	}
+}
+
+// A connection whose packets are written to a capture.
+type captureConn struct {
+	backend.Conn
+	w     *Writer
+	ipVer util.IPVersion
+	local net.IP
+	id    int
+
+	// The destination of each sequence number sent, so that errors can
+	// quote the original packet.
+	mu    sync.Mutex
+	dests map[int]net.IP
+}
+
+func (c *captureConn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	if err := c.Conn.WriteTo(pkt, dest, opts...); err != nil {
+		return err
+	}
+	ttl := defaultTTL
+	for _, o := range opts {
+		if o, ok := o.(backend.TTLOption); ok {
+			ttl = o.TTL
+		}
+	}
+	c.sent(pkt, dest, ttl)
+	return nil
+}
+
+// WriteBatch implements [backend.BatchConn].
+func (c *captureConn) WriteBatch(pkts []*backend.Packet, dest net.Addr) (int, error) {
+	n, err := backend.WriteBatch(c.Conn, pkts, dest)
+	for _, pkt := range pkts[:n] {
+		c.sent(pkt, dest, defaultTTL)
+	}
+	return n, err
+}
+
+// SocketStats implements [backend.StatsConn].
+func (c *captureConn) SocketStats() (backend.SocketStats, error) {
+	return backend.GetSocketStats(c.Conn)
+}
+
+func (c *captureConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	pkt, peer, err := c.Conn.ReadFrom(ctx)
+	if err == nil && pkt != nil {
+		c.received(pkt, peer)
+	}
+	return pkt, peer, err
+}
+
+// Writes a packet that was sent.
+func (c *captureConn) sent(pkt *backend.Packet, dest net.Addr, ttl int) {
+	dst := c.addrIP(dest)
+	c.mu.Lock()
+	if len(c.dests) >= maxDests {
+		clear(c.dests)
+	}
+	c.dests[pkt.Seq] = dst
+	c.mu.Unlock()
+	c.write(pkt, c.local, dst, ttl)
+}
+
+// Writes a packet that was received.
+func (c *captureConn) received(pkt *backend.Packet, peer net.Addr) {
+	ttl := pkt.TTL
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	c.write(pkt, c.addrIP(peer), c.local, ttl)
+}
+
+func (c *captureConn) write(pkt *backend.Packet, src, dst net.IP, ttl int) {
+	data, err := c.encode(pkt, src, dst, ttl)
+	if err != nil {
+		log.Printf("Error encoding %v for capture: %v", pkt.Type, err)
+		return
+	}
+	c.w.writePacket(data)
+}
+
+// Returns the IP of an address, or the unspecified address if there isn't
+// one.
+func (c *captureConn) addrIP(addr net.Addr) net.IP {
+	if ip := util.IP(addr); ip != nil {
+		return ip
+	}
+	return util.Choose(c.ipVer, net.IPv4zero, net.IPv6unspecified)
+}
+
+// Encodes a packet as an IP datagram.
+func (c *captureConn) encode(pkt *backend.Packet, src, dst net.IP, ttl int) ([]byte, error) {
+	msg, err := c.message(pkt, dst)
+	if err != nil {
+		return nil, err
+	}
+	var psh []byte
+	if c.ipVer == util.IPv6 {
+		psh = icmp.IPv6PseudoHeader(src, dst)
+	}
+	body, err := msg.Marshal(psh)
+	if err != nil {
+		return nil, err
+	}
+	return c.ipDatagram(src, dst, ttl, body), nil
+}
+
+// Returns the ICMP message standing for a packet sent to dst.
+func (c *captureConn) message(pkt *backend.Packet, dst net.IP) (*icmp.Message, error) {
+	v4 := c.ipVer == util.IPv4
+	echo := &icmp.Echo{ID: c.id, Seq: pkt.Seq, Data: pkt.Payload}
+	switch pkt.Type {
+	case backend.PacketRequest:
+		return &icmp.Message{Type: util.Choose[icmp.Type](c.ipVer, ipv4.ICMPTypeEcho, ipv6.ICMPTypeEchoRequest), Body: echo}, nil
+	case backend.PacketReply:
+		return &icmp.Message{Type: util.Choose[icmp.Type](c.ipVer, ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply), Body: echo}, nil
+	case backend.PacketTimestampRequest, backend.PacketTimestampReply:
+		if !v4 {
+			break
+		}
+		tp := ipv4.ICMPTypeTimestamp
+		if pkt.Type == backend.PacketTimestampReply {
+			tp = ipv4.ICMPTypeTimestampReply
+		}
+		return &icmp.Message{Type: tp, Body: &icmppkt.Timestamp{ID: c.id, Seq: pkt.Seq, Timestamps: pkt.Timestamps}}, nil
+	case backend.PacketAddressMaskRequest, backend.PacketAddressMaskReply:
+		if !v4 {
+			break
+		}
+		tp := icmppkt.ICMPTypeAddressMask
+		if pkt.Type == backend.PacketAddressMaskReply {
+			tp = icmppkt.ICMPTypeAddressMaskReply
+		}
+		return &icmp.Message{Type: tp, Body: &icmppkt.AddressMask{ID: c.id, Seq: pkt.Seq, Mask: pkt.AddressMask}}, nil
+	case backend.PacketTimeExceeded:
+		return &icmp.Message{
+			Type: util.Choose[icmp.Type](c.ipVer, ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded),
+			Body: &icmp.TimeExceeded{Data: c.quote(pkt, dst), Extensions: c.extensions(pkt.Extensions)},
+		}, nil
+	case backend.PacketDestinationUnreachable:
+		return &icmp.Message{
+			Type: util.Choose[icmp.Type](c.ipVer, ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable),
+			Body: &icmp.DstUnreach{Data: c.quote(pkt, dst), Extensions: c.extensions(pkt.Extensions)},
+		}, nil
+	case backend.PacketTooBig:
+		if v4 {
+			const codeFragNeeded = 4
+			return &icmp.Message{
+				Type: ipv4.ICMPTypeDestinationUnreachable,
+				Code: codeFragNeeded,
+				Body: &icmp.DstUnreach{Data: c.quote(pkt, dst)},
+			}, nil
+		}
+		return &icmp.Message{Type: ipv6.ICMPTypePacketTooBig, Body: &icmp.PacketTooBig{Data: c.quote(pkt, dst)}}, nil
+	}
+	return nil, fmt.Errorf("no ICMPv%v equivalent", c.ipVer)
+}
+
+// Returns the original request an error refers to, as a datagram from the
+// error's recipient to wherever that sequence number was last sent.
+func (c *captureConn) quote(pkt *backend.Packet, local net.IP) []byte {
+	c.mu.Lock()
+	dst := c.dests[pkt.Seq]
+	c.mu.Unlock()
+	if dst == nil {
+		dst = util.Choose(c.ipVer, net.IPv4zero, net.IPv6unspecified)
+	}
+	req := &backend.Packet{Type: backend.PacketRequest, Seq: pkt.Seq, Payload: pkt.Payload}
+	data, err := c.encode(req, local, dst, 1)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// Converts extensions back into ICMP extension objects.
+func (c *captureConn) extensions(ext *backend.Extensions) []icmp.Extension {
+	if ext == nil {
+		return nil
+	}
+	var res []icmp.Extension
+	if len(ext.MPLSLabels) > 0 {
+		st := &icmp.MPLSLabelStack{Class: 1, Type: 1}
+		for _, l := range ext.MPLSLabels {
+			st.Labels = append(st.Labels, icmp.MPLSLabel(l))
+		}
+		res = append(res, st)
+	}
+	for _, info := range ext.Interfaces {
+		// The type holds the role and flags for the fields present, which
+		// must match what x/net decides to marshal.
+		const (
+			hasIndex = 1 << (3 - iota)
+			hasAddr
+			hasName
+			hasMTU
+		)
+		ifi := &icmp.InterfaceInfo{Class: 2, Type: int(info.Role) << 6}
+		if info.Index > 0 {
+			ifi.Interface = &net.Interface{Index: info.Index, Name: info.Name, MTU: info.MTU}
+			ifi.Type |= hasIndex
+			if info.Name != "" {
+				ifi.Type |= hasName
+			}
+			if info.MTU > 0 {
+				ifi.Type |= hasMTU
+			}
+		}
+		if info.Addr != nil && (info.Addr.To4() != nil) == (c.ipVer == util.IPv4) {
+			ifi.Addr = &net.IPAddr{IP: info.Addr}
+			ifi.Type |= hasAddr
+		}
+		res = append(res, ifi)
+	}
+	return res
+}
+
+// Prepends an IP header to an ICMP message.
+func (c *captureConn) ipDatagram(src, dst net.IP, ttl int, body []byte) []byte {
+	if c.ipVer == util.IPv6 {
+		b := make([]byte, ipv6HeaderLen, ipv6HeaderLen+len(body))
+		b[0] = 6 << 4
+		binary.BigEndian.PutUint16(b[4:], uint16(len(body)))
+		b[6] = byte(c.ipVer.ICMPProtoNum())
+		b[7] = byte(ttl)
+		copy(b[8:], src.To16())
+		copy(b[24:], dst.To16())
+		return append(b, body...)
+	}
+	b := make([]byte, ipv4HeaderLen, ipv4HeaderLen+len(body))
+	b[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(b[2:], uint16(ipv4HeaderLen+len(body)))
+	b[8] = byte(ttl)
+	b[9] = byte(c.ipVer.ICMPProtoNum())
+	copy(b[12:], src.To4())
+	copy(b[16:], dst.To4())
+	binary.BigEndian.PutUint16(b[10:], checksum(b))
+	return append(b, body...)
+}
+
+// Computes an IPv4 header checksum.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// A captureConn for a connection that's also a [backend.PortConn].
+type capturePortConn struct {
+	*captureConn
+}
+
+func (c *capturePortConn) Ports() backend.PortRange {
+	return c.Conn.(backend.PortConn).Ports()
+}
+
+func (c *capturePortConn) SetPorts(r backend.PortRange) {
+	c.Conn.(backend.PortConn).SetPorts(r)
+}
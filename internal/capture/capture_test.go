@@ -0,0 +1,189 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/util/icmppkt"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// A reply returned by fakeConn.
+type reply struct {
+	pkt  *backend.Packet
+	peer net.Addr
+}
+
+// A connection that accepts every write and returns canned replies.
+type fakeConn struct {
+	replies []reply
+}
+
+func (c *fakeConn) WriteTo(*backend.Packet, net.Addr, ...backend.WriteOption) error {
+	return nil
+}
+
+func (c *fakeConn) ReadFrom(context.Context) (*backend.Packet, net.Addr, error) {
+	if len(c.replies) == 0 {
+		return nil, nil, backend.ErrTimeout
+	}
+	r := c.replies[0]
+	c.replies = c.replies[1:]
+	return r.pkt, r.peer, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+type fakePortConn struct {
+	fakeConn
+	ports backend.PortRange
+}
+
+func (c *fakePortConn) Ports() backend.PortRange     { return c.ports }
+func (c *fakePortConn) SetPorts(r backend.PortRange) { c.ports = r }
+
+// A packet read back from a capture.
+type captured struct {
+	Time     time.Time
+	Src, Dst string
+	TTL      int
+	Packet   *backend.Packet
+}
+
+// Parses a capture.
+func parseCapture(t *testing.T, ipVer util.IPVersion, b []byte) []captured {
+	t.Helper()
+	if len(b) < 24 {
+		t.Fatalf("Capture too short: %d bytes", len(b))
+	}
+	if magic := binary.LittleEndian.Uint32(b); magic != pcapMagic {
+		t.Errorf("Magic = %#x (want %#x)", magic, pcapMagic)
+	}
+	if lt := binary.LittleEndian.Uint32(b[20:]); lt != linkTypeRaw {
+		t.Errorf("Link type = %d (want %d)", lt, linkTypeRaw)
+	}
+	var res []captured
+	for b = b[24:]; len(b) > 0; {
+		if len(b) < 16 {
+			t.Fatalf("Short record header: %d bytes", len(b))
+		}
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		c := captured{Time: time.Unix(int64(binary.LittleEndian.Uint32(b)), int64(binary.LittleEndian.Uint32(b[4:])))}
+		data := b[16 : 16+n]
+		b = b[16+n:]
+		var body []byte
+		if ipVer == util.IPv4 {
+			hdr, err := ipv4.ParseHeader(data)
+			if err != nil {
+				t.Fatalf("Error parsing IPv4 header: %v", err)
+			}
+			if ck := checksum(data[:hdr.Len]); ck != 0 {
+				t.Errorf("Bad IPv4 header checksum in %v", hdr)
+			}
+			c.Src, c.Dst, c.TTL = hdr.Src.String(), hdr.Dst.String(), hdr.TTL
+			body = data[hdr.Len:]
+		} else {
+			hdr, err := ipv6.ParseHeader(data)
+			if err != nil {
+				t.Fatalf("Error parsing IPv6 header: %v", err)
+			}
+			c.Src, c.Dst, c.TTL = hdr.Src.String(), hdr.Dst.String(), hdr.HopLimit
+			body = data[ipv6.HeaderLen:]
+		}
+		pkt, _, _, err := icmppkt.Parse(ipVer, body)
+		if err != nil {
+			t.Fatalf("Error parsing ICMP message: %v", err)
+		}
+		c.Packet = pkt
+		res = append(res, c)
+	}
+	return res
+}
+
+func TestWrap(t *testing.T) {
+	now := time.Unix(1000, 5)
+	ext := &backend.Extensions{
+		MPLSLabels: []backend.MPLSLabel{{Label: 16, S: true, TTL: 1}},
+		Interfaces: []backend.InterfaceInfo{{Role: backend.IncomingInterface, Index: 2, Name: "eth0", MTU: 1500}},
+	}
+	cases := []struct {
+		Name       string
+		IPVer      util.IPVersion
+		Local, Hop string
+		Dest       string
+	}{
+		{Name: "IPv4", IPVer: util.IPv4, Local: "0.0.0.0", Hop: "192.0.2.9", Dest: "192.0.2.1"},
+		{Name: "IPv6", IPVer: util.IPv6, Local: "::", Hop: "2001:db8::9", Dest: "2001:db8::1"},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			hop := &net.UDPAddr{IP: net.ParseIP(c.Hop)}
+			dest := &net.UDPAddr{IP: net.ParseIP(c.Dest)}
+			fc := &fakeConn{replies: []reply{
+				{&backend.Packet{Type: backend.PacketTimeExceeded, Seq: 1, Payload: []byte("abc"), TTL: 250, Extensions: ext}, hop},
+				{&backend.Packet{Type: backend.PacketReply, Seq: 2, Payload: []byte("abc"), TTL: 60}, dest},
+			}}
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error: %v", err)
+			}
+			w.now = func() time.Time { return now }
+			conn := w.Wrap(fc, c.IPVer)
+			if _, ok := conn.(backend.PortConn); ok {
+				t.Errorf("Wrapped conn is a PortConn")
+			}
+			conn.WriteTo(&backend.Packet{Type: backend.PacketRequest, Seq: 1, Payload: []byte("abc")}, dest, backend.TTLOption{TTL: 1})
+			conn.ReadFrom(context.Background())
+			backend.WriteBatch(conn, []*backend.Packet{{Type: backend.PacketRequest, Seq: 2, Payload: []byte("abc")}}, dest)
+			conn.ReadFrom(context.Background())
+			if _, _, err := conn.ReadFrom(context.Background()); err != backend.ErrTimeout {
+				t.Errorf("ReadFrom error = %v (want %v)", err, backend.ErrTimeout)
+			}
+
+			got := parseCapture(t, c.IPVer, buf.Bytes())
+			want := []captured{
+				{Time: now, Src: c.Local, Dst: c.Dest, TTL: 1, Packet: &backend.Packet{Type: backend.PacketRequest, Seq: 1, Payload: []byte("abc")}},
+				{Time: now, Src: c.Hop, Dst: c.Local, TTL: 250, Packet: &backend.Packet{Type: backend.PacketTimeExceeded, Seq: 1, Payload: []byte("abc"), Extensions: ext}},
+				{Time: now, Src: c.Local, Dst: c.Dest, TTL: defaultTTL, Packet: &backend.Packet{Type: backend.PacketRequest, Seq: 2, Payload: []byte("abc")}},
+				{Time: now, Src: c.Dest, Dst: c.Local, TTL: 60, Packet: &backend.Packet{Type: backend.PacketReply, Seq: 2, Payload: []byte("abc")}},
+			}
+			// Errors with extensions pad the original datagram.
+			trimZeros := cmpopts.AcyclicTransformer("TrimZeros", func(b []byte) []byte { return bytes.TrimRight(b, "\x00") })
+			if diff := cmp.Diff(want, got, trimZeros); diff != "" {
+				t.Errorf("Wrong capture (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestWrap_PortConn(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("NewWriter error: %v", err)
+	}
+	fc := &fakePortConn{ports: backend.PortRange{Base: 33434, Stride: 1}}
+	conn, ok := w.Wrap(fc, util.IPv4).(backend.PortConn)
+	if !ok {
+		t.Fatalf("Wrapped conn isn't a PortConn")
+	}
+	if r := conn.Ports(); r != fc.ports {
+		t.Errorf("Ports() = %+v (want %+v)", r, fc.ports)
+	}
+	want := backend.PortRange{Base: 40000, Stride: 2}
+	conn.SetPorts(want)
+	if fc.ports != want {
+		t.Errorf("Ports = %+v (want %+v)", fc.ports, want)
+	}
+}
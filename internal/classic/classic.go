@@ -0,0 +1,41 @@
+// Package classic pings and traces a single host a line at a time, in the
+// style of the traditional ping and traceroute commands, so that vasily can
+// stand in for them in scripts. It uses the same backends and pinger as the
+// text UI.
+package classic
+
+import (
+	"net"
+
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Exit statuses, as used by BSD ping.
+const (
+	// ExitOK means at least one reply arrived, or a trace reached its
+	// destination.
+	ExitOK = 0
+
+	// ExitNoReply means nothing replied, or a trace ran out of hops.
+	ExitNoReply = 2
+
+	// ExitUsage means the command line was bad. EX_USAGE in sysexits.h.
+	ExitUsage = 64
+
+	// ExitNoHost means the host couldn't be resolved. EX_NOHOST.
+	ExitNoHost = 68
+
+	// ExitError means pinging failed for some other reason. EX_OSERR.
+	ExitError = 71
+)
+
+// Returns a host as "name (address)", or just the address if it has no name
+// or names aren't being looked up.
+func hostString(addr net.Addr) string {
+	ip := util.IP(addr).String()
+	if name := lookup.Addr(addr); name != ip {
+		return name + " (" + ip + ")"
+	}
+	return ip
+}
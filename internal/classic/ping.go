@@ -0,0 +1,154 @@
+package classic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Size of the ICMP or UDP header counted in the reply sizes that are printed.
+const headerSize = 8
+
+// PingOptions holds options for [Ping].
+type PingOptions struct {
+	// Backend is the protocol to ping with.
+	Backend backend.Name
+
+	// Count is the number of pings to send. Zero pings until ctx is
+	// canceled.
+	Count int
+
+	// Interval is the time between pings. Defaults to 1s.
+	Interval time.Duration
+
+	// Timeout is how long to wait for each reply. Defaults to 1s.
+	Timeout time.Duration
+
+	// PayloadSize is the number of data bytes in each ping. Defaults to 56,
+	// like ping's.
+	PayloadSize int
+
+	// Source binds the connection to a local interface or address.
+	Source backend.SourceOption
+
+	// Quiet prints only the first line and the summary.
+	Quiet bool
+}
+
+func (o *PingOptions) payloadSize() int {
+	if o == nil || o.PayloadSize == 0 {
+		return 56
+	}
+	return o.PayloadSize
+}
+
+// Ping pings host, printing a line to w for each reply and a summary when
+// it's done. It stops after Count pings, or when ctx is canceled, which is
+// how an interrupt should end it. Returns the exit status, and the error if
+// the status is [ExitNoHost] or [ExitError].
+func Ping(ctx context.Context, w io.Writer, host string, opts *PingOptions) (int, error) {
+	addr, err := lookup.String(host)
+	if err != nil {
+		return ExitNoHost, fmt.Errorf("cannot resolve %s: %v", host, err)
+	}
+	pp := &pingPrinter{w: w, be: opts.Backend, size: opts.payloadSize(), quiet: opts.Quiet}
+	p, err := pinger.New(opts.Backend, util.AddrVersion(addr), addr, &pinger.Options{
+		NPings:        opts.Count,
+		Interval:      opts.Interval,
+		Timeout:       opts.Timeout,
+		PayloadSize:   pp.size,
+		Source:        opts.Source,
+		OnResult:      pp.result,
+		BlockOnResult: true,
+	})
+	if err != nil {
+		return ExitError, err
+	}
+	defer p.Close()
+
+	pp.header(host, addr)
+	p.Run(ctx)
+	st := p.Stats()
+	pp.summary(host, st)
+	if st.N > st.Failures {
+		return ExitOK, nil
+	}
+	return ExitNoReply, nil
+}
+
+// Prints ping results in the style of ping.
+type pingPrinter struct {
+	w     io.Writer
+	be    backend.Name
+	size  int
+	quiet bool
+}
+
+func (pp *pingPrinter) header(host string, addr net.Addr) {
+	fmt.Fprintf(pp.w, "PING %s (%s): %d data bytes\n", host, util.IP(addr), pp.size)
+}
+
+// Returns the name of the sequence number field.
+func (pp *pingPrinter) seqName() string {
+	if pp.be == "icmp" {
+		return "icmp_seq"
+	}
+	return "seq"
+}
+
+// Prints a line for a result. Lost pings aren't printed, as with ping.
+func (pp *pingPrinter) result(seq int, res pinger.PingResult) {
+	if pp.quiet {
+		return
+	}
+	switch res.Type {
+	case pinger.Success, pinger.Duplicate, pinger.Late:
+		fmt.Fprintf(pp.w, "%d bytes from %s: %s=%d", pp.size+headerSize, util.IP(res.Peer), pp.seqName(), seq)
+		if res.TTL != 0 {
+			fmt.Fprintf(pp.w, " ttl=%d", res.TTL)
+		}
+		fmt.Fprintf(pp.w, " time=%s ms", millis(res.Latency))
+		switch res.Type {
+		case pinger.Duplicate:
+			fmt.Fprintf(pp.w, " (DUP!)")
+		case pinger.Late:
+			fmt.Fprintf(pp.w, " (late)")
+		}
+		fmt.Fprintln(pp.w)
+	case pinger.Corrupted:
+		fmt.Fprintf(pp.w, "%d bytes from %s: %s=%d wrong data\n", pp.size+headerSize, util.IP(res.Peer), pp.seqName(), seq)
+	case pinger.TTLExceeded:
+		fmt.Fprintf(pp.w, "From %s %s=%d Time to live exceeded\n", util.IP(res.Peer), pp.seqName(), seq)
+	case pinger.Unreachable:
+		fmt.Fprintf(pp.w, "From %s %s=%d Destination unreachable\n", util.IP(res.Peer), pp.seqName(), seq)
+	}
+}
+
+func (pp *pingPrinter) summary(host string, st pinger.Stats) {
+	fmt.Fprintf(pp.w, "\n--- %s ping statistics ---\n", host)
+	var loss float64
+	if st.N > 0 {
+		loss = 100 * st.PacketLoss()
+	}
+	fmt.Fprintf(pp.w, "%d packets transmitted, %d packets received, ", st.N, st.N-st.Failures)
+	if st.Duplicates > 0 {
+		fmt.Fprintf(pp.w, "+%d duplicates, ", st.Duplicates)
+	}
+	fmt.Fprintf(pp.w, "%.1f%% packet loss\n", loss)
+	if st.N > st.Failures {
+		fmt.Fprintf(pp.w, "round-trip min/avg/max/stddev = %s/%s/%s/%s ms\n",
+			millis(st.MinLatency), millis(st.AvgLatency), millis(st.MaxLatency), millis(st.StdDev))
+	}
+}
+
+// Formats a duration in milliseconds, to the microsecond.
+func millis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
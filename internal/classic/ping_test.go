@@ -0,0 +1,92 @@
+package classic
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+var peer = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+
+func TestPingPrinter_Result(t *testing.T) {
+	var out bytes.Buffer
+	pp := &pingPrinter{w: &out, be: "icmp", size: 56}
+	pp.header("example.com", peer)
+	pp.result(0, pinger.PingResult{Type: pinger.Success, Peer: peer, TTL: 57, Latency: 12345 * time.Microsecond})
+	pp.result(0, pinger.PingResult{Type: pinger.Duplicate, Peer: peer, TTL: 57, Latency: 13 * time.Millisecond})
+	pp.result(1, pinger.PingResult{Type: pinger.Dropped})
+	pp.result(2, pinger.PingResult{Type: pinger.Unreachable, Peer: peer})
+	pp.result(3, pinger.PingResult{Type: pinger.TTLExceeded, Peer: peer})
+	pp.result(4, pinger.PingResult{Type: pinger.Corrupted, Peer: peer})
+	pp.result(1, pinger.PingResult{Type: pinger.Late, Peer: peer, Latency: 2 * time.Second})
+
+	want := "PING example.com (192.0.2.1): 56 data bytes\n" +
+		"64 bytes from 192.0.2.1: icmp_seq=0 ttl=57 time=12.345 ms\n" +
+		"64 bytes from 192.0.2.1: icmp_seq=0 ttl=57 time=13.000 ms (DUP!)\n" +
+		"From 192.0.2.1 icmp_seq=2 Destination unreachable\n" +
+		"From 192.0.2.1 icmp_seq=3 Time to live exceeded\n" +
+		"64 bytes from 192.0.2.1: icmp_seq=4 wrong data\n" +
+		"64 bytes from 192.0.2.1: icmp_seq=1 time=2000.000 ms (late)\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("Wrong output (-want, +got):\n%v", diff)
+	}
+}
+
+func TestPingPrinter_Quiet(t *testing.T) {
+	var out bytes.Buffer
+	pp := &pingPrinter{w: &out, be: "udp", size: 56, quiet: true}
+	pp.result(0, pinger.PingResult{Type: pinger.Success, Peer: peer})
+	if out.Len() != 0 {
+		t.Errorf("Quiet printer wrote %q", out.String())
+	}
+}
+
+func TestPingPrinter_Summary(t *testing.T) {
+	cases := []struct {
+		name string
+		st   pinger.Stats
+		want string
+	}{
+		{
+			name: "Replies",
+			st: pinger.Stats{
+				N:          4,
+				Failures:   1,
+				Duplicates: 2,
+				MinLatency: time.Millisecond,
+				AvgLatency: 2 * time.Millisecond,
+				MaxLatency: 3 * time.Millisecond,
+				StdDev:     500 * time.Microsecond,
+			},
+			want: "\n--- example.com ping statistics ---\n" +
+				"4 packets transmitted, 3 packets received, +2 duplicates, 25.0% packet loss\n" +
+				"round-trip min/avg/max/stddev = 1.000/2.000/3.000/0.500 ms\n",
+		},
+		{
+			name: "NoReplies",
+			st:   pinger.Stats{N: 2, Failures: 2},
+			want: "\n--- example.com ping statistics ---\n" +
+				"2 packets transmitted, 0 packets received, 100.0% packet loss\n",
+		},
+		{
+			name: "NothingSent",
+			want: "\n--- example.com ping statistics ---\n" +
+				"0 packets transmitted, 0 packets received, 0.0% packet loss\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			pp := &pingPrinter{w: &out, be: "icmp", size: 56}
+			pp.summary("example.com", c.st)
+			if diff := cmp.Diff(c.want, out.String()); diff != "" {
+				t.Errorf("Wrong output (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
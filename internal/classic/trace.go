@@ -0,0 +1,152 @@
+package classic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// TraceOptions holds options for [Trace].
+type TraceOptions struct {
+	// Backend is the protocol to trace with.
+	Backend backend.Name
+
+	// MaxTTL is the maximum path length to trace. Defaults to 64.
+	MaxTTL int
+
+	// Queries is the number of probes sent to each hop. Defaults to 3.
+	Queries int
+
+	// Interval is the time between probes. Defaults to 1s.
+	Interval time.Duration
+
+	// Paris keeps the probes in a single flow.
+	Paris bool
+
+	// Port is the destination port of the first probe, for protocols with
+	// ports. Zero for the protocol's default.
+	Port int
+
+	// FixedPort sends every probe to Port. It implies Paris.
+	FixedPort bool
+
+	// Source binds the connection to a local interface or address.
+	Source backend.SourceOption
+}
+
+func (o *TraceOptions) maxTTL() int {
+	if o == nil || o.MaxTTL == 0 {
+		return 64
+	}
+	return o.MaxTTL
+}
+
+func (o *TraceOptions) queries() int {
+	if o == nil || o.Queries == 0 {
+		return 3
+	}
+	return o.Queries
+}
+
+// Trace traces the path to host, printing a line to w for each hop, in the
+// style of traceroute. Returns the exit status, and the error if the status
+// is [ExitNoHost] or [ExitError]. A trace that runs out of hops before
+// reaching the host exits with [ExitNoReply].
+func Trace(ctx context.Context, w io.Writer, host string, opts *TraceOptions) (int, error) {
+	addr, err := lookup.String(host)
+	if err != nil {
+		return ExitNoHost, fmt.Errorf("cannot resolve %s: %v", host, err)
+	}
+	tp := newTracePrinter(w, opts.queries())
+	fmt.Fprintf(w, "traceroute to %s (%s), %d hops max\n", host, util.IP(addr), opts.maxTTL())
+
+	steps := make(chan tracer.Step)
+	go func() {
+		// The steps are only needed by the UI. Each probe is printed instead.
+		for range steps {
+		}
+	}()
+	err = tracer.TraceRoute(ctx, opts.Backend, util.AddrVersion(addr), addr, steps, &tracer.Options{
+		Interval:     opts.Interval,
+		ProbesPerHop: opts.queries(),
+		MaxTTL:       opts.maxTTL(),
+		Paris:        opts.Paris,
+		BasePort:     opts.Port,
+		FixedPort:    opts.FixedPort,
+		Source:       opts.Source,
+		OnProbe:      tp.probe,
+	})
+	tp.flush()
+	switch {
+	case err == nil:
+		return ExitOK, nil
+	case errors.Is(err, tracer.ErrMaxTTL), errors.Is(err, context.Canceled):
+		return ExitNoReply, nil
+	default:
+		return ExitError, err
+	}
+}
+
+// Prints probes a hop at a time, in the style of traceroute. The tracer sends
+// one probe to each hop in turn, so a hop's line is held until all of its
+// probes are in, and the lines before it have been printed.
+type tracePrinter struct {
+	w       io.Writer
+	queries int
+	probes  map[int][]tracer.Probe
+	next    int // The next hop to print.
+	last    int // The furthest hop probed.
+}
+
+func newTracePrinter(w io.Writer, queries int) *tracePrinter {
+	return &tracePrinter{
+		w:       w,
+		queries: queries,
+		probes:  make(map[int][]tracer.Probe),
+		next:    1,
+	}
+}
+
+func (tp *tracePrinter) probe(p tracer.Probe) {
+	tp.probes[p.Pos] = append(tp.probes[p.Pos], p)
+	tp.last = max(tp.last, p.Pos)
+	for len(tp.probes[tp.next]) >= tp.queries {
+		tp.printHop(tp.next)
+		tp.next++
+	}
+}
+
+// Prints the hops that are still held, however many probes they got.
+func (tp *tracePrinter) flush() {
+	for ; tp.next <= tp.last; tp.next++ {
+		tp.printHop(tp.next)
+	}
+}
+
+func (tp *tracePrinter) printHop(pos int) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%2d ", pos)
+	var prev net.IP
+	for _, p := range tp.probes[pos] {
+		if p.Lost {
+			sb.WriteString(" *")
+			continue
+		}
+		if ip := util.IP(p.Host); !ip.Equal(prev) {
+			fmt.Fprintf(&sb, " %s", hostString(p.Host))
+			prev = ip
+		}
+		fmt.Fprintf(&sb, "  %s ms", millis(p.Latency))
+	}
+	delete(tp.probes, pos)
+	fmt.Fprintln(tp.w, sb.String())
+}
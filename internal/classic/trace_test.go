@@ -0,0 +1,43 @@
+package classic
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/tracer"
+)
+
+func TestTracePrinter(t *testing.T) {
+	lookup.NumericMode = true
+	defer func() { lookup.NumericMode = false }()
+
+	hop1 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	hop2a := &net.UDPAddr{IP: net.ParseIP("192.0.2.2")}
+	hop2b := &net.UDPAddr{IP: net.ParseIP("192.0.2.3")}
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+
+	var out bytes.Buffer
+	tp := newTracePrinter(&out, 2)
+	// Probes arrive a round at a time, as the tracer sends them.
+	tp.probe(tracer.Probe{Pos: 1, Host: hop1, Latency: ms(1)})
+	tp.probe(tracer.Probe{Pos: 2, Host: hop2a, Latency: ms(2)})
+	tp.probe(tracer.Probe{Pos: 3, Lost: true})
+	if out.Len() != 0 {
+		t.Fatalf("Printed hops before their probes were all in:\n%s", out.String())
+	}
+	tp.probe(tracer.Probe{Pos: 1, Lost: true, Host: hop1})
+	tp.probe(tracer.Probe{Pos: 2, Host: hop2b, Latency: ms(3)})
+	tp.flush()
+
+	want := " 1  192.0.2.1  1.000 ms *\n" +
+		" 2  192.0.2.2  2.000 ms 192.0.2.3  3.000 ms\n" +
+		" 3  *\n"
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("Wrong output (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,394 @@
+// Package config reads settings from a config file.
+//
+// The file is in a restricted subset of TOML, described in package toml:
+// single-line keys and values, with no plain tables, floats or dates. Files
+// that go outside it are rejected. Top-level keys give defaults for the
+// equivalent command-line flags, [[group]] tables name lists of targets
+// that can be pinged together with @name, and [[label]] tables attach a
+// free-text label to a host or group. For example:
+//
+//	protocol = "icmp"
+//	interval = "2s"
+//	theme = "default"
+//	sort = ["loss", "-avgms"]
+//	columns = ["hop", "host", "results", "avgms", "p95", "loss"]
+//	graph_style = "braille"
+//	anchors = ["1.1.1.1", "9.9.9.9"]
+//
+//	[[group]]
+//	name = "home"
+//	targets = ["192.168.1.1", "example.com"]
+//
+//	[[label]]
+//	host = "192.168.1.1"
+//	text = "office router"
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/config/toml"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tui"
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+// Config holds the settings from a config file. Zero values are unset.
+type Config struct {
+	// Protocol is the backend to use for pings.
+	Protocol backend.Name
+
+	// Interval is the interval between pings to a single host.
+	Interval time.Duration
+
+	// Theme is the name of a built-in UI theme or the path to a theme file.
+	Theme string
+
+	// Sort is the initial sort order of the table.
+	Sort []table.SortColumn
+
+	// Columns are the table columns to show, in order.
+	Columns []table.ColumnID
+
+	// GraphStyle is the set of characters for the latency graph.
+	GraphStyle *table.GraphStyle
+
+	// Anchors are the well-known hosts pinged with --well_known.
+	Anchors []string
+
+	// Groups are named lists of targets.
+	Groups []Group
+
+	// Labels maps hosts, as they're given on the command line, and IP
+	// addresses to their labels.
+	Labels map[string]string
+}
+
+// Group is a named list of targets.
+type Group struct {
+	Name    string
+	Targets []string
+}
+
+// DefaultPath returns the path of the config file: config.toml in the vasily
+// directory under $XDG_CONFIG_HOME, or ~/.config if that isn't set.
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "vasily", "config.toml"), nil
+}
+
+// LoadDefault reads the config file at [DefaultPath]. Returns an empty config
+// if there isn't one.
+func LoadDefault() (*Config, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return &Config{}, nil
+	}
+	c, err := Load(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	return c, err
+}
+
+// Load reads a config file.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses a config file.
+func Parse(r io.Reader) (*Config, error) {
+	doc, err := toml.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	for key, e := range doc.Top {
+		if err := c.set(key, e); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", e.Line, key, err)
+		}
+	}
+	for name, tables := range doc.Arrays {
+		var err error
+		switch name {
+		case "group":
+			err = c.addGroups(tables)
+		case "label":
+			err = c.addLabels(tables)
+		default:
+			err = fmt.Errorf("unknown table [[%s]]", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Adds the groups from [[group]] tables.
+func (c *Config) addGroups(tables []toml.Table) error {
+	for _, t := range tables {
+		g, err := parseGroup(t)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(c.Groups, func(o Group) bool { return o.Name == g.Name }) {
+			return fmt.Errorf("duplicate group %q", g.Name)
+		}
+		c.Groups = append(c.Groups, g)
+	}
+	return nil
+}
+
+// Adds the labels from [[label]] tables.
+func (c *Config) addLabels(tables []toml.Table) error {
+	for _, t := range tables {
+		var host, text string
+		for key, v := range t {
+			var err error
+			switch key {
+			case "host":
+				host, err = v.AsString()
+			case "text":
+				text, err = v.AsString()
+			default:
+				err = errors.New("unknown setting")
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %s: %v", v.Line, key, err)
+			}
+		}
+		if host == "" {
+			return errors.New("label without a host")
+		}
+		if _, ok := c.Labels[host]; ok {
+			return fmt.Errorf("duplicate label for %q", host)
+		}
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+		c.Labels[host] = text
+	}
+	return nil
+}
+
+// Sets a top-level setting.
+func (c *Config) set(key string, v toml.Value) error {
+	switch key {
+	case "protocol":
+		s, err := v.AsString()
+		c.Protocol = backend.Name(s)
+		return err
+	case "interval":
+		s, err := v.AsString()
+		if err != nil {
+			return err
+		}
+		c.Interval, err = time.ParseDuration(s)
+		return err
+	case "theme":
+		s, err := v.AsString()
+		if err != nil {
+			return err
+		}
+		if _, err := theme.Find(s); err != nil {
+			return err
+		}
+		c.Theme = s
+		return nil
+	case "sort":
+		cols, err := v.AsStrings()
+		if err != nil {
+			return err
+		}
+		c.Sort, err = table.ParseSort(strings.Join(cols, ","))
+		return err
+	case "columns":
+		names, err := v.AsStrings()
+		if err != nil {
+			return err
+		}
+		c.Columns, err = table.ParseColumns(names)
+		return err
+	case "graph_style":
+		s, err := v.AsString()
+		if err != nil {
+			return err
+		}
+		g, err := table.ParseGraphStyle(s)
+		c.GraphStyle = &g
+		return err
+	case "anchors":
+		var err error
+		c.Anchors, err = v.AsStrings()
+		return err
+	default:
+		return errors.New("unknown setting")
+	}
+}
+
+// Parses a [[group]] table.
+func parseGroup(t toml.Table) (Group, error) {
+	var g Group
+	for key, v := range t {
+		var err error
+		switch key {
+		case "name":
+			g.Name, err = v.AsString()
+		case "targets":
+			g.Targets, err = v.AsStrings()
+		default:
+			err = errors.New("unknown setting")
+		}
+		if err != nil {
+			return Group{}, fmt.Errorf("line %d: %s: %v", v.Line, key, err)
+		}
+	}
+	if g.Name == "" {
+		return Group{}, errors.New("group without a name")
+	}
+	if len(g.Targets) == 0 {
+		return Group{}, fmt.Errorf("group %q has no targets", g.Name)
+	}
+	return g, nil
+}
+
+// Group returns the group with the given name.
+func (c *Config) Group(name string) (Group, bool) {
+	i := slices.IndexFunc(c.Groups, func(g Group) bool { return g.Name == name })
+	if i < 0 {
+		return Group{}, false
+	}
+	return c.Groups[i], true
+}
+
+// Targets expands group references in a list of hosts. An argument like
+// @name is replaced by the targets of the group with that name.
+func (c *Config) Targets(args []string) ([]string, error) {
+	var res []string
+	for _, a := range args {
+		name, ok := strings.CutPrefix(a, "@")
+		if !ok {
+			res = append(res, a)
+			continue
+		}
+		g, ok := c.Group(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown group %q", name)
+		}
+		res = append(res, g.Targets...)
+	}
+	return res, nil
+}
+
+// PingerOptions returns pinger options with the settings from the config.
+func (c *Config) PingerOptions() *pinger.Options {
+	return &pinger.Options{
+		Interval: c.Interval,
+	}
+}
+
+// TargetOptions returns target manager options with the settings from the
+// config. Unset settings are left for [targets.New] to fill in with defaults.
+func (c *Config) TargetOptions() *targets.Options {
+	return &targets.Options{
+		PingBackend:  c.Protocol,
+		PingInterval: c.Interval,
+		Labels:       c.Labels,
+	}
+}
+
+// TUIOptions returns UI options with the settings from the config. Unset
+// settings are left for [tui.New] to fill in with defaults.
+func (c *Config) TUIOptions() *tui.Options {
+	opts := &tui.Options{
+		Sort:    c.Sort,
+		Columns: c.Columns,
+	}
+	if c.GraphStyle != nil {
+		opts.GraphStyle = *c.GraphStyle
+	}
+	if c.Theme != "" {
+		// Checked by Parse.
+		opts.Theme, _ = theme.Find(c.Theme)
+	}
+	return opts
+}
+
+// SaveColumns sets the columns setting in a config file, creating the file if
+// there isn't one. The rest of the file is left as it is.
+func SaveColumns(path string, cols []table.ColumnID) error {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = strconv.Quote(strings.ToLower(c.Display()))
+	}
+	line := fmt.Sprintf("columns = [%s]", strings.Join(names, ", "))
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, setTopLine(data, "columns", line), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Replaces the line for a top-level key, or adds it after the last top-level
+// line if there isn't one.
+func setTopLine(data []byte, key, line string) []byte {
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	top := len(lines)
+	for i, l := range lines {
+		l = strings.TrimSpace(l)
+		if strings.HasPrefix(l, "[") {
+			top = i
+			break
+		}
+		if k, _, ok := strings.Cut(l, "="); ok && strings.TrimSpace(k) == key {
+			lines[i] = line
+			return []byte(strings.Join(lines, "\n") + "\n")
+		}
+	}
+	// Goes before any blank lines separating the top level from the tables.
+	for top > 0 && strings.TrimSpace(lines[top-1]) == "" {
+		top--
+	}
+	lines = slices.Insert(lines, top, line)
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
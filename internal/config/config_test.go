@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/tui/table"
+)
+
+func TestParse(t *testing.T) {
+	in := `
+protocol = "udp"
+interval = "2s"
+theme = "default"
+sort = ["loss", "-AvgMs"]
+columns = ["host", "Results", "loss"]
+graph_style = "braille"
+anchors = ["1.1.1.1", "9.9.9.9"]
+
+[[group]]
+name = "home"
+targets = ["192.168.1.1", "example.com"]
+
+[[group]]
+name = "dns"
+targets = "8.8.8.8"
+
+[[label]]
+host = "192.168.1.1"
+text = "office router"
+`
+	want := &Config{
+		Protocol: "udp",
+		Interval: 2 * time.Second,
+		Theme:    "default",
+		Sort: []table.SortColumn{
+			{ColumnID: table.ColPctLoss},
+			{ColumnID: table.ColAvgMs, Reverse: true},
+		},
+		Columns:    []table.ColumnID{table.ColHost, table.ColResults, table.ColPctLoss},
+		GraphStyle: ptr(table.GraphBraille),
+		Anchors:    []string{"1.1.1.1", "9.9.9.9"},
+		Groups: []Group{
+			{Name: "home", Targets: []string{"192.168.1.1", "example.com"}},
+			{Name: "dns", Targets: []string{"8.8.8.8"}},
+		},
+		Labels: map[string]string{"192.168.1.1": "office router"},
+	}
+	got, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Parse() wrong result (-want, +got):\n%v", diff)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		Name string
+		In   string
+	}{
+		{Name: "UnknownSetting", In: "colour = 1"},
+		{Name: "WrongType", In: "protocol = 1"},
+		{Name: "BadInterval", In: `interval = "soon"`},
+		{Name: "BadTheme", In: `theme = "nonexistent"`},
+		{Name: "BadSort", In: `sort = "nonexistent"`},
+		{Name: "BadGraphStyle", In: `graph_style = "dots"`},
+		{Name: "BadColumn", In: `columns = ["host", "nonexistent"]`},
+		{Name: "DuplicateColumn", In: `columns = ["host", "Host"]`},
+		{Name: "UnknownTable", In: "[[target]]\nname = \"a\""},
+		{Name: "UnnamedGroup", In: "[[group]]\ntargets = [\"a\"]"},
+		{Name: "EmptyGroup", In: "[[group]]\nname = \"a\""},
+		{Name: "GroupSetting", In: "[[group]]\nname = \"a\"\ntargets = [\"a\"]\ninterval = \"1s\""},
+		{Name: "DuplicateGroup", In: "[[group]]\nname = \"a\"\ntargets = [\"a\"]\n[[group]]\nname = \"a\"\ntargets = [\"b\"]"},
+		{Name: "LabelWithoutHost", In: "[[label]]\ntext = \"a\""},
+		{Name: "LabelSetting", In: "[[label]]\nhost = \"a\"\ncolor = \"red\""},
+		{Name: "DuplicateLabel", In: "[[label]]\nhost = \"a\"\ntext = \"b\"\n[[label]]\nhost = \"a\"\ntext = \"c\""},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if _, err := Parse(strings.NewReader(c.In)); err == nil {
+				t.Errorf("Parse(%q) succeeded (want error)", c.In)
+			}
+		})
+	}
+}
+
+func TestLoadDefault(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	c, err := LoadDefault()
+	if err != nil {
+		t.Fatalf("LoadDefault() without file error: %v", err)
+	}
+	if diff := cmp.Diff(&Config{}, c); diff != "" {
+		t.Errorf("LoadDefault() without file wrong result (-want, +got):\n%v", diff)
+	}
+
+	path := filepath.Join(dir, "vasily", "config.toml")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(`interval = "5s"`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err = LoadDefault()
+	if err != nil {
+		t.Fatalf("LoadDefault() error: %v", err)
+	}
+	if c.Interval != 5*time.Second {
+		t.Errorf("LoadDefault() interval = %v (want %v)", c.Interval, 5*time.Second)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	c := &Config{
+		Groups: []Group{{Name: "home", Targets: []string{"a", "b"}}},
+	}
+	got, err := c.Targets([]string{"x", "@home", "y"})
+	if err != nil {
+		t.Fatalf("Targets() error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"x", "a", "b", "y"}, got); diff != "" {
+		t.Errorf("Targets() wrong result (-want, +got):\n%v", diff)
+	}
+	if _, err := c.Targets([]string{"@nonexistent"}); err == nil {
+		t.Errorf("Targets() with unknown group succeeded (want error)")
+	}
+}
+
+func TestSaveColumns(t *testing.T) {
+	cols := []table.ColumnID{table.ColHost, table.ColResults, table.ColP95}
+	cases := []struct {
+		Name string
+		In   string
+		Want string
+	}{
+		{
+			Name: "NoFile",
+			Want: "columns = [\"host\", \"results\", \"p95\"]\n",
+		},
+		{
+			Name: "Replace",
+			In:   "interval = \"2s\"\ncolumns = [\"host\"]\n",
+			Want: "interval = \"2s\"\ncolumns = [\"host\", \"results\", \"p95\"]\n",
+		},
+		{
+			Name: "BeforeTables",
+			In:   "# Settings\ninterval = \"2s\"\n\n[[group]]\nname = \"a\"\ntargets = [\"a\"]\n",
+			Want: "# Settings\ninterval = \"2s\"\ncolumns = [\"host\", \"results\", \"p95\"]\n\n[[group]]\nname = \"a\"\ntargets = [\"a\"]\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "vasily", "config.toml")
+			if c.In != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(c.In), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := SaveColumns(path, cols); err != nil {
+				t.Fatalf("SaveColumns() error: %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.Want, string(got)); diff != "" {
+				t.Errorf("Wrong file (-want, +got):\n%v", diff)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatalf("Load() error: %v", err)
+			}
+			if diff := cmp.Diff(cols, cfg.Columns); diff != "" {
+				t.Errorf("Loaded wrong columns (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
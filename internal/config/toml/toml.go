@@ -0,0 +1,278 @@
+// Package toml parses the restricted subset of TOML used by config and theme
+// files. It isn't a general TOML parser. Files that use anything outside the
+// subset are rejected with an error wrapping [ErrUnsupported], even when
+// they're valid TOML, rather than being misread.
+//
+// The subset has comments, and key/value pairs on single lines, at the top
+// level and in arrays of tables ([[name]]). Keys must be bare. Values may be
+// basic or literal strings, decimal integers, booleans, and arrays of those.
+// Plain tables, dotted and quoted keys, inline tables, multiline strings and
+// arrays, floats, dates and times, and hex, octal and binary integers aren't
+// supported.
+package toml
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupported is returned for TOML that's outside the supported subset.
+var ErrUnsupported = errors.New("unsupported TOML")
+
+// Table holds parsed keys and values.
+type Table map[string]Value
+
+// Value holds a parsed value and the line it came from.
+type Value struct {
+	// Val is a string, int64, bool, or []any array of those.
+	Val any
+
+	// Line is the line number the value was on.
+	Line int
+}
+
+// Document is a parsed file.
+type Document struct {
+	// Top contains the keys that come before any table header.
+	Top Table
+
+	// Arrays contains arrays of tables, keyed by name.
+	Arrays map[string][]Table
+}
+
+// Parse parses a document. Errors include the line number.
+func Parse(r io.Reader) (*Document, error) {
+	doc := &Document{Top: make(Table), Arrays: make(map[string][]Table)}
+	cur := doc.Top
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, "[["); ok {
+			name, rest, ok := strings.Cut(name, "]]")
+			name = strings.TrimSpace(name)
+			if ok && isQuotedOrDotted(name) {
+				return nil, fmt.Errorf("line %d: %w: dotted and quoted table names: %s", n, ErrUnsupported, line)
+			}
+			if !ok || !isBareKey(name) || !isComment(rest) {
+				return nil, fmt.Errorf("line %d: bad table header: %s", n, line)
+			}
+			cur = make(Table)
+			doc.Arrays[name] = append(doc.Arrays[name], cur)
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: %w: tables other than arrays of tables: %s", n, ErrUnsupported, line)
+		}
+
+		key, val, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if ok && isQuotedOrDotted(key) {
+			return nil, fmt.Errorf("line %d: %w: dotted and quoted keys: %s", n, ErrUnsupported, line)
+		}
+		if !ok || !isBareKey(key) {
+			return nil, fmt.Errorf("line %d: expected key = value: %s", n, line)
+		}
+		if _, ok := cur[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key %q", n, key)
+		}
+		v, rest, err := parseValue(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if !isComment(rest) {
+			return nil, fmt.Errorf("line %d: unexpected text after value: %s", n, rest)
+		}
+		cur[key] = Value{Val: v, Line: n}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// AsString returns the value as a string.
+func (v Value) AsString() (string, error) {
+	s, ok := v.Val.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string (got %v)", v.Val)
+	}
+	return s, nil
+}
+
+// AsStrings returns the value as an array of strings. A single string is taken
+// as an array of one.
+func (v Value) AsStrings() ([]string, error) {
+	if s, ok := v.Val.(string); ok {
+		return []string{s}, nil
+	}
+	arr, ok := v.Val.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an array of strings (got %v)", v.Val)
+	}
+	var res []string
+	for _, a := range arr {
+		s, err := Value{Val: a}.AsString()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// Returns true if s is a valid bare key.
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if s is a dotted or quoted key, which are valid TOML but aren't
+// supported.
+func isQuotedOrDotted(s string) bool {
+	return strings.ContainsAny(s, `."'`)
+}
+
+// Returns true if s is empty or a comment, ignoring surrounding space.
+func isComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
+
+// Parses the value at the start of s and returns the unparsed remainder.
+func parseValue(s string) (any, string, error) {
+	switch {
+	case strings.HasPrefix(s, `"""`), strings.HasPrefix(s, "'''"):
+		return nil, "", fmt.Errorf("%w: multiline strings: %s", ErrUnsupported, s)
+	case strings.HasPrefix(s, "{"):
+		return nil, "", fmt.Errorf("%w: inline tables: %s", ErrUnsupported, s)
+	case strings.HasPrefix(s, `"`):
+		return parseBasicString(s)
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated string: %s", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	case strings.HasPrefix(s, "["):
+		return parseArray(s)
+	}
+
+	end := strings.IndexAny(s, " \t,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	tok, rest := s[:end], s[end:]
+	switch tok {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	i, err := strconv.ParseInt(strings.ReplaceAll(tok, "_", ""), 10, 64)
+	if err == nil {
+		return i, rest, nil
+	}
+	if looksNumeric(tok) {
+		return nil, "", fmt.Errorf("%w: floats, dates and times, and non-decimal integers: %s", ErrUnsupported, tok)
+	}
+	return nil, "", fmt.Errorf("bad value: %s", tok)
+}
+
+// Returns true if tok looks like a number, date or time that isn't a decimal
+// integer.
+func looksNumeric(tok string) bool {
+	tok = strings.TrimLeft(tok, "+-")
+	if tok == "inf" || tok == "nan" {
+		return true
+	}
+	return tok != "" && tok[0] >= '0' && tok[0] <= '9'
+}
+
+// Parses a double-quoted string with TOML's escapes.
+func parseBasicString(s string) (any, string, error) {
+	var sb strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return sb.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch s[i] {
+			case '"', '\\':
+				sb.WriteByte(s[i])
+			case 'b':
+				sb.WriteByte('\b')
+			case 'f':
+				sb.WriteByte('\f')
+			case 'n':
+				sb.WriteByte('\n')
+			case 'r':
+				sb.WriteByte('\r')
+			case 't':
+				sb.WriteByte('\t')
+			case 'u', 'U':
+				n := 4
+				if s[i] == 'U' {
+					n = 8
+				}
+				if i+n >= len(s) {
+					return nil, "", fmt.Errorf("unterminated string: %s", s)
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return nil, "", fmt.Errorf("bad escape \\%s", s[i:i+1+n])
+				}
+				sb.WriteRune(rune(r))
+				i += n
+			default:
+				return nil, "", fmt.Errorf("bad escape \\%c", s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return nil, "", fmt.Errorf("unterminated string: %s", s)
+}
+
+// Parses a single-line array. A trailing comma is allowed.
+func parseArray(s string) (any, string, error) {
+	arr := []any{}
+	rest := strings.TrimSpace(s[1:])
+	for {
+		if r, ok := strings.CutPrefix(rest, "]"); ok {
+			return arr, r, nil
+		}
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			return nil, "", fmt.Errorf("%w: multiline arrays: %s", ErrUnsupported, s)
+		}
+		v, r, err := parseValue(rest)
+		if err != nil {
+			return nil, "", err
+		}
+		arr = append(arr, v)
+		rest = strings.TrimSpace(r)
+		if r, ok := strings.CutPrefix(rest, ","); ok {
+			rest = strings.TrimSpace(r)
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, "", fmt.Errorf("expected , or ] in array: %s", s)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package toml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	in := `# A comment.
+str = "a \"quoted\"\tstring" # Trailing comment.
+esc = "\u00e9\U0001F600\r"
+lit = 'C:\path'
+num = 1_000
+neg = -3
+yes = true
+no = false
+arr = [ "a", 'b', 3, ]
+empty = []
+
+[[group]]
+name = "one"
+
+[[group]]  # Another.
+name = "two"
+`
+	want := &Document{
+		Top: Table{
+			"str":   {Val: "a \"quoted\"\tstring", Line: 2},
+			"esc":   {Val: "\u00e9\U0001F600\r", Line: 3},
+			"lit":   {Val: `C:\path`, Line: 4},
+			"num":   {Val: int64(1000), Line: 5},
+			"neg":   {Val: int64(-3), Line: 6},
+			"yes":   {Val: true, Line: 7},
+			"no":    {Val: false, Line: 8},
+			"arr":   {Val: []any{"a", "b", int64(3)}, Line: 9},
+			"empty": {Val: []any{}, Line: 10},
+		},
+		Arrays: map[string][]Table{
+			"group": {
+				{"name": {Val: "one", Line: 13}},
+				{"name": {Val: "two", Line: 16}},
+			},
+		},
+	}
+	got, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Parse() wrong result (-want, +got):\n%v", diff)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	cases := []struct {
+		Name string
+		In   string
+		// True for valid TOML outside the supported subset.
+		Unsupported bool
+	}{
+		{Name: "NoValue", In: "a ="},
+		{Name: "NoEquals", In: "a"},
+		{Name: "DottedKey", In: "a.b = 1", Unsupported: true},
+		{Name: "QuotedKey", In: `"a" = 1`, Unsupported: true},
+		{Name: "DuplicateKey", In: "a = 1\na = 2"},
+		{Name: "Table", In: "[a]", Unsupported: true},
+		{Name: "DottedTableArray", In: "[[a.b]]", Unsupported: true},
+		{Name: "BadHeader", In: "[[a]"},
+		{Name: "Unterminated", In: `a = "b`},
+		{Name: "UnterminatedLiteral", In: `a = 'b`},
+		{Name: "BadEscape", In: `a = "\x"`},
+		{Name: "BadUnicodeEscape", In: `a = "\uzzzz"`},
+		{Name: "MultilineArray", In: "a = [\n  1,\n]", Unsupported: true},
+		{Name: "MultilineString", In: `a = """b"""`, Unsupported: true},
+		{Name: "InlineTable", In: "a = { b = 1 }", Unsupported: true},
+		{Name: "MissingComma", In: `a = [1 2]`},
+		{Name: "Float", In: "a = 1.5", Unsupported: true},
+		{Name: "Inf", In: "a = -inf", Unsupported: true},
+		{Name: "Hex", In: "a = 0xff", Unsupported: true},
+		{Name: "Date", In: "a = 2024-01-02", Unsupported: true},
+		{Name: "BareWord", In: "a = b"},
+		{Name: "TrailingText", In: `a = "b" c`},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(c.In))
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded (want error)", c.In)
+			}
+			if got := errors.Is(err, ErrUnsupported); got != c.Unsupported {
+				t.Errorf("Parse(%q) error %q: errors.Is(ErrUnsupported) = %v (want %v)", c.In, err, got, c.Unsupported)
+			}
+		})
+	}
+}
+
+func TestAsStrings(t *testing.T) {
+	cases := []struct {
+		In      any
+		Want    []string
+		WantErr bool
+	}{
+		{In: "a", Want: []string{"a"}},
+		{In: []any{"a", "b"}, Want: []string{"a", "b"}},
+		{In: []any{"a", int64(1)}, WantErr: true},
+		{In: true, WantErr: true},
+	}
+	for _, c := range cases {
+		got, err := Value{Val: c.In}.AsStrings()
+		if (err != nil) != c.WantErr {
+			t.Fatalf("AsStrings(%v) unexpected error: %v", c.In, err)
+		}
+		if diff := cmp.Diff(c.Want, got); diff != "" {
+			t.Errorf("AsStrings(%v) wrong result (-want, +got):\n%v", c.In, diff)
+		}
+	}
+}
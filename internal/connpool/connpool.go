@@ -0,0 +1,267 @@
+// Package connpool shares backend connections between several users, such as
+// pingers or traces. This saves opening a connection for each one, which
+// backends limit.
+//
+// Each connection carries up to [Slots] users, which are told apart by the
+// range of sequence numbers they send. Slot i sends sequence numbers starting
+// at i*[SeqSpace], but each user sees its own, from zero up to SeqSpace-1.
+// Replies are handed to the user whose range their sequence number is in, and
+// each user's packets are sent as a stream of their own, so that backends rate
+// limit them separately.
+package connpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+
+	"github.com/pcekm/vasily/internal/backend"
+)
+
+const (
+	// Slots is the number of users that may share one connection.
+	Slots = backend.MaxStreams
+
+	// SeqSpace is the number of sequence numbers each user gets.
+	SeqSpace = (math.MaxUint16 + 1) / Slots
+)
+
+// Options configures a [Pool].
+type Options struct {
+	// Replies is the number of replies buffered for each user.
+	Replies int
+
+	// DropWhenFull drops replies that arrive while a user's buffer is full.
+	// Otherwise they wait for the user to read, holding up the others.
+	DropWhenFull bool
+
+	// Unshared, if set, reports connections that mustn't be shared. They're
+	// handed to a single user as they are.
+	Unshared func(backend.Conn) bool
+}
+
+// Pool shares connections between users. Connections are only shared between
+// users with the same key, which should identify everything the connection
+// was opened with.
+type Pool[K comparable] struct {
+	opts Options
+
+	mu    sync.Mutex
+	conns map[K][]*sharedConn[K]
+}
+
+// New creates an empty pool.
+func New[K comparable](opts Options) *Pool[K] {
+	return &Pool[K]{opts: opts, conns: make(map[K][]*sharedConn[K])}
+}
+
+// Get returns a connection for a single user. It's a slot in a shared
+// connection with the same key, or in a new one opened with open if none has
+// room.
+func (p *Pool[K]) Get(key K, open func() (backend.Conn, error)) (backend.Conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sc := range p.conns[key] {
+		if pc := sc.add(); pc != nil {
+			return pc, nil
+		}
+	}
+	conn, err := open()
+	if err != nil {
+		return nil, err
+	}
+	if p.opts.Unshared != nil && p.opts.Unshared(conn) {
+		return conn, nil
+	}
+	sc := &sharedConn[K]{pool: p, key: key, conn: conn}
+	p.conns[key] = append(p.conns[key], sc)
+	go sc.readLoop()
+	return sc.add(), nil
+}
+
+// Removes a shared connection so that it isn't handed out again. Must be
+// called with p.mu held.
+func (p *Pool[K]) remove(sc *sharedConn[K]) {
+	conns := p.conns[sc.key]
+	for i, c := range conns {
+		if c == sc {
+			conns = append(conns[:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(conns) == 0 {
+		delete(p.conns, sc.key)
+	} else {
+		p.conns[sc.key] = conns
+	}
+}
+
+// A reply read from a shared connection.
+type readResult struct {
+	pkt  *backend.Packet
+	peer net.Addr
+}
+
+// A backend connection shared by several users. Fields other than conn are
+// guarded by pool.mu.
+type sharedConn[K comparable] struct {
+	pool   *Pool[K]
+	key    K
+	conn   backend.Conn
+	users  [Slots]*pooledConn[K]
+	nUsers int
+	next   int  // The next slot to try. Slots are reused as late as possible.
+	closed bool // Closed after the last user left.
+}
+
+// Adds a user in a free slot. Returns nil if the connection is full. Must be
+// called with pool.mu held.
+func (sc *sharedConn[K]) add() *pooledConn[K] {
+	if sc.nUsers == Slots {
+		return nil
+	}
+	for sc.users[sc.next] != nil {
+		sc.next = (sc.next + 1) % Slots
+	}
+	pc := &pooledConn[K]{
+		shared:  sc,
+		slot:    sc.next,
+		replies: make(chan readResult, sc.pool.opts.Replies),
+		readErr: make(chan error, 1),
+		done:    make(chan any),
+	}
+	sc.users[pc.slot] = pc
+	sc.nUsers++
+	sc.next = (sc.next + 1) % Slots
+	return pc
+}
+
+// Reads replies and hands each to the user whose slot its sequence number is
+// in. Replies for empty slots are dropped. If the connection fails, every user
+// gets the error, and it's removed from the pool.
+func (sc *sharedConn[K]) readLoop() {
+	for {
+		pkt, peer, err := sc.conn.ReadFrom(context.TODO())
+		if errors.Is(err, backend.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			sc.fail(err)
+			return
+		}
+		slot := pkt.Seq / SeqSpace
+		if pkt.Seq < 0 || slot >= Slots {
+			continue
+		}
+		sc.pool.mu.Lock()
+		pc := sc.users[slot]
+		sc.pool.mu.Unlock()
+		if pc == nil {
+			continue
+		}
+		reply := *pkt
+		reply.Seq %= SeqSpace
+		pc.deliver(readResult{pkt: &reply, peer: peer})
+	}
+}
+
+// Passes a read error on to all the users.
+func (sc *sharedConn[K]) fail(err error) {
+	sc.pool.mu.Lock()
+	defer sc.pool.mu.Unlock()
+	if sc.closed {
+		return
+	}
+	sc.pool.remove(sc)
+	for _, pc := range sc.users {
+		if pc != nil {
+			pc.readErr <- err
+		}
+	}
+}
+
+// Removes a user. Closes the connection if it was the last one.
+func (sc *sharedConn[K]) release(pc *pooledConn[K]) error {
+	sc.pool.mu.Lock()
+	sc.users[pc.slot] = nil
+	sc.nUsers--
+	if sc.nUsers > 0 {
+		sc.pool.mu.Unlock()
+		return nil
+	}
+	sc.closed = true
+	sc.pool.remove(sc)
+	sc.pool.mu.Unlock()
+	return sc.conn.Close()
+}
+
+// One user's view of a shared connection. The sequence numbers it sends and
+// receives must be less than SeqSpace.
+type pooledConn[K comparable] struct {
+	shared    *sharedConn[K]
+	slot      int
+	replies   chan readResult
+	readErr   chan error
+	done      chan any
+	closeOnce sync.Once
+}
+
+// Hands a reply to the user.
+func (pc *pooledConn[K]) deliver(rr readResult) {
+	if pc.shared.pool.opts.DropWhenFull {
+		select {
+		case pc.replies <- rr:
+		default:
+		}
+		return
+	}
+	select {
+	case pc.replies <- rr:
+	case <-pc.done:
+	}
+}
+
+// WriteTo implements [backend.Conn].
+func (pc *pooledConn[K]) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	if pkt.Seq < 0 || pkt.Seq >= SeqSpace {
+		return fmt.Errorf("sequence number out of range: %d", pkt.Seq)
+	}
+	shifted := *pkt
+	shifted.Seq += pc.slot * SeqSpace
+	opts = append(opts, backend.StreamOption{Stream: pc.slot})
+	return pc.shared.conn.WriteTo(&shifted, dest, opts...)
+}
+
+// ReadFrom implements [backend.Conn].
+func (pc *pooledConn[K]) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case rr := <-pc.replies:
+		return rr.pkt, rr.peer, nil
+	case err := <-pc.readErr:
+		return nil, nil, err
+	case <-ctx.Done():
+		return nil, nil, backend.ErrTimeout
+	case <-pc.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+// SocketStats implements [backend.StatsConn]. The statistics are for the
+// shared connection.
+func (pc *pooledConn[K]) SocketStats() (backend.SocketStats, error) {
+	return backend.GetSocketStats(pc.shared.conn)
+}
+
+// Close implements [backend.Conn]. The shared connection is closed along with
+// its last user.
+func (pc *pooledConn[K]) Close() error {
+	var err error
+	pc.closeOnce.Do(func() {
+		close(pc.done)
+		err = pc.shared.release(pc)
+	})
+	return err
+}
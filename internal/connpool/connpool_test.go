@@ -0,0 +1,231 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+)
+
+var peer = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+
+// A connection whose replies are fed in by the test. Records what's written.
+type fakeConn struct {
+	replies chan *backend.Packet
+	done    chan any
+
+	mu      sync.Mutex
+	written []int // Sequence numbers.
+	streams []int
+	closed  bool
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{replies: make(chan *backend.Packet, 100), done: make(chan any)}
+}
+
+func (c *fakeConn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	stream, _, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, pkt.Seq)
+	c.streams = append(c.streams, stream)
+	return nil
+}
+
+func (c *fakeConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case pkt := <-c.replies:
+		return pkt, peer, nil
+	case <-c.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Returns a function that opens fakeConns, and one that returns those opened
+// so far.
+func opener() (func() (backend.Conn, error), func() []*fakeConn) {
+	var mu sync.Mutex
+	var conns []*fakeConn
+	open := func() (backend.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := newFakeConn()
+		conns = append(conns, c)
+		return c, nil
+	}
+	return open, func() []*fakeConn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*fakeConn(nil), conns...)
+	}
+}
+
+func read(t *testing.T, conn backend.Conn) (*backend.Packet, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pkt, _, err := conn.ReadFrom(ctx)
+	return pkt, err
+}
+
+func TestGet_Shares(t *testing.T) {
+	open, opened := opener()
+	p := New[string](Options{})
+	a, err := p.Get("key", open)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	b, err := p.Get("key", open)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	other, err := p.Get("other", open)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if n := len(opened()); n != 2 {
+		t.Errorf("Opened %d connections (want 2)", n)
+	}
+	for _, c := range []backend.Conn{a, b, other} {
+		if err := c.Close(); err != nil {
+			t.Errorf("Close error: %v", err)
+		}
+	}
+	for i, c := range opened() {
+		if !c.isClosed() {
+			t.Errorf("Connection %d still open", i)
+		}
+	}
+}
+
+func TestGet_Full(t *testing.T) {
+	open, opened := opener()
+	p := New[string](Options{})
+	var conns []backend.Conn
+	for range Slots + 1 {
+		c, err := p.Get("key", open)
+		if err != nil {
+			t.Fatalf("Get error: %v", err)
+		}
+		conns = append(conns, c)
+	}
+	if n := len(opened()); n != 2 {
+		t.Errorf("Opened %d connections (want 2)", n)
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+func TestGet_OpenError(t *testing.T) {
+	wantErr := errors.New("no")
+	p := New[string](Options{})
+	if _, err := p.Get("key", func() (backend.Conn, error) { return nil, wantErr }); !errors.Is(err, wantErr) {
+		t.Errorf("Get error = %v (want %v)", err, wantErr)
+	}
+}
+
+func TestGet_Unshared(t *testing.T) {
+	open, opened := opener()
+	p := New[string](Options{Unshared: func(backend.Conn) bool { return true }})
+	a, err := p.Get("key", open)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer a.Close()
+	b, err := p.Get("key", open)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer b.Close()
+	conns := opened()
+	if len(conns) != 2 || a != conns[0] || b != conns[1] {
+		t.Errorf("Got %v and %v (want the opened connections %v)", a, b, conns)
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	open, opened := opener()
+	p := New[string](Options{Replies: 1})
+	a, _ := p.Get("key", open)
+	defer a.Close()
+	b, _ := p.Get("key", open)
+	defer b.Close()
+	fc := opened()[0]
+
+	for _, c := range []backend.Conn{a, b} {
+		if err := c.WriteTo(&backend.Packet{Seq: 3}, peer); err != nil {
+			t.Errorf("WriteTo error: %v", err)
+		}
+		if err := c.WriteTo(&backend.Packet{Seq: SeqSpace}, peer); err == nil {
+			t.Errorf("WriteTo(%d) succeeded (want error)", SeqSpace)
+		}
+	}
+	if diff := cmp.Diff([]int{3, SeqSpace + 3}, fc.written); diff != "" {
+		t.Errorf("Wrong sequence numbers written (-want, +got):\n%v", diff)
+	}
+	if diff := cmp.Diff([]int{0, 1}, fc.streams); diff != "" {
+		t.Errorf("Wrong streams (-want, +got):\n%v", diff)
+	}
+
+	fc.replies <- &backend.Packet{Seq: SeqSpace + 5}
+	pkt, err := read(t, b)
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v", err)
+	}
+	if pkt.Seq != 5 {
+		t.Errorf("Read sequence number %d (want 5)", pkt.Seq)
+	}
+}
+
+func TestDropWhenFull(t *testing.T) {
+	open, opened := opener()
+	p := New[string](Options{Replies: 1, DropWhenFull: true})
+	slow, _ := p.Get("key", open)
+	defer slow.Close()
+	fast, _ := p.Get("key", open)
+	defer fast.Close()
+	fc := opened()[0]
+
+	// The slow user's second reply is dropped instead of holding up the
+	// fast one's.
+	fc.replies <- &backend.Packet{Seq: 1}
+	fc.replies <- &backend.Packet{Seq: 2}
+	fc.replies <- &backend.Packet{Seq: SeqSpace + 3}
+	if pkt, err := read(t, fast); err != nil || pkt.Seq != 3 {
+		t.Fatalf("ReadFrom = %v, %v (want sequence number 3)", pkt, err)
+	}
+	if pkt, err := read(t, slow); err != nil || pkt.Seq != 1 {
+		t.Fatalf("ReadFrom = %v, %v (want sequence number 1)", pkt, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if pkt, _, err := slow.ReadFrom(ctx); !errors.Is(err, backend.ErrTimeout) {
+		t.Errorf("ReadFrom = %v, %v (want timeout)", pkt, err)
+	}
+}
@@ -0,0 +1,415 @@
+// Package control serves an API that lets other programs drive a running
+// instance: adding and removing targets, starting traces and reading
+// statistics.
+//
+// The API is JSON-RPC 2.0 over a unix domain socket or a loopback TCP
+// connection. Each request and response is a single JSON object on its own
+// line. For example:
+//
+//	{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"example.com"}}
+//	{"jsonrpc":"2.0","id":1,"result":{"group":"example.com"}}
+//
+// The methods and their params and results are:
+//
+//	add_target     AddTargetParams     AddTargetResult
+//	remove_target  RemoveTargetParams  RemoveTargetResult
+//	start_trace    StartTraceParams    StartTraceResult
+//	get_stats      GetStatsParams      GetStatsResult
+//
+// Requests on a single connection are handled in order. As in JSON-RPC, a
+// request without an id is a notification, and gets no response. A line
+// that isn't JSON gets a parse error, and the connection is closed. That keeps
+// a web page from driving a TCP server with an HTTP request, whose first
+// line never parses.
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pcekm/vasily/internal/targetlist"
+)
+
+// Method names.
+const (
+	MethodAddTarget    = "add_target"
+	MethodRemoveTarget = "remove_target"
+	MethodStartTrace   = "start_trace"
+	MethodGetStats     = "get_stats"
+)
+
+// Error codes. The negative ones are defined by JSON-RPC.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+
+	// CodeFailed means the request was understood, but couldn't be carried
+	// out.
+	CodeFailed = 1
+)
+
+// The longest request accepted.
+const maxRequestLen = 1 << 16
+
+// AddTargetParams are the params for add_target.
+type AddTargetParams struct {
+	// Host is a hostname or IP address to ping, or to trace in trace mode.
+	// It may also be a spec with per-target options, as described in
+	// [targetlist.ParseSpec], except for interface and netns. Over TCP, the
+	// protocol can't be set either, so the defaults are used.
+	Host string `json:"host"`
+}
+
+// AddTargetResult is the result of add_target.
+type AddTargetResult struct {
+	// Group is the group of the target's rows.
+	Group string `json:"group"`
+}
+
+// RemoveTargetParams are the params for remove_target.
+type RemoveTargetParams struct {
+	// Group is the group to remove rows from.
+	Group string `json:"group"`
+
+	// Index, if set, removes only the row with that index. Otherwise, the
+	// whole group is removed.
+	Index *int `json:"index,omitempty"`
+}
+
+// RemoveTargetResult is the result of remove_target.
+type RemoveTargetResult struct {
+	// Removed is the number of rows removed.
+	Removed int `json:"removed"`
+}
+
+// StartTraceParams are the params for start_trace.
+type StartTraceParams struct {
+	// Host is a hostname or IP address to trace the path to.
+	Host string `json:"host"`
+}
+
+// StartTraceResult is the result of start_trace.
+type StartTraceResult struct {
+	// Group is the group of the rows for the path's hops.
+	Group string `json:"group"`
+}
+
+// GetStatsParams are the params for get_stats.
+type GetStatsParams struct {
+	// Group, if set, only returns rows in that group.
+	Group string `json:"group,omitempty"`
+}
+
+// GetStatsResult is the result of get_stats.
+type GetStatsResult struct {
+	Rows []RowStats `json:"rows"`
+}
+
+// RowStats holds the statistics for a single row. Latencies are in
+// milliseconds.
+type RowStats struct {
+	Group    string  `json:"group"`
+	Index    int     `json:"index"`
+	Host     string  `json:"host"`
+	Addr     string  `json:"addr"`
+	Label    string  `json:"label,omitempty"`
+	Paused   bool    `json:"paused"`
+	Sent     int     `json:"sent"`
+	Lost     int     `json:"lost"`
+	Loss     float64 `json:"loss"` // Fraction of pings lost.
+	LastMs   float64 `json:"last_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MinMs    float64 `json:"min_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	P50Ms    float64 `json:"p50_ms"`
+	P95Ms    float64 `json:"p95_ms"`
+	P99Ms    float64 `json:"p99_ms"`
+	JitterMs float64 `json:"jitter_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+	Outages  int     `json:"outages"`
+}
+
+// Handler carries out requests. Its methods may be called concurrently.
+type Handler interface {
+	AddTarget(AddTargetParams) (AddTargetResult, error)
+	RemoveTarget(RemoveTargetParams) (RemoveTargetResult, error)
+	StartTrace(StartTraceParams) (StartTraceResult, error)
+	GetStats(GetStatsParams) (GetStatsResult, error)
+}
+
+// Request is a JSON-RPC request.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC response. Exactly one of Result or Error is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC error.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Server serves the control API.
+type Server struct {
+	handler Handler
+	ln      net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listen starts listening for connections. The address is either the path of
+// a unix domain socket, which must not already exist, or a TCP host:port on a
+// loopback address. A unix domain socket is only accessible to the current
+// user, but any local user can connect over TCP, so targets added that way
+// may only use the default protocols.
+func Listen(addr string, h Handler) (*Server, error) {
+	ln, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	if !isUnixAddr(addr) {
+		h = tcpHandler{h}
+	}
+	return &Server{
+		handler: h,
+		ln:      ln,
+		conns:   make(map[net.Conn]bool),
+	}, nil
+}
+
+// Returns true if addr is the path of a unix domain socket instead of a TCP
+// address.
+func isUnixAddr(addr string) bool {
+	return strings.Contains(addr, "/") || !strings.Contains(addr, ":")
+}
+
+// Wraps the handler of a TCP server. The privileged helper opens sockets for
+// any protocol it's asked to, so other users can't choose one.
+type tcpHandler struct {
+	Handler
+}
+
+func (h tcpHandler) AddTarget(p AddTargetParams) (AddTargetResult, error) {
+	// Specs that don't parse are left for the handler to report.
+	if host, err := targetlist.ParseSpec(p.Host); err == nil && host.Options.PingBackend != "" {
+		return AddTargetResult{}, &Error{Code: CodeInvalidParams, Message: "protocol option isn't allowed over TCP"}
+	}
+	return h.Handler.AddTarget(p)
+}
+
+// Serializes umask changes, which affect the whole process.
+var umaskMu sync.Mutex
+
+// Listens on a unix domain socket that only this user can connect to. It's
+// created with those permissions, so there's no moment when others could
+// connect.
+func listenUnix(addr string) (net.Listener, error) {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0o177)
+	defer syscall.Umask(old)
+	return net.Listen("unix", addr)
+}
+
+func listen(addr string) (net.Listener, error) {
+	if isUnixAddr(addr) {
+		return listenUnix(addr)
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if !tcpAddr.IP.IsLoopback() {
+		return nil, fmt.Errorf("not a loopback address: %v", addr)
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Serve accepts connections until the server is closed, and then returns
+// nil.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Records an open connection, which must be served in a goroutine that calls
+// s.wg.Done when it's finished. Returns false if the server has been closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = true
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	conn.Close()
+}
+
+// Close stops listening, closes all connections, and waits for requests in
+// progress to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	err := s.ln.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Handles requests on a connection until it's closed.
+func (s *Server) serveConn(conn net.Conn) {
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(make([]byte, 4096), maxRequestLen)
+	enc := json.NewEncoder(conn)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		resp, ok := s.handle(line)
+		if !ok {
+			continue
+		}
+		if err := enc.Encode(resp); err != nil {
+			log.Printf("Control: error writing response: %v", err)
+			return
+		}
+		if resp.Error != nil && resp.Error.Code == CodeParseError {
+			return
+		}
+	}
+	if err := sc.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("Control: error reading request: %v", err)
+	}
+}
+
+// Handles a single request. Returns false if there's no response because the
+// request is a notification.
+func (s *Server) handle(line []byte) (Response, bool) {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(nil, CodeParseError, err.Error()), true
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, CodeInvalidRequest, "invalid request"), true
+	}
+	resp := s.dispatch(req)
+	return resp, len(req.ID) > 0
+}
+
+// Calls the handler method for a request.
+func (s *Server) dispatch(req Request) Response {
+	var res any
+	var err error
+	switch req.Method {
+	case MethodAddTarget:
+		res, err = call(req.Params, s.handler.AddTarget)
+	case MethodRemoveTarget:
+		res, err = call(req.Params, s.handler.RemoveTarget)
+	case MethodStartTrace:
+		res, err = call(req.Params, s.handler.StartTrace)
+	case MethodGetStats:
+		res, err = call(req.Params, s.handler.GetStats)
+	default:
+		return errorResponse(req.ID, CodeMethodNotFound, fmt.Sprintf("unknown method %q", req.Method))
+	}
+	if err != nil {
+		var rpcErr *Error
+		if errors.As(err, &rpcErr) {
+			return errorResponse(req.ID, rpcErr.Code, rpcErr.Message)
+		}
+		return errorResponse(req.ID, CodeFailed, err.Error())
+	}
+	return Response{JSONRPC: "2.0", ID: idOrNull(req.ID), Result: res}
+}
+
+// Decodes params and calls a handler method with them.
+func call[P, R any](params json.RawMessage, f func(P) (R, error)) (any, error) {
+	var p P
+	if len(params) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(params))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+		}
+	}
+	return f(p)
+}
+
+func errorResponse(id json.RawMessage, code int, msg string) Response {
+	return Response{
+		JSONRPC: "2.0",
+		ID:      idOrNull(id),
+		Error:   &Error{Code: code, Message: msg},
+	}
+}
+
+// Responses always have an id, which is null if the request's couldn't be
+// read.
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
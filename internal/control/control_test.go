@@ -0,0 +1,317 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+// A handler that records its calls.
+type fakeHandler struct {
+	mu      sync.Mutex
+	added   []string
+	removed []RemoveTargetParams
+}
+
+func (h *fakeHandler) AddTarget(p AddTargetParams) (AddTargetResult, error) {
+	if p.Host == "" {
+		return AddTargetResult{}, errors.New("no host")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.added = append(h.added, p.Host)
+	return AddTargetResult{Group: p.Host}, nil
+}
+
+func (h *fakeHandler) RemoveTarget(p RemoveTargetParams) (RemoveTargetResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removed = append(h.removed, p)
+	return RemoveTargetResult{Removed: 1}, nil
+}
+
+func (h *fakeHandler) StartTrace(p StartTraceParams) (StartTraceResult, error) {
+	return StartTraceResult{Group: "192.0.2.1"}, nil
+}
+
+func (h *fakeHandler) GetStats(p GetStatsParams) (GetStatsResult, error) {
+	return GetStatsResult{Rows: []RowStats{{Group: p.Group, Host: "example.com", Sent: 10, Lost: 1, Loss: 0.1}}}, nil
+}
+
+// Starts a server, and returns a connection to it.
+func startServer(t *testing.T, addr string, h Handler) net.Conn {
+	t.Helper()
+	srv, err := Listen(addr, h)
+	if err != nil {
+		t.Fatalf("Listen(%q): %v", addr, err)
+	}
+	done := make(chan error)
+	go func() { done <- srv.Serve() }()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("Close error: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Serve error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for Serve to return")
+		}
+	})
+	conn, err := net.Dial(srv.Addr().Network(), srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+// Sends a line, and returns the decoded response.
+func roundTrip(t *testing.T, conn net.Conn, rd *bufio.Reader, line string) map[string]any {
+	t.Helper()
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	resp, err := rd.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	var res map[string]any
+	if err := json.Unmarshal(resp, &res); err != nil {
+		t.Fatalf("Error decoding %q: %v", resp, err)
+	}
+	return res
+}
+
+func TestServer(t *testing.T) {
+	cases := []struct {
+		Name string
+		Req  string
+		Want map[string]any
+	}{
+		{
+			Name: "AddTarget",
+			Req:  `{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"example.com"}}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 1.0, "result": map[string]any{"group": "example.com"}},
+		},
+		{
+			Name: "RemoveTarget",
+			Req:  `{"jsonrpc":"2.0","id":"a","method":"remove_target","params":{"group":"example.com","index":2}}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": "a", "result": map[string]any{"removed": 1.0}},
+		},
+		{
+			Name: "StartTrace",
+			Req:  `{"jsonrpc":"2.0","id":3,"method":"start_trace","params":{"host":"192.0.2.1"}}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 3.0, "result": map[string]any{"group": "192.0.2.1"}},
+		},
+		{
+			Name: "GetStats",
+			Req:  `{"jsonrpc":"2.0","id":4,"method":"get_stats"}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 4.0, "result": map[string]any{"rows": []any{
+				map[string]any{
+					"group": "", "index": 0.0, "host": "example.com", "addr": "", "paused": false,
+					"sent": 10.0, "lost": 1.0, "loss": 0.1, "last_ms": 0.0, "avg_ms": 0.0, "min_ms": 0.0, "max_ms": 0.0,
+					"p50_ms": 0.0, "p95_ms": 0.0, "p99_ms": 0.0, "jitter_ms": 0.0, "stddev_ms": 0.0,
+					"outages": 0.0,
+				},
+			}}},
+		},
+		{
+			Name: "HandlerError",
+			Req:  `{"jsonrpc":"2.0","id":5,"method":"add_target","params":{}}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 5.0, "error": map[string]any{"code": 1.0, "message": "no host"}},
+		},
+		{
+			Name: "InvalidRequest",
+			Req:  `{"id":6,"method":"get_stats"}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 6.0, "error": map[string]any{"code": -32600.0, "message": "invalid request"}},
+		},
+		{
+			Name: "UnknownMethod",
+			Req:  `{"jsonrpc":"2.0","id":7,"method":"reboot"}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 7.0, "error": map[string]any{"code": -32601.0, "message": `unknown method "reboot"`}},
+		},
+		{
+			Name: "InvalidParams",
+			Req:  `{"jsonrpc":"2.0","id":8,"method":"add_target","params":{"hots":"example.com"}}`,
+			Want: map[string]any{"jsonrpc": "2.0", "id": 8.0, "error": map[string]any{"code": -32602.0, "message": `json: unknown field "hots"`}},
+		},
+	}
+	for _, network := range []string{"unix", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			addr := "127.0.0.1:0"
+			if network == "unix" {
+				addr = filepath.Join(t.TempDir(), "control.sock")
+			}
+			conn := startServer(t, addr, &fakeHandler{})
+			rd := bufio.NewReader(conn)
+			for _, c := range cases {
+				t.Run(c.Name, func(t *testing.T) {
+					got := roundTrip(t, conn, rd, c.Req)
+					if diff := cmp.Diff(c.Want, got); diff != "" {
+						t.Errorf("Wrong response (-want, +got):\n%v", diff)
+					}
+				})
+			}
+		})
+	}
+}
+
+// A line that isn't JSON, like the start of an HTTP request from a web page,
+// gets a parse error and closes the connection.
+func TestServer_ParseError(t *testing.T) {
+	h := &fakeHandler{}
+	conn := startServer(t, "127.0.0.1:0", h)
+	rd := bufio.NewReader(conn)
+	got := roundTrip(t, conn, rd, "POST / HTTP/1.1")
+	want := map[string]any{"jsonrpc": "2.0", "id": nil, "error": map[string]any{"code": -32700.0, "message": "invalid character 'P' looking for beginning of value"}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong response (-want, +got):\n%v", diff)
+	}
+	conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"a.example"}}` + "\n"))
+	if line, err := rd.ReadBytes('\n'); err == nil {
+		t.Errorf("Read after parse error = %q (want closed connection)", line)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.added) > 0 {
+		t.Errorf("Added %v after parse error (want nothing)", h.added)
+	}
+}
+
+// Other users can connect over TCP, so they can't choose the protocol.
+func TestServer_TCPProtocol(t *testing.T) {
+	for _, host := range []string{"arp://192.0.2.1", "192.0.2.1?protocol=http"} {
+		t.Run(host, func(t *testing.T) {
+			h := &fakeHandler{}
+			conn := startServer(t, "127.0.0.1:0", h)
+			rd := bufio.NewReader(conn)
+			got := roundTrip(t, conn, rd, `{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"`+host+`"}}`)
+			want := map[string]any{"jsonrpc": "2.0", "id": 1.0, "error": map[string]any{"code": -32602.0, "message": "protocol option isn't allowed over TCP"}}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("Wrong response (-want, +got):\n%v", diff)
+			}
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if len(h.added) > 0 {
+				t.Errorf("Added %v over TCP (want nothing)", h.added)
+			}
+		})
+	}
+}
+
+// The protocol can be chosen over a unix domain socket, which only the
+// current user can connect to.
+func TestServer_UnixProtocol(t *testing.T) {
+	h := &fakeHandler{}
+	conn := startServer(t, filepath.Join(t.TempDir(), "control.sock"), h)
+	rd := bufio.NewReader(conn)
+	got := roundTrip(t, conn, rd, `{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"arp://192.0.2.1"}}`)
+	if got["error"] != nil {
+		t.Errorf("Got error response %v", got)
+	}
+}
+
+func TestListen_UnixMode(t *testing.T) {
+	addr := filepath.Join(t.TempDir(), "control.sock")
+	startServer(t, addr, &fakeHandler{})
+	fi, err := os.Stat(addr)
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	if got := fi.Mode().Perm(); got != 0o600 {
+		t.Errorf("Socket mode = %v (want %v)", got, os.FileMode(0o600))
+	}
+}
+
+func TestServer_Notification(t *testing.T) {
+	h := &fakeHandler{}
+	conn := startServer(t, filepath.Join(t.TempDir(), "control.sock"), h)
+	rd := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte(`{"jsonrpc":"2.0","method":"add_target","params":{"host":"a.example"}}` + "\n")); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	// The next response must belong to the next request, since the
+	// notification has none.
+	got := roundTrip(t, conn, rd, `{"jsonrpc":"2.0","id":1,"method":"add_target","params":{"host":"b.example"}}`)
+	if got["id"] != 1.0 {
+		t.Errorf("Got response %v (want id 1)", got)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if diff := cmp.Diff([]string{"a.example", "b.example"}, h.added); diff != "" {
+		t.Errorf("Wrong targets added (-want, +got):\n%v", diff)
+	}
+}
+
+func TestListen_NotLoopback(t *testing.T) {
+	srv, err := Listen("192.0.2.1:7070", &fakeHandler{})
+	if err == nil {
+		srv.Close()
+		t.Errorf("Listen succeeded on a non-loopback address")
+	}
+}
+
+func TestTargetsHandler(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	for i := range 2 {
+		key := targets.Key{Group: "path", Index: i + 1}
+		m.Feed(key, addr, 0, pinger.PingResult{Type: pinger.Success, Latency: 1500 * time.Microsecond})
+		m.Feed(key, addr, 1, pinger.PingResult{Type: pinger.Dropped})
+	}
+	h := TargetsHandler(m)
+
+	stats, err := h.GetStats(GetStatsParams{Group: "path"})
+	if err != nil {
+		t.Fatalf("GetStats error: %v", err)
+	}
+	if len(stats.Rows) != 2 {
+		t.Fatalf("GetStats returned %d rows (want 2)", len(stats.Rows))
+	}
+	row := stats.Rows[0]
+	if row.Index != 1 || row.Addr != "192.0.2.1" || row.Sent != 2 || row.Lost != 1 || row.Loss != 0.5 || row.AvgMs != 1.5 {
+		t.Errorf("Wrong stats: %+v", row)
+	}
+
+	idx := 2
+	if res, err := h.RemoveTarget(RemoveTargetParams{Group: "path", Index: &idx}); err != nil || res.Removed != 1 {
+		t.Errorf("RemoveTarget(index 2) = %v, %v (want 1 removed)", res, err)
+	}
+	if res, err := h.RemoveTarget(RemoveTargetParams{Group: "path"}); err != nil || res.Removed != 1 {
+		t.Errorf("RemoveTarget(group) = %v, %v (want 1 removed)", res, err)
+	}
+	if _, err := h.RemoveTarget(RemoveTargetParams{Group: "path"}); err == nil {
+		t.Errorf("RemoveTarget of missing group succeeded")
+	}
+}
+
+func TestTargetsHandler_SourceOptions(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	h := TargetsHandler(m)
+	for _, host := range []string{"192.0.2.1?interface=eth0", "192.0.2.1?netns=blue"} {
+		_, err := h.AddTarget(AddTargetParams{Host: host})
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+			t.Errorf("AddTarget(%q) error = %v (want code %d)", host, err, CodeInvalidParams)
+		}
+	}
+	if n := len(m.Targets()); n != 0 {
+		t.Errorf("Added %d targets (want 0)", n)
+	}
+}
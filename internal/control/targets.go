@@ -0,0 +1,120 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/targetlist"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
+)
+
+// TargetsHandler returns a handler that carries out requests with a target
+// manager.
+func TargetsHandler(m *targets.Manager) Handler {
+	return &targetsHandler{m: m}
+}
+
+type targetsHandler struct {
+	m *targets.Manager
+}
+
+func (h *targetsHandler) AddTarget(p AddTargetParams) (AddTargetResult, error) {
+	if p.Host == "" {
+		return AddTargetResult{}, errors.New("missing host")
+	}
+	host, err := targetlist.ParseSpec(p.Host)
+	if err != nil {
+		return AddTargetResult{}, err
+	}
+	// Other users may be able to connect over TCP, and the privileged helper
+	// opens sockets wherever it's asked to.
+	if host.Options.Interface != "" || host.Options.Netns != "" {
+		return AddTargetResult{}, &Error{Code: CodeInvalidParams, Message: "interface and netns options aren't allowed"}
+	}
+	addr, err := lookup.String(host.Host)
+	if err != nil {
+		return AddTargetResult{}, err
+	}
+	group, err := h.m.AddHost(host.Host, addr, host.Options)
+	if err != nil {
+		return AddTargetResult{}, err
+	}
+	return AddTargetResult{Group: group}, nil
+}
+
+func (h *targetsHandler) RemoveTarget(p RemoveTargetParams) (RemoveTargetResult, error) {
+	var res RemoveTargetResult
+	if p.Index == nil {
+		res.Removed = h.m.RemoveGroup(p.Group)
+	} else if h.m.Remove(targets.Key{Group: p.Group, Index: *p.Index}) {
+		res.Removed = 1
+	}
+	if res.Removed == 0 {
+		return res, fmt.Errorf("no such target: %v", p.Group)
+	}
+	return res, nil
+}
+
+func (h *targetsHandler) StartTrace(p StartTraceParams) (StartTraceResult, error) {
+	if p.Host == "" {
+		return StartTraceResult{}, errors.New("missing host")
+	}
+	addr, err := lookup.String(p.Host)
+	if err != nil {
+		return StartTraceResult{}, err
+	}
+	group, err := h.m.Trace(addr)
+	if err != nil {
+		return StartTraceResult{}, err
+	}
+	return StartTraceResult{Group: group}, nil
+}
+
+func (h *targetsHandler) GetStats(p GetStatsParams) (GetStatsResult, error) {
+	res := GetStatsResult{Rows: []RowStats{}}
+	for _, t := range h.m.Targets() {
+		if p.Group != "" && t.Group != p.Group {
+			continue
+		}
+		res.Rows = append(res.Rows, rowStats(t))
+	}
+	return res, nil
+}
+
+// Converts a target to its API representation.
+func rowStats(t targets.Target) RowStats {
+	r := view.FromTarget(t, nil)
+	st := r.Stats
+	rs := RowStats{
+		Group:    r.Group,
+		Index:    r.Index,
+		Host:     r.Host,
+		Addr:     util.IP(r.Addr).String(),
+		Label:    r.Label,
+		Paused:   r.Paused,
+		Sent:     st.N,
+		Lost:     st.Failures,
+		LastMs:   toMs(st.LastLatency),
+		AvgMs:    toMs(st.AvgLatency),
+		MinMs:    toMs(st.MinLatency),
+		MaxMs:    toMs(st.MaxLatency),
+		P50Ms:    toMs(st.P50),
+		P95Ms:    toMs(st.P95),
+		P99Ms:    toMs(st.P99),
+		JitterMs: toMs(st.Jitter),
+		StdDevMs: toMs(st.StdDev),
+		Outages:  r.Outages,
+	}
+	if st.N > 0 {
+		rs.Loss = st.PacketLoss()
+	}
+	return rs
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
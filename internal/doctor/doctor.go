@@ -0,0 +1,194 @@
+// Package doctor checks that the system has what vasily needs to send pings,
+// and explains how to fix what's missing. It checks the privileges pings need
+// in this build, the Linux ping_group_range sysctl, IPv6, the privileged helper,
+// and then pings loopback with every backend.
+//
+// The checks run after [privsep.Initialize], like everything else. A helper
+// that can't be started at all stops the program before they get a chance to
+// report it, with the error that stopped it.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/privsep"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Status is the outcome of a check.
+type Status int
+
+const (
+	// Pass means everything checked is in order.
+	Pass Status = iota
+
+	// Warn means pings work, but something they might need is missing.
+	Warn
+
+	// Fail means something pings need is missing.
+	Fail
+
+	// Skip means the check doesn't apply here.
+	Skip
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Warn:
+		return "WARN"
+	case Fail:
+		return "FAIL"
+	case Skip:
+		return "SKIP"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(s))
+	}
+}
+
+// Result is the result of a check.
+type Result struct {
+	// Name is what was checked.
+	Name string
+
+	Status Status
+
+	// Detail describes what was found.
+	Detail string
+
+	// Advice explains how to fix a warning or failure. It may be several
+	// lines long.
+	Advice string
+}
+
+// A check of one thing.
+type check func(ctx context.Context) Result
+
+// Run runs every check and prints the results to w, followed by a summary.
+// Returns the number of checks that failed.
+func Run(ctx context.Context, w io.Writer) int {
+	return run(ctx, w, checks())
+}
+
+// Returns the checks to run, in the order they're printed.
+func checks() []check {
+	cs := []check{checkPrivileges, checkPingGroupRange, checkIPv6, checkPrivsep}
+	for _, be := range backend.Names() {
+		for _, v := range []util.IPVersion{util.IPv4, util.IPv6} {
+			cs = append(cs, func(ctx context.Context) Result { return checkLoopback(ctx, be, v) })
+		}
+	}
+	return cs
+}
+
+// Runs checks concurrently, since the pings take a while, but prints them in
+// order.
+func run(ctx context.Context, w io.Writer, checks []check) int {
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c(ctx)
+		}()
+	}
+	wg.Wait()
+
+	counts := make(map[Status]int)
+	for _, r := range results {
+		counts[r.Status]++
+		fmt.Fprintf(w, "%v  %s: %s\n", r.Status, r.Name, r.Detail)
+		if r.Advice != "" && r.Status != Pass && r.Status != Skip {
+			for _, line := range strings.Split(r.Advice, "\n") {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+		}
+	}
+	fmt.Fprintf(w, "\n%d passed, %d warnings, %d failed, %d skipped\n",
+		counts[Pass], counts[Warn], counts[Fail], counts[Skip])
+	return counts[Fail]
+}
+
+// Checks that IPv6 is available, and that there's an address to reach other
+// hosts with.
+func checkIPv6(ctx context.Context) Result {
+	res := Result{Name: "IPv6"}
+	if err := ipv6Loopback(); err != nil {
+		res.Status = Warn
+		res.Detail = fmt.Sprintf("unavailable: %v", err)
+		res.Advice = "IPv6 hosts can't be pinged until IPv6 is enabled."
+		return res
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		res.Status = Warn
+		res.Detail = fmt.Sprintf("can't list addresses: %v", err)
+		return res
+	}
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.To4() == nil && ipn.IP.IsGlobalUnicast() {
+			res.Detail = fmt.Sprintf("available, with address %v", ipn.IP)
+			return res
+		}
+	}
+	res.Status = Warn
+	res.Detail = "available, but no interface has a global address"
+	res.Advice = "Only loopback and link-local IPv6 hosts can be pinged."
+	return res
+}
+
+// Returns an error if the IPv6 loopback address can't be used.
+func ipv6Loopback() error {
+	c, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+// Opens and closes an icmp socket in this process. A variable for testing.
+var openICMP = func() error {
+	conn, err := backend.New("icmp", util.IPv4)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Checks that the privileged helper is running, if there's meant to be one.
+// Without one, it's only not needed if this process can open ICMP sockets
+// itself.
+func checkPrivsep(ctx context.Context) Result {
+	res := Result{Name: "Privileged helper"}
+	st := privsep.CurrentStatus()
+	switch {
+	case !st.Enabled:
+		if err := openICMP(); err != nil {
+			res.Status = Fail
+			res.Detail = fmt.Sprintf("not running, and this process can't open ICMP sockets: %v", err)
+			res.Advice = "The icmp backend can't ping until the privileges or ping_group_range check passes."
+			return res
+		}
+		res.Status = Skip
+		res.Detail = "not needed; this process can open ICMP sockets itself"
+	case !st.Alive:
+		res.Status = Fail
+		res.Detail = "exited, and hasn't been restarted yet"
+		res.Advice = "The log has the errors it exited with."
+	case st.Restarts > 0:
+		res.Status = Warn
+		res.Detail = fmt.Sprintf("running, after %d restarts", st.Restarts)
+		res.Advice = "The log has the errors it exited with."
+	default:
+		res.Detail = "running"
+	}
+	return res
+}
@@ -0,0 +1,62 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRun(t *testing.T) {
+	checks := []check{
+		func(context.Context) Result { return Result{Name: "A", Detail: "fine", Advice: "Not printed."} },
+		func(context.Context) Result {
+			return Result{Name: "B", Status: Fail, Detail: "broken", Advice: "Fix it with:\n    fix"}
+		},
+		func(context.Context) Result { return Result{Name: "C", Status: Warn, Detail: "odd"} },
+		func(context.Context) Result { return Result{Name: "D", Status: Skip, Detail: "n/a"} },
+	}
+	var sb strings.Builder
+	failed := run(context.Background(), &sb, checks)
+	want := `PASS  A: fine
+FAIL  B: broken
+      Fix it with:
+          fix
+WARN  C: odd
+SKIP  D: n/a
+
+1 passed, 1 warnings, 1 failed, 1 skipped
+`
+	if diff := cmp.Diff(want, sb.String()); diff != "" {
+		t.Errorf("Wrong output (-want, +got):\n%v", diff)
+	}
+	if failed != 1 {
+		t.Errorf("run() = %d (want 1)", failed)
+	}
+}
+
+func TestCheckPrivsep_Unneeded(t *testing.T) {
+	cases := []struct {
+		name    string
+		openErr error
+		want    Status
+	}{
+		{name: "CanOpen", want: Skip},
+		{name: "CantOpen", openErr: errors.New("permission denied"), want: Fail},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func(f func() error) { openICMP = f }(openICMP)
+			openICMP = func() error { return c.openErr }
+			res := checkPrivsep(context.Background())
+			if res.Status != c.want {
+				t.Errorf("checkPrivsep() = %v %q (want %v)", res.Status, res.Detail, c.want)
+			}
+			if c.openErr != nil && !strings.Contains(res.Detail, c.openErr.Error()) {
+				t.Errorf("Detail %q doesn't include the error %q", res.Detail, c.openErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+const (
+	// Pings sent to loopback by each backend. One reply is enough to pass.
+	loopbackPings = 2
+
+	// How long to wait for each reply. Loopback replies take microseconds.
+	loopbackTimeout = time.Second
+)
+
+// Starts a server on ip for a backend to ping. Returns its port, and a
+// function that stops it.
+type serveFunc func(ip net.IP) (port int, stop func(), err error)
+
+var (
+	// Backends that need something on loopback to answer them. The rest are
+	// answered by the kernel.
+	servers = map[backend.Name]serveFunc{
+		"dns":  serveDNS,
+		"http": serveHTTP,
+	}
+
+	// Backends that can't be checked on loopback, and why.
+	untestable = map[backend.Name]string{
+		"arp": "loopback has no link layer to probe",
+	}
+)
+
+// Pings the loopback address for ipVer with a backend.
+func checkLoopback(ctx context.Context, be backend.Name, ipVer util.IPVersion) Result {
+	res := Result{Name: fmt.Sprintf("Loopback %s %v", be, ipVer)}
+	if why, ok := untestable[be]; ok {
+		res.Status = Skip
+		res.Detail = why
+		return res
+	}
+	if ipVer == util.IPv6 {
+		if err := ipv6Loopback(); err != nil {
+			res.Status = Skip
+			res.Detail = "IPv6 is unavailable"
+			return res
+		}
+	}
+
+	dest := &net.UDPAddr{IP: util.Choose(ipVer, net.IPv4(127, 0, 0, 1), net.IPv6loopback)}
+	if serve, ok := servers[be]; ok {
+		port, stop, err := serve(dest.IP)
+		if err != nil {
+			res.Status = Fail
+			res.Detail = fmt.Sprintf("can't start a server to ping: %v", err)
+			return res
+		}
+		defer stop()
+		dest.Port = port
+	}
+
+	p, err := pinger.New(be, ipVer, dest, &pinger.Options{
+		NPings:  loopbackPings,
+		Timeout: loopbackTimeout,
+	})
+	if err != nil {
+		res.Status = Fail
+		res.Detail = fmt.Sprintf("can't open a connection: %v", err)
+		res.Advice = "The checks above may say why."
+		return res
+	}
+	defer p.Close()
+	p.Run(ctx)
+
+	st := p.Stats()
+	if st.N > st.Failures {
+		res.Detail = fmt.Sprintf("%d of %d pings answered", st.N-st.Failures, st.N)
+		return res
+	}
+	res.Status = Fail
+	res.Detail = fmt.Sprintf("none of %d pings answered", st.N)
+	res.Advice = "Check for a firewall rule dropping them on the loopback interface."
+	return res
+}
+
+// Answers every DNS query with its own header marked as a response, which
+// the dns backend counts as a reply.
+func serveDNS(ip net.IP) (int, func(), error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: ip})
+	if err != nil {
+		return 0, nil, err
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			// The header is 12 bytes. The high bit of the third is QR, which
+			// is set in responses.
+			if n < 12 {
+				continue
+			}
+			buf[2] |= 0x80
+			conn.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).Port, func() { conn.Close() }, nil
+}
+
+// Answers every HTTP request with an empty page.
+func serveHTTP(ip net.IP) (int, func(), error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: ip})
+	if err != nil {
+		return 0, nil, err
+	}
+	srv := &nethttp.Server{
+		Handler: nethttp.HandlerFunc(func(w nethttp.ResponseWriter, _ *nethttp.Request) {
+			w.WriteHeader(nethttp.StatusNoContent)
+		}),
+	}
+	go srv.Serve(l)
+	return l.Addr().(*net.TCPAddr).Port, func() { srv.Close() }, nil
+}
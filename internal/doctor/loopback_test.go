@@ -0,0 +1,41 @@
+package doctor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	_ "github.com/pcekm/vasily/internal/backend/dns"
+	_ "github.com/pcekm/vasily/internal/backend/http"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	cases := []struct {
+		Backend backend.Name
+		Want    Result
+	}{
+		{
+			Backend: "dns",
+			Want:    Result{Name: "Loopback dns IPv4", Detail: "2 of 2 pings answered"},
+		},
+		{
+			Backend: "http",
+			Want:    Result{Name: "Loopback http IPv4", Detail: "2 of 2 pings answered"},
+		},
+		{
+			Backend: "arp",
+			Want:    Result{Name: "Loopback arp IPv4", Status: Skip, Detail: "loopback has no link layer to probe"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(string(c.Backend), func(t *testing.T) {
+			t.Parallel()
+			got := checkLoopback(context.Background(), c.Backend, util.IPv4)
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong result (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+//go:build rawsock || !(darwin || linux)
+
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pcekm/vasily/internal/privsep"
+)
+
+// Pings are sent on raw sockets in this build.
+const rawSockets = true
+
+// Checks that raw sockets can be opened, either by the privileged helper or
+// in this process.
+func checkPrivileges(ctx context.Context) Result {
+	res := Result{Name: "Privileges"}
+	st := privsep.CurrentStatus()
+	switch {
+	case !st.Enabled:
+		// The helper is only skipped when this process has cap_net_raw.
+		res.Detail = "cap_net_raw allows raw sockets in this process"
+	case st.PrivilegesDropped:
+		res.Detail = "setuid; raw sockets are opened by the privileged helper"
+	case os.Geteuid() == 0:
+		res.Detail = "running as root; raw sockets are opened by the privileged helper"
+	default:
+		res.Status = Fail
+		res.Detail = "not root, setuid or given cap_net_raw, so raw sockets can't be opened"
+		res.Advice = privilegesAdvice()
+	}
+	return res
+}
+
+// Explains how to give the binary the privileges it needs.
+func privilegesAdvice() string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	setuid := fmt.Sprintf("setuid root with:\n    sudo chown 0:0 %s\n    sudo chmod u+s %s", exe, exe)
+	if runtime.GOOS == "linux" {
+		return fmt.Sprintf("Give it cap_net_raw with:\n    sudo setcap cap_net_raw+ep %s\nOr make it %s", exe, setuid)
+	}
+	return "Make it " + setuid
+}
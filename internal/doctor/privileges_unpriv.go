@@ -0,0 +1,25 @@
+//go:build !rawsock && (darwin || linux)
+
+package doctor
+
+import (
+	"context"
+	"os"
+)
+
+// Pings are sent on unprivileged ICMP sockets in this build.
+const rawSockets = false
+
+// Checks that no privileges are used, since none are needed. Running setuid
+// is caught by [privsep.Initialize], which refuses to start.
+func checkPrivileges(ctx context.Context) Result {
+	res := Result{Name: "Privileges"}
+	if os.Geteuid() == 0 {
+		res.Status = Warn
+		res.Detail = "running as root, which this build doesn't need"
+		res.Advice = "Run vasily as an ordinary user."
+		return res
+	}
+	res.Detail = "none needed; pings use unprivileged ICMP sockets"
+	return res
+}
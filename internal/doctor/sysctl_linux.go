@@ -0,0 +1,35 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pcekm/vasily/internal/privsep"
+)
+
+// Checks that the net.ipv4.ping_group_range sysctl lets this user open
+// unprivileged ICMP sockets, which this build needs for the icmp backend.
+func checkPingGroupRange(ctx context.Context) Result {
+	res := Result{Name: "ping_group_range"}
+	if rawSockets {
+		res.Status = Skip
+		res.Detail = "not needed; this build uses raw sockets"
+		return res
+	}
+	lo, hi, err := privsep.PingGroupRange()
+	if err != nil {
+		res.Status = Fail
+		res.Detail = fmt.Sprintf("can't read it: %v", err)
+		return res
+	}
+	if privsep.UnprivilegedPing() {
+		res.Detail = fmt.Sprintf("gids %d-%d include one of this user's groups", lo, hi)
+		return res
+	}
+	res.Status = Fail
+	res.Detail = fmt.Sprintf("gids %d-%d don't include any of this user's groups, so the icmp backend can't ping", lo, hi)
+	res.Advice = `Allow every group until the next reboot with:
+    sudo sysctl -w net.ipv4.ping_group_range="0 2147483647"
+Add it to /etc/sysctl.conf to keep it. Other backends, like udp, work without it.`
+	return res
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package doctor
+
+import "context"
+
+// The ping_group_range sysctl only exists on Linux.
+func checkPingGroupRange(ctx context.Context) Result {
+	return Result{Name: "ping_group_range", Status: Skip, Detail: "only on Linux"}
+}
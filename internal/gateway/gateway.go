@@ -0,0 +1,48 @@
+// Package gateway finds the default gateways in the routing table, so that
+// they can be pinged to tell problems with the local network from problems
+// further out. Linux's table is read with netlink, and the BSDs' and macOS's
+// with a routing sysctl.
+package gateway
+
+import (
+	"net"
+	"slices"
+)
+
+// Default returns the gateways of the default routes, IPv4 first. Link-local
+// IPv6 gateways are left out, since they can only be reached through the
+// interface they're on, and targets don't keep track of that. Returns an
+// empty list if there's no default route.
+func Default() ([]net.IP, error) {
+	gws, err := defaultGateways()
+	if err != nil {
+		return nil, err
+	}
+	return filter(gws), nil
+}
+
+// Removes duplicates and link-local gateways, and sorts IPv4 before IPv6.
+// Gateways of the same version stay in the order the routing table lists them.
+func filter(gws []net.IP) []net.IP {
+	var res []net.IP
+	for _, gw := range gws {
+		if gw.IsLinkLocalUnicast() && gw.To4() == nil {
+			continue
+		}
+		if slices.ContainsFunc(res, gw.Equal) {
+			continue
+		}
+		res = append(res, gw)
+	}
+	slices.SortStableFunc(res, func(a, b net.IP) int {
+		return version(a) - version(b)
+	})
+	return res
+}
+
+func version(ip net.IP) int {
+	if ip.To4() != nil {
+		return 4
+	}
+	return 6
+}
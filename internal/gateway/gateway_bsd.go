@@ -0,0 +1,62 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package gateway
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+func defaultGateways() ([]net.IP, error) {
+	rib, err := route.FetchRIB(unix.AF_UNSPEC, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error reading routes: %v", err)
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing routes: %v", err)
+	}
+	return gateways(msgs), nil
+}
+
+// Returns the gateways of the default routes in a routing table dump.
+func gateways(msgs []route.Message) []net.IP {
+	var res []net.IP
+	for _, m := range msgs {
+		rm, ok := m.(*route.RouteMessage)
+		if !ok || rm.Flags&(unix.RTF_UP|unix.RTF_GATEWAY) != unix.RTF_UP|unix.RTF_GATEWAY {
+			continue
+		}
+		if len(rm.Addrs) <= unix.RTAX_GATEWAY || !unspecified(rm.Addrs[unix.RTAX_DST]) {
+			continue
+		}
+		if len(rm.Addrs) > unix.RTAX_NETMASK && rm.Addrs[unix.RTAX_NETMASK] != nil && !unspecified(rm.Addrs[unix.RTAX_NETMASK]) {
+			continue
+		}
+		if ip := addrIP(rm.Addrs[unix.RTAX_GATEWAY]); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res
+}
+
+// Returns the IP address in a routing address, or nil if it isn't one.
+func addrIP(a route.Addr) net.IP {
+	switch a := a.(type) {
+	case *route.Inet4Addr:
+		return net.IP(a.IP[:]).To16()
+	case *route.Inet6Addr:
+		return net.IP(a.IP[:])
+	default:
+		return nil
+	}
+}
+
+// Returns true if a is the unspecified IPv4 or IPv6 address.
+func unspecified(a route.Addr) bool {
+	ip := addrIP(a)
+	return ip != nil && ip.IsUnspecified()
+}
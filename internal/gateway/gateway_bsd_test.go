@@ -0,0 +1,42 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package gateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+)
+
+// Makes a route's addresses, indexed by RTAX_*.
+func routeAddrs(dst, gw, mask route.Addr) []route.Addr {
+	addrs := make([]route.Addr, unix.RTAX_MAX)
+	addrs[unix.RTAX_DST] = dst
+	addrs[unix.RTAX_GATEWAY] = gw
+	addrs[unix.RTAX_NETMASK] = mask
+	return addrs
+}
+
+func TestGateways(t *testing.T) {
+	const flags = unix.RTF_UP | unix.RTF_GATEWAY
+	any4 := &route.Inet4Addr{}
+	any6 := &route.Inet6Addr{}
+	msgs := []route.Message{
+		// Default routes.
+		&route.RouteMessage{Flags: flags, Addrs: routeAddrs(any4, &route.Inet4Addr{IP: [4]byte{192, 0, 2, 1}}, nil)},
+		&route.RouteMessage{Flags: flags, Addrs: routeAddrs(any6, &route.Inet6Addr{IP: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}, any6)},
+		// Not a default route.
+		&route.RouteMessage{Flags: flags, Addrs: routeAddrs(any4, &route.Inet4Addr{IP: [4]byte{192, 0, 2, 9}}, &route.Inet4Addr{IP: [4]byte{255, 255, 255, 0}})},
+		// Directly connected.
+		&route.RouteMessage{Flags: unix.RTF_UP, Addrs: routeAddrs(any4, &route.LinkAddr{Name: "en0"}, nil)},
+		// Down.
+		&route.RouteMessage{Flags: unix.RTF_GATEWAY, Addrs: routeAddrs(any4, &route.Inet4Addr{IP: [4]byte{192, 0, 2, 10}}, nil)},
+	}
+	want := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")}
+	if diff := cmp.Diff(want, gateways(msgs)); diff != "" {
+		t.Errorf("Wrong gateways (-want, +got):\n%v", diff)
+	}
+}
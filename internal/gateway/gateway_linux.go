@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"fmt"
+	"iter"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Size of a struct rtnexthop, which precedes the attributes of each hop in
+// an RTA_MULTIPATH attribute.
+const sizeofRtNexthop = 8
+
+func defaultGateways() ([]net.IP, error) {
+	rib, err := syscall.NetlinkRIB(unix.RTM_GETROUTE, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("error reading routes: %v", err)
+	}
+	return parseRIB(rib)
+}
+
+// Returns the gateways of the default routes in the main table of a netlink
+// route dump.
+func parseRIB(rib []byte) ([]net.IP, error) {
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing routes: %v", err)
+	}
+	var res []net.IP
+	for _, m := range msgs {
+		if m.Header.Type != unix.RTM_NEWROUTE || len(m.Data) < unix.SizeofRtMsg {
+			continue
+		}
+		dstLen, table, typ := m.Data[1], uint32(m.Data[4]), m.Data[7]
+		if dstLen != 0 || typ != unix.RTN_UNICAST {
+			continue
+		}
+		var gws []net.IP
+		for typ, val := range attrs(m.Data[unix.SizeofRtMsg:]) {
+			switch typ {
+			case unix.RTA_TABLE:
+				if len(val) == 4 {
+					table = binary.NativeEndian.Uint32(val)
+				}
+			case unix.RTA_GATEWAY:
+				gws = append(gws, ipFrom(val))
+			case unix.RTA_MULTIPATH:
+				gws = append(gws, multipathGateways(val)...)
+			}
+		}
+		if table == unix.RT_TABLE_MAIN {
+			res = append(res, gws...)
+		}
+	}
+	return res, nil
+}
+
+// Returns the gateways of the hops in an RTA_MULTIPATH attribute.
+func multipathGateways(b []byte) []net.IP {
+	var res []net.IP
+	for len(b) >= sizeofRtNexthop {
+		n := int(binary.NativeEndian.Uint16(b))
+		if n < sizeofRtNexthop || n > len(b) {
+			break
+		}
+		for typ, val := range attrs(b[sizeofRtNexthop:n]) {
+			if typ == unix.RTA_GATEWAY {
+				res = append(res, ipFrom(val))
+			}
+		}
+		b = b[rtaAlign(n):]
+	}
+	return res
+}
+
+// Iterates over the route attributes in b, as type, value.
+func attrs(b []byte) iter.Seq2[uint16, []byte] {
+	return func(yield func(uint16, []byte) bool) {
+		for len(b) >= unix.SizeofRtAttr {
+			n := int(binary.NativeEndian.Uint16(b))
+			typ := binary.NativeEndian.Uint16(b[2:])
+			if n < unix.SizeofRtAttr || n > len(b) {
+				return
+			}
+			if !yield(typ, b[unix.SizeofRtAttr:n]) {
+				return
+			}
+			b = b[min(len(b), rtaAlign(n)):]
+		}
+	}
+}
+
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) &^ (unix.RTA_ALIGNTO - 1)
+}
+
+// Copies an address out of an attribute, so that it doesn't keep the whole
+// dump alive.
+func ipFrom(b []byte) net.IP {
+	return net.IP(append([]byte(nil), b...))
+}
@@ -0,0 +1,84 @@
+package gateway
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
+)
+
+// Encodes a route attribute, padded to its alignment.
+func rtAttr(typ uint16, val []byte) []byte {
+	b := make([]byte, rtaAlign(unix.SizeofRtAttr+len(val)))
+	binary.NativeEndian.PutUint16(b, uint16(unix.SizeofRtAttr+len(val)))
+	binary.NativeEndian.PutUint16(b[2:], typ)
+	copy(b[unix.SizeofRtAttr:], val)
+	return b
+}
+
+// Encodes a multipath hop.
+func nexthop(attrs ...[]byte) []byte {
+	b := make([]byte, sizeofRtNexthop)
+	for _, a := range attrs {
+		b = append(b, a...)
+	}
+	binary.NativeEndian.PutUint16(b, uint16(len(b)))
+	return b
+}
+
+// Encodes an RTM_NEWROUTE message.
+func routeMsg(family, dstLen, table, typ byte, attrs ...[]byte) []byte {
+	rtm := make([]byte, unix.SizeofRtMsg)
+	rtm[0], rtm[1], rtm[4], rtm[7] = family, dstLen, table, typ
+	for _, a := range attrs {
+		rtm = append(rtm, a...)
+	}
+	b := make([]byte, unix.SizeofNlMsghdr)
+	binary.NativeEndian.PutUint32(b, uint32(len(b)+len(rtm)))
+	binary.NativeEndian.PutUint16(b[4:], unix.RTM_NEWROUTE)
+	return append(b, rtm...)
+}
+
+func TestParseRIB(t *testing.T) {
+	gw4 := net.ParseIP("192.0.2.1").To4()
+	gw6 := net.ParseIP("2001:db8::1")
+	var rib []byte
+	for _, m := range [][]byte{
+		// A default route.
+		routeMsg(unix.AF_INET, 0, unix.RT_TABLE_MAIN, unix.RTN_UNICAST, rtAttr(unix.RTA_GATEWAY, gw4)),
+		// Not a default route.
+		routeMsg(unix.AF_INET, 24, unix.RT_TABLE_MAIN, unix.RTN_UNICAST, rtAttr(unix.RTA_GATEWAY, net.IP{192, 0, 2, 9})),
+		// Another table.
+		routeMsg(unix.AF_INET, 0, 100, unix.RTN_UNICAST, rtAttr(unix.RTA_GATEWAY, net.IP{192, 0, 2, 10})),
+		// A table too large for the header.
+		routeMsg(unix.AF_INET, 0, unix.RT_TABLE_COMPAT, unix.RTN_UNICAST,
+			rtAttr(unix.RTA_TABLE, binary.NativeEndian.AppendUint32(nil, 1000)),
+			rtAttr(unix.RTA_GATEWAY, net.IP{192, 0, 2, 11})),
+		// Not unicast.
+		routeMsg(unix.AF_INET, 0, unix.RT_TABLE_MAIN, unix.RTN_UNREACHABLE),
+		// Multipath.
+		routeMsg(unix.AF_INET6, 0, unix.RT_TABLE_MAIN, unix.RTN_UNICAST,
+			rtAttr(unix.RTA_MULTIPATH, append(
+				nexthop(rtAttr(unix.RTA_GATEWAY, gw6)),
+				nexthop(rtAttr(unix.RTA_GATEWAY, net.ParseIP("2001:db8::2")))...))),
+	} {
+		rib = append(rib, m...)
+	}
+
+	got, err := parseRIB(rib)
+	if err != nil {
+		t.Fatalf("parseRIB error: %v", err)
+	}
+	want := []net.IP{gw4, gw6, net.ParseIP("2001:db8::2")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong gateways (-want, +got):\n%v", diff)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	if _, err := Default(); err != nil {
+		t.Errorf("Default error: %v", err)
+	}
+}
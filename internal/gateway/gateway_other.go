@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+func defaultGateways() ([]net.IP, error) {
+	return nil, fmt.Errorf("not supported on %s", runtime.GOOS)
+}
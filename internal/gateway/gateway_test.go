@@ -0,0 +1,24 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func ips(ss ...string) []net.IP {
+	var res []net.IP
+	for _, s := range ss {
+		res = append(res, net.ParseIP(s))
+	}
+	return res
+}
+
+func TestFilter(t *testing.T) {
+	got := filter(ips("2001:db8::1", "192.0.2.1", "fe80::1", "192.0.2.1", "198.51.100.1"))
+	want := ips("192.0.2.1", "198.51.100.1", "2001:db8::1")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong gateways (-want, +got):\n%v", diff)
+	}
+}
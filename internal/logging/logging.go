@@ -0,0 +1,244 @@
+// Package logging is a leveled, rate-limited log. The most recent entries are
+// kept in memory so the text UI can show them, and everything at or above the
+// minimum level also goes to an output, which is usually a file.
+//
+// Messages are rate limited by their format string. Past a burst, messages
+// with the same format are suppressed, and the next one that gets through
+// says how many were. This keeps a read loop that fails on every packet from
+// burying everything else.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the importance of a log entry.
+type Level int
+
+// Values for Level.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "DEBUG"
+	case Info:
+		return "INFO"
+	case Warn:
+		return "WARN"
+	case Error:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(l))
+	}
+}
+
+// ParseLevel parses a level name, in any case.
+func ParseLevel(s string) (Level, error) {
+	for l := Debug; l <= Error; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Entry is a logged message.
+type Entry struct {
+	Time  time.Time
+	Level Level
+	Msg   string
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%s %-5v %s", e.Time.Format("2006/01/02 15:04:05"), e.Level, e.Msg)
+}
+
+const (
+	// Number of recent entries kept in memory.
+	ringSize = 500
+
+	// Messages with the same format that may be logged at once, and how
+	// often another is allowed after that.
+	burst         = 10
+	burstInterval = time.Second
+)
+
+// Rate limits the messages with one format.
+type limiter struct {
+	tokens     int
+	last       time.Time
+	suppressed int
+}
+
+// Returns true if a message may be logged now.
+func (l *limiter) allow(now time.Time) bool {
+	if n := int(now.Sub(l.last) / burstInterval); n > 0 {
+		l.tokens = min(burst, l.tokens+n)
+		l.last = l.last.Add(time.Duration(n) * burstInterval)
+	}
+	if l.tokens == 0 {
+		l.suppressed++
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Logger is a log. It's safe for concurrent use.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  Level
+	ring   []Entry // Oldest first once it's full, starting at next.
+	next   int
+	limits map[string]*limiter
+
+	now func() time.Time // For testing.
+}
+
+// New creates a Logger that writes entries at Info and above to out.
+func New(out io.Writer) *Logger {
+	return &Logger{
+		out:    out,
+		level:  Info,
+		limits: make(map[string]*limiter),
+		now:    time.Now,
+	}
+}
+
+// SetOutput changes where entries are written.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+// SetLevel changes the least important level that's logged.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// Logf logs a message at the given level, unless it's less important than
+// the logger's level or its format has been used too often lately.
+func (l *Logger) Logf(level Level, format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	now := l.now()
+	lim, ok := l.limits[format]
+	if !ok {
+		lim = &limiter{tokens: burst, last: now}
+		l.limits[format] = lim
+	}
+	if !lim.allow(now) {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if lim.suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar messages suppressed)", msg, lim.suppressed)
+		lim.suppressed = 0
+	}
+	l.add(Entry{Time: now, Level: level, Msg: msg})
+}
+
+// Records an entry. Must be called with mu held.
+func (l *Logger) add(e Entry) {
+	if len(l.ring) < ringSize {
+		l.ring = append(l.ring, e)
+	} else {
+		l.ring[l.next] = e
+		l.next = (l.next + 1) % ringSize
+	}
+	if l.out != nil {
+		fmt.Fprintln(l.out, e)
+	}
+}
+
+// Recent returns the most recent entries, oldest first.
+func (l *Logger) Recent() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]Entry, 0, len(l.ring))
+	res = append(res, l.ring[l.next:]...)
+	return append(res, l.ring[:l.next]...)
+}
+
+// Writer returns a writer that logs each line written to it at Info. It's
+// meant for the standard log package, so that what's still logged through it
+// ends up here too. Those lines aren't rate limited or filtered by level,
+// since they include fatal errors.
+func (l *Logger) Writer() io.Writer {
+	return stdWriter{l}
+}
+
+type stdWriter struct {
+	l *Logger
+}
+
+func (w stdWriter) Write(b []byte) (int, error) {
+	w.l.mu.Lock()
+	defer w.l.mu.Unlock()
+	now := w.l.now()
+	for _, line := range bytes.Split(bytes.TrimRight(b, "\n"), []byte("\n")) {
+		w.l.add(Entry{Time: now, Level: Info, Msg: string(line)})
+	}
+	return len(b), nil
+}
+
+// The default logger.
+var std = New(io.Discard)
+
+// Default returns the default logger, which the package-level functions use.
+func Default() *Logger {
+	return std
+}
+
+// SetOutput changes where the default logger writes entries.
+func SetOutput(w io.Writer) {
+	std.SetOutput(w)
+}
+
+// SetLevel changes the least important level the default logger logs.
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// Debugf logs a message to the default logger at Debug.
+func Debugf(format string, args ...any) {
+	std.Logf(Debug, format, args...)
+}
+
+// Infof logs a message to the default logger at Info.
+func Infof(format string, args ...any) {
+	std.Logf(Info, format, args...)
+}
+
+// Warnf logs a message to the default logger at Warn.
+func Warnf(format string, args ...any) {
+	std.Logf(Warn, format, args...)
+}
+
+// Errorf logs a message to the default logger at Error.
+func Errorf(format string, args ...any) {
+	std.Logf(Error, format, args...)
+}
+
+// Recent returns the default logger's most recent entries, oldest first.
+func Recent() []Entry {
+	return std.Recent()
+}
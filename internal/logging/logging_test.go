@@ -0,0 +1,118 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var start = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// Makes a logger with a settable time.
+func newTestLogger(out *bytes.Buffer) (*Logger, *time.Time) {
+	now := start
+	l := New(out)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func messages(entries []Entry) []string {
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Msg)
+	}
+	return res
+}
+
+func TestLevel(t *testing.T) {
+	var out bytes.Buffer
+	l, _ := newTestLogger(&out)
+	l.Logf(Debug, "debug")
+	l.Logf(Info, "info")
+	l.Logf(Error, "error")
+	l.SetLevel(Debug)
+	l.Logf(Debug, "debug again")
+
+	want := []Entry{
+		{Time: start, Level: Info, Msg: "info"},
+		{Time: start, Level: Error, Msg: "error"},
+		{Time: start, Level: Debug, Msg: "debug again"},
+	}
+	if diff := cmp.Diff(want, l.Recent()); diff != "" {
+		t.Errorf("Wrong entries (-want, +got):\n%v", diff)
+	}
+	wantOut := "2025/01/02 03:04:05 INFO  info\n" +
+		"2025/01/02 03:04:05 ERROR error\n" +
+		"2025/01/02 03:04:05 DEBUG debug again\n"
+	if diff := cmp.Diff(wantOut, out.String()); diff != "" {
+		t.Errorf("Wrong output (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var out bytes.Buffer
+	l, now := newTestLogger(&out)
+	for i := range burst + 5 {
+		l.Logf(Warn, "flood %d", i)
+	}
+	l.Logf(Warn, "other")
+	*now = now.Add(burstInterval)
+	l.Logf(Warn, "flood %d", 99)
+
+	var want []string
+	for i := range burst {
+		want = append(want, fmt.Sprintf("flood %d", i))
+	}
+	want = append(want, "other", "flood 99 (5 similar messages suppressed)")
+	if diff := cmp.Diff(want, messages(l.Recent())); diff != "" {
+		t.Errorf("Wrong messages (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRing(t *testing.T) {
+	l, now := newTestLogger(&bytes.Buffer{})
+	for i := range ringSize + 3 {
+		// Spaced out so the rate limit doesn't apply.
+		*now = now.Add(burstInterval)
+		l.Logf(Info, "entry %d", i)
+	}
+	got := messages(l.Recent())
+	if len(got) != ringSize {
+		t.Fatalf("Kept %d entries (want %d)", len(got), ringSize)
+	}
+	if got[0] != "entry 3" || got[len(got)-1] != fmt.Sprintf("entry %d", ringSize+2) {
+		t.Errorf("Wrong entries kept: %q ... %q", got[0], got[len(got)-1])
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	l, _ := newTestLogger(&out)
+	std := log.New(l.Writer(), "", 0)
+	std.Printf("one")
+	std.Printf("two\nthree")
+
+	want := []string{"one", "two", "three"}
+	if diff := cmp.Diff(want, messages(l.Recent())); diff != "" {
+		t.Errorf("Wrong messages (-want, +got):\n%v", diff)
+	}
+	if n := strings.Count(out.String(), "\n"); n != 3 {
+		t.Errorf("Wrote %d lines (want 3)", n)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, s := range []string{"warn", "WARN", "Warn"} {
+		if got, err := ParseLevel(s); err != nil || got != Warn {
+			t.Errorf("ParseLevel(%q) = %v, %v (want %v)", s, got, err, Warn)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Errorf("ParseLevel(\"loud\") succeeded (want error)")
+	}
+}
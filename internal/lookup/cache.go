@@ -0,0 +1,167 @@
+package lookup
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// How long to keep successful reverse lookups.
+	defaultTTL = 10 * time.Minute
+
+	// How long to keep failed reverse lookups.
+	defaultNegativeTTL = time.Minute
+)
+
+var defaultResolver = NewResolver(defaultTTL, defaultNegativeTTL)
+
+// Resolver is a caching reverse DNS resolver.
+type Resolver struct {
+	ttl, negativeTTL time.Duration
+
+	// For testing.
+	lookupAddr func(string) ([]string, error)
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	name    string
+	expires time.Time
+
+	// Refreshing is true while a refresh is in progress. Done is closed when
+	// it completes.
+	refreshing bool
+	done       chan struct{}
+}
+
+// NewResolver creates a new resolver. Names are cached for ttl, and failed
+// lookups for negativeTTL.
+func NewResolver(ttl, negativeTTL time.Duration) *Resolver {
+	return &Resolver{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		lookupAddr:  net.LookupAddr,
+		now:         time.Now,
+		entries:     make(map[string]*cacheEntry),
+	}
+}
+
+// Cached returns the best name currently known for addr without blocking. This
+// is the IP address itself if nothing has been resolved yet. If refresh is
+// true, the entry is missing or expired and the caller must call [Refresh],
+// probably asynchronously. Refresh is only returned once per refresh, so that
+// callers can poll without starting duplicate lookups.
+func (r *Resolver) Cached(addr net.Addr) (name string, refresh bool) {
+	ipstr, ok := ipString(addr)
+	if !ok || NumericMode {
+		return ipstr, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(ipstr)
+	if e.refreshing || r.now().Before(e.expires) {
+		return e.name, false
+	}
+	e.startRefresh()
+	return e.name, true
+}
+
+// Refresh looks up the name for addr and updates the cache.
+func (r *Resolver) Refresh(addr net.Addr) string {
+	ipstr, ok := ipString(addr)
+	if !ok || NumericMode {
+		return ipstr
+	}
+
+	name, ttl := ipstr, r.negativeTTL
+	if names, err := r.lookupAddr(ipstr); err == nil && len(names) > 0 {
+		name, ttl = names[0], r.ttl
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entry(ipstr)
+	e.name = name
+	e.expires = r.now().Add(ttl)
+	if e.refreshing {
+		e.refreshing = false
+		close(e.done)
+	}
+	return name
+}
+
+// Resolve returns the name for addr, blocking on a lookup if the cache entry is
+// missing or expired. Concurrent calls for the same address share a single
+// lookup.
+func (r *Resolver) Resolve(addr net.Addr) string {
+	ipstr, ok := ipString(addr)
+	if !ok || NumericMode {
+		return ipstr
+	}
+
+	r.mu.Lock()
+	e := r.entry(ipstr)
+	for e.refreshing {
+		done := e.done
+		r.mu.Unlock()
+		<-done
+		r.mu.Lock()
+	}
+	if r.now().Before(e.expires) {
+		defer r.mu.Unlock()
+		return e.name
+	}
+	e.startRefresh()
+	r.mu.Unlock()
+	return r.Refresh(addr)
+}
+
+// Gets or creates the entry for an IP. Callers must hold r.mu.
+func (r *Resolver) entry(ipstr string) *cacheEntry {
+	e := r.entries[ipstr]
+	if e == nil {
+		e = &cacheEntry{name: ipstr}
+		r.entries[ipstr] = e
+	}
+	return e
+}
+
+func (e *cacheEntry) startRefresh() {
+	e.refreshing = true
+	e.done = make(chan struct{})
+}
+
+// Cached calls [Resolver.Cached] on the default resolver.
+func Cached(addr net.Addr) (name string, refresh bool) {
+	return defaultResolver.Cached(addr)
+}
+
+// Refresh calls [Resolver.Refresh] on the default resolver.
+func Refresh(addr net.Addr) string {
+	return defaultResolver.Refresh(addr)
+}
+
+// Resolve calls [Resolver.Resolve] on the default resolver.
+func Resolve(addr net.Addr) string {
+	return defaultResolver.Resolve(addr)
+}
+
+// Returns the IP part of addr as a string, or the whole address and false if
+// it isn't an IP address.
+func ipString(addr net.Addr) (string, bool) {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP.String(), true
+	case *net.TCPAddr:
+		return addr.IP.String(), true
+	case *net.IPAddr:
+		return addr.IP.String(), true
+	default:
+		return addr.String(), false
+	}
+}
@@ -0,0 +1,120 @@
+package lookup
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testAddr = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+
+type fakeDNS struct {
+	names   map[string]string
+	lookups atomic.Int32
+	block   chan struct{}
+}
+
+func (f *fakeDNS) LookupAddr(ip string) ([]string, error) {
+	f.lookups.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	if n, ok := f.names[ip]; ok {
+		return []string{n}, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func newTestResolver(dns *fakeDNS, now *time.Time) *Resolver {
+	r := NewResolver(time.Minute, time.Second)
+	r.lookupAddr = dns.LookupAddr
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func TestResolver_CachedRefresh(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dns := &fakeDNS{names: map[string]string{"192.0.2.1": "one.example."}}
+	r := newTestResolver(dns, &now)
+
+	if name, refresh := r.Cached(testAddr); name != "192.0.2.1" || !refresh {
+		t.Errorf("Cached() = %q, %v (want IP, true)", name, refresh)
+	}
+	if _, refresh := r.Cached(testAddr); refresh {
+		t.Errorf("Cached() asked for a second refresh while one is pending.")
+	}
+	if name := r.Refresh(testAddr); name != "one.example." {
+		t.Errorf("Refresh() = %q (want one.example.)", name)
+	}
+	if name, refresh := r.Cached(testAddr); name != "one.example." || refresh {
+		t.Errorf("Cached() = %q, %v (want one.example., false)", name, refresh)
+	}
+
+	// Expired entries keep returning the old name until refreshed.
+	now = now.Add(2 * time.Minute)
+	dns.names["192.0.2.1"] = "two.example."
+	if name, refresh := r.Cached(testAddr); name != "one.example." || !refresh {
+		t.Errorf("Cached() = %q, %v (want one.example., true)", name, refresh)
+	}
+	r.Refresh(testAddr)
+	if name, _ := r.Cached(testAddr); name != "two.example." {
+		t.Errorf("Cached() = %q (want two.example.)", name)
+	}
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dns := &fakeDNS{names: map[string]string{"192.0.2.1": "one.example."}}
+	r := newTestResolver(dns, &now)
+
+	for range 3 {
+		if name := r.Resolve(testAddr); name != "one.example." {
+			t.Errorf("Resolve() = %q (want one.example.)", name)
+		}
+	}
+	if n := dns.lookups.Load(); n != 1 {
+		t.Errorf("Got %d lookups (want 1)", n)
+	}
+}
+
+func TestResolver_NegativeTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dns := &fakeDNS{names: map[string]string{}}
+	r := newTestResolver(dns, &now)
+
+	if name := r.Resolve(testAddr); name != "192.0.2.1" {
+		t.Errorf("Resolve() = %q (want IP)", name)
+	}
+	r.Resolve(testAddr)
+	now = now.Add(2 * time.Second)
+	r.Resolve(testAddr)
+	if n := dns.lookups.Load(); n != 2 {
+		t.Errorf("Got %d lookups (want 2)", n)
+	}
+}
+
+func TestResolver_ConcurrentResolve(t *testing.T) {
+	now := time.Unix(1000, 0)
+	dns := &fakeDNS{names: map[string]string{"192.0.2.1": "one.example."}, block: make(chan struct{})}
+	r := newTestResolver(dns, &now)
+
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if name := r.Resolve(testAddr); name != "one.example." {
+				t.Errorf("Resolve() = %q (want one.example.)", name)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(dns.block)
+	wg.Wait()
+	if n := dns.lookups.Load(); n != 1 {
+		t.Errorf("Got %d lookups (want 1)", n)
+	}
+}
@@ -1,13 +1,15 @@
 // Package name contains name resolution functions.
 //
-// This is meant to add some ease of use to the base functions, but ultimately
-// likely some caching as well.
+// This adds some ease of use to the base functions, as well as caching of
+// reverse lookups.
 package lookup
 
 import (
 	"errors"
 	"fmt"
 	"net"
+
+	"github.com/pcekm/vasily/internal/util"
 )
 
 // Package flags.
@@ -19,31 +21,15 @@ var (
 
 // Addr finds the name for a given address, or returns the address itself as
 // a string if no name can be found. If multiple names are found, this returns
-// the first.
+// the first. Results are cached.
 func Addr(addr net.Addr) string {
-	var ipstr string
-	switch addr := addr.(type) {
-	case *net.UDPAddr:
-		ipstr = addr.IP.String()
-	case *net.TCPAddr:
-		ipstr = addr.IP.String()
-	case *net.IPAddr:
-		ipstr = addr.IP.String()
-	default:
-		return addr.String()
-	}
-	if NumericMode {
-		return ipstr
-	}
-	names, err := net.LookupAddr(ipstr)
-	if err != nil || len(names) == 0 {
-		return ipstr
-	}
-	return names[0]
+	return Resolve(addr)
 }
 
 // String parses a string address or hostname. Returns the first IPv4 address if
-// it exists, or the first IPv6 address otherwise.
+// it exists, or the first IPv6 address otherwise. If [NAT64Prefix] is set, it
+// instead returns the first IPv6 address, or the first IPv4 address translated
+// into the prefix.
 func String(s string) (*net.UDPAddr, error) {
 	ipAddrs, err := net.LookupIP(s)
 	if err != nil {
@@ -52,11 +38,40 @@ func String(s string) (*net.UDPAddr, error) {
 	if len(ipAddrs) == 0 {
 		return nil, errors.New("no addresses found")
 	}
+	return &net.UDPAddr{IP: choose(ipAddrs)}, nil
+}
+
+// Recheck looks up a hostname again to see if it's moved from addr. Returns
+// nil if addr is still one of the host's addresses, or else the address
+// [String] returns now. A host with several addresses doesn't count as moved
+// just because they come back in a different order.
+func Recheck(s string, addr net.Addr) (*net.UDPAddr, error) {
+	ipAddrs, err := net.LookupIP(s)
+	if err != nil {
+		return nil, fmt.Errorf("lookup error: %v", err)
+	}
+	if len(ipAddrs) == 0 {
+		return nil, errors.New("no addresses found")
+	}
+	cur := util.IP(addr)
+	for _, ip := range ipAddrs {
+		if ip.Equal(cur) || (NAT64Prefix != nil && NAT64Address(NAT64Prefix, ip).Equal(cur)) {
+			return nil, nil
+		}
+	}
+	return &net.UDPAddr{IP: choose(ipAddrs)}, nil
+}
+
+// Chooses which of a host's addresses to use.
+func choose(ipAddrs []net.IP) net.IP {
+	if NAT64Prefix != nil {
+		return nat64Choose(NAT64Prefix, ipAddrs)
+	}
 	ip := ipAddrs[0]
 	for _, a := range ipAddrs {
 		if a.To4() != nil {
 			ip = a
 		}
 	}
-	return &net.UDPAddr{IP: ip}, nil
+	return ip
 }
@@ -58,3 +58,23 @@ func TestString(t *testing.T) {
 		})
 	}
 }
+
+func TestRecheck(t *testing.T) {
+	cases := []struct {
+		addr net.Addr
+		want *net.UDPAddr
+	}{
+		{addr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}},
+		{addr: &net.IPAddr{IP: net.ParseIP("127.0.0.1")}},
+		{addr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, want: &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}},
+	}
+	for _, c := range cases {
+		got, err := Recheck("localhost", c.addr)
+		if err != nil {
+			t.Fatalf("Error looking up name: %v", err)
+		}
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("Recheck(localhost, %v) wrong address (-want, +got):\n%v", c.addr, diff)
+		}
+	}
+}
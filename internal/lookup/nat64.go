@@ -0,0 +1,142 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// NAT64Prefix is the prefix of a NAT64 translator (RFC 6146) to reach IPv4
+// hosts through from an IPv6-only network, or nil for none. When it's set,
+// [String] prefers IPv6 addresses, and translates the addresses of IPv4-only
+// hosts into the prefix.
+var NAT64Prefix *net.IPNet
+
+// WellKnownNAT64Prefix is the prefix reserved for NAT64 by RFC 6052.
+var WellKnownNAT64Prefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)}
+
+// The name DNS64 resolvers translate to find their prefix, and the IPv4
+// addresses it has (RFC 7050).
+const ipv4OnlyName = "ipv4only.arpa"
+
+var ipv4OnlyAddrs = []net.IP{net.IPv4(192, 0, 0, 170).To4(), net.IPv4(192, 0, 0, 171).To4()}
+
+// Timeout for discovering the NAT64 prefix.
+const nat64Timeout = 5 * time.Second
+
+// ParseNAT64 parses a NAT64 prefix, or discovers the local network's with
+// [DiscoverNAT64] if s is "auto".
+func ParseNAT64(s string) (*net.IPNet, error) {
+	if s == "auto" {
+		return DiscoverNAT64()
+	}
+	_, prefix, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNAT64(prefix); err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+// Returns an error if a prefix can't hold embedded IPv4 addresses.
+func checkNAT64(prefix *net.IPNet) error {
+	ones, bits := prefix.Mask.Size()
+	if bits != 8*net.IPv6len || prefix.IP.To4() != nil {
+		return fmt.Errorf("NAT64 prefix %v isn't IPv6", prefix)
+	}
+	if !slices.Contains([]int{32, 40, 48, 56, 64, 96}, ones) {
+		return fmt.Errorf("NAT64 prefix %v must be /32, /40, /48, /56, /64 or /96", prefix)
+	}
+	return nil
+}
+
+// DiscoverNAT64 finds the prefix the network's DNS64 resolver translates IPv4
+// addresses into, by looking up ipv4only.arpa (RFC 7050).
+func DiscoverNAT64() (*net.IPNet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), nat64Timeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip6", ipv4OnlyName)
+	if err != nil {
+		return nil, fmt.Errorf("no DNS64 found: %v", err)
+	}
+	return nat64FromAAAA(ips)
+}
+
+// Finds the NAT64 prefix in the translated addresses of ipv4only.arpa.
+func nat64FromAAAA(ips []net.IP) (*net.IPNet, error) {
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			continue
+		}
+		// Longer prefixes first, since a shorter one would find the address
+		// in the wrong place if the prefix happens to contain it.
+		for _, ones := range []int{96, 64, 56, 48, 40, 32} {
+			prefix := &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 128)), Mask: net.CIDRMask(ones, 128)}
+			emb := NAT64Embedded(prefix, ip)
+			if slices.ContainsFunc(ipv4OnlyAddrs, emb.Equal) {
+				return prefix, nil
+			}
+		}
+	}
+	return nil, errors.New("no NAT64 prefix found in DNS64 addresses")
+}
+
+// Returns the bytes of an IPv6 address that hold an IPv4 address embedded in
+// a prefix of a given length. Byte 8 is always skipped (RFC 6052, section
+// 2.2).
+func nat64Positions(ones int) []int {
+	var pos []int
+	for i := ones / 8; len(pos) < net.IPv4len; i++ {
+		if i == 8 {
+			continue
+		}
+		pos = append(pos, i)
+	}
+	return pos
+}
+
+// NAT64Address returns the address an IPv4 address is translated to in a
+// NAT64 prefix, or nil if ip isn't an IPv4 address.
+func NAT64Address(prefix *net.IPNet, ip net.IP) net.IP {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil
+	}
+	ones, _ := prefix.Mask.Size()
+	res := make(net.IP, net.IPv6len)
+	copy(res, prefix.IP.Mask(prefix.Mask))
+	for i, p := range nat64Positions(ones) {
+		res[p] = ip4[i]
+	}
+	return res
+}
+
+// NAT64Embedded returns the IPv4 address embedded in an address in a NAT64
+// prefix, or nil if ip isn't in the prefix.
+func NAT64Embedded(prefix *net.IPNet, ip net.IP) net.IP {
+	if ip.To4() != nil || !prefix.Contains(ip) {
+		return nil
+	}
+	ones, _ := prefix.Mask.Size()
+	res := make(net.IP, net.IPv4len)
+	for i, p := range nat64Positions(ones) {
+		res[i] = ip[p]
+	}
+	return res
+}
+
+// Chooses the address to reach a host at through NAT64: the first IPv6
+// address, or else the first IPv4 address translated into the prefix.
+func nat64Choose(prefix *net.IPNet, ips []net.IP) net.IP {
+	for _, ip := range ips {
+		if ip.To4() == nil {
+			return ip
+		}
+	}
+	return NAT64Address(prefix, ips[0])
+}
@@ -0,0 +1,100 @@
+package lookup
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Examples from RFC 6052, section 2.4.
+var nat64Cases = []struct {
+	Prefix string
+	Addr   string
+}{
+	{Prefix: "2001:db8::/32", Addr: "2001:db8:c000:221::"},
+	{Prefix: "2001:db8:100::/40", Addr: "2001:db8:1c0:2:21::"},
+	{Prefix: "2001:db8:122::/48", Addr: "2001:db8:122:c000:2:2100::"},
+	{Prefix: "2001:db8:122:300::/56", Addr: "2001:db8:122:3c0:0:221::"},
+	{Prefix: "2001:db8:122:344::/64", Addr: "2001:db8:122:344:c0:2:2100:0"},
+	{Prefix: "2001:db8:122:344::/96", Addr: "2001:db8:122:344::192.0.2.33"},
+	{Prefix: "64:ff9b::/96", Addr: "64:ff9b::192.0.2.33"},
+}
+
+func TestNAT64Address(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.33")
+	for _, c := range nat64Cases {
+		prefix := mustParseCIDR(c.Prefix)
+		want := net.ParseIP(c.Addr)
+		if got := NAT64Address(prefix, ip4); !got.Equal(want) {
+			t.Errorf("NAT64Address(%v, %v) = %v (want %v)", prefix, ip4, got, want)
+		}
+		if got := NAT64Embedded(prefix, want); !got.Equal(ip4) {
+			t.Errorf("NAT64Embedded(%v, %v) = %v (want %v)", prefix, want, got, ip4)
+		}
+	}
+	if got := NAT64Embedded(WellKnownNAT64Prefix, net.ParseIP("2001:db8::1")); got != nil {
+		t.Errorf("NAT64Embedded outside the prefix = %v (want nil)", got)
+	}
+	if got := NAT64Address(WellKnownNAT64Prefix, net.ParseIP("2001:db8::1")); got != nil {
+		t.Errorf("NAT64Address of an IPv6 address = %v (want nil)", got)
+	}
+}
+
+func TestNAT64FromAAAA(t *testing.T) {
+	for _, c := range nat64Cases {
+		prefix := mustParseCIDR(c.Prefix)
+		ips := []net.IP{NAT64Address(prefix, net.ParseIP("192.0.0.170")), NAT64Address(prefix, net.ParseIP("192.0.0.171"))}
+		got, err := nat64FromAAAA(ips)
+		if err != nil {
+			t.Errorf("nat64FromAAAA(%v) error: %v", ips, err)
+			continue
+		}
+		if diff := cmp.Diff(prefix.String(), got.String()); diff != "" {
+			t.Errorf("nat64FromAAAA(%v) wrong prefix (-want, +got):\n%v", ips, diff)
+		}
+	}
+	if _, err := nat64FromAAAA([]net.IP{net.ParseIP("2001:db8::1")}); err == nil {
+		t.Errorf("nat64FromAAAA succeeded without a translated address")
+	}
+}
+
+func TestParseNAT64(t *testing.T) {
+	if got, err := ParseNAT64("64:ff9b::/96"); err != nil || got.String() != WellKnownNAT64Prefix.String() {
+		t.Errorf("ParseNAT64(64:ff9b::/96) = %v, %v (want %v)", got, err, WellKnownNAT64Prefix)
+	}
+	for _, s := range []string{"64:ff9b::/80", "192.0.2.0/24", "bogus"} {
+		if _, err := ParseNAT64(s); err == nil {
+			t.Errorf("ParseNAT64(%q) succeeded (want error)", s)
+		}
+	}
+}
+
+func TestString_NAT64(t *testing.T) {
+	NAT64Prefix = WellKnownNAT64Prefix
+	defer func() { NAT64Prefix = nil }()
+	cases := []struct {
+		s    string
+		want *net.UDPAddr
+	}{
+		{s: "192.0.2.1", want: &net.UDPAddr{IP: net.ParseIP("64:ff9b::192.0.2.1")}},
+		{s: "2001:db8::1", want: &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}},
+	}
+	for _, c := range cases {
+		addr, err := String(c.s)
+		if err != nil {
+			t.Fatalf("String(%q) error: %v", c.s, err)
+		}
+		if diff := cmp.Diff(c.want, addr); diff != "" {
+			t.Errorf("String(%q) wrong address (-want, +got):\n%v", c.s, diff)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package lookup
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	// The system's resolver config.
+	resolvConfPath = "/etc/resolv.conf"
+
+	// The upstream resolvers of systemd-resolved, whose stub resolver is
+	// what's usually in resolv.conf on systems that run it.
+	resolvedConfPath = "/run/systemd/resolve/resolv.conf"
+)
+
+// Resolvers returns the addresses of the DNS servers the system uses, from
+// resolv.conf. If that only lists a local stub resolver, like
+// systemd-resolved's, the stub's own upstream servers are returned if they
+// can be found.
+func Resolvers() ([]net.IP, error) {
+	servers, err := readResolvConf(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) > 0 && !slices.ContainsFunc(servers, func(ip net.IP) bool { return !ip.IsLoopback() }) {
+		if upstream, err := readResolvConf(resolvedConfPath); err == nil && len(upstream) > 0 {
+			return upstream, nil
+		}
+	}
+	return servers, nil
+}
+
+func readResolvConf(path string) ([]net.IP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
+
+// Returns the nameserver addresses in a resolv.conf file, in order. Link-local
+// IPv6 servers with a zone are left out, since their zone can't be kept.
+func parseResolvConf(r io.Reader) ([]net.IP, error) {
+	var res []net.IP
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if ip := net.ParseIP(fields[1]); ip != nil {
+			res = append(res, ip)
+		}
+	}
+	return res, sc.Err()
+}
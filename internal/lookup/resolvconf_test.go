@@ -0,0 +1,87 @@
+package lookup
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf := `# Generated by something
+search example.com
+nameserver 192.0.2.53
+; nameserver 192.0.2.54
+nameserver 2001:db8::53
+nameserver fe80::1%eth0
+nameserver
+options edns0
+`
+	got, err := parseResolvConf(strings.NewReader(conf))
+	if err != nil {
+		t.Fatalf("parseResolvConf error: %v", err)
+	}
+	want := []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("2001:db8::53")}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong servers (-want, +got):\n%v", diff)
+	}
+}
+
+// Points the resolv.conf paths at files with the given contents. An empty
+// string leaves the file out.
+func setResolvConfs(t *testing.T, conf, resolvedConf string) {
+	t.Helper()
+	dir := t.TempDir()
+	oldConf, oldResolved := resolvConfPath, resolvedConfPath
+	t.Cleanup(func() { resolvConfPath, resolvedConfPath = oldConf, oldResolved })
+	resolvConfPath = filepath.Join(dir, "resolv.conf")
+	resolvedConfPath = filepath.Join(dir, "resolved.conf")
+	for path, s := range map[string]string{resolvConfPath: conf, resolvedConfPath: resolvedConf} {
+		if s == "" {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	cases := []struct {
+		name, conf, resolvedConf string
+		want                     []net.IP
+	}{
+		{
+			name:         "Plain",
+			conf:         "nameserver 192.0.2.53\n",
+			resolvedConf: "nameserver 192.0.2.99\n",
+			want:         []net.IP{net.ParseIP("192.0.2.53")},
+		},
+		{
+			name:         "Stub",
+			conf:         "nameserver 127.0.0.53\n",
+			resolvedConf: "nameserver 192.0.2.99\n",
+			want:         []net.IP{net.ParseIP("192.0.2.99")},
+		},
+		{
+			name: "StubWithoutUpstream",
+			conf: "nameserver 127.0.0.53\n",
+			want: []net.IP{net.ParseIP("127.0.0.53")},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			setResolvConfs(t, c.conf, c.resolvedConf)
+			got, err := Resolvers()
+			if err != nil {
+				t.Fatalf("Resolvers error: %v", err)
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("Wrong servers (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+// Package netns runs code in named Linux network namespaces, the ones
+// "ip netns add" creates. It only imports the standard library, since the
+// privileged server uses it.
+package netns
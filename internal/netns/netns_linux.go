@@ -0,0 +1,156 @@
+package netns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Where "ip netns add" puts the named network namespaces.
+const netnsDir = "/run/netns"
+
+var (
+	mu sync.Mutex
+
+	// Namespaces opened by Preopen, by name. The process's own namespace is
+	// under the empty name.
+	preopened map[string]int
+)
+
+// Preopen opens the process's network namespace and every named one, so that
+// [Run] can switch between them later without opening any files. The
+// privileged server calls it before it's sandboxed. Namespaces added later
+// can't be used.
+func Preopen() error {
+	mu.Lock()
+	defer mu.Unlock()
+	fds := make(map[string]int)
+	self, err := open("/proc/self/ns/net")
+	if err != nil {
+		return err
+	}
+	fds[""] = self
+	entries, err := os.ReadDir(netnsDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		syscall.Close(self)
+		return err
+	}
+	for _, e := range entries {
+		fd, err := open(filepath.Join(netnsDir, e.Name()))
+		if err != nil {
+			continue
+		}
+		fds[e.Name()] = fd
+	}
+	preopened = fds
+	return nil
+}
+
+// Opens a namespace file.
+func open(path string) (int, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return fd, nil
+}
+
+// Opens a network namespace by name, or the process's own if name is empty.
+// Uses the one opened by Preopen if there is one. The file must be closed
+// unless it was preopened.
+func openNetns(name string) (fd int, pre bool, err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if fd, ok := preopened[name]; ok {
+		return fd, true, nil
+	}
+	if preopened != nil {
+		return -1, false, fmt.Errorf("network namespace %q wasn't there at startup", name)
+	}
+	if name == "" {
+		fd, err = open("/proc/self/ns/net")
+	} else {
+		fd, err = open(filepath.Join(netnsDir, name))
+	}
+	return fd, false, err
+}
+
+// Switches the calling thread to a network namespace.
+func setns(fd int) error {
+	if _, _, errno := syscall.RawSyscall(sysSetns, uintptr(fd), syscall.CLONE_NEWNET, 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Check returns an error if there's no network namespace with the given name.
+// It doesn't need any privileges, so unlike [Run] it can be called outside
+// the privileged server.
+func Check(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	_, err := os.Stat(filepath.Join(netnsDir, name))
+	return err
+}
+
+// Returns an error if name can't be a file in netnsDir.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, '/') {
+		return fmt.Errorf("bad network namespace name %q", name)
+	}
+	return nil
+}
+
+// Run calls f on a thread in the named network namespace, so that the sockets
+// it opens belong to that namespace. The sockets stay there after it returns.
+// An empty name calls f in the current namespace. Switching needs
+// CAP_SYS_ADMIN.
+func Run(name string, f func() error) error {
+	if name == "" {
+		return f()
+	}
+	if err := checkName(name); err != nil {
+		return err
+	}
+	ns, nsPre, err := openNetns(name)
+	if err != nil {
+		return err
+	}
+	if !nsPre {
+		defer syscall.Close(ns)
+	}
+	self, selfPre, err := openNetns("")
+	if err != nil {
+		return err
+	}
+	if !selfPre {
+		defer syscall.Close(self)
+	}
+
+	// The namespace belongs to the thread, so f runs on a thread of its own.
+	// If it can't be switched back, the thread is left locked, and the
+	// runtime ends it along with the goroutine.
+	res := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := setns(ns); err != nil {
+			runtime.UnlockOSThread()
+			res <- fmt.Errorf("error entering network namespace %q: %v", name, err)
+			return
+		}
+		ferr := f()
+		if err := setns(self); err != nil {
+			res <- errors.Join(ferr, fmt.Errorf("error leaving network namespace %q: %v", name, err))
+			return
+		}
+		runtime.UnlockOSThread()
+		res <- ferr
+	}()
+	return <-res
+}
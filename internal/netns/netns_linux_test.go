@@ -0,0 +1,29 @@
+package netns
+
+import "testing"
+
+func TestRun_Current(t *testing.T) {
+	called := false
+	if err := Run("", func() error { called = true; return nil }); err != nil {
+		t.Errorf("Run error: %v", err)
+	}
+	if !called {
+		t.Errorf("Run didn't call f")
+	}
+}
+
+func TestRun_BadName(t *testing.T) {
+	for _, name := range []string{".", "..", "../../proc/1/ns/net"} {
+		if err := Run(name, func() error { t.Errorf("Run(%q) called f", name); return nil }); err == nil {
+			t.Errorf("Run(%q) succeeded (want error)", name)
+		}
+	}
+}
+
+func TestCheck_Bad(t *testing.T) {
+	for _, name := range []string{"", ".", "..", "../../proc/1/ns/net", "vasily-test-missing"} {
+		if err := Check(name); err == nil {
+			t.Errorf("Check(%q) succeeded (want error)", name)
+		}
+	}
+}
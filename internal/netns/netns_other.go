@@ -0,0 +1,29 @@
+//go:build !linux
+
+package netns
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Preopen opens the network namespaces for later use. There are none on this
+// OS.
+func Preopen() error {
+	return nil
+}
+
+// Check returns an error if there's no network namespace with the given name.
+// There are none on this OS.
+func Check(name string) error {
+	return fmt.Errorf("network namespaces are not supported on %s", runtime.GOOS)
+}
+
+// Run calls f in the named network namespace. Only the current one, with an
+// empty name, is supported on this OS.
+func Run(name string, f func() error) error {
+	if name != "" {
+		return fmt.Errorf("network namespaces are not supported on %s", runtime.GOOS)
+	}
+	return f()
+}
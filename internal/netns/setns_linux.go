@@ -0,0 +1,7 @@
+//go:build !(386 || amd64)
+
+package netns
+
+import "syscall"
+
+const sysSetns = syscall.SYS_SETNS
@@ -0,0 +1,4 @@
+package netns
+
+// The syscall package doesn't have this one on 386.
+const sysSetns = 346
@@ -0,0 +1,4 @@
+package netns
+
+// The syscall package doesn't have this one on amd64.
+const sysSetns = 308
@@ -0,0 +1,228 @@
+// Package pathmtu finds the maximum transmission unit (MTU) along the path to
+// a host.
+//
+// Discovery works by sending pings with fragmentation disabled and searching
+// for the largest size that gets a reply. Packets that are too big will either
+// get an ICMP fragmentation needed (IPv4) or packet too big (IPv6) response,
+// or will be silently dropped by a misconfigured router. Either way, the size
+// is treated as too big.
+package pathmtu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+const (
+	defaultMaxMTU   = 1500
+	defaultInterval = time.Second
+	defaultProbes   = 2
+
+	noInterval = time.Duration(-1)
+
+	// Maximum time to wait for a reply.
+	timeout = time.Second
+
+	icmpHeaderLen = 8
+)
+
+var (
+	// ErrNoReply is returned when there was no reply to the smallest
+	// possible probe.
+	ErrNoReply = errors.New("no reply to minimum size probe")
+)
+
+// Options contains [Discover] options.
+type Options struct {
+	// MaxMTU is the largest MTU to try. Defaults to 1500. Note that the ICMP
+	// backends can't currently read replies larger than 1500 bytes.
+	MaxMTU int
+
+	// Interval is the time between probes. Defaults to 1s.
+	Interval time.Duration
+
+	// Probes is the number of times to try each size before deciding it's too
+	// big. Defaults to 2.
+	Probes int
+
+	// Source binds the connection to a local interface or address.
+	Source backend.SourceOption
+}
+
+func (o *Options) maxMTU() int {
+	if o == nil || o.MaxMTU == 0 {
+		return defaultMaxMTU
+	}
+	return o.MaxMTU
+}
+
+func (o *Options) interval() time.Duration {
+	if o == nil || o.Interval == 0 {
+		return defaultInterval
+	}
+	if o.Interval == noInterval {
+		return 0
+	}
+	return o.Interval
+}
+
+func (o *Options) source() backend.SourceOption {
+	if o == nil {
+		return backend.SourceOption{}
+	}
+	return o.Source
+}
+
+func (o *Options) probes() int {
+	if o == nil || o.Probes == 0 {
+		return defaultProbes
+	}
+	return o.Probes
+}
+
+// Returns the smallest MTU every link must support.
+func minMTU(ipVer util.IPVersion) int {
+	return util.Choose(ipVer, 68, 1280)
+}
+
+// Returns the length of the IP and ICMP headers.
+func headerLen(ipVer util.IPVersion) int {
+	return util.Choose(ipVer, 20, 40) + icmpHeaderLen
+}
+
+// Discover finds the path MTU to dest. The backend must be ICMP-based and
+// support [backend.DontFragmentOption].
+func Discover(name backend.Name, ipVer util.IPVersion, dest net.Addr, opts *Options) (int, error) {
+	conn, err := backend.New(name, ipVer, opts.source())
+	if err != nil {
+		return 0, fmt.Errorf("error creating connection: %v", err)
+	}
+	defer conn.Close()
+
+	tick, stop := immediateTick(opts.interval())
+	defer stop()
+	p := &prober{
+		conn:   conn,
+		ipVer:  ipVer,
+		dest:   dest,
+		probes: opts.probes(),
+		tick:   tick,
+	}
+
+	// Invariant: lo fits and hi doesn't.
+	lo, hi := minMTU(ipVer), opts.maxMTU()
+	if ok, err := p.fits(lo); err != nil {
+		return 0, err
+	} else if !ok {
+		return 0, ErrNoReply
+	}
+	if ok, err := p.fits(hi); err != nil {
+		return 0, err
+	} else if ok {
+		return hi, nil
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := p.fits(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+type prober struct {
+	conn   backend.Conn
+	ipVer  util.IPVersion
+	dest   net.Addr
+	probes int
+	tick   <-chan time.Time
+	seq    int
+}
+
+// Sends a probe of the given total size and reports whether it got through.
+func (p *prober) fits(size int) (bool, error) {
+	payload := make([]byte, size-headerLen(p.ipVer))
+	for range p.probes {
+		<-p.tick
+		p.seq++
+		pkt := &backend.Packet{Seq: p.seq, Payload: payload}
+		err := p.conn.WriteTo(pkt, p.dest, backend.DontFragmentOption{})
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// Too big for the local interface.
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error sending probe: %v", err)
+		}
+		reply, peer, err := readSeq(p.conn, p.seq)
+		if errors.Is(err, backend.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return false, fmt.Errorf("read error: %v", err)
+		}
+		switch reply.Type {
+		case backend.PacketReply:
+			return true, nil
+		case backend.PacketTooBig:
+			return false, nil
+		case backend.PacketDestinationUnreachable:
+			return false, fmt.Errorf("destination unreachable: %v", peer)
+		}
+	}
+	return false, nil
+}
+
+// Like time.Tick, but the first tick occurs immediately rather than after d.
+// Call stop when finished with the ticks.
+func immediateTick(d time.Duration) (ticks <-chan time.Time, stop func()) {
+	ch := make(chan time.Time, 1)
+	if d == 0 {
+		close(ch) // No delays.
+		return ch, func() {}
+	}
+	ch <- time.Now()
+	ticker := time.NewTicker(d)
+	done := make(chan any)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				select {
+				case ch <- t:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return ch, func() { close(done) }
+}
+
+func readSeq(conn backend.Conn, seq int) (*backend.Packet, net.Addr, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	defer cancel()
+	for {
+		pkt, peer, err := conn.ReadFrom(ctx)
+		if pkt != nil && (pkt.Seq != seq || pkt.Type == backend.PacketRequest) {
+			continue
+		}
+		return pkt, peer, err
+	}
+}
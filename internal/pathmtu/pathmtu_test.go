@@ -0,0 +1,125 @@
+package pathmtu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A fake connection over a path with a fixed MTU.
+type fakePath struct {
+	ipVer util.IPVersion
+
+	// Largest packet the path carries.
+	mtu int
+
+	// Largest packet the local interface sends.
+	ifaceMTU int
+
+	// Nothing gets through.
+	down bool
+
+	replies chan *backend.Packet
+}
+
+func newFakePath(ipVer util.IPVersion, mtu int) *fakePath {
+	return &fakePath{
+		ipVer:    ipVer,
+		mtu:      mtu,
+		ifaceMTU: 9000,
+		replies:  make(chan *backend.Packet, 1),
+	}
+}
+
+func (f *fakePath) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	if len(opts) != 1 || opts[0] != (backend.DontFragmentOption{}) {
+		return errors.New("probe sent without DontFragmentOption")
+	}
+	size := len(pkt.Payload) + headerLen(f.ipVer)
+	switch {
+	case size > f.ifaceMTU:
+		return syscall.EMSGSIZE
+	case f.down:
+	case size <= f.mtu:
+		f.replies <- &backend.Packet{Type: backend.PacketReply, Seq: pkt.Seq}
+	default:
+		f.replies <- &backend.Packet{Type: backend.PacketTooBig, Seq: pkt.Seq}
+	}
+	return nil
+}
+
+func (f *fakePath) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case pkt := <-f.replies:
+		return pkt, test.LoopbackV4, nil
+	case <-ctx.Done():
+		return nil, nil, backend.ErrTimeout
+	}
+}
+
+func (f *fakePath) Close() error {
+	return nil
+}
+
+func TestDiscover(t *testing.T) {
+	cases := []struct {
+		Name    string
+		IPVer   util.IPVersion
+		Path    func(*fakePath)
+		MaxMTU  int
+		Want    int
+		WantErr error
+	}{
+		{Name: "Full", IPVer: util.IPv4, Want: 1500},
+		{Name: "Jumbo", IPVer: util.IPv4, MaxMTU: 9000, Want: 9000},
+		{Name: "Tunnel", IPVer: util.IPv4, Path: func(f *fakePath) { f.mtu = 1420 }, Want: 1420},
+		{Name: "Tunnel", IPVer: util.IPv6, Path: func(f *fakePath) { f.mtu = 1280 }, Want: 1280},
+		{Name: "LocalInterface", IPVer: util.IPv4, Path: func(f *fakePath) { f.ifaceMTU = 1400 }, Want: 1400},
+		{Name: "Minimum", IPVer: util.IPv4, Path: func(f *fakePath) { f.mtu = 68 }, Want: 68},
+		{Name: "Down", IPVer: util.IPv4, Path: func(f *fakePath) { f.down = true }, WantErr: ErrNoReply},
+	}
+	for _, c := range cases {
+		t.Run(c.Name+"/"+c.IPVer.String(), func(t *testing.T) {
+			path := newFakePath(c.IPVer, 9000)
+			if c.Path != nil {
+				c.Path(path)
+			}
+			name := test.RegisterMock(path)
+			opts := &Options{MaxMTU: c.MaxMTU, Interval: noInterval, Probes: 1}
+			got, err := Discover(name, c.IPVer, test.LoopbackV4, opts)
+			if !errors.Is(err, c.WantErr) {
+				t.Fatalf("Wrong error: %v (want %v)", err, c.WantErr)
+			}
+			if got != c.Want {
+				t.Errorf("Discover() = %d (want %d)", got, c.Want)
+			}
+		})
+	}
+}
+
+// Stopping the ticks must end the goroutine sending them, even though
+// nothing reads them.
+func TestImmediateTick_Stop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for range 10 {
+		tick, stop := immediateTick(time.Millisecond)
+		<-tick
+		time.Sleep(5 * time.Millisecond)
+		stop()
+	}
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after stopping (want at most %d)", n, before)
+	}
+}
@@ -0,0 +1,41 @@
+package pinger
+
+import "time"
+
+// Adjusts the ping interval based on ping results, in a manner similar to
+// fping's adaptive mode. Losses double the interval. Successful replies that
+// arrive quickly relative to the current interval shrink it by a quarter.
+// The interval always stays within [min, max].
+type intervalController struct {
+	min, max time.Duration
+	cur      time.Duration
+}
+
+func newIntervalController(min, max time.Duration) *intervalController {
+	return &intervalController{
+		min: min,
+		max: max,
+		cur: min,
+	}
+}
+
+// Interval returns the current interval.
+func (c *intervalController) Interval() time.Duration {
+	return c.cur
+}
+
+// Update adjusts the interval for a new result and returns the new interval.
+func (c *intervalController) Update(r PingResult) time.Duration {
+	switch r.Type {
+	case Success:
+		// Only speed up for hosts that reply well within the interval. Slow
+		// hosts are left alone.
+		if r.Latency < c.cur/2 {
+			c.cur -= c.cur / 4
+		}
+	case Dropped, Unreachable:
+		c.cur *= 2
+	}
+	c.cur = min(max(c.cur, c.min), c.max)
+	return c.cur
+}
@@ -0,0 +1,54 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIntervalController(t *testing.T) {
+	c := newIntervalController(time.Second, 8*time.Second)
+
+	results := []PingResult{
+		{Type: Success, Latency: time.Millisecond},
+		{Type: Dropped},
+		{Type: Dropped},
+		{Type: Unreachable},
+		{Type: Dropped},
+		{Type: Success, Latency: time.Millisecond},
+		{Type: Success, Latency: 5 * time.Second},
+		{Type: Duplicate},
+		{Type: Gap},
+		{Type: Success, Latency: time.Millisecond},
+		{Type: Success, Latency: time.Millisecond},
+	}
+	var got []time.Duration
+	for _, r := range results {
+		got = append(got, c.Update(r))
+	}
+
+	want := []time.Duration{
+		time.Second,
+		2 * time.Second,
+		4 * time.Second,
+		8 * time.Second,
+		8 * time.Second,
+		6 * time.Second,
+		6 * time.Second,
+		6 * time.Second,
+		6 * time.Second,
+		4500 * time.Millisecond,
+		3375 * time.Millisecond,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong intervals (-want, +got):\n%v", diff)
+	}
+
+	for range 10 {
+		c.Update(PingResult{Type: Success, Latency: time.Millisecond})
+	}
+	if c.Interval() != time.Second {
+		t.Errorf("Interval not clamped to minimum: %v (want %v)", c.Interval(), time.Second)
+	}
+}
@@ -0,0 +1,116 @@
+package pinger
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// BurstLatency chooses how the latencies of the replies to a burst of probes
+// are combined into a single result.
+type BurstLatency int
+
+// BurstLatency values.
+const (
+	// BurstMin uses the lowest latency in the burst.
+	BurstMin BurstLatency = iota
+
+	// BurstMedian uses the median latency in the burst.
+	BurstMedian
+
+	numBurstLatencies
+)
+
+// ParseBurstLatency parses a name as returned by [BurstLatency.String].
+func ParseBurstLatency(s string) (BurstLatency, error) {
+	for b := range numBurstLatencies {
+		if b.String() == s {
+			return b, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown burst latency %q", s)
+}
+
+func (b BurstLatency) String() string {
+	switch b {
+	case BurstMin:
+		return "min"
+	case BurstMedian:
+		return "median"
+	default:
+		return fmt.Sprintf("(unknown:%d)", b)
+	}
+}
+
+// Combines the results of the probes in a burst. The res arg is the pending
+// result for the whole burst. Probes still waiting for a reply are counted as
+// dropped. A burst that fell into a gap is a gap, whatever its replies, since
+// their latencies may span the gap.
+func combineBurst(res PingResult, probes []PingResult, how BurstLatency) PingResult {
+	if res.Type == Gap {
+		return res
+	}
+	var replied, failed []PingResult
+	res.Probes = 0
+	for _, r := range probes {
+		switch r.Type {
+		case Waiting:
+			res.Probes++
+		case Success:
+			res.Probes++
+			replied = append(replied, r)
+		default:
+			res.Probes++
+			failed = append(failed, r)
+		}
+	}
+	res.Replies = len(replied)
+
+	if len(replied) == 0 {
+		if len(failed) == 0 {
+			res.Type = Dropped
+			return res
+		}
+		return withReply(res, failed[0])
+	}
+
+	slices.SortStableFunc(replied, func(a, b PingResult) int {
+		return cmp.Compare(a.Latency, b.Latency)
+	})
+	res.Spread = replied[len(replied)-1].Latency - replied[0].Latency
+	var r PingResult
+	switch how {
+	case BurstMedian:
+		r = replied[len(replied)/2]
+		if len(replied)%2 == 0 {
+			lo := replied[len(replied)/2-1]
+			r.Latency = lo.Latency + (r.Latency-lo.Latency)/2
+		}
+	default:
+		r = replied[0]
+	}
+	return withReply(res, r)
+}
+
+// Returns res with the type, latency and reply data of a probe's result.
+func withReply(res, probe PingResult) PingResult {
+	res.Type = probe.Type
+	res.Latency = probe.Latency
+	res.Peer = probe.Peer
+	res.TTL = probe.TTL
+	res.Timestamps = probe.Timestamps
+	res.AddressMask = probe.AddressMask
+	return res
+}
+
+// Returns the number of probes a result stands for, and how many of them
+// went unanswered.
+func probeCounts(r PingResult) (n, failed int) {
+	if r.Probes == 0 {
+		if r.Type == Success {
+			return 1, 0
+		}
+		return 1, 1
+	}
+	return r.Probes, r.Probes - r.Replies
+}
@@ -0,0 +1,96 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCombineBurst(t *testing.T) {
+	ms := func(n int) time.Duration { return time.Duration(n) * time.Millisecond }
+	cases := []struct {
+		Name    string
+		Pending ResultType
+		Probes  []PingResult
+		How     BurstLatency
+		Want    PingResult
+	}{
+		{
+			Name:    "Min",
+			Pending: Waiting,
+			Probes: []PingResult{
+				{Type: Success, Latency: ms(30)},
+				{Type: Success, Latency: ms(10), TTL: 60},
+				{Type: Success, Latency: ms(20)},
+			},
+			Want: PingResult{Type: Success, Latency: ms(10), TTL: 60, Probes: 3, Replies: 3, Spread: ms(20)},
+		},
+		{
+			Name:    "Median",
+			Pending: Waiting,
+			Probes: []PingResult{
+				{Type: Success, Latency: ms(30)},
+				{Type: Success, Latency: ms(10)},
+				{Type: Success, Latency: ms(20), TTL: 60},
+			},
+			How:  BurstMedian,
+			Want: PingResult{Type: Success, Latency: ms(20), TTL: 60, Probes: 3, Replies: 3, Spread: ms(20)},
+		},
+		{
+			Name:    "Median/Even",
+			Pending: Waiting,
+			Probes: []PingResult{
+				{Type: Success, Latency: ms(30)},
+				{Type: Waiting},
+				{Type: Success, Latency: ms(10)},
+			},
+			How:  BurstMedian,
+			Want: PingResult{Type: Success, Latency: ms(20), Probes: 3, Replies: 2, Spread: ms(20)},
+		},
+		{
+			Name:    "Dropped",
+			Pending: Waiting,
+			Probes:  []PingResult{{Type: Waiting}, {Type: Waiting}},
+			Want:    PingResult{Type: Dropped, Probes: 2},
+		},
+		{
+			Name:    "Unreachable",
+			Pending: Waiting,
+			Probes:  []PingResult{{Type: Waiting}, {Type: Unreachable, Latency: ms(5)}},
+			Want:    PingResult{Type: Unreachable, Latency: ms(5), Probes: 2},
+		},
+		{
+			Name:    "Gap",
+			Pending: Gap,
+			Probes:  []PingResult{{Type: Waiting}, {Type: Success, Latency: ms(5)}},
+			Want:    PingResult{Type: Gap},
+		},
+		{
+			Name:    "Gap/NoReplies",
+			Pending: Gap,
+			Probes:  []PingResult{{Type: Waiting}, {Type: Waiting}},
+			Want:    PingResult{Type: Gap},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got := combineBurst(PingResult{Type: c.Pending}, c.Probes, c.How)
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong result (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestParseBurstLatency(t *testing.T) {
+	for b := range numBurstLatencies {
+		got, err := ParseBurstLatency(b.String())
+		if err != nil || got != b {
+			t.Errorf("ParseBurstLatency(%q) = %v, %v (want %v, nil)", b.String(), got, err, b)
+		}
+	}
+	if _, err := ParseBurstLatency("max"); err == nil {
+		t.Errorf("ParseBurstLatency(\"max\") succeeded.")
+	}
+}
@@ -0,0 +1,104 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
+)
+
+// How often a pinger checks its socket for dropped replies.
+const dropCheckInterval = 10 * time.Second
+
+// Counts the replies a socket dropped between samples of its statistics.
+type dropCounter struct {
+	last int64 // Drops at the last sample, or -1 before the first.
+}
+
+func newDropCounter() *dropCounter {
+	return &dropCounter{last: -1}
+}
+
+// Takes a sample, and returns the number of drops since the last one. The
+// first sample is the baseline, since a shared socket may have dropped
+// replies meant for others. A count that goes down means a new socket, and
+// starts over.
+func (d *dropCounter) sample(st backend.SocketStats) int64 {
+	if st.Drops < 0 {
+		return 0
+	}
+	var n int64
+	if d.last >= 0 && st.Drops > d.last {
+		n = st.Drops - d.last
+	}
+	d.last = st.Drops
+	return n
+}
+
+// SocketStats returns the statistics of the socket the pinger reads replies
+// from. Pingers that share a socket report the same numbers. Returns
+// [backend.ErrNoSocketStats] if the backend doesn't have them, or while
+// reconnecting.
+func (p *Pinger) SocketStats() (backend.SocketStats, error) {
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+	if conn == nil {
+		return backend.SocketStats{}, backend.ErrNoSocketStats
+	}
+	return backend.GetSocketStats(conn)
+}
+
+// SocketDrops returns the number of replies the kernel has dropped from the
+// pinger's socket while it ran, because they arrived faster than they were
+// read. Their pings look lost, though the network didn't lose them. If
+// pingers share a socket, each counts every drop. Checked every 10s.
+func (p *Pinger) SocketDrops() int64 {
+	return p.socketDrops.Load()
+}
+
+// Samples the socket's statistics until ctx is canceled, and warns about
+// dropped replies. Returns right away if the backend has no statistics.
+func (p *Pinger) watchDrops(ctx context.Context) {
+	dc := newDropCounter()
+	// Returns false if there are no statistics to check.
+	check := func() bool {
+		p.mu.Lock()
+		conn := p.conn
+		p.mu.Unlock()
+		if conn == nil {
+			// Reconnecting.
+			return true
+		}
+		st, err := backend.GetSocketStats(conn)
+		if errors.Is(err, backend.ErrNoSocketStats) {
+			return false
+		}
+		if err != nil {
+			logging.Debugf("Error getting socket stats for %v: %v", p.dest, err)
+			return true
+		}
+		if n := dc.sample(st); n > 0 {
+			p.socketDrops.Add(n)
+			logging.Warnf("Kernel dropped %d replies from %v for want of receive buffer space. Their pings look lost.", n, p.dest)
+		}
+		return true
+	}
+	if !check() {
+		return
+	}
+	ticker := p.clock.NewTicker(dropCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if !check() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package pinger
+
+import (
+	"testing"
+
+	"github.com/pcekm/vasily/internal/backend"
+)
+
+func TestDropCounter(t *testing.T) {
+	cases := []struct {
+		Drops int64
+		Want  int64
+	}{
+		{Drops: 5, Want: 0}, // Baseline.
+		{Drops: 5, Want: 0},
+		{Drops: 8, Want: 3},
+		{Drops: -1, Want: 0}, // Not counted.
+		{Drops: 10, Want: 2},
+		{Drops: 1, Want: 0}, // New socket.
+		{Drops: 4, Want: 3},
+	}
+	dc := newDropCounter()
+	for i, c := range cases {
+		if got := dc.sample(backend.SocketStats{Drops: c.Drops}); got != c.Want {
+			t.Errorf("Sample %d: sample(Drops=%d) = %d (want %d)", i, c.Drops, got, c.Want)
+		}
+	}
+}
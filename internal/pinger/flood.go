@@ -0,0 +1,45 @@
+package pinger
+
+import "time"
+
+const (
+	// How often pings are sent in flood mode.
+	floodTick = 10 * time.Millisecond
+
+	// The most pings sent in a single flood mode batch.
+	maxFloodBatch = 64
+)
+
+// Sizes the batches of pings sent on each flood mode tick, in a manner similar
+// to ping -f. One ping is sent per tick, plus one for every reply received
+// since the last tick, so the send rate rises to match the rate replies come
+// back. If maxPPS is nonzero, a token bucket caps the rate.
+type floodBatcher struct {
+	maxPPS int
+	tokens float64
+	last   time.Time
+}
+
+func newFloodBatcher(maxPPS int, now time.Time) *floodBatcher {
+	return &floodBatcher{
+		maxPPS: maxPPS,
+		tokens: 1,
+		last:   now,
+	}
+}
+
+// Next returns the number of pings to send at time now, given the number of
+// replies received since the last call.
+func (b *floodBatcher) Next(now time.Time, replies int) int {
+	n := min(1+replies, maxFloodBatch)
+	if b.maxPPS == 0 {
+		return n
+	}
+	// Allow a burst of two ticks' worth of pings to absorb ticker jitter.
+	burst := max(1, 2*float64(b.maxPPS)*floodTick.Seconds())
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(b.maxPPS), burst)
+	b.last = now
+	n = min(n, int(b.tokens))
+	b.tokens -= float64(n)
+	return n
+}
@@ -0,0 +1,50 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFloodBatcher(t *testing.T) {
+	cases := []struct {
+		name    string
+		maxPPS  int
+		replies []int
+		want    []int
+	}{
+		{
+			name:    "Uncapped",
+			replies: []int{0, 1, 5, 0, 1000},
+			want:    []int{1, 2, 6, 1, maxFloodBatch},
+		},
+		{
+			// 1000 pps allows 10 per tick, and a burst of 20.
+			name:    "Capped",
+			maxPPS:  1000,
+			replies: []int{0, 0, 30, 30, 5},
+			want:    []int{1, 1, 19, 10, 6},
+		},
+		{
+			// 50 pps allows one ping every other tick.
+			name:    "SlowerThanTicks",
+			maxPPS:  50,
+			replies: []int{0, 0, 0, 0, 0},
+			want:    []int{1, 0, 1, 0, 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			now := time.Unix(1000, 0)
+			b := newFloodBatcher(c.maxPPS, now)
+			var got []int
+			for i, r := range c.replies {
+				got = append(got, b.Next(now.Add(time.Duration(i)*floodTick), r))
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("Wrong batch sizes (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
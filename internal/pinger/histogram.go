@@ -0,0 +1,68 @@
+package pinger
+
+import (
+	"slices"
+	"time"
+)
+
+// HistogramBounds are the upper bounds of the latency histogram's buckets,
+// roughly evenly spaced on a log scale so that latencies from a LAN to a
+// satellite link are all told apart. Latencies above the last go in a bucket
+// of their own.
+var HistogramBounds = []time.Duration{
+	time.Millisecond,
+	1500 * time.Microsecond,
+	2 * time.Millisecond,
+	3 * time.Millisecond,
+	5 * time.Millisecond,
+	7 * time.Millisecond,
+	10 * time.Millisecond,
+	15 * time.Millisecond,
+	20 * time.Millisecond,
+	30 * time.Millisecond,
+	50 * time.Millisecond,
+	70 * time.Millisecond,
+	100 * time.Millisecond,
+	150 * time.Millisecond,
+	200 * time.Millisecond,
+	300 * time.Millisecond,
+	500 * time.Millisecond,
+	700 * time.Millisecond,
+	time.Second,
+	1500 * time.Millisecond,
+	2 * time.Second,
+}
+
+// Histogram counts the latencies of successful pings in buckets. Unlike the
+// average, it shows latencies that cluster in more than one place, such as
+// when some replies are held up by wifi retries.
+type Histogram struct {
+	// Counts has one more element than HistogramBounds. Counts[i] is the
+	// number of latencies at or below HistogramBounds[i] and above the
+	// bound before it. The last counts everything above the last bound.
+	Counts []int
+}
+
+func newHistogram() Histogram {
+	return Histogram{Counts: make([]int, len(HistogramBounds)+1)}
+}
+
+// Counts a latency.
+func (h Histogram) add(latency time.Duration) {
+	i, _ := slices.BinarySearch(HistogramBounds, latency)
+	h.Counts[i]++
+}
+
+// Total returns the number of latencies counted.
+func (h Histogram) Total() int {
+	var n int
+	for _, c := range h.Counts {
+		n += c
+	}
+	return n
+}
+
+// Clone returns a copy of h.
+func (h Histogram) Clone() Histogram {
+	return Histogram{Counts: slices.Clone(h.Counts)}
+}
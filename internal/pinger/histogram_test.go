@@ -0,0 +1,60 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram()
+	for _, lat := range []time.Duration{
+		500 * time.Microsecond,
+		time.Millisecond,
+		1100 * time.Microsecond,
+		12 * time.Millisecond,
+		14 * time.Millisecond,
+		time.Minute,
+	} {
+		h.add(lat)
+	}
+	want := map[int]int{
+		0:                    2, // At or below 1ms.
+		1:                    1, // 1.5ms.
+		7:                    2, // 15ms.
+		len(HistogramBounds): 1, // Above 2s.
+	}
+	for i, c := range h.Counts {
+		if c != want[i] {
+			t.Errorf("Counts[%d] = %d (want %d)", i, c, want[i])
+		}
+	}
+	if h.Total() != 6 {
+		t.Errorf("Total() = %d (want 6)", h.Total())
+	}
+}
+
+func TestHistory_Histogram(t *testing.T) {
+	start := time.Now()
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(4)
+	h.clock = c
+
+	addIncRec := func(seq, ms int, tp ResultType) {
+		h.Add(seq)
+		c.Increment(time.Duration(ms) * time.Millisecond)
+		res := h.Get(seq)
+		res.Type = tp
+		h.Record(seq, res)
+	}
+	addIncRec(0, 4, Success)
+	addIncRec(1, 25, Success)
+	addIncRec(2, 4, Success)
+	addIncRec(3, 40, Dropped)
+
+	got := h.Histogram()
+	if got.Counts[4] != 2 || got.Counts[9] != 1 || got.Total() != 3 {
+		t.Errorf("Wrong counts: %v (want 2 in 5ms, 1 in 30ms)", got.Counts)
+	}
+}
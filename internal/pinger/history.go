@@ -9,21 +9,52 @@ import (
 	"time"
 
 	"code.cloudfoundry.org/clock"
+	"github.com/pcekm/vasily/internal/logging"
 )
 
 // Stats holds statistics for a ping session.
 type Stats struct {
-	// N is the number of pings represented in these stats.
+	// N is the number of pings represented in these stats. Pings that fell
+	// into a gap are not counted. Each probe in a burst counts separately.
 	N int
 
 	// Failures is the number of pings without a successful reply.
 	Failures int
 
-	// AvgLatency is the average latency of successful pings.
+	// Late is the number of replies that arrived after their pings were
+	// counted as dropped. They're still counted in Failures.
+	Late int
+
+	// Duplicates is the number of extra replies to pings that were already
+	// answered. They don't change the first reply's result.
+	Duplicates int
+
+	// Corrupted is the number of replies that failed payload verification.
+	// They're counted in Failures. Each probe in a burst counts separately.
+	Corrupted int
+
+	// AvgLatency is the average latency of successful pings. For bursts,
+	// this is over the combined latency of each burst.
 	AvgLatency time.Duration
 
 	// StdDev is the standard deviation of successful ping latencies.
 	StdDev time.Duration
+
+	// Jitter is a running estimate of the interarrival jitter, as defined in
+	// RFC 3550: a smoothed mean of the differences between the latencies of
+	// successive successful pings.
+	Jitter time.Duration
+
+	// MinLatency and MaxLatency are the lowest and highest latencies of
+	// successful pings.
+	MinLatency, MaxLatency time.Duration
+
+	// LastLatency is the latency of the most recent successful ping.
+	LastLatency time.Duration
+
+	// P50, P95 and P99 are estimates of the 50th, 95th and 99th percentile
+	// latencies of successful pings.
+	P50, P95, P99 time.Duration
 }
 
 // PacketLoss is the fraction of dropped packets.
@@ -31,6 +62,15 @@ func (s Stats) PacketLoss() float64 {
 	return float64(s.Failures) / float64(s.N)
 }
 
+// DuplicateRate is the number of duplicate replies as a fraction of the pings
+// sent.
+func (s Stats) DuplicateRate() float64 {
+	return float64(s.Duplicates) / float64(s.N)
+}
+
+// The most results a history grows to in order to meet its retention time.
+const maxRetainedHistory = 1 << 16
+
 type pingHistory struct {
 	// This is a ring buffer. The index for a given sequence number is given by:
 	//    i = seq % len(history)
@@ -40,21 +80,154 @@ type pingHistory struct {
 	m2      time.Duration
 	len     int
 	lastSeq int
-	clock   clock.Clock
+
+	// Latency of the last successful ping, for calculating the jitter.
+	prevLatency time.Duration
+	// Set by a gap, so that the jitter isn't taken across it.
+	afterGap bool
+
+	// Number of results, and successful results, counted in stats. These
+	// differ from the N in stats when pings are sent in bursts.
+	nResults, nSuccess int
+
+	// The oldest sequence number that may be in the ring buffer. After the
+	// buffer grows, slots for anything older are empty.
+	minSeq int
+	clock  clock.Clock
+
+	// If nonzero, the ring buffer grows as needed to keep results for at
+	// least this long, up to maxRetainedHistory.
+	retention time.Duration
+
+	// Pings still waiting for a reply or a timeout, by sequence number. These
+	// are tracked apart from the ring buffer, so that pings with a timeout
+	// longer than the history can hold still count once their slots have
+	// been reused.
+	pending map[int]PingResult
+
+	// Results of each probe in pending bursts of more than one probe.
+	bursts map[int][]PingResult
+
+	// How the latencies of a burst's replies are combined.
+	burstLatency BurstLatency
+
+	// Streaming latency percentile estimators.
+	p50, p95, p99 *quantile
+
+	// Counts of successful latencies in buckets.
+	latencies Histogram
+
+	// Statistics over the last few minutes.
+	windows *windows
+
+	// Outages detected so far, and the next sequence number to check for
+	// them. Results are checked in sequence order once they stop pending.
+	outages   *outageLog
+	outageSeq int
 }
 
 func newHistory(n int) *pingHistory {
 	return &pingHistory{
-		history: make([]PingResult, n),
-		lastSeq: -1,
-		clock:   clock.NewClock(),
+		history:   make([]PingResult, n),
+		p50:       newQuantile(0.5),
+		p95:       newQuantile(0.95),
+		p99:       newQuantile(0.99),
+		windows:   newWindows(),
+		latencies: newHistogram(),
+		lastSeq:   -1,
+		clock:     clock.NewClock(),
+		pending:   make(map[int]PingResult),
+		bursts:    make(map[int][]PingResult),
+		outages:   newOutageLog(defaultOutageThreshold),
+	}
+}
+
+// Size returns the size of the ring buffer.
+func (h *pingHistory) Size() int {
+	return len(h.history)
+}
+
+// Resize changes the size of the ring buffer. The most recent results that
+// fit are kept. Pending pings aren't affected.
+func (h *pingHistory) Resize(n int) {
+	n = max(n, 1)
+	if n == len(h.history) {
+		return
+	}
+	// The index of a given seq changes with the size, so each result has to
+	// be moved to its new slot.
+	hist := make([]PingResult, n)
+	h.minSeq = max(h.firstSeq(), h.lastSeq-n+1)
+	for seq := h.minSeq; seq <= h.lastSeq; seq++ {
+		hist[seq%n] = h.history[seq%len(h.history)]
+	}
+	h.history = hist
+}
+
+// Returns the oldest sequence number in the ring buffer.
+func (h *pingHistory) firstSeq() int {
+	return max(0, h.minSeq, h.lastSeq-len(h.history)+1)
+}
+
+// Returns true if seq is in the ring buffer.
+func (h *pingHistory) inRing(seq int) bool {
+	return seq >= h.firstSeq() && seq <= h.lastSeq
+}
+
+// SetRetention sets the minimum time to keep results for. Zero keeps a fixed
+// number of results.
+func (h *pingHistory) SetRetention(d time.Duration) {
+	h.retention = d
+}
+
+// SetOutageThreshold sets the number of consecutive lost pings that count as
+// an outage. Only affects outages that haven't ended yet.
+func (h *pingHistory) SetOutageThreshold(n int) {
+	h.outages.threshold = n
+}
+
+// Events returns the outages detected, oldest first.
+func (h *pingHistory) Events() []Event {
+	return h.outages.Events()
+}
+
+// Checks results that are no longer pending for outages, in sequence order.
+func (h *pingHistory) advanceOutages() {
+	for h.outageSeq <= h.lastSeq {
+		if _, ok := h.pending[h.outageSeq]; ok {
+			return
+		}
+		// Results that have left the ring buffer come back as waiting, and
+		// are ignored.
+		h.outages.Add(h.Get(h.outageSeq))
+		h.outageSeq++
+	}
+}
+
+// SetBurstLatency sets how the latencies of a burst's replies are combined.
+func (h *pingHistory) SetBurstLatency(b BurstLatency) {
+	h.burstLatency = b
+}
+
+// Grows the ring buffer if the result about to be overwritten by seq is
+// newer than the retention time.
+func (h *pingHistory) maybeGrow(seq int) {
+	if h.retention == 0 || len(h.history) >= maxRetainedHistory {
+		return
+	}
+	if seq-len(h.history) < h.firstSeq() {
+		// The slot is empty.
+		return
+	}
+	if h.clock.Since(h.history[seq%len(h.history)].Time) < h.retention {
+		h.Resize(min(2*len(h.history), maxRetainedHistory))
 	}
 }
 
 // Get gets the result for the given sequence number. Returns the zero value if
 // that sequence number is no longer in the history.
 func (h *pingHistory) Get(seq int) PingResult {
-	if seq < h.lastSeq-len(h.history)+1 {
+	if seq < h.firstSeq() {
 		// That seq is long gone.
 		return PingResult{}
 	}
@@ -62,48 +235,270 @@ func (h *pingHistory) Get(seq int) PingResult {
 	return h.history[i]
 }
 
-// Add records a ping that has just been sent. The seq arg must match the next
-// sequence number, and panics if it doesn't.
+// UnwrapSeq converts a sequence number from the wire into the most recent
+// full sequence number it could refer to, when pings are sent in bursts of n.
+// Probe i of burst seq goes out on the wire as (seq*n+i)&mask. Also returns
+// the index of the probe in its burst.
+func (h *pingHistory) UnwrapSeq(wire, n, mask int) (seq, i int) {
+	last := (h.lastSeq+1)*n - 1
+	probe := last - (last-wire)&mask
+	return probe / n, probe % n
+}
+
+// Pending gets the result for a ping that's still waiting for a reply or a
+// timeout. Returns false if the ping isn't pending.
+func (h *pingHistory) Pending(seq int) (PingResult, bool) {
+	r, ok := h.pending[seq]
+	return r, ok
+}
+
+// Outstanding returns the number of pending pings.
+func (h *pingHistory) Outstanding() int {
+	return len(h.pending)
+}
+
+// Forget stops tracking a pending ping without recording a result.
+func (h *pingHistory) Forget(seq int) {
+	h.forget(seq)
+	h.advanceOutages()
+}
+
+// Stops tracking a pending ping, as for Forget, without checking for outages.
+func (h *pingHistory) forget(seq int) {
+	delete(h.pending, seq)
+	delete(h.bursts, seq)
+}
+
+// Add records a ping that has just been sent, and marks it pending. The seq
+// arg must match the next sequence number, and panics if it doesn't.
 func (h *pingHistory) Add(seq int) {
+	h.pending[seq] = h.addSlot(seq)
+}
+
+// AddBurst records a burst of n probes that has just been sent, as for Add.
+// The probes' results are set with RecordProbe. A burst of one is the same
+// as a single ping.
+func (h *pingHistory) AddBurst(seq, n int) {
+	if n == 1 {
+		h.Add(seq)
+		return
+	}
+	r := h.addSlot(seq)
+	r.Probes = n
+	h.history[seq%len(h.history)] = r
+	h.pending[seq] = r
+	h.bursts[seq] = slices.Repeat([]PingResult{{Type: Waiting}}, n)
+}
+
+// IsBurst returns true if seq is a pending burst of more than one probe.
+func (h *pingHistory) IsBurst(seq int) bool {
+	_, ok := h.bursts[seq]
+	return ok
+}
+
+// RecordProbe sets the result of probe i in a pending burst. Once every probe
+// has a result, the burst's combined result is recorded and returned along
+// with true. Repeated replies to a probe are ignored. The probe's latency is
+// measured now unless it's already set.
+func (h *pingHistory) RecordProbe(seq, i int, r PingResult) (PingResult, bool) {
+	probes, ok := h.bursts[seq]
+	if !ok || i < 0 || i >= len(probes) {
+		return PingResult{}, false
+	}
+	if probes[i].Type != Waiting {
+		logging.Debugf("Duplicate reply to probe %d of burst %d.", i, seq)
+		h.stats.Duplicates++
+		return PingResult{}, false
+	}
+	if r.Type == Corrupted {
+		h.stats.Corrupted++
+	}
+	if r.Latency == 0 {
+		r.Latency = h.clock.Since(h.pending[seq].Time)
+	}
+	probes[i] = r
+	if slices.ContainsFunc(probes, func(r PingResult) bool { return r.Type == Waiting }) {
+		return PingResult{}, false
+	}
+	return h.FinishBurst(seq)
+}
+
+// FinishBurst records the combined result of a pending burst. Probes still
+// waiting count as dropped. A burst that fell into a gap isn't counted at all,
+// and returns false.
+func (h *pingHistory) FinishBurst(seq int) (PingResult, bool) {
+	probes, ok := h.bursts[seq]
+	if !ok {
+		return PingResult{}, false
+	}
+	res := combineBurst(h.pending[seq], probes, h.burstLatency)
+	if res.Type == Gap {
+		h.Forget(seq)
+		return PingResult{}, false
+	}
+	return h.record(seq, res), true
+}
+
+// Adds a waiting result to the ring buffer.
+func (h *pingHistory) addSlot(seq int) PingResult {
 	if h.lastSeq+1 != seq {
 		log.Panicf("Wrong sequence number: %d (want %d)", seq, h.lastSeq+1)
 	}
-	i := seq % len(h.history)
-	h.history[i] = PingResult{
+	h.maybeGrow(seq)
+	r := PingResult{
 		Type: Waiting,
 		Time: h.clock.Now(),
 	}
+	h.history[seq%len(h.history)] = r
 	h.lastSeq = seq
+	return r
 }
 
-// Records sets the result for the given sequence number. Returns the PingResult
-// updated with latency.
+// Records sets the result for the given sequence number, which stops being
+// pending. Returns the PingResult updated with latency. Results for pings that
+// are neither pending nor still in the ring buffer are dropped. Duplicates
+// are only counted, leaving the result that came first.
 func (h *pingHistory) Record(seq int, r PingResult) PingResult {
-	if h.lastSeq-seq >= len(h.history) {
-		log.Printf("Seq %d too late to record in history.", seq)
+	r.Latency = h.clock.Since(r.Time)
+	return h.record(seq, r)
+}
+
+// RecordLatency records a result with its latency already set, as for
+// Record.
+func (h *pingHistory) RecordLatency(seq int, r PingResult) PingResult {
+	return h.record(seq, r)
+}
+
+// Records a result with its latency already set, as for Record.
+func (h *pingHistory) record(seq int, r PingResult) PingResult {
+	if r.Type == Duplicate {
+		h.stats.Duplicates++
 		return r
 	}
-	i := seq % len(h.history)
-	r.Latency = h.clock.Since(r.Time)
-	h.history[i] = r
-	if r.Type != Duplicate {
+	_, pending := h.pending[seq]
+	inRing := h.inRing(seq)
+	if !pending && !inRing {
+		logging.Debugf("Seq %d too late to record in history.", seq)
+		return r
+	}
+	if r.Type != Gap && h.inGap(seq) {
+		// The latency of a reply to a ping that fell into a gap may span
+		// the gap, so it's left out of the statistics along with the rest.
+		logging.Debugf("Seq %d fell into a gap; not counting reply.", seq)
+		r.Type = Gap
+	}
+	h.forget(seq)
+	if inRing {
+		h.history[seq%len(h.history)] = r
+	}
+	if r.Type != Gap {
 		h.addStatsFor(r)
 	}
+	h.advanceOutages()
 	return r
 }
 
+// Replay sets the result for the given sequence number to a previously
+// recorded result, keeping its time and latency. Any skipped sequence numbers
+// are added as waiting.
+func (h *pingHistory) Replay(seq int, r PingResult) {
+	for h.lastSeq < seq {
+		h.addSlot(h.lastSeq + 1)
+	}
+	if r.Type == Duplicate {
+		h.stats.Duplicates++
+		return
+	}
+	if !h.inRing(seq) {
+		logging.Debugf("Seq %d too late to replay in history.", seq)
+		return
+	}
+	h.history[seq%len(h.history)] = r
+	if r.Type != Gap {
+		h.addStatsFor(r)
+	}
+	h.advanceOutages()
+}
+
+// Returns true if seq is marked as a gap, either in the ring buffer or while
+// it's still pending.
+func (h *pingHistory) inGap(seq int) bool {
+	if r, ok := h.pending[seq]; ok {
+		return r.Type == Gap
+	}
+	return h.inRing(seq) && h.history[seq%len(h.history)].Type == Gap
+}
+
+// MarkGap converts all results that are still waiting for a reply into gaps.
+// Neither a reply that arrives later nor a timeout is counted in the
+// statistics, and the jitter isn't taken across the gap.
+func (h *pingHistory) MarkGap() {
+	h.afterGap = true
+	h.windows.Gap()
+	for seq := h.firstSeq(); seq <= h.lastSeq; seq++ {
+		i := seq % len(h.history)
+		if h.history[i].Type == Waiting {
+			h.history[i].Type = Gap
+		}
+	}
+	for seq, r := range h.pending {
+		if r.Type == Waiting {
+			r.Type = Gap
+			h.pending[seq] = r
+		}
+	}
+}
+
 // Adds stats for a new record.
 func (h *pingHistory) addStatsFor(r PingResult) {
-	h.stats.N++
+	if r.Type == Late {
+		// The ping was already counted when it was dropped.
+		h.stats.Late++
+		return
+	}
+	if r.Type == Corrupted && r.Probes == 0 {
+		// Corrupted probes in bursts were counted as they arrived.
+		h.stats.Corrupted++
+	}
+	h.windows.Add(r)
+	n, failed := probeCounts(r)
+	h.stats.N += n
+	h.stats.Failures += failed
+	h.nResults++
 	if r.Type != Success {
-		h.stats.Failures++
 		return
 	}
-	n := time.Duration(h.stats.N - h.stats.Failures)
+	h.nSuccess++
+	ns := time.Duration(h.nSuccess)
 	prevAvg := h.stats.AvgLatency
-	h.stats.AvgLatency = ((n-1)*h.stats.AvgLatency + r.Latency) / n
+	h.stats.AvgLatency = ((ns-1)*h.stats.AvgLatency + r.Latency) / ns
 	h.m2 = h.m2 + (r.Latency-prevAvg)*(r.Latency-h.stats.AvgLatency)
-	h.stats.StdDev = time.Duration(math.Sqrt(float64(h.m2) / float64(h.stats.N)))
+	h.stats.StdDev = time.Duration(math.Sqrt(float64(h.m2) / float64(h.nResults)))
+
+	if h.nSuccess == 1 {
+		h.stats.MinLatency = r.Latency
+		h.stats.MaxLatency = r.Latency
+	} else {
+		h.stats.MinLatency = min(h.stats.MinLatency, r.Latency)
+		h.stats.MaxLatency = max(h.stats.MaxLatency, r.Latency)
+	}
+	if h.nSuccess > 1 && !h.afterGap {
+		// RFC 3550 section 6.4.1. The send times cancel out of the
+		// difference in transit times, leaving the difference in latencies.
+		d := r.Latency - h.prevLatency
+		h.stats.Jitter += (d.Abs() - h.stats.Jitter) / 16
+	}
+	h.prevLatency = r.Latency
+	h.afterGap = false
+	h.stats.LastLatency = r.Latency
+
+	h.p50.Add(float64(r.Latency))
+	h.p95.Add(float64(r.Latency))
+	h.p99.Add(float64(r.Latency))
+	h.stats.P50 = time.Duration(h.p50.Value())
+	h.stats.P95 = time.Duration(h.p95.Value())
+	h.stats.P99 = time.Duration(h.p99.Value())
+	h.latencies.add(r.Latency)
 }
 
 // RevResults iterates over sequence#, result from newest to oldest.
@@ -112,11 +507,7 @@ func (h *pingHistory) RevResults(mu *sync.Mutex) iter.Seq2[int, PingResult] {
 	return func(yield func(k int, v PingResult) bool) {
 		mu.Lock()
 		defer mu.Unlock()
-		firstSeq := h.lastSeq - len(h.history) + 1
-		if firstSeq < 0 {
-			firstSeq = 0
-		}
-		for seq := h.lastSeq; seq >= firstSeq; seq-- {
+		for seq := h.lastSeq; seq >= h.firstSeq(); seq-- {
 			if !yield(seq, h.history[seq%len(h.history)]) {
 				return
 			}
@@ -148,3 +539,13 @@ func (h *pingHistory) Latest() PingResult {
 func (h *pingHistory) Stats() Stats {
 	return h.stats
 }
+
+// Histogram returns the counts of all the successful latencies.
+func (h *pingHistory) Histogram() Histogram {
+	return h.latencies.Clone()
+}
+
+// WindowStats returns the statistics for one of [StatsWindows].
+func (h *pingHistory) WindowStats(d time.Duration) Stats {
+	return h.windows.Stats(d)
+}
@@ -1,6 +1,7 @@
 package pinger
 
 import (
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -33,6 +34,36 @@ func TestAdd_WrongSeq(t *testing.T) {
 	}
 }
 
+func TestUnwrapSeq(t *testing.T) {
+	cases := []struct {
+		lastSeq, wire, n, mask, want, wantI int
+	}{
+		{lastSeq: 5, wire: 5, n: 1, want: 5},
+		{lastSeq: 5, wire: 3, n: 1, want: 3},
+		{lastSeq: 65536, wire: 0, n: 1, want: 65536},
+		{lastSeq: 65537, wire: 65535, n: 1, want: 65535},
+		{lastSeq: 200000, wire: 200000 & sequenceNoMask, n: 1, want: 200000},
+		{lastSeq: 200000, wire: 199990 & sequenceNoMask, n: 1, want: 199990},
+		{lastSeq: 5, wire: 17, n: 3, want: 5, wantI: 2},
+		{lastSeq: 5, wire: 12, n: 3, want: 4, wantI: 0},
+		{lastSeq: 21845, wire: 0, n: 3, want: 21845, wantI: 1},
+		{lastSeq: 21845, wire: 65534, n: 3, want: 21844, wantI: 2},
+		{lastSeq: 1024, wire: 0, n: 1, mask: 1023, want: 1024},
+		{lastSeq: 1025, wire: 1023, n: 1, mask: 1023, want: 1023},
+	}
+	for _, c := range cases {
+		h := newHistory(1)
+		h.lastSeq = c.lastSeq
+		mask := c.mask
+		if mask == 0 {
+			mask = sequenceNoMask
+		}
+		if got, i := h.UnwrapSeq(c.wire, c.n, mask); got != c.want || i != c.wantI {
+			t.Errorf("UnwrapSeq(%d, %d, %d) with lastSeq %d = %d, %d (want %d, %d)", c.wire, c.n, mask, c.lastSeq, got, i, c.want, c.wantI)
+		}
+	}
+}
+
 func TestGet_Empty(t *testing.T) {
 	h := newHistory(1)
 	if diff := cmp.Diff(PingResult{}, h.Get(0)); diff != "" {
@@ -49,6 +80,67 @@ func TestGet_Missing(t *testing.T) {
 	}
 }
 
+func TestPending(t *testing.T) {
+	h := newHistory(1)
+	h.Add(0)
+	h.Add(1)
+	if n := h.Outstanding(); n != 2 {
+		t.Errorf("Outstanding() = %d (want 2)", n)
+	}
+	// Seq 0's slot has been reused, but it's still pending.
+	if r, ok := h.Pending(0); !ok || r.Type != Waiting {
+		t.Errorf("Pending(0) = %v, %v (want Waiting, true)", r, ok)
+	}
+	h.Record(1, PingResult{Type: Success})
+	if _, ok := h.Pending(1); ok {
+		t.Errorf("Seq 1 still pending after Record.")
+	}
+	h.Forget(0)
+	if n := h.Outstanding(); n != 0 {
+		t.Errorf("Outstanding() = %d (want 0)", n)
+	}
+}
+
+func TestRecord_AfterSlotReused(t *testing.T) {
+	start := time.Now()
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(2)
+	h.clock = c
+
+	for seq := range 4 {
+		h.Add(seq)
+	}
+	c.Increment(10 * time.Millisecond)
+	for seq, tp := range []ResultType{Success, Dropped, Success, Dropped} {
+		res, _ := h.Pending(seq)
+		res.Type = tp
+		h.Record(seq, res)
+	}
+
+	// Everything counts, even though only the last two results are still in
+	// the history.
+	want := Stats{
+		N:           4,
+		Failures:    2,
+		AvgLatency:  10 * time.Millisecond,
+		MinLatency:  10 * time.Millisecond,
+		MaxLatency:  10 * time.Millisecond,
+		LastLatency: 10 * time.Millisecond,
+		P50:         10 * time.Millisecond,
+		P95:         10 * time.Millisecond,
+		P99:         10 * time.Millisecond,
+	}
+	if diff := cmp.Diff(want, h.Stats()); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+
+	// Unknown and long gone.
+	h.Record(0, PingResult{Type: Success})
+	if n := h.Stats().N; n != 4 {
+		t.Errorf("Late result counted: N = %d (want 4)", n)
+	}
+}
+
 func TestStats(t *testing.T) {
 	start := time.Now()
 	c := fakeclock.NewFakeClock(start)
@@ -69,10 +161,17 @@ func TestStats(t *testing.T) {
 	addIncRec(3, 40, Dropped)
 
 	want := Stats{
-		N:          4,
-		Failures:   2,
-		AvgLatency: 15 * time.Millisecond,
-		StdDev:     5 * time.Millisecond,
+		N:           4,
+		Failures:    2,
+		AvgLatency:  15 * time.Millisecond,
+		StdDev:      5 * time.Millisecond,
+		Jitter:      625 * time.Microsecond,
+		MinLatency:  10 * time.Millisecond,
+		MaxLatency:  20 * time.Millisecond,
+		LastLatency: 20 * time.Millisecond,
+		P50:         10 * time.Millisecond,
+		P95:         20 * time.Millisecond,
+		P99:         20 * time.Millisecond,
 	}
 
 	if diff := cmp.Diff(want, h.Stats()); diff != "" {
@@ -101,10 +200,17 @@ func TestStats_Overflow(t *testing.T) {
 	addIncRec(4, 50, Success)
 
 	want := Stats{
-		N:          5,
-		Failures:   2,
-		AvgLatency: 40 * time.Millisecond,
-		StdDev:     6 * time.Millisecond,
+		N:           5,
+		Failures:    2,
+		AvgLatency:  40 * time.Millisecond,
+		StdDev:      6 * time.Millisecond,
+		Jitter:      1 * time.Millisecond,
+		MinLatency:  30 * time.Millisecond,
+		MaxLatency:  50 * time.Millisecond,
+		LastLatency: 50 * time.Millisecond,
+		P50:         40 * time.Millisecond,
+		P95:         50 * time.Millisecond,
+		P99:         50 * time.Millisecond,
 	}
 
 	opt := cmp.Transformer("Duration", func(in time.Duration) int64 {
@@ -218,3 +324,373 @@ func TestLatest(t *testing.T) {
 		t.Errorf("Wrong RevResults (-want, +got):\n%v", diff)
 	}
 }
+
+func TestStats_Gap(t *testing.T) {
+	start := time.Now()
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(8)
+	h.clock = c
+
+	addIncRec := func(seq int, d time.Duration, tp ResultType) {
+		h.Add(seq)
+		c.Increment(d)
+		res := h.Get(seq)
+		res.Type = tp
+		h.Record(seq, res)
+	}
+
+	addIncRec(0, 10*time.Millisecond, Success)
+	addIncRec(1, 10*time.Millisecond, Success)
+
+	// Pause with two pings outstanding, and resume much later. The reply to
+	// seq 2 arrives after the pause, and seq 3 times out.
+	h.Add(2)
+	h.Add(3)
+	h.MarkGap()
+	c.Increment(10 * time.Minute)
+	res := h.Get(2)
+	res.Type = Success
+	h.Record(2, res)
+	res = h.Get(3)
+	res.Type = Dropped
+	h.Record(3, res)
+
+	addIncRec(4, 20*time.Millisecond, Success)
+	addIncRec(5, 20*time.Millisecond, Success)
+
+	for seq := 2; seq <= 3; seq++ {
+		if got := h.Get(seq).Type; got != Gap {
+			t.Errorf("Wrong type for seq %d: %v (want %v)", seq, got, Gap)
+		}
+	}
+
+	// Neither the reply's latency nor the timeout is counted, and the jitter
+	// isn't taken across the gap.
+	opt := cmp.Transformer("Duration", func(in time.Duration) time.Duration {
+		return in.Round(100 * time.Microsecond)
+	})
+	got := h.Stats()
+	want := Stats{
+		N:           4,
+		Failures:    0,
+		AvgLatency:  15 * time.Millisecond,
+		StdDev:      got.StdDev,
+		Jitter:      0,
+		MinLatency:  10 * time.Millisecond,
+		MaxLatency:  20 * time.Millisecond,
+		LastLatency: 20 * time.Millisecond,
+		P50:         got.P50,
+		P95:         20 * time.Millisecond,
+		P99:         20 * time.Millisecond,
+	}
+	if diff := cmp.Diff(want, got, opt); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+	if got.P50 < 10*time.Millisecond || got.P50 > 20*time.Millisecond {
+		t.Errorf("P50 = %v (want between 10ms and 20ms)", got.P50)
+	}
+
+	// The window that ends after the pause only has the pings after it.
+	want = Stats{
+		N:           2,
+		AvgLatency:  20 * time.Millisecond,
+		MinLatency:  20 * time.Millisecond,
+		MaxLatency:  20 * time.Millisecond,
+		LastLatency: 20 * time.Millisecond,
+		P50:         20 * time.Millisecond,
+		P95:         20 * time.Millisecond,
+		P99:         20 * time.Millisecond,
+	}
+	if diff := cmp.Diff(want, h.WindowStats(time.Minute), opt); diff != "" {
+		t.Errorf("Wrong window stats (-want, +got):\n%v", diff)
+	}
+}
+
+func TestMarkGap_OnlyWaiting(t *testing.T) {
+	h := newHistory(4)
+	h.Add(0)
+	h.Record(0, PingResult{Type: Dropped})
+	h.Add(1)
+	h.MarkGap()
+
+	var mu sync.Mutex
+	var got []ResultType
+	for _, r := range h.RevResults(&mu) {
+		got = append(got, r.Type)
+	}
+	want := []ResultType{Gap, Dropped}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong result types (-want, +got):\n%v", diff)
+	}
+}
+
+func TestMarkGap_Pending(t *testing.T) {
+	h := newHistory(1)
+	h.Add(0)
+	h.Add(1)
+	h.MarkGap()
+	for seq := range 2 {
+		if r, _ := h.Pending(seq); r.Type != Gap {
+			t.Errorf("Pending seq %d has type %v (want %v)", seq, r.Type, Gap)
+		}
+	}
+}
+
+func TestBurst(t *testing.T) {
+	start := time.Now()
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(4)
+	h.clock = c
+
+	h.AddBurst(0, 3)
+	c.Increment(10 * time.Millisecond)
+	if _, ok := h.RecordProbe(0, 1, PingResult{Type: Success}); ok {
+		t.Errorf("Burst finished after one of three replies.")
+	}
+	c.Increment(10 * time.Millisecond)
+	if _, ok := h.RecordProbe(0, 0, PingResult{Type: Success}); ok {
+		t.Errorf("Burst finished after two of three replies.")
+	}
+	if _, ok := h.RecordProbe(0, 0, PingResult{Type: Success}); ok {
+		t.Errorf("Burst finished after a duplicate reply.")
+	}
+	got, ok := h.FinishBurst(0)
+	if !ok {
+		t.Fatalf("FinishBurst(0) recorded nothing.")
+	}
+
+	want := PingResult{Type: Success, Time: start, Latency: 10 * time.Millisecond, Spread: 10 * time.Millisecond, Probes: 3, Replies: 2}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong burst result (-want, +got):\n%v", diff)
+	}
+	if diff := cmp.Diff(want, h.Get(0)); diff != "" {
+		t.Errorf("Wrong history (-want, +got):\n%v", diff)
+	}
+	if h.Outstanding() != 0 || h.IsBurst(0) {
+		t.Errorf("Burst still pending after FinishBurst.")
+	}
+
+	wantStats := Stats{
+		N:           3,
+		Failures:    1,
+		Duplicates:  1,
+		AvgLatency:  10 * time.Millisecond,
+		MinLatency:  10 * time.Millisecond,
+		MaxLatency:  10 * time.Millisecond,
+		LastLatency: 10 * time.Millisecond,
+		P50:         10 * time.Millisecond,
+		P95:         10 * time.Millisecond,
+		P99:         10 * time.Millisecond,
+	}
+	if diff := cmp.Diff(wantStats, h.Stats()); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+}
+
+func TestBurst_AllReplied(t *testing.T) {
+	h := newHistory(4)
+	h.AddBurst(0, 2)
+	h.RecordProbe(0, 0, PingResult{Type: Success})
+	got, ok := h.RecordProbe(0, 1, PingResult{Type: Success})
+	if !ok || got.Type != Success || got.Replies != 2 {
+		t.Errorf("RecordProbe(0, 1) = %v, %v (want 2 successful replies, true)", got, ok)
+	}
+}
+
+func TestBurst_Gap(t *testing.T) {
+	h := newHistory(4)
+	h.AddBurst(0, 2)
+	h.MarkGap()
+	if _, ok := h.FinishBurst(0); ok {
+		t.Errorf("FinishBurst recorded a burst that fell into a gap.")
+	}
+	if got := h.Get(0).Type; got != Gap {
+		t.Errorf("Wrong type for seq 0: %v (want %v)", got, Gap)
+	}
+	if h.Outstanding() != 0 {
+		t.Errorf("Burst still pending.")
+	}
+	if diff := cmp.Diff(Stats{}, h.Stats()); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+}
+
+func TestResize(t *testing.T) {
+	cases := []struct {
+		name      string
+		size      int
+		nAdded    int
+		newSize   int
+		wantSeqs  []int
+		wantTypes []ResultType
+	}{
+		{name: "Grow", size: 3, nAdded: 5, newSize: 6, wantSeqs: []int{4, 3, 2}},
+		{name: "GrowNotFull", size: 4, nAdded: 2, newSize: 8, wantSeqs: []int{1, 0}},
+		{name: "Shrink", size: 4, nAdded: 6, newSize: 2, wantSeqs: []int{5, 4}},
+		{name: "Same", size: 3, nAdded: 4, newSize: 3, wantSeqs: []int{3, 2, 1}},
+		{name: "Empty", size: 3, nAdded: 0, newSize: 5, wantSeqs: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := newHistory(c.size)
+			for seq := range c.nAdded {
+				h.Add(seq)
+				h.Record(seq, PingResult{Type: Success, Peer: &net.UDPAddr{Port: seq}})
+			}
+			h.Resize(c.newSize)
+			if h.Size() != c.newSize {
+				t.Errorf("Size() = %d (want %d)", h.Size(), c.newSize)
+			}
+
+			var mu sync.Mutex
+			var got []int
+			for seq, r := range h.RevResults(&mu) {
+				if r.Peer.(*net.UDPAddr).Port != seq {
+					t.Errorf("Seq %d has result for seq %d", seq, r.Peer.(*net.UDPAddr).Port)
+				}
+				got = append(got, seq)
+			}
+			if diff := cmp.Diff(c.wantSeqs, got); diff != "" {
+				t.Errorf("Wrong seqs (-want, +got):\n%v", diff)
+			}
+
+			// Adding continues to work after a resize.
+			h.Add(c.nAdded)
+			if r := h.Latest(); r.Type != Waiting {
+				t.Errorf("Latest() = %v after add (want %v)", r.Type, Waiting)
+			}
+		})
+	}
+}
+
+func TestRetention(t *testing.T) {
+	c := fakeclock.NewFakeClock(time.Now())
+	h := newHistory(2)
+	h.clock = c
+	h.SetRetention(time.Minute)
+
+	// One ping every 10 seconds for a minute needs 6 slots.
+	for seq := range 10 {
+		h.Add(seq)
+		c.Increment(10 * time.Second)
+	}
+	if h.Size() != 8 {
+		t.Errorf("Size() = %d (want 8)", h.Size())
+	}
+
+	// Slow enough that nothing more is needed.
+	h.SetRetention(time.Second)
+	for seq := 10; seq < 20; seq++ {
+		h.Add(seq)
+		c.Increment(10 * time.Second)
+	}
+	if h.Size() != 8 {
+		t.Errorf("Size() = %d (want 8)", h.Size())
+	}
+}
+
+func TestReplay(t *testing.T) {
+	h := newHistory(4)
+	h.Replay(1, PingResult{Type: Success, Latency: 10 * time.Millisecond})
+	h.Replay(0, PingResult{Type: Dropped})
+	h.Replay(3, PingResult{Type: Success, Latency: 20 * time.Millisecond})
+
+	var mu sync.Mutex
+	var got []PingResult
+	for _, r := range h.RevResults(&mu) {
+		got = append(got, r)
+	}
+	want := []PingResult{
+		{Type: Success, Latency: 20 * time.Millisecond},
+		{Type: Waiting},
+		{Type: Success, Latency: 10 * time.Millisecond},
+		{Type: Dropped},
+	}
+	opt := cmp.FilterValues(func(t1, t2 time.Time) bool { return true }, cmp.Ignore())
+	if diff := cmp.Diff(want, got, opt); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+
+	wantStats := Stats{
+		N:           3,
+		Failures:    1,
+		AvgLatency:  15 * time.Millisecond,
+		StdDev:      h.Stats().StdDev,
+		Jitter:      625 * time.Microsecond,
+		MinLatency:  10 * time.Millisecond,
+		MaxLatency:  20 * time.Millisecond,
+		LastLatency: 20 * time.Millisecond,
+		P50:         h.Stats().P50,
+		P95:         h.Stats().P95,
+		P99:         h.Stats().P99,
+	}
+	if diff := cmp.Diff(wantStats, h.Stats()); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+}
+
+func TestWindowStats(t *testing.T) {
+	start := time.Now()
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(8)
+	h.clock = c
+
+	addIncRec := func(seq, ms int, tp ResultType) {
+		h.Add(seq)
+		c.Increment(time.Duration(ms) * time.Millisecond)
+		res := h.Get(seq)
+		res.Type = tp
+		h.Record(seq, res)
+	}
+	addIncRec(0, 100, Success)
+	c.Increment(2 * time.Minute)
+	addIncRec(1, 10, Success)
+	addIncRec(2, 1000, Dropped)
+	addIncRec(3, 1000, Gap)
+
+	opt := cmp.Transformer("Duration", func(in time.Duration) int64 {
+		return in.Milliseconds()
+	})
+	cases := []struct {
+		d    time.Duration
+		want Stats
+	}{
+		{
+			d: time.Minute,
+			want: Stats{
+				N:           2,
+				Failures:    1,
+				AvgLatency:  10 * time.Millisecond,
+				StdDev:      0,
+				Jitter:      90 * time.Millisecond,
+				MinLatency:  10 * time.Millisecond,
+				MaxLatency:  10 * time.Millisecond,
+				LastLatency: 10 * time.Millisecond,
+				P50:         10 * time.Millisecond,
+				P95:         10 * time.Millisecond,
+				P99:         10 * time.Millisecond,
+			},
+		},
+		{
+			d: 5 * time.Minute,
+			want: Stats{
+				N:           3,
+				Failures:    1,
+				AvgLatency:  55 * time.Millisecond,
+				StdDev:      36 * time.Millisecond,
+				Jitter:      90 * time.Millisecond,
+				MinLatency:  10 * time.Millisecond,
+				MaxLatency:  100 * time.Millisecond,
+				LastLatency: 10 * time.Millisecond,
+				P50:         10 * time.Millisecond,
+				P95:         100 * time.Millisecond,
+				P99:         100 * time.Millisecond,
+			},
+		},
+	}
+	for _, c := range cases {
+		if diff := cmp.Diff(c.want, h.WindowStats(c.d), opt); diff != "" {
+			t.Errorf("Wrong stats for %v window (-want, +got):\n%v", c.d, diff)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package pinger
+
+import "time"
+
+const (
+	// The most outages kept in a pinger's event log. Older ones are
+	// discarded.
+	maxEvents = 100
+
+	defaultOutageThreshold = 3
+)
+
+// Event records an outage: a run of at least [Options.OutageThreshold]
+// consecutive lost pings.
+type Event struct {
+	// Start is when the first lost ping was sent.
+	Start time.Time
+
+	// Last is when the most recent lost ping was sent.
+	Last time.Time
+
+	// End is when the first ping answered after the outage was sent. It's the
+	// zero time while the outage is ongoing.
+	End time.Time
+
+	// Lost is the number of consecutive pings lost.
+	Lost int
+}
+
+// Ongoing returns true if no ping has been answered since the outage began.
+func (e Event) Ongoing() bool {
+	return e.End.IsZero()
+}
+
+// Duration returns how long the outage lasted, or for an ongoing outage, how
+// long it has lasted as of the most recent lost ping.
+func (e Event) Duration() time.Duration {
+	if e.Ongoing() {
+		return e.Last.Sub(e.Start)
+	}
+	return e.End.Sub(e.Start)
+}
+
+// Detects outages from a series of results in sequence order.
+type outageLog struct {
+	threshold int
+	run       Event // The current run of lost pings, which may be too short to count.
+	events    []Event
+}
+
+func newOutageLog(threshold int) *outageLog {
+	return &outageLog{threshold: threshold}
+}
+
+// Add adds the next result in sequence. Results that say nothing about
+// whether the host is reachable, like gaps, are ignored.
+func (l *outageLog) Add(r PingResult) {
+	switch r.Type {
+	case Success:
+		if l.run.Lost >= l.threshold {
+			l.run.End = r.Time
+			l.events = append(l.events, l.run)
+			if len(l.events) > maxEvents {
+				l.events = l.events[len(l.events)-maxEvents:]
+			}
+		}
+		l.run = Event{}
+	case Dropped, TTLExceeded, Unreachable:
+		if l.run.Lost == 0 {
+			l.run.Start = r.Time
+		}
+		l.run.Lost++
+		l.run.Last = r.Time
+	}
+}
+
+// Events returns the outages detected, oldest first, including one that's
+// ongoing.
+func (l *outageLog) Events() []Event {
+	res := append([]Event(nil), l.events...)
+	if l.run.Lost >= l.threshold {
+		res = append(res, l.run)
+	}
+	return res
+}
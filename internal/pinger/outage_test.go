@@ -0,0 +1,117 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestOutageLog(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+	cases := []struct {
+		Name  string
+		Types []ResultType
+		Want  []Event
+	}{
+		{
+			Name:  "NoLoss",
+			Types: []ResultType{Success, Success, Success},
+		},
+		{
+			Name:  "TooShort",
+			Types: []ResultType{Success, Dropped, Dropped, Success},
+		},
+		{
+			Name:  "Ended",
+			Types: []ResultType{Success, Dropped, Unreachable, Dropped, Success, Dropped},
+			Want:  []Event{{Start: at(1), Last: at(3), End: at(4), Lost: 3}},
+		},
+		{
+			Name:  "Ongoing",
+			Types: []ResultType{Success, Dropped, Dropped, Dropped, Dropped},
+			Want:  []Event{{Start: at(1), Last: at(4), Lost: 4}},
+		},
+		{
+			Name:  "GapsIgnored",
+			Types: []ResultType{Dropped, Gap, Dropped, Duplicate, Dropped, Success},
+			Want:  []Event{{Start: at(0), Last: at(4), End: at(5), Lost: 3}},
+		},
+		{
+			Name:  "Several",
+			Types: []ResultType{Dropped, Dropped, Dropped, Success, TTLExceeded, TTLExceeded, TTLExceeded},
+			Want: []Event{
+				{Start: at(0), Last: at(2), End: at(3), Lost: 3},
+				{Start: at(4), Last: at(6), Lost: 3},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			l := newOutageLog(3)
+			for i, tp := range c.Types {
+				l.Add(PingResult{Type: tp, Time: at(i)})
+			}
+			if diff := cmp.Diff(c.Want, l.Events()); diff != "" {
+				t.Errorf("Wrong events (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestOutageLog_Limit(t *testing.T) {
+	l := newOutageLog(1)
+	for range maxEvents + 10 {
+		l.Add(PingResult{Type: Dropped})
+		l.Add(PingResult{Type: Success})
+	}
+	if n := len(l.Events()); n != maxEvents {
+		t.Errorf("Kept %d events (want %d)", n, maxEvents)
+	}
+}
+
+func TestEventDuration(t *testing.T) {
+	start := time.Unix(1000, 0)
+	ongoing := Event{Start: start, Last: start.Add(2 * time.Second), Lost: 3}
+	if !ongoing.Ongoing() || ongoing.Duration() != 2*time.Second {
+		t.Errorf("%+v: Ongoing() = %v, Duration() = %v (want true, 2s)", ongoing, ongoing.Ongoing(), ongoing.Duration())
+	}
+	ended := ongoing
+	ended.End = start.Add(3 * time.Second)
+	if ended.Ongoing() || ended.Duration() != 3*time.Second {
+		t.Errorf("%+v: Ongoing() = %v, Duration() = %v (want false, 3s)", ended, ended.Ongoing(), ended.Duration())
+	}
+}
+
+func TestHistoryEvents_OutOfOrder(t *testing.T) {
+	start := time.Unix(1000, 0)
+	c := fakeclock.NewFakeClock(start)
+	h := newHistory(10)
+	h.clock = c
+	h.SetOutageThreshold(2)
+	as := func(seq int, tp ResultType) PingResult {
+		r := h.Get(seq)
+		r.Type = tp
+		return r
+	}
+
+	for seq := range 4 {
+		h.Add(seq)
+		c.Increment(time.Second)
+	}
+	// The reply to seq 3 arrives before the earlier pings time out.
+	h.Record(3, as(3, Success))
+	h.Record(0, as(0, Success))
+	if got := h.Events(); len(got) != 0 {
+		t.Errorf("Events before timeouts: %v (want none)", got)
+	}
+	h.Record(1, as(1, Dropped))
+	h.Record(2, as(2, Dropped))
+
+	want := []Event{{Start: start.Add(time.Second), Last: start.Add(2 * time.Second), End: start.Add(3 * time.Second), Lost: 2}}
+	if diff := cmp.Diff(want, h.Events()); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
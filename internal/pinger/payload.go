@@ -0,0 +1,72 @@
+package pinger
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Size of the token written at the start of each payload when replies are
+// verified. It holds the send time in nanoseconds, the wire sequence number
+// and a CRC-32 of the whole payload, with the CRC itself left out.
+const tokenSize = 16
+
+// MaxPayloadSize returns the largest payload a ping can carry over the given
+// IP version: the largest IP packet less the ICMP or UDP header, and less the
+// IP header for IPv4. An IPv6 header doesn't count against its packet length.
+func MaxPayloadSize(ipVer util.IPVersion) int {
+	return util.Choose(ipVer, 65535-20-8, 65535-8)
+}
+
+// Makes a ping payload of the given size. The payload is filled by repeating
+// pattern, or with random bytes if pattern is empty.
+func makePayload(size int, pattern []byte) []byte {
+	if size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if len(pattern) == 0 {
+		// Never returns an error.
+		rand.Read(buf)
+		return buf
+	}
+	for i := 0; i < size; i += len(pattern) {
+		copy(buf[i:], pattern)
+	}
+	return buf
+}
+
+// Returns a copy of payload with a token for the ping with wire sequence
+// number seq sent at t. The payload must be at least tokenSize bytes.
+func withToken(payload []byte, seq int, t time.Time) []byte {
+	buf := make([]byte, len(payload))
+	copy(buf, payload)
+	binary.BigEndian.PutUint64(buf[0:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:12], uint32(seq))
+	binary.BigEndian.PutUint32(buf[12:16], tokenSum(buf))
+	return buf
+}
+
+// Returns the send time from a payload's token.
+func tokenTime(payload []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(payload[0:8])))
+}
+
+// Returns true if an echoed payload holds an intact token for the wire
+// sequence number seq.
+func checkToken(payload []byte, seq int) bool {
+	if len(payload) < tokenSize {
+		return false
+	}
+	return binary.BigEndian.Uint32(payload[8:12]) == uint32(seq) &&
+		binary.BigEndian.Uint32(payload[12:16]) == tokenSum(payload)
+}
+
+// Checksums a payload, skipping the token's checksum field.
+func tokenSum(payload []byte) uint32 {
+	sum := crc32.ChecksumIEEE(payload[:12])
+	return crc32.Update(sum, crc32.IEEETable, payload[tokenSize:])
+}
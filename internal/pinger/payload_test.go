@@ -0,0 +1,74 @@
+package pinger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMakePayload(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Size    int
+		Pattern []byte
+		Want    []byte
+	}{
+		{Name: "Empty", Size: 0, Pattern: []byte{1}, Want: nil},
+		{Name: "Exact", Size: 4, Pattern: []byte{1, 2}, Want: []byte{1, 2, 1, 2}},
+		{Name: "Partial", Size: 5, Pattern: []byte{1, 2}, Want: []byte{1, 2, 1, 2, 1}},
+		{Name: "ShorterThanPattern", Size: 2, Pattern: []byte{1, 2, 3}, Want: []byte{1, 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if got := makePayload(c.Size, c.Pattern); !bytes.Equal(got, c.Want) {
+				t.Errorf("makePayload(%d, %v) = %v (want %v)", c.Size, c.Pattern, got, c.Want)
+			}
+		})
+	}
+}
+
+func TestMakePayload_Random(t *testing.T) {
+	a := makePayload(64, nil)
+	b := makePayload(64, nil)
+	if len(a) != 64 || len(b) != 64 {
+		t.Fatalf("Wrong lengths: %d, %d (want 64)", len(a), len(b))
+	}
+	if bytes.Equal(a, b) {
+		t.Errorf("Random payloads are identical: %v", a)
+	}
+}
+
+func TestToken(t *testing.T) {
+	sent := withToken(makePayload(64, []byte{0xaa}), 7, time.Unix(1, 2))
+	if !checkToken(sent, 7) {
+		t.Fatalf("checkToken(%v, 7) = false (want true)", sent)
+	}
+	if checkToken(sent, 8) {
+		t.Errorf("checkToken() = true for the wrong sequence number")
+	}
+	for _, i := range []int{0, 9, 13, 63} {
+		bad := bytes.Clone(sent)
+		bad[i] ^= 1
+		if checkToken(bad, 7) {
+			t.Errorf("checkToken() = true with byte %d flipped", i)
+		}
+	}
+	if checkToken(sent[:tokenSize-1], 7) {
+		t.Errorf("checkToken() = true for a truncated payload")
+	}
+	if checkToken(sent[:40], 7) {
+		t.Errorf("checkToken() = true for a payload missing its tail")
+	}
+}
+
+func TestToken_Unique(t *testing.T) {
+	payload := makePayload(tokenSize, []byte{0})
+	a := withToken(payload, 1, time.Unix(1, 0))
+	b := withToken(payload, 1, time.Unix(1, 1))
+	if bytes.Equal(a, b) {
+		t.Errorf("Tokens for different send times are identical: %v", a)
+	}
+	if !bytes.Equal(payload, makePayload(tokenSize, []byte{0})) {
+		t.Errorf("withToken() changed its argument: %v", payload)
+	}
+}
@@ -4,23 +4,37 @@ package pinger
 import (
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
 	"iter"
 	"log"
 	"math"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"code.cloudfoundry.org/clock"
+
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/connpool"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/util"
 )
 
 const (
-	// Number of possible sequence numbers.
+	// Mask for the sequence numbers sent on the wire. Sequence numbers are
+	// kept in full internally, and unwrapped as replies come in.
 	sequenceNoMask = (1 << 16) - 1
+
+	// Delays between attempts to replace a dead connection.
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
 )
 
+// Returned by sends while a dead connection is being replaced.
+var errReconnecting = errors.New("reconnecting")
+
 // Options contains options for the pinger.
 type Options struct {
 	// NPings is the number of pings to send. Zero means infinite.
@@ -30,11 +44,128 @@ type Options struct {
 	Interval time.Duration
 
 	// History is the maximum number of ping results to store. Defaults to 300.
+	// May be changed later with [Pinger.SetHistorySize].
 	History int
 
+	// Retention, if nonzero, keeps ping results for at least this long,
+	// growing the history beyond History as needed. May be changed later
+	// with [Pinger.SetRetention].
+	Retention time.Duration
+
 	// Timeout is the maximum amount of time to wait before assuming no response
-	// is coming. Defaults to 1s if unset.
+	// is coming. Defaults to 1s if unset. It may be much longer than Interval
+	// times History. Pings that are still waiting when their history slots are
+	// reused are tracked until they're answered or time out.
 	Timeout time.Duration
+
+	// MaxOutstanding is the most pings that may be waiting for a reply at
+	// once. Pings that come due while at the limit are skipped. Defaults to
+	// 1000, and is capped at half the number of sequence numbers so that
+	// replies can't be mistaken for each other. A pinger sharing a connection
+	// from a [Pool] has far fewer sequence numbers.
+	MaxOutstanding int
+
+	// Adaptive adjusts the interval between pings based on observed latency
+	// and loss. Pings to fast, reliable hosts are sent as often as Interval
+	// allows, and back off towards MaxInterval as packets are lost.
+	Adaptive bool
+
+	// MaxInterval is the longest interval used in adaptive mode. Defaults to
+	// 8 times Interval.
+	MaxInterval time.Duration
+
+	// PayloadSize is the number of bytes of data to send in each ping. It may
+	// not be more than [MaxPayloadSize] for the destination's IP version.
+	PayloadSize int
+
+	// PayloadPattern is repeated to fill the payload. If empty, the payload
+	// is filled with random bytes.
+	PayloadPattern []byte
+
+	// VerifyPayload writes a token into each ping's payload, made of the
+	// time it was sent and a checksum, and checks that echo replies bring it
+	// back intact. Replies that don't are recorded as [Corrupted]. The
+	// payload grows to hold the token if PayloadSize is too small for it.
+	// Only meaningful for backends whose replies echo the payload, like
+	// icmp.
+	VerifyPayload bool
+
+	// PayloadTimestamp writes the send time into each ping's payload, in the
+	// same token as VerifyPayload, and measures the latency of echo replies
+	// from it instead of from when the ping was recorded as sent. Replies
+	// are always timed to [backend.Packet.RecvTime] when the backend sets
+	// it, which leaves out time spent queued in the pinger and between
+	// privsep processes. That matters for sub-millisecond latencies.
+	PayloadTimestamp bool
+
+	// OnResult, if set, is called with each result from a subscription of
+	// its own for each call to [Pinger.Run]. See [Pinger.Subscribe]. Results
+	// are passed to it one at a time, from a goroutine of its own. Run waits
+	// for the last call to return.
+	OnResult func(seq int, res PingResult)
+
+	// ResultQueue is the buffer size of the OnResult subscription. See
+	// [SubscribeOptions.Buffer].
+	ResultQueue int
+
+	// BlockOnResult makes the pinger wait for OnResult when its buffer is
+	// full. See [SubscribeOptions.Block].
+	BlockOnResult bool
+
+	// Source binds the connection to a local interface or address.
+	Source backend.SourceOption
+
+	// Flood sends pings as fast as replies come back, or 100 times a second,
+	// whichever is more, in the manner of ping -f. Interval and Adaptive are
+	// ignored. Backends only allow this for root.
+	Flood bool
+
+	// MaxPPS caps the number of pings sent per second in flood mode. Zero
+	// means no cap.
+	MaxPPS int
+
+	// Request is the type of request to send. Defaults to an echo request.
+	// Timestamp and address mask requests are IPv4 only, and few backends
+	// support them.
+	Request backend.PacketType
+
+	// FlowLabel sets the flow label of IPv6 pings. Zero means none. Only the
+	// icmp backend supports this.
+	FlowLabel uint32
+
+	// ProbesPerInterval is the number of pings sent back to back each
+	// interval. The replies to each burst are combined into a single result,
+	// which smooths over jitter in individual packets. Defaults to 1. Ignored
+	// in flood mode.
+	ProbesPerInterval int
+
+	// BurstLatency chooses how the latencies of a burst's replies are
+	// combined. Defaults to the minimum.
+	BurstLatency BurstLatency
+
+	// Pool, if set, shares a connection with other pingers using the same
+	// pool, backend and connection options. Ignored in flood mode.
+	Pool *Pool
+
+	// OutageThreshold is the number of consecutive lost pings that count as
+	// an outage. See [Pinger.Events]. Defaults to 3.
+	OutageThreshold int
+
+	// DNSQuery is the query sent by the dns backend. Ignored by other
+	// backends.
+	DNSQuery backend.DNSQueryOption
+
+	// HTTP sets the requests sent by the http backend. Ignored by other
+	// backends.
+	HTTP backend.HTTPOption
+
+	// RecvBuffer is the size in bytes to grow the socket receive buffer to.
+	// Zero for the system default. See [backend.RecvBufferOption].
+	RecvBuffer int
+
+	// Clock drives the pinger's timers and times its results. Defaults to
+	// the real clock. For testing.
+	Clock clock.Clock
 }
 
 func (o *Options) nPings() int {
@@ -44,6 +175,13 @@ func (o *Options) nPings() int {
 	return o.NPings
 }
 
+func (o *Options) clock() clock.Clock {
+	if o == nil || o.Clock == nil {
+		return clock.NewClock()
+	}
+	return o.Clock
+}
+
 func (o *Options) interval() time.Duration {
 	if o == nil || o.Interval == 0 {
 		return time.Second
@@ -51,6 +189,166 @@ func (o *Options) interval() time.Duration {
 	return o.Interval
 }
 
+func (o *Options) adaptive() bool {
+	return o != nil && o.Adaptive
+}
+
+func (o *Options) maxInterval() time.Duration {
+	if o == nil || o.MaxInterval == 0 {
+		return 8 * o.interval()
+	}
+	return o.MaxInterval
+}
+
+func (o *Options) payloadSize() int {
+	if o == nil {
+		return 0
+	}
+	if o.token() {
+		return max(o.PayloadSize, tokenSize)
+	}
+	return o.PayloadSize
+}
+
+func (o *Options) payload() []byte {
+	if o == nil {
+		return nil
+	}
+	return makePayload(o.payloadSize(), o.PayloadPattern)
+}
+
+func (o *Options) verifyPayload() bool {
+	return o != nil && o.VerifyPayload
+}
+
+func (o *Options) payloadTimestamp() bool {
+	return o != nil && o.PayloadTimestamp
+}
+
+// Returns true if payloads start with a token.
+func (o *Options) token() bool {
+	return o.verifyPayload() || o.payloadTimestamp()
+}
+
+func (o *Options) source() backend.SourceOption {
+	if o == nil {
+		return backend.SourceOption{}
+	}
+	return o.Source
+}
+
+func (o *Options) maxOutstanding() int {
+	// Each probe in a burst has its own sequence number on the wire.
+	limit := (o.seqMask() + 1) / 2 / o.probesPerInterval()
+	if o == nil || o.MaxOutstanding == 0 {
+		return min(1000, limit)
+	}
+	return min(o.MaxOutstanding, limit)
+}
+
+func (o *Options) pool() *Pool {
+	if o == nil || o.Flood {
+		return nil
+	}
+	return o.Pool
+}
+
+// Mask for the sequence numbers sent on the wire.
+func (o *Options) seqMask() int {
+	if o.pool() != nil {
+		return connpool.SeqSpace - 1
+	}
+	return sequenceNoMask
+}
+
+func (o *Options) flood() bool {
+	return o != nil && o.Flood
+}
+
+func (o *Options) maxPPS() int {
+	if o == nil {
+		return 0
+	}
+	return o.MaxPPS
+}
+
+func (o *Options) request() backend.PacketType {
+	if o == nil {
+		return backend.PacketRequest
+	}
+	return o.Request
+}
+
+func (o *Options) probesPerInterval() int {
+	if o == nil || o.ProbesPerInterval == 0 || o.Flood {
+		return 1
+	}
+	return o.ProbesPerInterval
+}
+
+func (o *Options) burstLatency() BurstLatency {
+	if o == nil {
+		return BurstMin
+	}
+	return o.BurstLatency
+}
+
+func (o *Options) flowLabel() uint32 {
+	if o == nil {
+		return 0
+	}
+	return o.FlowLabel
+}
+
+func (o *Options) connOptions() []backend.ConnOption {
+	opts := []backend.ConnOption{o.source()}
+	if o.flood() {
+		opts = append(opts, backend.FloodOption{})
+	}
+	if l := o.flowLabel(); l != 0 {
+		opts = append(opts, backend.FlowLabelOption{Label: l})
+	}
+	if q := o.dnsQuery(); q != (backend.DNSQueryOption{}) {
+		opts = append(opts, q)
+	}
+	if h := o.http(); h != (backend.HTTPOption{}) {
+		opts = append(opts, h)
+	}
+	if n := o.recvBuffer(); n != 0 {
+		// The process's own privileges decide whether forcing works.
+		opts = append(opts, backend.RecvBufferOption{Bytes: n, Force: true})
+	}
+	return opts
+}
+
+func (o *Options) recvBuffer() int {
+	if o == nil {
+		return 0
+	}
+	return o.RecvBuffer
+}
+
+func (o *Options) dnsQuery() backend.DNSQueryOption {
+	if o == nil {
+		return backend.DNSQueryOption{}
+	}
+	return o.DNSQuery
+}
+
+func (o *Options) http() backend.HTTPOption {
+	if o == nil {
+		return backend.HTTPOption{}
+	}
+	return o.HTTP
+}
+
+func (o *Options) outageThreshold() int {
+	if o == nil || o.OutageThreshold == 0 {
+		return defaultOutageThreshold
+	}
+	return o.OutageThreshold
+}
+
 func (o *Options) history() int {
 	if o == nil || o.History == 0 {
 		return 300
@@ -58,6 +356,13 @@ func (o *Options) history() int {
 	return o.History
 }
 
+func (o *Options) retention() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.Retention
+}
+
 func (o *Options) timeout() time.Duration {
 	if o == nil || o.Timeout == 0 {
 		return time.Second
@@ -80,7 +385,9 @@ const (
 	// Dropped means no reply was received in the allotted time.
 	Dropped
 
-	// Duplicate means a duplicate reply was received.
+	// Duplicate means a duplicate reply was received. Duplicates are
+	// counted in [Stats.Duplicates], and don't replace the first reply's
+	// result in the history.
 	Duplicate
 
 	// TTLExceeded means the packet exceeded its maximum hop count.
@@ -88,6 +395,21 @@ const (
 
 	// Unreachable means the host was unreachable.
 	Unreachable
+
+	// Gap means probing was interrupted (paused or suspended) while waiting
+	// for a reply. Gaps are excluded from statistics.
+	Gap
+
+	// Late means a reply arrived after the ping had already been counted as
+	// dropped. It replaces the Dropped result in the history, but stays a
+	// loss in the statistics, and is counted in [Stats.Late].
+	Late
+
+	// Corrupted means a reply came back without the token it was sent
+	// with, because something along the way changed its payload. Only
+	// recorded with [Options.VerifyPayload]. It counts as a loss, and is
+	// counted in [Stats.Corrupted].
+	Corrupted
 )
 
 func (r ResultType) String() string {
@@ -104,6 +426,12 @@ func (r ResultType) String() string {
 		return "TTLExceeded"
 	case Unreachable:
 		return "Unreachable"
+	case Gap:
+		return "Gap"
+	case Late:
+		return "Late"
+	case Corrupted:
+		return "Corrupted"
 	default:
 		return fmt.Sprintf("(unknown:%d)", r)
 	}
@@ -122,6 +450,43 @@ type PingResult struct {
 
 	// Peer is the host that responded to the ping.
 	Peer net.Addr
+
+	// Timestamps holds the timestamps from a timestamp reply.
+	Timestamps backend.Timestamps
+
+	// AddressMask is the mask from an address mask reply.
+	AddressMask net.IPMask
+
+	// TTL is the TTL or hop limit the reply arrived with, or zero if
+	// unknown.
+	TTL int
+
+	// Probes is the number of pings sent in this result's burst, when
+	// [Options.ProbesPerInterval] is more than one. Zero for single pings.
+	Probes int
+
+	// Replies is the number of successful replies to this result's burst.
+	Replies int
+
+	// Spread is the difference between the highest and lowest latencies of
+	// the successful replies to this result's burst. Zero for single pings.
+	Spread time.Duration
+}
+
+// HopDistance estimates how many hops away the host that sent the reply is.
+// See [util.HopDistance].
+func (r PingResult) HopDistance() (int, bool) {
+	return util.HopDistance(r.TTL)
+}
+
+// ClockOffset estimates how far the remote host's clock is ahead of the local
+// one from a timestamp reply. Returns false if this isn't a successful
+// timestamp reply, or the remote host doesn't use standard timestamps.
+func (r PingResult) ClockOffset() (time.Duration, bool) {
+	if r.Type != Success || r.Timestamps == (backend.Timestamps{}) {
+		return 0, false
+	}
+	return r.Timestamps.ClockOffset(r.Time.Add(r.Latency))
 }
 
 type readResult struct {
@@ -136,35 +501,169 @@ type timeoutDatum struct {
 
 // Pinger pings a specific host and reports the results.
 type Pinger struct {
-	conn backend.Conn
-	dest net.Addr
-	opts *Options
-	done chan any
+	be    backend.Name
+	ipVer util.IPVersion
+	dest  net.Addr
+	opts  *Options
+	clock clock.Clock
+
+	// Canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Pause state. Changes are signaled over wake.
+	paused atomic.Bool
+	wake   chan any
+
+	// Data sent in each ping.
+	payload []byte
+
+	// Current interval between pings. Only changes in adaptive mode.
+	interval   atomic.Int64
+	controller *intervalController
+
+	// Replies received since the last batch was sent. Only used in flood
+	// mode.
+	replies atomic.Int64
+
+	// Result subscriptions.
+	subMu sync.RWMutex
+	subs  map[*Subscription]bool
+
+	// Replies the kernel dropped from the socket. See [Pinger.SocketDrops].
+	socketDrops atomic.Int64
 
 	mu   sync.Mutex
 	hist *pingHistory
+	// Nil while reconnecting.
+	conn backend.Conn
 }
 
-// New creates a new pinger and starts pinging. It will continue until Close()
-// is called.
+// New creates a new pinger. Pinging starts with [Pinger.Run].
 func New(be backend.Name, ipVer util.IPVersion, dest net.Addr, opts *Options) (*Pinger, error) {
-	conn, err := backend.New(be, ipVer)
+	if size := opts.payloadSize(); size > MaxPayloadSize(ipVer) {
+		return nil, fmt.Errorf("payload size %d is too large for %v (most is %d)", size, ipVer, MaxPayloadSize(ipVer))
+	}
+	p := &Pinger{
+		be:      be,
+		ipVer:   ipVer,
+		dest:    dest,
+		opts:    opts,
+		clock:   opts.clock(),
+		wake:    make(chan any, 1),
+		hist:    newHistory(opts.history()),
+		payload: opts.payload(),
+	}
+	p.hist.clock = p.clock
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	conn, err := p.newConn()
 	if err != nil {
+		p.cancel()
 		return nil, err
 	}
-	return &Pinger{
-		conn: conn,
-		dest: dest,
-		opts: opts,
-		done: make(chan any),
-		hist: newHistory(opts.history()),
-	}, nil
+	p.conn = conn
+	p.hist.SetRetention(opts.retention())
+	p.hist.SetBurstLatency(opts.burstLatency())
+	p.hist.SetOutageThreshold(opts.outageThreshold())
+	p.interval.Store(int64(opts.interval()))
+	if opts.adaptive() {
+		p.controller = newIntervalController(opts.interval(), opts.maxInterval())
+	}
+	return p, nil
+}
+
+// NewReplay creates a pinger that doesn't send anything. Its results come
+// entirely from calls to [Pinger.Replay]. Don't call Run on it.
+func NewReplay(opts *Options) *Pinger {
+	p := &Pinger{
+		opts:  opts,
+		clock: opts.clock(),
+		hist:  newHistory(opts.history()),
+	}
+	p.hist.clock = p.clock
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.hist.SetRetention(opts.retention())
+	p.hist.SetOutageThreshold(opts.outageThreshold())
+	return p
 }
 
 // Close stops the Pinger and performs an orderly shutdown.
 func (p *Pinger) Close() error {
-	close(p.done)
-	return p.conn.Close()
+	p.cancel()
+	p.closeSubscriptions()
+	p.mu.Lock()
+	conn := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// Opens a connection, or gets one from the pool.
+func (p *Pinger) newConn() (backend.Conn, error) {
+	if pool := p.opts.pool(); pool != nil {
+		return pool.get(p.be, p.ipVer, p.opts)
+	}
+	return backend.New(p.be, p.ipVer, p.opts.connOptions()...)
+}
+
+// Returns true if the pinger has been closed.
+func (p *Pinger) closed() bool {
+	return p.ctx.Err() != nil
+}
+
+// Replaces a dead connection with a new one from the same backend, retrying
+// with backoff until it succeeds. Pings awaiting replies are marked as gaps,
+// and sends are skipped until the new connection is ready. Returns false if
+// the pinger is closed or ctx is canceled first.
+func (p *Pinger) reconnect(ctx context.Context) bool {
+	p.MarkGap()
+	p.mu.Lock()
+	old := p.conn
+	p.conn = nil
+	p.mu.Unlock()
+	if old == nil {
+		// Already closed.
+		return false
+	}
+	if err := old.Close(); err != nil {
+		logging.Warnf("Error closing dead connection to %v: %v", p.dest, err)
+	}
+
+	delay := minReconnectDelay
+	for {
+		conn, err := p.newConn()
+		if err == nil {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.closed() {
+				conn.Close()
+				return false
+			}
+			p.conn = conn
+			logging.Infof("Reconnected to %v", p.dest)
+			return true
+		}
+		logging.Warnf("Error reconnecting to %v; retrying in %v: %v", p.dest, delay, err)
+		select {
+		case <-p.clock.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		delay = min(2*delay, maxReconnectDelay)
+	}
+}
+
+// Replay records a previously recorded result, as passed to
+// [Options.OnResult]. Results are expected roughly in the order they were
+// recorded. They're passed on to subscribers.
+func (p *Pinger) Replay(seq int, res PingResult) {
+	p.mu.Lock()
+	p.hist.Replay(seq, res)
+	p.mu.Unlock()
+	p.publish(p.ctx, seq, res)
 }
 
 // Latest returns the most recent ping result or the zero result if no results
@@ -187,6 +686,74 @@ func (p *Pinger) History() []PingResult {
 	return p.hist.History(&p.mu)
 }
 
+// Pause stops sending pings until [Pinger.Resume] is called. The connection
+// and history are kept. Pings already sent are marked as gaps, and aren't
+// counted in the statistics even if a reply arrives for one.
+func (p *Pinger) Pause() {
+	if p.paused.CompareAndSwap(false, true) {
+		p.MarkGap()
+		p.signalWake()
+	}
+}
+
+// Resume resumes sending pings after [Pinger.Pause].
+func (p *Pinger) Resume() {
+	if p.paused.CompareAndSwap(true, false) {
+		p.signalWake()
+	}
+}
+
+// Paused returns true if the pinger is paused.
+func (p *Pinger) Paused() bool {
+	return p.paused.Load()
+}
+
+// Tells the send loop that the pause state has changed. The send loop always
+// checks the latest state, so a signal that's already waiting is enough.
+func (p *Pinger) signalWake() {
+	select {
+	case p.wake <- nil:
+	default:
+	}
+}
+
+// HistorySize returns the number of results the history can hold.
+func (p *Pinger) HistorySize() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hist.Size()
+}
+
+// SetHistorySize changes the number of results the history can hold. The most
+// recent results are kept when shrinking.
+func (p *Pinger) SetHistorySize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hist.Resize(n)
+}
+
+// SetRetention sets the minimum time to keep results for. The history grows
+// as needed. Zero keeps a fixed number of results.
+func (p *Pinger) SetRetention(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hist.SetRetention(d)
+}
+
+// Events returns the outages detected so far, oldest first. The last one may
+// be ongoing. Only the most recent 100 are kept.
+func (p *Pinger) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hist.Events()
+}
+
+// Interval returns the current interval between pings. This is constant unless
+// adaptive mode is enabled.
+func (p *Pinger) Interval() time.Duration {
+	return time.Duration(p.interval.Load())
+}
+
 // Stats returns ping statistics.
 func (p *Pinger) Stats() Stats {
 	p.mu.Lock()
@@ -194,20 +761,68 @@ func (p *Pinger) Stats() Stats {
 	return p.hist.Stats()
 }
 
+// Histogram returns the counts of the latencies of all the successful pings.
+func (p *Pinger) Histogram() Histogram {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hist.Histogram()
+}
+
+// WindowStats returns statistics for the results of pings sent in the last d,
+// up to the newest result. The window d must be one of [StatsWindows], or zero
+// for the cumulative statistics returned by [Pinger.Stats].
+func (p *Pinger) WindowStats(d time.Duration) Stats {
+	if d == 0 {
+		return p.Stats()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hist.WindowStats(d)
+}
+
 func (p *Pinger) afterNextTimeout(timeouts *list.List) <-chan time.Time {
 	fr := timeouts.Front()
 	if fr == nil {
 		return nil
 	}
-	return time.After(fr.Value.(timeoutDatum).t.Sub(time.Now()))
+	return p.clock.After(fr.Value.(timeoutDatum).t.Sub(p.clock.Now()))
 }
 
-// Runs the pinger. Returns when complete, or Close().
-func (p *Pinger) Run() {
+// Run runs the pinger. Returns when all the pings have been sent and
+// answered or timed out, when ctx is canceled, or when the pinger is closed.
+// The pinger's goroutines have all finished by the time it returns, except
+// for one blocked reading from a connection that ignores cancellation, which
+// finishes on Close. So have any calls to [Options.OnResult].
+func (p *Pinger) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(p.ctx, cancel)()
+
+	// Stopped last, once there are no more results.
+	defer p.startOnResult()()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Canceled before waiting for the goroutines.
+	defer cancel()
+
 	sentSeqs := make(chan int)
-	go p.sendLoop(sentSeqs)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if p.opts.flood() {
+			p.floodLoop(ctx, sentSeqs)
+		} else {
+			p.sendLoop(ctx, sentSeqs)
+		}
+	}()
 	receivedPkts := make(chan readResult)
-	go p.receiveLoop(receivedPkts)
+	go p.receiveLoop(ctx, receivedPkts)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.watchDrops(ctx)
+	}()
 
 	timeouts := list.New()
 	shutdown := false
@@ -216,122 +831,421 @@ func (p *Pinger) Run() {
 		select {
 		case seq, ok := <-sentSeqs:
 			if !ok {
-				log.Printf("Main loop: shutting down")
+				if timeouts.Len() == 0 {
+					logging.Debugf("Main loop: finished shutdown")
+					return
+				}
+				logging.Debugf("Main loop: shutting down")
 				shutdown = true
 				sentSeqs = nil
 				break
 			}
-			timeouts.PushBack(timeoutDatum{seq: seq, t: time.Now().Add(p.opts.timeout())})
-		case res := <-receivedPkts:
-			p.handleReply(res.pkt, res.peer)
+			timeouts.PushBack(timeoutDatum{seq: seq, t: p.clock.Now().Add(p.opts.timeout())})
+		case rr := <-receivedPkts:
+			seq, res, ok := p.handleReply(rr.pkt, rr.peer)
+			if !ok {
+				// Part of a burst that's still waiting for replies.
+				break
+			}
+			if p.opts.flood() && res.Type != Duplicate {
+				p.replies.Add(1)
+			}
+			p.adapt(res)
+			p.publish(ctx, seq, res)
 		case <-p.afterNextTimeout(timeouts):
 			fr := timeouts.Front()
 			timeouts.Remove(fr)
 			td := fr.Value.(timeoutDatum)
-			p.maybeRecordTimeout(td.seq)
+			if suspended(p.clock.Now(), td.t, p.opts.interval()) {
+				p.MarkGap()
+			}
+			if res, ok := p.maybeRecordTimeout(td.seq); ok {
+				p.adapt(res)
+				p.publish(ctx, td.seq, res)
+			}
 			if shutdown && timeouts.Len() == 0 {
-				log.Printf("Main loop: finished shutdown")
+				logging.Debugf("Main loop: finished shutdown")
 				return
 			}
-		case <-p.done:
-			log.Printf("Main loop: aborting")
+		case <-ctx.Done():
+			logging.Debugf("Main loop: aborting")
 			return
 		}
 	}
 }
 
+// States of a send loop.
+type sendState int
+
+const (
+	sendRunning sendState = iota
+	sendPaused
+	sendStopped
+)
+
+// Drives a send loop. Calls send on each tick while running, and stops the
+// ticker while paused. The interval func is checked after every send, and the
+// ticker is reset if it changes. Returns when send returns false or the
+// pinger is closed.
+func (p *Pinger) tickLoop(ctx context.Context, interval func() time.Duration, send func(now time.Time) bool) {
+	// Tickers from a clock.Clock can't be reset, so they're replaced.
+	cur := interval()
+	ticker := p.clock.NewTicker(cur)
+	defer func() { ticker.Stop() }()
+	state := sendRunning
+	if p.Paused() {
+		ticker.Stop()
+		state = sendPaused
+	}
+	for state != sendStopped {
+		switch state {
+		case sendRunning:
+			select {
+			case now := <-ticker.C():
+				if !send(now) {
+					state = sendStopped
+				} else if i := interval(); i != cur {
+					cur = i
+					ticker.Stop()
+					ticker = p.clock.NewTicker(cur)
+				}
+			case <-p.wake:
+				if p.Paused() {
+					ticker.Stop()
+					state = sendPaused
+				}
+			case <-ctx.Done():
+				state = sendStopped
+			}
+		case sendPaused:
+			select {
+			case <-p.wake:
+				if !p.Paused() {
+					ticker = p.clock.NewTicker(cur)
+					state = sendRunning
+				}
+			case <-ctx.Done():
+				state = sendStopped
+			}
+		}
+	}
+}
+
 // Sends pings and emits the sent sequence numbers over the channel.
-func (p *Pinger) sendLoop(sentSeqs chan<- int) {
+func (p *Pinger) sendLoop(ctx context.Context, sentSeqs chan<- int) {
 	defer close(sentSeqs)
-	// Note: This deliberately doesn't use p.clock because trying to manage
-	// advancing the clock and getting this to fire correctly is a nightmare.
-	ticker := time.NewTicker(p.opts.interval())
-	defer ticker.Stop()
 	pingsRemaining := p.opts.nPings()
 	seq := 0
-	for {
-		select {
-		case <-ticker.C:
-			if pingsRemaining <= 0 {
-				return
+	p.tickLoop(ctx, p.Interval, func(time.Time) bool {
+		if pingsRemaining <= 0 {
+			return false
+		}
+		if p.room() == 0 {
+			logging.Warnf("Too many outstanding pings to %v; skipping.", p.dest)
+			return true
+		}
+		pingsRemaining--
+		if err := p.sendPing(seq); err != nil {
+			// Keep going. The connection may be getting replaced, or the
+			// network may come back.
+			if !errors.Is(err, errReconnecting) {
+				logging.Warnf("Ping error: %v", err)
 			}
-			pingsRemaining--
-			err := p.sendPing(seq)
-			if err != nil {
-				log.Printf("Ping error; exiting send loop: %v", err)
-				return
+			return true
+		}
+		select {
+		case sentSeqs <- seq:
+		case <-ctx.Done():
+			return false
+		}
+		seq++
+		return true
+	})
+}
+
+// Sends pings in flood mode and emits the sent sequence numbers over the
+// channel. Pings are sent in batches sized by a [floodBatcher].
+func (p *Pinger) floodLoop(ctx context.Context, sentSeqs chan<- int) {
+	defer close(sentSeqs)
+	batcher := newFloodBatcher(p.opts.maxPPS(), p.clock.Now())
+	pingsRemaining := p.opts.nPings()
+	seq := 0
+	p.tickLoop(ctx, func() time.Duration { return floodTick }, func(now time.Time) bool {
+		if pingsRemaining <= 0 {
+			return false
+		}
+		n := min(batcher.Next(now, int(p.replies.Swap(0))), pingsRemaining, p.room())
+		if n == 0 {
+			return true
+		}
+		pingsRemaining -= n
+		sent, err := p.sendBatch(seq, n)
+		for range sent {
+			select {
+			case sentSeqs <- seq:
+			case <-ctx.Done():
+				return false
 			}
-			sentSeqs <- seq
-			seq = (seq + 1) & sequenceNoMask
-		case <-p.done:
-			return
+			seq++
+		}
+		if err != nil && !errors.Is(err, errReconnecting) {
+			logging.Warnf("Ping error: %v", err)
 		}
+		return true
+	})
+}
+
+// Returns the number of pings that can be sent without exceeding
+// MaxOutstanding.
+func (p *Pinger) room() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return max(0, p.opts.maxOutstanding()-p.hist.Outstanding())
+}
+
+// Makes the request packet for the ping with sequence number seq.
+func (p *Pinger) packet(seq int) *backend.Packet {
+	pkt := &backend.Packet{Type: p.opts.request(), Seq: seq & p.opts.seqMask(), Payload: p.payload}
+	if p.opts.token() {
+		pkt.Payload = withToken(p.payload, pkt.Seq, p.clock.Now())
 	}
+	return pkt
 }
 
-// Sends a ping.
+// Sends a ping, or a burst of them if ProbesPerInterval is more than one.
 func (p *Pinger) sendPing(seq int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	pkt := &backend.Packet{Seq: seq}
-	if err := p.conn.WriteTo(pkt, p.dest); err != nil {
+	if p.conn == nil {
+		return errReconnecting
+	}
+	n := p.opts.probesPerInterval()
+	if n == 1 {
+		pkt := p.packet(seq)
+		if err := p.conn.WriteTo(pkt, p.dest); err != nil {
+			return fmt.Errorf("error pinging %v: %v", p.dest, err)
+		}
+		p.hist.Add(seq)
+		return nil
+	}
+	pkts := make([]*backend.Packet, n)
+	for i := range pkts {
+		pkts[i] = p.packet(seq*n + i)
+	}
+	sent, err := backend.WriteBatch(p.conn, pkts, p.dest)
+	if sent > 0 {
+		p.hist.AddBurst(seq, sent)
+	}
+	if err != nil {
+		if sent > 0 {
+			logging.Warnf("Sent %d of %d probes to %v: %v", sent, n, p.dest, err)
+			return nil
+		}
 		return fmt.Errorf("error pinging %v: %v", p.dest, err)
 	}
-	p.hist.Add(seq)
 	return nil
 }
 
-// Receives pings and emits the results over the channel. Stops when conn is
-// closed.
-func (p *Pinger) receiveLoop(received chan<- readResult) {
+// Sends n pings starting at seq in a single batch. Returns the number sent.
+func (p *Pinger) sendBatch(seq, n int) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn == nil {
+		return 0, errReconnecting
+	}
+	pkts := make([]*backend.Packet, n)
+	for i := range pkts {
+		pkts[i] = p.packet(seq + i)
+	}
+	sent, err := backend.WriteBatch(p.conn, pkts, p.dest)
+	for i := range sent {
+		p.hist.Add(seq + i)
+	}
+	if err != nil {
+		return sent, fmt.Errorf("error pinging %v: %v", p.dest, err)
+	}
+	return sent, nil
+}
+
+// Receives pings and emits the results over the channel. Replaces the
+// connection if it dies. Stops when ctx is canceled or the pinger is closed.
+func (p *Pinger) receiveLoop(ctx context.Context, received chan<- readResult) {
 	for {
-		pkt, peer, err := p.conn.ReadFrom(context.TODO())
-		if err != nil {
-			log.Printf("ReadFrom error: %v", err)
+		p.mu.Lock()
+		conn := p.conn
+		p.mu.Unlock()
+		if conn == nil {
 			return
 		}
-		received <- readResult{pkt: pkt, peer: peer}
+		pkt, peer, err := conn.ReadFrom(ctx)
+		switch {
+		case err == nil:
+			select {
+			case received <- readResult{pkt: pkt, peer: peer}:
+			case <-ctx.Done():
+				return
+			}
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, backend.ErrTimeout):
+		case p.closed():
+			logging.Warnf("ReadFrom error: %v", err)
+			return
+		default:
+			logging.Warnf("ReadFrom error; reconnecting: %v", err)
+			if !p.reconnect(ctx) {
+				return
+			}
+		}
 	}
 }
 
-func (p *Pinger) handleReply(pkt *backend.Packet, peer net.Addr) {
+// Adjusts the ping interval for a new result in adaptive mode.
+func (p *Pinger) adapt(res PingResult) {
+	if p.controller == nil {
+		return
+	}
+	p.interval.Store(int64(p.controller.Update(res)))
+}
+
+// Handles a reply and returns its full sequence number and the recorded
+// result. Returns false if nothing was recorded because the reply is part of
+// a burst that's still waiting for others.
+func (p *Pinger) handleReply(pkt *backend.Packet, peer net.Addr) (int, PingResult, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	res := p.hist.Get(pkt.Seq)
+	seq, i := p.hist.UnwrapSeq(pkt.Seq, p.opts.probesPerInterval(), p.opts.seqMask())
+	if p.hist.IsBurst(seq) {
+		probe := p.replyResult(PingResult{Peer: peer, TTL: pkt.TTL}, pkt)
+		burst, _ := p.hist.Pending(seq)
+		probe.Latency, _ = p.wireLatency(pkt, burst.Time)
+		res, ok := p.hist.RecordProbe(seq, i, probe)
+		return seq, res, ok
+	}
+	res, ok := p.hist.Pending(seq)
+	if !ok {
+		res = p.hist.Get(seq)
+	}
 	res.Peer = peer
+	res.TTL = pkt.TTL
 
-	if t := res.Type; t != Waiting && t != Dropped {
-		log.Printf("Duplicate packet: %v", pkt)
+	switch res.Type {
+	case Waiting, Gap:
+		return seq, p.recordReply(seq, p.replyResult(res, pkt), pkt), true
+	case Dropped:
+		res = p.replyResult(res, pkt)
+		res.Type = Late
+		return seq, p.recordReply(seq, res, pkt), true
+	default:
+		logging.Debugf("Duplicate packet: %v", pkt)
 		res.Type = Duplicate
-		res = p.hist.Record(pkt.Seq, res)
-		return
+		return seq, p.hist.Record(seq, res), true
+	}
+}
+
+// Records the result of a reply, with its latency measured as close to the
+// wire as possible.
+func (p *Pinger) recordReply(seq int, res PingResult, pkt *backend.Packet) PingResult {
+	if lat, ok := p.wireLatency(pkt, res.Time); ok {
+		res.Latency = lat
+		return p.hist.RecordLatency(seq, res)
+	}
+	return p.hist.Record(seq, res)
+}
+
+// Returns the latency of a reply to a ping sent at sent, measured to the time
+// the reply was received. With PayloadTimestamp, the send time in an intact
+// token is used instead of sent. Returns false if the backend didn't say when
+// the reply was received and there's no token, or if the result makes no
+// sense because the wall clock changed.
+func (p *Pinger) wireLatency(pkt *backend.Packet, sent time.Time) (time.Duration, bool) {
+	received := pkt.RecvTime
+	if p.opts.payloadTimestamp() && pkt.Type == backend.PacketReply && checkToken(pkt.Payload, pkt.Seq) {
+		sent = tokenTime(pkt.Payload)
+		if received.IsZero() {
+			received = p.clock.Now()
+		}
+	}
+	if received.IsZero() {
+		return 0, false
 	}
+	lat := received.Sub(sent)
+	if lat <= 0 || lat > p.opts.timeout() {
+		return 0, false
+	}
+	return lat, true
+}
 
+// Fills in a result from a reply packet. Echo replies that fail verification
+// are corrupted.
+func (p *Pinger) replyResult(res PingResult, pkt *backend.Packet) PingResult {
+	res = replyResult(res, pkt)
+	if p.opts.verifyPayload() && pkt.Type == backend.PacketReply && !checkToken(pkt.Payload, pkt.Seq) {
+		logging.Debugf("Corrupted reply from %v: %v", res.Peer, pkt)
+		res.Type = Corrupted
+	}
+	return res
+}
+
+// Fills in a result from a reply packet.
+func replyResult(res PingResult, pkt *backend.Packet) PingResult {
 	switch pkt.Type {
-	case backend.PacketRequest:
+	case backend.PacketRequest, backend.PacketTimestampRequest, backend.PacketAddressMaskRequest:
 		// This case should be filtered out by PingConnection.
 		log.Panicf("Unexpected packet request received: %v", pkt)
 	case backend.PacketReply:
 		res.Type = Success
+	case backend.PacketTimestampReply:
+		res.Type = Success
+		res.Timestamps = pkt.Timestamps
+	case backend.PacketAddressMaskReply:
+		res.Type = Success
+		res.AddressMask = pkt.AddressMask
 	case backend.PacketTimeExceeded:
 		res.Type = TTLExceeded
-	case backend.PacketDestinationUnreachable:
+	case backend.PacketDestinationUnreachable, backend.PacketTooBig:
 		res.Type = Unreachable
 	}
+	return res
+}
 
-	res = p.hist.Record(pkt.Seq, res)
+// MarkGap marks all pings still awaiting a reply as gaps, so that they're
+// left out of the statistics whether or not a reply arrives. Call this when
+// probing is interrupted.
+func (p *Pinger) MarkGap() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hist.MarkGap()
 }
 
-// Records a timeout if necessary.
-func (p *Pinger) maybeRecordTimeout(seq int) {
+// Determines whether a timer scheduled for t that fired at now fired so late
+// that the process was most likely suspended (e.g. laptop sleep). The
+// monotonic clock stops during suspend on some systems, so this compares wall
+// clock times.
+func suspended(now, t time.Time, interval time.Duration) bool {
+	return now.Round(0).Sub(t.Round(0)) > max(interval, time.Second)
+}
+
+// Records a timeout if necessary. Returns the recorded result and true if a
+// timeout was recorded.
+func (p *Pinger) maybeRecordTimeout(seq int) (PingResult, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	res := p.hist.Get(seq)
-	if res.Type != Waiting {
-		return
+	if p.hist.IsBurst(seq) {
+		return p.hist.FinishBurst(seq)
+	}
+	res, ok := p.hist.Pending(seq)
+	if !ok {
+		// Already answered.
+		return PingResult{}, false
+	}
+	if res.Type == Gap {
+		p.hist.Forget(seq)
+		return PingResult{}, false
 	}
 	res.Type = Dropped
-	res = p.hist.Record(seq, res)
+	return p.hist.Record(seq, res), true
 }
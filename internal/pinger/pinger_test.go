@@ -1,14 +1,18 @@
 package pinger
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"runtime"
+	"slices"
 	"syscall"
 	"testing"
 	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pcekm/vasily/internal/backend"
 	_ "github.com/pcekm/vasily/internal/backend/icmp"
@@ -48,7 +52,7 @@ func TestLive(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating pinger: %v", err)
 	}
-	p.Run()
+	p.Run(context.Background())
 
 	if err := p.Close(); err != nil {
 		t.Errorf("Error closing pinger: %v", err)
@@ -59,7 +63,15 @@ func TestLive(t *testing.T) {
 		{Type: Success, Peer: test.LoopbackV4},
 		{Type: Success, Peer: test.LoopbackV4},
 	}
-	if diff := diffPingResults(want, p.History()); diff != "" {
+	got := p.History()
+	// Reply TTLs depend on the system.
+	for i := range got {
+		if got[i].TTL <= 0 {
+			t.Errorf("Result %d TTL = %d (want > 0)", i, got[i].TTL)
+		}
+		got[i].TTL = 0
+	}
+	if diff := diffPingResults(want, got); diff != "" {
 		t.Errorf("Wrong history (-want, +got):\n%v", diff)
 	}
 }
@@ -84,7 +96,7 @@ func TestPacketLoss(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating pinger: %v", err)
 	}
-	if !test.WithTimeout(p.Run, time.Second) {
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
 		t.Error("Timed out waiting for pinger completion.")
 	}
 	if err := p.Close(); err != nil {
@@ -107,6 +119,66 @@ func TestPacketLoss(t *testing.T) {
 	ctrl.Finish()
 }
 
+func TestOnResult(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	pe := test.NewPingExchange(0).SetNoReply(true)
+	conn.MockPingExchange(pe)
+	pe = test.NewPingExchange(1)
+	conn.MockPingExchange(pe)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	type seqResult struct {
+		Seq int
+		Res PingResult
+	}
+	var got []seqResult
+	opts := &Options{
+		NPings:   2,
+		Interval: time.Microsecond,
+		History:  2,
+		Timeout:  time.Millisecond,
+		OnResult: func(seq int, res PingResult) {
+			got = append(got, seqResult{Seq: seq, Res: res})
+		},
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	// Results arrive in whatever order replies and timeouts happen.
+	slices.SortFunc(got, func(a, b seqResult) int { return a.Seq - b.Seq })
+	want := []seqResult{
+		{Seq: 0, Res: PingResult{Type: Dropped}},
+		{Seq: 1, Res: PingResult{Type: Success, Peer: test.LoopbackV4}},
+	}
+	if diff := diffPingResults(want, got); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+
+	// Replaying the results should reproduce the history.
+	rp := NewReplay(&Options{History: 2})
+	for _, r := range got {
+		rp.Replay(r.Seq, r.Res)
+	}
+	if diff := diffPingResults(p.History(), rp.History()); diff != "" {
+		t.Errorf("Wrong replayed history (-want, +got):\n%v", diff)
+	}
+	if err := rp.Close(); err != nil {
+		t.Errorf("Error closing replay pinger: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
 func TestDuplicatePacket(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	conn := test.NewMockConn(ctrl)
@@ -130,15 +202,16 @@ func TestDuplicatePacket(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating pinger: %v", err)
 	}
-	if !test.WithTimeout(p.Run, time.Second) {
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
 		t.Error("Timed out waiting for pinger completion.")
 	}
 	if err := p.Close(); err != nil {
 		t.Errorf("Error closing pinger: %v", err)
 	}
 
+	// The duplicate leaves the first reply's result alone.
 	want := []PingResult{
-		{Type: Duplicate, Peer: test.LoopbackV4},
+		{Type: Success, Peer: test.LoopbackV4},
 		{Type: Success, Peer: test.LoopbackV4},
 		{Type: Dropped}}
 	if diff := diffPingResults(want, p.History()); diff != "" {
@@ -147,11 +220,414 @@ func TestDuplicatePacket(t *testing.T) {
 	if pl := p.Stats().PacketLoss(); pl != 1/3. {
 		t.Errorf("Wrong packet loss stats: %f (want %f)", pl, 1/3.)
 	}
+	if n := p.Stats().Duplicates; n != 1 {
+		t.Errorf("Wrong duplicate count: %d (want 1)", n)
+	}
 	log.Printf("Stats: %+v", p.Stats())
 
 	ctrl.Finish()
 }
 
+func TestLateReply(t *testing.T) {
+	p := NewReplay(&Options{History: 2})
+	defer p.Close()
+	p.hist.Add(0)
+	if _, ok := p.maybeRecordTimeout(0); !ok {
+		t.Fatalf("Timeout not recorded")
+	}
+	reply := &backend.Packet{Type: backend.PacketReply, Seq: 0}
+	_, res, ok := p.handleReply(reply, test.LoopbackV4)
+	if !ok {
+		t.Fatalf("Reply not recorded")
+	}
+	want := PingResult{Type: Late, Peer: test.LoopbackV4}
+	if diff := diffPingResults(want, res); diff != "" {
+		t.Errorf("Wrong result (-want, +got):\n%v", diff)
+	}
+	if diff := diffPingResults([]PingResult{want}, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+	// The ping is still lost, as it was when it timed out.
+	if st := p.Stats(); st.N != 1 || st.Failures != 1 || st.Late != 1 {
+		t.Errorf("Stats = %+v (want 1 ping, 1 failure, 1 late)", st)
+	}
+
+	if _, res, _ := p.handleReply(reply, test.LoopbackV4); res.Type != Duplicate {
+		t.Errorf("Second late reply type = %v (want %v)", res.Type, Duplicate)
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	opts := &Options{History: 3, VerifyPayload: true}
+	p := NewReplay(opts)
+	defer p.Close()
+	p.payload = opts.payload()
+	if len(p.payload) != tokenSize {
+		t.Fatalf("Payload is %d bytes (want %d)", len(p.payload), tokenSize)
+	}
+	var sent []*backend.Packet
+	for seq := range 3 {
+		p.hist.Add(seq)
+		sent = append(sent, p.packet(seq))
+	}
+
+	mangled := slices.Clone(sent[1].Payload)
+	mangled[tokenSize-1] ^= 0xff
+	replies := []*backend.Packet{
+		{Type: backend.PacketReply, Seq: 0, Payload: sent[0].Payload},
+		{Type: backend.PacketReply, Seq: 1, Payload: mangled},
+		// Echoes another ping's payload.
+		{Type: backend.PacketReply, Seq: 2, Payload: sent[0].Payload},
+	}
+	for _, r := range replies {
+		if _, _, ok := p.handleReply(r, test.LoopbackV4); !ok {
+			t.Fatalf("Reply %v not recorded", r)
+		}
+	}
+
+	want := []PingResult{
+		{Type: Success, Peer: test.LoopbackV4},
+		{Type: Corrupted, Peer: test.LoopbackV4},
+		{Type: Corrupted, Peer: test.LoopbackV4},
+	}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+	if st := p.Stats(); st.N != 3 || st.Failures != 2 || st.Corrupted != 2 {
+		t.Errorf("Stats = %+v (want 3 pings, 2 failures, 2 corrupted)", st)
+	}
+}
+
+func TestPayloadTimestamp(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	clk := fakeclock.NewFakeClock(start)
+	opts := &Options{History: 3, PayloadSize: 32, PayloadTimestamp: true, Clock: clk}
+	p := NewReplay(opts)
+	defer p.Close()
+	p.payload = opts.payload()
+	var sent []*backend.Packet
+	for seq := range 3 {
+		p.hist.Add(seq)
+		sent = append(sent, p.packet(seq))
+	}
+	clk.Increment(5 * time.Millisecond)
+
+	mangled := slices.Clone(sent[2].Payload)
+	mangled[tokenSize] ^= 0xff
+	replies := []*backend.Packet{
+		{Type: backend.PacketReply, Seq: 0, Payload: sent[0].Payload, RecvTime: start.Add(2 * time.Millisecond)},
+		// Read at an unknown time.
+		{Type: backend.PacketReply, Seq: 1, Payload: sent[1].Payload},
+		// Timed from when the ping was recorded as sent.
+		{Type: backend.PacketReply, Seq: 2, Payload: mangled, RecvTime: start.Add(time.Millisecond)},
+	}
+	var got []time.Duration
+	for _, r := range replies {
+		_, res, ok := p.handleReply(r, test.LoopbackV4)
+		if !ok || res.Type != Success {
+			t.Fatalf("handleReply(%v) = %v, %v (want success)", r, res, ok)
+		}
+		got = append(got, res.Latency)
+	}
+	want := []time.Duration{2 * time.Millisecond, 5 * time.Millisecond, time.Millisecond}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong latencies (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRecvTime(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	clk := fakeclock.NewFakeClock(start)
+	p := NewReplay(&Options{History: 3, Clock: clk})
+	defer p.Close()
+	for seq := range 3 {
+		p.hist.Add(seq)
+	}
+	clk.Increment(5 * time.Millisecond)
+
+	replies := []*backend.Packet{
+		{Type: backend.PacketReply, Seq: 0, RecvTime: start.Add(3 * time.Millisecond)},
+		// Unknown receive time.
+		{Type: backend.PacketReply, Seq: 1},
+		// The wall clock went backwards.
+		{Type: backend.PacketReply, Seq: 2, RecvTime: start.Add(-time.Hour)},
+	}
+	var got []time.Duration
+	for _, r := range replies {
+		_, res, _ := p.handleReply(r, test.LoopbackV4)
+		got = append(got, res.Latency)
+	}
+	want := []time.Duration{3 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong latencies (-want, +got):\n%v", diff)
+	}
+}
+
+func TestFlood(t *testing.T) {
+	const nPings = 20
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	var want []PingResult
+	for seq := range nPings {
+		conn.MockPingExchange(test.NewPingExchange(seq))
+		want = append(want, PingResult{Type: Success, Peer: test.LoopbackV4})
+	}
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	// At the default one second interval, this would take far longer than
+	// the timeout.
+	opts := &Options{
+		NPings:  nPings,
+		History: nPings,
+		Timeout: 100 * time.Millisecond,
+		Flood:   true,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTimeoutLongerThanHistory(t *testing.T) {
+	const nPings = 6
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	// Holds all the replies until every ping has been sent, by which time
+	// most of their history slots have been reused.
+	allSent := make(chan any)
+	sent := 0
+	conn.EXPECT().
+		WriteTo(gomock.Any(), gomock.Any()).
+		Times(nPings).
+		Do(func(*backend.Packet, net.Addr, ...backend.WriteOption) {
+			sent++
+			if sent == nPings {
+				close(allSent)
+			}
+		}).
+		Return(nil)
+	for seq := range nPings {
+		conn.EXPECT().
+			ReadFrom(gomock.Not(gomock.Nil())).
+			Do(func(context.Context) { <-allSent }).
+			Return(&backend.Packet{Type: backend.PacketReply, Seq: seq}, test.LoopbackV4, nil)
+	}
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:   nPings,
+		Interval: time.Millisecond,
+		History:  2,
+		Timeout:  200 * time.Millisecond,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	if st := p.Stats(); st.N != nPings || st.Failures != 0 {
+		t.Errorf("Wrong stats: N=%d Failures=%d (want N=%d Failures=0)", st.N, st.Failures, nPings)
+	}
+	want := []PingResult{
+		{Type: Success, Peer: test.LoopbackV4},
+		{Type: Success, Peer: test.LoopbackV4},
+	}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestFakeClock(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	sent := make(chan any)
+	conn.EXPECT().
+		WriteTo(&backend.Packet{Seq: 0}, test.LoopbackV4).
+		Do(func(*backend.Packet, net.Addr, ...backend.WriteOption) { close(sent) }).
+		Return(nil)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	start := time.Unix(1000, 0)
+	clk := fakeclock.NewFakeClock(start)
+	opts := &Options{
+		NPings:   1,
+		Interval: time.Second,
+		Timeout:  5 * time.Second,
+		Clock:    clk,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	done := make(chan any)
+	go func() {
+		p.Run(context.Background())
+		close(done)
+	}()
+
+	// Nothing is sent until the first tick.
+	clk.WaitForWatcherAndIncrement(time.Second)
+	<-sent
+	// Waits for the ticker and the timeout.
+	clk.WaitForNWatchersAndIncrement(opts.Timeout, 2)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := []PingResult{{Type: Dropped, Time: start.Add(time.Second), Latency: opts.Timeout}}
+	if diff := cmp.Diff(want, p.History()); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestRun_Cancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	sent := make(chan any, 1)
+	conn.EXPECT().
+		WriteTo(gomock.Any(), gomock.Any()).
+		AnyTimes().
+		Do(func(*backend.Packet, net.Addr, ...backend.WriteOption) {
+			select {
+			case sent <- nil:
+			default:
+			}
+		}).
+		Return(nil)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	p, err := New(name, util.IPv4, test.LoopbackV4, &Options{Interval: time.Millisecond, Timeout: time.Hour})
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sent
+		cancel()
+	}()
+	// Pings are still waiting for replies, but cancellation doesn't wait for
+	// them.
+	if !test.WithTimeout(func() { p.Run(ctx) }, time.Second) {
+		t.Error("Timed out waiting for Run to return after cancellation.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestNew_PayloadSize(t *testing.T) {
+	cases := []struct {
+		Name    string
+		IPVer   util.IPVersion
+		Dest    net.Addr
+		Size    int
+		WantErr bool
+	}{
+		{Name: "IPv4/Max", IPVer: util.IPv4, Dest: test.LoopbackV4, Size: 65507},
+		{Name: "IPv4/TooLarge", IPVer: util.IPv4, Dest: test.LoopbackV4, Size: 65508, WantErr: true},
+		{Name: "IPv6/Max", IPVer: util.IPv6, Dest: test.LoopbackV6, Size: 65527},
+		{Name: "IPv6/TooLarge", IPVer: util.IPv6, Dest: test.LoopbackV6, Size: 65528, WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			conn := test.NewMockConn(ctrl)
+			if !c.WantErr {
+				conn.MockClose()
+			}
+			name := test.RegisterMock(conn)
+
+			p, err := New(name, c.IPVer, c.Dest, &Options{PayloadSize: c.Size})
+			if c.WantErr {
+				if err == nil {
+					p.Close()
+					t.Errorf("New() with a %d byte payload succeeded (want error)", c.Size)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error creating pinger: %v", err)
+			}
+			if len(p.payload) != c.Size {
+				t.Errorf("Payload is %d bytes (want %d)", len(p.payload), c.Size)
+			}
+			if err := p.Close(); err != nil {
+				t.Errorf("Error closing pinger: %v", err)
+			}
+		})
+	}
+}
+
+func TestMaxOutstanding(t *testing.T) {
+	const nPings = 4
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).Times(nPings).Return(nil)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:         nPings,
+		Interval:       time.Millisecond,
+		Timeout:        100 * time.Millisecond,
+		MaxOutstanding: 2,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	start := time.Now()
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	// The last two pings can't be sent until the first two time out.
+	if elapsed := time.Since(start); elapsed < 2*opts.Timeout {
+		t.Errorf("Finished too soon: %v (want at least %v)", elapsed, 2*opts.Timeout)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	if st := p.Stats(); st.N != nPings || st.Failures != nPings {
+		t.Errorf("Wrong stats: N=%d Failures=%d (want N=%d Failures=%d)", st.N, st.Failures, nPings, nPings)
+	}
+
+	ctrl.Finish()
+}
+
 func TestHistory(t *testing.T) {
 	mkAddr := func(i int) net.Addr {
 		return &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i+1))}
@@ -198,7 +674,7 @@ func TestHistory(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Error creating pinger: %v", err)
 			}
-			if !test.WithTimeout(p.Run, time.Second) {
+			if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
 				t.Error("Timed out waiting for pinger completion.")
 			}
 			if err := p.Close(); err != nil {
@@ -216,3 +692,282 @@ func TestHistory(t *testing.T) {
 		})
 	}
 }
+
+func TestSetHistorySize(t *testing.T) {
+	p := NewReplay(&Options{History: 2})
+	defer p.Close()
+	for seq := range 3 {
+		p.Replay(seq, PingResult{Type: Success})
+	}
+
+	p.SetHistorySize(4)
+	if n := p.HistorySize(); n != 4 {
+		t.Errorf("HistorySize() = %d (want 4)", n)
+	}
+	// Seq 0 was already gone, so there's nothing to fill the extra space.
+	if n := len(p.History()); n != 2 {
+		t.Errorf("History has %d results after growing (want 2)", n)
+	}
+	for seq := 3; seq < 6; seq++ {
+		p.Replay(seq, PingResult{Type: Success})
+	}
+	if n := len(p.History()); n != 4 {
+		t.Errorf("History has %d results after more pings (want 4)", n)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	sent := make(chan any)
+	conn.EXPECT().
+		WriteTo(&backend.Packet{Seq: 0}, test.LoopbackV4).
+		Do(func(*backend.Packet, net.Addr, ...backend.WriteOption) { close(sent) }).
+		Return(nil)
+	conn.MockPingExchange(test.NewPingExchange(1))
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:   2,
+		Interval: 20 * time.Millisecond,
+		Timeout:  20 * time.Millisecond,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	done := make(chan any)
+	go func() {
+		p.Run(context.Background())
+		close(done)
+	}()
+
+	<-sent
+	p.Pause()
+	if !p.Paused() {
+		t.Errorf("Not paused after Pause().")
+	}
+	// Long enough for several more pings, and for the first to time out.
+	time.Sleep(5 * opts.Interval)
+	want := []PingResult{{Type: Gap}}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong results while paused (-want, +got):\n%v", diff)
+	}
+
+	p.Resume()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want = []PingResult{
+		{Type: Gap},
+		{Type: Success, Peer: test.LoopbackV4},
+	}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+	if st := p.Stats(); st.N != 1 || st.Failures != 0 {
+		t.Errorf("Wrong stats: N=%d Failures=%d (want N=1 Failures=0)", st.N, st.Failures)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTimestampRequest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	ts := backend.Timestamps{Originate: 1000, Receive: 6000, Transmit: 6000}
+	pe := test.NewPingExchange(0)
+	pe.SendPkt.Type = backend.PacketTimestampRequest
+	pe.RecvPkt = backend.Packet{Type: backend.PacketTimestampReply, Timestamps: ts}
+	conn.MockPingExchange(pe)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:   1,
+		Interval: time.Microsecond,
+		Timeout:  100 * time.Millisecond,
+		Request:  backend.PacketTimestampRequest,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := PingResult{Type: Success, Peer: test.LoopbackV4, Timestamps: ts}
+	got := p.Latest()
+	if diff := diffPingResults(want, got); diff != "" {
+		t.Errorf("Wrong result (-want, +got):\n%v", diff)
+	}
+	if _, ok := got.ClockOffset(); !ok {
+		t.Errorf("No clock offset in %v", got)
+	}
+
+	ctrl.Finish()
+}
+
+func TestReplyTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	pe := test.NewPingExchange(0)
+	pe.RecvPkt = backend.Packet{Type: backend.PacketReply, TTL: 57}
+	conn.MockPingExchange(pe)
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:   1,
+		Interval: time.Microsecond,
+		Timeout:  100 * time.Millisecond,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := PingResult{Type: Success, Peer: test.LoopbackV4, TTL: 57}
+	if diff := diffPingResults(want, p.Latest()); diff != "" {
+		t.Errorf("Wrong result (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestProbesPerInterval(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	// Two bursts of three. The second probe of the first burst is lost.
+	for wire := range 6 {
+		conn.MockPingExchange(test.NewPingExchange(wire).SetNoReply(wire == 1))
+	}
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	opts := &Options{
+		NPings:            2,
+		Interval:          time.Microsecond,
+		History:           2,
+		Timeout:           10 * time.Millisecond,
+		ProbesPerInterval: 3,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := []PingResult{
+		{Type: Success, Peer: test.LoopbackV4, Probes: 3, Replies: 2},
+		{Type: Success, Peer: test.LoopbackV4, Probes: 3, Replies: 3},
+	}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+	if st := p.Stats(); st.N != 6 || st.Failures != 1 {
+		t.Errorf("Wrong stats: N=%d Failures=%d (want N=6 Failures=1)", st.N, st.Failures)
+	}
+
+	ctrl.Finish()
+}
+
+func TestHopDistance(t *testing.T) {
+	cases := []struct {
+		TTL    int
+		Want   int
+		WantOK bool
+	}{
+		{TTL: 0},
+		{TTL: 1, Want: 31, WantOK: true},
+		{TTL: 32, Want: 0, WantOK: true},
+		{TTL: 57, Want: 7, WantOK: true},
+		{TTL: 64, Want: 0, WantOK: true},
+		{TTL: 117, Want: 11, WantOK: true},
+		{TTL: 250, Want: 5, WantOK: true},
+		{TTL: 255, Want: 0, WantOK: true},
+		{TTL: 256},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprint(c.TTL), func(t *testing.T) {
+			got, ok := PingResult{Type: Success, TTL: c.TTL}.HopDistance()
+			if got != c.Want || ok != c.WantOK {
+				t.Errorf("HopDistance() = %d, %v (want %d, %v)", got, ok, c.Want, c.WantOK)
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	dead := test.NewMockConn(ctrl)
+	sent := make(chan any)
+	dead.EXPECT().
+		WriteTo(&backend.Packet{Seq: 0}, test.LoopbackV4).
+		Do(func(*backend.Packet, net.Addr, ...backend.WriteOption) { close(sent) }).
+		Return(nil)
+	dead.EXPECT().
+		ReadFrom(gomock.Any()).
+		Do(func(context.Context) { <-sent }).
+		Return(nil, nil, errors.New("connection died"))
+	dead.EXPECT().Close().Return(nil)
+	live := test.NewMockConn(ctrl)
+	live.MockPingExchange(test.NewPingExchange(1))
+	live.MockClose()
+
+	conns := []backend.Conn{dead, live}
+	name := backend.Name(fmt.Sprintf("reconnect:%p", t))
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		if len(conns) == 0 {
+			return nil, errors.New("no more connections")
+		}
+		c := conns[0]
+		conns = conns[1:]
+		return c, nil
+	})
+
+	opts := &Options{
+		NPings:   2,
+		Interval: 20 * time.Millisecond,
+		Timeout:  100 * time.Millisecond,
+	}
+	p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+	if err != nil {
+		t.Fatalf("Error creating pinger: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := []PingResult{{Type: Gap}, {Type: Success, Peer: test.LoopbackV4}}
+	if diff := diffPingResults(want, p.History()); diff != "" {
+		t.Errorf("Wrong ping results (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
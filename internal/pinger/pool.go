@@ -0,0 +1,63 @@
+package pinger
+
+import (
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/connpool"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Replies buffered for each pinger sharing a connection. It's enough for a
+// burst, or a few intervals' worth of replies. Beyond that, replies are
+// dropped, so that a pinger that falls behind doesn't hold up the others.
+const pooledReplies = 16
+
+// Identifies connections that can be shared.
+type poolKey struct {
+	be        backend.Name
+	ipVer     util.IPVersion
+	iface     string
+	addr      string
+	mark      uint32
+	netns     string
+	flowLabel uint32
+	dnsQuery  backend.DNSQueryOption
+	http      backend.HTTPOption
+	recvBuf   int
+}
+
+// Pool shares backend connections between pingers. Each connection carries
+// up to 64 pingers, which are told apart by the range of sequence numbers
+// they send. This saves opening a connection for every host, which backends
+// limit. Set [Options.Pool] to use one. Flood pingers never share.
+type Pool struct {
+	conns *connpool.Pool[poolKey]
+}
+
+// NewPool creates an empty pool.
+func NewPool() *Pool {
+	return &Pool{conns: connpool.New[poolKey](connpool.Options{
+		Replies:      pooledReplies,
+		DropWhenFull: true,
+	})}
+}
+
+// Gets a connection for a single pinger, opening a new shared connection if
+// none has room.
+func (p *Pool) get(be backend.Name, ipVer util.IPVersion, opts *Options) (backend.Conn, error) {
+	src := opts.source()
+	key := poolKey{
+		be:        be,
+		ipVer:     ipVer,
+		iface:     src.Interface,
+		addr:      src.Addr.String(),
+		mark:      src.Mark,
+		netns:     src.Netns,
+		flowLabel: opts.flowLabel(),
+		dnsQuery:  opts.dnsQuery(),
+		http:      opts.http(),
+		recvBuf:   opts.recvBuffer(),
+	}
+	return p.conns.Get(key, func() (backend.Conn, error) {
+		return backend.New(be, ipVer, opts.connOptions()...)
+	})
+}
@@ -0,0 +1,283 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/connpool"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A connection that answers every ping immediately.
+type echoConn struct {
+	replies chan readResult
+	readErr chan error
+	done    chan any
+
+	mu      sync.Mutex
+	streams map[int]bool
+	closed  bool
+}
+
+func newEchoConn() *echoConn {
+	return &echoConn{
+		replies: make(chan readResult, 100),
+		readErr: make(chan error, 1),
+		done:    make(chan any),
+		streams: make(map[int]bool),
+	}
+}
+
+func (c *echoConn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	stream, _, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.streams[stream] = true
+	c.mu.Unlock()
+	c.replies <- readResult{pkt: &backend.Packet{Type: backend.PacketReply, Seq: pkt.Seq}, peer: dest}
+	return nil
+}
+
+func (c *echoConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case rr := <-c.replies:
+		return rr.pkt, rr.peer, nil
+	case err := <-c.readErr:
+		return nil, nil, err
+	case <-c.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *echoConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *echoConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Registers a backend that opens echoConns. Returns the backend name and a
+// function that returns the connections opened so far.
+func registerEcho(t *testing.T) (backend.Name, func() []*echoConn) {
+	t.Helper()
+	var mu sync.Mutex
+	var conns []*echoConn
+	name := backend.Name("echo:" + t.Name())
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := newEchoConn()
+		conns = append(conns, c)
+		return c, nil
+	})
+	return name, func() []*echoConn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*echoConn(nil), conns...)
+	}
+}
+
+func TestPoolSharesConnection(t *testing.T) {
+	name, opened := registerEcho(t)
+	pool := NewPool()
+	const nPingers = 3
+	var pingers []*Pinger
+	for range nPingers {
+		opts := &Options{
+			NPings:   3,
+			History:  3,
+			Interval: time.Millisecond,
+			Timeout:  10 * time.Millisecond,
+			Pool:     pool,
+		}
+		p, err := New(name, util.IPv4, test.LoopbackV4, opts)
+		if err != nil {
+			t.Fatalf("Error creating pinger: %v", err)
+		}
+		pingers = append(pingers, p)
+	}
+	conns := opened()
+	if len(conns) != 1 {
+		t.Fatalf("Opened %d connections (want 1)", len(conns))
+	}
+
+	var wg sync.WaitGroup
+	for _, p := range pingers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run(context.Background())
+		}()
+	}
+	if !test.WithTimeout(wg.Wait, time.Second) {
+		t.Fatal("Timed out waiting for pingers.")
+	}
+
+	want := []PingResult{
+		{Type: Success, Peer: test.LoopbackV4},
+		{Type: Success, Peer: test.LoopbackV4},
+		{Type: Success, Peer: test.LoopbackV4},
+	}
+	for i, p := range pingers {
+		if diff := diffPingResults(want, p.History()); diff != "" {
+			t.Errorf("Pinger %d wrong history (-want, +got):\n%v", i, diff)
+		}
+	}
+	wantStreams := map[int]bool{0: true, 1: true, 2: true}
+	if diff := cmp.Diff(wantStreams, conns[0].streams); diff != "" {
+		t.Errorf("Wrong streams used (-want, +got):\n%v", diff)
+	}
+
+	for i, p := range pingers {
+		if conns[0].isClosed() {
+			t.Errorf("Connection closed before pinger %d", i)
+		}
+		if err := p.Close(); err != nil {
+			t.Errorf("Error closing pinger %d: %v", i, err)
+		}
+	}
+	if !conns[0].isClosed() {
+		t.Errorf("Connection still open after all pingers closed")
+	}
+}
+
+func TestPoolFull(t *testing.T) {
+	name, opened := registerEcho(t)
+	pool := NewPool()
+	var conns []backend.Conn
+	for range connpool.Slots + 1 {
+		conn, err := pool.get(name, util.IPv4, &Options{})
+		if err != nil {
+			t.Fatalf("Error getting connection: %v", err)
+		}
+		conns = append(conns, conn)
+	}
+	if n := len(opened()); n != 2 {
+		t.Errorf("Opened %d connections (want 2)", n)
+	}
+
+	// A freed slot is reused before opening a new connection.
+	conns[0].Close()
+	conn, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	conns[0] = conn
+	if n := len(opened()); n != 2 {
+		t.Errorf("Opened %d connections (want 2)", n)
+	}
+
+	// Different connection options don't share.
+	other, err := pool.get(name, util.IPv4, &Options{FlowLabel: 1})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	conns = append(conns, other)
+	if n := len(opened()); n != 3 {
+		t.Errorf("Opened %d connections (want 3)", n)
+	}
+	other, err = pool.get(name, util.IPv4, &Options{DNSQuery: backend.DNSQueryOption{Name: "example.com"}})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	conns = append(conns, other)
+	if n := len(opened()); n != 4 {
+		t.Errorf("Opened %d connections (want 4)", n)
+	}
+
+	for _, c := range conns {
+		c.Close()
+	}
+	for i, c := range opened() {
+		if !c.isClosed() {
+			t.Errorf("Connection %d still open", i)
+		}
+	}
+}
+
+func TestPoolReadError(t *testing.T) {
+	name, opened := registerEcho(t)
+	pool := NewPool()
+	a, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	defer a.Close()
+	b, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	defer b.Close()
+
+	wantErr := errors.New("broken")
+	opened()[0].readErr <- wantErr
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, c := range []backend.Conn{a, b} {
+		if _, _, err := c.ReadFrom(ctx); !errors.Is(err, wantErr) {
+			t.Errorf("ReadFrom error = %v (want %v)", err, wantErr)
+		}
+	}
+
+	// The broken connection isn't handed out again.
+	c, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	defer c.Close()
+	if n := len(opened()); n != 2 {
+		t.Errorf("Opened %d connections (want 2)", n)
+	}
+}
+
+func TestPoolStalledReader(t *testing.T) {
+	name, _ := registerEcho(t)
+	pool := NewPool()
+	stalled, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	defer stalled.Close()
+	other, err := pool.get(name, util.IPv4, &Options{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	defer other.Close()
+
+	// More replies than are buffered for a pinger that never reads them.
+	for i := range 2 * pooledReplies {
+		if err := stalled.WriteTo(&backend.Packet{Seq: i}, test.LoopbackV4); err != nil {
+			t.Fatalf("WriteTo error: %v", err)
+		}
+	}
+	if err := other.WriteTo(&backend.Packet{Seq: 1}, test.LoopbackV4); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pkt, _, err := other.ReadFrom(ctx)
+	if err != nil {
+		t.Fatalf("ReadFrom error: %v (want the reply past the stalled pinger's)", err)
+	}
+	if pkt.Seq != 1 {
+		t.Errorf("Got reply %d (want 1)", pkt.Seq)
+	}
+}
@@ -0,0 +1,108 @@
+package pinger
+
+import (
+	"math"
+	"slices"
+)
+
+// Estimates a quantile of a stream of values without storing them, using the
+// P² algorithm:
+//
+//	Jain, R. and Chlamtac, I. "The P² algorithm for dynamic calculation of
+//	quantiles and histograms without storing observations." Communications
+//	of the ACM 28, 10 (1985).
+//
+// The estimate is exact for fewer than five values.
+type quantile struct {
+	p     float64
+	n     int
+	q     [5]float64 // Marker heights
+	pos   [5]float64 // Actual marker positions
+	want  [5]float64 // Desired marker positions
+	delta [5]float64 // Increments to desired marker positions
+}
+
+func newQuantile(p float64) *quantile {
+	return &quantile{
+		p:     p,
+		delta: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// Add adds a new value.
+func (e *quantile) Add(x float64) {
+	if e.n < len(e.q) {
+		e.q[e.n] = x
+		e.n++
+		if e.n == len(e.q) {
+			slices.Sort(e.q[:])
+			e.pos = [5]float64{1, 2, 3, 4, 5}
+			e.want = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.n++
+
+	// Find the cell k such that q[k] <= x < q[k+1], adjusting the extremes
+	// if necessary.
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for k < 3 && x >= e.q[k+1] {
+			k++
+		}
+	}
+	for i := k + 1; i < len(e.pos); i++ {
+		e.pos[i]++
+	}
+	for i := range e.want {
+		e.want[i] += e.delta[i]
+	}
+
+	// Adjust the middle markers if they're too far from where they should be.
+	for i := 1; i <= 3; i++ {
+		d := e.want[i] - e.pos[i]
+		if (d >= 1 && e.pos[i+1]-e.pos[i] > 1) || (d <= -1 && e.pos[i-1]-e.pos[i] < -1) {
+			s := math.Copysign(1, d)
+			q := e.parabolic(i, s)
+			if e.q[i-1] >= q || q >= e.q[i+1] {
+				q = e.linear(i, s)
+			}
+			e.q[i] = q
+			e.pos[i] += s
+		}
+	}
+}
+
+// Piecewise-parabolic prediction for marker i moved by s (±1).
+func (e *quantile) parabolic(i int, s float64) float64 {
+	return e.q[i] + s/(e.pos[i+1]-e.pos[i-1])*
+		((e.pos[i]-e.pos[i-1]+s)*(e.q[i+1]-e.q[i])/(e.pos[i+1]-e.pos[i])+
+			(e.pos[i+1]-e.pos[i]-s)*(e.q[i]-e.q[i-1])/(e.pos[i]-e.pos[i-1]))
+}
+
+// Linear prediction for marker i moved by s (±1).
+func (e *quantile) linear(i int, s float64) float64 {
+	j := i + int(s)
+	return e.q[i] + s*(e.q[j]-e.q[i])/(e.pos[j]-e.pos[i])
+}
+
+// Value returns the current estimate. Returns zero if there are no values.
+func (e *quantile) Value() float64 {
+	if e.n == 0 {
+		return 0
+	}
+	if e.n < len(e.q) {
+		// Nearest rank on the values seen so far.
+		vals := slices.Clone(e.q[:e.n])
+		slices.Sort(vals)
+		i := max(0, int(math.Ceil(e.p*float64(e.n)))-1)
+		return vals[i]
+	}
+	return e.q[2]
+}
@@ -0,0 +1,59 @@
+package pinger
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestQuantile_Small(t *testing.T) {
+	cases := []struct {
+		p    float64
+		vals []float64
+		want float64
+	}{
+		{p: 0.5, vals: nil, want: 0},
+		{p: 0.5, vals: []float64{3}, want: 3},
+		{p: 0.5, vals: []float64{20, 10}, want: 10},
+		{p: 0.95, vals: []float64{20, 10}, want: 20},
+		{p: 0.5, vals: []float64{4, 1, 3, 2}, want: 2},
+		{p: 0.5, vals: []float64{5, 4, 1, 3, 2}, want: 3},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%v/%v", c.p, c.vals), func(t *testing.T) {
+			q := newQuantile(c.p)
+			for _, v := range c.vals {
+				q.Add(v)
+			}
+			if got := q.Value(); got != c.want {
+				t.Errorf("Wrong quantile: %v (want %v)", got, c.want)
+			}
+		})
+	}
+}
+
+func TestQuantile_Stream(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var vals []float64
+	for range 10000 {
+		// Roughly latency-shaped: mostly low values with a long tail.
+		vals = append(vals, 10+rnd.ExpFloat64()*20)
+	}
+	sorted := slices.Clone(vals)
+	slices.Sort(sorted)
+
+	for _, p := range []float64{0.5, 0.95, 0.99} {
+		t.Run(fmt.Sprint(p), func(t *testing.T) {
+			q := newQuantile(p)
+			for _, v := range vals {
+				q.Add(v)
+			}
+			want := sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+			if got := q.Value(); math.Abs(got-want)/want > 0.05 {
+				t.Errorf("Quantile estimate too far off: %v (want %v)", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package pinger
+
+import (
+	"context"
+	"iter"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pcekm/vasily/internal/logging"
+)
+
+// Default number of results buffered for each subscription.
+const defaultResultQueue = 256
+
+// SeqResult is a result and the sequence number of its ping.
+type SeqResult struct {
+	Seq    int
+	Result PingResult
+}
+
+// SubscribeOptions holds options for [Pinger.Subscribe].
+type SubscribeOptions struct {
+	// Buffer is the number of results that may wait to be read. Defaults to
+	// 256.
+	Buffer int
+
+	// Block makes the pinger wait for the subscriber when its buffer is full,
+	// so that it sees every result. Otherwise, results are dropped until it
+	// catches up. While it waits, the pinger doesn't read replies, or pass
+	// results to other subscribers, so a slow subscriber can skew latencies.
+	Block bool
+}
+
+func (o *SubscribeOptions) buffer() int {
+	if o == nil || o.Buffer == 0 {
+		return defaultResultQueue
+	}
+	return o.Buffer
+}
+
+func (o *SubscribeOptions) block() bool {
+	return o != nil && o.Block
+}
+
+// Subscription receives a pinger's results, including timeouts and
+// duplicates, in the order they're recorded. That isn't always the order they
+// were sent in, since a lost ping is only recorded when it times out. Each
+// subscription has a buffer of its own, so subscribers don't hold each other
+// up unless they block.
+type Subscription struct {
+	p       *Pinger
+	opts    *SubscribeOptions
+	ch      chan SeqResult
+	done    chan any // Closed by Close.
+	once    sync.Once
+	dropped atomic.Int64
+}
+
+// Subscribe returns a subscription to the pinger's results from now on.
+// Close it when done. Closing the pinger closes it too.
+func (p *Pinger) Subscribe(opts *SubscribeOptions) *Subscription {
+	s := &Subscription{
+		p:    p,
+		opts: opts,
+		ch:   make(chan SeqResult, opts.buffer()),
+		done: make(chan any),
+	}
+	p.subMu.Lock()
+	defer p.subMu.Unlock()
+	if p.closed() {
+		s.once.Do(func() {
+			close(s.done)
+			close(s.ch)
+		})
+		return s
+	}
+	if p.subs == nil {
+		p.subs = make(map[*Subscription]bool)
+	}
+	p.subs[s] = true
+	return s
+}
+
+// C returns the channel results arrive on. It's closed when the subscription
+// or the pinger is closed.
+func (s *Subscription) C() <-chan SeqResult {
+	return s.ch
+}
+
+// All returns an iterator over the results. It ends when the subscription or
+// the pinger is closed. Stopping early doesn't close the subscription.
+func (s *Subscription) All() iter.Seq2[int, PingResult] {
+	return func(yield func(int, PingResult) bool) {
+		for r := range s.ch {
+			if !yield(r.Seq, r.Result) {
+				return
+			}
+		}
+	}
+}
+
+// Dropped returns the number of results dropped because the buffer was full.
+func (s *Subscription) Dropped() int {
+	return int(s.dropped.Load())
+}
+
+// Close ends the subscription. Results already buffered can still be read.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		// Wakes a blocked send, which has the lock.
+		close(s.done)
+		s.p.subMu.Lock()
+		defer s.p.subMu.Unlock()
+		delete(s.p.subs, s)
+		close(s.ch)
+		if n := s.Dropped(); n > 0 {
+			logging.Warnf("Dropped %d results a subscriber to %v couldn't keep up with.", n, s.p.dest)
+		}
+	})
+}
+
+// Sends a result to the subscriber. Must be called with p.subMu read locked.
+func (s *Subscription) send(ctx context.Context, r SeqResult) {
+	select {
+	case s.ch <- r:
+		return
+	default:
+	}
+	if s.opts.block() {
+		select {
+		case s.ch <- r:
+			return
+		case <-s.done:
+			return
+		case <-ctx.Done():
+		}
+	}
+	if s.dropped.Add(1) == 1 {
+		logging.Warnf("A subscriber to %v is falling behind. Dropping results.", s.p.dest)
+	}
+}
+
+// Sends a result to all the subscribers.
+func (p *Pinger) publish(ctx context.Context, seq int, res PingResult) {
+	p.subMu.RLock()
+	defer p.subMu.RUnlock()
+	for s := range p.subs {
+		s.send(ctx, SeqResult{Seq: seq, Result: res})
+	}
+}
+
+// Closes all the subscriptions.
+func (p *Pinger) closeSubscriptions() {
+	p.subMu.RLock()
+	var subs []*Subscription
+	for s := range p.subs {
+		subs = append(subs, s)
+	}
+	p.subMu.RUnlock()
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+// Passes results to [Options.OnResult] from a subscription of its own. Call
+// the returned func to wait for it once there are no more results.
+func (p *Pinger) startOnResult() (stop func()) {
+	if p.opts == nil || p.opts.OnResult == nil {
+		return func() {}
+	}
+	sub := p.Subscribe(&SubscribeOptions{Buffer: p.opts.ResultQueue, Block: p.opts.BlockOnResult})
+	done := make(chan any)
+	go func() {
+		defer close(done)
+		for seq, res := range sub.All() {
+			p.opts.OnResult(seq, res)
+		}
+	}()
+	return func() {
+		sub.Close()
+		<-done
+	}
+}
@@ -0,0 +1,89 @@
+package pinger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Reads all of a subscription's results.
+func readAll(s *Subscription) []int {
+	var seqs []int
+	for seq := range s.All() {
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestSubscribe(t *testing.T) {
+	p := NewReplay(nil)
+	a := p.Subscribe(nil)
+	b := p.Subscribe(&SubscribeOptions{Buffer: 1, Block: true})
+	var gotB []int
+	done := make(chan any)
+	go func() {
+		defer close(done)
+		for r := range b.C() {
+			time.Sleep(time.Microsecond)
+			gotB = append(gotB, r.Seq)
+		}
+	}()
+
+	var want []int
+	for i := range 20 {
+		p.Replay(i, PingResult{Type: Success})
+		want = append(want, i)
+	}
+	p.Close()
+	<-done
+	if diff := cmp.Diff(want, readAll(a)); diff != "" {
+		t.Errorf("Wrong results for first subscriber (-want, +got):\n%v", diff)
+	}
+	if diff := cmp.Diff(want, gotB); diff != "" {
+		t.Errorf("Wrong results for blocking subscriber (-want, +got):\n%v", diff)
+	}
+}
+
+func TestSubscribe_Drop(t *testing.T) {
+	p := NewReplay(nil)
+	defer p.Close()
+	s := p.Subscribe(&SubscribeOptions{Buffer: 2})
+	for i := range 3 {
+		p.Replay(i, PingResult{Type: Success})
+	}
+	s.Close()
+	if diff := cmp.Diff([]int{0, 1}, readAll(s)); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+	if s.Dropped() != 1 {
+		t.Errorf("Dropped %d results (want 1)", s.Dropped())
+	}
+}
+
+func TestSubscribe_CloseWhileBlocked(t *testing.T) {
+	p := NewReplay(nil)
+	defer p.Close()
+	s := p.Subscribe(&SubscribeOptions{Buffer: 1, Block: true})
+	p.Replay(0, PingResult{Type: Success})
+	go func() {
+		time.Sleep(time.Millisecond)
+		s.Close()
+	}()
+	// Blocks until the subscription is closed.
+	p.Replay(1, PingResult{Type: Success})
+	if diff := cmp.Diff([]int{0}, readAll(s)); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+}
+
+func TestSubscribe_Closed(t *testing.T) {
+	p := NewReplay(nil)
+	p.Close()
+	s := p.Subscribe(nil)
+	p.Replay(0, PingResult{Type: Success})
+	if got := readAll(s); len(got) != 0 {
+		t.Errorf("Got results after close: %v", got)
+	}
+	s.Close()
+}
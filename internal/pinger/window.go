@@ -0,0 +1,179 @@
+package pinger
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// StatsWindows are the lengths of the rolling windows that statistics are kept
+// for, besides the cumulative ones. Shortest first.
+var StatsWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// A result counted in the rolling windows.
+type windowEntry struct {
+	t         time.Time
+	n, failed int
+	success   bool
+	latency   time.Duration
+
+	// Difference from the latency of the previous successful result, if
+	// there was one.
+	jitter    time.Duration
+	hasJitter bool
+}
+
+// Running totals for one window.
+type windowSums struct {
+	d     time.Duration
+	start int // Index of the oldest entry in the window.
+
+	n, failures        int
+	nResults, nSuccess int
+	nJitter            int
+	sum, jitterSum     time.Duration
+	sumSq              float64
+}
+
+func (s *windowSums) add(e windowEntry, sign int) {
+	s.n += sign * e.n
+	s.failures += sign * e.failed
+	s.nResults += sign
+	if e.success {
+		s.nSuccess += sign
+		s.sum += time.Duration(sign) * e.latency
+		s.sumSq += float64(sign) * float64(e.latency) * float64(e.latency)
+	}
+	if e.hasJitter {
+		s.nJitter += sign
+		s.jitterSum += time.Duration(sign) * e.jitter
+	}
+}
+
+// Keeps statistics over rolling windows of the most recent results. Counts
+// and sums are updated as results come and go. The latency extremes and
+// percentiles are worked out when asked for, and cached until the next
+// result. Windows end at the newest result rather than the current time, so
+// that replayed sessions have windows too.
+type windows struct {
+	// Results in the longest window, oldest first. Never more than
+	// maxRetainedHistory.
+	entries []windowEntry
+	sums    []windowSums // Indexed like StatsWindows.
+
+	prevLatency time.Duration
+	hasPrev     bool
+
+	// Time of the newest result, where the windows end.
+	latest time.Time
+
+	cache map[time.Duration]Stats
+}
+
+func newWindows() *windows {
+	w := &windows{cache: make(map[time.Duration]Stats)}
+	for _, d := range StatsWindows {
+		w.sums = append(w.sums, windowSums{d: d})
+	}
+	return w
+}
+
+// Add counts a result.
+func (w *windows) Add(r PingResult) {
+	n, failed := probeCounts(r)
+	e := windowEntry{t: r.Time, n: n, failed: failed}
+	if r.Type == Success {
+		e.success = true
+		e.latency = r.Latency
+		if w.hasPrev {
+			e.jitter = (r.Latency - w.prevLatency).Abs()
+			e.hasJitter = true
+		}
+		w.prevLatency = r.Latency
+		w.hasPrev = true
+	}
+	w.entries = append(w.entries, e)
+	for i := range w.sums {
+		w.sums[i].add(e, 1)
+	}
+	if r.Time.After(w.latest) {
+		w.latest = r.Time
+	}
+	w.expire(w.latest)
+	clear(w.cache)
+}
+
+// Gap notes a gap in probing, so that the jitter isn't taken across it.
+func (w *windows) Gap() {
+	w.hasPrev = false
+}
+
+// Drops entries that have left each window as of now.
+func (w *windows) expire(now time.Time) {
+	for i := range w.sums {
+		s := &w.sums[i]
+		limit := now.Add(-s.d)
+		for s.start < len(w.entries) && (!w.entries[s.start].t.After(limit) || len(w.entries)-s.start > maxRetainedHistory) {
+			s.add(w.entries[s.start], -1)
+			s.start++
+		}
+	}
+	// The longest window starts earliest.
+	drop := w.sums[len(w.sums)-1].start
+	if drop > len(w.entries)/2 {
+		w.entries = slices.Delete(w.entries, 0, drop)
+		for i := range w.sums {
+			w.sums[i].start -= drop
+		}
+	}
+}
+
+// Stats returns the statistics for the window of length d, which must be one
+// of StatsWindows. Late, duplicate and corrupted replies are only counted in
+// the cumulative statistics. The jitter is the mean difference between successive
+// latencies, rather than the smoothed estimate of the cumulative statistics.
+func (w *windows) Stats(d time.Duration) Stats {
+	if st, ok := w.cache[d]; ok {
+		return st
+	}
+	i := slices.IndexFunc(w.sums, func(s windowSums) bool { return s.d == d })
+	if i < 0 {
+		return Stats{}
+	}
+	s := w.sums[i]
+	st := Stats{N: s.n, Failures: s.failures}
+	if s.nSuccess > 0 {
+		avg := float64(s.sum) / float64(s.nSuccess)
+		st.AvgLatency = time.Duration(avg)
+		// Rounding in the running sums can take the variance a little
+		// below zero.
+		m2 := max(0, s.sumSq-float64(s.nSuccess)*avg*avg)
+		st.StdDev = time.Duration(math.Sqrt(m2 / float64(s.nResults)))
+	}
+	if s.nJitter > 0 {
+		st.Jitter = s.jitterSum / time.Duration(s.nJitter)
+	}
+	var lat []time.Duration
+	for _, e := range w.entries[s.start:] {
+		if e.success {
+			lat = append(lat, e.latency)
+		}
+	}
+	if len(lat) > 0 {
+		st.LastLatency = lat[len(lat)-1]
+		slices.Sort(lat)
+		st.MinLatency = lat[0]
+		st.MaxLatency = lat[len(lat)-1]
+		st.P50 = percentile(lat, 0.5)
+		st.P95 = percentile(lat, 0.95)
+		st.P99 = percentile(lat, 0.99)
+	}
+	w.cache[d] = st
+	return st
+}
+
+// Returns the nearest-rank percentile p of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(0, i)]
+}
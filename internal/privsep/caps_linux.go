@@ -0,0 +1,100 @@
+package privsep
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Bit for CAP_NET_RAW in the capability sets.
+const capNetRaw = 13
+
+var (
+	// For testing.
+	procStatusPath     = "/proc/self/status"
+	pingGroupRangePath = "/proc/sys/net/ipv4/ping_group_range"
+)
+
+// Returns true if raw sockets can be opened in this process without being
+// root, which is the case when the binary was given cap_net_raw (e.g. with
+// setcap). A process running as root has every capability, but that's no
+// reason to skip privsep: it should still drop root, and keep only a helper.
+func inProcessRawSockets() bool {
+	if syscall.Geteuid() == 0 {
+		return false
+	}
+	caps, err := effectiveCaps()
+	if err != nil {
+		return false
+	}
+	return caps&(1<<capNetRaw) != 0
+}
+
+// Reads the effective capability set of this process.
+func effectiveCaps() (uint64, error) {
+	f, err := os.Open(procStatusPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no CapEff in %s", procStatusPath)
+}
+
+// UnprivilegedPing returns true if the net.ipv4.ping_group_range sysctl lets
+// one of this process's groups open unprivileged ICMP sockets. It covers IPv6
+// as well.
+func UnprivilegedPing() bool {
+	lo, hi, err := PingGroupRange()
+	if err != nil {
+		return false
+	}
+	groups, err := syscall.Getgroups()
+	if err != nil {
+		return false
+	}
+	for _, g := range append(groups, syscall.Getegid()) {
+		if lo <= g && g <= hi {
+			return true
+		}
+	}
+	return false
+}
+
+// PingGroupRange returns the range of gids allowed to open unprivileged ICMP
+// sockets by the net.ipv4.ping_group_range sysctl. The range is empty if lo is
+// greater than hi, which is the default.
+func PingGroupRange() (lo, hi int, err error) {
+	b, err := os.ReadFile(pingGroupRangePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parsePingGroupRange(string(b))
+}
+
+// Parses the contents of the ping_group_range sysctl, which is two gids
+// separated by whitespace. The range is empty if the first is greater.
+func parsePingGroupRange(s string) (lo, hi int, err error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("malformed ping_group_range: %q", s)
+	}
+	if lo, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, fmt.Errorf("malformed ping_group_range: %q", s)
+	}
+	if hi, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, fmt.Errorf("malformed ping_group_range: %q", s)
+	}
+	return lo, hi, nil
+}
@@ -0,0 +1,69 @@
+package privsep
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePingGroupRange(t *testing.T) {
+	cases := []struct {
+		In             string
+		WantLo, WantHi int
+		WantErr        bool
+	}{
+		{In: "0\t2147483647\n", WantLo: 0, WantHi: 2147483647},
+		{In: "1 0", WantLo: 1, WantHi: 0},
+		{In: "1", WantErr: true},
+		{In: "a b", WantErr: true},
+	}
+	for _, c := range cases {
+		lo, hi, err := parsePingGroupRange(c.In)
+		if (err != nil) != c.WantErr {
+			t.Errorf("parsePingGroupRange(%q) error = %v (want error: %v)", c.In, err, c.WantErr)
+			continue
+		}
+		if lo != c.WantLo || hi != c.WantHi {
+			t.Errorf("parsePingGroupRange(%q) = %d, %d (want %d, %d)", c.In, lo, hi, c.WantLo, c.WantHi)
+		}
+	}
+}
+
+func TestEffectiveCaps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status")
+	status := "Name:\tvasily\nCapInh:\t0000000000000000\nCapPrm:\t0000000000002000\nCapEff:\t0000000000002000\n"
+	if err := os.WriteFile(path, []byte(status), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig string) { procStatusPath = orig }(procStatusPath)
+	procStatusPath = path
+
+	caps, err := effectiveCaps()
+	if err != nil {
+		t.Fatalf("effectiveCaps error: %v", err)
+	}
+	if caps != 1<<capNetRaw {
+		t.Errorf("effectiveCaps() = %#x (want %#x)", caps, 1<<capNetRaw)
+	}
+}
+
+func TestUnprivilegedPing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ping_group_range")
+	defer func(orig string) { pingGroupRangePath = orig }(pingGroupRangePath)
+	pingGroupRangePath = path
+
+	for _, c := range []struct {
+		Range string
+		Want  bool
+	}{
+		{Range: "0\t2147483647\n", Want: true},
+		{Range: "1\t0\n", Want: false},
+	} {
+		if err := os.WriteFile(path, []byte(c.Range), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := UnprivilegedPing(); got != c.Want {
+			t.Errorf("UnprivilegedPing() with range %q = %v (want %v)", c.Range, got, c.Want)
+		}
+	}
+}
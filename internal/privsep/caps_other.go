@@ -0,0 +1,15 @@
+//go:build !linux
+
+package privsep
+
+// Returns true if raw sockets can be opened in this process without being
+// root. Only Linux has capabilities that allow that.
+func inProcessRawSockets() bool {
+	return false
+}
+
+// UnprivilegedPing returns true if this process can open unprivileged ICMP
+// sockets. Darwin always allows it, and the other systems use privsep instead.
+func UnprivilegedPing() bool {
+	return true
+}
@@ -6,65 +6,241 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
+	"net"
 	"os"
+	"slices"
 	"sync"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/privsep/messages"
 	"github.com/pcekm/vasily/internal/util"
 )
 
+// ErrRestarting is returned by requests and writes made while the client is
+// switching to a restarted server. It's transient.
+var ErrRestarting = errors.New("privsep server restarting")
+
+// Most pings that may wait to be written to the server. Beyond this, the
+// oldest are dropped.
+const maxQueuedPings = 256
+
+// Most replies that may wait to be read on each connection. Beyond this, the
+// oldest are dropped.
+const maxQueuedReplies = 256
+
+// QueueStats describes the pings waiting to be written to the server.
+type QueueStats struct {
+	Depth    int // Pings waiting now.
+	MaxDepth int // Most pings that have waited at once.
+	Queued   int // Pings queued in total.
+	Dropped  int // Pings dropped to make room for newer ones.
+	Batches  int // Writes that sent several pings at once.
+}
+
+// ReplyStats describes the replies waiting to be read, over all connections.
+// Each connection has its own queue, so that one that's slow to read doesn't
+// hold up replies to the others.
+type ReplyStats struct {
+	MaxDepth  int // Most replies that have waited at once on one connection.
+	Delivered int // Replies queued in total.
+	Dropped   int // Replies dropped to make room for newer ones.
+}
+
+// A message waiting to be written to the server.
+type outgoing struct {
+	msg messages.Message
+
+	// Set for pings, whose connection ID is filled in when they're written.
+	conn *Connection
+
+	// Gets the result of writing anything but a ping. Buffered.
+	done chan error
+}
+
 // Client is the client for the privsep server.
 type Client struct {
-	in            io.ReadCloser
-	inb           *bufio.Reader
-	openConnReply chan messages.OpenConnectionReply
+	helloReply chan messages.HelloReply
 
 	mu          sync.Mutex
+	in          io.ReadCloser
 	out         io.WriteCloser
 	connections map[messages.ConnectionID]*Connection
+
+	// Requests awaiting replies, by request ID.
+	nextRequest messages.RequestID
+	pending     map[messages.RequestID]chan messages.Message
+
+	// Set while Reconnect is bringing a new server up to date.
+	restarting bool
+
+	// Messages waiting to be written, oldest first. A single writer keeps
+	// them in order, so a slow server holds up pings without holding up
+	// their callers.
+	queue      []outgoing
+	queued     *sync.Cond // Signaled when queue grows or the client closes.
+	queueStats QueueStats
+	queueLimit int  // For testing.
+	complained bool // Whether drops have been logged since the queue was empty.
+	closed     bool
+
+	replyStats ReplyStats
+	replyLimit int // For testing.
+
+	// The rate limits in effect, if they were ever changed. A restarted
+	// server gets them too.
+	limits *messages.RateLimitReply
 }
 
 // New creates a new client.
 func New(in io.ReadCloser, out io.WriteCloser) *Client {
 	c := &Client{
-		in:            in,
-		inb:           bufio.NewReader(in),
-		out:           out,
-		openConnReply: make(chan messages.OpenConnectionReply),
-		connections:   make(map[messages.ConnectionID]*Connection),
+		in:          in,
+		out:         out,
+		helloReply:  make(chan messages.HelloReply),
+		connections: make(map[messages.ConnectionID]*Connection),
+		pending:     make(map[messages.RequestID]chan messages.Message),
+		queueLimit:  maxQueuedPings,
+		replyLimit:  maxQueuedReplies,
 	}
-	go c.inputDemux()
+	c.queued = sync.NewCond(&c.mu)
+	go c.inputDemux(in)
+	go c.writeLoop()
 	return c
 }
 
-// Close closes the client.
+// Close closes the client. Messages still waiting to be written are dropped.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.queued.Broadcast()
 	return errors.Join(
 		c.in.Close(),
 		c.out.Close(),
 	)
 }
 
+// Reconnect switches the client over to a new server after the old one exited.
+// Requests still waiting on the old server fail with [ErrRestarting], as do new
+// requests and writes until the switch is complete. Then the new server is
+// greeted, given the rate limits in effect, and asked to reopen every open
+// connection. Connections carry on under the IDs the new server assigns them.
+// Any that can't be reopened get a read error.
+func (c *Client) Reconnect(in io.ReadCloser, out io.WriteCloser) error {
+	c.mu.Lock()
+	// The old pipes are most likely closed already.
+	c.in.Close()
+	c.out.Close()
+	c.in, c.out = in, out
+	c.restarting = true
+	for id, replyCh := range c.pending {
+		close(replyCh)
+		delete(c.pending, id)
+	}
+	// Whatever was meant for the old server is stale.
+	c.clearQueue(ErrRestarting)
+	conns := slices.Collect(maps.Values(c.connections))
+	limits := c.limits
+	c.mu.Unlock()
+	go c.inputDemux(in)
+
+	if err := c.Hello(); err != nil {
+		return err
+	}
+	if limits != nil {
+		if _, err := c.setRateLimit(true, limits.PerConnection, limits.Global); err != nil {
+			return fmt.Errorf("error restoring rate limits: %v", err)
+		}
+	}
+
+	reopened := make(map[messages.ConnectionID]*Connection)
+	for _, conn := range conns {
+		reply, err := c.openConnection(true, conn.open)
+		if err != nil {
+			logging.Warnf("Error reopening connection %v: %v", conn.ID(), err)
+			select {
+			case conn.readErr <- fmt.Errorf("error reopening connection after privsep server restart: %v", err):
+			default:
+			}
+			continue
+		}
+		reopened[reply.ID] = conn
+	}
+
+	c.mu.Lock()
+	var closed []messages.ConnectionID
+	for id, conn := range reopened {
+		conn.id = id
+		if conn.closed {
+			// Closed while it was being reopened.
+			delete(reopened, id)
+			closed = append(closed, id)
+		}
+	}
+	c.connections = reopened
+	c.restarting = false
+	c.mu.Unlock()
+
+	for _, id := range closed {
+		_, err := request[messages.CloseConnectionReply](c, func(reqID messages.RequestID) messages.Message {
+			return messages.CloseConnection{Request: reqID, ID: id}
+		})
+		if err != nil {
+			logging.Warnf("Error closing connection %v: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// Hello performs the protocol version handshake with the server. It must be
+// called before any other requests. Returns an error if the server speaks a
+// different version of the protocol, in which case the server will exit.
+func (c *Client) Hello() error {
+	if err := c.sendMessage(messages.Hello{Version: messages.ProtocolVersion}); err != nil {
+		return err
+	}
+	reply := <-c.helloReply
+	if reply.Version != messages.ProtocolVersion {
+		return fmt.Errorf("privsep protocol version mismatch: server has version %d (want %d); the privileged helper may be from a different build", reply.Version, messages.ProtocolVersion)
+	}
+	return nil
+}
+
 // NewConn creates a new ping connection.
-func (c *Client) NewConn(backendName backend.Name, ipVer util.IPVersion) (backend.Conn, error) {
-	err := c.sendMessage(messages.OpenConnection{
-		Backend: backendName,
-		IPVer:   ipVer,
-	})
+func (c *Client) NewConn(backendName backend.Name, ipVer util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+	src := backend.GetSource(opts)
+	if backend.IsFlood(opts) && c.passesSockets() && src.Netns == "" {
+		// Flood connections have the most to gain from skipping the server,
+		// and aren't rate limited by it anyway. They use raw sockets from
+		// OpenSocket, which are always in the server's own namespace.
+		return backend.NewLocal(backendName, ipVer, opts...)
+	}
+	open := messages.OpenConnection{
+		Backend:         backendName,
+		IPVer:           ipVer,
+		SourceInterface: src.Interface,
+		SourceAddr:      src.Addr,
+		SourceMark:      src.Mark,
+		SourceNetns:     src.Netns,
+		Flood:           backend.IsFlood(opts),
+		FlowLabel:       backend.GetFlowLabel(opts),
+		RecvBuffer:      backend.GetRecvBuffer(opts).Bytes,
+	}
+	reply, err := c.openConnection(false, open)
 	if err != nil {
 		return nil, err
 	}
-	reply := <-c.openConnReply
 	conn := &Connection{
 		client:  c,
 		id:      reply.ID,
 		backend: backendName,
-		// Buffered to prevent a "hold and wait" (possible deadlock) scenario,
-		// since the send occurs while mu is locked.
-		readFrom: make(chan messages.PingReply, 1),
-		closed:   make(chan error, 1),
+		open:    open,
+		// Buffered so that the demultiplexer never waits on a reader.
+		replyReady: make(chan struct{}, 1),
+		readErr:    make(chan error, 1),
+		writeErr:   make(chan error, 1),
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -72,64 +248,387 @@ func (c *Client) NewConn(backendName backend.Name, ipVer util.IPVersion) (backen
 	return conn, nil
 }
 
+// OpenSocket asks the server for a raw ICMP socket. This only works if the
+// server was started by root, and is connected by a socket rather than pipes.
+func (c *Client) OpenSocket(ipVer util.IPVersion) (*os.File, error) {
+	reply, err := request[messages.SocketReply](c, func(id messages.RequestID) messages.Message {
+		return messages.OpenSocket{Request: id, IPVer: ipVer}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reply.File == nil {
+		return nil, errors.New("privsep server didn't pass a socket")
+	}
+	return reply.File, nil
+}
+
+// Returns true if the server is connected by a socket that can pass files.
+func (c *Client) passesSockets() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.in.(*messages.SocketConn)
+	return ok
+}
+
+// Sends an OpenConnection request. Its request ID is filled in here.
+func (c *Client) openConnection(restart bool, msg messages.OpenConnection) (messages.OpenConnectionReply, error) {
+	return requestDuring[messages.OpenConnectionReply](c, restart, func(id messages.RequestID) messages.Message {
+		msg.Request = id
+		return msg
+	})
+}
+
+// SetRateLimit asks the server to tighten its rate limits on outgoing pings.
+// Zero limits leave the corresponding setting unchanged, so calling this with
+// zero values queries the current limits. The server won't loosen its limits
+// beyond their defaults. Returns the limits in effect.
+func (c *Client) SetRateLimit(perConn, global messages.RateLimit) (messages.RateLimitReply, error) {
+	return c.setRateLimit(false, perConn, global)
+}
+
+func (c *Client) setRateLimit(restart bool, perConn, global messages.RateLimit) (messages.RateLimitReply, error) {
+	reply, err := requestDuring[messages.RateLimitReply](c, restart, func(id messages.RequestID) messages.Message {
+		return messages.SetRateLimit{
+			Request:       id,
+			PerConnection: perConn,
+			Global:        global,
+		}
+	})
+	if err == nil && (perConn != messages.RateLimit{} || global != messages.RateLimit{}) {
+		c.mu.Lock()
+		c.limits = &reply
+		c.mu.Unlock()
+	}
+	return reply, err
+}
+
 // Shutdown sends a shutdown message to the server.
 func (c *Client) Shutdown() error {
 	return c.sendMessage(messages.Shutdown{})
 }
 
-// Sends a message.
+// Sends a message and waits for it to be written.
 func (c *Client) sendMessage(msg messages.Message) error {
+	c.mu.Lock()
+	done := c.enqueue(msg)
+	c.mu.Unlock()
+	return <-done
+}
+
+// Queues a message other than a ping for writing. Returns a channel that gets
+// the result. Must be called with mu held.
+func (c *Client) enqueue(msg messages.Message) chan error {
+	done := make(chan error, 1)
+	if c.closed {
+		done <- fmt.Errorf("error writing to server: %w", os.ErrClosed)
+		return done
+	}
+	c.queue = append(c.queue, outgoing{msg: msg, done: done})
+	c.queued.Signal()
+	return done
+}
+
+// Queues a ping on a connection. It's written asynchronously, and a failure is
+// returned by a later write on the connection. If too many pings are waiting,
+// the oldest is dropped, and is seen as a lost packet.
+func (c *Client) sendPing(conn *Connection, msg messages.SendPing) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if _, err := msg.WriteTo(c.out); err != nil {
-		return fmt.Errorf("error writing to server: %v", err)
+	if c.restarting {
+		return ErrRestarting
+	}
+	if c.closed {
+		return fmt.Errorf("error writing to server: %w", os.ErrClosed)
 	}
+	if c.queueStats.Depth >= c.queueLimit {
+		i := slices.IndexFunc(c.queue, func(o outgoing) bool { return o.conn != nil })
+		c.queue = slices.Delete(c.queue, i, i+1)
+		c.queueStats.Depth--
+		c.queueStats.Dropped++
+		// Only complain once per backlog.
+		if !c.complained {
+			logging.Warnf("Privsep server is falling behind; dropping oldest pings.")
+			c.complained = true
+		}
+	}
+	c.queue = append(c.queue, outgoing{msg: msg, conn: conn})
+	c.queueStats.Depth++
+	c.queueStats.MaxDepth = max(c.queueStats.MaxDepth, c.queueStats.Depth)
+	c.queueStats.Queued++
+	c.queued.Signal()
 	return nil
 }
 
+// ReplyStats returns statistics about the replies waiting to be read.
+func (c *Client) ReplyStats() ReplyStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.replyStats
+}
+
+// QueueStats returns statistics about the pings waiting to be written.
+func (c *Client) QueueStats() QueueStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queueStats
+}
+
+// Drops everything waiting to be written. Anyone waiting on a message gets
+// err. Must be called with mu held.
+func (c *Client) clearQueue(err error) {
+	for _, o := range c.queue {
+		if o.done != nil {
+			o.done <- err
+		}
+	}
+	c.queue = nil
+	c.queueStats.Depth = 0
+	c.complained = false
+}
+
+// Writes queued messages to the server in order until the client is closed.
+func (c *Client) writeLoop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		for len(c.queue) == 0 && !c.closed {
+			c.queued.Wait()
+		}
+		if c.closed {
+			c.clearQueue(fmt.Errorf("error writing to server: %w", os.ErrClosed))
+			return
+		}
+		var (
+			msg   messages.Message
+			done  chan error
+			conns []*Connection
+		)
+		if o := c.queue[0]; o.conn == nil {
+			msg, done = o.msg, o.done
+			c.queue = c.queue[1:]
+		} else if msg, conns = c.nextPings(); msg == nil {
+			continue
+		}
+		out := c.out
+		c.mu.Unlock()
+		_, err := msg.WriteTo(out)
+		c.mu.Lock()
+		if err != nil {
+			err = fmt.Errorf("error writing to server: %v", err)
+		}
+		if done != nil {
+			done <- err
+			continue
+		}
+		if err != nil {
+			for _, conn := range conns {
+				select {
+				case conn.writeErr <- err:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// Takes the pings at the front of the queue, and returns a message sending
+// them and the connections they're for. Pings that are waiting together, as
+// in a sweep, go out in one batch. The writer doesn't wait for more to
+// arrive, since that would add to the measured latency. Returns nil if every
+// ping was for a closed connection. Must be called with mu held.
+func (c *Client) nextPings() (messages.Message, []*Connection) {
+	var (
+		batch messages.SendPingBatch
+		conns []*Connection
+	)
+	for len(c.queue) > 0 && c.queue[0].conn != nil && len(batch.Pings) < messages.MaxBatchPings {
+		o := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queueStats.Depth--
+		if o.conn.closed {
+			continue
+		}
+		ping := o.msg.(messages.SendPing)
+		ping.ID = o.conn.id
+		batch.Pings = append(batch.Pings, ping)
+		conns = append(conns, o.conn)
+	}
+	if c.queueStats.Depth == 0 {
+		c.complained = false
+	}
+	switch len(batch.Pings) {
+	case 0:
+		return nil, nil
+	case 1:
+		return batch.Pings[0], conns
+	default:
+		c.queueStats.Batches++
+		return batch, conns
+	}
+}
+
+// Sends a request built by newMsg with a fresh request ID, and waits for the
+// reply with the same ID. Replies may arrive in any order. An [messages.Error]
+// reply is returned as the error.
+func request[T messages.Message](c *Client, newMsg func(messages.RequestID) messages.Message) (T, error) {
+	return requestDuring[T](c, false, newMsg)
+}
+
+// Like request, but fails with ErrRestarting during a restart unless restart
+// is true. Only Reconnect may send requests then.
+func requestDuring[T messages.Message](c *Client, restart bool, newMsg func(messages.RequestID) messages.Message) (T, error) {
+	var zero T
+	replyCh := make(chan messages.Message, 1)
+	c.mu.Lock()
+	if c.restarting && !restart {
+		c.mu.Unlock()
+		return zero, ErrRestarting
+	}
+	c.nextRequest++
+	if c.nextRequest == 0 {
+		c.nextRequest++ // Zero means a message isn't a reply.
+	}
+	id := c.nextRequest
+	c.pending[id] = replyCh
+	done := c.enqueue(newMsg(id))
+	c.mu.Unlock()
+	if err := <-done; err != nil {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return zero, err
+	}
+
+	msg, ok := <-replyCh
+	if !ok {
+		// The server exited before answering.
+		return zero, ErrRestarting
+	}
+	switch reply := msg.(type) {
+	case T:
+		return reply, nil
+	case messages.Error:
+		return zero, reply
+	default:
+		return zero, fmt.Errorf("unexpected reply from privsep server: %#v", reply)
+	}
+}
+
+// Delivers a reply to the request waiting for it. Returns false if no request
+// was waiting.
+func (c *Client) deliverReply(id messages.RequestID, msg messages.Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	replyCh, ok := c.pending[id]
+	if !ok {
+		logging.Warnf("Reply to unknown request %v: %#v", id, msg)
+		return false
+	}
+	delete(c.pending, id)
+	replyCh <- msg // Buffered, so this won't block.
+	return true
+}
+
+// Forgets a closed connection.
+func (c *Client) removeConnection(conn *Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn.closed = true
+	if c.connections[conn.id] == conn {
+		delete(c.connections, conn.id)
+	}
+}
+
 // Reads input from privsep server and sends it where it needs to go.
-func (c *Client) inputDemux() {
+func (c *Client) inputDemux(in io.Reader) {
+	sock, _ := in.(*messages.SocketConn)
+	r := bufio.NewReader(in)
 	for {
-		msg, err := messages.ReadMessage(c.inb)
+		msg, err := messages.ReadMessage(r)
 		if err != nil {
-			if !errors.Is(err, os.ErrClosed) {
-				log.Printf("Error reading from privsep server: %v", err)
+			if !errors.Is(err, os.ErrClosed) && !errors.Is(err, net.ErrClosed) {
+				logging.Errorf("Error reading from privsep server: %v", err)
 			}
 			return
 		}
 		switch msg := msg.(type) {
+		case messages.HelloReply:
+			c.helloReply <- msg
 		case messages.OpenConnectionReply:
-			c.openConnReply <- msg
+			c.deliverReply(msg.Request, msg)
 		case messages.CloseConnectionReply:
-			c.handleCloseConnectionReply(msg)
+			c.deliverReply(msg.Request, msg)
 		case messages.PingReply:
 			c.handlePingReply(msg)
+		case messages.RateLimitReply:
+			c.deliverReply(msg.Request, msg)
+		case messages.SocketReply:
+			if sock != nil {
+				msg.File = sock.TakeFile()
+			}
+			if !c.deliverReply(msg.Request, msg) && msg.File != nil {
+				msg.File.Close()
+			}
+		case messages.Error:
+			if msg.Request != 0 {
+				c.deliverReply(msg.Request, msg)
+			} else {
+				c.handleError(msg)
+			}
 		default:
-			log.Printf("Unknown message read from privsep server: %#v", msg)
+			logging.Warnf("Unknown message read from privsep server: %#v", msg)
 		}
 	}
 }
 
-func (c *Client) handleCloseConnectionReply(msg messages.CloseConnectionReply) {
+// Queues a reply on the connection it belongs to. This never waits for the
+// connection to read it, since every connection's replies come through here.
+// If too many replies are waiting, the oldest is dropped, and its ping is
+// seen as lost.
+func (c *Client) handlePingReply(msg messages.PingReply) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	conn, ok := c.connections[msg.ID]
 	if !ok {
-		log.Printf("Received close reply to already closed connection: %v", msg.ID)
+		logging.Debugf("Reply from unknown connection %v", msg.ID)
 		return
 	}
-	delete(c.connections, msg.ID)
-	conn.closed <- nil
-	conn.client = nil // Panic on future writes (reads will block infinitely)
+	depth, dropped := conn.pushReply(msg, c.replyLimit)
+	c.replyStats.Delivered++
+	c.replyStats.MaxDepth = max(c.replyStats.MaxDepth, depth)
+	if dropped {
+		c.replyStats.Dropped++
+	}
 }
 
-func (c *Client) handlePingReply(msg messages.PingReply) {
+// Delivers an error that doesn't answer a request to the connection it belongs
+// to. Since pings are sent asynchronously, send errors are returned by the next
+// write on the connection.
+func (c *Client) handleError(msg messages.Error) {
+	if msg.Code == messages.ErrorRateLimited {
+		// The ping was dropped. The caller sees it as a lost packet.
+		logging.Debugf("Ping on connection %v dropped: %v", msg.ID, msg.Text)
+		return
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	conn, ok := c.connections[msg.ID]
 	if !ok {
-		log.Printf("Reply from unknown connection %v", msg.ID)
+		logging.Warnf("Error from unknown connection %v: %v", msg.ID, msg)
 		return
 	}
-	conn.readFrom <- msg
+	switch msg.Code {
+	case messages.ErrorSendFailed:
+		// Keep only the first error if several arrive between writes.
+		select {
+		case conn.writeErr <- msg:
+		default:
+		}
+	case messages.ErrorReadFailed:
+		conn.readErr <- msg
+	default:
+		logging.Warnf("Unhandled error on connection %v: %v", msg.ID, msg)
+	}
 }
@@ -8,10 +8,13 @@ import (
 	"log"
 	"net"
 	"os"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/backend/test"
 	"github.com/pcekm/vasily/internal/privsep/messages"
@@ -53,7 +56,23 @@ func (s *fakeServer) Run() {
 			log.Printf("ReadMessage: %v", err)
 			return
 		}
+		if batch, ok := in.(messages.SendPingBatch); ok {
+			// Unpacked, like the real server does.
+			for _, ping := range batch.Pings {
+				s.handler(ping)
+			}
+			continue
+		}
 		out := s.handler(in)
+		if reply, ok := out.(messages.SocketReply); ok && reply.File != nil {
+			err := s.out.(*messages.SocketConn).WriteWithFile(reply, reply.File)
+			reply.File.Close()
+			if err != nil {
+				log.Printf("WriteWithFile: %v", err)
+				return
+			}
+			continue
+		}
 		if out != nil {
 			_, err := out.WriteTo(s.out)
 			if err != nil {
@@ -66,6 +85,12 @@ func (s *fakeServer) Run() {
 
 // Makes a connected client/server pair.
 func makeCSPair(t *testing.T, handler messageHandler) (*Client, *fakeServer) {
+	fromServer, toServer, server := makeServer(t, handler)
+	return New(fromServer, toServer), server
+}
+
+// Makes a server, and the pipes for a client to talk to it with.
+func makeServer(t *testing.T, handler messageHandler) (io.ReadCloser, io.WriteCloser, *fakeServer) {
 	fromClient, toServer, err := os.Pipe()
 	if err != nil {
 		t.Fatalf("Error creating pipe: %v", err)
@@ -79,22 +104,74 @@ func makeCSPair(t *testing.T, handler messageHandler) (*Client, *fakeServer) {
 	fromServer.SetDeadline(time.Now().Add(5 * time.Second))
 	toClient.SetDeadline(time.Now().Add(5 * time.Second))
 
-	client := New(fromServer, toServer)
-	server := newFakeServer(fromClient, toClient, handler)
-	return client, server
+	return fromServer, toServer, newFakeServer(fromClient, toClient, handler)
+}
+
+// Like makeCSPair, but connects the client and server with a socket pair.
+func makeSocketCSPair(t *testing.T, handler messageHandler) (*Client, *fakeServer) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("Socketpair error: %v", err)
+	}
+	var socks [2]*messages.SocketConn
+	for i, fd := range fds {
+		socks[i], err = messages.NewSocketConn(os.NewFile(uintptr(fd), "test"))
+		if err != nil {
+			t.Fatalf("NewSocketConn error: %v", err)
+		}
+		socks[i].SetDeadline(time.Now().Add(5 * time.Second))
+	}
+	return New(socks[0], socks[0]), newFakeServer(socks[1], socks[1], handler)
+}
+
+func TestHello(t *testing.T) {
+	cases := []struct {
+		Name          string
+		ServerVersion int
+		WantErr       bool
+	}{
+		{Name: "Match", ServerVersion: messages.ProtocolVersion},
+		{Name: "Mismatch", ServerVersion: messages.ProtocolVersion + 1, WantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var gotVersion int // Don't test until after client.Close() to avoid race.
+			handler := func(msg messages.Message) messages.Message {
+				switch msg := msg.(type) {
+				case messages.Hello:
+					gotVersion = msg.Version
+					return messages.HelloReply{Version: c.ServerVersion}
+				default:
+					return nil
+				}
+			}
+			client, server := makeCSPair(t, handler)
+			go server.Run()
+
+			if err := client.Hello(); (err != nil) != c.WantErr {
+				t.Errorf("Wrong error: %v (WantErr=%v)", err, c.WantErr)
+			}
+			if err := client.Close(); err != nil {
+				t.Errorf("Error closing client: %v", err)
+			}
+			if gotVersion != messages.ProtocolVersion {
+				t.Errorf("Wrong version sent: %d (want %d)", gotVersion, messages.ProtocolVersion)
+			}
+		})
+	}
 }
 
 func TestClientOpenClose(t *testing.T) {
 	handler := func(msg messages.Message) messages.Message {
 		switch msg := msg.(type) {
 		case messages.OpenConnection:
-			return messages.OpenConnectionReply{ID: 1234}
+			return messages.OpenConnectionReply{Request: msg.Request, ID: 1234}
 		case messages.CloseConnection:
 			if msg.ID != 1234 {
 				// Only reply to expected ID.
 				return nil
 			}
-			return messages.CloseConnectionReply{ID: msg.ID}
+			return messages.CloseConnectionReply{Request: msg.Request, ID: msg.ID}
 		default:
 			return nil
 		}
@@ -137,13 +214,13 @@ func TestReadFrom(t *testing.T) {
 	handler := func(msg messages.Message) messages.Message {
 		switch msg := msg.(type) {
 		case messages.OpenConnection:
-			return messages.OpenConnectionReply{ID: 1234}
+			return messages.OpenConnectionReply{Request: msg.Request, ID: 1234}
 		case messages.CloseConnection:
 			if msg.ID != 1234 {
 				// Only reply to expected ID.
 				return nil
 			}
-			return messages.CloseConnectionReply{ID: msg.ID}
+			return messages.CloseConnectionReply{Request: msg.Request, ID: msg.ID}
 		case messages.SendPing:
 			return sent
 		default:
@@ -186,13 +263,13 @@ func TestWriteTo(t *testing.T) {
 	handler := func(msg messages.Message) messages.Message {
 		switch msg := msg.(type) {
 		case messages.OpenConnection:
-			return messages.OpenConnectionReply{ID: 1234}
+			return messages.OpenConnectionReply{Request: msg.Request, ID: 1234}
 		case messages.CloseConnection:
 			if msg.ID != 1234 {
 				// Only reply to expected ID.
 				return nil
 			}
-			return messages.CloseConnectionReply{ID: msg.ID}
+			return messages.CloseConnectionReply{Request: msg.Request, ID: msg.ID}
 		case messages.SendPing:
 			gotMsg = msg
 			return nil
@@ -212,7 +289,7 @@ func TestWriteTo(t *testing.T) {
 		Seq:     2,
 		Payload: []byte("stuff"),
 	}
-	if err := conn.WriteTo(sent, test.LoopbackV4, backend.TTLOption{TTL: 5}); err != nil {
+	if err := conn.WriteTo(sent, test.LoopbackV4, backend.TTLOption{TTL: 5}, backend.DontFragmentOption{}, backend.StreamOption{Stream: 3}); err != nil {
 		t.Errorf("WriteTo error: %v", err)
 	}
 
@@ -224,12 +301,498 @@ func TestWriteTo(t *testing.T) {
 	}
 
 	want := messages.SendPing{
-		ID:     1234,
-		Packet: *sent,
-		Addr:   test.LoopbackV4.IP,
-		TTL:    5,
+		ID:           1234,
+		Packet:       *sent,
+		Addr:         test.LoopbackV4.IP,
+		TTL:          5,
+		DontFragment: true,
+		Stream:       3,
 	}
 	if diff := cmp.Diff(want, gotMsg); diff != "" {
 		t.Errorf("Wrong packet received by server (-want, +got):\n%v", diff)
 	}
 }
+
+func TestWriteTo_Backlog(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		gotSeqs []int
+	)
+	stalled := make(chan any)
+	release := make(chan any)
+	allSent := make(chan any)
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenConnection:
+			return messages.OpenConnectionReply{Request: msg.Request, ID: 1}
+		case messages.SendPing:
+			mu.Lock()
+			gotSeqs = append(gotSeqs, msg.Packet.Seq)
+			n := len(gotSeqs)
+			mu.Unlock()
+			switch n {
+			case 1:
+				// Stop reading, so the client's writes back up.
+				close(stalled)
+				<-release
+			case 6:
+				close(allSent)
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+	// An io.Pipe blocks writes until they're read, unlike an OS pipe.
+	fromClient, toServer := io.Pipe()
+	fromServer, toClient, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Error creating pipe: %v", err)
+	}
+	fromServer.SetDeadline(time.Now().Add(5 * time.Second))
+	toClient.SetDeadline(time.Now().Add(5 * time.Second))
+	server := newFakeServer(fromClient, toClient, handler)
+	go server.Run()
+	defer server.Close()
+	client := New(fromServer, toServer)
+	client.queueLimit = 4
+
+	conn, err := client.NewConn("foo", util.IPv4)
+	if err != nil {
+		t.Fatalf("NewConn error: %v", err)
+	}
+	write := func(seq int) {
+		if err := conn.WriteTo(&backend.Packet{Seq: seq}, test.LoopbackV4); err != nil {
+			t.Errorf("WriteTo(%d) error: %v", seq, err)
+		}
+	}
+	// The first ping stalls the server, and the second stalls the writer.
+	write(0)
+	<-stalled
+	write(1)
+	deadline := time.Now().Add(5 * time.Second)
+	for client.QueueStats().Depth != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for queue to drain.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for seq := 2; seq < 10; seq++ {
+		write(seq)
+	}
+
+	wantStats := QueueStats{Depth: 4, MaxDepth: 4, Queued: 10, Dropped: 4}
+	if diff := cmp.Diff(wantStats, client.QueueStats()); diff != "" {
+		t.Errorf("Wrong queue stats (-want, +got):\n%v", diff)
+	}
+
+	close(release)
+	select {
+	case <-allSent:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for pings.")
+	}
+	// The pings that waited together went out together.
+	if got := client.QueueStats().Batches; got != 1 {
+		t.Errorf("Sent %d batches (want 1)", got)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]int{0, 1, 6, 7, 8, 9}, gotSeqs); diff != "" {
+		t.Errorf("Wrong pings received by server (-want, +got):\n%v", diff)
+	}
+}
+
+func TestReadFrom_SlowConnection(t *testing.T) {
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	var server *fakeServer
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenConnection:
+			return messages.OpenConnectionReply{Request: msg.Request, ID: messages.ConnectionID(msg.Backend[0])}
+		case messages.SendPing:
+			// Flood connection a with replies, then answer b.
+			for seq := range 6 {
+				reply := messages.PingReply{ID: 'a', Packet: backend.Packet{Type: backend.PacketReply, Seq: seq}, Peer: msg.Addr}
+				if _, err := reply.WriteTo(server.out); err != nil {
+					t.Errorf("WriteTo error: %v", err)
+				}
+			}
+			return messages.PingReply{ID: 'b', Packet: backend.Packet{Type: backend.PacketReply, Seq: 0}, Peer: msg.Addr}
+		default:
+			return nil
+		}
+	}
+	client, server := makeCSPair(t, handler)
+	client.replyLimit = 4
+	go server.Run()
+
+	a, err := client.NewConn("a", util.IPv4)
+	if err != nil {
+		t.Fatalf("NewConn(a) error: %v", err)
+	}
+	b, err := client.NewConn("b", util.IPv4)
+	if err != nil {
+		t.Fatalf("NewConn(b) error: %v", err)
+	}
+	if err := b.WriteTo(&backend.Packet{}, test.LoopbackV4); err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+
+	// Connection a isn't reading, but b still gets its reply.
+	if _, _, err := b.ReadFrom(ctx); err != nil {
+		t.Fatalf("ReadFrom(b) error: %v", err)
+	}
+	var gotSeqs []int
+	for range 4 {
+		pkt, _, err := a.ReadFrom(ctx)
+		if err != nil {
+			t.Fatalf("ReadFrom(a) error: %v", err)
+		}
+		gotSeqs = append(gotSeqs, pkt.Seq)
+	}
+	if diff := cmp.Diff([]int{2, 3, 4, 5}, gotSeqs); diff != "" {
+		t.Errorf("Wrong replies on a (-want, +got):\n%v", diff)
+	}
+	if got := a.(*Connection).DroppedReplies(); got != 2 {
+		t.Errorf("DroppedReplies() = %d (want 2)", got)
+	}
+	wantStats := ReplyStats{MaxDepth: 4, Delivered: 7, Dropped: 2}
+	if diff := cmp.Diff(wantStats, client.ReplyStats()); diff != "" {
+		t.Errorf("Wrong reply stats (-want, +got):\n%v", diff)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+}
+
+func TestSetRateLimit(t *testing.T) {
+	want := messages.RateLimitReply{
+		PerConnection: messages.RateLimit{Interval: 2 * time.Second, Burst: 3},
+		Global:        messages.RateLimit{Interval: time.Millisecond, Burst: 100},
+	}
+	var gotMsg messages.SetRateLimit // Don't test until after client.Close() to avoid race.
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.SetRateLimit:
+			gotMsg = msg
+			reply := want
+			reply.Request = msg.Request
+			return reply
+		default:
+			return nil
+		}
+	}
+	client, server := makeCSPair(t, handler)
+	go server.Run()
+
+	perConn := messages.RateLimit{Interval: 2 * time.Second, Burst: 3}
+	got, err := client.SetRateLimit(perConn, messages.RateLimit{})
+	if err != nil {
+		t.Errorf("SetRateLimit error: %v", err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(messages.RateLimitReply{}, "Request")); diff != "" {
+		t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+	if diff := cmp.Diff(messages.SetRateLimit{Request: 1, PerConnection: perConn}, gotMsg); diff != "" {
+		t.Errorf("Wrong message sent (-want, +got):\n%v", diff)
+	}
+}
+
+func TestNewConn_Error(t *testing.T) {
+	want := messages.Error{Request: 1, Code: messages.ErrorOpenFailed, Text: "invalid backend"}
+	handler := func(msg messages.Message) messages.Message {
+		switch msg.(type) {
+		case messages.OpenConnection:
+			return want
+		default:
+			return nil
+		}
+	}
+	client, server := makeCSPair(t, handler)
+	go server.Run()
+
+	conn, err := client.NewConn("foo", util.IPv4)
+	if conn != nil {
+		t.Errorf("NewConn returned a connection: %#v", conn)
+	}
+	var got messages.Error
+	if !errors.As(err, &got) {
+		t.Fatalf("Wrong error: %v (want %v)", err, want)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong error (-want, +got):\n%v", diff)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+}
+
+func TestConnectionErrors(t *testing.T) {
+	sendErr := messages.Error{ID: 1234, Code: messages.ErrorSendFailed, Text: "network is unreachable"}
+	readErr := messages.Error{ID: 1234, Code: messages.ErrorReadFailed, Text: "bad read"}
+	closeErr := messages.Error{Request: 2, ID: 1234, Code: messages.ErrorCloseFailed, Text: "bad close"}
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenConnection:
+			return messages.OpenConnectionReply{Request: msg.Request, ID: 1234}
+		case messages.SendPing:
+			return sendErr
+		case messages.SetRateLimit:
+			return readErr
+		case messages.CloseConnection:
+			return closeErr
+		default:
+			return nil
+		}
+	}
+	client, server := makeCSPair(t, handler)
+	go server.Run()
+
+	conn, err := client.NewConn("foo", util.IPv4)
+	if err != nil {
+		t.Fatalf("NewConn error: %v", err)
+	}
+
+	if err := conn.WriteTo(&backend.Packet{}, test.LoopbackV4); err != nil {
+		t.Errorf("First WriteTo error: %v", err)
+	}
+	// The send error arrives asynchronously. Poll until it does.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := conn.WriteTo(&backend.Packet{}, test.LoopbackV4)
+		if err != nil {
+			if diff := cmp.Diff(sendErr, err); diff != "" {
+				t.Errorf("Wrong WriteTo error (-want, +got):\n%v", diff)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for WriteTo error.")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The fake server answers SetRateLimit with a read error. This doesn't
+	// happen in real life, but it's an easy way to trigger one.
+	if err := client.sendMessage(messages.SetRateLimit{}); err != nil {
+		t.Fatalf("Error sending message: %v", err)
+	}
+	ctx, done := context.WithTimeout(context.Background(), 5*time.Second)
+	defer done()
+	if _, _, err := conn.ReadFrom(ctx); cmp.Diff(readErr, err) != "" {
+		t.Errorf("Wrong ReadFrom error: %v (want %v)", err, readErr)
+	}
+
+	if err := conn.Close(); cmp.Diff(closeErr, err) != "" {
+		t.Errorf("Wrong Close error: %v (want %v)", err, closeErr)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+}
+
+func TestRepliesOutOfOrder(t *testing.T) {
+	var server *fakeServer
+	var first messages.OpenConnection
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenConnection:
+			// Hold on to the first request, and answer both in reverse order
+			// when the second arrives.
+			if first.Request == 0 {
+				first = msg
+				return nil
+			}
+			reply := messages.OpenConnectionReply{Request: msg.Request, ID: messages.ConnectionID(msg.Backend[0])}
+			if _, err := reply.WriteTo(server.out); err != nil {
+				t.Errorf("WriteTo error: %v", err)
+			}
+			return messages.OpenConnectionReply{Request: first.Request, ID: messages.ConnectionID(first.Backend[0])}
+		default:
+			return nil
+		}
+	}
+	client, server := makeCSPair(t, handler)
+	go server.Run()
+
+	results := make(chan *Connection)
+	for _, name := range []backend.Name{"a", "b"} {
+		go func() {
+			conn, err := client.NewConn(name, util.IPv4)
+			if err != nil {
+				t.Errorf("NewConn(%q) error: %v", name, err)
+				results <- nil
+				return
+			}
+			results <- conn.(*Connection)
+		}()
+	}
+	for range 2 {
+		conn := <-results
+		if conn == nil {
+			continue
+		}
+		if want := messages.ConnectionID(conn.Backend()[0]); conn.ID() != want {
+			t.Errorf("Connection for backend %q has ID %v (want %v)", conn.Backend(), conn.ID(), want)
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	stuckSent := make(chan any)
+	oldHandler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenConnection:
+			if msg.Backend == "stuck" {
+				// Never answered, since the server "exits".
+				close(stuckSent)
+				return nil
+			}
+			return messages.OpenConnectionReply{Request: msg.Request, ID: messages.ConnectionID(msg.Request) + 10}
+		default:
+			return nil
+		}
+	}
+	client, oldServer := makeCSPair(t, oldHandler)
+	go oldServer.Run()
+
+	var conns []*Connection
+	for _, name := range []backend.Name{"a", "b"} {
+		conn, err := client.NewConn(name, util.IPv4, backend.FlowLabelOption{Label: 7})
+		if err != nil {
+			t.Fatalf("NewConn(%q) error: %v", name, err)
+		}
+		conns = append(conns, conn.(*Connection))
+	}
+	stuck := make(chan error)
+	go func() {
+		_, err := client.NewConn("stuck", util.IPv4)
+		stuck <- err
+	}()
+
+	var (
+		mu        sync.Mutex
+		reopened  []messages.OpenConnection
+		gotPings  []messages.SendPing
+		nextID    messages.ConnectionID
+		pingsDone = make(chan any)
+	)
+	newHandler := func(msg messages.Message) messages.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		switch msg := msg.(type) {
+		case messages.Hello:
+			return messages.HelloReply{Version: messages.ProtocolVersion}
+		case messages.OpenConnection:
+			reopened = append(reopened, msg)
+			nextID++
+			return messages.OpenConnectionReply{Request: msg.Request, ID: nextID}
+		case messages.SendPing:
+			gotPings = append(gotPings, msg)
+			if len(gotPings) == len(conns) {
+				close(pingsDone)
+			}
+			return nil
+		default:
+			return nil
+		}
+	}
+	in, out, newServer := makeServer(t, newHandler)
+	go newServer.Run()
+
+	<-stuckSent
+	oldServer.Close()
+	if err := client.Reconnect(in, out); err != nil {
+		t.Fatalf("Reconnect error: %v", err)
+	}
+	if err := <-stuck; !errors.Is(err, ErrRestarting) {
+		t.Errorf("Pending request error: %v (want %v)", err, ErrRestarting)
+	}
+
+	for _, conn := range conns {
+		if err := conn.WriteTo(&backend.Packet{}, test.LoopbackV4); err != nil {
+			t.Errorf("WriteTo error: %v", err)
+		}
+	}
+	select {
+	case <-pingsDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for pings.")
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reopened) != len(conns) {
+		t.Fatalf("Reopened %d connections (want %d)", len(reopened), len(conns))
+	}
+	for _, msg := range reopened {
+		if msg.FlowLabel != 7 || msg.IPVer != util.IPv4 {
+			t.Errorf("Connection reopened with different options: %+v", msg)
+		}
+	}
+	gotIDs := make(map[messages.ConnectionID]bool)
+	for _, p := range gotPings {
+		gotIDs[p.ID] = true
+	}
+	if diff := cmp.Diff(map[messages.ConnectionID]bool{1: true, 2: true}, gotIDs); diff != "" {
+		t.Errorf("Wrong connection IDs in pings (-want, +got):\n%v", diff)
+	}
+}
+
+func TestOpenSocket(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe error: %v", err)
+	}
+	defer pr.Close()
+	gotMsg := make(chan messages.OpenSocket, 1)
+	handler := func(msg messages.Message) messages.Message {
+		switch msg := msg.(type) {
+		case messages.OpenSocket:
+			gotMsg <- msg
+			return messages.SocketReply{Request: msg.Request, File: pw}
+		default:
+			return nil
+		}
+	}
+	client, server := makeSocketCSPair(t, handler)
+	go server.Run()
+
+	f, err := client.OpenSocket(util.IPv6)
+	if err != nil {
+		t.Fatalf("OpenSocket error: %v", err)
+	}
+	if _, err := f.Write([]byte("x")); err != nil {
+		t.Errorf("Error writing to passed file: %v", err)
+	}
+	f.Close()
+	buf := make([]byte, 2)
+	if n, _ := pr.Read(buf); string(buf[:n]) != "x" {
+		t.Errorf("Read %q from pipe (want %q)", buf[:n], "x")
+	}
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Error closing client: %v", err)
+	}
+	if diff := cmp.Diff(messages.OpenSocket{Request: 1, IPVer: util.IPv6}, <-gotMsg); diff != "" {
+		t.Errorf("Wrong message sent (-want, +got):\n%v", diff)
+	}
+}
@@ -2,10 +2,14 @@ package client
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net"
+	"slices"
+	"sync"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
 	"github.com/pcekm/vasily/internal/privsep/messages"
 	"github.com/pcekm/vasily/internal/util"
 )
@@ -15,12 +19,24 @@ type Connection struct {
 	client   *Client
 	id       messages.ConnectionID
 	backend  backend.Name
-	readFrom chan messages.PingReply
-	closed   chan error
+	open     messages.OpenConnection // For reopening after a server restart.
+	closed   bool                    // Guarded by client.mu.
+	readErr  chan error              // The server stopped reading from the connection.
+	writeErr chan error              // An earlier send failed.
+
+	// Replies waiting to be read, oldest first. replyReady is signaled when
+	// one is added.
+	replyMu    sync.Mutex
+	replies    []messages.PingReply
+	replyReady chan struct{}
+	dropped    int  // Replies dropped because too many were waiting.
+	complained bool // Whether drops have been logged since the queue was empty.
 }
 
 // ID returns the connection ID. This is mostly for testing purposes.
 func (c *Connection) ID() messages.ConnectionID {
+	c.client.mu.Lock()
+	defer c.client.mu.Unlock()
 	return c.id
 }
 
@@ -29,10 +45,15 @@ func (c *Connection) Backend() backend.Name {
 	return c.backend
 }
 
-// WriteTo writes a ping message to a remote host.
+// WriteTo writes a ping message to a remote host. The server sends pings
+// asynchronously, so a failure is returned by the following call.
 func (c *Connection) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	select {
+	case err := <-c.writeErr:
+		return err
+	default:
+	}
 	msg := messages.SendPing{
-		ID:     c.id,
 		Packet: *pkt,
 		Addr:   util.IP(dest),
 	}
@@ -40,27 +61,88 @@ func (c *Connection) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend
 		switch o := o.(type) {
 		case backend.TTLOption:
 			msg.TTL = o.TTL
+		case backend.DontFragmentOption:
+			msg.DontFragment = true
+		case backend.StreamOption:
+			msg.Stream = o.Stream
 		default:
 			log.Panicf("Unhandled backend.WriteOption: %#v", o)
 		}
 	}
-	return c.client.sendMessage(msg)
+	return c.client.sendPing(c, msg)
 }
 
 // ReadFrom reads the next available ping reply.
 func (c *Connection) ReadFrom(ctx context.Context) (pkt *backend.Packet, peer net.Addr, err error) {
+	for {
+		if msg, ok := c.popReply(); ok {
+			return &msg.Packet, &net.UDPAddr{IP: msg.Peer}, nil
+		}
+		select {
+		case <-c.replyReady:
+		case err := <-c.readErr:
+			return nil, nil, err
+		case <-ctx.Done():
+			return nil, nil, backend.ErrTimeout
+		}
+	}
+}
+
+// DroppedReplies returns the number of replies that were dropped because the
+// connection didn't read them fast enough. Their pings look lost.
+func (c *Connection) DroppedReplies() int {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	return c.dropped
+}
+
+// Adds a reply to the queue, dropping the oldest if more than limit would be
+// waiting. Returns the number waiting, and whether one was dropped.
+func (c *Connection) pushReply(msg messages.PingReply, limit int) (depth int, dropped bool) {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	if len(c.replies) >= limit {
+		c.replies = slices.Delete(c.replies, 0, 1)
+		c.dropped++
+		dropped = true
+		// Only complain once per backlog.
+		if !c.complained {
+			logging.Warnf("Connection %v is falling behind on replies; dropping oldest.", c.id)
+			c.complained = true
+		}
+	}
+	c.replies = append(c.replies, msg)
 	select {
-	case msg := <-c.readFrom:
-		return &msg.Packet, &net.UDPAddr{IP: msg.Peer}, nil
-	case <-ctx.Done():
-		return nil, nil, backend.ErrTimeout
+	case c.replyReady <- struct{}{}:
+	default:
 	}
+	return len(c.replies), dropped
 }
 
-// Closes the connection.
+// Takes the oldest reply from the queue. Returns false if there isn't one.
+func (c *Connection) popReply() (messages.PingReply, bool) {
+	c.replyMu.Lock()
+	defer c.replyMu.Unlock()
+	if len(c.replies) == 0 {
+		c.complained = false
+		return messages.PingReply{}, false
+	}
+	msg := c.replies[0]
+	c.replies = slices.Delete(c.replies, 0, 1)
+	return msg, true
+}
+
+// Closes the connection. A connection closed while the server is restarting
+// is closed on the new server once it's reopened there.
 func (c *Connection) Close() error {
-	if err := c.client.sendMessage(messages.CloseConnection{ID: c.id}); err != nil {
-		return err
+	client := c.client
+	_, err := request[messages.CloseConnectionReply](client, func(id messages.RequestID) messages.Message {
+		return messages.CloseConnection{Request: id, ID: c.id}
+	})
+	if errors.Is(err, ErrRestarting) {
+		err = nil
 	}
-	return <-c.closed
+	client.removeConnection(c)
+	c.client = nil // Panic on future writes (reads will block infinitely)
+	return err
 }
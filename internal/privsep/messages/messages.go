@@ -15,13 +15,35 @@ import (
 	"log"
 	"math"
 	"net"
+	"os"
+	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/util"
 )
 
 const (
-	maxMessageLen = 2 + 255*(1+255)
+	maxMessageLen = 2 + math.MaxUint8*(2+math.MaxUint16)
+
+	// MaxPayloadLen is the longest packet payload that can be encoded. It's
+	// the maximum arg length minus the type, sequence number and payload
+	// length fields, and the longest trailer.
+	MaxPayloadLen = math.MaxUint16 - 5 - timestampsLen
+
+	// ProtocolVersion is the version of the protocol implemented by this
+	// package. It must be incremented whenever the encoding of any message
+	// changes.
+	ProtocolVersion = 19
+
+	// MaxBatchPings is the most pings a [SendPingBatch] can carry.
+	MaxBatchPings = math.MaxUint8 / sendPingArgs
+
+	// Number of args in an encoded SendPing.
+	sendPingArgs = 6
+
+	// Lengths of the packet trailers.
+	timestampsLen  = 12
+	addressMaskLen = 4
 )
 
 var (
@@ -93,6 +115,35 @@ const (
 
 	// msgPingReply is a reply message containing a ping reply.
 	msgPingReply
+
+	// msgHello is the first message sent by the client. It contains the
+	// client's protocol version.
+	msgHello
+
+	// msgHelloReply is the server's response to msgHello. It contains the
+	// server's protocol version.
+	msgHelloReply
+
+	// msgSetRateLimit is a request to query or tighten the server's rate
+	// limits.
+	msgSetRateLimit
+
+	// msgRateLimitReply is the server's response to msgSetRateLimit. It
+	// contains the limits in effect.
+	msgRateLimitReply
+
+	// msgError is a reply message reporting a failed request.
+	msgError
+
+	// msgOpenSocket is a request for a raw socket.
+	msgOpenSocket
+
+	// msgSocketReply is the reply to msgOpenSocket. The socket is passed
+	// along with it.
+	msgSocketReply
+
+	// msgSendPingBatch is a request message to send several pings.
+	msgSendPingBatch
 )
 
 func (t messageType) String() string {
@@ -113,6 +164,22 @@ func (t messageType) String() string {
 		return "msgSendPing"
 	case msgPingReply:
 		return "msgPingReply"
+	case msgHello:
+		return "msgHello"
+	case msgHelloReply:
+		return "msgHelloReply"
+	case msgSetRateLimit:
+		return "msgSetRateLimit"
+	case msgRateLimitReply:
+		return "msgRateLimitReply"
+	case msgError:
+		return "msgError"
+	case msgOpenSocket:
+		return "msgOpenSocket"
+	case msgSocketReply:
+		return "msgSocketReply"
+	case msgSendPingBatch:
+		return "msgSendPingBatch"
 	default:
 		return fmt.Sprintf("(unknown:%d)", t)
 	}
@@ -147,6 +214,22 @@ func ReadMessage(r io.ByteReader) (msg Message, err error) {
 		msg = raw.asSendPing()
 	case msgPingReply:
 		msg = raw.asPingReply()
+	case msgHello:
+		msg = raw.asHello()
+	case msgHelloReply:
+		msg = raw.asHelloReply()
+	case msgSetRateLimit:
+		msg = raw.asSetRateLimit()
+	case msgRateLimitReply:
+		msg = raw.asRateLimitReply()
+	case msgError:
+		msg = raw.asError()
+	case msgOpenSocket:
+		msg = raw.asOpenSocket()
+	case msgSocketReply:
+		msg = raw.asSocketReply()
+	case msgSendPingBatch:
+		msg = raw.asSendPingBatch()
 	default:
 		msg = raw
 	}
@@ -160,6 +243,15 @@ func (n ConnectionID) encode() []byte {
 	return encodeInt(int(n))
 }
 
+// RequestID identifies a request, so that the client can match replies to
+// outstanding requests. Replies carry the ID of the request they answer. Zero
+// means a message isn't part of a request/reply exchange.
+type RequestID uint32
+
+func (r RequestID) encode() []byte {
+	return encodeInt(int(r))
+}
+
 // RawMessage is a basic message.
 type RawMessage struct {
 	// Type is the type of message.
@@ -188,10 +280,15 @@ func readRawMessage(r io.ByteReader) (RawMessage, error) {
 
 	// Read args.
 	for range numArgs {
-		argLen, err := r.ReadByte()
+		hi, err := r.ReadByte()
+		if err != nil {
+			return RawMessage{}, err
+		}
+		lo, err := r.ReadByte()
 		if err != nil {
 			return RawMessage{}, err
 		}
+		argLen := uint16(hi)<<8 | uint16(lo)
 		arg := make([]byte, argLen)
 		for i := range argLen {
 			arg[i], err = r.ReadByte()
@@ -212,10 +309,10 @@ func (m RawMessage) WriteTo(w io.Writer) (int64, error) {
 	}
 	buf := []byte{byte(m.Type), byte(len(m.Args))}
 	for _, arg := range m.Args {
-		if len(arg) > math.MaxUint8 {
-			return 0, fmt.Errorf("arg too long (%d): %v", len(arg), arg)
+		if len(arg) > math.MaxUint16 {
+			return 0, fmt.Errorf("arg too long (%d)", len(arg))
 		}
-		buf = append(buf, byte(len(arg)))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(arg)))
 		buf = append(buf, arg...)
 	}
 	n, err := w.Write(buf)
@@ -265,6 +362,19 @@ func (m RawMessage) argByte(i int) byte {
 	return m.Args[i][0]
 }
 
+// Gets a bool arg at position i. Only 0 and 1 are valid.
+func (m RawMessage) argBool(i int) bool {
+	switch b := m.argByte(i); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		panicMsgf("arg %d is invalid bool: %d", i, b)
+		return false
+	}
+}
+
 // Gets a big-endian uint16 arg at position i.
 func (m RawMessage) argUint16(i int) uint16 {
 	m.checkArgLen(i, 2)
@@ -288,6 +398,37 @@ func (m RawMessage) argConnectionID(i int) ConnectionID {
 	return ConnectionID(m.argInt(i))
 }
 
+// Decodes a [RateLimit] from the two arguments starting at index i.
+func (m RawMessage) argRateLimit(i int) RateLimit {
+	return RateLimit{
+		Interval: time.Duration(m.argInt(i)) * time.Microsecond,
+		Burst:    m.argInt(i + 1),
+	}
+}
+
+// Decodes a request id at argument index i.
+func (m RawMessage) argRequestID(i int) RequestID {
+	return RequestID(m.argInt(i))
+}
+
+// Gets an IPv6 flow label arg at position i.
+func (m RawMessage) argFlowLabel(i int) uint32 {
+	label := m.argInt(i)
+	if label < 0 || label > backend.MaxFlowLabel {
+		panicMsgf("flow label out of range: %d", label)
+	}
+	return uint32(label)
+}
+
+// Gets a stream arg at position i. Must be less than [backend.MaxStreams].
+func (m RawMessage) argStream(i int) int {
+	stream := int(m.argByte(i))
+	if stream >= backend.MaxStreams {
+		panicMsgf("stream out of range: %d", stream)
+	}
+	return stream
+}
+
 // Gets an IPVersion arg at position i.
 func (m RawMessage) argIPVersion(i int) util.IPVersion {
 	return util.IPVersion(m.argByte(i))
@@ -302,15 +443,41 @@ func (m RawMessage) argIP(i int) net.IP {
 	return ip
 }
 
+// Gets an IP address arg at position i, which may be empty. Returns nil if it
+// is.
+func (m RawMessage) argOptionalIP(i int) net.IP {
+	m.checkArgExists(i)
+	if len(m.Args[i]) == 0 {
+		return nil
+	}
+	return m.argIP(i)
+}
+
+// Gets a time arg at position i, encoded as big-endian nanoseconds since the
+// Unix epoch. An empty arg is the zero time.
+func (m RawMessage) argOptionalTime(i int) time.Time {
+	m.checkArgExists(i)
+	if len(m.Args[i]) == 0 {
+		return time.Time{}
+	}
+	m.checkArgLen(i, 8)
+	return time.Unix(0, int64(binary.BigEndian.Uint64(m.Args[i])))
+}
+
 // Decodes a [backend.Packet] at index i.
 // Packets are encoded as:
 //
-//	<type><seq><payloadLen><payload>
+//	<type><seq><payloadLen><payload>[<trailer>]
 //
 //	<type>:       1 byte; maps to payload.PacketType
 //	<seq>:        2 bytes; unsigned, big endian sequence number
-//	<payloadLen>: 1 byte; length of payload
+//	<payloadLen>: 2 bytes; unsigned, big endian length of payload
 //	<payload>:    sequence of payloadLen bytes
+//	<trailer>:    depends on type:
+//	              timestamp packets: 3 * 4 byte big endian originate,
+//	                                 receive and transmit timestamps
+//	              address mask packets: 4 byte mask
+//	              others: nothing
 func (m RawMessage) decodePacket(i int) backend.Packet {
 	m.checkArgExists(i)
 	buf := bytes.NewBuffer(m.Args[i])
@@ -322,8 +489,8 @@ func (m RawMessage) decodePacket(i int) backend.Packet {
 	if err := binary.Read(buf, binary.BigEndian, &seq); err != nil {
 		panicMsgf("error reading sequence number: %#v", err)
 	}
-	plen, err := buf.ReadByte()
-	if err != nil {
+	var plen uint16
+	if err := binary.Read(buf, binary.BigEndian, &plen); err != nil {
 		panicMsgf("error reading payload len: %v", err)
 	}
 	payload := make([]byte, plen)
@@ -334,14 +501,26 @@ func (m RawMessage) decodePacket(i int) backend.Packet {
 	if n != int(plen) {
 		panicMsgf("short payload: %d bytes (want %d)", n, plen)
 	}
-	if buf.Len() != 0 {
-		panicMsgf("unused %d extra bytes at end of payload", buf.Len())
-	}
-	return backend.Packet{
+	pkt := backend.Packet{
 		Type:    backend.PacketType(tp),
 		Seq:     int(seq),
 		Payload: payload,
 	}
+	switch pkt.Type {
+	case backend.PacketTimestampRequest, backend.PacketTimestampReply:
+		if err := binary.Read(buf, binary.BigEndian, &pkt.Timestamps); err != nil {
+			panicMsgf("error reading timestamps: %v", err)
+		}
+	case backend.PacketAddressMaskRequest, backend.PacketAddressMaskReply:
+		pkt.AddressMask = make(net.IPMask, addressMaskLen)
+		if n, _ := buf.Read(pkt.AddressMask); n != addressMaskLen {
+			panicMsgf("short address mask: %d bytes (want %d)", n, addressMaskLen)
+		}
+	}
+	if buf.Len() != 0 {
+		panicMsgf("unused %d extra bytes at end of packet", buf.Len())
+	}
+	return pkt
 }
 
 // Encodes a packet. Silently truncates a payload that's too long.
@@ -352,14 +531,125 @@ func encodePacket(pkt backend.Packet) []byte {
 	buf.WriteByte(byte(pkt.Type))
 	binary.Write(&buf, binary.BigEndian, uint16(pkt.Seq))
 	payload := pkt.Payload
-	if len(payload) > math.MaxUint8 {
-		payload = payload[:math.MaxUint8]
+	if len(payload) > MaxPayloadLen {
+		payload = payload[:MaxPayloadLen]
 	}
-	buf.WriteByte(byte(len(payload)))
+	binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
 	buf.Write(payload)
+	switch pkt.Type {
+	case backend.PacketTimestampRequest, backend.PacketTimestampReply:
+		binary.Write(&buf, binary.BigEndian, pkt.Timestamps)
+	case backend.PacketAddressMaskRequest, backend.PacketAddressMaskReply:
+		mask := make([]byte, addressMaskLen)
+		copy(mask, pkt.AddressMask)
+		buf.Write(mask)
+	}
 	return buf.Bytes()
 }
 
+// Decodes the [backend.Extensions] of an ICMP error at index i. An empty arg
+// means there were none. Extensions are encoded as:
+//
+//	<nLabels><label>*<nInterfaces><interface>*
+//
+//	<nLabels>:     1 byte; number of MPLS labels
+//	<label>:       4 bytes; an MPLS label stack entry as on the wire
+//	<nInterfaces>: 1 byte; number of interfaces
+//	<interface>:   <role><index><addrLen><addr><nameLen><name><mtu>
+//	<role>:        1 byte; maps to backend.InterfaceRole
+//	<index>:       4 bytes; big endian ifIndex
+//	<addrLen>:     1 byte; 0, 4 or 16
+//	<addr>:        addrLen bytes; IP address
+//	<nameLen>:     1 byte; length of name
+//	<name>:        nameLen bytes; interface name
+//	<mtu>:         4 bytes; big endian MTU
+func (m RawMessage) argExtensions(i int) *backend.Extensions {
+	m.checkArgExists(i)
+	if len(m.Args[i]) == 0 {
+		return nil
+	}
+	buf := bytes.NewBuffer(m.Args[i])
+	next := func(n int, what string) []byte {
+		b := buf.Next(n)
+		if len(b) != n {
+			panicMsgf("short extensions reading %s", what)
+		}
+		return b
+	}
+	var ext backend.Extensions
+	for range next(1, "label count")[0] {
+		lse := binary.BigEndian.Uint32(next(4, "label"))
+		ext.MPLSLabels = append(ext.MPLSLabels, backend.MPLSLabel{
+			Label: int(lse >> 12),
+			TC:    int(lse >> 9 & 0x7),
+			S:     lse&0x100 != 0,
+			TTL:   int(lse & 0xff),
+		})
+	}
+	for range next(1, "interface count")[0] {
+		info := backend.InterfaceInfo{
+			Role:  backend.InterfaceRole(next(1, "role")[0]),
+			Index: int(binary.BigEndian.Uint32(next(4, "index"))),
+		}
+		switch n := int(next(1, "address length")[0]); n {
+		case 0:
+		case net.IPv4len, net.IPv6len:
+			info.Addr = net.IP(bytes.Clone(next(n, "address")))
+		default:
+			panicMsgf("wrong IP length: %d", n)
+		}
+		info.Name = string(next(int(next(1, "name length")[0]), "name"))
+		info.MTU = int(binary.BigEndian.Uint32(next(4, "MTU")))
+		ext.Interfaces = append(ext.Interfaces, info)
+	}
+	if buf.Len() != 0 {
+		panicMsgf("unused %d extra bytes at end of extensions", buf.Len())
+	}
+	return &ext
+}
+
+// Encodes extensions. Silently drops any beyond what the encoding can hold.
+func encodeExtensions(ext *backend.Extensions) []byte {
+	if ext == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	labels := ext.MPLSLabels[:min(len(ext.MPLSLabels), math.MaxUint8)]
+	buf.WriteByte(byte(len(labels)))
+	for _, l := range labels {
+		lse := uint32(l.Label)<<12 | uint32(l.TC&0x7)<<9 | uint32(l.TTL&0xff)
+		if l.S {
+			lse |= 0x100
+		}
+		binary.Write(&buf, binary.BigEndian, lse)
+	}
+	ifaces := ext.Interfaces[:min(len(ext.Interfaces), math.MaxUint8)]
+	buf.WriteByte(byte(len(ifaces)))
+	for _, info := range ifaces {
+		buf.WriteByte(byte(info.Role))
+		binary.Write(&buf, binary.BigEndian, uint32(info.Index))
+		addr := info.Addr
+		if ip4 := addr.To4(); ip4 != nil {
+			addr = ip4
+		}
+		buf.WriteByte(byte(len(addr)))
+		buf.Write(addr)
+		name := info.Name[:min(len(info.Name), math.MaxUint8)]
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+		binary.Write(&buf, binary.BigEndian, uint32(info.MTU))
+	}
+	return buf.Bytes()
+}
+
+// Encodes a bool as a single byte.
+func encodeBool(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
 // Encodes a 32-bit signed int in big-endian order.
 func encodeInt(n int) []byte {
 	return []byte{
@@ -370,6 +660,14 @@ func encodeInt(n int) []byte {
 	}
 }
 
+// Encodes a time as for argOptionalTime.
+func encodeOptionalTime(t time.Time) []byte {
+	if t.IsZero() {
+		return nil
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
 // Shutdown is a message sent to the server telling it to exit.
 type Shutdown struct{}
 
@@ -402,8 +700,36 @@ func (m RawMessage) asPrivilegeDrop() (msg PrivilegeDrop) {
 
 // OpenConnection is a message to open a new ICMP connection.
 type OpenConnection struct {
+	// Request identifies the request. It's copied into the reply.
+	Request RequestID
+
 	Backend backend.Name
 	IPVer   util.IPVersion
+
+	// SourceInterface is the interface to bind to. Empty for any.
+	SourceInterface string
+
+	// SourceAddr is the source address to bind to. Nil for any.
+	SourceAddr net.IP
+
+	// Flood removes the rate limits on the connection. The server only
+	// allows this if it was started by root.
+	Flood bool
+
+	// FlowLabel is the IPv6 flow label for sent packets. Zero for none.
+	FlowLabel uint32
+
+	// SourceMark is the firewall mark to set on the connection's sockets.
+	// Zero for none.
+	SourceMark uint32
+
+	// SourceNetns is the named network namespace to open the connection in.
+	// Empty for the server's own.
+	SourceNetns string
+
+	// RecvBuffer is the size of the socket receive buffer in bytes. Zero for
+	// the system default.
+	RecvBuffer int
 }
 
 func (c OpenConnection) WriteTo(w io.Writer) (int64, error) {
@@ -412,6 +738,14 @@ func (c OpenConnection) WriteTo(w io.Writer) (int64, error) {
 		Args: [][]byte{
 			[]byte(c.Backend),
 			{byte(c.IPVer)},
+			[]byte(c.SourceInterface),
+			[]byte(c.SourceAddr),
+			c.Request.encode(),
+			encodeBool(c.Flood),
+			encodeInt(int(c.FlowLabel)),
+			encodeInt(int(c.SourceMark)),
+			[]byte(c.SourceNetns),
+			encodeInt(c.RecvBuffer),
 		},
 	}
 	return raw.WriteTo(w)
@@ -419,14 +753,26 @@ func (c OpenConnection) WriteTo(w io.Writer) (int64, error) {
 
 func (m RawMessage) asOpenConnection() OpenConnection {
 	m.checkType(msgOpenConnection)
+	m.checkNArgs(10)
 	return OpenConnection{
-		Backend: backend.Name(m.argString(0)),
-		IPVer:   m.argIPVersion(1),
+		Backend:         backend.Name(m.argString(0)),
+		IPVer:           m.argIPVersion(1),
+		SourceInterface: m.argString(2),
+		SourceAddr:      m.argOptionalIP(3),
+		Request:         m.argRequestID(4),
+		Flood:           m.argBool(5),
+		FlowLabel:       m.argFlowLabel(6),
+		SourceMark:      uint32(m.argInt(7)),
+		SourceNetns:     m.argString(8),
+		RecvBuffer:      m.argInt(9),
 	}
 }
 
 // OpenConnectionReply is a message to open a new ICMP connection.
 type OpenConnectionReply struct {
+	// Request is the ID of the request being answered.
+	Request RequestID
+
 	// ID holds the identifier for the opened connection.
 	ID ConnectionID
 }
@@ -434,20 +780,24 @@ type OpenConnectionReply struct {
 func (o OpenConnectionReply) WriteTo(w io.Writer) (int64, error) {
 	raw := RawMessage{
 		Type: msgOpenConnectionReply,
-		Args: [][]byte{o.ID.encode()},
+		Args: [][]byte{o.ID.encode(), o.Request.encode()},
 	}
 	return raw.WriteTo(w)
 }
 
 func (m RawMessage) asOpenConnectionReply() (msg OpenConnectionReply) {
 	m.checkType(msgOpenConnectionReply)
-	m.checkNArgs(1)
+	m.checkNArgs(2)
 	msg.ID = m.argConnectionID(0)
+	msg.Request = m.argRequestID(1)
 	return msg
 }
 
 // CloseConnection is a message to close an existing ICMP connection.
 type CloseConnection struct {
+	// Request identifies the request. It's copied into the reply.
+	Request RequestID
+
 	// ID holds the identifier of the connection to close.
 	ID ConnectionID
 }
@@ -455,34 +805,40 @@ type CloseConnection struct {
 func (c CloseConnection) WriteTo(w io.Writer) (int64, error) {
 	raw := RawMessage{
 		Type: msgCloseConnection,
-		Args: [][]byte{c.ID.encode()},
+		Args: [][]byte{c.ID.encode(), c.Request.encode()},
 	}
 	return raw.WriteTo(w)
 }
 
 func (m RawMessage) asCloseConnection() (msg CloseConnection) {
 	m.checkType(msgCloseConnection)
-	m.checkNArgs(1)
+	m.checkNArgs(2)
 	msg.ID = m.argConnectionID(0)
+	msg.Request = m.argRequestID(1)
 	return msg
 }
 
 // CloseConnectionReply is a response to a close message request.
 type CloseConnectionReply struct {
+	// Request is the ID of the request being answered.
+	Request RequestID
+
 	ID ConnectionID
 }
 
 func (c CloseConnectionReply) WriteTo(w io.Writer) (int64, error) {
 	raw := RawMessage{
 		Type: msgCloseConnectionReply,
-		Args: [][]byte{c.ID.encode()},
+		Args: [][]byte{c.ID.encode(), c.Request.encode()},
 	}
 	return raw.WriteTo(w)
 }
 
 func (m RawMessage) asCloseConnectionReply() (msg CloseConnectionReply) {
 	m.checkType(msgCloseConnectionReply)
+	m.checkNArgs(2)
 	msg.ID = m.argConnectionID(0)
+	msg.Request = m.argRequestID(1)
 	return msg
 }
 
@@ -491,8 +847,8 @@ type SendPing struct {
 	// ID holds the identifier of the connection to send the message over.
 	ID ConnectionID
 
-	// Packet is the ping message to send. The message type _must_ be
-	// PacketRequest.
+	// Packet is the ping message to send. The message type _must_ be a
+	// request.
 	Packet backend.Packet
 
 	// Addr is the address to ping.
@@ -501,30 +857,79 @@ type SendPing struct {
 	// TTL is the time to live for the outgoing packet. Zero means use the
 	// default.
 	TTL int
+
+	// DontFragment sends the packet with fragmentation disabled.
+	DontFragment bool
+
+	// Stream is the user of a shared connection the packet is sent for. Each
+	// stream is rate limited separately. See [backend.StreamOption].
+	Stream int
 }
 
 func (s SendPing) WriteTo(w io.Writer) (int64, error) {
 	raw := RawMessage{
 		Type: msgSendPing,
-		Args: [][]byte{
-			s.ID.encode(),
-			encodePacket(s.Packet),
-			[]byte(s.Addr),
-			encodeInt(s.TTL),
-		},
+		Args: s.encode(),
 	}
 	return raw.WriteTo(w)
 }
 
+func (s SendPing) encode() [][]byte {
+	return [][]byte{
+		s.ID.encode(),
+		encodePacket(s.Packet),
+		[]byte(s.Addr),
+		encodeInt(s.TTL),
+		encodeBool(s.DontFragment),
+		{byte(s.Stream)},
+	}
+}
+
 func (m RawMessage) asSendPing() SendPing {
 	m.checkType(msgSendPing)
-	m.checkNArgs(4)
+	m.checkNArgs(sendPingArgs)
+	return m.sendPingAt(0)
+}
+
+// Decodes a SendPing whose args start at i.
+func (m RawMessage) sendPingAt(i int) SendPing {
 	return SendPing{
-		ID:     m.argConnectionID(0),
-		Packet: m.decodePacket(1),
-		Addr:   m.argIP(2),
-		TTL:    m.argInt(3),
+		ID:           m.argConnectionID(i),
+		Packet:       m.decodePacket(i + 1),
+		Addr:         m.argIP(i + 2),
+		TTL:          m.argInt(i + 3),
+		DontFragment: m.argBool(i + 4),
+		Stream:       m.argStream(i + 5),
+	}
+}
+
+// SendPingBatch is a message to send several pings at once, which saves
+// writes to the server when many are sent together. The server handles each
+// as though it came in its own SendPing. It carries at most [MaxBatchPings].
+type SendPingBatch struct {
+	Pings []SendPing
+}
+
+func (b SendPingBatch) WriteTo(w io.Writer) (int64, error) {
+	if len(b.Pings) > MaxBatchPings {
+		return 0, fmt.Errorf("too many pings in batch: %d (max %d)", len(b.Pings), MaxBatchPings)
+	}
+	raw := RawMessage{Type: msgSendPingBatch}
+	for _, p := range b.Pings {
+		raw.Args = append(raw.Args, p.encode()...)
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asSendPingBatch() (msg SendPingBatch) {
+	m.checkType(msgSendPingBatch)
+	if len(m.Args) == 0 || len(m.Args)%sendPingArgs != 0 {
+		panicMsgf("unexpected argument count: %d (want a nonzero multiple of %d)", len(m.Args), sendPingArgs)
 	}
+	for i := 0; i < len(m.Args); i += sendPingArgs {
+		msg.Pings = append(msg.Pings, m.sendPingAt(i))
+	}
+	return msg
 }
 
 // PingReply is a message with the response to a ping.
@@ -533,7 +938,8 @@ type PingReply struct {
 	// ID holds the identifier of the connection that received the message.
 	ID ConnectionID
 
-	// Packet is the ping message received.
+	// Packet is the ping message received, including the TTL it arrived
+	// with, the time it was read and any ICMP extensions.
 	Packet backend.Packet
 
 	// Peer is the host the packet was received from.
@@ -547,16 +953,308 @@ func (p PingReply) WriteTo(w io.Writer) (int64, error) {
 			p.ID.encode(),
 			encodePacket(p.Packet),
 			[]byte(p.Peer),
+			encodeInt(p.Packet.TTL),
+			encodeExtensions(p.Packet.Extensions),
+			encodeOptionalTime(p.Packet.RecvTime),
 		},
 	}
 	return raw.WriteTo(w)
 }
 func (m RawMessage) asPingReply() PingReply {
 	m.checkType(msgPingReply)
-	m.checkNArgs(3)
-	return PingReply{
+	m.checkNArgs(6)
+	reply := PingReply{
 		ID:     m.argConnectionID(0),
 		Packet: m.decodePacket(1),
 		Peer:   m.argIP(2),
 	}
+	reply.Packet.TTL = m.argInt(3)
+	reply.Packet.Extensions = m.argExtensions(4)
+	reply.Packet.RecvTime = m.argOptionalTime(5)
+	return reply
+}
+
+// Hello is the first message sent by the client. The server will refuse to
+// continue if the protocol versions don't match.
+type Hello struct {
+	// Version is the client's protocol version.
+	Version int
+}
+
+func (h Hello) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgHello,
+		Args: [][]byte{encodeInt(h.Version)},
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asHello() (msg Hello) {
+	m.checkType(msgHello)
+	m.checkNArgs(1)
+	msg.Version = m.argInt(0)
+	return msg
+}
+
+// HelloReply is the server's response to a [Hello] message. It's sent even when
+// the versions don't match, so that the client can report the problem.
+type HelloReply struct {
+	// Version is the server's protocol version.
+	Version int
+}
+
+func (h HelloReply) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgHelloReply,
+		Args: [][]byte{encodeInt(h.Version)},
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asHelloReply() (msg HelloReply) {
+	m.checkType(msgHelloReply)
+	m.checkNArgs(1)
+	msg.Version = m.argInt(0)
+	return msg
+}
+
+// RateLimit is a token bucket rate limit. It allows one packet per Interval on
+// average, with bursts of up to Burst packets. The zero value means no limit
+// was specified.
+type RateLimit struct {
+	// Interval is the average time between packets. It's encoded with
+	// microsecond precision.
+	Interval time.Duration
+
+	// Burst is the largest number of packets that may be sent at once.
+	Burst int
+}
+
+// IsZero returns true if neither field of the limit is set.
+func (r RateLimit) IsZero() bool {
+	return r.Interval == 0 && r.Burst == 0
+}
+
+func (r RateLimit) String() string {
+	return fmt.Sprintf("1/%v (burst %d)", r.Interval, r.Burst)
+}
+
+// Encodes a rate limit as two args: the interval in microseconds and the burst.
+func (r RateLimit) encode() [][]byte {
+	return [][]byte{
+		encodeInt(int(r.Interval / time.Microsecond)),
+		encodeInt(r.Burst),
+	}
+}
+
+// SetRateLimit is a request to query or change the server's rate limits. Zero
+// fields leave the corresponding setting unchanged, so an empty message is a
+// query. The server only allows the limits to be tightened; requests to loosen
+// them beyond its defaults are clamped.
+type SetRateLimit struct {
+	// Request identifies the request. It's copied into the reply.
+	Request RequestID
+
+	// PerConnection is the limit applied to each connection separately.
+	PerConnection RateLimit
+
+	// Global is the limit applied to all connections together.
+	Global RateLimit
+}
+
+func (s SetRateLimit) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgSetRateLimit,
+		Args: append(append(s.PerConnection.encode(), s.Global.encode()...), s.Request.encode()),
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asSetRateLimit() SetRateLimit {
+	m.checkType(msgSetRateLimit)
+	m.checkNArgs(5)
+	return SetRateLimit{
+		PerConnection: m.argRateLimit(0),
+		Global:        m.argRateLimit(2),
+		Request:       m.argRequestID(4),
+	}
+}
+
+// RateLimitReply is the server's response to a [SetRateLimit] message. It
+// holds the limits in effect after the request was applied.
+type RateLimitReply struct {
+	// Request is the ID of the request being answered.
+	Request RequestID
+
+	// PerConnection is the limit applied to each connection separately.
+	PerConnection RateLimit
+
+	// Global is the limit applied to all connections together.
+	Global RateLimit
+}
+
+func (r RateLimitReply) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgRateLimitReply,
+		Args: append(append(r.PerConnection.encode(), r.Global.encode()...), r.Request.encode()),
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asRateLimitReply() RateLimitReply {
+	m.checkType(msgRateLimitReply)
+	m.checkNArgs(5)
+	return RateLimitReply{
+		PerConnection: m.argRateLimit(0),
+		Global:        m.argRateLimit(2),
+		Request:       m.argRequestID(4),
+	}
+}
+
+// ErrorCode identifies the kind of failure reported in an [Error] message.
+type ErrorCode byte
+
+// Error codes.
+const (
+	// ErrorUnknown is an unspecified error.
+	ErrorUnknown ErrorCode = iota
+
+	// ErrorRateLimited means a ping was dropped because it exceeded a rate
+	// limit.
+	ErrorRateLimited
+
+	// ErrorOpenFailed means an [OpenConnection] or [OpenSocket] request
+	// failed.
+	ErrorOpenFailed
+
+	// ErrorCloseFailed means a [CloseConnection] request failed.
+	ErrorCloseFailed
+
+	// ErrorSendFailed means a [SendPing] request failed.
+	ErrorSendFailed
+
+	// ErrorReadFailed means reading from a connection failed. No more
+	// replies will arrive on it.
+	ErrorReadFailed
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case ErrorUnknown:
+		return "unknown error"
+	case ErrorRateLimited:
+		return "rate limited"
+	case ErrorOpenFailed:
+		return "open failed"
+	case ErrorCloseFailed:
+		return "close failed"
+	case ErrorSendFailed:
+		return "send failed"
+	case ErrorReadFailed:
+		return "read failed"
+	default:
+		return fmt.Sprintf("error code %d", c)
+	}
+}
+
+// Error is sent by the server when it refuses or fails to carry out a request.
+// It also implements the error interface, so the client can return it
+// directly.
+type Error struct {
+	// Request is the ID of the failed request. It's zero for errors that
+	// don't answer a request, such as failures of asynchronous sends and
+	// reads.
+	Request RequestID
+
+	// ID is the connection the failed request was made on. It's unused for
+	// [ErrorOpenFailed], since there's no connection yet.
+	ID ConnectionID
+
+	// Code identifies the kind of error.
+	Code ErrorCode
+
+	// Text is a human-readable description of the error.
+	Text string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("privsep server: %v: %s", e.Code, e.Text)
+}
+
+func (e Error) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgError,
+		Args: [][]byte{
+			e.ID.encode(),
+			{byte(e.Code)},
+			[]byte(e.Text),
+			e.Request.encode(),
+		},
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asError() Error {
+	m.checkType(msgError)
+	m.checkNArgs(4)
+	return Error{
+		ID:      m.argConnectionID(0),
+		Code:    ErrorCode(m.argByte(1)),
+		Text:    m.argString(2),
+		Request: m.argRequestID(3),
+	}
+}
+
+// OpenSocket is a request for a raw ICMP socket. The server opens it and passes
+// it to the client, which can then send and receive without going through the
+// server. It's only possible over a [SocketConn].
+type OpenSocket struct {
+	// Request identifies the request. It's copied into the reply.
+	Request RequestID
+
+	IPVer util.IPVersion
+}
+
+func (o OpenSocket) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgOpenSocket,
+		Args: [][]byte{o.Request.encode(), {byte(o.IPVer)}},
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asOpenSocket() OpenSocket {
+	m.checkType(msgOpenSocket)
+	m.checkNArgs(2)
+	return OpenSocket{
+		Request: m.argRequestID(0),
+		IPVer:   m.argIPVersion(1),
+	}
+}
+
+// SocketReply is the server's response to an [OpenSocket] message. The socket
+// itself isn't part of the encoding. It's passed alongside with
+// [SocketConn.WriteWithFile], and the receiver fills in File with
+// [SocketConn.TakeFile].
+type SocketReply struct {
+	// Request is the ID of the request being answered.
+	Request RequestID
+
+	// File is the socket.
+	File *os.File
+}
+
+func (s SocketReply) WriteTo(w io.Writer) (int64, error) {
+	raw := RawMessage{
+		Type: msgSocketReply,
+		Args: [][]byte{s.Request.encode()},
+	}
+	return raw.WriteTo(w)
+}
+
+func (m RawMessage) asSocketReply() (msg SocketReply) {
+	m.checkType(msgSocketReply)
+	m.checkNArgs(1)
+	msg.Request = m.argRequestID(0)
+	return msg
 }
@@ -3,30 +3,36 @@ package messages
 import (
 	"bytes"
 	"log"
+	"math"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/util"
 )
 
-// Makes a raw message that is as long as it can possibly be. (About 64k).
+// Makes a raw message at the limits of the encoding: the maximum number of
+// args, with the last one as long as an arg can be. (About 64k.) A message with
+// every arg at maximum length would be about 16M, which is too slow to compare.
 func makeEncodedMaximalMessage() []byte {
 	msg := []byte{254, 255}
-	for range 255 {
-		msg = append(msg, 255)
-		msg = append(msg, bytes.Repeat([]byte{0}, 255)...)
+	for range 254 {
+		msg = append(msg, 0, 0)
 	}
+	msg = append(msg, 255, 255)
+	msg = append(msg, bytes.Repeat([]byte{0}, math.MaxUint16)...)
 	return msg
 }
 
 // Makes a parsed message that should match makeEncodedMaximalMessage.
 func makeDecodedMaximalMessage() RawMessage {
 	msg := RawMessage{Type: 254}
-	for range 255 {
-		msg.Args = append(msg.Args, bytes.Repeat([]byte{0}, 255))
+	for range 254 {
+		msg.Args = append(msg.Args, []byte{})
 	}
+	msg.Args = append(msg.Args, bytes.Repeat([]byte{0}, math.MaxUint16))
 	return msg
 }
 
@@ -48,15 +54,86 @@ func TestReadMessage(t *testing.T) {
 		{Name: "Empty", Encoded: []byte{}, WantErr: true},
 		{Name: "MissingArgCount", Encoded: []byte{1}, WantErr: true},
 		{Name: "MissingArgLen", Encoded: []byte{1, 1}, WantErr: true},
-		{Name: "MissingMessage", Encoded: []byte{1, 1, 1}, WantErr: true},
+		{Name: "MissingArgLenLowByte", Encoded: []byte{1, 1, 0}, WantErr: true},
+		{Name: "MissingMessage", Encoded: []byte{1, 1, 0, 1}, WantErr: true},
 		{Name: "InvalidMsgType", Encoded: []byte{254, 0}, Want: RawMessage{Type: 254}},
 		{Name: "Shutdown", Encoded: []byte{byte(msgShutdown), 0}, Want: Shutdown{}},
-		{Name: "Shutdown/ExtraArgs", Encoded: []byte{byte(msgShutdown), 1, 0}, WantErr: true},
+		{Name: "Shutdown/ExtraArgs", Encoded: []byte{byte(msgShutdown), 1, 0, 0}, WantErr: true},
 		{Name: "PrivilegeDrop", Encoded: []byte{byte(msgPrivilegeDrop), 0}, Want: PrivilegeDrop{}},
 		{
 			Name:    "OpenConnection",
-			Encoded: []byte{byte(msgOpenConnection), 2, 3, 102, 111, 111, 1, 4},
-			Want:    OpenConnection{Backend: "foo", IPVer: util.IPv4},
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv4},
+		},
+		{
+			Name:    "OpenConnection/Flood",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 1, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv4, Flood: true},
+		},
+		{
+			Name:    "OpenConnection/BadFlood",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 2, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/FlowLabel",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 6, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0x0f, 0xff, 0xff, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv6, FlowLabel: 0xfffff},
+		},
+		{
+			Name:    "OpenConnection/BadFlowLabel",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 6, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0x10, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/Mark",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0xff, 0, 0, 1, 0, 0, 0, 4, 0, 0, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv4, SourceMark: 0xff000001},
+		},
+		{
+			Name:    "OpenConnection/Netns",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 3, 114, 101, 100, 0, 4, 0, 0, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv4, SourceNetns: "red"},
+		},
+		{
+			Name:    "OpenConnection/RecvBuffer",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0x10, 0, 0},
+			Want:    OpenConnection{Request: 9, Backend: "foo", IPVer: util.IPv4, RecvBuffer: 1 << 20},
+		},
+		{
+			Name:    "OpenConnection/MissingMark",
+			Encoded: []byte{byte(msgOpenConnection), 7, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9, 0, 1, 0, 0, 4, 0, 0, 0, 0},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/MissingFlood",
+			Encoded: []byte{byte(msgOpenConnection), 5, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 9},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/Source",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 4, 101, 116, 104, 48, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			Want: OpenConnection{
+				Backend:         "foo",
+				IPVer:           util.IPv4,
+				SourceInterface: "eth0",
+				SourceAddr:      net.ParseIP("192.0.2.1"),
+			},
+		},
+		{
+			Name:    "OpenConnection/BadSourceAddr",
+			Encoded: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 3, 192, 0, 2, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/MissingSource",
+			Encoded: []byte{byte(msgOpenConnection), 2, 0, 3, 102, 111, 111, 0, 1, 4},
+			WantErr: true,
+		},
+		{
+			Name:    "OpenConnection/MissingRequest",
+			Encoded: []byte{byte(msgOpenConnection), 4, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0},
+			WantErr: true,
 		},
 		{
 			Name:    "OpenConnection/MissingArgs",
@@ -65,13 +142,13 @@ func TestReadMessage(t *testing.T) {
 		},
 		{
 			Name:    "OpenConnection/MissingIPVer",
-			Encoded: []byte{byte(msgOpenConnection), 1, 3, 102, 111, 111},
+			Encoded: []byte{byte(msgOpenConnection), 1, 0, 3, 102, 111, 111},
 			WantErr: true,
 		},
 		{
 			Name:    "OpenConnectionReply",
-			Encoded: []byte{byte(msgOpenConnectionReply), 1, 4, 0, 0, 0, 1},
-			Want:    OpenConnectionReply{ID: 1},
+			Encoded: []byte{byte(msgOpenConnectionReply), 2, 0, 4, 0, 0, 0, 1, 0, 4, 0, 0, 1, 2},
+			Want:    OpenConnectionReply{Request: 0x102, ID: 1},
 		},
 		{
 			Name:    "OpenConnectionReply/MissingConnectionID",
@@ -80,22 +157,22 @@ func TestReadMessage(t *testing.T) {
 		},
 		{
 			Name:    "OpenConnectionReply/ExtraArgs",
-			Encoded: marshalRawMsg(RawMessage{Type: msgOpenConnectionReply, Args: [][]byte{{0}, {}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgOpenConnectionReply, Args: [][]byte{{0, 0, 0, 1}, {0, 0, 0, 1}, {}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "CloseConnection",
-			Encoded: []byte{byte(msgCloseConnection), 1, 4, 0xde, 0xad, 0xbe, 0xef},
-			Want:    CloseConnection{ID: 0xdeadbeef},
+			Encoded: []byte{byte(msgCloseConnection), 2, 0, 4, 0xde, 0xad, 0xbe, 0xef, 0, 4, 0, 0, 0, 3},
+			Want:    CloseConnection{Request: 3, ID: 0xdeadbeef},
 		},
 		{
 			Name:    "CloseConnection/TooManyArgs",
-			Encoded: []byte{byte(msgCloseConnection), 2, 3, 98, 97, 114, 0},
+			Encoded: []byte{byte(msgCloseConnection), 3, 0, 4, 0, 0, 0, 1, 0, 4, 0, 0, 0, 1, 0, 0},
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing",
-			Encoded: []byte{byte(msgSendPing), 4, 4, 0, 0, 0, 88, 7, 1, 2, 3, 3, 4, 5, 6, 4, 192, 0, 2, 1, 4, 0, 0, 0, 11},
+			Encoded: []byte{byte(msgSendPing), 6, 0, 4, 0, 0, 0, 88, 0, 8, 1, 2, 3, 0, 3, 4, 5, 6, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 11, 0, 1, 1, 0, 1, 3},
 			Want: SendPing{
 				ID: 88,
 				Packet: backend.Packet{
@@ -103,67 +180,289 @@ func TestReadMessage(t *testing.T) {
 					Seq:     0x0203,
 					Payload: []byte{4, 5, 6},
 				},
-				Addr: net.ParseIP("192.0.2.1"),
-				TTL:  11,
+				Addr:         net.ParseIP("192.0.2.1"),
+				TTL:          11,
+				DontFragment: true,
+				Stream:       3,
 			},
 		},
 		{
 			Name:    "CloseConnectionReply",
-			Encoded: []byte{byte(msgCloseConnectionReply), 1, 4, 0xde, 0xad, 0xbe, 0xef},
-			Want:    CloseConnectionReply{ID: 0xdeadbeef},
+			Encoded: []byte{byte(msgCloseConnectionReply), 2, 0, 4, 0xde, 0xad, 0xbe, 0xef, 0, 4, 0, 0, 0, 4},
+			Want:    CloseConnectionReply{Request: 4, ID: 0xdeadbeef},
+		},
+		{
+			Name:    "CloseConnectionReply/MissingRequest",
+			Encoded: []byte{byte(msgCloseConnectionReply), 1, 0, 4, 0xde, 0xad, 0xbe, 0xef},
+			WantErr: true,
+		},
+		{
+			Name: "SendPingBatch",
+			Encoded: []byte{
+				byte(msgSendPingBatch), 12,
+				0, 4, 0, 0, 0, 88, 0, 8, 1, 2, 3, 0, 3, 4, 5, 6, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 11, 0, 1, 1, 0, 1, 3,
+				0, 4, 0, 0, 0, 89, 0, 5, 0, 0, 4, 0, 0, 0, 4, 192, 0, 2, 2, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0,
+			},
+			Want: SendPingBatch{Pings: []SendPing{
+				{
+					ID: 88,
+					Packet: backend.Packet{
+						Type:    backend.PacketReply,
+						Seq:     0x0203,
+						Payload: []byte{4, 5, 6},
+					},
+					Addr:         net.ParseIP("192.0.2.1"),
+					TTL:          11,
+					DontFragment: true,
+					Stream:       3,
+				},
+				{
+					ID:     89,
+					Packet: backend.Packet{Seq: 4, Payload: []byte{}},
+					Addr:   net.ParseIP("192.0.2.2"),
+				},
+			}},
+		},
+		{
+			Name:    "SendPingBatch/Empty",
+			Encoded: []byte{byte(msgSendPingBatch), 0},
+			WantErr: true,
+		},
+		{
+			Name:    "SendPingBatch/PartialPing",
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPingBatch, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}, {0, 0, 0, 0}}}),
+			WantErr: true,
 		},
 		{
 			Name:    "SendPing/MissingArgs",
 			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}}}),
 			WantErr: true,
 		},
+		{
+			Name:    "SendPing/InvalidBool",
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {2}, {0}}}),
+			WantErr: true,
+		},
+		{
+			Name:    "SendPing/StreamOutOfRange",
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {64}}}),
+			WantErr: true,
+		},
 		{
 			Name:    "SendPing/Packet/MissingType",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing/Packet/MissingSequence",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing/Packet/MissingPayloadLen",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
+			WantErr: true,
+		},
+		{
+			Name:    "SendPing/Packet/ShortPayloadLen",
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing/Packet/MissingPayload",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 3}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 3}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing/Packet/ShortPayload",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 3, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 3, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "SendPing/Packet/CruftAtEnd",
-			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 3, 0, 0, 0, 9}, {192, 0, 2, 1}, {0, 0, 0, 0}}}),
+			Encoded: marshalRawMsg(RawMessage{Type: msgSendPing, Args: [][]byte{{0, 0, 0, 0}, {0, 1, 2, 0, 3, 0, 0, 0, 9}, {192, 0, 2, 1}, {0, 0, 0, 0}, {0}, {0}}}),
 			WantErr: true,
 		},
 		{
 			Name:    "PingReply",
-			Encoded: []byte{byte(msgPingReply), 3, 4, 0, 0, 0, 89, 9, 2, 3, 4, 5, 5, 6, 7, 8, 9, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			Encoded: []byte{byte(msgPingReply), 6, 0, 4, 0, 0, 0, 89, 0, 10, 2, 3, 4, 0, 5, 5, 6, 7, 8, 9, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 4, 0, 0, 0, 57, 0, 0, 0, 0},
 			Want: PingReply{
 				ID: 89,
 				Packet: backend.Packet{
 					Type:    backend.PacketTimeExceeded,
 					Seq:     0x0304,
 					Payload: []byte{5, 6, 7, 8, 9},
+					TTL:     57,
 				},
 				Peer: net.ParseIP("2001:db8::1"),
 			},
 		},
-		{Name: "OneEmptyArg", Encoded: []byte{254, 1, 0}, Want: RawMessage{Type: 254, Args: [][]byte{{}}}},
+		{
+			Name:    "PingReply/Timestamps",
+			Encoded: []byte{byte(msgPingReply), 6, 0, 4, 0, 0, 0, 89, 0, 17, 6, 0, 7, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0},
+			Want: PingReply{
+				ID: 89,
+				Packet: backend.Packet{
+					Type:       backend.PacketTimestampReply,
+					Seq:        7,
+					Payload:    []byte{},
+					Timestamps: backend.Timestamps{Originate: 1, Receive: 2, Transmit: 3},
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+		},
+		{
+			Name:    "PingReply/AddressMask",
+			Encoded: []byte{byte(msgPingReply), 6, 0, 4, 0, 0, 0, 89, 0, 9, 8, 0, 7, 0, 0, 255, 255, 255, 0, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0},
+			Want: PingReply{
+				ID: 89,
+				Packet: backend.Packet{
+					Type:        backend.PacketAddressMaskReply,
+					Seq:         7,
+					Payload:     []byte{},
+					AddressMask: net.CIDRMask(24, 32),
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+		},
+		{
+			Name:    "PingReply/Packet/ShortTimestamps",
+			Encoded: marshalRawMsg(RawMessage{Type: msgPingReply, Args: [][]byte{{0, 0, 0, 0}, {6, 0, 7, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2}, {192, 0, 2, 1}, {0, 0, 0, 0}, {}, {}}}),
+			WantErr: true,
+		},
+		{
+			Name:    "PingReply/Packet/ShortAddressMask",
+			Encoded: marshalRawMsg(RawMessage{Type: msgPingReply, Args: [][]byte{{0, 0, 0, 0}, {8, 0, 7, 0, 0, 255, 255}, {192, 0, 2, 1}, {0, 0, 0, 0}, {}, {}}}),
+			WantErr: true,
+		},
+		{
+			Name: "PingReply/Extensions",
+			Encoded: []byte{
+				byte(msgPingReply), 6, 0, 4, 0, 0, 0, 89, 0, 5, 2, 3, 4, 0, 0, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 57,
+				0, 25,
+				1, 0x06, 0x40, 0x11, 0x01, // MPLS label 25601, TC 0, S, TTL 1
+				1, 0, 0, 0, 0, 2, 4, 192, 0, 2, 1, 4, 'e', 't', 'h', '0', 0, 0, 0x05, 0xdc,
+				0, 0,
+			},
+			Want: PingReply{
+				ID: 89,
+				Packet: backend.Packet{
+					Type:    backend.PacketTimeExceeded,
+					Seq:     0x0304,
+					Payload: []byte{},
+					TTL:     57,
+					Extensions: &backend.Extensions{
+						MPLSLabels: []backend.MPLSLabel{{Label: 25601, S: true, TTL: 1}},
+						Interfaces: []backend.InterfaceInfo{{
+							Role:  backend.IncomingInterface,
+							Index: 2,
+							Addr:  net.IP{192, 0, 2, 1},
+							Name:  "eth0",
+							MTU:   1500,
+						}},
+					},
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+		},
+		{
+			Name:    "PingReply/Extensions/Short",
+			Encoded: marshalRawMsg(RawMessage{Type: msgPingReply, Args: [][]byte{{0, 0, 0, 0}, {2, 3, 4, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 0}, {1, 0x06, 0x40}, {}}}),
+			WantErr: true,
+		},
+		{
+			Name:    "PingReply/RecvTime",
+			Encoded: marshalRawMsg(RawMessage{Type: msgPingReply, Args: [][]byte{{0, 0, 0, 89}, {1, 0, 7, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 57}, {}, {0, 0, 0, 0, 0x3b, 0x9a, 0xca, 0x01}}}),
+			Want: PingReply{
+				ID: 89,
+				Packet: backend.Packet{
+					Type:     backend.PacketReply,
+					Seq:      7,
+					Payload:  []byte{},
+					TTL:      57,
+					RecvTime: time.Unix(1, 1),
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+		},
+		{
+			Name:    "PingReply/ShortReceived",
+			Encoded: marshalRawMsg(RawMessage{Type: msgPingReply, Args: [][]byte{{0, 0, 0, 89}, {1, 0, 7, 0, 0}, {192, 0, 2, 1}, {0, 0, 0, 57}, {}, {0x3b, 0x9a, 0xca, 0x01}}}),
+			WantErr: true,
+		},
+		{
+			Name:    "PingReply/MissingTTL",
+			Encoded: []byte{byte(msgPingReply), 3, 0, 4, 0, 0, 0, 89, 0, 9, 8, 0, 7, 0, 0, 255, 255, 255, 0, 0, 4, 192, 0, 2, 1},
+			WantErr: true,
+		},
+		{
+			Name:    "Hello",
+			Encoded: []byte{byte(msgHello), 1, 0, 4, 0, 0, 0, 3},
+			Want:    Hello{Version: 3},
+		},
+		{
+			Name:    "Hello/MissingVersion",
+			Encoded: []byte{byte(msgHello), 0},
+			WantErr: true,
+		},
+		{
+			Name:    "HelloReply",
+			Encoded: []byte{byte(msgHelloReply), 1, 0, 4, 0, 0, 1, 0},
+			Want:    HelloReply{Version: 256},
+		},
+		{
+			Name:    "SetRateLimit",
+			Encoded: []byte{byte(msgSetRateLimit), 5, 0, 4, 0, 0x0f, 0x42, 0x40, 0, 4, 0, 0, 0, 5, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 1},
+			Want:    SetRateLimit{Request: 1, PerConnection: RateLimit{Interval: time.Second, Burst: 5}},
+		},
+		{
+			Name:    "SetRateLimit/MissingGlobal",
+			Encoded: []byte{byte(msgSetRateLimit), 2, 0, 4, 0, 0x0f, 0x42, 0x40, 0, 4, 0, 0, 0, 5},
+			WantErr: true,
+		},
+		{
+			Name:    "RateLimitReply",
+			Encoded: []byte{byte(msgRateLimitReply), 5, 0, 4, 0, 0x0f, 0x42, 0x40, 0, 4, 0, 0, 0, 5, 0, 4, 0, 0, 0x03, 0xe8, 0, 4, 0, 0, 0, 100, 0, 4, 0, 0, 0, 2},
+			Want: RateLimitReply{
+				Request:       2,
+				PerConnection: RateLimit{Interval: time.Second, Burst: 5},
+				Global:        RateLimit{Interval: time.Millisecond, Burst: 100},
+			},
+		},
+		{
+			Name:    "RateLimitReply/ShortInterval",
+			Encoded: []byte{byte(msgRateLimitReply), 5, 0, 2, 0x42, 0x40, 0, 4, 0, 0, 0, 5, 0, 4, 0, 0, 0x03, 0xe8, 0, 4, 0, 0, 0, 100, 0, 4, 0, 0, 0, 2},
+			WantErr: true,
+		},
+		{
+			Name:    "Error",
+			Encoded: []byte{byte(msgError), 4, 0, 4, 0, 0, 0, 3, 0, 1, 1, 0, 3, 102, 111, 111, 0, 4, 0, 0, 0, 5},
+			Want:    Error{Request: 5, ID: 3, Code: ErrorRateLimited, Text: "foo"},
+		},
+		{
+			Name:    "OpenSocket",
+			Encoded: []byte{byte(msgOpenSocket), 2, 0, 4, 0, 0, 0, 3, 0, 1, 6},
+			Want:    OpenSocket{Request: 3, IPVer: util.IPv6},
+		},
+		{
+			Name:    "OpenSocket/MissingIPVer",
+			Encoded: []byte{byte(msgOpenSocket), 1, 0, 4, 0, 0, 0, 3},
+			WantErr: true,
+		},
+		{
+			Name:    "SocketReply",
+			Encoded: []byte{byte(msgSocketReply), 1, 0, 4, 0, 0, 0, 3},
+			Want:    SocketReply{Request: 3},
+		},
+		{
+			Name:    "Error/LongCode",
+			Encoded: []byte{byte(msgError), 4, 0, 4, 0, 0, 0, 3, 0, 2, 0, 1, 0, 3, 102, 111, 111, 0, 4, 0, 0, 0, 5},
+			WantErr: true,
+		},
+		{Name: "OneEmptyArg", Encoded: []byte{254, 1, 0, 0}, Want: RawMessage{Type: 254, Args: [][]byte{{}}}},
 		{
 			Name:    "OneNonemptyArg",
-			Encoded: []byte{254, 1, 2, 3, 4},
+			Encoded: []byte{254, 1, 0, 2, 3, 4},
 			Want: RawMessage{
 				Type: 254,
 				Args: [][]byte{{3, 4}},
@@ -171,7 +470,7 @@ func TestReadMessage(t *testing.T) {
 		},
 		{
 			Name:    "TwoNonemptyArgs",
-			Encoded: []byte{254, 2, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			Encoded: []byte{254, 2, 0, 2, 3, 4, 0, 5, 6, 7, 8, 9, 10},
 			Want: RawMessage{
 				Type: 254,
 				Args: [][]byte{
@@ -212,18 +511,38 @@ func TestMessage_WriteTo(t *testing.T) {
 		{Name: "PrivilegeDrop", Msg: PrivilegeDrop{}, Want: []byte{byte(msgPrivilegeDrop), 0}},
 		{
 			Name: "OpenConnection",
-			Msg:  OpenConnection{Backend: "foo", IPVer: util.IPv6},
-			Want: []byte{byte(msgOpenConnection), 2, 3, 102, 111, 111, 1, 6},
+			Msg:  OpenConnection{Request: 0x01020304, Backend: "foo", IPVer: util.IPv6},
+			Want: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 6, 0, 0, 0, 0, 0, 4, 1, 2, 3, 4, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+		},
+		{
+			Name: "OpenConnection/Flood",
+			Msg:  OpenConnection{Request: 1, Backend: "foo", IPVer: util.IPv4, Flood: true},
+			Want: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 1, 0, 1, 1, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+		},
+		{
+			Name: "OpenConnection/Source",
+			Msg:  OpenConnection{Backend: "foo", IPVer: util.IPv4, SourceInterface: "eth0", SourceAddr: net.ParseIP("192.0.2.1").To4()},
+			Want: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 4, 0, 4, 101, 116, 104, 48, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
+		},
+		{
+			Name: "OpenConnection/FlowLabel",
+			Msg:  OpenConnection{Request: 1, Backend: "foo", IPVer: util.IPv6, FlowLabel: 0x12345},
+			Want: []byte{byte(msgOpenConnection), 10, 0, 3, 102, 111, 111, 0, 1, 6, 0, 0, 0, 0, 0, 4, 0, 0, 0, 1, 0, 1, 0, 0, 4, 0, 1, 0x23, 0x45, 0, 4, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0},
 		},
 		{
 			Name: "OpenConnectionReply",
-			Msg:  OpenConnectionReply{ID: 1},
-			Want: []byte{byte(msgOpenConnectionReply), 1, 4, 0, 0, 0, 1},
+			Msg:  OpenConnectionReply{Request: 2, ID: 1},
+			Want: []byte{byte(msgOpenConnectionReply), 2, 0, 4, 0, 0, 0, 1, 0, 4, 0, 0, 0, 2},
 		},
 		{
 			Name: "CloseConnection",
-			Msg:  CloseConnection{ID: 0xdeadbeef},
-			Want: []byte{byte(msgCloseConnection), 1, 4, 0xde, 0xad, 0xbe, 0xef},
+			Msg:  CloseConnection{Request: 3, ID: 0xdeadbeef},
+			Want: []byte{byte(msgCloseConnection), 2, 0, 4, 0xde, 0xad, 0xbe, 0xef, 0, 4, 0, 0, 0, 3},
+		},
+		{
+			Name: "CloseConnectionReply",
+			Msg:  CloseConnectionReply{Request: 4, ID: 5},
+			Want: []byte{byte(msgCloseConnectionReply), 2, 0, 4, 0, 0, 0, 5, 0, 4, 0, 0, 0, 4},
 		},
 		{
 			Name: "SendPing",
@@ -236,7 +555,24 @@ func TestMessage_WriteTo(t *testing.T) {
 				Addr: net.ParseIP("192.0.2.2").To4(),
 				TTL:  7,
 			},
-			Want: []byte{byte(msgSendPing), 4, 4, 0, 0, 0, 88, 6, 2, 2, 3, 2, 4, 5, 4, 192, 0, 2, 2, 4, 0, 0, 0, 7},
+			Want: []byte{byte(msgSendPing), 6, 0, 4, 0, 0, 0, 88, 0, 7, 2, 2, 3, 0, 2, 4, 5, 0, 4, 192, 0, 2, 2, 0, 4, 0, 0, 0, 7, 0, 1, 0, 0, 1, 0},
+		},
+		{
+			Name: "SendPingBatch",
+			Msg: SendPingBatch{Pings: []SendPing{
+				{ID: 88, Packet: backend.Packet{Seq: 1}, Addr: net.ParseIP("192.0.2.1").To4()},
+				{ID: 89, Packet: backend.Packet{Seq: 2}, Addr: net.ParseIP("192.0.2.2").To4(), TTL: 3},
+			}},
+			Want: []byte{
+				byte(msgSendPingBatch), 12,
+				0, 4, 0, 0, 0, 88, 0, 5, 0, 0, 1, 0, 0, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0,
+				0, 4, 0, 0, 0, 89, 0, 5, 0, 0, 2, 0, 0, 0, 4, 192, 0, 2, 2, 0, 4, 0, 0, 0, 3, 0, 1, 0, 0, 1, 0,
+			},
+		},
+		{
+			Name:    "SendPingBatch/TooMany",
+			Msg:     SendPingBatch{Pings: make([]SendPing, MaxBatchPings+1)},
+			WantErr: true,
 		},
 		{
 			Name: "PingReply",
@@ -245,23 +581,109 @@ func TestMessage_WriteTo(t *testing.T) {
 					Type:    backend.PacketReply,
 					Seq:     0x0405,
 					Payload: []byte{6, 7, 8},
+					TTL:     250,
 				},
 				Peer: net.ParseIP("2001:db8::1"),
 			},
-			Want: []byte{byte(msgPingReply), 3, 4, 0, 0, 0, 80, 7, 1, 4, 5, 3, 6, 7, 8, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1},
+			Want: []byte{byte(msgPingReply), 6, 0, 4, 0, 0, 0, 80, 0, 8, 1, 4, 5, 0, 3, 6, 7, 8, 0, 16, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 4, 0, 0, 0, 250, 0, 0, 0, 0},
+		},
+		{
+			Name: "PingReply/Extensions",
+			Msg: PingReply{
+				ID: 80, Packet: backend.Packet{
+					Type:    backend.PacketTimeExceeded,
+					Seq:     0x0405,
+					Payload: []byte{},
+					Extensions: &backend.Extensions{
+						MPLSLabels: []backend.MPLSLabel{{Label: 16, TC: 5, TTL: 254}, {Label: 17, S: true, TTL: 1}},
+						Interfaces: []backend.InterfaceInfo{{Role: backend.OutgoingInterface, Index: 3, Addr: net.ParseIP("192.0.2.1")}},
+					},
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+			Want: []byte{
+				byte(msgPingReply), 6, 0, 4, 0, 0, 0, 80, 0, 5, 2, 4, 5, 0, 0, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0,
+				0, 25,
+				2, 0, 1, 0x0a, 0xfe, 0, 1, 0x11, 0x01,
+				1, 2, 0, 0, 0, 3, 4, 192, 0, 2, 1, 0, 0, 0, 0, 0,
+				0, 0,
+			},
+		},
+		{
+			Name: "SendPing/Timestamps",
+			Msg: SendPing{
+				ID: 88, Packet: backend.Packet{
+					Type:       backend.PacketTimestampRequest,
+					Seq:        0x0203,
+					Timestamps: backend.Timestamps{Originate: 0x01020304},
+				},
+				Addr: net.ParseIP("192.0.2.2").To4(),
+			},
+			Want: []byte{byte(msgSendPing), 6, 0, 4, 0, 0, 0, 88, 0, 17, 5, 2, 3, 0, 0, 1, 2, 3, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 192, 0, 2, 2, 0, 4, 0, 0, 0, 0, 0, 1, 0, 0, 1, 0},
+		},
+		{
+			Name: "PingReply/AddressMask",
+			Msg: PingReply{
+				ID: 80, Packet: backend.Packet{
+					Type:        backend.PacketAddressMaskReply,
+					Seq:         0x0405,
+					AddressMask: net.CIDRMask(16, 32),
+				},
+				Peer: net.ParseIP("192.0.2.1").To4(),
+			},
+			Want: []byte{byte(msgPingReply), 6, 0, 4, 0, 0, 0, 80, 0, 9, 8, 4, 5, 0, 0, 255, 255, 0, 0, 0, 4, 192, 0, 2, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			Name: "Hello",
+			Msg:  Hello{Version: 1},
+			Want: []byte{byte(msgHello), 1, 0, 4, 0, 0, 0, 1},
+		},
+		{
+			Name: "HelloReply",
+			Msg:  HelloReply{Version: 2},
+			Want: []byte{byte(msgHelloReply), 1, 0, 4, 0, 0, 0, 2},
+		},
+		{
+			Name: "SetRateLimit",
+			Msg:  SetRateLimit{Request: 6, Global: RateLimit{Interval: 2 * time.Millisecond, Burst: 10}},
+			Want: []byte{byte(msgSetRateLimit), 5, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0, 4, 0, 0, 0x07, 0xd0, 0, 4, 0, 0, 0, 10, 0, 4, 0, 0, 0, 6},
+		},
+		{
+			Name: "RateLimitReply",
+			Msg: RateLimitReply{
+				Request:       7,
+				PerConnection: RateLimit{Interval: time.Second, Burst: 5},
+				Global:        RateLimit{Interval: time.Millisecond, Burst: 100},
+			},
+			Want: []byte{byte(msgRateLimitReply), 5, 0, 4, 0, 0x0f, 0x42, 0x40, 0, 4, 0, 0, 0, 5, 0, 4, 0, 0, 0x03, 0xe8, 0, 4, 0, 0, 0, 100, 0, 4, 0, 0, 0, 7},
+		},
+		{
+			Name: "Error",
+			Msg:  Error{ID: 7, Code: ErrorRateLimited, Text: "slow down"},
+			Want: []byte{byte(msgError), 4, 0, 4, 0, 0, 0, 7, 0, 1, 1, 0, 9, 115, 108, 111, 119, 32, 100, 111, 119, 110, 0, 4, 0, 0, 0, 0},
+		},
+		{
+			Name: "OpenSocket",
+			Msg:  OpenSocket{Request: 3, IPVer: util.IPv4},
+			Want: []byte{byte(msgOpenSocket), 2, 0, 4, 0, 0, 0, 3, 0, 1, 4},
+		},
+		{
+			Name: "SocketReply",
+			Msg:  SocketReply{Request: 3},
+			Want: []byte{byte(msgSocketReply), 1, 0, 4, 0, 0, 0, 3},
 		},
 
 		{Name: "TooManyArgs", Msg: RawMessage{Args: make([][]byte, 256)}, WantErr: true},
-		{Name: "ArgTooLong", Msg: RawMessage{Args: [][]byte{make([]byte, 256)}}, WantErr: true},
+		{Name: "ArgTooLong", Msg: RawMessage{Args: [][]byte{make([]byte, math.MaxUint16+1)}}, WantErr: true},
 		{Name: "NoArgs", Msg: RawMessage{Type: msgShutdown}, Want: []byte{byte(msgShutdown), 0}},
-		{Name: "OneEmptyArg", Msg: RawMessage{Type: msgShutdown, Args: [][]byte{{}}}, Want: []byte{byte(msgShutdown), 1, 0}},
+		{Name: "OneEmptyArg", Msg: RawMessage{Type: msgShutdown, Args: [][]byte{{}}}, Want: []byte{byte(msgShutdown), 1, 0, 0}},
 		{
 			Name: "OneNonemptyArg",
 			Msg: RawMessage{
 				Type: msgShutdown,
 				Args: [][]byte{{3, 4}},
 			},
-			Want: []byte{byte(msgShutdown), 1, 2, 3, 4},
+			Want: []byte{byte(msgShutdown), 1, 0, 2, 3, 4},
 		},
 		{
 			Name: "TwoNonemptyArgs",
@@ -272,7 +694,7 @@ func TestMessage_WriteTo(t *testing.T) {
 					{6, 7, 8, 9, 10},
 				},
 			},
-			Want: []byte{byte(msgSendPing), 2, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			Want: []byte{byte(msgSendPing), 2, 0, 2, 3, 4, 0, 5, 6, 7, 8, 9, 10},
 		},
 		{
 			Name: "MaximalMessage",
@@ -303,7 +725,7 @@ func TestMessage_WriteTo(t *testing.T) {
 
 func FuzzRawMessage(f *testing.F) {
 	f.Fuzz(func(t *testing.T, mType byte, arg1, arg2 []byte) {
-		if len(arg1) > 255 || len(arg2) > 255 {
+		if len(arg1) > math.MaxUint16 || len(arg2) > math.MaxUint16 {
 			t.Skip("Args too long")
 		}
 		msg := RawMessage{Type: messageType(mType), Args: [][]byte{arg1, arg2}}
@@ -328,10 +750,10 @@ func FuzzRawMessage(f *testing.F) {
 func FuzzReadMessage(f *testing.F) {
 	for _, seed := range [][]byte{
 		{0, 0},
-		{1, 1, 0},
-		{1, 1, 1, 0},
-		{1, 2, 0, 0},
-		{1, 2, 1, 0, 2, 0, 0},
+		{1, 1, 0, 0},
+		{1, 1, 0, 1, 0},
+		{1, 2, 0, 0, 0, 0},
+		{1, 2, 0, 1, 0, 0, 2, 0, 0},
 		makeEncodedMaximalMessage(),
 	} {
 		f.Add(seed)
@@ -0,0 +1,160 @@
+package messages
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Size of the buffer each record is read into. It's larger than any
+	// message the client or server sends.
+	recordBufLen = 1 << 17
+
+	// The most files that may arrive with a single read.
+	maxFilesPerRead = 8
+)
+
+// SocketConn carries messages over a unix domain socket. Unlike a pipe, it can
+// pass open files along with a message.
+//
+// The socket may be SOCK_SEQPACKET or SOCK_STREAM. Either way, every message
+// must be written with a single Write call, which [Message.WriteTo] does. That
+// keeps a message in one record, and keeps any file sent with it from arriving
+// after the message itself.
+type SocketConn struct {
+	conn      *net.UnixConn
+	closeOnce sync.Once
+	closeErr  error
+
+	// Read state. Only one goroutine may read at a time.
+	buf    []byte
+	unread []byte
+	oob    []byte
+	files  []*os.File
+}
+
+// NewSocketConn creates a connection from a unix domain socket. It takes
+// ownership of f.
+func NewSocketConn(f *os.File) (*SocketConn, error) {
+	defer f.Close()
+	c, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("not a unix domain socket: %v", f.Name())
+	}
+	return &SocketConn{
+		conn: conn,
+		buf:  make([]byte, recordBufLen),
+		oob:  make([]byte, syscall.CmsgSpace(maxFilesPerRead*4)),
+	}, nil
+}
+
+// Read implements [io.Reader]. Files that arrive are queued for [TakeFile].
+func (c *SocketConn) Read(p []byte) (int, error) {
+	if len(c.unread) == 0 {
+		if err := c.readRecord(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+// Reads the next record, and any files sent with it.
+func (c *SocketConn) readRecord() error {
+	n, oobn, flags, _, err := c.conn.ReadMsgUnix(c.buf, c.oob)
+	if err != nil {
+		return err
+	}
+	// Take the files first, so that none leak if something's wrong with the
+	// record.
+	if err := c.queueFiles(c.oob[:oobn]); err != nil {
+		return err
+	}
+	if flags&syscall.MSG_TRUNC != 0 {
+		return errors.New("record too long")
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return errors.New("too many files received")
+	}
+	if n == 0 {
+		return io.EOF
+	}
+	c.unread = c.buf[:n]
+	return nil
+}
+
+// Queues the files in a control message.
+func (c *SocketConn) queueFiles(oob []byte) error {
+	if len(oob) == 0 {
+		return nil
+	}
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return fmt.Errorf("error parsing control message: %v", err)
+	}
+	for _, cmsg := range cmsgs {
+		fds, err := syscall.ParseUnixRights(&cmsg)
+		if err != nil {
+			return fmt.Errorf("error parsing control message: %v", err)
+		}
+		for _, fd := range fds {
+			c.files = append(c.files, os.NewFile(uintptr(fd), "privsep-fd"))
+		}
+	}
+	return nil
+}
+
+// TakeFile returns the oldest file received and not yet taken, or nil if there
+// isn't one. A file is received no later than the message it was sent with.
+// It must be called from the reading goroutine.
+func (c *SocketConn) TakeFile() *os.File {
+	if len(c.files) == 0 {
+		return nil
+	}
+	f := c.files[0]
+	c.files = c.files[1:]
+	return f
+}
+
+// Write implements [io.Writer]. Each call is sent as one record.
+func (c *SocketConn) Write(p []byte) (int, error) {
+	return c.conn.Write(p)
+}
+
+// WriteWithFile writes a message along with an open file. The receiver gets
+// its own descriptor for the file, so f may be closed afterwards.
+func (c *SocketConn) WriteWithFile(msg Message, f *os.File) error {
+	var buf bytes.Buffer
+	if _, err := msg.WriteTo(&buf); err != nil {
+		return err
+	}
+	_, _, err := c.conn.WriteMsgUnix(buf.Bytes(), syscall.UnixRights(int(f.Fd())), nil)
+	return err
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *SocketConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+// Close closes the connection. The same connection is usually both the input
+// and the output, so closing it more than once is harmless.
+func (c *SocketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.conn.Close()
+	})
+	return c.closeErr
+}
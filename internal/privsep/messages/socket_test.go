@@ -0,0 +1,110 @@
+package messages
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// Makes a connected pair of SocketConns of the given type.
+func makeSocketPair(t *testing.T, sotype int) (*SocketConn, *SocketConn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, sotype, 0)
+	if err != nil {
+		t.Skipf("Socketpair error: %v", err)
+	}
+	var conns [2]*SocketConn
+	for i, fd := range fds {
+		conns[i], err = NewSocketConn(os.NewFile(uintptr(fd), "test"))
+		if err != nil {
+			t.Fatalf("NewSocketConn error: %v", err)
+		}
+		conns[i].SetDeadline(time.Now().Add(5 * time.Second))
+	}
+	return conns[0], conns[1]
+}
+
+func TestSocketConn(t *testing.T) {
+	for _, c := range []struct {
+		Name   string
+		SoType int
+	}{
+		{Name: "SeqPacket", SoType: syscall.SOCK_SEQPACKET},
+		{Name: "Stream", SoType: syscall.SOCK_STREAM},
+	} {
+		t.Run(c.Name, func(t *testing.T) {
+			a, b := makeSocketPair(t, c.SoType)
+			defer a.Close()
+			defer b.Close()
+
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				t.Fatalf("Pipe error: %v", err)
+			}
+			defer pr.Close()
+
+			sent := []Message{
+				Hello{Version: 1},
+				SocketReply{Request: 2},
+				Error{Request: 3, Code: ErrorOpenFailed, Text: "foo"},
+			}
+			go func() {
+				for _, msg := range sent {
+					var err error
+					if _, ok := msg.(SocketReply); ok {
+						err = a.WriteWithFile(msg, pw)
+						pw.Close()
+					} else {
+						_, err = msg.WriteTo(a)
+					}
+					if err != nil {
+						t.Errorf("Write error: %v", err)
+					}
+				}
+				a.Close()
+			}()
+
+			r := bufio.NewReader(b)
+			var got []Message
+			for {
+				msg, err := ReadMessage(r)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadMessage error: %v", err)
+				}
+				if reply, ok := msg.(SocketReply); ok {
+					reply.File = b.TakeFile()
+					if reply.File == nil {
+						t.Fatalf("No file received with %v", reply)
+					}
+					if _, err := reply.File.Write([]byte("x")); err != nil {
+						t.Errorf("Error writing to received file: %v", err)
+					}
+					reply.File.Close()
+					msg = reply
+				}
+				got = append(got, msg)
+			}
+			if diff := cmp.Diff(sent, got, cmpopts.IgnoreFields(SocketReply{}, "File")); diff != "" {
+				t.Errorf("Wrong messages (-want, +got):\n%v", diff)
+			}
+			if f := b.TakeFile(); f != nil {
+				t.Errorf("Unexpected extra file: %v", f.Name())
+			}
+
+			buf := make([]byte, 2)
+			if n, _ := pr.Read(buf); string(buf[:n]) != "x" {
+				t.Errorf("Read %q from pipe (want %q)", buf[:n], "x")
+			}
+		})
+	}
+}
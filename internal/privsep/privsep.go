@@ -3,7 +3,9 @@ Package privsep contains code for running some code as root.
 
 This works as a client/server, where the main part of the program is the client,
 and the privileged part runs in a separate process as a server. The two are
-connected with pipes.
+connected with a unix domain socket pair, or with pipes on systems where that
+isn't possible. The socket is SOCK_SEQPACKET where available, so that each
+message arrives as a single record.
 
 # Rationale
 
@@ -24,6 +26,11 @@ privileges. However, since this program is interactive, things are more
 complicated. As long as new sockets need be opened, it's necessary to maintain
 privileges. Privilege separation is the next best thing.
 
+It's skipped when it isn't needed. Builds using raw sockets on Linux run
+entirely in-process if the binary was given cap_net_raw instead of setuid. The
+default Linux build never uses it, and only warns if the ping_group_range
+sysctl doesn't allow the user unprivileged ICMP.
+
 # Rules
 
 The rules for this module are:
@@ -36,8 +43,12 @@ The rules for this module are:
     the first line.
   - No 3rd party packages imported here. The amount of scrutiny for the standard
     library is far higher than it is for most 3rd party code. (Including,
-    unfortunately, this package, but there's no getting around that one.)
-  - No [unsafe] (it should go without saying)
+    unfortunately, this package, but there's no getting around that one.) The
+    one exception is golang.org/x/sys/unix in the sandbox package on OpenBSD,
+    which is the only way to call pledge and unveil there.
+  - No [unsafe] (it should go without saying). The one exception is the
+    conversion in the sandbox package that hands the kernel a pointer to the
+    seccomp filter, which can't be installed any other way.
 
 # Protocol
 
@@ -50,25 +61,80 @@ Messages are formatted as:
 
 	<type><num_args>{<arg>}*
 
-Each arg is a variable-length string with an 8-bit length prefix:
+Each arg is a variable-length string with a 16-bit big endian length prefix:
 
 	<len>{<char>}*
 
 The maximum message length is:
 
-	2 + 255 * (1 + 255) = 65282
+	2 + 255 * (2 + 65535) = 16711682
 
 backend.Packet is formatted as:
 
-	<packet-type><seq><payload-len><payload>
+	<packet-type><seq><payload-len><payload>[<trailer>]
 
 	<packet-type>: 1 byte
 	<seq>:         2 byte big endian sequence number
-	<payload-len>: 1 byte
+	<payload-len>: 2 byte big endian payload length
 	<payload>:     payload-len bytes
+	<trailer>:     12 bytes of timestamps for timestamp packets, 4 bytes of
+	               mask for address mask packets, and empty otherwise
+
+The first message sent by the client must be a Hello message containing its
+protocol version. The server always answers with a HelloReply containing its
+own version, and exits if the two differ. This guards against a new client
+talking to a stale privileged helper.
+
+Requests that expect a reply carry a request ID, which the server copies into
+the reply (or the Error sent in its place). The client uses it to match replies
+to outstanding requests, so several requests may be in flight at once and
+answered in any order. Messages that don't answer a request have an ID of zero.
+
+The server rate limits outgoing pings, both per connection and across all
+connections, so that a compromised client can't use it as a flood tool. A
+connection shared by several pingers sends each one's pings on a separate
+stream, but the per-connection limit covers all of its streams together, and
+is loose enough for a connection with every stream in use. Pings over the
+limit are dropped and answered with an Error message. The client can
+query the limits, or tighten them, with a SetRateLimit message, but it can't
+loosen them beyond the server's defaults. The one exception is a connection
+opened in flood mode, which is exempt from the limits. The server only allows
+that when it was started by root, who could flood the network anyway.
+
+Before serving requests, the server caps the files it may open, and on systems
+where the limit counts processes rather than threads, forbids it from starting
+any. If VASILY_PRIVSEP_CHROOT names an empty directory that only root can
+write to, the server also changes its root to it. Even before that, it opens
+every named network namespace, so that connections can be opened in them
+later. All of this happens before the sandbox package restricts it further.
+The client clears its supplementary groups and resets its group along with
+its user when it drops privileges.
+
+If the server exits unexpectedly, [Initialize]'s watchdog starts a new one. The
+client greets it, restores any tightened rate limits, and reopens every open
+connection with the same OpenConnection request as before. The new server picks
+new connection IDs, which the client maps its connections onto. Requests and
+pings in the meantime fail with a transient error. A server that exits soon
+after starting isn't restarted, and the program exits instead.
+
+The client writes to the server from a single queue, so a slow server doesn't
+hold up the pingers sharing it. If too many pings back up, the oldest are
+dropped. Pings that are waiting together go out in a single SendPingBatch
+message, which the server handles as though each came in its own SendPing.
+Replies are still sent one at a time.
+
+Over a socket, the server can also pass open files to the client. An OpenSocket
+request asks for a raw ICMP socket, which arrives with the SocketReply. The
+client sends and receives on it directly, so flood connections don't pay for a
+round trip through the server on every packet. Nothing limits what the client
+sends on such a socket, so the server only hands them out when it was started by
+root, just like flood connections.
 
 Any unrecognized or improperly-formatted messages to the privileged server will
 cause it to immediately exit. The unprivileged client can be more forgiving.
+Well-formed requests that fail, such as a ping to an unreachable network or a
+request for an unknown connection, are answered with an Error message instead.
+The client returns it from the corresponding connection operation.
 
 [Postel's law]: https://en.wikipedia.org/wiki/Robustness_principle
 */
@@ -82,14 +148,34 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/netns"
 	"github.com/pcekm/vasily/internal/privsep/client"
+	"github.com/pcekm/vasily/internal/privsep/messages"
+	"github.com/pcekm/vasily/internal/privsep/sandbox"
 )
 
 const (
 	startPrivFlag = "[privileged]"
+
+	// Starts the privileged server connected by a socket on fd 3, instead of
+	// stdin and stdout.
+	startPrivSocketFlag = "[privileged-socket]"
+
+	// The privileged server is restarted if it exits, but only if it ran for
+	// at least this long. Otherwise it's likely to keep exiting.
+	minServerUptime = 10 * time.Second
+
+	// Environment variable naming an empty, root-owned directory for the
+	// privileged server to chroot into. It's passed on to the server, whose
+	// environment is otherwise empty.
+	chrootEnv = "VASILY_PRIVSEP_CHROOT"
 )
 
 func Initialize() func() {
@@ -97,49 +183,147 @@ func Initialize() func() {
 		return func() {}
 	}
 
-	if len(os.Args) == 2 && os.Args[1] == startPrivFlag {
+	if len(os.Args) == 2 && (os.Args[1] == startPrivFlag || os.Args[1] == startPrivSocketFlag) {
+		// The client relays the server's stderr into its own log.
+		logging.SetOutput(os.Stderr)
 		log.Printf("Starting privileged server.")
-		server := newServer()
+		server, err := serverFromArgs(os.Args[1])
+		if err != nil {
+			log.Fatalf("Error starting privileged server: %v", err)
+		}
+		// Only carry on without a sandbox if the OS doesn't support one. The
+		// chroot and limits come first, since the sandbox may not allow
+		// them. Network namespaces have to be opened before either, while
+		// they can still be found.
+		if err := netns.Preopen(); err != nil {
+			log.Printf("Error opening network namespaces: %v", err)
+		}
+		// Running unconfined after a chroot was asked for would go unnoticed.
+		if dir := os.Getenv(chrootEnv); dir != "" {
+			if err := sandbox.Chroot(dir); err != nil {
+				log.Fatalf("Error changing privileged server's root to %s: %v", dir, err)
+			}
+		}
+		if err := sandbox.LimitResources(); err != nil {
+			log.Printf("Error limiting privileged server's resources: %v", err)
+		}
+		if err := sandbox.Apply(); errors.Is(err, errors.ErrUnsupported) {
+			log.Printf("Not sandboxing privileged server: %v", err)
+		} else if err != nil {
+			log.Fatalf("Error sandboxing privileged server: %v", err)
+		}
 		server.run()
 		os.Exit(0)
 	}
 
+	setuid := syscall.Getuid() != syscall.Geteuid()
 	if err := dropPrivileges(); err != nil {
 		log.Fatalf("Error dropping privileges: %v", err)
 	}
 
+	cmd, clientIn, clientOut, err := startServer()
+	if err != nil {
+		log.Fatalf("Error running privileged server: %v", err)
+	}
+	sup := &supervisor{
+		client:  client.New(clientIn, clientOut),
+		cmd:     cmd,
+		started: time.Now(),
+		waited:  make(chan any),
+	}
+	if err := sup.client.Hello(); err != nil {
+		log.Fatalf("Error starting privileged server: %v", err)
+	}
+	backend.UsePrivsep(sup.client)
+	backend.UseRawSockets(sup.client.OpenSocket)
+	updateStatus(func(s *Status) {
+		*s = Status{Enabled: true, Alive: true, PrivilegesDropped: setuid}
+	})
+	go sup.watchdog()
+
+	return sup.shutdown
+}
+
+// Creates the server in the privileged process.
+func serverFromArgs(flag string) (*Server, error) {
+	if flag == startPrivFlag {
+		return newServer(os.Stdin, os.Stdout), nil
+	}
+	sock, err := messages.NewSocketConn(os.NewFile(3, "privsep-socket"))
+	if err != nil {
+		return nil, err
+	}
+	return newServer(sock, sock), nil
+}
+
+// Starts the privileged server, and returns its input and output. They're the
+// same socket unless a socket pair couldn't be created.
+func startServer() (*exec.Cmd, io.ReadCloser, io.WriteCloser, error) {
 	me, err := os.Executable()
 	if err != nil {
-		log.Fatalf("Can't determine self executable: %v", err)
+		return nil, nil, nil, fmt.Errorf("can't determine self executable: %v", err)
 	}
 	cmd := exec.Command(me, startPrivFlag)
 	cmd.Args[0] = "vasily"
 	cmd.Env = []string{}
-
-	clientIn, err := cmd.StdoutPipe()
-	if err != nil {
-		log.Fatalf("Error creating pipe: %v", err)
+	if dir := os.Getenv(chrootEnv); dir != "" {
+		cmd.Env = append(cmd.Env, chrootEnv+"="+dir)
 	}
-	clientOut, err := cmd.StdinPipe()
-	if err != nil {
-		log.Fatalf("Error creating pipe: %v", err)
+
+	var clientIn io.ReadCloser
+	var clientOut io.WriteCloser
+	clientSock, serverSock, err := socketPair()
+	if err == nil {
+		cmd.Args[1] = startPrivSocketFlag
+		cmd.ExtraFiles = []*os.File{serverSock}
+		defer serverSock.Close()
+		sock, err := messages.NewSocketConn(clientSock)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating socket: %v", err)
+		}
+		clientIn, clientOut = sock, sock
+	} else {
+		log.Printf("Error creating socket pair; using pipes: %v", err)
+		clientIn, err = cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating pipe: %v", err)
+		}
+		clientOut, err = cmd.StdinPipe()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error creating pipe: %v", err)
+		}
 	}
 	clientErr, err := cmd.StderrPipe()
 	if err != nil {
-		log.Fatalf("Error creating pipe: %v", err)
+		return nil, nil, nil, fmt.Errorf("error creating pipe: %v", err)
 	}
-	waited := make(chan any)
 	go stderrLogger(clientErr)
 
 	if err := cmd.Start(); err != nil {
-		log.Fatalf("Error running privileged server: %v", err)
+		return nil, nil, nil, err
 	}
-	go watchdog(cmd, waited)
-
-	client := client.New(clientIn, clientOut)
-	backend.UsePrivsep(client)
+	return cmd, clientIn, clientOut, nil
+}
 
-	return shutdownFunc(cmd, client, waited)
+// Creates a connected pair of unix domain sockets. Tries SOCK_SEQPACKET first,
+// and falls back to SOCK_STREAM on systems without it, such as macOS.
+func socketPair() (client, server *os.File, err error) {
+	for _, sotype := range []int{syscall.SOCK_SEQPACKET, syscall.SOCK_STREAM} {
+		var fds [2]int
+		// Hold the fork lock so no child inherits the sockets before
+		// close-on-exec is set.
+		syscall.ForkLock.RLock()
+		fds, err = syscall.Socketpair(syscall.AF_UNIX, sotype, 0)
+		if err == nil {
+			syscall.CloseOnExec(fds[0])
+			syscall.CloseOnExec(fds[1])
+		}
+		syscall.ForkLock.RUnlock()
+		if err == nil {
+			return os.NewFile(uintptr(fds[0]), "privsep-client"), os.NewFile(uintptr(fds[1]), "privsep-server"), nil
+		}
+	}
+	return nil, nil, err
 }
 
 func stderrLogger(r io.Reader) {
@@ -152,32 +336,87 @@ func stderrLogger(r io.Reader) {
 			}
 			return
 		}
-		log.Printf("privsep: %v", line)
+		logging.Infof("privsep: %s", strings.TrimSuffix(line, "\n"))
 	}
 }
 
-func watchdog(cmd *exec.Cmd, waited chan<- any) {
-	defer close(waited)
-	if err := cmd.Wait(); err != nil {
-		log.Fatalf("Privsep server exited with error: %v", err)
-	}
+// Keeps the privileged server running, and shuts it down.
+type supervisor struct {
+	client *client.Client
+	waited chan any // Closed when the watchdog stops.
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	started  time.Time
+	stopping bool
 }
 
-func shutdownFunc(cmd *exec.Cmd, privsepClient *client.Client, waited <-chan any) func() {
-	return func() {
-		if err := privsepClient.Shutdown(); err != nil {
-			log.Printf("Error shutting down privsep: %v", err)
-			if err := cmd.Process.Kill(); err != nil {
-				log.Printf("Error killing privsep: %v", err)
-			}
+// Waits for the server to exit, and restarts it unless it's being shut down.
+// A server that keeps exiting soon after starting is given up on.
+func (s *supervisor) watchdog() {
+	defer close(s.waited)
+	for {
+		s.mu.Lock()
+		cmd := s.cmd
+		s.mu.Unlock()
+
+		err := cmd.Wait()
+
+		s.mu.Lock()
+		stopping := s.stopping
+		uptime := time.Since(s.started)
+		s.mu.Unlock()
+		if stopping {
+			return
+		}
+		if uptime < minServerUptime {
+			log.Fatalf("Privsep server exited after %v: %v", uptime, err)
 		}
-		if err := privsepClient.Close(); err != nil {
-			log.Printf("Error closing privsep client: %v", err)
+		log.Printf("Privsep server exited; restarting: %v", err)
+		updateStatus(func(s *Status) { s.Alive = false })
+
+		cmd, clientIn, clientOut, err := startServer()
+		if err != nil {
+			log.Fatalf("Error restarting privileged server: %v", err)
 		}
-		<-waited
+		s.mu.Lock()
+		s.cmd = cmd
+		s.started = time.Now()
+		s.mu.Unlock()
+		if err := s.client.Reconnect(clientIn, clientOut); err != nil {
+			log.Fatalf("Error restarting privileged server: %v", err)
+		}
+		updateStatus(func(s *Status) {
+			s.Alive = true
+			s.Restarts++
+		})
 	}
 }
 
+func (s *supervisor) shutdown() {
+	s.mu.Lock()
+	s.stopping = true
+	cmd := s.cmd
+	s.mu.Unlock()
+	if err := s.client.Shutdown(); err != nil {
+		log.Printf("Error shutting down privsep: %v", err)
+		if err := cmd.Process.Kill(); err != nil {
+			log.Printf("Error killing privsep: %v", err)
+		}
+	}
+	if err := s.client.Close(); err != nil {
+		log.Printf("Error closing privsep client: %v", err)
+	}
+	if st := s.client.QueueStats(); st.Dropped > 0 {
+		log.Printf("Privsep server fell behind: dropped %d of %d pings (at most %d waiting)", st.Dropped, st.Queued, st.MaxDepth)
+	}
+	if st := s.client.ReplyStats(); st.Dropped > 0 {
+		log.Printf("Connections fell behind on replies: dropped %d of %d (at most %d waiting)", st.Dropped, st.Delivered, st.MaxDepth)
+	}
+	<-s.waited
+	updateStatus(func(s *Status) { s.Alive = false })
+}
+
 func dropPrivileges() error {
 	uid := syscall.Getuid()
 	euid := syscall.Geteuid()
@@ -189,6 +428,20 @@ func dropPrivileges() error {
 		return nil
 	}
 
+	// The groups go first, while there are still privileges to change them
+	// with. Nothing here needs the supplementary groups, so they're cleared
+	// rather than kept, and the group is set back to the real one in case it
+	// was changed by setgid as well.
+	gid := syscall.Getgid()
+	if euid == 0 {
+		if err := syscall.Setgroups(nil); err != nil {
+			return fmt.Errorf("setgroups: %v", err)
+		}
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %v", err)
+	}
+
 	// Give up privileges.
 	if err := syscall.Setuid(uid); err != nil {
 		return fmt.Errorf("setuid: %v", err)
@@ -199,10 +452,24 @@ func dropPrivileges() error {
 		return fmt.Errorf("failed to drop privileges: uid (%d) != euid (%d)", syscall.Getuid(), syscall.Geteuid())
 	}
 
+	if syscall.Getgid() != syscall.Getegid() {
+		return fmt.Errorf("failed to drop privileges: gid (%d) != egid (%d)", syscall.Getgid(), syscall.Getegid())
+	}
+	if euid == 0 {
+		if groups, err := syscall.Getgroups(); err != nil || len(groups) > 0 {
+			return fmt.Errorf("failed to clear supplementary groups: %v %v", groups, err)
+		}
+	}
+
 	// Try to regain root and return an error if that was possible.
 	if err := syscall.Seteuid(0); err == nil {
 		return fmt.Errorf("unexpectedly able to regain root")
 	}
+	if gid != 0 {
+		if err := syscall.Setegid(0); err == nil {
+			return fmt.Errorf("unexpectedly able to regain the root group")
+		}
+	}
 
 	// One last check to make sure privileges are truly gone.
 	if syscall.Getuid() != syscall.Geteuid() {
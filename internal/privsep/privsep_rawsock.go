@@ -2,6 +2,12 @@
 
 package privsep
 
+import "github.com/pcekm/vasily/internal/logging"
+
 func usePrivsep() bool {
+	if inProcessRawSockets() {
+		logging.Infof("Raw sockets are allowed by CAP_NET_RAW; running without privsep.")
+		return false
+	}
 	return true
 }
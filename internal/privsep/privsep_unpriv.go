@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+
+	"github.com/pcekm/vasily/internal/logging"
 )
 
 func usePrivsep() bool {
@@ -20,5 +22,11 @@ using something like:
 		os.Exit(1)
 	}
 
+	// Pings are sent on unprivileged ICMP sockets, which nothing else can
+	// stand in for in this build. Keep going, since the udp, tcp and other
+	// backends still work.
+	if !UnprivilegedPing() {
+		logging.Warnf("Unprivileged ICMP isn't allowed for this user; see the net.ipv4.ping_group_range sysctl.")
+	}
 	return false
 }
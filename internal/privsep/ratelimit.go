@@ -0,0 +1,87 @@
+package privsep
+
+import (
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/privsep/messages"
+)
+
+var (
+	// The default and loosest allowed rate limit for a single connection.
+	// This matches the limit the ICMP backend enforces on each stream, times
+	// the number of streams that may share a connection.
+	defaultConnRateLimit = messages.RateLimit{
+		Interval: time.Second / backend.MaxStreams,
+		Burst:    5 * backend.MaxStreams,
+	}
+
+	// The default and loosest allowed rate limit across all connections.
+	defaultGlobalRateLimit = messages.RateLimit{Interval: time.Millisecond, Burst: 100}
+)
+
+// Tightens a rate limit. Zero fields in req leave the corresponding field of
+// cur unchanged. Nothing is allowed to be looser than max.
+func tighten(cur, req, max messages.RateLimit) messages.RateLimit {
+	if req.Interval != 0 {
+		cur.Interval = req.Interval
+	}
+	if req.Burst != 0 {
+		cur.Burst = req.Burst
+	}
+	cur.Interval = maxDuration(cur.Interval, max.Interval)
+	cur.Burst = min(cur.Burst, max.Burst)
+	return cur
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// A token bucket rate limiter. Written by hand, because this package doesn't
+// import 3rd party packages. Not safe for concurrent use.
+type tokenBucket struct {
+	limit  messages.RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// Creates a new token bucket that starts out full.
+func newTokenBucket(limit messages.RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+// Adds the tokens accumulated since the last call.
+func (b *tokenBucket) refill(now time.Time) {
+	if !now.After(b.last) {
+		return
+	}
+	b.tokens += float64(now.Sub(b.last)) / float64(b.limit.Interval)
+	b.tokens = min(b.tokens, float64(b.limit.Burst))
+	b.last = now
+}
+
+// Changes the limit. Tokens in excess of the new burst size are discarded.
+func (b *tokenBucket) setLimit(limit messages.RateLimit, now time.Time) {
+	b.refill(now)
+	b.limit = limit
+	b.tokens = min(b.tokens, float64(limit.Burst))
+}
+
+// Returns true if a token is available without taking it.
+func (b *tokenBucket) ready(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+// Takes a token. Call only after ready returns true.
+func (b *tokenBucket) take() {
+	b.tokens--
+}
@@ -0,0 +1,104 @@
+package privsep
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/privsep/messages"
+)
+
+func TestTighten(t *testing.T) {
+	max := messages.RateLimit{Interval: time.Second, Burst: 5}
+	cases := []struct {
+		Name string
+		Cur  messages.RateLimit
+		Req  messages.RateLimit
+		Want messages.RateLimit
+	}{
+		{Name: "Query", Cur: max, Want: max},
+		{
+			Name: "Tighter",
+			Cur:  max,
+			Req:  messages.RateLimit{Interval: 2 * time.Second, Burst: 2},
+			Want: messages.RateLimit{Interval: 2 * time.Second, Burst: 2},
+		},
+		{
+			Name: "Looser",
+			Cur:  messages.RateLimit{Interval: 2 * time.Second, Burst: 2},
+			Req:  messages.RateLimit{Interval: time.Millisecond, Burst: 100},
+			Want: max,
+		},
+		{
+			Name: "IntervalOnly",
+			Cur:  messages.RateLimit{Interval: 2 * time.Second, Burst: 2},
+			Req:  messages.RateLimit{Interval: 3 * time.Second},
+			Want: messages.RateLimit{Interval: 3 * time.Second, Burst: 2},
+		},
+		{
+			Name: "BurstOnly",
+			Cur:  messages.RateLimit{Interval: 2 * time.Second, Burst: 2},
+			Req:  messages.RateLimit{Burst: 1},
+			Want: messages.RateLimit{Interval: 2 * time.Second, Burst: 1},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got := tighten(c.Cur, c.Req, max)
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong limit (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := newTokenBucket(messages.RateLimit{Interval: time.Second, Burst: 2}, start)
+
+	// Check whether a token is available at the given offset from start, and
+	// takes it if so.
+	try := func(offset time.Duration) bool {
+		if b.ready(start.Add(offset)) {
+			b.take()
+			return true
+		}
+		return false
+	}
+
+	steps := []struct {
+		Offset time.Duration
+		Want   bool
+	}{
+		{Offset: 0, Want: true},
+		{Offset: 0, Want: true},
+		{Offset: 0, Want: false},
+		{Offset: 500 * time.Millisecond, Want: false},
+		{Offset: time.Second, Want: true},
+		{Offset: time.Second, Want: false},
+		// Refills no further than the burst size.
+		{Offset: 10 * time.Second, Want: true},
+		{Offset: 10 * time.Second, Want: true},
+		{Offset: 10 * time.Second, Want: false},
+		// Time going backwards doesn't add tokens.
+		{Offset: 0, Want: false},
+	}
+	for i, s := range steps {
+		if got := try(s.Offset); got != s.Want {
+			t.Errorf("Step %d at %v: got %v, want %v", i, s.Offset, got, s.Want)
+		}
+	}
+}
+
+func TestTokenBucket_SetLimit(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := newTokenBucket(messages.RateLimit{Interval: time.Second, Burst: 5}, start)
+	b.setLimit(messages.RateLimit{Interval: time.Second, Burst: 1}, start)
+	if !b.ready(start) {
+		t.Fatalf("No token available after shrinking burst.")
+	}
+	b.take()
+	if b.ready(start) {
+		t.Errorf("Excess tokens kept after shrinking burst.")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package sandbox
+
+import "errors"
+
+// Chroot changes the root directory. It's not supported on this platform.
+func Chroot(dir string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Only directories that would be rejected are tried, since a test that
+// succeeded would change the root of the test process.
+func TestChrootRejects(t *testing.T) {
+	full := t.TempDir()
+	if err := os.WriteFile(filepath.Join(full, "file"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	writable := t.TempDir()
+	if err := os.Chmod(writable, 0o777); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		dir  string
+	}{
+		{name: "Missing", dir: filepath.Join(full, "missing")},
+		{name: "NotDir", dir: filepath.Join(full, "file")},
+		{name: "NotEmpty", dir: full},
+		{name: "Writable", dir: writable},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := Chroot(c.dir); err == nil {
+				t.Errorf("Chroot(%q) succeeded (want error)", c.dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package sandbox
+
+import "syscall"
+
+// The syscall package doesn't have RLIMIT_NPROC. It's the same on all these
+// systems.
+const rlimitNproc = 7
+
+// RLIMIT_NPROC counts processes here, not threads, so capping it at zero
+// keeps the server from starting any without getting in the Go runtime's way.
+var limits = []limit{
+	{name: "RLIMIT_NOFILE", resource: syscall.RLIMIT_NOFILE, max: maxOpenFiles},
+	{name: "RLIMIT_NPROC", resource: rlimitNproc, max: 0},
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package sandbox
+
+// LimitResources caps the resources the current process may use. It does
+// nothing on this platform.
+func LimitResources() error {
+	return nil
+}
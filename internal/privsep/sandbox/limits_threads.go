@@ -0,0 +1,14 @@
+//go:build unix && !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package sandbox
+
+import "syscall"
+
+// RLIMIT_NPROC isn't capped on Linux, where it counts every thread of every
+// process with the same real uid. The Go runtime starts threads as it needs
+// them, and how many the user's other processes have says nothing about how
+// many the server needs. The seccomp filter keeps it from starting processes
+// instead. Other systems are assumed to count the same way.
+var limits = []limit{
+	{name: "RLIMIT_NOFILE", resource: syscall.RLIMIT_NOFILE, max: maxOpenFiles},
+}
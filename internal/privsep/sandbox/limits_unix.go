@@ -0,0 +1,46 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Most files the server may have open at once. Each connection it opens for
+// the client takes one.
+const maxOpenFiles = 4096
+
+// A cap on a resource.
+type limit struct {
+	name     string
+	resource int
+	max      uint64
+}
+
+// LimitResources caps the resources the current process may use. Limits that
+// are already lower are left alone. The hard limits are lowered too, so the
+// caps can't be lifted again.
+func LimitResources() error {
+	for _, l := range limits {
+		var rl syscall.Rlimit
+		if err := syscall.Getrlimit(l.resource, &rl); err != nil {
+			return fmt.Errorf("getrlimit %s: %v", l.name, err)
+		}
+		rl.Cur = capAt(rl.Cur, l.max)
+		rl.Max = capAt(rl.Max, l.max)
+		if err := syscall.Setrlimit(l.resource, &rl); err != nil {
+			return fmt.Errorf("setrlimit %s: %v", l.name, err)
+		}
+	}
+	return nil
+}
+
+// Returns the lower of a limit and max. Limits are signed on some systems,
+// where the infinite limit is negative, and compares as the highest.
+func capAt[T int64 | uint64](v T, max uint64) T {
+	if uint64(v) > max {
+		return T(max)
+	}
+	return v
+}
@@ -0,0 +1,45 @@
+/*
+Package sandbox restricts what the privileged server process can do, beyond
+running it under a separate uid.
+
+[Apply] is called once, after the server has started and before it reads any
+requests. It can't be undone. What it does depends on the OS:
+
+  - Linux (amd64 and arm64): Installs a seccomp filter on every thread that
+    allows the system calls needed to open, use and close ping sockets, plus
+    those the Go runtime needs. Some arguments are checked too: sockets may
+    only be IPv4, IPv6, packet or routing sockets, clone may only start
+    threads, and ioctl isn't allowed at all. Anything else, such as opening
+    files or running programs, fails with EPERM. System calls made through a
+    different ABI kill the process. Network namespaces opened before the
+    filter was installed can still be entered, so connections can be opened
+    in them.
+
+  - OpenBSD: Pledges to use only stdio, networking, routing information and
+    setuid, and unveils no part of the filesystem.
+
+  - Anywhere else, including Linux on other architectures: Nothing.
+
+Apply returns an error wrapping [errors.ErrUnsupported] if there's no sandbox
+on this platform, or the kernel lacks what it needs, in which case the server
+may carry on without it. Any other
+error should stop the server.
+
+Before that, [LimitResources] caps the resources the server may use, and
+[Chroot] can change its root to an empty directory. Both need to come before
+[Apply], which may forbid the system calls they make.
+
+Sockets have to stay openable, since the server opens a new one for each
+connection the client asks for.
+
+Installing a seccomp filter means handing the kernel a pointer to it, which
+can't be done without [unsafe]. That single conversion, in sandbox_linux.go, is
+the one exception to the privsep package's no-unsafe rule.
+
+The filter is written with the syscall package alone, like the rest of privsep.
+The exception is OpenBSD, where pledge and unveil come from
+golang.org/x/sys/unix. The standard library doesn't wrap them, and OpenBSD
+doesn't allow system calls from anywhere but libc, so there's no other way to
+make them.
+*/
+package sandbox
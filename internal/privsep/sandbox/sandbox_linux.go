@@ -0,0 +1,260 @@
+//go:build amd64 || arm64
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// Kernel constants the syscall package doesn't have.
+const (
+	prSetNoNewPrivs = 38
+
+	seccompSetModeFilter  = 1
+	seccompFilterFlagSync = 1
+
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// Offsets of fields in struct seccomp_data. Arguments are 64 bits, with the
+// low half first on little-endian architectures.
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// The flags clone may be called with. Anything else, such as starting a
+// process, is refused.
+const (
+	// The Go runtime's threads. On amd64, it adds CLONE_SETTLS for those
+	// that run Go code.
+	cloneThreadFlags = syscall.CLONE_VM | syscall.CLONE_FS | syscall.CLONE_FILES |
+		syscall.CLONE_SIGHAND | syscall.CLONE_SYSVSEM | syscall.CLONE_THREAD
+	cloneThreadTLSFlags = cloneThreadFlags | syscall.CLONE_SETTLS
+
+	// Threads started by glibc's pthread_create, which the Go runtime uses
+	// instead when cgo is enabled.
+	clonePthreadFlags = cloneThreadTLSFlags | syscall.CLONE_PARENT_SETTID |
+		syscall.CLONE_CHILD_CLEARTID
+)
+
+// System calls allowed on every architecture, whatever their arguments.
+// System calls with checked arguments are in argRules instead.
+var allowedSyscalls = []uintptr{
+	// Sockets.
+	syscall.SYS_BIND,
+	syscall.SYS_CONNECT,
+	syscall.SYS_GETSOCKNAME,
+	syscall.SYS_GETPEERNAME,
+	syscall.SYS_SETSOCKOPT,
+	syscall.SYS_GETSOCKOPT,
+	syscall.SYS_SENDTO,
+	syscall.SYS_SENDMSG,
+	sysSendmmsg,
+	syscall.SYS_RECVFROM,
+	syscall.SYS_RECVMSG,
+	syscall.SYS_SHUTDOWN,
+	syscall.SYS_CLOSE,
+
+	// Reading requests and writing replies and logs.
+	syscall.SYS_READ,
+	syscall.SYS_WRITE,
+	syscall.SYS_WRITEV,
+	syscall.SYS_FCNTL,
+
+	// The network poller.
+	syscall.SYS_EPOLL_CREATE1,
+	syscall.SYS_EPOLL_CTL,
+	syscall.SYS_EPOLL_PWAIT,
+	syscall.SYS_EVENTFD2,
+	syscall.SYS_PIPE2,
+
+	// Privilege drops.
+	syscall.SYS_GETUID,
+	syscall.SYS_GETEUID,
+	syscall.SYS_GETGID,
+	syscall.SYS_GETEGID,
+	syscall.SYS_SETUID,
+	syscall.SYS_SETGID,
+	syscall.SYS_SETRESUID,
+	syscall.SYS_SETRESGID,
+	syscall.SYS_SETGROUPS,
+
+	// Opening connections in other network namespaces. Only those opened
+	// before the sandbox was applied can be entered.
+	sysSetns,
+
+	// The Go runtime.
+	syscall.SYS_MMAP,
+	syscall.SYS_MUNMAP,
+	syscall.SYS_MPROTECT,
+	syscall.SYS_MADVISE,
+	syscall.SYS_BRK,
+	syscall.SYS_RT_SIGACTION,
+	syscall.SYS_RT_SIGPROCMASK,
+	syscall.SYS_RT_SIGRETURN,
+	syscall.SYS_SIGALTSTACK,
+	syscall.SYS_EXIT,
+	syscall.SYS_EXIT_GROUP,
+	syscall.SYS_FUTEX,
+	syscall.SYS_SCHED_YIELD,
+	syscall.SYS_SCHED_GETAFFINITY,
+	syscall.SYS_NANOSLEEP,
+	syscall.SYS_CLOCK_GETTIME,
+	syscall.SYS_CLOCK_NANOSLEEP,
+	syscall.SYS_GETTIMEOFDAY,
+	syscall.SYS_GETPID,
+	syscall.SYS_GETTID,
+	syscall.SYS_TGKILL,
+	sysGetrandom,
+
+	// Threads started by glibc. It aborts if a new thread can't register
+	// its restartable sequence.
+	syscall.SYS_SET_ROBUST_LIST,
+	sysRseq,
+}
+
+// An argument of a system call, and the values it may have.
+type argCheck struct {
+	arg    int
+	values []uint32
+}
+
+// Allows a system call if all its checks pass. A system call may have more
+// than one rule, in which case any of them may pass.
+type argRule struct {
+	nr     uintptr
+	checks []argCheck
+}
+
+// System calls whose arguments are checked. ioctl isn't allowed at all: the
+// BPF requests are only made by the ARP backend on the BSDs, and interface
+// lookups on Linux go through netlink.
+var argRules = []argRule{
+	// Ping sockets, raw sockets and the ARP backend's packet socket.
+	{nr: syscall.SYS_SOCKET, checks: []argCheck{
+		{arg: 0, values: []uint32{syscall.AF_INET, syscall.AF_INET6, syscall.AF_PACKET}},
+	}},
+	// Listing interfaces and their addresses, which the ARP backend does
+	// before each probe.
+	{nr: syscall.SYS_SOCKET, checks: []argCheck{
+		{arg: 0, values: []uint32{syscall.AF_NETLINK}},
+		{arg: 2, values: []uint32{syscall.NETLINK_ROUTE}},
+	}},
+	// Starting threads, but not processes.
+	{nr: syscall.SYS_CLONE, checks: []argCheck{
+		{arg: 0, values: []uint32{cloneThreadFlags, cloneThreadTLSFlags, clonePthreadFlags}},
+	}},
+}
+
+// Apply installs a seccomp filter on every thread of the current process.
+func Apply() error {
+	prog := filter()
+
+	// No new privileges is required to install a filter without
+	// CAP_SYS_ADMIN. It only applies to the calling thread, which must be the
+	// one to install the filter. Synchronizing the filter to the other
+	// threads sets it on them too.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		return fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %v", errno)
+	}
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	_, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagSync, uintptr(unsafe.Pointer(&fprog)))
+	runtime.KeepAlive(prog)
+	if errno == syscall.ENOSYS {
+		return fmt.Errorf("seccomp: %w", errors.ErrUnsupported)
+	}
+	if errno != 0 {
+		return fmt.Errorf("seccomp: %v", errno)
+	}
+	return nil
+}
+
+// Returns a BPF statement.
+func stmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+// Returns a BPF conditional jump. jt and jf count the instructions skipped.
+func jump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// Returns an instruction loading the system call number.
+func loadNr() syscall.SockFilter {
+	return stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offsetNr)
+}
+
+// Assembles the seccomp filter.
+func filter() []syscall.SockFilter {
+	prog := []syscall.SockFilter{
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offsetArch),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, auditArch, 1, 0),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProcess),
+
+		// clone3 takes its flags in a struct, where the filter can't see
+		// them. Claiming it doesn't exist makes glibc fall back to clone.
+		loadNr(),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, sysClone3, 0, 1),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.ENOSYS)),
+	}
+	for _, r := range argRules {
+		prog = append(prog, r.insts()...)
+	}
+	allowed := slices.Concat(allowedSyscalls, archSyscalls)
+	prog = append(prog, loadNr())
+	for i, nr := range allowed {
+		// Jump past the remaining comparisons and the deny to the allow.
+		prog = append(prog, jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(nr), uint8(len(allowed)-i), 0))
+	}
+	return append(prog,
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)),
+		stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+	)
+}
+
+// Returns the instructions for a rule. They allow the system call if it
+// passes, and otherwise fall through to whatever comes next.
+func (r argRule) insts() []syscall.SockFilter {
+	// Built back to front, so each check knows how far it is to the end,
+	// where a failed check goes.
+	body := []syscall.SockFilter{stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow)}
+	for _, c := range slices.Backward(r.checks) {
+		body = append(c.insts(len(body)), body...)
+	}
+	return append([]syscall.SockFilter{
+		loadNr(),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, uint32(r.nr), 0, uint8(len(body))),
+	}, body...)
+}
+
+// Returns the instructions for a check, followed by rest more instructions
+// that run if it passes. The high half of the argument has to be zero, and
+// the low half one of the values.
+func (c argCheck) insts(rest int) []syscall.SockFilter {
+	off := uint32(offsetArgs + 8*c.arg)
+	n := len(c.values)
+	insts := []syscall.SockFilter{
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, off+4),
+		jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 0, 0, uint8(1+n+rest)),
+		stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, off),
+	}
+	for i, v := range c.values {
+		var jf uint8
+		if i == n-1 {
+			jf = uint8(rest)
+		}
+		insts = append(insts, jump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, v, uint8(n-1-i), jf))
+	}
+	return insts
+}
@@ -0,0 +1,95 @@
+//go:build amd64 || arm64
+
+package sandbox
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Set in the environment of the child process that gets sandboxed, since a
+// sandbox can't be removed once applied.
+const childEnv = "VASILY_SANDBOX_TEST_CHILD"
+
+func TestApply(t *testing.T) {
+	if os.Getenv(childEnv) != "" {
+		sandboxedChild(t)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApply$", "-test.v")
+	cmd.Env = append(os.Environ(), childEnv+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("Sandboxed process failed: %v\n%s", err, out)
+	}
+}
+
+func sandboxedChild(t *testing.T) {
+	if err := Apply(); err != nil {
+		t.Fatalf("Apply error: %v", err)
+	}
+
+	if f, err := os.Open("/"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Open error: %v (want %v)", err, syscall.EPERM)
+		if f != nil {
+			f.Close()
+		}
+	}
+	if err := exec.Command("/bin/true").Run(); err == nil {
+		t.Errorf("Sandboxed process ran another program.")
+	}
+
+	// Arguments are checked too.
+	if _, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Socket(AF_UNIX) error: %v (want %v)", err, syscall.EPERM)
+	}
+	if _, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_NETFILTER); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Socket(AF_NETLINK, NETLINK_NETFILTER) error: %v (want %v)", err, syscall.EPERM)
+	}
+	// A thread outside the thread group is invalid, which is what it fails
+	// with if the filter lets it through.
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CLONE, syscall.CLONE_THREAD, 0, 0); errno != syscall.EPERM {
+		t.Errorf("clone error: %v (want %v)", errno, syscall.EPERM)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, 0, syscall.TCGETS, 0); errno != syscall.EPERM {
+		t.Errorf("ioctl error: %v (want %v)", errno, syscall.EPERM)
+	}
+	if _, err := net.Interfaces(); err != nil {
+		t.Errorf("Interfaces error: %v", err)
+	}
+
+	// Threads still start. Each goroutine holds on to one of its own until
+	// it returns.
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.LockOSThread()
+			time.Sleep(10 * time.Millisecond)
+		}()
+	}
+	wg.Wait()
+
+	// Sockets still work.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo([]byte("hello"), conn.LocalAddr()); err != nil {
+		t.Errorf("WriteTo error: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	if n, _, err := conn.ReadFrom(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("ReadFrom = %q, %v (want %q)", buf[:n], err, "hello")
+	}
+}
@@ -0,0 +1,22 @@
+package sandbox
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apply restricts the current process with unveil and pledge.
+func Apply() error {
+	// Unveiling "/" with no permissions hides the whole filesystem.
+	if err := unix.Unveil("/", ""); err != nil {
+		return fmt.Errorf("unveil: %v", err)
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return fmt.Errorf("unveil: %v", err)
+	}
+	if err := unix.PledgePromises("stdio inet route id sendfd"); err != nil {
+		return fmt.Errorf("pledge: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !openbsd && !(linux && (amd64 || arm64))
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// Apply restricts the current process. There's no sandbox on this platform,
+// so it only returns an error wrapping [errors.ErrUnsupported].
+func Apply() error {
+	return fmt.Errorf("no sandbox on %s/%s: %w", runtime.GOOS, runtime.GOARCH, errors.ErrUnsupported)
+}
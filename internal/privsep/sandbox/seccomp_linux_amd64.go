@@ -0,0 +1,23 @@
+package sandbox
+
+import "syscall"
+
+const auditArch = 0xc000003e // AUDIT_ARCH_X86_64
+
+// System calls the syscall package doesn't have numbers for.
+const (
+	sysSendmmsg  = 307
+	sysSetns     = 308
+	sysSeccomp   = 317
+	sysGetrandom = 318
+	sysRseq      = 334
+	sysClone3    = 435
+)
+
+// Older system calls that arm64 doesn't have, but that the Go runtime may use
+// on amd64.
+var archSyscalls = []uintptr{
+	syscall.SYS_ARCH_PRCTL,
+	syscall.SYS_EPOLL_WAIT,
+	syscall.SYS_POLL,
+}
@@ -0,0 +1,16 @@
+package sandbox
+
+import "syscall"
+
+const auditArch = 0xc00000b7 // AUDIT_ARCH_AARCH64
+
+const (
+	sysSendmmsg  = syscall.SYS_SENDMMSG
+	sysSetns     = syscall.SYS_SETNS
+	sysSeccomp   = syscall.SYS_SECCOMP
+	sysGetrandom = syscall.SYS_GETRANDOM
+	sysRseq      = 293
+	sysClone3    = 435
+)
+
+var archSyscalls []uintptr
@@ -4,12 +4,15 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/privsep/messages"
@@ -18,22 +21,47 @@ import (
 
 // Handles messages from [privClient] and issues replies.
 type Server struct {
-	osExit func(int) // For test injection
+	osExit func(int)        // For test injection
+	now    func() time.Time // For test injection
+	getuid func() int       // For test injection
 	conns  map[messages.ConnectionID]backend.Conn
 	nextId messages.ConnectionID
 
-	in *os.File
+	// Rate limits on outgoing pings. These keep a compromised client from
+	// turning the server into a flood tool. All the streams of a connection
+	// share its limiter, so picking new ones doesn't get around it. Flood
+	// connections, which only root may open, have no limiters and don't count
+	// against the global limit.
+	connLimit    messages.RateLimit
+	connLimiters map[messages.ConnectionID]*tokenBucket
+	globalLimit  *tokenBucket
+
+	// Set after a Hello message with a matching protocol version.
+	greeted bool
+
+	in io.ReadCloser
 
 	mu  sync.Mutex
-	out *os.File
+	out io.WriteCloser
+
+	// Set if the client is connected with a socket that can pass files.
+	sock *messages.SocketConn
 }
 
-func newServer() *Server {
+// Creates a server that reads requests from in and writes replies to out.
+func newServer(in io.ReadCloser, out io.WriteCloser) *Server {
+	sock, _ := in.(*messages.SocketConn)
 	return &Server{
-		in:     os.Stdin,
-		out:    os.Stdout,
-		osExit: os.Exit,
-		conns:  make(map[messages.ConnectionID]backend.Conn),
+		in:           in,
+		out:          out,
+		sock:         sock,
+		osExit:       os.Exit,
+		now:          time.Now,
+		getuid:       os.Getuid,
+		conns:        make(map[messages.ConnectionID]backend.Conn),
+		connLimit:    defaultConnRateLimit,
+		connLimiters: make(map[messages.ConnectionID]*tokenBucket),
+		globalLimit:  newTokenBucket(defaultGlobalRateLimit, time.Now()),
 	}
 }
 
@@ -53,8 +81,7 @@ func (s *Server) run() {
 }
 
 // Reads from connection in a loop. Exits when the connection is closed.
-func (s *Server) readLoop(id messages.ConnectionID) {
-	conn := s.connFor(id)
+func (s *Server) readLoop(id messages.ConnectionID, conn backend.Conn) {
 	for {
 		pkt, peer, err := conn.ReadFrom(context.TODO())
 		if err != nil {
@@ -65,7 +92,9 @@ func (s *Server) readLoop(id messages.ConnectionID) {
 			if strings.Contains(err.Error(), "closed network connection") {
 				return
 			}
-			log.Panicf("Error reading from connection: %v", err)
+			log.Printf("Error reading from connection %v: %v", id, err)
+			s.write(messages.Error{ID: id, Code: messages.ErrorReadFailed, Text: err.Error()})
+			return
 		}
 		msg := messages.PingReply{
 			ID:     id,
@@ -95,12 +124,13 @@ func (s *Server) Close() error {
 	return errors.Join(errs...)
 }
 
-func (s *Server) connFor(id messages.ConnectionID) backend.Conn {
-	conn, ok := s.conns[id]
-	if !ok {
-		log.Panicf("No ICMP connection for %d", id)
+// Writes a message to the client along with an open file. Panics on error.
+func (s *Server) writeFile(msg messages.Message, f *os.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sock.WriteWithFile(msg, f); err != nil {
+		log.Panicf("Error writing message: %v", err)
 	}
-	return conn
 }
 
 // Writes a message to the client. Panics on error.
@@ -114,7 +144,14 @@ func (s *Server) write(msg messages.Message) {
 }
 
 func (s *Server) handleMessage(msg messages.Message) {
+	if _, ok := msg.(messages.Hello); !ok && !s.greeted {
+		log.Panicf("Expected Hello message; got: %v", msg)
+	}
 	switch msg := msg.(type) {
+	case messages.Hello:
+		s.handleHello(msg)
+	case messages.HelloReply:
+		s.handleHelloReply(msg)
 	case messages.Shutdown:
 		s.handleShutdown(msg)
 	case messages.PrivilegeDrop:
@@ -127,13 +164,43 @@ func (s *Server) handleMessage(msg messages.Message) {
 		s.handleCloseConnection(msg)
 	case messages.SendPing:
 		s.handleSendPing(msg)
+	case messages.SendPingBatch:
+		s.handleSendPingBatch(msg)
 	case messages.PingReply:
 		s.handlePingReply(msg)
+	case messages.SetRateLimit:
+		s.handleSetRateLimit(msg)
+	case messages.RateLimitReply:
+		s.handleRateLimitReply(msg)
+	case messages.Error:
+		s.handleError(msg)
+	case messages.OpenSocket:
+		s.handleOpenSocket(msg)
+	case messages.SocketReply:
+		s.handleSocketReply(msg)
 	default:
 		log.Panicf("Invalid message: %v", msg)
 	}
 }
 
+func (s *Server) handleHello(msg messages.Hello) {
+	if s.greeted {
+		log.Panicf("Unexpected message: %v", msg)
+	}
+	// Always reply, so the client can report a mismatch clearly.
+	s.write(messages.HelloReply{Version: messages.ProtocolVersion})
+	if msg.Version != messages.ProtocolVersion {
+		log.Printf("Protocol version mismatch: client %d, server %d", msg.Version, messages.ProtocolVersion)
+		s.osExit(1)
+		return
+	}
+	s.greeted = true
+}
+
+func (s *Server) handleHelloReply(msg messages.HelloReply) {
+	log.Panicf("Unexpected message: %v", msg)
+}
+
 func (s *Server) handleShutdown(messages.Shutdown) {
 	s.osExit(0)
 }
@@ -145,16 +212,51 @@ func (s *Server) handlePrivilegeDrop(messages.PrivilegeDrop) {
 }
 
 func (s *Server) handleOpenConnection(msg messages.OpenConnection) {
-	conn, err := backend.New(msg.Backend, msg.IPVer)
+	// The real uid is the user who started the server. Since the server may
+	// run setuid, the effective uid says nothing about who that was.
+	if msg.Flood && s.getuid() != 0 {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: "flood mode requires root"})
+		return
+	}
+	// Setting a mark normally needs CAP_NET_ADMIN, and the server can enter
+	// any namespace, so neither is done for anyone else.
+	if msg.SourceMark != 0 && s.getuid() != 0 {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: "fwmark requires root"})
+		return
+	}
+	if msg.SourceNetns != "" && s.getuid() != 0 {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: "netns requires root"})
+		return
+	}
+	opts := []backend.ConnOption{backend.SourceOption{Interface: msg.SourceInterface, Addr: msg.SourceAddr, Mark: msg.SourceMark, Netns: msg.SourceNetns}}
+	if msg.Flood {
+		opts = append(opts, backend.FloodOption{})
+	}
+	if msg.FlowLabel != 0 {
+		opts = append(opts, backend.FlowLabelOption{Label: msg.FlowLabel})
+	}
+	if msg.RecvBuffer > 0 {
+		// Only root may pin more kernel memory than the system allows.
+		opts = append(opts, backend.RecvBufferOption{
+			Bytes: min(msg.RecvBuffer, backend.MaxRecvBuffer),
+			Force: s.getuid() == 0,
+		})
+	}
+	conn, err := backend.New(msg.Backend, msg.IPVer, opts...)
 	if err != nil {
-		log.Panicf("Error opening connection: %v", err)
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: err.Error()})
+		return
 	}
 	id := s.nextId
 	s.nextId++
 	s.conns[id] = conn
-	go s.readLoop(id)
+	if !msg.Flood {
+		s.connLimiters[id] = newTokenBucket(s.connLimit, s.now())
+	}
+	go s.readLoop(id, conn)
 	s.write(messages.OpenConnectionReply{
-		ID: id,
+		Request: msg.Request,
+		ID:      id,
 	})
 }
 
@@ -163,24 +265,120 @@ func (s *Server) handleOpenConnectionReply(msg messages.OpenConnectionReply) {
 }
 
 func (s *Server) handleCloseConnection(msg messages.CloseConnection) {
-	conn := s.connFor(msg.ID)
-	if err := conn.Close(); err != nil {
-		log.Panicf("Error closing connection: %v", err)
+	conn, ok := s.conns[msg.ID]
+	if !ok {
+		s.write(messages.Error{Request: msg.Request, ID: msg.ID, Code: messages.ErrorCloseFailed, Text: "no such connection"})
+		return
 	}
+	// The connection is forgotten even if closing fails, since there's
+	// nothing more that can be done with it.
 	delete(s.conns, msg.ID)
+	delete(s.connLimiters, msg.ID)
+	if err := conn.Close(); err != nil {
+		s.write(messages.Error{Request: msg.Request, ID: msg.ID, Code: messages.ErrorCloseFailed, Text: err.Error()})
+		return
+	}
+	s.write(messages.CloseConnectionReply{Request: msg.Request, ID: msg.ID})
 }
 
 func (s *Server) handleSendPing(msg messages.SendPing) {
-	conn := s.connFor(msg.ID)
+	conn, ok := s.conns[msg.ID]
+	if !ok {
+		s.write(messages.Error{ID: msg.ID, Code: messages.ErrorSendFailed, Text: "no such connection"})
+		return
+	}
+	if connLimit, ok := s.connLimiters[msg.ID]; ok {
+		now := s.now()
+		if !connLimit.ready(now) || !s.globalLimit.ready(now) {
+			s.write(messages.Error{
+				ID:   msg.ID,
+				Code: messages.ErrorRateLimited,
+				Text: fmt.Sprintf("ping to %v dropped: rate limit exceeded", msg.Addr),
+			})
+			return
+		}
+		connLimit.take()
+		s.globalLimit.take()
+	}
 	var opts []backend.WriteOption
 	if msg.TTL != 0 {
 		opts = append(opts, backend.TTLOption{TTL: msg.TTL})
 	}
-	if err := conn.WriteTo(&msg.Packet, &net.UDPAddr{IP: msg.Addr}, opts...); err != nil {
-		log.Panicf("Error sending ping: %v", err)
+	if msg.DontFragment {
+		opts = append(opts, backend.DontFragmentOption{})
+	}
+	if msg.Stream != 0 {
+		opts = append(opts, backend.StreamOption{Stream: msg.Stream})
+	}
+	err := conn.WriteTo(&msg.Packet, &net.UDPAddr{IP: msg.Addr}, opts...)
+	if msg.DontFragment && errors.Is(err, syscall.EMSGSIZE) {
+		// Expected when probing the path MTU. The client sees it as a lost
+		// packet.
+		log.Printf("Packet too big to send: %v", err)
+		return
+	}
+	if err != nil {
+		s.write(messages.Error{ID: msg.ID, Code: messages.ErrorSendFailed, Text: err.Error()})
+	}
+}
+
+func (s *Server) handleSendPingBatch(msg messages.SendPingBatch) {
+	for _, ping := range msg.Pings {
+		s.handleSendPing(ping)
 	}
 }
 
 func (s *Server) handlePingReply(msg messages.PingReply) {
 	log.Panicf("Unexpected message: %v", msg)
 }
+
+func (s *Server) handleSetRateLimit(msg messages.SetRateLimit) {
+	now := s.now()
+	s.connLimit = tighten(s.connLimit, msg.PerConnection, defaultConnRateLimit)
+	for _, l := range s.connLimiters {
+		l.setLimit(s.connLimit, now)
+	}
+	global := tighten(s.globalLimit.limit, msg.Global, defaultGlobalRateLimit)
+	s.globalLimit.setLimit(global, now)
+	s.write(messages.RateLimitReply{
+		Request:       msg.Request,
+		PerConnection: s.connLimit,
+		Global:        global,
+	})
+}
+
+func (s *Server) handleRateLimitReply(msg messages.RateLimitReply) {
+	log.Panicf("Unexpected message: %v", msg)
+}
+
+func (s *Server) handleError(msg messages.Error) {
+	log.Panicf("Unexpected message: %v", msg)
+}
+
+func (s *Server) handleOpenSocket(msg messages.OpenSocket) {
+	// Nothing limits what the client sends on a raw socket of its own, so
+	// this has the same requirement as a flood connection.
+	if s.getuid() != 0 {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: "raw sockets require root"})
+		return
+	}
+	if s.sock == nil {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: "can't pass sockets over a pipe"})
+		return
+	}
+	if msg.IPVer != util.IPv4 && msg.IPVer != util.IPv6 {
+		log.Panicf("Invalid IP version: %v", msg.IPVer)
+	}
+	fd, err := syscall.Socket(msg.IPVer.AddressFamily(), syscall.SOCK_RAW, msg.IPVer.ICMPProtoNum())
+	if err != nil {
+		s.write(messages.Error{Request: msg.Request, Code: messages.ErrorOpenFailed, Text: err.Error()})
+		return
+	}
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("icmp:%v", msg.IPVer))
+	defer f.Close()
+	s.writeFile(messages.SocketReply{Request: msg.Request}, f)
+}
+
+func (s *Server) handleSocketReply(msg messages.SocketReply) {
+	log.Panicf("Unexpected message: %v", msg)
+}
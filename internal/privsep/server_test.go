@@ -2,6 +2,8 @@ package privsep
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"os"
@@ -13,8 +15,10 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/pcekm/vasily/internal/backend"
 	_ "github.com/pcekm/vasily/internal/backend/icmp"
+	"github.com/pcekm/vasily/internal/backend/test"
 	"github.com/pcekm/vasily/internal/privsep/messages"
 	"github.com/pcekm/vasily/internal/util"
+	"go.uber.org/mock/gomock"
 )
 
 var (
@@ -47,9 +51,7 @@ func newServerHarness(t *testing.T) *serverHarness {
 	}
 	fromClient.SetDeadline(deadline)
 	toClient.SetDeadline(deadline)
-	srv := newServer()
-	srv.in = fromClient
-	srv.out = toServer
+	srv := newServer(fromClient, toServer)
 	srvDone := make(chan any)
 	return &serverHarness{
 		t:       t,
@@ -61,6 +63,31 @@ func newServerHarness(t *testing.T) *serverHarness {
 	}
 }
 
+// Like newServerHarness, but connects to the server with a socket pair.
+func newSocketServerHarness(t *testing.T) *serverHarness {
+	clientFile, serverFile, err := socketPair()
+	if err != nil {
+		t.Fatalf("Error creating socket pair: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var socks [2]*messages.SocketConn
+	for i, f := range []*os.File{clientFile, serverFile} {
+		socks[i], err = messages.NewSocketConn(f)
+		if err != nil {
+			t.Fatalf("Error creating socket: %v", err)
+		}
+		socks[i].SetDeadline(deadline)
+	}
+	return &serverHarness{
+		t:       t,
+		srv:     newServer(socks[1], socks[1]),
+		srvDone: make(chan any),
+		in:      socks[0],
+		inb:     bufio.NewReader(socks[0]),
+		out:     socks[0],
+	}
+}
+
 func (h *serverHarness) Run() {
 	h.srv.run()
 	close(h.srvDone)
@@ -102,6 +129,42 @@ func (h *serverHarness) Read() messages.Message {
 	return msg
 }
 
+// Performs the protocol version handshake.
+func (h *serverHarness) Hello() {
+	h.t.Helper()
+	h.Write(messages.Hello{Version: messages.ProtocolVersion})
+	msg := h.Read()
+	if diff := cmp.Diff(messages.HelloReply{Version: messages.ProtocolVersion}, msg); diff != "" {
+		h.t.Errorf("Wrong hello reply (-want, +got):\n%v", diff)
+	}
+}
+
+func TestHello_VersionMismatch(t *testing.T) {
+	h := newServerHarness(t)
+	defer h.Close()
+
+	var exitcode *int
+	h.srv.osExit = func(x int) {
+		exitcode = &x
+	}
+	go func() {
+		defer h.DoneWriting()
+		h.Write(messages.Hello{Version: messages.ProtocolVersion + 1})
+		msg := h.Read()
+		if diff := cmp.Diff(messages.HelloReply{Version: messages.ProtocolVersion}, msg); diff != "" {
+			t.Errorf("Wrong hello reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+	if exitcode == nil || *exitcode != 1 {
+		t.Errorf("Version mismatch did not call sys.Exit(1)")
+	}
+	if h.srv.greeted {
+		t.Errorf("Server accepted mismatched client.")
+	}
+}
+
 func TestShutdown(t *testing.T) {
 	h := newServerHarness(t)
 	defer h.Close()
@@ -111,6 +174,7 @@ func TestShutdown(t *testing.T) {
 		exitcode = &x
 	}
 	go func() {
+		h.Hello()
 		h.Write(messages.Shutdown{})
 		h.DoneWriting()
 	}()
@@ -133,6 +197,7 @@ func TestPrivilegeDrop_SmokeTest(t *testing.T) {
 	defer h.Close()
 
 	go func() {
+		h.Hello()
 		h.Write(messages.PrivilegeDrop{})
 		h.DoneWriting()
 	}()
@@ -161,6 +226,7 @@ func TestPingLoopback(t *testing.T) {
 			var id messages.ConnectionID
 			go func() {
 				defer h.DoneWriting()
+				h.Hello()
 				h.Write(messages.OpenConnection{Backend: "icmp", IPVer: c.Ver})
 				msg := h.Read()
 				ocr, ok := msg.(messages.OpenConnectionReply)
@@ -187,9 +253,15 @@ func TestPingLoopback(t *testing.T) {
 					return
 				}
 
+				if pingRepl.Packet.TTL <= 0 {
+					t.Errorf("Reply TTL = %d (want > 0)", pingRepl.Packet.TTL)
+				}
+				if pingRepl.Packet.RecvTime.IsZero() {
+					t.Errorf("Reply has no receive time")
+				}
 				want := messages.PingReply{
 					ID:     id,
-					Packet: backend.Packet{Type: backend.PacketReply, Seq: 1, Payload: []byte("8675309")},
+					Packet: backend.Packet{Type: backend.PacketReply, Seq: 1, Payload: []byte("8675309"), TTL: pingRepl.Packet.TTL, RecvTime: pingRepl.Packet.RecvTime},
 					Peer:   c.Addr,
 				}
 				if diff := cmp.Diff(want, pingRepl); diff != "" {
@@ -209,3 +281,453 @@ func TestPingLoopback(t *testing.T) {
 		})
 	}
 }
+
+func TestSetRateLimit(t *testing.T) {
+	h := newServerHarness(t)
+	defer h.Close()
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+
+		h.Write(messages.SetRateLimit{})
+		want := messages.RateLimitReply{
+			PerConnection: defaultConnRateLimit,
+			Global:        defaultGlobalRateLimit,
+		}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong query reply (-want, +got):\n%v", diff)
+		}
+
+		h.Write(messages.SetRateLimit{
+			Request:       1,
+			PerConnection: messages.RateLimit{Interval: 2 * time.Second, Burst: 1},
+			Global:        messages.RateLimit{Interval: time.Nanosecond, Burst: 1000},
+		})
+		want = messages.RateLimitReply{
+			Request:       1,
+			PerConnection: messages.RateLimit{Interval: 2 * time.Second, Burst: 1},
+			Global:        defaultGlobalRateLimit,
+		}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong set reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestSendPing_RateLimited(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.EXPECT().ReadFrom(gomock.Any()).Return(nil, nil, errors.New("use of closed network connection")).AnyTimes()
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	conn.EXPECT().Close().Return(nil)
+	name := test.RegisterMock(conn)
+
+	h := newServerHarness(t)
+	defer h.Close()
+	now := time.Unix(1000, 0)
+	h.srv.now = func() time.Time { return now }
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.SetRateLimit{PerConnection: messages.RateLimit{Burst: 1}})
+		h.Read()
+		h.Write(messages.OpenConnection{Backend: name, IPVer: util.IPv4})
+		ocr, ok := h.Read().(messages.OpenConnectionReply)
+		if !ok {
+			t.Errorf("Expected OpenConnectionReply")
+			return
+		}
+		ping := messages.SendPing{
+			ID:     ocr.ID,
+			Packet: backend.Packet{Seq: 1},
+			Addr:   net.ParseIP("192.0.2.1").To4(),
+		}
+
+		h.Write(ping)
+		h.Write(ping)
+		want := messages.Error{
+			ID:   ocr.ID,
+			Code: messages.ErrorRateLimited,
+			Text: "ping to 192.0.2.1 dropped: rate limit exceeded",
+		}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong error reply (-want, +got):\n%v", diff)
+		}
+
+		// Other streams on the same connection share its limit.
+		for stream := 1; stream < 3; stream++ {
+			streamPing := ping
+			streamPing.Stream = stream
+			h.Write(streamPing)
+			if diff := cmp.Diff(want, h.Read()); diff != "" {
+				t.Errorf("Wrong error reply for stream %d (-want, +got):\n%v", stream, diff)
+			}
+		}
+
+		// Allowed again after the interval has passed. The next read blocks
+		// until the server has handled the previous message, so this doesn't
+		// race.
+		h.Write(messages.SetRateLimit{})
+		h.Read()
+		now = now.Add(defaultConnRateLimit.Interval)
+		h.Write(ping)
+		h.Write(messages.CloseConnection{Request: 2, ID: ocr.ID})
+		if diff := cmp.Diff(messages.CloseConnectionReply{Request: 2, ID: ocr.ID}, h.Read()); diff != "" {
+			t.Errorf("Wrong close reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestSendPing_Flood(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.EXPECT().ReadFrom(gomock.Any()).Return(nil, nil, errors.New("use of closed network connection")).AnyTimes()
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).Return(nil).Times(10)
+	conn.EXPECT().Close().Return(nil)
+	name := test.RegisterMock(conn)
+
+	h := newServerHarness(t)
+	defer h.Close()
+	now := time.Unix(1000, 0)
+	h.srv.now = func() time.Time { return now }
+	h.srv.getuid = func() int { return 0 }
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenConnection{Backend: name, IPVer: util.IPv4, Flood: true})
+		ocr, ok := h.Read().(messages.OpenConnectionReply)
+		if !ok {
+			t.Errorf("Expected OpenConnectionReply")
+			return
+		}
+		// Well past the default burst, with the clock stopped.
+		for seq := range 10 {
+			h.Write(messages.SendPing{
+				ID:     ocr.ID,
+				Packet: backend.Packet{Seq: seq},
+				Addr:   net.ParseIP("192.0.2.1").To4(),
+			})
+		}
+		h.Write(messages.CloseConnection{Request: 2, ID: ocr.ID})
+		if diff := cmp.Diff(messages.CloseConnectionReply{Request: 2, ID: ocr.ID}, h.Read()); diff != "" {
+			t.Errorf("Wrong close reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestOpenConnection_FloodNotRoot(t *testing.T) {
+	h := newServerHarness(t)
+	defer h.Close()
+	h.srv.getuid = func() int { return 1000 }
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenConnection{Request: 4, Backend: "icmp", IPVer: util.IPv4, Flood: true})
+		want := messages.Error{Request: 4, Code: messages.ErrorOpenFailed, Text: "flood mode requires root"}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestOpenConnection_SourceNotRoot(t *testing.T) {
+	cases := []struct {
+		Name string
+		Msg  messages.OpenConnection
+		Text string
+	}{
+		{
+			Name: "Mark",
+			Msg:  messages.OpenConnection{Request: 5, Backend: "icmp", IPVer: util.IPv4, SourceMark: 7},
+			Text: "fwmark requires root",
+		},
+		{
+			Name: "Netns",
+			Msg:  messages.OpenConnection{Request: 5, Backend: "icmp", IPVer: util.IPv4, SourceNetns: "blue"},
+			Text: "netns requires root",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			h := newServerHarness(t)
+			defer h.Close()
+			h.srv.getuid = func() int { return 1000 }
+
+			go func() {
+				defer h.DoneWriting()
+				h.Hello()
+				h.Write(c.Msg)
+				want := messages.Error{Request: 5, Code: messages.ErrorOpenFailed, Text: c.Text}
+				if diff := cmp.Diff(want, h.Read()); diff != "" {
+					t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+				}
+			}()
+
+			h.Run()
+		})
+	}
+}
+
+func TestOpenConnection_RecvBuffer(t *testing.T) {
+	cases := []struct {
+		Name string
+		UID  int
+		Want backend.RecvBufferOption
+	}{
+		{Name: "Root", UID: 0, Want: backend.RecvBufferOption{Bytes: backend.MaxRecvBuffer, Force: true}},
+		{Name: "NotRoot", UID: 1000, Want: backend.RecvBufferOption{Bytes: backend.MaxRecvBuffer}},
+	}
+	for i, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			conn := test.NewMockConn(ctrl)
+			conn.EXPECT().ReadFrom(gomock.Any()).Return(nil, nil, errors.New("use of closed network connection")).AnyTimes()
+			conn.EXPECT().Close().Return(nil)
+			var got backend.RecvBufferOption
+			name := backend.Name(fmt.Sprintf("recvbuffer:%d", i))
+			backend.Register(name, func(_ util.IPVersion, opts ...backend.ConnOption) (backend.Conn, error) {
+				got = backend.GetRecvBuffer(opts)
+				return conn, nil
+			})
+
+			h := newServerHarness(t)
+			defer h.Close()
+			h.srv.getuid = func() int { return c.UID }
+
+			go func() {
+				defer h.DoneWriting()
+				h.Hello()
+				// Far more than the server allows.
+				h.Write(messages.OpenConnection{Backend: name, IPVer: util.IPv4, RecvBuffer: 1 << 30})
+				ocr, ok := h.Read().(messages.OpenConnectionReply)
+				if !ok {
+					t.Errorf("Expected OpenConnectionReply")
+					return
+				}
+				h.Write(messages.CloseConnection{Request: 2, ID: ocr.ID})
+				h.Read()
+			}()
+
+			h.Run()
+			if diff := cmp.Diff(c.Want, got); diff != "" {
+				t.Errorf("Wrong receive buffer option (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestOpenConnection_Error(t *testing.T) {
+	h := newServerHarness(t)
+	defer h.Close()
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenConnection{Request: 3, Backend: "nonexistent", IPVer: util.IPv4})
+		want := messages.Error{Request: 3, Code: messages.ErrorOpenFailed, Text: `invalid backend "nonexistent"`}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestCloseConnection_NoSuchConnection(t *testing.T) {
+	h := newServerHarness(t)
+	defer h.Close()
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.CloseConnection{Request: 5, ID: 42})
+		want := messages.Error{Request: 5, ID: 42, Code: messages.ErrorCloseFailed, Text: "no such connection"}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestSendPing_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.EXPECT().ReadFrom(gomock.Any()).Return(nil, nil, errors.New("use of closed network connection")).AnyTimes()
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).Return(syscall.ENETUNREACH)
+	conn.EXPECT().Close().Return(nil)
+	name := test.RegisterMock(conn)
+
+	h := newServerHarness(t)
+	defer h.Close()
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenConnection{Backend: name, IPVer: util.IPv4})
+		ocr, ok := h.Read().(messages.OpenConnectionReply)
+		if !ok {
+			t.Errorf("Expected OpenConnectionReply")
+			return
+		}
+		ping := messages.SendPing{
+			ID:     ocr.ID,
+			Packet: backend.Packet{Seq: 1},
+			Addr:   net.ParseIP("192.0.2.1").To4(),
+		}
+
+		h.Write(ping)
+		want := messages.Error{ID: ocr.ID, Code: messages.ErrorSendFailed, Text: syscall.ENETUNREACH.Error()}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong send reply (-want, +got):\n%v", diff)
+		}
+
+		ping.ID = ocr.ID + 1
+		h.Write(ping)
+		want = messages.Error{ID: ocr.ID + 1, Code: messages.ErrorSendFailed, Text: "no such connection"}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong unknown connection reply (-want, +got):\n%v", diff)
+		}
+
+		h.Write(messages.CloseConnection{ID: ocr.ID})
+		h.Read()
+	}()
+
+	h.Run()
+}
+
+func TestSendPingBatch(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.EXPECT().ReadFrom(gomock.Any()).Return(nil, nil, errors.New("use of closed network connection")).AnyTimes()
+	var gotSeqs []int
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any()).DoAndReturn(func(pkt *backend.Packet, _ net.Addr, _ ...backend.WriteOption) error {
+		gotSeqs = append(gotSeqs, pkt.Seq)
+		if pkt.Seq == 2 {
+			return syscall.ENETUNREACH
+		}
+		return nil
+	}).Times(3)
+	conn.EXPECT().Close().Return(nil)
+	name := test.RegisterMock(conn)
+
+	h := newServerHarness(t)
+	defer h.Close()
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenConnection{Backend: name, IPVer: util.IPv4})
+		ocr, ok := h.Read().(messages.OpenConnectionReply)
+		if !ok {
+			t.Errorf("Expected OpenConnectionReply")
+			return
+		}
+		var batch messages.SendPingBatch
+		for seq := 1; seq <= 3; seq++ {
+			batch.Pings = append(batch.Pings, messages.SendPing{
+				ID:     ocr.ID,
+				Packet: backend.Packet{Seq: seq},
+				Addr:   net.ParseIP("192.0.2.1").To4(),
+			})
+		}
+
+		h.Write(batch)
+		want := messages.Error{ID: ocr.ID, Code: messages.ErrorSendFailed, Text: syscall.ENETUNREACH.Error()}
+		if diff := cmp.Diff(want, h.Read()); diff != "" {
+			t.Errorf("Wrong send reply (-want, +got):\n%v", diff)
+		}
+
+		h.Write(messages.CloseConnection{ID: ocr.ID})
+		h.Read()
+	}()
+
+	h.Run()
+
+	if diff := cmp.Diff([]int{1, 2, 3}, gotSeqs); diff != "" {
+		t.Errorf("Wrong pings sent (-want, +got):\n%v", diff)
+	}
+}
+
+func TestOpenSocket(t *testing.T) {
+	if !supportedOS[runtime.GOOS] {
+		t.Skipf("Unsupported OS: %v", runtime.GOOS)
+	}
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+	if err != nil {
+		t.Skipf("Can't open raw socket: %v", err)
+	}
+	syscall.Close(fd)
+
+	h := newSocketServerHarness(t)
+	defer h.Close()
+	h.srv.getuid = func() int { return 0 }
+	sock := h.in.(*messages.SocketConn)
+
+	go func() {
+		defer h.DoneWriting()
+		h.Hello()
+		h.Write(messages.OpenSocket{Request: 6, IPVer: util.IPv4})
+		msg := h.Read()
+		reply, ok := msg.(messages.SocketReply)
+		if !ok || reply.Request != 6 {
+			t.Errorf("Wrong reply: %#v (want SocketReply for request 6)", msg)
+			return
+		}
+		f := sock.TakeFile()
+		if f == nil {
+			t.Errorf("No socket passed with %v", reply)
+			return
+		}
+		defer f.Close()
+		proto, err := syscall.GetsockoptInt(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_TYPE)
+		if err != nil || proto != syscall.SOCK_RAW {
+			t.Errorf("Passed socket type = %v, %v (want SOCK_RAW)", proto, err)
+		}
+	}()
+
+	h.Run()
+}
+
+func TestOpenSocket_Errors(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Harness func(*testing.T) *serverHarness
+		UID     int
+		Want    string
+	}{
+		{Name: "NotRoot", Harness: newSocketServerHarness, UID: 1000, Want: "raw sockets require root"},
+		{Name: "Pipe", Harness: newServerHarness, UID: 0, Want: "can't pass sockets over a pipe"},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			h := c.Harness(t)
+			defer h.Close()
+			h.srv.getuid = func() int { return c.UID }
+
+			go func() {
+				defer h.DoneWriting()
+				h.Hello()
+				h.Write(messages.OpenSocket{Request: 2, IPVer: util.IPv4})
+				want := messages.Error{Request: 2, Code: messages.ErrorOpenFailed, Text: c.Want}
+				if diff := cmp.Diff(want, h.Read()); diff != "" {
+					t.Errorf("Wrong reply (-want, +got):\n%v", diff)
+				}
+			}()
+
+			h.Run()
+		})
+	}
+}
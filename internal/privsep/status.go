@@ -0,0 +1,60 @@
+package privsep
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Status describes the privileged server, for display.
+type Status struct {
+	// Enabled is true if pings go through a privileged server.
+	Enabled bool
+
+	// Alive is true while the server is running. It's false while a server
+	// that exited is restarted.
+	Alive bool
+
+	// PrivilegesDropped is true if this process started with privileges
+	// through setuid, and gave them up.
+	PrivilegesDropped bool
+
+	// Restarts is the number of times the server has been restarted.
+	Restarts int
+}
+
+func (s Status) String() string {
+	if !s.Enabled {
+		return "privsep off"
+	}
+	parts := []string{"helper down"}
+	if s.Alive {
+		parts[0] = "helper up"
+	}
+	if s.PrivilegesDropped {
+		parts = append(parts, "privileges dropped")
+	}
+	if s.Restarts > 0 {
+		parts = append(parts, fmt.Sprintf("%d restarts", s.Restarts))
+	}
+	return "privsep: " + strings.Join(parts, ", ")
+}
+
+var (
+	statusMu sync.Mutex
+	status   Status
+)
+
+// CurrentStatus returns the status of the privileged server.
+func CurrentStatus() Status {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	return status
+}
+
+// Changes the status.
+func updateStatus(f func(s *Status)) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	f(&status)
+}
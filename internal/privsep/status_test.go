@@ -0,0 +1,20 @@
+package privsep
+
+import "testing"
+
+func TestStatusString(t *testing.T) {
+	cases := []struct {
+		s    Status
+		want string
+	}{
+		{Status{}, "privsep off"},
+		{Status{Enabled: true, Alive: true}, "privsep: helper up"},
+		{Status{Enabled: true, Alive: true, PrivilegesDropped: true}, "privsep: helper up, privileges dropped"},
+		{Status{Enabled: true, Restarts: 2}, "privsep: helper down, 2 restarts"},
+	}
+	for _, c := range cases {
+		if got := c.s.String(); got != c.want {
+			t.Errorf("%+v.String() = %q (want %q)", c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package report summarizes the results for each target in a run, in the style
+// of mtr's report mode. The summary can come from a live [targets.Manager] or
+// a recorded session, and can be written as text, JSON or Markdown.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/session"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
+)
+
+// Format is an output format for a report.
+type Format int
+
+// Values for Format.
+const (
+	Text Format = iota
+	JSON
+	Markdown
+)
+
+func (f Format) String() string {
+	switch f {
+	case Text:
+		return "text"
+	case JSON:
+		return "json"
+	case Markdown:
+		return "markdown"
+	default:
+		return fmt.Sprintf("(unknown:%d)", f)
+	}
+}
+
+// ParseFormat parses the name of a format.
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	case "markdown", "md":
+		return Markdown, nil
+	default:
+		return 0, fmt.Errorf("unknown report format %q (want text, json or markdown)", s)
+	}
+}
+
+// Row summarizes the results for a single target.
+type Row struct {
+	// Group and Index identify the target. For the hops in a path, Index is
+	// the hop's distance.
+	Group string
+	Index int
+
+	// Addr is the target's address.
+	Addr string
+
+	// Name is the host name for Addr from the lookup cache, or empty if it
+	// isn't known.
+	Name string
+
+	// Label is the user's label for the target, or empty if it has none.
+	Label string
+
+	// Sent is the number of pings, and Lost the number without a successful
+	// reply.
+	Sent int
+	Lost int
+
+	// Last is the latency of the most recent successful ping. The others are
+	// over all the successful pings. All are zero if there were none.
+	Last   time.Duration
+	Avg    time.Duration
+	Best   time.Duration
+	Worst  time.Duration
+	StdDev time.Duration
+}
+
+// Loss returns the fraction of pings without a successful reply.
+func (r Row) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(r.Sent)
+}
+
+// FromTargets summarizes each target. The rows are in the same order as the
+// targets.
+func FromTargets(ts []targets.Target) []Row {
+	res := make([]Row, 0, len(ts))
+	for _, t := range ts {
+		st := t.Pinger.Stats()
+		r := Row{
+			Group:  t.Group,
+			Index:  t.Index,
+			Addr:   addrString(t.Addr),
+			Label:  t.Label,
+			Sent:   st.N,
+			Lost:   st.Failures,
+			Last:   st.LastLatency,
+			Avg:    st.AvgLatency,
+			Best:   st.MinLatency,
+			Worst:  st.MaxLatency,
+			StdDev: st.StdDev,
+		}
+		// Only what's already cached, since lookups could hold up the exit.
+		if r.Addr != "" {
+			if name, _ := lookup.Cached(t.Addr); name != r.Addr {
+				r.Name = name
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// FromRecording summarizes a session recorded with [session.Recorder]. Hops
+// that were replaced when a path changed are left out, just as they are when
+// the recording is replayed.
+func FromRecording(r io.Reader) []Row {
+	m := targets.New(nil)
+	defer m.Close()
+	m.Replay(session.NewPlayer(r, math.Inf(1)))
+	return FromTargets(m.Targets())
+}
+
+// Write writes a report in the given format.
+func Write(w io.Writer, rows []Row, f Format) error {
+	return write(w, rows, f, nil)
+}
+
+// WriteHosts writes a report like [Write], but shows hosts by name, address or
+// both, as chosen in the text UI. Other targets are named as they were given.
+// JSON always has both.
+func WriteHosts(w io.Writer, rows []Row, f Format, hosts view.HostDisplay) error {
+	return write(w, rows, f, &hosts)
+}
+
+// Writes a report, showing hosts the usual way if hosts is nil.
+func write(w io.Writer, rows []Row, f Format, hosts *view.HostDisplay) error {
+	switch f {
+	case Text:
+		return writeText(w, rows, hosts)
+	case JSON:
+		return writeJSON(w, rows)
+	case Markdown:
+		return writeMarkdown(w, rows, hosts)
+	default:
+		return fmt.Errorf("unknown report format %v", f)
+	}
+}
+
+// Returns the name shown for a row's host. Hops are numbered, and other
+// targets also show their address if it's not their name, unless hosts says
+// otherwise. Labels follow in brackets.
+func hostName(r Row, hosts *view.HostDisplay) string {
+	var name string
+	switch {
+	case hosts != nil && r.Index != 0:
+		name = fmt.Sprintf("%2d. %v", r.Index, hosts.Format(r.Name, r.Addr))
+	case hosts != nil:
+		name = hosts.Format(r.Group, r.Addr)
+	case r.Index != 0:
+		name = fmt.Sprintf("%2d. %v", r.Index, r.Addr)
+	case r.Addr == "" || r.Addr == r.Group:
+		name = r.Group
+	default:
+		name = fmt.Sprintf("%v (%v)", r.Group, r.Addr)
+	}
+	return withLabel(name, r.Label)
+}
+
+// Adds a label to a name, if there is one.
+func withLabel(name, label string) string {
+	if label == "" {
+		return name
+	}
+	return fmt.Sprintf("%s [%s]", name, label)
+}
+
+// Formats a latency in milliseconds.
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1f", float64(d)/float64(time.Millisecond))
+}
+
+// Returns a row's statistics as strings, in the order of statHeadings.
+func stats(r Row) []string {
+	return []string{
+		fmt.Sprintf("%.1f%%", 100*r.Loss()),
+		fmt.Sprint(r.Sent),
+		ms(r.Last),
+		ms(r.Avg),
+		ms(r.Best),
+		ms(r.Worst),
+		ms(r.StdDev),
+	}
+}
+
+var statHeadings = []string{"Loss%", "Sent", "Last", "Avg", "Best", "Wrst", "StDev"}
+
+// Writes a plain text table. The hops in a path are listed under a line
+// naming the path.
+func writeText(w io.Writer, rows []Row, hosts *view.HostDisplay) error {
+	var lines [][]string
+	for i, r := range rows {
+		if r.Index != 0 && (i == 0 || rows[i-1].Group != r.Group) {
+			lines = append(lines, []string{r.Group})
+		}
+		host := hostName(r, hosts)
+		if r.Index != 0 {
+			host = "  " + host
+		}
+		lines = append(lines, append([]string{host}, stats(r)...))
+	}
+	widths := make([]int, len(statHeadings)+1)
+	for i, h := range statHeadings {
+		widths[i+1] = len(h)
+	}
+	for _, l := range lines {
+		// Path names take up a line of their own.
+		if len(l) == 1 {
+			continue
+		}
+		for i, s := range l {
+			widths[i] = max(widths[i], len(s))
+		}
+	}
+
+	var sb strings.Builder
+	writeLine := func(l []string) {
+		fmt.Fprintf(&sb, "%-*s", widths[0], l[0])
+		for i, s := range l[1:] {
+			fmt.Fprintf(&sb, "  %*s", widths[i+1], s)
+		}
+		sb.WriteString("\n")
+	}
+	writeLine(append([]string{"Host"}, statHeadings...))
+	for _, l := range lines {
+		if len(l) == 1 {
+			fmt.Fprintln(&sb, l[0])
+			continue
+		}
+		writeLine(l)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// A row as written in JSON. Latencies are in milliseconds.
+type jsonRow struct {
+	Group    string  `json:"group"`
+	Index    int     `json:"index,omitempty"`
+	Addr     string  `json:"addr"`
+	Name     string  `json:"name,omitempty"`
+	Label    string  `json:"label,omitempty"`
+	Sent     int     `json:"sent"`
+	Lost     int     `json:"lost"`
+	LossPct  float64 `json:"loss_pct"`
+	LastMs   float64 `json:"last_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	BestMs   float64 `json:"best_ms"`
+	WorstMs  float64 `json:"worst_ms"`
+	StdDevMs float64 `json:"stddev_ms"`
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Writes a JSON array with an object for each row.
+func writeJSON(w io.Writer, rows []Row) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jsonRows(rows))
+}
+
+// Converts rows to the form they're written in as JSON.
+func jsonRows(rows []Row) []jsonRow {
+	res := make([]jsonRow, 0, len(rows))
+	for _, r := range rows {
+		res = append(res, jsonRow{
+			Group:    r.Group,
+			Index:    r.Index,
+			Addr:     r.Addr,
+			Name:     r.Name,
+			Label:    r.Label,
+			Sent:     r.Sent,
+			Lost:     r.Lost,
+			LossPct:  100 * r.Loss(),
+			LastMs:   toMs(r.Last),
+			AvgMs:    toMs(r.Avg),
+			BestMs:   toMs(r.Best),
+			WorstMs:  toMs(r.Worst),
+			StdDevMs: toMs(r.StdDev),
+		})
+	}
+	return res
+}
+
+// Writes a Markdown table. Unlike text, every row names its target, since
+// there's nowhere to put a heading for a path.
+func writeMarkdown(w io.Writer, rows []Row, hosts *view.HostDisplay) error {
+	var sb strings.Builder
+	sb.WriteString("| Target | Hop | Host | " + strings.Join(statHeadings, " | ") + " |\n")
+	sb.WriteString("|:--|--:|:--|" + strings.Repeat("--:|", len(statHeadings)) + "\n")
+	for _, r := range rows {
+		hop, host := "", r.Addr
+		if hosts != nil {
+			host = hosts.Format(r.Name, r.Addr)
+		}
+		host = withLabel(host, r.Label)
+		if r.Index != 0 {
+			hop = fmt.Sprint(r.Index)
+		}
+		cells := append([]string{escapeMarkdown(r.Group), hop, escapeMarkdown(host)}, stats(r)...)
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Escapes characters that would break a Markdown table cell.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `|`, `\|`).Replace(s)
+}
+
+func addrString(addr net.Addr) string {
+	if ip := util.IP(addr); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
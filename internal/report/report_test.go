@@ -0,0 +1,164 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/session"
+	"github.com/pcekm/vasily/internal/view"
+)
+
+var (
+	hostA = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	hostB = &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+)
+
+var testRows = []Row{
+	{Group: "a.example", Addr: "192.0.2.1", Label: "office router", Sent: 4, Lost: 1, Last: 30 * time.Millisecond, Avg: 20 * time.Millisecond, Best: 10 * time.Millisecond, Worst: 30 * time.Millisecond, StdDev: 8165 * time.Microsecond},
+	{Group: "b|example", Index: 1, Addr: "2001:db8::1", Sent: 1, Last: 5 * time.Millisecond, Avg: 5 * time.Millisecond, Best: 5 * time.Millisecond, Worst: 5 * time.Millisecond},
+	{Group: "b|example", Index: 2, Sent: 2, Lost: 2},
+}
+
+func TestFromRecording(t *testing.T) {
+	var buf bytes.Buffer
+	rec := session.NewRecorder(&buf)
+	start := time.Unix(1000, 0)
+	for i, lat := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 0, 30 * time.Millisecond} {
+		res := pinger.PingResult{Type: pinger.Success, Time: start.Add(time.Duration(i) * time.Second), Latency: lat}
+		if lat == 0 {
+			res = pinger.PingResult{Type: pinger.Dropped, Time: res.Time}
+		}
+		rec.RecordPing("a.example", 0, hostA, i, res)
+	}
+	rec.RecordPing("b.example", 1, hostB, 0, pinger.PingResult{Type: pinger.Success, Time: start, Latency: 5 * time.Millisecond})
+
+	got := FromRecording(&buf)
+	want := []Row{
+		{Group: "a.example", Addr: "192.0.2.1", Sent: 4, Lost: 1, Last: 30 * time.Millisecond, Avg: 20 * time.Millisecond, Best: 10 * time.Millisecond, Worst: 30 * time.Millisecond},
+		{Group: "b.example", Index: 1, Addr: "2001:db8::1", Sent: 1, Last: 5 * time.Millisecond, Avg: 5 * time.Millisecond, Best: 5 * time.Millisecond, Worst: 5 * time.Millisecond},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Row{}, "StdDev")); diff != "" {
+		t.Errorf("Wrong rows (-want, +got):\n%v", diff)
+	}
+	if len(got) > 0 && got[0].StdDev == 0 {
+		t.Errorf("StdDev = 0 (want nonzero)")
+	}
+}
+
+func TestWrite_Text(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testRows, Text); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	want := "" +
+		"Host                                    Loss%  Sent  Last   Avg  Best  Wrst  StDev\n" +
+		"a.example (192.0.2.1) [office router]   25.0%     4  30.0  20.0  10.0  30.0    8.2\n" +
+		"b|example\n" +
+		"   1. 2001:db8::1                        0.0%     1   5.0   5.0   5.0   5.0    0.0\n" +
+		"   2.                                  100.0%     2   0.0   0.0   0.0   0.0    0.0\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Wrong text (-want, +got):\n%v", diff)
+	}
+}
+
+func TestWrite_TextHosts(t *testing.T) {
+	rows := []Row{
+		{Group: "a.example", Addr: "192.0.2.1", Sent: 1},
+		{Group: "b.example", Index: 1, Addr: "198.51.100.1", Name: "gw.example", Sent: 1},
+	}
+	cases := []struct {
+		hosts view.HostDisplay
+		want  []string
+	}{
+		{hosts: view.HostName, want: []string{"a.example", "   1. gw.example"}},
+		{hosts: view.HostAddr, want: []string{"192.0.2.1", "   1. 198.51.100.1"}},
+		{hosts: view.HostBoth, want: []string{"a.example (192.0.2.1)", "   1. gw.example (198.51.100.1)"}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		if err := WriteHosts(&buf, rows, Text, c.hosts); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		// Each host starts a line, and is padded out to the loss column.
+		for _, host := range c.want {
+			if !strings.Contains(buf.String(), "\n"+host+"  ") {
+				t.Errorf("Report with %v hosts is missing %q:\n%s", c.hosts, host, buf.String())
+			}
+		}
+	}
+}
+
+func TestWrite_TextDefaultHosts(t *testing.T) {
+	rows := []Row{
+		{Group: "a.example", Addr: "192.0.2.1", Sent: 1},
+		{Group: "b.example", Index: 1, Addr: "198.51.100.1", Name: "gw.example", Sent: 1},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, rows, Text); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	for _, host := range []string{"a.example (192.0.2.1)", "   1. 198.51.100.1"} {
+		if !strings.Contains(buf.String(), "\n"+host+"  ") {
+			t.Errorf("Report is missing %q:\n%s", host, buf.String())
+		}
+	}
+}
+
+func TestWrite_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testRows[:1], JSON); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	var got []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Error decoding %q: %v", buf.String(), err)
+	}
+	want := []map[string]any{{
+		"group":     "a.example",
+		"addr":      "192.0.2.1",
+		"label":     "office router",
+		"sent":      4.0,
+		"lost":      1.0,
+		"loss_pct":  25.0,
+		"last_ms":   30.0,
+		"avg_ms":    20.0,
+		"best_ms":   10.0,
+		"worst_ms":  30.0,
+		"stddev_ms": 8.165,
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong JSON (-want, +got):\n%v", diff)
+	}
+}
+
+func TestWrite_Markdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testRows[1:2], Markdown); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+	want := "" +
+		"| Target | Hop | Host | Loss% | Sent | Last | Avg | Best | Wrst | StDev |\n" +
+		"|:--|--:|:--|--:|--:|--:|--:|--:|--:|--:|\n" +
+		"| b\\|example | 1 | 2001:db8::1 | 0.0% | 1 | 5.0 | 5.0 | 5.0 | 5.0 | 0.0 |\n"
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Wrong Markdown (-want, +got):\n%v", diff)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []Format{Text, JSON, Markdown} {
+		if got, err := ParseFormat(f.String()); err != nil || got != f {
+			t.Errorf("ParseFormat(%q) = %v, %v (want %v)", f.String(), got, err, f)
+		}
+	}
+	if _, err := ParseFormat("html"); err == nil {
+		t.Errorf("ParseFormat(\"html\") succeeded (want error)")
+	}
+}
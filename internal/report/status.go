@@ -0,0 +1,101 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Status sums up the health of a run's targets. Its value is the exit status
+// vasily uses, so that scripts can act on the result of a run with --count
+// or --deadline.
+type Status int
+
+// Values for Status. When more than one applies, the highest wins.
+const (
+	// Healthy means every target answered every ping.
+	Healthy Status = iota
+
+	// Loss means some target lost pings.
+	Loss
+
+	// Unresolved means some host's name couldn't be resolved.
+	Unresolved
+
+	// Down means some target never answered.
+	Down
+
+	// Failed means the run itself failed, so nothing is known about the
+	// targets.
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Loss:
+		return "loss"
+	case Unresolved:
+		return "unresolved"
+	case Down:
+		return "down"
+	case Failed:
+		return "failed"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(s))
+	}
+}
+
+// StatusOf returns the status of a run with the given rows, and hosts that
+// couldn't be resolved. A traced path is judged by its last hop, since
+// routers along the way often limit how fast they reply. Targets that haven't
+// sent anything are left out.
+func StatusOf(rows []Row, unresolved []string) Status {
+	st := Healthy
+	if len(unresolved) > 0 {
+		st = Unresolved
+	}
+	last := make(map[string]Row)
+	for _, r := range rows {
+		if prev, ok := last[r.Group]; !ok || r.Index > prev.Index {
+			last[r.Group] = r
+		}
+	}
+	for _, r := range last {
+		switch {
+		case r.Sent == 0:
+		case r.Lost == r.Sent:
+			st = max(st, Down)
+		case r.Lost > 0:
+			st = max(st, Loss)
+		}
+	}
+	return st
+}
+
+// A summary as written by [WriteSummary].
+type jsonSummary struct {
+	Status     int       `json:"status"`
+	StatusName string    `json:"status_name"`
+	Unresolved []string  `json:"unresolved"`
+	Targets    []jsonRow `json:"targets"`
+}
+
+// WriteSummary writes a JSON object with the run's [Status], the hosts that
+// couldn't be resolved, and each row as it appears in a JSON report.
+func WriteSummary(w io.Writer, rows []Row, unresolved []string) error {
+	st := StatusOf(rows, unresolved)
+	res := jsonSummary{
+		Status:     int(st),
+		StatusName: st.String(),
+		Unresolved: unresolved,
+		Targets:    jsonRows(rows),
+	}
+	if res.Unresolved == nil {
+		res.Unresolved = []string{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(res)
+}
@@ -0,0 +1,100 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStatusOf(t *testing.T) {
+	cases := []struct {
+		name       string
+		rows       []Row
+		unresolved []string
+		want       Status
+	}{
+		{name: "Empty", want: Healthy},
+		{
+			name: "Healthy",
+			rows: []Row{{Group: "a", Sent: 3}, {Group: "b", Sent: 3}},
+			want: Healthy,
+		},
+		{
+			name: "Loss",
+			rows: []Row{{Group: "a", Sent: 3}, {Group: "b", Sent: 3, Lost: 1}},
+			want: Loss,
+		},
+		{
+			name: "Down",
+			rows: []Row{{Group: "a", Sent: 3, Lost: 1}, {Group: "b", Sent: 3, Lost: 3}},
+			want: Down,
+		},
+		{
+			name:       "Unresolved",
+			rows:       []Row{{Group: "a", Sent: 3, Lost: 1}},
+			unresolved: []string{"nowhere.invalid"},
+			want:       Unresolved,
+		},
+		{
+			name:       "DownBeatsUnresolved",
+			rows:       []Row{{Group: "a", Sent: 3, Lost: 3}},
+			unresolved: []string{"nowhere.invalid"},
+			want:       Down,
+		},
+		{
+			name: "NothingSent",
+			rows: []Row{{Group: "a"}},
+			want: Healthy,
+		},
+		{
+			name: "PathJudgedByLastHop",
+			rows: []Row{
+				{Group: "p", Index: 1, Sent: 3},
+				{Group: "p", Index: 2, Sent: 3, Lost: 3},
+				{Group: "p", Index: 3, Sent: 3},
+			},
+			want: Healthy,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := StatusOf(c.rows, c.unresolved); got != c.want {
+				t.Errorf("StatusOf() = %v (want %v)", got, c.want)
+			}
+		})
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, testRows[:1], nil); err != nil {
+		t.Fatalf("WriteSummary error: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Error decoding %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"status":      1.0,
+		"status_name": "loss",
+		"unresolved":  []any{},
+		"targets": []any{map[string]any{
+			"group":     "a.example",
+			"addr":      "192.0.2.1",
+			"label":     "office router",
+			"sent":      4.0,
+			"lost":      1.0,
+			"loss_pct":  25.0,
+			"last_ms":   30.0,
+			"avg_ms":    20.0,
+			"best_ms":   10.0,
+			"worst_ms":  30.0,
+			"stddev_ms": 8.165,
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong JSON (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,252 @@
+// Package session records ping sessions to a file and replays them.
+//
+// A recording is a stream of JSON objects, one per line, each holding a single
+// ping result or traceroute step along with the time it happened relative to
+// the start of the recording. For example:
+//
+//	{"at":1002345678,"group":"example.com","ping":{"addr":"192.0.2.1","seq":0,"type":1,"time":"...","latency":2345678}}
+//	{"at":1500000000,"group":"example.com","index":3,"step":{"host":"192.0.2.7","prev":"192.0.2.9"}}
+package session
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Event is a single recorded event. Exactly one of Ping or Step is set.
+type Event struct {
+	// At is the time of the event relative to the start of the recording.
+	At time.Duration `json:"at"`
+
+	// Group and Index identify the table row the event belongs to.
+	Group string `json:"group"`
+	Index int    `json:"index,omitempty"`
+
+	Ping *Ping `json:"ping,omitempty"`
+	Step *Step `json:"step,omitempty"`
+}
+
+// Ping is a recorded ping result.
+type Ping struct {
+	// Addr is the address that was pinged.
+	Addr string `json:"addr"`
+
+	// Seq is the ping's sequence number.
+	Seq int `json:"seq"`
+
+	Type    pinger.ResultType `json:"type"`
+	Time    time.Time         `json:"time"`
+	Latency time.Duration     `json:"latency"`
+	Peer    string            `json:"peer,omitempty"`
+
+	// Timestamps holds the timestamps from a timestamp reply.
+	Timestamps *Timestamps `json:"timestamps,omitempty"`
+
+	// AddressMask is the hex mask from an address mask reply.
+	AddressMask string `json:"address_mask,omitempty"`
+
+	// TTL is the TTL the reply arrived with.
+	TTL int `json:"ttl,omitempty"`
+
+	// Probes and Replies count the pings in a burst and their successful
+	// replies. Both are zero for single pings.
+	Probes  int `json:"probes,omitempty"`
+	Replies int `json:"replies,omitempty"`
+}
+
+// Timestamps is a recorded [backend.Timestamps].
+type Timestamps struct {
+	Originate uint32 `json:"originate"`
+	Receive   uint32 `json:"receive"`
+	Transmit  uint32 `json:"transmit"`
+}
+
+// Target returns the address that was pinged.
+func (p *Ping) Target() net.Addr {
+	return parseAddr(p.Addr)
+}
+
+// Result returns the recorded ping result.
+func (p *Ping) Result() pinger.PingResult {
+	res := pinger.PingResult{
+		Type:    p.Type,
+		Time:    p.Time,
+		Latency: p.Latency,
+		Peer:    parseAddr(p.Peer),
+		TTL:     p.TTL,
+		Probes:  p.Probes,
+		Replies: p.Replies,
+	}
+	if ts := p.Timestamps; ts != nil {
+		res.Timestamps = backend.Timestamps(*ts)
+	}
+	if mask, err := hex.DecodeString(p.AddressMask); err == nil && len(mask) > 0 {
+		res.AddressMask = net.IPMask(mask)
+	}
+	return res
+}
+
+// Step is a recorded traceroute step. The position is the event's Index.
+type Step struct {
+	Host string `json:"host,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// Recorder writes events to a recording. It's safe for concurrent use.
+type Recorder struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	start  time.Time
+	failed bool
+
+	// For testing.
+	now func() time.Time
+}
+
+// NewRecorder creates a recorder that writes to w. Times are relative to
+// when this is called.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+		now:   time.Now,
+	}
+}
+
+// RecordPing records a ping result.
+func (r *Recorder) RecordPing(group string, index int, target net.Addr, seq int, res pinger.PingResult) {
+	ping := &Ping{
+		Addr:    addrString(target),
+		Seq:     seq,
+		Type:    res.Type,
+		Time:    res.Time,
+		Latency: res.Latency,
+		Peer:    addrString(res.Peer),
+		TTL:     res.TTL,
+		Probes:  res.Probes,
+		Replies: res.Replies,
+	}
+	if res.Timestamps != (backend.Timestamps{}) {
+		ts := Timestamps(res.Timestamps)
+		ping.Timestamps = &ts
+	}
+	if res.AddressMask != nil {
+		ping.AddressMask = res.AddressMask.String()
+	}
+	r.record(Event{
+		Group: group,
+		Index: index,
+		Ping:  ping,
+	})
+}
+
+// RecordStep records a traceroute step.
+func (r *Recorder) RecordStep(group string, step tracer.Step) {
+	r.record(Event{
+		Group: group,
+		Index: step.Pos,
+		Step: &Step{
+			Host: addrString(step.Host),
+			Prev: addrString(step.Prev),
+		},
+	})
+}
+
+func (r *Recorder) record(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failed {
+		return
+	}
+	ev.At = r.now().Sub(r.start)
+	if err := r.enc.Encode(ev); err != nil {
+		// Only complain once. The rest are sure to fail the same way.
+		log.Printf("Error recording session; recording stopped: %v", err)
+		r.failed = true
+	}
+}
+
+// Player reads events from a recording, pacing them to match the original
+// timing.
+type Player struct {
+	dec   *json.Decoder
+	speed float64
+	start time.Time
+
+	// For testing.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewPlayer creates a player that reads a recording from r. Speed scales the
+// playback rate: 1 plays in real time, 2 plays twice as fast and so on.
+func NewPlayer(r io.Reader, speed float64) *Player {
+	return &Player{
+		dec:   json.NewDecoder(r),
+		speed: speed,
+		now:   time.Now,
+		sleep: time.Sleep,
+	}
+}
+
+// Next waits until it's time for the next event and returns it. Playback
+// time starts with the first call. Returns [io.EOF] at the end of the
+// recording.
+func (p *Player) Next() (Event, error) {
+	var ev Event
+	if err := p.dec.Decode(&ev); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Event{}, io.EOF
+		}
+		return Event{}, fmt.Errorf("error reading recording: %v", err)
+	}
+	if (ev.Ping == nil) == (ev.Step == nil) {
+		return Event{}, fmt.Errorf("malformed event at %v: exactly one of ping or step must be set", ev.At)
+	}
+	if p.start.IsZero() {
+		p.start = p.now()
+	}
+	due := p.start.Add(time.Duration(float64(ev.At) / p.speed))
+	if wait := due.Sub(p.now()); wait > 0 {
+		p.sleep(wait)
+	}
+	return ev, nil
+}
+
+// TraceStep returns the recorded step for an event containing one.
+func (ev Event) TraceStep() tracer.Step {
+	return tracer.Step{
+		Pos:  ev.Index,
+		Host: parseAddr(ev.Step.Host),
+		Prev: parseAddr(ev.Step.Prev),
+	}
+}
+
+func addrString(addr net.Addr) string {
+	if ip := util.IP(addr); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// Converts an IP string back to an address. Uses the same address type as
+// lookup.String. Returns nil for empty or invalid strings.
+func parseAddr(s string) net.Addr {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	return &net.UDPAddr{IP: ip}
+}
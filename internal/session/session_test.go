@@ -0,0 +1,129 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/tracer"
+)
+
+var (
+	hostA = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	hostB = &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+)
+
+func TestRecordReplay(t *testing.T) {
+	start := time.Unix(1000, 0)
+	now := start
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.start = start
+	rec.now = func() time.Time { return now }
+
+	res := pinger.PingResult{
+		Type:    pinger.Success,
+		Time:    start.UTC(),
+		Latency: 12 * time.Millisecond,
+		Peer:    hostA,
+	}
+	now = start.Add(time.Second)
+	rec.RecordPing("a", 0, hostA, 7, res)
+	now = start.Add(3 * time.Second)
+	rec.RecordStep("b", tracer.Step{Pos: 2, Host: hostB, Prev: hostA})
+	now = start.Add(4 * time.Second)
+	rec.RecordPing("b", 2, hostB, 0, pinger.PingResult{Type: pinger.Dropped, Time: start.UTC()})
+
+	pnow := time.Unix(5000, 0)
+	var sleeps []time.Duration
+	p := NewPlayer(&buf, 2)
+	p.now = func() time.Time { return pnow }
+	p.sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		pnow = pnow.Add(d)
+	}
+
+	var got []Event
+	for {
+		ev, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		got = append(got, ev)
+	}
+
+	want := []Event{
+		{At: time.Second, Group: "a", Ping: &Ping{Addr: "192.0.2.1", Seq: 7, Type: pinger.Success, Time: start.UTC(), Latency: 12 * time.Millisecond, Peer: "192.0.2.1"}},
+		{At: 3 * time.Second, Group: "b", Index: 2, Step: &Step{Host: "2001:db8::1", Prev: "192.0.2.1"}},
+		{At: 4 * time.Second, Group: "b", Index: 2, Ping: &Ping{Addr: "2001:db8::1", Type: pinger.Dropped, Time: start.UTC()}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("Wrong events (-want, +got):\n%v", diff)
+	}
+
+	// Played back at double speed.
+	wantSleeps := []time.Duration{500 * time.Millisecond, time.Second, 500 * time.Millisecond}
+	if diff := cmp.Diff(wantSleeps, sleeps); diff != "" {
+		t.Errorf("Wrong sleeps (-want, +got):\n%v", diff)
+	}
+
+	if diff := cmp.Diff(res, got[0].Ping.Result(), cmp.Comparer(func(a, b net.Addr) bool { return a.String() == b.String() })); diff != "" {
+		t.Errorf("Wrong ping result (-want, +got):\n%v", diff)
+	}
+	step := got[1].TraceStep()
+	if step.Pos != 2 || step.Host.String() != hostB.String() || step.Prev.String() != hostA.String() {
+		t.Errorf("Wrong step: %+v", step)
+	}
+}
+
+func TestRecordReplay_ReplyData(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	res := pinger.PingResult{
+		Type:        pinger.Success,
+		Time:        time.Unix(1000, 0).UTC(),
+		Peer:        hostA,
+		Timestamps:  backend.Timestamps{Originate: 1, Receive: 2, Transmit: 3},
+		AddressMask: net.CIDRMask(24, 32),
+		TTL:         57,
+		Probes:      3,
+		Replies:     2,
+	}
+	rec.RecordPing("a", 0, hostA, 0, res)
+
+	p := NewPlayer(&buf, 1)
+	p.sleep = func(time.Duration) {}
+	ev, err := p.Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if diff := cmp.Diff(res, ev.Ping.Result(), cmp.Comparer(func(a, b net.Addr) bool { return a.String() == b.String() })); diff != "" {
+		t.Errorf("Wrong ping result (-want, +got):\n%v", diff)
+	}
+}
+
+func TestPlayer_Malformed(t *testing.T) {
+	cases := []string{
+		`{"at":1,"group":"a"}`,
+		`{"at":1,"group":"a","ping":{},"step":{}}`,
+		`{"at":1,`,
+	}
+	for _, c := range cases {
+		t.Run(c, func(t *testing.T) {
+			p := NewPlayer(strings.NewReader(c), 1)
+			if _, err := p.Next(); err == nil || errors.Is(err, io.EOF) {
+				t.Errorf("Next() = %v (want non-EOF error)", err)
+			}
+		})
+	}
+}
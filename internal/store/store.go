@@ -0,0 +1,426 @@
+// Package store keeps ping results in a SQLite database, so that monitoring
+// sessions lasting days survive restarts and can be queried afterward.
+//
+// Each result is kept for [Options.Retention]. Before results are deleted,
+// they're rolled up into one aggregate per target per [Options.RollupPeriod],
+// which are kept for [Options.RollupRetention]. Results are written in batches
+// by a single goroutine, so recording them never waits on the disk.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	// Registers the sqlite3 driver.
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Default option values.
+const (
+	DefaultRetention       = 7 * 24 * time.Hour
+	DefaultRollupPeriod    = time.Minute
+	DefaultRollupRetention = 365 * 24 * time.Hour
+	DefaultFlushInterval   = time.Second
+)
+
+const (
+	// The number of results that can wait to be written. Results beyond
+	// this are dropped rather than slowing down the pingers.
+	queueLen = 4096
+
+	// The most results written in a single transaction.
+	maxBatch = 1000
+
+	// How long after a period ends before it's rolled up. Results arrive
+	// up to a ping timeout after their pings are sent, and then wait up to
+	// a flush interval to be written.
+	rollupDelay = time.Minute
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS results (
+	time    INTEGER NOT NULL,
+	grp     TEXT NOT NULL,
+	idx     INTEGER NOT NULL,
+	addr    TEXT NOT NULL,
+	seq     INTEGER NOT NULL,
+	type    INTEGER NOT NULL,
+	latency INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_grp_time ON results (grp, time);
+CREATE INDEX IF NOT EXISTS results_time ON results (time);
+CREATE TABLE IF NOT EXISTS rollups (
+	start   INTEGER NOT NULL,
+	period  INTEGER NOT NULL,
+	grp     TEXT NOT NULL,
+	idx     INTEGER NOT NULL,
+	addr    TEXT NOT NULL,
+	sent    INTEGER NOT NULL,
+	replies INTEGER NOT NULL,
+	min     INTEGER,
+	avg     INTEGER,
+	max     INTEGER,
+	PRIMARY KEY (grp, start, idx, addr)
+);
+CREATE INDEX IF NOT EXISTS rollups_start ON rollups (start);
+CREATE TABLE IF NOT EXISTS state (
+	name  TEXT PRIMARY KEY,
+	value INTEGER NOT NULL
+);
+`
+
+// Options contains options for a store.
+type Options struct {
+	// Retention is how long results are kept.
+	Retention time.Duration
+
+	// RollupPeriod is the length of time each aggregate covers.
+	RollupPeriod time.Duration
+
+	// RollupRetention is how long aggregates are kept.
+	RollupRetention time.Duration
+
+	// FlushInterval is the longest a result waits to be written.
+	FlushInterval time.Duration
+}
+
+func setOptionDefaults(o *Options) *Options {
+	if o == nil {
+		o = &Options{}
+	}
+	util.MaybeSetDefault(&o.Retention, DefaultRetention)
+	util.MaybeSetDefault(&o.RollupPeriod, DefaultRollupPeriod)
+	util.MaybeSetDefault(&o.RollupRetention, DefaultRollupRetention)
+	util.MaybeSetDefault(&o.FlushInterval, DefaultFlushInterval)
+	return o
+}
+
+// Result is a stored ping result.
+type Result struct {
+	// Group and Index identify the target that was pinged.
+	Group string
+	Index int
+
+	// Addr is the address that was pinged.
+	Addr string
+
+	Seq     int
+	Type    pinger.ResultType
+	Time    time.Time
+	Latency time.Duration
+}
+
+// Rollup aggregates a target's results over a period.
+type Rollup struct {
+	// Group and Index identify the target that was pinged.
+	Group string
+	Index int
+
+	// Addr is the address that was pinged.
+	Addr string
+
+	// Start and Period give the span of time the rollup covers.
+	Start  time.Time
+	Period time.Duration
+
+	// Sent is the number of pings, not counting duplicates or gaps. Replies
+	// is the number that were successful.
+	Sent    int
+	Replies int
+
+	// The latencies of the successful pings. Zero if there were none.
+	MinLatency time.Duration
+	AvgLatency time.Duration
+	MaxLatency time.Duration
+}
+
+// Loss returns the fraction of pings without a successful reply.
+func (r Rollup) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Replies) / float64(r.Sent)
+}
+
+// Store writes ping results to a database. It's safe for concurrent use.
+type Store struct {
+	db   *sql.DB
+	opts *Options
+	now  func() time.Time // For test injection
+
+	results chan Result
+	done    chan any
+	stopped chan any
+
+	mu         sync.Mutex
+	closed     bool
+	dropped    int
+	lastRolled time.Time
+}
+
+// Open opens or creates a database, and starts writing results to it.
+func Open(path string, opts *Options) (*Store, error) {
+	return open(path, opts, time.Now)
+}
+
+func open(path string, opts *Options, now func() time.Time) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows one writer, and the rest wait on the busy
+	// timeout.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating tables in %v: %v", path, err)
+	}
+	s := &Store{
+		db:      db,
+		opts:    setOptionDefaults(opts),
+		now:     now,
+		results: make(chan Result, queueLen),
+		done:    make(chan any),
+		stopped: make(chan any),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Record queues a ping result to be written. Results still waiting for a
+// reply aren't recorded.
+func (s *Store) Record(group string, index int, target net.Addr, seq int, res pinger.PingResult) {
+	if res.Type == pinger.Waiting {
+		return
+	}
+	r := Result{
+		Group:   group,
+		Index:   index,
+		Addr:    addrString(target),
+		Seq:     seq,
+		Type:    res.Type,
+		Time:    res.Time,
+		Latency: res.Latency,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.results <- r:
+	default:
+		// Only complain once per backlog.
+		if s.dropped == 0 {
+			log.Printf("Store can't keep up; dropping results")
+		}
+		s.dropped++
+	}
+}
+
+// Writes results and maintains the rollups until the store is closed.
+func (s *Store) run() {
+	defer close(s.stopped)
+	tick := time.NewTicker(s.opts.FlushInterval)
+	defer tick.Stop()
+	var batch []Result
+	for {
+		select {
+		case r := <-s.results:
+			batch = append(batch, r)
+			if len(batch) < maxBatch {
+				continue
+			}
+		case <-tick.C:
+		case <-s.done:
+			// Record won't add any more, so this drains the queue.
+			for len(s.results) > 0 {
+				batch = append(batch, <-s.results)
+			}
+			s.flush(batch)
+			return
+		}
+		batch = s.flush(batch)
+		s.maintain()
+	}
+}
+
+// Writes a batch of results, and returns the batch emptied for reuse.
+func (s *Store) flush(batch []Result) []Result {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := s.insert(batch); err != nil {
+		log.Printf("Error storing %d results: %v", len(batch), err)
+	}
+	s.mu.Lock()
+	if s.dropped > 0 {
+		log.Printf("Store dropped %d results", s.dropped)
+		s.dropped = 0
+	}
+	s.mu.Unlock()
+	return batch[:0]
+}
+
+// Inserts results in a single transaction.
+func (s *Store) insert(batch []Result) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO results (time, grp, idx, addr, seq, type, latency) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range batch {
+		if _, err := stmt.Exec(r.Time.UnixNano(), r.Group, r.Index, r.Addr, r.Seq, int(r.Type), int64(r.Latency)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Rolls up the periods that have ended and deletes expired data, at most once
+// per period.
+func (s *Store) maintain() {
+	end := s.now().Add(-rollupDelay).Truncate(s.opts.RollupPeriod)
+	if !end.After(s.lastRolled) {
+		return
+	}
+	if err := s.rollup(end); err != nil {
+		log.Printf("Error rolling up results: %v", err)
+		return
+	}
+	s.lastRolled = end
+}
+
+// Rolls up the results from before end that haven't been yet, and deletes
+// expired data.
+func (s *Store) rollup(end time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var start int64
+	err = tx.QueryRow(`SELECT value FROM state WHERE name = 'rolled_until'`).Scan(&start)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	period := int64(s.opts.RollupPeriod)
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO rollups (start, period, grp, idx, addr, sent, replies, min, avg, max)
+		SELECT time / ?1 * ?1, ?1, grp, idx, addr,
+			COUNT(*),
+			SUM(type = ?4),
+			MIN(CASE WHEN type = ?4 THEN latency END),
+			AVG(CASE WHEN type = ?4 THEN latency END),
+			MAX(CASE WHEN type = ?4 THEN latency END)
+		FROM results
+		WHERE time >= ?2 AND time < ?3 AND type NOT IN (?5, ?6, ?7)
+		GROUP BY time / ?1, grp, idx, addr`,
+		period, start, end.UnixNano(), int(pinger.Success), int(pinger.Duplicate), int(pinger.Gap), int(pinger.Late)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO state (name, value) VALUES ('rolled_until', ?)`, end.UnixNano()); err != nil {
+		return err
+	}
+	now := s.now()
+	// Results are only deleted once they've been rolled up.
+	expired := min(now.Add(-s.opts.Retention).UnixNano(), end.UnixNano())
+	if _, err := tx.Exec(`DELETE FROM results WHERE time < ?`, expired); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM rollups WHERE start < ?`, now.Add(-s.opts.RollupRetention).UnixNano()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Results returns a group's stored results from the span [from, to), in time
+// order. Results recorded in the last [Options.FlushInterval] may not be
+// written yet.
+func (s *Store) Results(group string, from, to time.Time) ([]Result, error) {
+	rows, err := s.db.Query(`
+		SELECT time, idx, addr, seq, type, latency FROM results
+		WHERE grp = ? AND time >= ? AND time < ?
+		ORDER BY time, idx`,
+		group, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Result
+	for rows.Next() {
+		r := Result{Group: group}
+		var t, lat int64
+		if err := rows.Scan(&t, &r.Index, &r.Addr, &r.Seq, &r.Type, &lat); err != nil {
+			return nil, err
+		}
+		r.Time = time.Unix(0, t)
+		r.Latency = time.Duration(lat)
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// Rollups returns a group's rollups that start in the span [from, to), in
+// time order.
+func (s *Store) Rollups(group string, from, to time.Time) ([]Rollup, error) {
+	rows, err := s.db.Query(`
+		SELECT start, period, idx, addr, sent, replies, min, avg, max FROM rollups
+		WHERE grp = ? AND start >= ? AND start < ?
+		ORDER BY start, idx`,
+		group, from.UnixNano(), to.UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Rollup
+	for rows.Next() {
+		r := Rollup{Group: group}
+		var start, period int64
+		var minLat, avgLat, maxLat sql.NullFloat64
+		if err := rows.Scan(&start, &period, &r.Index, &r.Addr, &r.Sent, &r.Replies, &minLat, &avgLat, &maxLat); err != nil {
+			return nil, err
+		}
+		r.Start = time.Unix(0, start)
+		r.Period = time.Duration(period)
+		r.MinLatency = time.Duration(minLat.Float64)
+		r.AvgLatency = time.Duration(avgLat.Float64)
+		r.MaxLatency = time.Duration(maxLat.Float64)
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// Close writes any queued results and closes the database.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+	<-s.stopped
+	return s.db.Close()
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return util.IP(addr).String()
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+var (
+	start  = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	target = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+)
+
+// A clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+func openTest(t *testing.T, path string, clock *fakeClock) *Store {
+	t.Helper()
+	s, err := open(path, &Options{
+		Retention:       time.Hour,
+		RollupRetention: 24 * time.Hour,
+		FlushInterval:   time.Millisecond,
+	}, clock.Now)
+	if err != nil {
+		t.Fatalf("open error: %v", err)
+	}
+	return s
+}
+
+// Waits for the number of stored results for example.com to reach n.
+func waitForResults(t *testing.T, s *Store, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		res, err := s.Results("example.com", start, start.Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Results error: %v", err)
+		}
+		if len(res) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d results (want %d)", len(res), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func success(at time.Duration, lat time.Duration) pinger.PingResult {
+	return pinger.PingResult{Type: pinger.Success, Time: start.Add(at), Latency: lat}
+}
+
+func TestRecord_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pings.db")
+	clock := &fakeClock{now: start}
+	s := openTest(t, path, clock)
+	s.Record("example.com", 0, target, 0, success(0, 10*time.Millisecond))
+	s.Record("example.com", 0, target, 1, pinger.PingResult{Type: pinger.Waiting, Time: start.Add(time.Second)})
+	s.Record("example.com", 2, target, 1, pinger.PingResult{Type: pinger.Dropped, Time: start.Add(time.Second)})
+	s.Record("other.example", 0, target, 0, success(0, time.Millisecond))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	// Closing again is harmless, and recording after closing is ignored.
+	s.Close()
+	s.Record("example.com", 0, target, 5, success(5*time.Second, time.Millisecond))
+
+	s = openTest(t, path, clock)
+	defer s.Close()
+	got, err := s.Results("example.com", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Results error: %v", err)
+	}
+	want := []Result{
+		{Group: "example.com", Addr: "192.0.2.1", Seq: 0, Type: pinger.Success, Time: start, Latency: 10 * time.Millisecond},
+		{Group: "example.com", Index: 2, Addr: "192.0.2.1", Seq: 1, Type: pinger.Dropped, Time: start.Add(time.Second)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRollup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pings.db")
+	clock := &fakeClock{now: start}
+	s := openTest(t, path, clock)
+	defer s.Close()
+	s.Record("example.com", 0, target, 0, success(0, 10*time.Millisecond))
+	s.Record("example.com", 0, target, 1, success(time.Second, 30*time.Millisecond))
+	s.Record("example.com", 0, target, 1, pinger.PingResult{Type: pinger.Duplicate, Time: start.Add(time.Second)})
+	s.Record("example.com", 0, target, 2, pinger.PingResult{Type: pinger.Dropped, Time: start.Add(2 * time.Second)})
+	s.Record("example.com", 0, target, 3, pinger.PingResult{Type: pinger.Gap, Time: start.Add(3 * time.Second)})
+	s.Record("example.com", 0, target, 60, pinger.PingResult{Type: pinger.Dropped, Time: start.Add(time.Minute)})
+	s.Record("example.com", 0, target, 120, success(2*time.Minute, time.Millisecond))
+
+	waitForResults(t, s, 7)
+
+	// The first two periods are rolled up once enough time has passed.
+	clock.Set(start.Add(2*time.Minute + rollupDelay))
+	want := []Rollup{
+		{
+			Group: "example.com", Addr: "192.0.2.1", Start: start, Period: time.Minute, Sent: 3, Replies: 2,
+			MinLatency: 10 * time.Millisecond, AvgLatency: 20 * time.Millisecond, MaxLatency: 30 * time.Millisecond,
+		},
+		{Group: "example.com", Addr: "192.0.2.1", Start: start.Add(time.Minute), Period: time.Minute, Sent: 1},
+	}
+	var got []Rollup
+	deadline := time.Now().Add(5 * time.Second)
+	for !cmp.Equal(want, got) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		var err error
+		if got, err = s.Rollups("example.com", start, start.Add(time.Hour)); err != nil {
+			t.Fatalf("Rollups error: %v", err)
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong rollups (-want, +got):\n%v", diff)
+	}
+	if loss := got[0].Loss(); loss != 1.0/3 {
+		t.Errorf("Loss = %v (want 1/3)", loss)
+	}
+
+	// Much later, the results expire, but their rollups don't.
+	clock.Set(start.Add(2 * time.Hour))
+	waitForResults(t, s, 0)
+	got, err := s.Rollups("example.com", start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Rollups error: %v", err)
+	}
+	if len(got) != 3 {
+		t.Errorf("Got %d rollups (want 3): %v", len(got), got)
+	}
+}
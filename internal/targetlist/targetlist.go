@@ -0,0 +1,346 @@
+// Package targetlist reads lists of targets and keeps a [targets.Manager] in
+// step with them. A list has one host per line, optionally followed by
+// options for that host:
+//
+//	# Comments and blank lines are ignored.
+//	example.com
+//	192.0.2.1 trace interval=5s
+//	web.example.com protocol=http
+//
+// The options are:
+//
+//	ping            Ping the host, even in trace mode.
+//	trace           Trace the path to the host, and ping each hop.
+//	interval=DUR    Ping every DUR instead of the usual interval.
+//	protocol=NAME   Ping with the NAME backend.
+//	move=POLICY     When the host's name resolves to a new address, follow
+//	                it, or report it and keep pinging the old one.
+//	tab=NAME        Show the host in a tab named NAME in the text UI. Only
+//	                used for hosts given to the UI, not for those in a list.
+//	interface=NAME  Send from interface NAME. Naming a VRF device sends in
+//	                that VRF.
+//	netns=NAME      Send from the network namespace NAME, as created by
+//	                "ip netns add". Linux only.
+//
+// A host may also be written as a spec, which puts its options in a single
+// word. See [ParseSpec].
+package targetlist
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+// The shortest interval allowed. Matches the rate limit in the privsep
+// server.
+const minInterval = time.Second
+
+// Entry is a line in a target list.
+type Entry struct {
+	// Host is the name or address of the host.
+	Host string
+
+	// Options are the options that follow the host.
+	Options targets.HostOptions
+
+	// Tab is the name of the UI tab to show the host in, or empty for the
+	// default.
+	Tab string
+}
+
+// ParseMovePolicy parses the value of the move option: follow or report.
+func ParseMovePolicy(s string) (targets.MovePolicy, error) {
+	switch s {
+	case "follow":
+		return targets.FollowMove, nil
+	case "report":
+		return targets.ReportMove, nil
+	}
+	return targets.DefaultMove, fmt.Errorf("bad move policy %q: not follow or report", s)
+}
+
+// Matches the protocol at the start of a spec.
+var specProtocol = regexp.MustCompile(`^([a-z][a-z0-9]*)://`)
+
+// ParseSpec parses a host spec, such as one given on the command line. A spec
+// is a host, optionally preceded by a protocol and followed by a query of
+// options separated by &:
+//
+//	example.com
+//	udp://192.0.2.1?interval=5s
+//	http://[2001:db8::1]?trace&interval=2s
+//
+// The options are the same as in a list, and the protocol is the same as the
+// protocol option. IPv6 addresses may be put in brackets.
+func ParseSpec(spec string) (Entry, error) {
+	var e Entry
+	if m := specProtocol.FindStringSubmatch(spec); m != nil {
+		e.Options.PingBackend = backend.Name(m[1])
+		spec = spec[len(m[0]):]
+	}
+	host, query, _ := strings.Cut(spec, "?")
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	if host == "" || strings.ContainsAny(host, "/[]") {
+		return Entry{}, fmt.Errorf("bad host %q", host)
+	}
+	e.Host = host
+	if query == "" {
+		return e, nil
+	}
+	for _, opt := range strings.Split(query, "&") {
+		if err := parseOption(&e, opt); err != nil {
+			return Entry{}, err
+		}
+	}
+	return e, nil
+}
+
+// ParseLine parses a line of a target list. Returns false if the line is
+// blank or a comment.
+func ParseLine(line string) (Entry, bool, error) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return Entry{}, false, nil
+	}
+	e, err := ParseSpec(fields[0])
+	if err != nil {
+		return Entry{}, false, err
+	}
+	for _, f := range fields[1:] {
+		if err := parseOption(&e, f); err != nil {
+			return Entry{}, false, err
+		}
+	}
+	return e, true, nil
+}
+
+// Sets one of an entry's options.
+func parseOption(e *Entry, opt string) error {
+	name, val, hasVal := strings.Cut(opt, "=")
+	switch {
+	case name == "ping" && !hasVal:
+		e.Options.Mode = targets.PingMode
+	case name == "trace" && !hasVal:
+		e.Options.Mode = targets.TraceMode
+	case name == "interval" && hasVal:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return fmt.Errorf("bad interval: %v", err)
+		}
+		if d < minInterval {
+			return fmt.Errorf("interval may not be less than %v", minInterval)
+		}
+		e.Options.PingInterval = d
+	case name == "protocol" && hasVal && val != "":
+		e.Options.PingBackend = backend.Name(val)
+	case name == "move" && hasVal:
+		p, err := ParseMovePolicy(val)
+		if err != nil {
+			return err
+		}
+		e.Options.OnMove = p
+	case name == "tab" && hasVal && val != "":
+		e.Tab = val
+	case name == "interface" && hasVal && val != "":
+		e.Options.Interface = val
+	case name == "netns" && hasVal:
+		if val == "" || val == "." || val == ".." || strings.ContainsRune(val, '/') {
+			return fmt.Errorf("bad network namespace %q", val)
+		}
+		e.Options.Netns = val
+	default:
+		return fmt.Errorf("bad option %q", opt)
+	}
+	return nil
+}
+
+// Parse parses a target list. Errors give the number of the line at fault.
+func Parse(r io.Reader) ([]Entry, error) {
+	var res []Entry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		e, ok, err := ParseLine(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if ok {
+			res = append(res, e)
+		}
+	}
+	return res, sc.Err()
+}
+
+// List keeps a manager's targets in step with a target list. Targets added
+// some other way are left alone. It's safe for concurrent use.
+type List struct {
+	m       *targets.Manager
+	resolve func(string) (net.Addr, error) // For test injection
+
+	mu     sync.Mutex
+	active map[Entry]string // The group of each entry's targets.
+	loaded []byte           // The contents of the file last loaded.
+}
+
+// New creates a list that adds targets to m.
+func New(m *targets.Manager) *List {
+	return &List{
+		m:       m,
+		resolve: resolve,
+		active:  make(map[Entry]string),
+	}
+}
+
+// Looks up a host with [lookup.String].
+func resolve(host string) (net.Addr, error) {
+	addr, err := lookup.String(host)
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// Add adds the targets for an entry, unless they're already there.
+func (l *List) Add(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.add(e)
+}
+
+// Must be called with l.mu held.
+func (l *List) add(e Entry) {
+	if _, ok := l.active[e]; ok {
+		return
+	}
+	addr, err := l.resolve(e.Host)
+	if err != nil {
+		log.Printf("Error looking up %q: %v", e.Host, err)
+		return
+	}
+	group, err := l.m.AddHost(e.Host, addr, e.Options)
+	if err != nil {
+		log.Printf("Not adding %q: %v", e.Host, err)
+		return
+	}
+	l.active[e] = group
+}
+
+// Set makes the list's targets match entries. Targets for entries that are
+// gone or whose options changed are removed, and new ones are added.
+func (l *List) Set(entries []Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keep := make(map[Entry]bool)
+	for _, e := range entries {
+		keep[e] = true
+	}
+	for e, group := range l.active {
+		if !keep[e] {
+			l.m.RemoveGroup(group)
+			delete(l.active, e)
+		}
+	}
+	for _, e := range entries {
+		l.add(e)
+	}
+}
+
+// Read adds an entry for each line read from r until it ends. Bad lines are
+// logged and skipped. Since entries are added as they arrive, this suits
+// lists piped in from another program.
+func (l *List) Read(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		e, ok, err := ParseLine(sc.Text())
+		if err != nil {
+			log.Printf("Bad target on line %d: %v", n, err)
+			continue
+		}
+		if ok {
+			l.Add(e)
+		}
+	}
+	return sc.Err()
+}
+
+// LoadFile sets the list's targets to the entries in a file.
+func (l *List) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return l.load(b)
+}
+
+// Sets the list's targets from a file's contents.
+func (l *List) load(b []byte) error {
+	entries, err := Parse(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	l.Set(entries)
+	l.mu.Lock()
+	l.loaded = b
+	l.mu.Unlock()
+	return nil
+}
+
+// Returns true if b is the same as the file last loaded.
+func (l *List) unchanged(b []byte) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loaded != nil && bytes.Equal(b, l.loaded)
+}
+
+// WatchFile rereads a file every interval, and updates the targets when its
+// contents change, until ctx is done. A file that can't be read or parsed
+// leaves the targets as they were. Call [List.LoadFile] first to load the
+// file right away.
+func (l *List) WatchFile(ctx context.Context, path string, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+		b, err := os.ReadFile(path)
+		if err == nil && l.unchanged(b) {
+			lastErr = nil
+			continue
+		}
+		if err == nil {
+			err = l.load(b)
+		}
+		if err != nil {
+			// Only log each error once, rather than every interval.
+			if lastErr == nil || err.Error() != lastErr.Error() {
+				log.Printf("Error reloading target list %v: %v", path, err)
+			}
+			lastErr = err
+			continue
+		}
+		log.Printf("Reloaded target list %v", path)
+		lastErr = nil
+	}
+}
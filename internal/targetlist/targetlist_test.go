@@ -0,0 +1,253 @@
+package targetlist
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A connection that never gets replies.
+type silentConn struct {
+	once sync.Once
+	done chan any
+}
+
+func (c *silentConn) WriteTo(*backend.Packet, net.Addr, ...backend.WriteOption) error {
+	return nil
+}
+
+func (c *silentConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, nil, backend.ErrTimeout
+	case <-c.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *silentConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// Creates a list whose pings go nowhere, and whose hosts all resolve to
+// 192.0.2.1 unless they're named "bogus".
+func newTestList(t *testing.T) (*List, *targets.Manager) {
+	t.Helper()
+	name := backend.Name("silent:" + t.Name())
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		return &silentConn{done: make(chan any)}, nil
+	})
+	m := targets.New(&targets.Options{PingBackend: name})
+	t.Cleanup(func() { m.Close() })
+	l := New(m)
+	l.resolve = func(host string) (net.Addr, error) {
+		if host == "bogus" {
+			return nil, errors.New("no such host")
+		}
+		return &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, nil
+	}
+	return l, m
+}
+
+// Returns the groups of a manager's targets.
+func groups(m *targets.Manager) []string {
+	var res []string
+	for _, t := range m.Targets() {
+		res = append(res, t.Group)
+	}
+	return slices.Compact(res)
+}
+
+func TestParseLine(t *testing.T) {
+	cases := []struct {
+		Line    string
+		Want    Entry
+		WantOK  bool
+		WantErr bool
+	}{
+		{Line: "", WantOK: false},
+		{Line: "   # Just a comment", WantOK: false},
+		{Line: "example.com", Want: Entry{Host: "example.com"}, WantOK: true},
+		{Line: "  example.com  # Trailing comment", Want: Entry{Host: "example.com"}, WantOK: true},
+		{
+			Line:   "192.0.2.1 trace interval=5s protocol=udp",
+			Want:   Entry{Host: "192.0.2.1", Options: targets.HostOptions{Mode: targets.TraceMode, PingInterval: 5 * time.Second, PingBackend: "udp"}},
+			WantOK: true,
+		},
+		{Line: "udp://example.com trace", Want: Entry{Host: "example.com", Options: targets.HostOptions{Mode: targets.TraceMode, PingBackend: "udp"}}, WantOK: true},
+		{Line: "example.com ping", Want: Entry{Host: "example.com", Options: targets.HostOptions{Mode: targets.PingMode}}, WantOK: true},
+		{Line: "example.com interval=fast", WantErr: true},
+		{Line: "example.com interval=100ms", WantErr: true},
+		{Line: "example.com tab=web", Want: Entry{Host: "example.com", Tab: "web"}, WantOK: true},
+		{Line: "example.com move=report", Want: Entry{Host: "example.com", Options: targets.HostOptions{OnMove: targets.ReportMove}}, WantOK: true},
+		{Line: "example.com move=stay", WantErr: true},
+		{
+			Line:   "192.0.2.1 interface=vrf-blue netns=red",
+			Want:   Entry{Host: "192.0.2.1", Options: targets.HostOptions{Interface: "vrf-blue", Netns: "red"}},
+			WantOK: true,
+		},
+		{Line: "example.com netns=../etc", WantErr: true},
+		{Line: "example.com interface=", WantErr: true},
+		{Line: "example.com protocol=", WantErr: true},
+		{Line: "example.com tab=", WantErr: true},
+		{Line: "example.com trace=yes", WantErr: true},
+		{Line: "example.com bogus", WantErr: true},
+	}
+	for _, c := range cases {
+		got, ok, err := ParseLine(c.Line)
+		if (err != nil) != c.WantErr {
+			t.Errorf("ParseLine(%q) error = %v (want error %v)", c.Line, err, c.WantErr)
+			continue
+		}
+		if ok != c.WantOK {
+			t.Errorf("ParseLine(%q) ok = %v (want %v)", c.Line, ok, c.WantOK)
+		}
+		if diff := cmp.Diff(c.Want, got); diff != "" {
+			t.Errorf("ParseLine(%q) wrong entry (-want, +got):\n%v", c.Line, diff)
+		}
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	cases := []struct {
+		Spec    string
+		Want    Entry
+		WantErr bool
+	}{
+		{Spec: "example.com", Want: Entry{Host: "example.com"}},
+		{Spec: "2001:db8::1", Want: Entry{Host: "2001:db8::1"}},
+		{Spec: "udp://192.0.2.1", Want: Entry{Host: "192.0.2.1", Options: targets.HostOptions{PingBackend: "udp"}}},
+		{
+			Spec: "http://[2001:db8::1]?trace&interval=2s",
+			Want: Entry{Host: "2001:db8::1", Options: targets.HostOptions{Mode: targets.TraceMode, PingInterval: 2 * time.Second, PingBackend: "http"}},
+		},
+		{Spec: "example.com?protocol=dns", Want: Entry{Host: "example.com", Options: targets.HostOptions{PingBackend: "dns"}}},
+		{Spec: "example.com?trace&tab=paths", Want: Entry{Host: "example.com", Options: targets.HostOptions{Mode: targets.TraceMode}, Tab: "paths"}},
+		{Spec: "udp://192.0.2.1?netns=red", Want: Entry{Host: "192.0.2.1", Options: targets.HostOptions{PingBackend: "udp", Netns: "red"}}},
+		{Spec: "udp://", WantErr: true},
+		{Spec: "?trace", WantErr: true},
+		{Spec: "http://example.com/path", WantErr: true},
+		{Spec: "udp://192.0.2.1?interval=200ms", WantErr: true},
+		{Spec: "example.com?bogus", WantErr: true},
+	}
+	for _, c := range cases {
+		got, err := ParseSpec(c.Spec)
+		if (err != nil) != c.WantErr {
+			t.Errorf("ParseSpec(%q) error = %v (want error %v)", c.Spec, err, c.WantErr)
+			continue
+		}
+		if diff := cmp.Diff(c.Want, got); diff != "" {
+			t.Errorf("ParseSpec(%q) wrong entry (-want, +got):\n%v", c.Spec, diff)
+		}
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	_, err := Parse(strings.NewReader("a.example\n\nb.example bogus\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Parse error = %v (want error on line 3)", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	l, m := newTestList(t)
+	l.Set([]Entry{{Host: "a.example"}, {Host: "b.example"}, {Host: "bogus"}})
+	if diff := cmp.Diff([]string{"a.example", "b.example"}, groups(m)); diff != "" {
+		t.Errorf("Wrong groups after first Set (-want, +got):\n%v", diff)
+	}
+	a, _ := m.Target(targets.Key{Group: "a.example"})
+
+	// Changing b's options replaces it, and a is left alone.
+	l.Set([]Entry{{Host: "a.example"}, {Host: "b.example", Options: targets.HostOptions{PingInterval: 2 * time.Second}}, {Host: "c.example"}})
+	if diff := cmp.Diff([]string{"a.example", "b.example", "c.example"}, groups(m)); diff != "" {
+		t.Errorf("Wrong groups after second Set (-want, +got):\n%v", diff)
+	}
+	if got, _ := m.Target(targets.Key{Group: "a.example"}); got.Pinger != a.Pinger {
+		t.Errorf("Unchanged entry was replaced")
+	}
+	if b, _ := m.Target(targets.Key{Group: "b.example"}); b.Pinger.Interval() != 2*time.Second {
+		t.Errorf("Changed entry has interval %v (want 2s)", b.Pinger.Interval())
+	}
+
+	// Targets added elsewhere are left alone.
+	if _, err := m.Add("other.example", &net.UDPAddr{IP: net.ParseIP("192.0.2.2")}); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	l.Set(nil)
+	if diff := cmp.Diff([]string{"other.example"}, groups(m)); diff != "" {
+		t.Errorf("Wrong groups after last Set (-want, +got):\n%v", diff)
+	}
+}
+
+func TestRead(t *testing.T) {
+	l, m := newTestList(t)
+	if err := l.Read(strings.NewReader("a.example\nb.example bogus\n# c.example\nd.example\na.example\n")); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a.example", "d.example"}, groups(m)); diff != "" {
+		t.Errorf("Wrong groups (-want, +got):\n%v", diff)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	l, m := newTestList(t)
+	path := filepath.Join(t.TempDir(), "targets")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+	}
+	// Waits for the targets to match want.
+	waitFor := func(want []string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !slices.Equal(groups(m), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Groups = %v (want %v)", groups(m), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	write("a.example\nb.example\n")
+	if err := l.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile error: %v", err)
+	}
+	waitFor([]string{"a.example", "b.example"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan any)
+	go func() {
+		l.WatchFile(ctx, path, time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	write("b.example\nc.example\n")
+	waitFor([]string{"b.example", "c.example"})
+
+	// A bad list leaves the targets alone.
+	write("d.example bogus\n")
+	time.Sleep(20 * time.Millisecond)
+	waitFor([]string{"b.example", "c.example"})
+
+	write("")
+	waitFor(nil)
+}
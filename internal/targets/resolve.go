@@ -0,0 +1,109 @@
+package targets
+
+import (
+	"cmp"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Looks up a host with [lookup.Recheck]. Returns nil if it hasn't moved.
+func recheck(host string, addr net.Addr) (net.Addr, error) {
+	moved, err := lookup.Recheck(host, addr)
+	if moved == nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+// Looks up the names of hosts again every [Options.ReResolveInterval] until
+// the manager is closed.
+func (m *Manager) reResolve() {
+	tick := time.NewTicker(m.opts.ReResolveInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-tick.C:
+		}
+		m.recheckAll()
+	}
+}
+
+// Looks up the names of all the hosts added by name, and acts on any that
+// have moved.
+func (m *Manager) recheckAll() {
+	m.mu.Lock()
+	var named []*Target
+	for _, t := range m.targets {
+		if t.hopts != nil {
+			named = append(named, t)
+		}
+	}
+	m.mu.Unlock()
+	for _, t := range named {
+		addr, err := m.recheck(t.Group, t.Addr)
+		if err != nil {
+			// Keeps pinging the old address through DNS outages.
+			log.Printf("Error looking up %v again: %v", t.Group, err)
+			continue
+		}
+		m.moved(t, addr)
+	}
+}
+
+// Acts on a host whose name resolves to addr, or to its current address if
+// addr is nil.
+func (m *Manager) moved(t *Target, addr net.Addr) {
+	if addr == nil {
+		m.mu.Lock()
+		t.movedTo = nil
+		m.mu.Unlock()
+		return
+	}
+	switch cmp.Or(t.hopts.OnMove, m.opts.OnMove) {
+	case FollowMove:
+		m.follow(t, addr)
+	case ReportMove:
+		m.report(t, addr)
+	}
+}
+
+// Replaces a host's target with one for its new address.
+func (m *Manager) follow(old *Target, addr net.Addr) {
+	t, err := m.newTarget(old.Key, addr, *old.hopts, nil)
+	if err != nil {
+		log.Printf("Error following %v to %v: %v", old.Group, addr, err)
+		return
+	}
+	t.hopts = old.hopts
+	m.mu.Lock()
+	// Leaves alone hosts removed while they were being looked up.
+	if m.closed || m.targets[old.Key] != old {
+		m.mu.Unlock()
+		t.Pinger.Close()
+		return
+	}
+	log.Printf("%v moved from %v to %v", old.Group, old.Addr, addr)
+	m.put(t)
+	go t.Pinger.Run(m.ctx)
+	m.mu.Unlock()
+	m.pingCLAT(old.Group, addr, *old.hopts)
+}
+
+// Sends a Moved event for a host, unless it's already been sent for the
+// same address.
+func (m *Manager) report(t *Target, addr net.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.targets[t.Key] != t || util.IP(t.movedTo).Equal(util.IP(addr)) {
+		return
+	}
+	log.Printf("%v moved from %v to %v; still pinging %[2]v", t.Group, t.Addr, addr)
+	t.movedTo = addr
+	m.notify(Event{Type: Moved, Target: *t, NewAddr: addr})
+}
@@ -0,0 +1,53 @@
+package targets
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/netns"
+)
+
+// CheckSource returns an error if connections can't be sent from src with
+// the given backends. It's checked before a host is added, since the
+// privileged helper exits on any error opening a connection.
+func CheckSource(src backend.SourceOption, backends ...backend.Name) error {
+	if src.Netns != "" {
+		if err := netns.Check(src.Netns); err != nil {
+			return fmt.Errorf("bad netns: %v", err)
+		}
+	} else if src.Interface != "" {
+		// An interface in another namespace can't be seen from here.
+		if _, err := net.InterfaceByName(src.Interface); err != nil {
+			return fmt.Errorf("bad interface: %v", err)
+		}
+	}
+	if src.Mark == 0 && src.Netns == "" {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("fwmark and netns are not supported on %s", runtime.GOOS)
+	}
+	for _, be := range backends {
+		if be != "icmp" && be != "udp" {
+			return fmt.Errorf("fwmark and netns require the icmp or udp backend, not %v", be)
+		}
+	}
+	return nil
+}
+
+// Checks the source of a host with options of its own. Its pings use the
+// ping backend, and in trace mode the path is traced with the trace backend
+// too.
+func (m *Manager) checkHostSource(addr net.Addr, hopts HostOptions, trace bool) error {
+	if hopts.Interface == "" && hopts.Netns == "" {
+		return nil
+	}
+	backends := []backend.Name{cmp.Or(hopts.PingBackend, m.opts.PingBackend)}
+	if trace {
+		backends = append(backends, m.opts.TraceBackend)
+	}
+	return CheckSource(m.SourceFor(addr, hopts), backends...)
+}
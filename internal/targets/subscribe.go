@@ -0,0 +1,139 @@
+package targets
+
+import (
+	"net"
+	"sync"
+)
+
+// EventType is the type of a change to the targets.
+type EventType int
+
+// Event types.
+const (
+	// Added means a target was added. A target that replaces another with
+	// the same key comes after the old one's Removed event.
+	Added EventType = iota
+
+	// Removed means a target was removed and its pinger stopped.
+	Removed
+
+	// Failed means a trace failed. Only the target's group is set.
+	Failed
+
+	// Moved means a host's name resolved to a new address, and the target
+	// was left pinging the old one. See [ReportMove].
+	Moved
+
+	// PassComplete means a trace finished a pass through its path. Only the
+	// target's group is set, and Reached says whether the pass got to the
+	// destination.
+	PassComplete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case Added:
+		return "Added"
+	case Removed:
+		return "Removed"
+	case Failed:
+		return "Failed"
+	case Moved:
+		return "Moved"
+	case PassComplete:
+		return "PassComplete"
+	default:
+		return "(unknown)"
+	}
+}
+
+// Event is a change to the targets.
+type Event struct {
+	Type   EventType
+	Target Target
+
+	// Err is the reason for a failure.
+	Err error
+
+	// NewAddr is the address a Moved target's host now resolves to.
+	NewAddr net.Addr
+
+	// Reached is true if a completed pass reached the destination.
+	Reached bool
+}
+
+// Subscription receives events from a manager in the order they happened.
+// Events are queued until they're read, so a slow reader never holds up the
+// manager.
+type Subscription struct {
+	m *Manager
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+	closed bool
+}
+
+// Subscribe returns a subscription to changes to the targets. It starts with
+// an Added event for each existing target.
+func (m *Manager) Subscribe() *Subscription {
+	s := &Subscription{m: m}
+	s.cond = sync.NewCond(&s.mu)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.sortedTargets() {
+		s.events = append(s.events, Event{Type: Added, Target: t})
+	}
+	if m.closed {
+		s.closed = true
+	} else {
+		m.subs[s] = true
+	}
+	return s
+}
+
+// Sends an event to all subscribers. Must be called with m.mu held.
+func (m *Manager) notify(ev Event) {
+	for s := range m.subs {
+		s.push(ev)
+	}
+}
+
+func (s *Subscription) push(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+	s.cond.Signal()
+}
+
+// Next waits for the next event. Returns false once the subscription or the
+// manager has been closed and all the queued events have been read.
+func (s *Subscription) Next() (Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.events) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.events) == 0 {
+		return Event{}, false
+	}
+	ev := s.events[0]
+	s.events[0] = Event{}
+	s.events = s.events[1:]
+	return ev, true
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.m.mu.Lock()
+	delete(s.m.subs, s)
+	s.m.mu.Unlock()
+	s.close()
+}
+
+func (s *Subscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+}
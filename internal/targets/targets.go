@@ -0,0 +1,861 @@
+// Package targets manages the pingers and traces for a set of targets, apart
+// from how they're displayed. User interfaces, the control API and anything
+// else interested in the targets subscribe to the manager to find out when
+// they're added and removed.
+package targets
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pcekm/vasily/internal/alert"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/session"
+	"github.com/pcekm/vasily/internal/store"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+var (
+	// ErrExists is returned when adding a target that's already there.
+	ErrExists = errors.New("already exists")
+
+	// ErrReplaying is returned when adding targets while replaying a
+	// recording.
+	ErrReplaying = errors.New("replaying a recording")
+
+	// ErrClosed is returned when adding targets after the manager is closed.
+	ErrClosed = errors.New("manager closed")
+)
+
+// Key uniquely identifies a target.
+type Key struct {
+	// Group is used to group related targets, such as all the hosts in a
+	// path.
+	Group string
+
+	// Index is the numeric index of the target within its group. For the
+	// hops in a path, this is the hop's distance.
+	Index int
+}
+
+// Options contains options for the manager.
+type Options struct {
+	// Trace activates traceroute mode. [Manager.Add] traces the path to each
+	// host and pings each step in the path.
+	Trace bool
+
+	// PingInterval is the interval that pings are sent.
+	PingInterval time.Duration
+
+	// Count, if nonzero, is the number of pings each target sends before its
+	// pinger stops. Continuous traces probe their paths this many times. See
+	// [Manager.Finished].
+	Count int
+
+	// Deadline, if nonzero, stops every pinger and trace this long after the
+	// manager is created, however many pings they've sent.
+	Deadline time.Duration
+
+	// AdaptiveInterval adjusts the ping interval for each host based on its
+	// latency and loss. PingInterval becomes the minimum interval.
+	AdaptiveInterval bool
+
+	// Flood sends pings as fast as replies come back, ignoring PingInterval.
+	// Requires root.
+	Flood bool
+
+	// FloodMaxPPS caps the pings sent per second to each host in flood mode.
+	// Zero means no cap.
+	FloodMaxPPS int
+
+	// PingBackend is the backend to use for pings.
+	PingBackend backend.Name
+
+	// PingRequest is the type of request to ping IPv4 hosts with. IPv6
+	// hosts always get echo requests, since there's no IPv6 equivalent of
+	// the others.
+	PingRequest backend.PacketType
+
+	// FlowLabel is the flow label for IPv6 pings. Zero means none.
+	FlowLabel uint32
+
+	// ProbesPerInterval is the number of pings sent to each host per
+	// interval. Each burst is counted as a single result.
+	ProbesPerInterval int
+
+	// BurstLatency chooses how the latencies in a burst are combined.
+	BurstLatency pinger.BurstLatency
+
+	// OutageThreshold is the number of consecutive lost pings that count as
+	// an outage. Defaults to the pinger's default.
+	OutageThreshold int
+
+	// TraceInterval is the interval between route trace probes.
+	TraceInterval time.Duration
+
+	// TraceBackend is the backend to use for traces.
+	TraceBackend backend.Name
+
+	// TraceMaxTTL is the maximum ttl to trace.
+	TraceMaxTTL int
+
+	// ProbesPerHop is the number of times to probe for responses at each ttl.
+	// Continuous traces ignore it.
+	ProbesPerHop int
+
+	// ContinuousTrace keeps re-tracing paths and replaces hop targets when
+	// they change. Hop statistics come from the trace probes themselves
+	// instead of pinging each hop separately.
+	ContinuousTrace bool
+
+	// ParisTrace keeps all of a trace's probes in one flow so that load
+	// balancers send them along the same path.
+	ParisTrace bool
+
+	// TraceBasePort, TracePortStride and TraceFixedPort choose the ports
+	// trace probes go to with backends that use them. See
+	// [tracer.Options.BasePort].
+	TraceBasePort   int
+	TracePortStride int
+	TraceFixedPort  bool
+
+	// ReturnPath estimates the length of each hop's return path from the
+	// TTLs of its replies, and logs hops whose return paths look different
+	// from the forward path. See [tracer.Options.ReturnPath].
+	ReturnPath bool
+
+	// PayloadSize is the number of data bytes to send in each ping.
+	PayloadSize int
+
+	// PayloadPattern is repeated to fill ping payloads. If empty, payloads
+	// are random.
+	PayloadPattern []byte
+
+	// VerifyPayload checks that ping replies echo their payloads intact.
+	// See [pinger.Options.VerifyPayload].
+	VerifyPayload bool
+
+	// PayloadTimestamp times ping replies from the send time in their
+	// payloads. See [pinger.Options.PayloadTimestamp].
+	PayloadTimestamp bool
+
+	// Source binds all connections to a local interface or address. The
+	// address is only used for hosts of the same IP version.
+	Source backend.SourceOption
+
+	// DNSQuery is the query sent by the dns ping backend.
+	DNSQuery backend.DNSQueryOption
+
+	// HTTP sets the requests sent by the http ping backend.
+	HTTP backend.HTTPOption
+
+	// RecvBuffer is the size in bytes of the receive buffers of ping
+	// sockets. Zero for the system default.
+	RecvBuffer int
+
+	// CLATPrefix turns on 464XLAT testing with a NAT64 prefix. A host
+	// pinged at an address in the prefix is also pinged at the IPv4 address
+	// embedded in it, which goes through the local CLAT rather than straight
+	// to the NAT64, in a group of its own named by [CLATGroup].
+	CLATPrefix *net.IPNet
+
+	// Recorder, if set, records every ping result and trace step.
+	Recorder *session.Recorder
+
+	// Store, if set, stores every ping result.
+	Store *store.Store
+
+	// AlertRules are thresholds that notify AlertNotifier when exceeded.
+	AlertRules []alert.Rule
+
+	// AlertNotifier acts on alerts. By default they're only logged.
+	AlertNotifier *alert.Notifier
+
+	// ReResolveInterval is how often the names of pinged hosts are looked up
+	// again, to catch them moving to a new address. Zero means never. Traces
+	// are grouped by address, so they aren't looked up again.
+	ReResolveInterval time.Duration
+
+	// OnMove chooses what happens when a host's name resolves to a new
+	// address. Defaults to FollowMove.
+	OnMove MovePolicy
+
+	// Labels are free-text labels for targets, keyed by address or by the
+	// name a host was added by. See [Manager.SetLabel].
+	Labels map[string]string
+}
+
+func setOptionDefaults(o *Options) *Options {
+	if o == nil {
+		o = &Options{}
+	}
+	util.MaybeSetDefault(&o.PingInterval, time.Second)
+	util.MaybeSetDefault(&o.PingBackend, "icmp")
+	util.MaybeSetDefault(&o.TraceInterval, time.Second)
+	util.MaybeSetDefault(&o.TraceBackend, "udp")
+	util.MaybeSetDefault(&o.TraceMaxTTL, 64)
+	util.MaybeSetDefault(&o.ProbesPerHop, 3)
+	util.MaybeSetDefault(&o.AlertNotifier, &alert.Notifier{})
+	util.MaybeSetDefault(&o.OnMove, FollowMove)
+	return o
+}
+
+// Mode says whether a host is pinged or traced.
+type Mode int
+
+// Values for Mode.
+const (
+	// DefaultMode uses the mode in [Options.Trace].
+	DefaultMode Mode = iota
+
+	// PingMode pings the host.
+	PingMode
+
+	// TraceMode traces the path to the host, and pings each hop.
+	TraceMode
+)
+
+// MovePolicy says what happens when a host's name resolves to a new address.
+type MovePolicy int
+
+// Values for MovePolicy.
+const (
+	// DefaultMove uses the policy in [Options.OnMove].
+	DefaultMove MovePolicy = iota
+
+	// FollowMove replaces the host's target with one for the new address.
+	FollowMove
+
+	// ReportMove keeps pinging the old address, and sends a Moved event.
+	ReportMove
+)
+
+// HostOptions override the manager's options for one host. Zero values keep
+// the manager's.
+type HostOptions struct {
+	// Mode chooses between pinging the host and tracing the path to it.
+	Mode Mode
+
+	// PingInterval is the interval that pings are sent. In trace mode, it
+	// applies to the pings of each hop.
+	PingInterval time.Duration
+
+	// PingBackend is the backend to use for pings. In trace mode, it applies
+	// to the pings of each hop, and the trace uses the manager's backend.
+	PingBackend backend.Name
+
+	// OnMove chooses what happens when the host's name resolves to a new
+	// address.
+	OnMove MovePolicy
+
+	// Interface is the interface or VRF device to send from. It applies to
+	// the trace as well as the pings.
+	Interface string
+
+	// Netns is the named network namespace to send from. It applies to the
+	// trace as well as the pings.
+	Netns string
+}
+
+// Target is a host being pinged, or whose results come from elsewhere.
+type Target struct {
+	Key
+
+	// Addr is the address of the target.
+	Addr net.Addr
+
+	// Pinger holds the target's results.
+	Pinger *pinger.Pinger
+
+	// Alert evaluates the alert rules that apply to the target. Nil if none
+	// do.
+	Alert *alert.Evaluator
+
+	// Replayed is true if the results come from a recording.
+	Replayed bool
+
+	// Extensions holds the ICMP extensions (such as MPLS labels) last seen
+	// from a traced hop. Nil if there were none.
+	Extensions *backend.Extensions
+
+	// ReturnHops is the estimated length of the path a traced hop's replies
+	// take back, or zero if unknown. See [tracer.ReturnHops].
+	ReturnHops int
+
+	// Backend is the backend the target is probed with. Targets whose
+	// results are fed in have the one they'd be pinged with.
+	Backend backend.Name
+
+	// Source is what the target's connections are bound to.
+	Source backend.SourceOption
+
+	// Label is the user's label for the target, or empty if it has none.
+	Label string
+
+	// True if results are fed in rather than coming from the pinger itself.
+	fed bool
+
+	// The options of a host added by name, for looking it up again. Nil for
+	// other targets.
+	hopts *HostOptions
+
+	// The address the host was last reported to have moved to.
+	movedTo net.Addr
+}
+
+// Manager owns the pingers and traces for a set of targets. It's safe for
+// concurrent use.
+type Manager struct {
+	opts *Options
+
+	// Connections shared by the pingers, and by the traces.
+	pool      *pinger.Pool
+	tracePool *tracer.Pool
+
+	recheck func(host string, addr net.Addr) (net.Addr, error) // For testing.
+
+	// Canceled on close, or at the deadline, to stop the pingers and traces.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	targets   map[Key]*Target
+	traces    map[string]*trace
+	clat      map[string]bool // Groups with a CLATGroup.
+	labels    map[string]string
+	subs      map[*Subscription]bool
+	replaying bool
+	closed    bool
+
+	// Pingers and traces that are still running, and closed once they've
+	// all finished with a Count, or the Deadline has passed.
+	running  int
+	finished chan struct{}
+}
+
+// New creates a new manager.
+func New(opts *Options) *Manager {
+	opts = setOptionDefaults(opts)
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if opts.Deadline > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), opts.Deadline)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	m := &Manager{
+		opts:      opts,
+		pool:      pinger.NewPool(),
+		tracePool: tracer.NewPool(),
+		recheck:   recheck,
+		ctx:       ctx,
+		cancel:    cancel,
+		targets:   make(map[Key]*Target),
+		traces:    make(map[string]*trace),
+		clat:      make(map[string]bool),
+		labels:    maps.Clone(opts.Labels),
+		subs:      make(map[*Subscription]bool),
+		finished:  make(chan struct{}),
+	}
+	if m.opts.ReResolveInterval > 0 {
+		go m.reResolve()
+	}
+	context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.finish()
+		}
+	})
+	return m
+}
+
+// Add starts pinging a host, or tracing the path to it in trace mode. Returns
+// the group of the host's targets.
+func (m *Manager) Add(host string, addr net.Addr) (string, error) {
+	return m.AddHost(host, addr, HostOptions{})
+}
+
+// AddHost adds a host like [Manager.Add], with options of its own.
+func (m *Manager) AddHost(host string, addr net.Addr, hopts HostOptions) (string, error) {
+	trace := hopts.Mode == TraceMode || (hopts.Mode == DefaultMode && m.opts.Trace)
+	if err := m.checkHostSource(addr, hopts, trace); err != nil {
+		return "", fmt.Errorf("%v: %w", host, err)
+	}
+	if trace {
+		return m.trace(addr, hopts)
+	}
+	m.mu.Lock()
+	err := m.checkAdd(host)
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	t, err := m.newTarget(Key{Group: host}, addr, hopts, nil)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) == nil {
+		t.hopts = &hopts
+	}
+	if err := m.start(t); err != nil {
+		return "", err
+	}
+	m.pingCLAT(host, addr, hopts)
+	return host, nil
+}
+
+// Pings a host through the local CLAT if it's reached through
+// [Options.CLATPrefix]. Otherwise removes any CLAT target left from an
+// earlier address.
+func (m *Manager) pingCLAT(host string, addr net.Addr, hopts HostOptions) {
+	if m.opts.CLATPrefix == nil {
+		return
+	}
+	ip4 := lookup.NAT64Embedded(m.opts.CLATPrefix, util.IP(addr))
+	if ip4 == nil {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.clat[host] {
+			delete(m.clat, host)
+			for _, k := range m.groupKeys(CLATGroup(host)) {
+				m.remove(m.targets[k])
+			}
+		}
+		return
+	}
+	if err := m.ping(Key{Group: CLATGroup(host)}, &net.UDPAddr{IP: ip4}, hopts, nil); err != nil {
+		log.Printf("Error pinging %v through CLAT: %v", host, err)
+		return
+	}
+	m.mu.Lock()
+	m.clat[host] = true
+	m.mu.Unlock()
+}
+
+// CLATGroup returns the group of the target that pings a host through the
+// local CLAT with [Options.CLATPrefix]. It's removed along with the host's
+// own group.
+func CLATGroup(host string) string {
+	return host + " (CLAT)"
+}
+
+// Returns an error if targets can't be added to a group. Must be called with
+// m.mu held.
+func (m *Manager) checkAdd(group string) error {
+	switch {
+	case m.closed:
+		return ErrClosed
+	case m.replaying:
+		return ErrReplaying
+	case m.traces[group] != nil || len(m.groupKeys(group)) > 0:
+		return fmt.Errorf("%v: %w", group, ErrExists)
+	}
+	return nil
+}
+
+// Ping starts pinging a target. An existing target with the same key is
+// replaced.
+func (m *Manager) Ping(key Key, addr net.Addr) error {
+	return m.ping(key, addr, HostOptions{}, nil)
+}
+
+func (m *Manager) ping(key Key, addr net.Addr, hopts HostOptions, ext *backend.Extensions) error {
+	t, err := m.newTarget(key, addr, hopts, ext)
+	if err != nil {
+		return err
+	}
+	return m.start(t)
+}
+
+// Creates a target with a pinger that hasn't been started.
+func (m *Manager) newTarget(key Key, addr net.Addr, hopts HostOptions, ext *backend.Extensions) (*Target, error) {
+	opts := &pinger.Options{
+		NPings:           m.opts.Count,
+		Interval:         cmp.Or(hopts.PingInterval, m.opts.PingInterval),
+		Adaptive:         m.opts.AdaptiveInterval,
+		Flood:            m.opts.Flood,
+		MaxPPS:           m.opts.FloodMaxPPS,
+		PayloadSize:      m.opts.PayloadSize,
+		PayloadPattern:   m.opts.PayloadPattern,
+		VerifyPayload:    m.opts.VerifyPayload,
+		PayloadTimestamp: m.opts.PayloadTimestamp,
+		Source:           m.SourceFor(addr, hopts),
+		FlowLabel:        m.opts.FlowLabel,
+		Pool:             m.pool,
+
+		ProbesPerInterval: m.opts.ProbesPerInterval,
+		BurstLatency:      m.opts.BurstLatency,
+		OutageThreshold:   m.opts.OutageThreshold,
+		DNSQuery:          m.opts.DNSQuery,
+		HTTP:              m.opts.HTTP,
+		RecvBuffer:        m.opts.RecvBuffer,
+	}
+	if util.AddrVersion(addr) == util.IPv4 {
+		opts.Request = m.opts.PingRequest
+	}
+	rec := m.opts.Recorder
+	st := m.opts.Store
+	eval := m.newEvaluator(key, addr)
+	if rec != nil || st != nil || eval != nil {
+		// Recordings and stores shouldn't have holes in them.
+		opts.BlockOnResult = rec != nil || st != nil
+		opts.OnResult = func(seq int, res pinger.PingResult) {
+			if rec != nil {
+				rec.RecordPing(key.Group, key.Index, addr, seq, res)
+			}
+			if st != nil {
+				st.Record(key.Group, key.Index, addr, seq, res)
+			}
+			if eval != nil {
+				eval.Add(res)
+			}
+		}
+	}
+	be := cmp.Or(hopts.PingBackend, m.opts.PingBackend)
+	ping, err := pinger.New(be, util.AddrVersion(addr), addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Target{Key: key, Addr: addr, Pinger: ping, Alert: eval, Extensions: ext, Backend: be, Source: opts.Source}, nil
+}
+
+// Adds a target from newTarget and starts its pinger.
+func (m *Manager) start(t *Target) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		t.Pinger.Close()
+		return ErrClosed
+	}
+	m.put(t)
+	m.running++
+	go func() {
+		t.Pinger.Run(m.ctx)
+		m.stopped()
+	}()
+	return nil
+}
+
+// Notes that a pinger or trace has stopped running.
+func (m *Manager) stopped() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running--
+	if m.running == 0 && m.opts.Count > 0 {
+		m.finish()
+	}
+}
+
+// FinishIfIdle closes [Manager.Finished] if there's a [Options.Count] and
+// nothing is running, as when none of the hosts could be resolved. Call it
+// once the initial hosts have been added.
+func (m *Manager) FinishIfIdle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running == 0 && m.opts.Count > 0 {
+		m.finish()
+	}
+}
+
+// Closes finished, if it isn't already. Must be called with m.mu held.
+func (m *Manager) finish() {
+	select {
+	case <-m.finished:
+	default:
+		close(m.finished)
+	}
+}
+
+// Finished returns a channel that's closed once every pinger and trace has
+// stopped after sending [Options.Count] pings, or when [Options.Deadline]
+// passes. It's never closed without one of them. Targets added after that
+// aren't waited for.
+func (m *Manager) Finished() <-chan struct{} {
+	return m.finished
+}
+
+// Feed adds a result measured elsewhere to a target, creating the target if
+// needed. A target for a different address is replaced.
+func (m *Manager) Feed(key Key, addr net.Addr, seq int, res pinger.PingResult) {
+	m.feed(key, addr, seq, res, nil, false)
+}
+
+func (m *Manager) feed(key Key, addr net.Addr, seq int, res pinger.PingResult, ext *backend.Extensions, replayed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	t := m.targets[key]
+	if t == nil || !t.fed || !util.IP(t.Addr).Equal(util.IP(addr)) {
+		t = &Target{
+			Key:      key,
+			Addr:     addr,
+			Pinger:   pinger.NewReplay(&pinger.Options{OutageThreshold: m.opts.OutageThreshold}),
+			Alert:    m.newEvaluator(key, addr),
+			Replayed: replayed,
+			Backend:  m.opts.PingBackend,
+			Source:   m.SourceFor(addr, HostOptions{}),
+			fed:      true,
+		}
+		m.put(t)
+	}
+	if ext != nil {
+		t.Extensions = ext
+	}
+	t.Pinger.Replay(seq, res)
+	if t.Alert != nil {
+		t.Alert.Add(res)
+	}
+}
+
+// Adds a target, replacing any with the same key. Must be called with m.mu
+// held.
+func (m *Manager) put(t *Target) {
+	if old := m.targets[t.Key]; old != nil {
+		m.remove(old)
+	}
+	m.targets[t.Key] = t
+	m.applyLabel(t)
+	m.notify(Event{Type: Added, Target: *t})
+}
+
+// SetLabel labels the targets for an address, or the host added by a name.
+// A label for an address takes precedence, and an empty one hides any label
+// for the name. Labels are shown alongside the targets, and included in
+// their alerts.
+func (m *Manager) SetLabel(name, label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.labels == nil {
+		m.labels = make(map[string]string)
+	}
+	m.labels[name] = label
+	for _, t := range m.targets {
+		m.applyLabel(t)
+	}
+}
+
+// Sets a target's label, and the label of its alerts. Must be called with
+// m.mu held.
+func (m *Manager) applyLabel(t *Target) {
+	label, ok := m.labels[util.IP(t.Addr).String()]
+	if !ok && t.Index == 0 {
+		label = m.labels[t.Group]
+	}
+	t.Label = label
+	if t.Alert != nil {
+		t.Alert.SetLabel(label)
+	}
+}
+
+// Removes a target and stops its pinger. Must be called with m.mu held.
+func (m *Manager) remove(t *Target) {
+	delete(m.targets, t.Key)
+	if err := t.Pinger.Close(); err != nil {
+		log.Printf("Error closing pinger for %v: %v", t.Addr, err)
+	}
+	m.notify(Event{Type: Removed, Target: *t})
+}
+
+// Remove removes a target and stops its pinger. Returns false if there's no
+// such target.
+func (m *Manager) Remove(key Key) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[key]
+	if t == nil {
+		return false
+	}
+	m.remove(t)
+	return true
+}
+
+// RemoveGroup removes all the targets in a group, and stops adding hops to it
+// if it's a trace. Returns the number of targets removed.
+func (m *Manager) RemoveGroup(group string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tr := m.traces[group]; tr != nil {
+		tr.cancel()
+		delete(m.traces, group)
+	}
+	keys := m.groupKeys(group)
+	if m.clat[group] {
+		delete(m.clat, group)
+		keys = append(keys, m.groupKeys(CLATGroup(group))...)
+	}
+	for _, k := range keys {
+		m.remove(m.targets[k])
+	}
+	return len(keys)
+}
+
+// Returns the keys of the targets in a group. Must be called with m.mu held.
+func (m *Manager) groupKeys(group string) []Key {
+	var keys []Key
+	for k := range m.targets {
+		if k.Group == group {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Removes the target for a hop that's no longer in a path. Does nothing if
+// it's already been replaced by a different host.
+func (m *Manager) removeHop(key Key, prev net.Addr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t := m.targets[key]; t != nil && util.IP(t.Addr).Equal(util.IP(prev)) {
+		m.remove(t)
+	}
+}
+
+// TogglePause pauses or resumes a target's pinger. Targets whose results are
+// fed in have no pinger of their own to pause.
+func (m *Manager) TogglePause(key Key) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[key]
+	if t == nil || t.fed {
+		return
+	}
+	if t.Pinger.Paused() {
+		t.Pinger.Resume()
+	} else {
+		t.Pinger.Pause()
+	}
+}
+
+// Target returns the target with a key.
+func (m *Manager) Target(key Key) (Target, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[key]
+	if t == nil {
+		return Target{}, false
+	}
+	return *t, true
+}
+
+// Targets returns all the targets, ordered by key.
+func (m *Manager) Targets() []Target {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sortedTargets()
+}
+
+// Must be called with m.mu held.
+func (m *Manager) sortedTargets() []Target {
+	res := make([]Target, 0, len(m.targets))
+	for _, t := range m.targets {
+		res = append(res, *t)
+	}
+	slices.SortFunc(res, func(a, b Target) int {
+		return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Index, b.Index))
+	})
+	return res
+}
+
+// SourceFor returns the source to bind connections to a target to. The
+// host's interface and namespace replace the manager's. The source address is
+// dropped if it's for a different IP version than the target.
+func (m *Manager) SourceFor(target net.Addr, hopts HostOptions) backend.SourceOption {
+	src := m.opts.Source
+	src.Interface = cmp.Or(hopts.Interface, src.Interface)
+	src.Netns = cmp.Or(hopts.Netns, src.Netns)
+	if src.Addr != nil && (src.Addr.To4() != nil) != (util.AddrVersion(target) == util.IPv4) {
+		src.Addr = nil
+	}
+	return src
+}
+
+// Creates an alert evaluator for a target. Returns nil if no rules apply to
+// it.
+func (m *Manager) newEvaluator(key Key, target net.Addr) *alert.Evaluator {
+	ip := util.IP(target).String()
+	var rules []alert.Rule
+	for _, r := range m.opts.AlertRules {
+		if r.Matches(key.Group, ip) {
+			rules = append(rules, r)
+		}
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	name := key.Group
+	if key.Index != 0 {
+		name = fmt.Sprintf("%v (hop %d to %v)", ip, key.Index, key.Group)
+	}
+	return alert.NewEvaluator(name, rules, m.opts.AlertNotifier.Notify)
+}
+
+// Replay feeds a recorded session to the manager, and returns when it ends.
+// Targets are created by the first ping result recorded for them. Other
+// targets can't be added once replay starts.
+func (m *Manager) Replay(p *session.Player) {
+	m.mu.Lock()
+	m.replaying = true
+	m.mu.Unlock()
+	for {
+		ev, err := p.Next()
+		if errors.Is(err, io.EOF) {
+			log.Printf("Replay finished")
+			return
+		}
+		if err != nil {
+			// A recording cut short by a crash may end with a partial line,
+			// so stop quietly.
+			log.Printf("Error replaying session; replay stopped: %v", err)
+			return
+		}
+		key := Key{Group: ev.Group, Index: ev.Index}
+		switch {
+		case ev.Step != nil:
+			if step := ev.TraceStep(); step.Changed() {
+				log.Printf("Path to %v changed at hop %d: %v -> %v", ev.Group, step.Pos, step.Prev, step.Host)
+				m.removeHop(key, step.Prev)
+			}
+		case ev.Ping != nil:
+			m.feed(key, ev.Ping.Target(), ev.Ping.Seq, ev.Ping.Result(), nil, true)
+		}
+	}
+}
+
+// Close removes all the targets, stops any traces in progress and ends all
+// subscriptions.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.cancel()
+	for _, t := range m.targets {
+		m.remove(t)
+	}
+	clear(m.traces)
+	clear(m.clat)
+	for s := range m.subs {
+		s.close()
+	}
+	clear(m.subs)
+	m.closed = true
+	return nil
+}
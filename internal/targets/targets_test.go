@@ -0,0 +1,501 @@
+package targets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/session"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+var (
+	addrA = &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	addrB = &net.UDPAddr{IP: net.ParseIP("192.0.2.2")}
+)
+
+// A connection that never gets replies.
+type silentConn struct {
+	once sync.Once
+	done chan any
+}
+
+func (c *silentConn) WriteTo(*backend.Packet, net.Addr, ...backend.WriteOption) error {
+	return nil
+}
+
+func (c *silentConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case <-ctx.Done():
+		return nil, nil, backend.ErrTimeout
+	case <-c.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *silentConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// Creates a manager whose pings go nowhere.
+func newTestManager(t *testing.T, opts *Options) *Manager {
+	t.Helper()
+	name := backend.Name("silent:" + t.Name())
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		return &silentConn{done: make(chan any)}, nil
+	})
+	if opts == nil {
+		opts = &Options{}
+	}
+	opts.PingBackend = name
+	m := New(opts)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// A summary of an event for comparisons.
+type eventSummary struct {
+	Type     EventType
+	Key      Key
+	Addr     string
+	Replayed bool
+}
+
+// Closes a subscription and returns the events that were waiting in it.
+func drain(s *Subscription) []eventSummary {
+	s.Close()
+	var res []eventSummary
+	for {
+		ev, ok := s.Next()
+		if !ok {
+			return res
+		}
+		var addr string
+		if ev.Target.Addr != nil {
+			addr = ev.Target.Addr.String()
+		}
+		res = append(res, eventSummary{Type: ev.Type, Key: ev.Target.Key, Addr: addr, Replayed: ev.Target.Replayed})
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	m := newTestManager(t, nil)
+	sub := m.Subscribe()
+	group, err := m.Add("a.example", addrA)
+	if err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	if group != "a.example" {
+		t.Errorf("Add group = %q (want a.example)", group)
+	}
+	if _, err := m.Add("a.example", addrA); !errors.Is(err, ErrExists) {
+		t.Errorf("Second Add error = %v (want %v)", err, ErrExists)
+	}
+	key := Key{Group: "a.example"}
+	if _, ok := m.Target(key); !ok {
+		t.Errorf("Target(%v) not found", key)
+	}
+	if !m.Remove(key) {
+		t.Errorf("Remove(%v) = false", key)
+	}
+	if m.Remove(key) {
+		t.Errorf("Second Remove(%v) = true", key)
+	}
+
+	want := []eventSummary{
+		{Type: Added, Key: key, Addr: addrA.String()},
+		{Type: Removed, Key: key, Addr: addrA.String()},
+	}
+	if diff := cmp.Diff(want, drain(sub)); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
+
+func TestFinished(t *testing.T) {
+	m := newTestManager(t, &Options{Count: 1})
+	for _, addr := range []net.Addr{addrA, addrB} {
+		if _, err := m.Add(addr.String(), addr); err != nil {
+			t.Fatalf("Add error: %v", err)
+		}
+	}
+	select {
+	case <-m.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Not finished after the pings timed out")
+	}
+	for _, tg := range m.Targets() {
+		if n := tg.Pinger.Stats().N; n != 1 {
+			t.Errorf("%v sent %d pings (want 1)", tg.Key, n)
+		}
+	}
+}
+
+func TestFinished_Deadline(t *testing.T) {
+	m := newTestManager(t, &Options{Deadline: 50 * time.Millisecond})
+	if _, err := m.Add("a.example", addrA); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	select {
+	case <-m.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Not finished after the deadline")
+	}
+}
+
+func TestFinished_NoCount(t *testing.T) {
+	m := newTestManager(t, nil)
+	if _, err := m.Add("a.example", addrA); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	m.Close()
+	select {
+	case <-m.Finished():
+		t.Errorf("Finished without a count")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFinishIfIdle(t *testing.T) {
+	m := newTestManager(t, &Options{Count: 1})
+	m.FinishIfIdle()
+	select {
+	case <-m.Finished():
+	default:
+		t.Errorf("Not finished with nothing running")
+	}
+}
+
+func TestFinishIfIdle_Running(t *testing.T) {
+	m := newTestManager(t, &Options{Count: 100})
+	if _, err := m.Add("a.example", addrA); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	m.FinishIfIdle()
+	select {
+	case <-m.Finished():
+		t.Errorf("Finished while pinging")
+	default:
+	}
+}
+
+func TestPingReplaces(t *testing.T) {
+	m := newTestManager(t, nil)
+	key := Key{Group: "example", Index: 3}
+	if err := m.Ping(key, addrA); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	sub := m.Subscribe()
+	if err := m.Ping(key, addrB); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	want := []eventSummary{
+		{Type: Added, Key: key, Addr: addrA.String()},
+		{Type: Removed, Key: key, Addr: addrA.String()},
+		{Type: Added, Key: key, Addr: addrB.String()},
+	}
+	if diff := cmp.Diff(want, drain(sub)); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
+
+func TestAddHost(t *testing.T) {
+	m := newTestManager(t, &Options{PingInterval: time.Second})
+	group, err := m.AddHost("a.example", addrA, HostOptions{Mode: PingMode, PingInterval: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	tgt, ok := m.Target(Key{Group: group})
+	if !ok {
+		t.Fatalf("Target(%v) not found", group)
+	}
+	if got := tgt.Pinger.Interval(); got != 5*time.Second {
+		t.Errorf("Interval = %v (want 5s)", got)
+	}
+	if _, err := m.AddHost("b.example", addrB, HostOptions{PingBackend: "bogus"}); err == nil {
+		t.Errorf("AddHost succeeded with a bogus backend")
+	}
+}
+
+func TestTargetBackend(t *testing.T) {
+	m := newTestManager(t, nil)
+	other := backend.Name("other:" + t.Name())
+	backend.Register(other, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		return &silentConn{done: make(chan any)}, nil
+	})
+	if _, err := m.AddHost("a.example", addrA, HostOptions{PingBackend: other}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	m.Feed(Key{Group: "trace", Index: 1}, addrB, 0, pinger.PingResult{Type: pinger.Success})
+
+	cases := []struct {
+		Key  Key
+		Want backend.Name
+	}{
+		{Key: Key{Group: "a.example"}, Want: other},
+		{Key: Key{Group: "trace", Index: 1}, Want: m.opts.PingBackend},
+	}
+	for _, c := range cases {
+		tgt, ok := m.Target(c.Key)
+		if !ok {
+			t.Fatalf("Target(%v) not found", c.Key)
+		}
+		if tgt.Backend != c.Want {
+			t.Errorf("Target(%v).Backend = %q (want %q)", c.Key, tgt.Backend, c.Want)
+		}
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	m := newTestManager(t, &Options{Labels: map[string]string{"a.example": "office router"}})
+	if _, err := m.AddHost("a.example", addrA, HostOptions{}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	m.Feed(Key{Group: "trace", Index: 1}, addrA, 0, pinger.PingResult{Type: pinger.Success})
+	labels := func() []string {
+		var res []string
+		for _, tgt := range m.Targets() {
+			res = append(res, tgt.Label)
+		}
+		return res
+	}
+
+	// The name's label doesn't apply to hops at the same address.
+	if diff := cmp.Diff([]string{"office router", ""}, labels()); diff != "" {
+		t.Errorf("Wrong labels (-want, +got):\n%v", diff)
+	}
+	m.SetLabel(addrA.IP.String(), "VPN gw")
+	if diff := cmp.Diff([]string{"VPN gw", "VPN gw"}, labels()); diff != "" {
+		t.Errorf("Wrong labels after labeling the address (-want, +got):\n%v", diff)
+	}
+	m.SetLabel(addrA.IP.String(), "")
+	if diff := cmp.Diff([]string{"", ""}, labels()); diff != "" {
+		t.Errorf("Wrong labels after clearing the address's label (-want, +got):\n%v", diff)
+	}
+}
+
+func TestAddHost_CLAT(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("64:ff9b::/96")
+	m := newTestManager(t, &Options{CLATPrefix: prefix})
+	nat64 := &net.UDPAddr{IP: net.ParseIP("64:ff9b::192.0.2.1")}
+	if _, err := m.AddHost("a.example", nat64, HostOptions{}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	if _, err := m.AddHost("b.example", &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}, HostOptions{}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	clat, ok := m.Target(Key{Group: CLATGroup("a.example")})
+	if !ok {
+		t.Fatalf("No CLAT target")
+	}
+	if !util.IP(clat.Addr).Equal(addrA.IP) {
+		t.Errorf("CLAT target address = %v (want %v)", clat.Addr, addrA)
+	}
+	if _, ok := m.Target(Key{Group: CLATGroup("b.example")}); ok {
+		t.Errorf("CLAT target added for a host outside the NAT64 prefix")
+	}
+	if n := m.RemoveGroup("a.example"); n != 2 {
+		t.Errorf("RemoveGroup removed %d targets (want 2)", n)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	m := newTestManager(t, nil)
+	sub := m.Subscribe()
+	key := Key{Group: "path", Index: 1}
+	m.Feed(key, addrA, 0, pinger.PingResult{Type: pinger.Success, Latency: time.Millisecond})
+	m.Feed(key, addrA, 1, pinger.PingResult{Type: pinger.Dropped})
+
+	tgt, ok := m.Target(key)
+	if !ok {
+		t.Fatalf("Target(%v) not found", key)
+	}
+	if st := tgt.Pinger.Stats(); st.N != 2 || st.Failures != 1 {
+		t.Errorf("Stats = %+v (want 2 pings, 1 failure)", st)
+	}
+	// Fed targets have no pinger of their own to pause.
+	m.TogglePause(key)
+	if tgt.Pinger.Paused() {
+		t.Errorf("Fed target paused")
+	}
+
+	// A new host at the same position replaces the old one.
+	m.Feed(key, addrB, 2, pinger.PingResult{Type: pinger.Success})
+	m.RemoveGroup("path")
+
+	want := []eventSummary{
+		{Type: Added, Key: key, Addr: addrA.String()},
+		{Type: Removed, Key: key, Addr: addrA.String()},
+		{Type: Added, Key: key, Addr: addrB.String()},
+		{Type: Removed, Key: key, Addr: addrB.String()},
+	}
+	if diff := cmp.Diff(want, drain(sub)); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
+
+func TestTogglePause(t *testing.T) {
+	m := newTestManager(t, nil)
+	key := Key{Group: "a.example"}
+	if err := m.Ping(key, addrA); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	tgt, _ := m.Target(key)
+	m.TogglePause(key)
+	if !tgt.Pinger.Paused() {
+		t.Errorf("Not paused after first toggle")
+	}
+	m.TogglePause(key)
+	if tgt.Pinger.Paused() {
+		t.Errorf("Paused after second toggle")
+	}
+}
+
+func TestSubscribe_Existing(t *testing.T) {
+	m := newTestManager(t, nil)
+	for _, k := range []Key{{Group: "b", Index: 1}, {Group: "a", Index: 2}, {Group: "b", Index: 0}} {
+		m.Feed(k, addrA, 0, pinger.PingResult{Type: pinger.Success})
+	}
+	want := []eventSummary{
+		{Type: Added, Key: Key{Group: "a", Index: 2}, Addr: addrA.String()},
+		{Type: Added, Key: Key{Group: "b", Index: 0}, Addr: addrA.String()},
+		{Type: Added, Key: Key{Group: "b", Index: 1}, Addr: addrA.String()},
+	}
+	if diff := cmp.Diff(want, drain(m.Subscribe())); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
+
+func TestClose(t *testing.T) {
+	m := newTestManager(t, nil)
+	key := Key{Group: "a.example"}
+	if err := m.Ping(key, addrA); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	sub := m.Subscribe()
+	m.Close()
+	if ev, ok := sub.Next(); !ok || ev.Type != Added {
+		t.Errorf("First event = %v, %v (want Added)", ev.Type, ok)
+	}
+	if ev, ok := sub.Next(); !ok || ev.Type != Removed {
+		t.Errorf("Second event = %v, %v (want Removed)", ev.Type, ok)
+	}
+	if _, ok := sub.Next(); ok {
+		t.Errorf("Subscription still open after Close")
+	}
+	if _, err := m.Add("b.example", addrB); !errors.Is(err, ErrClosed) {
+		t.Errorf("Add after Close error = %v (want %v)", err, ErrClosed)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := session.NewRecorder(&buf)
+	rec.RecordPing("path", 1, addrA, 0, pinger.PingResult{Type: pinger.Success, Latency: time.Millisecond})
+	rec.RecordPing("path", 2, addrB, 0, pinger.PingResult{Type: pinger.Dropped})
+	rec.RecordStep("path", tracer.Step{Pos: 2, Host: addrA, Prev: addrB})
+
+	m := newTestManager(t, nil)
+	sub := m.Subscribe()
+	m.Replay(session.NewPlayer(&buf, 1000))
+
+	want := []eventSummary{
+		{Type: Added, Key: Key{Group: "path", Index: 1}, Addr: addrA.String(), Replayed: true},
+		{Type: Added, Key: Key{Group: "path", Index: 2}, Addr: addrB.String(), Replayed: true},
+		{Type: Removed, Key: Key{Group: "path", Index: 2}, Addr: addrB.String(), Replayed: true},
+	}
+	if diff := cmp.Diff(want, drain(sub)); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+	if _, err := m.Add("c.example", addrA); !errors.Is(err, ErrReplaying) {
+		t.Errorf("Add while replaying error = %v (want %v)", err, ErrReplaying)
+	}
+}
+
+func TestSourceFor(t *testing.T) {
+	m := New(&Options{Source: backend.SourceOption{Interface: "eth0", Addr: net.ParseIP("192.0.2.9")}})
+	if src := m.SourceFor(addrA, HostOptions{}); src.Addr == nil || src.Interface != "eth0" {
+		t.Errorf("SourceFor(%v) = %+v (want address kept)", addrA, src)
+	}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1")}
+	if src := m.SourceFor(v6, HostOptions{}); src.Addr != nil || src.Interface != "eth0" {
+		t.Errorf("SourceFor(%v) = %+v (want address dropped)", v6, src)
+	}
+	hopts := HostOptions{Interface: "vrf-blue", Netns: "red"}
+	if src := m.SourceFor(addrA, hopts); src.Interface != "vrf-blue" || src.Netns != "red" || src.Addr == nil {
+		t.Errorf("SourceFor(%v, %+v) = %+v (want host's interface and namespace)", addrA, hopts, src)
+	}
+}
+
+func TestAddHost_BadSource(t *testing.T) {
+	cases := []struct {
+		name  string
+		hopts HostOptions
+	}{
+		{name: "Interface", hopts: HostOptions{Interface: "vasily-missing0"}},
+		{name: "Netns", hopts: HostOptions{Netns: "vasily-missing"}},
+		{name: "TraceInterface", hopts: HostOptions{Mode: TraceMode, Interface: "vasily-missing0"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newTestManager(t, nil)
+			if _, err := m.AddHost("a.example", addrA, c.hopts); err == nil {
+				t.Errorf("AddHost with %+v succeeded (want error)", c.hopts)
+			}
+			if n := len(m.Targets()); n != 0 {
+				t.Errorf("AddHost with %+v added %d targets (want 0)", c.hopts, n)
+			}
+		})
+	}
+}
+
+func TestReResolve(t *testing.T) {
+	m := newTestManager(t, nil)
+	moved := map[string]net.Addr{"follow.example": addrB, "report.example": addrB}
+	m.recheck = func(host string, addr net.Addr) (net.Addr, error) {
+		if util.IP(moved[host]).Equal(util.IP(addr)) {
+			return nil, nil
+		}
+		return moved[host], nil
+	}
+	if _, err := m.AddHost("follow.example", addrA, HostOptions{}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	if _, err := m.AddHost("report.example", addrA, HostOptions{OnMove: ReportMove}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	// Addresses given as hosts have no name to look up.
+	if _, err := m.AddHost("192.0.2.1", addrA, HostOptions{}); err != nil {
+		t.Fatalf("AddHost error: %v", err)
+	}
+	sub := m.Subscribe()
+	m.recheckAll()
+	m.recheckAll()
+
+	follow := Key{Group: "follow.example"}
+	report := Key{Group: "report.example"}
+	want := []eventSummary{
+		{Type: Added, Key: Key{Group: "192.0.2.1"}, Addr: addrA.String()},
+		{Type: Added, Key: follow, Addr: addrA.String()},
+		{Type: Added, Key: report, Addr: addrA.String()},
+		{Type: Removed, Key: follow, Addr: addrA.String()},
+		{Type: Added, Key: follow, Addr: addrB.String()},
+		{Type: Moved, Key: report, Addr: addrA.String()},
+	}
+	got := drain(sub)
+	// The hosts are looked up in no particular order.
+	slices.SortStableFunc(got[3:], func(a, b eventSummary) int { return strings.Compare(a.Key.Group, b.Key.Group) })
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong events (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,182 @@
+package targets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A trace in progress.
+type trace struct {
+	group  string
+	hopts  HostOptions        // For pinging hops.
+	cancel context.CancelFunc // Stops the trace.
+}
+
+// Trace starts tracing the path to a host, adding a target for each hop.
+// Returns the group of the hops, which is the host's address.
+func (m *Manager) Trace(addr net.Addr) (string, error) {
+	return m.trace(addr, HostOptions{})
+}
+
+func (m *Manager) trace(addr net.Addr, hopts HostOptions) (string, error) {
+	if addr == nil {
+		return "", errors.New("no address to trace")
+	}
+	group := addr.String()
+	ctx, cancel := context.WithCancel(m.ctx)
+	tr := &trace{group: group, hopts: hopts, cancel: cancel}
+	m.mu.Lock()
+	if err := m.checkAdd(group); err != nil {
+		m.mu.Unlock()
+		cancel()
+		return "", err
+	}
+	m.traces[group] = tr
+	m.running++
+	m.mu.Unlock()
+
+	steps := make(chan tracer.Step)
+	opts := &tracer.Options{
+		Interval:     m.opts.TraceInterval,
+		ProbesPerHop: m.opts.ProbesPerHop,
+		MaxTTL:       m.opts.TraceMaxTTL,
+		Continuous:   m.opts.ContinuousTrace,
+		Rounds:       m.opts.Count,
+		Paris:        m.opts.ParisTrace,
+		BasePort:     m.opts.TraceBasePort,
+		PortStride:   m.opts.TracePortStride,
+		FixedPort:    m.opts.TraceFixedPort,
+		ReturnPath:   m.opts.ReturnPath,
+		PassEvents:   true,
+		Source:       m.SourceFor(addr, hopts),
+		Pool:         m.tracePool,
+	}
+	if m.opts.ContinuousTrace {
+		// Hop targets are fed by the trace itself rather than separate
+		// pingers, so latency and loss are measured along the traced path.
+		opts.OnProbe = func(p tracer.Probe) { m.addProbe(tr, p) }
+	}
+	go func() {
+		defer cancel()
+		err := tracer.TraceRoute(ctx, m.opts.TraceBackend, util.AddrVersion(addr), addr, steps, opts)
+		if errors.Is(err, context.Canceled) {
+			return
+		} else if errors.Is(err, tracer.ErrMaxTTL) {
+			log.Printf("Maximum TTL reached for %v", addr)
+		} else if err != nil {
+			m.fail(tr, fmt.Errorf("traceroute: %v: %v", addr, err))
+		}
+	}()
+	go func() {
+		// The trace has only stopped once its last hop has been added.
+		defer m.stopped()
+		for step := range steps {
+			m.addStep(tr, step)
+		}
+	}()
+	return group, nil
+}
+
+// Returns true if a trace hasn't been removed.
+func (m *Manager) tracing(tr *trace) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.traces[tr.group] == tr
+}
+
+// Handles a step in a trace.
+func (m *Manager) addStep(tr *trace, step tracer.Step) {
+	if !m.tracing(tr) {
+		return
+	}
+	switch step.Type {
+	case tracer.PassComplete:
+		m.passComplete(tr, step.ReachedDest)
+		return
+	case tracer.TraceComplete:
+		return
+	}
+	key := Key{Group: tr.group, Index: step.Pos}
+	if rec := m.opts.Recorder; rec != nil {
+		rec.RecordStep(tr.group, step)
+	}
+	if step.Changed() {
+		log.Printf("Path to %v changed at hop %d: %v -> %v", tr.group, step.Pos, step.Prev, step.Host)
+		m.removeHop(key, step.Prev)
+	}
+	if step.Asymmetric() {
+		log.Printf("Hop %d to %v (%v) replies over about %d hops; its return path may differ", step.Pos, tr.group, step.Host, step.ReturnHops)
+	}
+	if step.Host != nil && !m.opts.ContinuousTrace {
+		if err := m.ping(key, step.Host, tr.hopts, step.Extensions); err != nil {
+			m.fail(tr, err)
+			return
+		}
+		m.setReturnHops(key, step.ReturnHops)
+	}
+}
+
+// Sets the estimated return path length of a hop's target, if it's known.
+func (m *Manager) setReturnHops(key Key, n int) {
+	if n == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t := m.targets[key]; t != nil {
+		t.ReturnHops = n
+	}
+}
+
+// Adds a continuous trace probe to its hop's target. Targets are created by
+// the first probe a host answers.
+func (m *Manager) addProbe(tr *trace, p tracer.Probe) {
+	if p.Host == nil || !m.tracing(tr) {
+		return
+	}
+	key := Key{Group: tr.group, Index: p.Pos}
+	res := pinger.PingResult{
+		Type:    pinger.Success,
+		Time:    p.Time,
+		Latency: p.Latency,
+		Peer:    p.Host,
+	}
+	if p.Lost {
+		res = pinger.PingResult{Type: pinger.Dropped, Time: p.Time}
+	}
+	m.feed(key, p.Host, p.Seq, res, p.Extensions, false)
+	m.setReturnHops(key, p.ReturnHops)
+	if rec := m.opts.Recorder; rec != nil {
+		rec.RecordPing(key.Group, key.Index, p.Host, p.Seq, res)
+	}
+	if st := m.opts.Store; st != nil {
+		st.Record(key.Group, key.Index, p.Host, p.Seq, res)
+	}
+}
+
+// Reports the end of a pass through a trace's path.
+func (m *Manager) passComplete(tr *trace, reached bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.traces[tr.group] != tr {
+		return
+	}
+	m.notify(Event{Type: PassComplete, Target: Target{Key: Key{Group: tr.group}}, Reached: reached})
+}
+
+// Reports a trace that failed.
+func (m *Manager) fail(tr *trace, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.traces[tr.group] != tr {
+		return
+	}
+	m.notify(Event{Type: Failed, Target: Target{Key: Key{Group: tr.group}}, Err: err})
+}
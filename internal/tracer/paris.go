@@ -0,0 +1,157 @@
+package tracer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+const (
+	defaultFlows = 16
+
+	// Length of the payload that identifies a probe in Paris mode.
+	parisPayloadLen = 4
+)
+
+// Chooses the sequence number and payload of each probe, and matches replies
+// to probes.
+//
+// Ordinarily the sequence number follows the TTL, and for UDP it picks the
+// destination port. Load balancers hash ports into their choice of path, so
+// each probe may take a different path. In Paris mode the sequence number is
+// fixed, and probes are told apart by a payload instead. The two 16-bit words
+// of the payload always add up to 0xffff, so the UDP or ICMP checksum is the
+// same for every probe as well.
+type prober struct {
+	paris bool
+	flow  int
+	id    uint16
+}
+
+func newProber(opts *Options) *prober {
+	return &prober{paris: opts.paris(), flow: opts.flow()}
+}
+
+// Returns the packet for the next probe.
+func (p *prober) packet(ttl int) *backend.Packet {
+	if !p.paris {
+		return &backend.Packet{Seq: ttl - 1}
+	}
+	p.id++
+	return &backend.Packet{Seq: p.flow, Payload: parisPayload(p.id)}
+}
+
+// Returns true if recv may be a reply to sent. Replies that don't quote enough
+// of the probe to include its payload are matched on sequence number alone.
+func (p *prober) matches(sent, recv *backend.Packet) bool {
+	if recv.Seq != sent.Seq || recv.Type == backend.PacketRequest {
+		return false
+	}
+	if !p.paris || len(recv.Payload) < parisPayloadLen {
+		return true
+	}
+	return bytes.Equal(recv.Payload[:parisPayloadLen], sent.Payload)
+}
+
+// Returns the payload identifying a Paris mode probe.
+func parisPayload(id uint16) []byte {
+	return []byte{byte(id >> 8), byte(id), byte(^id >> 8), byte(^id)}
+}
+
+// Path is a distinct path found by [Multipath].
+type Path struct {
+	// Hops holds the host at each position in the path, starting with TTL 1.
+	// An entry is nil if no host answered at that position.
+	Hops []net.Addr
+
+	// Flows lists the flows that took this path.
+	Flows []int
+}
+
+// Returns a key that's the same for paths through the same hosts.
+func (p Path) key() string {
+	var sb strings.Builder
+	for _, h := range p.Hops {
+		if h == nil {
+			sb.WriteString("*")
+		} else {
+			sb.WriteString(util.IP(h).String())
+		}
+		sb.WriteString(" ")
+	}
+	return sb.String()
+}
+
+// Multipath looks for the different paths load balancers may send packets to
+// a host along. It traces the path taken by each of [Options.Flows] flows in
+// Paris mode, and returns the distinct paths in the order they were found.
+// Each position is probed until a host answers, up to [Options.ProbesPerHop]
+// times. Continuous, Rounds, Paris, Flow, FixedPort and OnProbe are ignored.
+// Canceling ctx stops the search, and returns ctx's error.
+func Multipath(ctx context.Context, name backend.Name, ipVer util.IPVersion, dest net.Addr, opts *Options) ([]Path, error) {
+	conn, err := openConn(name, ipVer, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating connection: %v", err)
+	}
+	defer conn.Close()
+	newPortRange(conn, opts, false)
+
+	tick, stop := immediateTick(opts.clock(), opts.interval())
+	defer stop()
+	pr := &prober{paris: true}
+	var paths []Path
+	index := make(map[string]int)
+	for flow := range opts.flows() {
+		pr.flow = flow
+		hops, err := traceFlow(ctx, conn, pr, dest, tick, opts)
+		if err != nil {
+			return nil, err
+		}
+		path := Path{Hops: hops}
+		k := path.key()
+		if i, ok := index[k]; ok {
+			paths[i].Flows = append(paths[i].Flows, flow)
+			continue
+		}
+		index[k] = len(paths)
+		path.Flows = []int{flow}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Traces the path taken by a single flow.
+func traceFlow(ctx context.Context, conn backend.Conn, pr *prober, dest net.Addr, tick <-chan time.Time, opts *Options) ([]net.Addr, error) {
+	var hops []net.Addr
+	for ttl := 1; ttl < opts.maxTTL(); ttl++ {
+		var host net.Addr
+		for range opts.probesPerHop() {
+			if err := wait(ctx, tick); err != nil {
+				return nil, err
+			}
+			recvPkt, peer, err := probe(ctx, conn, pr, pr.packet(ttl), dest, ttl)
+			if err != nil {
+				return nil, err
+			}
+			if recvPkt == nil {
+				continue
+			}
+			if recvPkt.Type == backend.PacketDestinationUnreachable {
+				return nil, fmt.Errorf("destination unreachable: %v", peer)
+			}
+			host = peer
+			if recvPkt.Type == backend.PacketReply {
+				return append(hops, host), nil
+			}
+			break
+		}
+		hops = append(hops, host)
+	}
+	return nil, fmt.Errorf("flow %d: %w", pr.flow, ErrMaxTTL)
+}
@@ -0,0 +1,103 @@
+package tracer
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"github.com/pcekm/vasily/internal/util"
+	"go.uber.org/mock/gomock"
+)
+
+// Returns an exchange for the Paris mode probe with the given id.
+func parisExchange(ttl, flow int, id uint16, hop *net.UDPAddr, dest net.Addr) *test.PingExchangeOpts {
+	opts := traceExchange(ttl, hop, dest)
+	opts.SendPkt.Seq = flow
+	opts.RecvPkt.Seq = flow
+	return opts.SetPayload(parisPayload(id))
+}
+
+func TestParisPayload(t *testing.T) {
+	for _, id := range []uint16{0, 1, 0x1234, 0xffff} {
+		b := parisPayload(id)
+		if got := binary.BigEndian.Uint16(b); got != id {
+			t.Errorf("parisPayload(%#x) id = %#x", id, got)
+		}
+		sum := uint32(binary.BigEndian.Uint16(b)) + uint32(binary.BigEndian.Uint16(b[2:]))
+		if sum != 0xffff {
+			t.Errorf("parisPayload(%#x) words sum to %#x (want 0xffff)", id, sum)
+		}
+	}
+}
+
+func TestTraceRouteParis(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+
+	// A late reply to some other probe with the same sequence number.
+	conn.EXPECT().
+		ReadFrom(gomock.Any()).
+		Return(&backend.Packet{Type: backend.PacketTimeExceeded, Seq: 2, Payload: parisPayload(99)}, hopAddr(7), nil)
+	conn.MockPingExchange(parisExchange(1, 2, 1, hopAddr(1), dest))
+	conn.MockPingExchange(parisExchange(2, 2, 2, dest, dest).SetRespType(backend.PacketReply))
+	// A reply that doesn't quote the payload.
+	opts := parisExchange(1, 2, 3, hopAddr(1), dest)
+	opts.RecvPkt.Payload = nil
+	conn.MockPingExchange(opts)
+	conn.MockPingExchange(parisExchange(2, 2, 4, dest, dest).SetRespType(backend.PacketReply))
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: dest},
+	}
+	if err := checkTrace(t, name, dest, &Options{ProbesPerHop: 2, Paris: true, Flow: 2}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestMultipath(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+
+	conn.MockPingExchange(parisExchange(1, 0, 1, hopAddr(1), dest))
+	conn.MockPingExchange(parisExchange(2, 0, 2, dest, dest).SetRespType(backend.PacketReply))
+
+	lost := parisExchange(1, 1, 3, hopAddr(2), dest)
+	lost.RecvErr = backend.ErrTimeout
+	conn.MockPingExchange(lost)
+	conn.MockPingExchange(parisExchange(1, 1, 4, hopAddr(2), dest))
+	conn.MockPingExchange(parisExchange(2, 1, 5, dest, dest).SetRespType(backend.PacketReply))
+
+	conn.MockPingExchange(parisExchange(1, 2, 6, hopAddr(1), dest))
+	conn.MockPingExchange(parisExchange(2, 2, 7, dest, dest).SetRespType(backend.PacketReply))
+
+	conn.EXPECT().Close().Return(nil)
+
+	opts := &Options{Interval: noInterval, ProbesPerHop: 2, Flows: 3}
+	got, err := Multipath(context.Background(), name, util.IPv4, dest, opts)
+	if err != nil {
+		t.Fatalf("Multipath error: %v", err)
+	}
+	want := []Path{
+		{Hops: []net.Addr{hopAddr(1), dest}, Flows: []int{0, 2}},
+		{Hops: []net.Addr{hopAddr(2), dest}, Flows: []int{1}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong paths (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
@@ -0,0 +1,78 @@
+package tracer
+
+import (
+	"fmt"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/connpool"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Replies buffered for each trace sharing a connection. A trace only waits
+// for one reply at a time, so a backlog is too late to matter, and it mustn't
+// hold up the other traces.
+const pooledReplies = 4
+
+// Identifies connections that can be shared.
+type poolKey struct {
+	be    backend.Name
+	ipVer util.IPVersion
+	iface string
+	addr  string
+	mark  uint32
+	netns string
+}
+
+// Pool shares backend connections between traces, so that several can run at
+// once over one connection. Each connection carries up to 64 traces, which are
+// told apart by the range of sequence numbers they send, much like the
+// pingers' pool. Backends whose sequence numbers pick ports, such as udp,
+// don't share, since the ports would no longer be the usual traceroute ones.
+// Set [Options.Pool] to use one.
+type Pool struct {
+	conns *connpool.Pool[poolKey]
+}
+
+// NewPool creates an empty pool.
+func NewPool() *Pool {
+	return &Pool{conns: connpool.New[poolKey](connpool.Options{
+		Replies:      pooledReplies,
+		DropWhenFull: true,
+		Unshared: func(conn backend.Conn) bool {
+			_, ok := conn.(backend.PortConn)
+			return ok
+		},
+	})}
+}
+
+// Opens a connection for a trace, from opts.Pool if there is one. Returns an
+// error if the trace's sequence numbers wouldn't fit in its share of a pooled
+// connection.
+func openConn(name backend.Name, ipVer util.IPVersion, opts *Options) (backend.Conn, error) {
+	if opts.maxTTL() > MaxTTL {
+		return nil, fmt.Errorf("max TTL %d is more than %d", opts.maxTTL(), MaxTTL)
+	}
+	if p := opts.pool(); p != nil {
+		if opts.flow() >= connpool.SeqSpace {
+			return nil, fmt.Errorf("flow %d is more than %d", opts.flow(), connpool.SeqSpace-1)
+		}
+		return p.get(name, ipVer, opts.source())
+	}
+	return backend.New(name, ipVer, opts.source())
+}
+
+// Gets a connection for a single trace, opening a new shared connection if
+// none has room.
+func (p *Pool) get(be backend.Name, ipVer util.IPVersion, src backend.SourceOption) (backend.Conn, error) {
+	key := poolKey{
+		be:    be,
+		ipVer: ipVer,
+		iface: src.Interface,
+		addr:  src.Addr.String(),
+		mark:  src.Mark,
+		netns: src.Netns,
+	}
+	return p.conns.Get(key, func() (backend.Conn, error) {
+		return backend.New(be, ipVer, src)
+	})
+}
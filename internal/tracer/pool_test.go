@@ -0,0 +1,130 @@
+package tracer
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/connpool"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// A reply waiting to be read.
+type readResult struct {
+	pkt  *backend.Packet
+	peer net.Addr
+}
+
+// A two hop path that answers every probe immediately.
+type pathConn struct {
+	dest    net.Addr
+	replies chan readResult
+	done    chan any
+
+	mu      sync.Mutex
+	streams map[int]bool
+	closed  bool
+}
+
+func (c *pathConn) WriteTo(pkt *backend.Packet, dest net.Addr, opts ...backend.WriteOption) error {
+	stream, _, err := backend.SplitStream(opts)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.streams[stream] = true
+	c.mu.Unlock()
+	reply := readResult{
+		pkt:  &backend.Packet{Type: backend.PacketTimeExceeded, Seq: pkt.Seq},
+		peer: hopAddr(1),
+	}
+	if pkt.Seq%connpool.SeqSpace == 1 {
+		reply.pkt.Type = backend.PacketReply
+		reply.peer = c.dest
+	}
+	c.replies <- reply
+	return nil
+}
+
+func (c *pathConn) ReadFrom(ctx context.Context) (*backend.Packet, net.Addr, error) {
+	select {
+	case rr := <-c.replies:
+		return rr.pkt, rr.peer, nil
+	case <-c.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (c *pathConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.done)
+	}
+	return nil
+}
+
+func TestPoolSharesConnection(t *testing.T) {
+	dest := hopAddr(9)
+	var mu sync.Mutex
+	var conns []*pathConn
+	name := backend.Name("path:" + t.Name())
+	backend.Register(name, func(util.IPVersion, ...backend.ConnOption) (backend.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := &pathConn{
+			dest:    dest,
+			replies: make(chan readResult, 100),
+			done:    make(chan any),
+			streams: make(map[int]bool),
+		}
+		conns = append(conns, c)
+		return c, nil
+	})
+
+	pool := NewPool()
+	// Holds the connection open so the traces share it even if one finishes
+	// before the next starts.
+	holder, err := pool.get(name, util.IPv4, backend.SourceOption{})
+	if err != nil {
+		t.Fatalf("Error getting connection: %v", err)
+	}
+	const nTraces = 3
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: dest},
+	}
+	var wg sync.WaitGroup
+	for range nTraces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts := &Options{ProbesPerHop: 1, Pool: pool}
+			if err := checkTrace(t, name, dest, opts, want); err != nil {
+				t.Errorf("TraceRoute error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := holder.Close(); err != nil {
+		t.Errorf("Error closing connection: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) != 1 {
+		t.Fatalf("Opened %d connections (want 1)", len(conns))
+	}
+	c := conns[0]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		t.Errorf("Shared connection not closed.")
+	}
+	if len(c.streams) != nTraces {
+		t.Errorf("Traces used %d streams (want %d)", len(c.streams), nTraces)
+	}
+}
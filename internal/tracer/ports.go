@@ -0,0 +1,57 @@
+package tracer
+
+import (
+	"github.com/pcekm/vasily/internal/backend"
+)
+
+// Chooses the ports probes go to, for connections that map sequence numbers
+// onto them. Outside of Paris mode, each pass through the path moves on to
+// ports that haven't been used yet, as traceroute does, so that late replies
+// to one pass aren't mistaken for replies to the next.
+type portRange struct {
+	conn   backend.PortConn // Nil if the connection doesn't use ports.
+	first  backend.PortRange
+	next   backend.PortRange
+	maxSeq int
+}
+
+// Sets up the ports of conn from the options. Fixed sends every probe to the
+// base port.
+func newPortRange(conn backend.Conn, opts *Options, fixed bool) *portRange {
+	pc, ok := conn.(backend.PortConn)
+	if !ok {
+		return &portRange{}
+	}
+	r := pc.Ports()
+	if p := opts.basePort(); p != 0 {
+		r.Base = p
+	}
+	r.Stride = opts.portStride()
+	if fixed {
+		r.Stride = 0
+	}
+	pc.SetPorts(r)
+	return &portRange{
+		conn:   pc,
+		first:  r,
+		next:   r,
+		maxSeq: max(opts.maxTTL(), opts.flow()),
+	}
+}
+
+// Counts a probe sent in the current pass.
+func (r *portRange) sent() {
+	r.next.Base += r.next.Stride
+}
+
+// Moves on to the ports after the ones used by the probes sent so far. Starts
+// over from the first ports if the next pass could run out of them.
+func (r *portRange) advance() {
+	if r.conn == nil || r.next.Stride == 0 {
+		return
+	}
+	if r.next.Port(r.maxSeq) > backend.MaxPort {
+		r.next = r.first
+	}
+	r.conn.SetPorts(r.next)
+}
@@ -0,0 +1,83 @@
+package tracer
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/backend"
+)
+
+// A PortConn that records the ports it's given.
+type fakePortConn struct {
+	backend.Conn
+	set []backend.PortRange
+}
+
+func (c *fakePortConn) Ports() backend.PortRange {
+	return backend.PortRange{Base: 33434, Stride: 1}
+}
+
+func (c *fakePortConn) SetPorts(r backend.PortRange) {
+	c.set = append(c.set, r)
+}
+
+func TestPortRange(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Opts  *Options
+		Fixed bool
+		Want  []backend.PortRange
+	}{
+		{
+			Name: "Default",
+			Want: []backend.PortRange{{Base: 33434, Stride: 1}, {Base: 33437, Stride: 1}, {Base: 33440, Stride: 1}},
+		},
+		{
+			Name: "BaseAndStride",
+			Opts: &Options{BasePort: 40000, PortStride: 2},
+			Want: []backend.PortRange{{Base: 40000, Stride: 2}, {Base: 40006, Stride: 2}, {Base: 40012, Stride: 2}},
+		},
+		{
+			Name:  "Fixed",
+			Opts:  &Options{BasePort: 53, FixedPort: true},
+			Fixed: true,
+			Want:  []backend.PortRange{{Base: 53}},
+		},
+		{
+			Name: "WrapsAround",
+			Opts: &Options{BasePort: backend.MaxPort - 68, MaxTTL: 64},
+			Want: []backend.PortRange{
+				{Base: backend.MaxPort - 68, Stride: 1},
+				{Base: backend.MaxPort - 65, Stride: 1},
+				{Base: backend.MaxPort - 68, Stride: 1},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			conn := &fakePortConn{}
+			r := newPortRange(conn, c.Opts, c.Fixed)
+			for range 2 {
+				for range 3 {
+					r.sent()
+				}
+				r.advance()
+			}
+			if diff := cmp.Diff(c.Want, conn.set); diff != "" {
+				t.Errorf("Wrong ports (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
+
+func TestPortRange_NotPortConn(t *testing.T) {
+	r := newPortRange(nil, &Options{BasePort: 40000}, false)
+	r.sent()
+	r.advance()
+}
+
+func TestOptionsParis_FixedPort(t *testing.T) {
+	if !(&Options{FixedPort: true}).paris() {
+		t.Errorf("FixedPort doesn't imply Paris")
+	}
+}
@@ -0,0 +1,32 @@
+package tracer
+
+import "github.com/pcekm/vasily/internal/util"
+
+// AsymmetryThreshold is the number of hops by which a hop's return distance
+// may differ from its position in the path before [Asymmetric] flags it.
+// Some slack is needed, since the return distance is only an estimate, and
+// hops like MPLS tunnels may not count the same way in each direction.
+const AsymmetryThreshold = 2
+
+// ReturnHops estimates how many hops a reply took to get back from the TTL it
+// arrived with. See [util.HopDistance]. The sender itself counts as a hop, so
+// a reply that came back along the path it was sent on gives the hop's
+// position. Returns zero if the TTL is unknown.
+func ReturnHops(recvTTL int) int {
+	d, ok := util.HopDistance(recvTTL)
+	if !ok {
+		return 0
+	}
+	return d + 1
+}
+
+// Asymmetric returns true if the estimated return distance of the hop at pos
+// differs from pos by more than [AsymmetryThreshold], which suggests replies
+// come back along a different path. Returns false if returnHops is zero.
+func Asymmetric(pos, returnHops int) bool {
+	if returnHops == 0 {
+		return false
+	}
+	d := pos - returnHops
+	return d > AsymmetryThreshold || d < -AsymmetryThreshold
+}
@@ -0,0 +1,43 @@
+package tracer
+
+import "testing"
+
+func TestReturnHops(t *testing.T) {
+	cases := []struct {
+		ttl  int
+		want int
+	}{
+		{ttl: 0, want: 0},
+		{ttl: 64, want: 1},
+		{ttl: 60, want: 5},
+		{ttl: 33, want: 32},
+		{ttl: 32, want: 1},
+		{ttl: 120, want: 9},
+		{ttl: 250, want: 6},
+		{ttl: 256, want: 0},
+	}
+	for _, c := range cases {
+		if got := ReturnHops(c.ttl); got != c.want {
+			t.Errorf("ReturnHops(%d) = %d (want %d)", c.ttl, got, c.want)
+		}
+	}
+}
+
+func TestAsymmetric(t *testing.T) {
+	cases := []struct {
+		pos, returnHops int
+		want            bool
+	}{
+		{pos: 5, returnHops: 0, want: false},
+		{pos: 5, returnHops: 5, want: false},
+		{pos: 5, returnHops: 7, want: false},
+		{pos: 5, returnHops: 3, want: false},
+		{pos: 5, returnHops: 8, want: true},
+		{pos: 5, returnHops: 2, want: true},
+	}
+	for _, c := range cases {
+		if got := Asymmetric(c.pos, c.returnHops); got != c.want {
+			t.Errorf("Asymmetric(%d, %d) = %v (want %v)", c.pos, c.returnHops, got, c.want)
+		}
+	}
+}
@@ -5,9 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"net"
+	"slices"
 	"time"
 
+	"code.cloudfoundry.org/clock"
+
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/util"
 )
@@ -23,6 +28,10 @@ const (
 	timeout = time.Second
 )
 
+// MaxTTL is the largest [Options.MaxTTL]: the most an IP header's TTL or hop
+// limit can hold.
+const MaxTTL = 255
+
 var (
 	ErrMaxTTL = errors.New("maximum TTL reached")
 )
@@ -33,11 +42,72 @@ type Options struct {
 	Interval time.Duration
 
 	// ProbesPerHop is the number of times to probe each step in the route.
-	// Defaults to 3.
+	// Defaults to 3. Continuous mode ignores it, and probes each step once
+	// per round instead.
 	ProbesPerHop int
 
 	// MaxTTL is the maximum path length to probe. Defaults to 64.
 	MaxTTL int
+
+	// Continuous keeps re-probing the path and reports changes to it. See
+	// [Step].
+	Continuous bool
+
+	// Rounds is the number of times to probe the full path in continuous
+	// mode. Zero means forever.
+	Rounds int
+
+	// Source binds the connection to a local interface or address.
+	Source backend.SourceOption
+
+	// Paris keeps every probe in the same flow, like Paris traceroute, so
+	// that load balancers send them all along the same path. Without it,
+	// each probe may be balanced onto a different path, making hosts on
+	// separate paths look like they're in one.
+	Paris bool
+
+	// Flow chooses the flow probes are sent in with Paris. Different flows
+	// may take different paths.
+	Flow int
+
+	// Flows is the number of flows [Multipath] tries. Defaults to 16.
+	Flows int
+
+	// BasePort is the port of the first probe, for backends that send
+	// probes to a range of ports, like udp. Zero for the backend's default.
+	BasePort int
+
+	// PortStride is the difference between the ports of consecutive probes.
+	// Defaults to 1.
+	PortStride int
+
+	// FixedPort sends every probe to BasePort, for getting through firewalls
+	// that only let one port through. It implies Paris, since replies can
+	// only be told apart by payload. [Multipath] ignores it.
+	FixedPort bool
+
+	// ReturnPath estimates how many hops each reply took to get back, from
+	// the TTL it arrived with, to find hops whose return paths differ from
+	// the forward path. See [ReturnHops].
+	ReturnPath bool
+
+	// PassEvents sends [PassComplete] and [TraceComplete] steps as well as
+	// hops, to show when each pass through the path ends, and whether it
+	// reached the destination.
+	PassEvents bool
+
+	// Pool, if set, shares a connection with other traces instead of
+	// opening one for each.
+	Pool *Pool
+
+	// OnProbe, if set, is called with the result of every probe. It's called
+	// from the goroutine running the trace, after any [Step] the probe
+	// produced has been sent.
+	OnProbe func(Probe)
+
+	// Clock times the interval between probes and their latencies. Defaults
+	// to the real clock. For testing.
+	Clock clock.Clock
 }
 
 func (o *Options) interval() time.Duration {
@@ -50,6 +120,13 @@ func (o *Options) interval() time.Duration {
 	return o.Interval
 }
 
+func (o *Options) clock() clock.Clock {
+	if o == nil || o.Clock == nil {
+		return clock.NewClock()
+	}
+	return o.Clock
+}
+
 func (o *Options) probesPerHop() int {
 	if o == nil || o.ProbesPerHop == 0 {
 		return defaultProbesPerHop
@@ -57,6 +134,90 @@ func (o *Options) probesPerHop() int {
 	return o.ProbesPerHop
 }
 
+func (o *Options) source() backend.SourceOption {
+	if o == nil {
+		return backend.SourceOption{}
+	}
+	return o.Source
+}
+
+func (o *Options) paris() bool {
+	return o != nil && o.Paris || o.fixedPort()
+}
+
+func (o *Options) flow() int {
+	if o == nil {
+		return 0
+	}
+	return o.Flow
+}
+
+func (o *Options) basePort() int {
+	if o == nil {
+		return 0
+	}
+	return o.BasePort
+}
+
+func (o *Options) portStride() int {
+	if o == nil || o.PortStride == 0 {
+		return 1
+	}
+	return o.PortStride
+}
+
+func (o *Options) fixedPort() bool {
+	return o != nil && o.FixedPort
+}
+
+func (o *Options) flows() int {
+	if o == nil || o.Flows == 0 {
+		return defaultFlows
+	}
+	return o.Flows
+}
+
+func (o *Options) returnPath() bool {
+	return o != nil && o.ReturnPath
+}
+
+// Returns the estimated return distance of a reply, or zero if it isn't
+// wanted.
+func (o *Options) returnHops(pkt *backend.Packet) int {
+	if !o.returnPath() {
+		return 0
+	}
+	return ReturnHops(pkt.TTL)
+}
+
+func (o *Options) passEvents() bool {
+	return o != nil && o.PassEvents
+}
+
+func (o *Options) pool() *Pool {
+	if o == nil {
+		return nil
+	}
+	return o.Pool
+}
+
+func (o *Options) onProbe(p Probe) {
+	if o != nil && o.OnProbe != nil {
+		o.OnProbe(p)
+	}
+}
+
+func (o *Options) continuous() bool {
+	return o != nil && o.Continuous
+}
+
+func (o *Options) rounds() int {
+	if o == nil || o.Rounds == 0 {
+		return math.MaxInt
+	}
+	return o.Rounds
+}
+
 func (o *Options) maxTTL() int {
 	if o == nil || o.MaxTTL == 0 {
 		return defaultMaxTTL
@@ -64,46 +225,270 @@ func (o *Options) maxTTL() int {
 	return o.MaxTTL
 }
 
-// Step describes a single step in the path to a remote host.
+// StepType is the type of a [Step].
+type StepType int
+
+// Step types.
+const (
+	// HopFound means a host was found at a position in the path, or in
+	// continuous mode, that one changed or went away. It's the zero value,
+	// so steps are hops unless they say otherwise.
+	HopFound StepType = iota
+
+	// PassComplete means a pass through the path finished. Only Pass and
+	// ReachedDest are set. Only sent with [Options.PassEvents].
+	PassComplete
+
+	// TraceComplete means the trace finished, other than by being canceled
+	// or failing. It's the last step sent. Only Pass and ReachedDest are
+	// set, from the last pass. Only sent with [Options.PassEvents].
+	TraceComplete
+)
+
+func (t StepType) String() string {
+	switch t {
+	case HopFound:
+		return "HopFound"
+	case PassComplete:
+		return "PassComplete"
+	case TraceComplete:
+		return "TraceComplete"
+	default:
+		return fmt.Sprintf("(unknown:%d)", t)
+	}
+}
+
+// Step describes a single step in the path to a remote host, or with
+// [Options.PassEvents], the progress of the trace.
+//
+// In continuous mode, a Step is only sent when the path changes. Prev holds
+// the host that used to be at this position, and Host is nil if the path no
+// longer reaches it.
 type Step struct {
+	// Type is the type of step.
+	Type StepType
+
 	// Pos is the hosts position in the path.
 	Pos int
 
 	// Host is the address of the host at this step.
 	Host net.Addr
+
+	// Prev is the host previously seen at this position in a continuous
+	// trace, or nil if this is the first host seen here.
+	Prev net.Addr
+
+	// Extensions holds any ICMP extensions (such as MPLS labels) the host
+	// sent with its reply.
+	Extensions *backend.Extensions
+
+	// ReturnHops is the estimated length of the path the host's reply took
+	// back. Zero unless [Options.ReturnPath] is set and the TTL of the reply
+	// is known.
+	ReturnHops int
+
+	// Pass is the number of the pass that finished, starting from zero.
+	Pass int
+
+	// ReachedDest is true if the pass that finished reached the
+	// destination.
+	ReachedDest bool
+}
+
+// Changed returns true if this step replaces or removes a previously-seen
+// step.
+func (s Step) Changed() bool {
+	return s.Prev != nil
+}
+
+// Asymmetric returns true if the host's reply seems to have come back along a
+// path of a different length. See [Asymmetric].
+func (s Step) Asymmetric() bool {
+	return Asymmetric(s.Pos, s.ReturnHops)
+}
+
+// HopStats holds statistics for the probes sent to one position in the path.
+// They start over whenever a different host appears at the position.
+type HopStats struct {
+	// Sent is the number of probes sent.
+	Sent int
+
+	// Received is the number of probes answered.
+	Received int
+
+	// Last, Min and Max are the latencies of the most recent, fastest and
+	// slowest answered probes.
+	Last, Min, Max time.Duration
+
+	// Total is the sum of the latencies of all answered probes.
+	Total time.Duration
+}
+
+// Lost returns the number of unanswered probes.
+func (s HopStats) Lost() int {
+	return s.Sent - s.Received
+}
+
+// Loss returns the fraction of probes that went unanswered.
+func (s HopStats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Lost()) / float64(s.Sent)
+}
+
+// Mean returns the average latency of answered probes.
+func (s HopStats) Mean() time.Duration {
+	if s.Received == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Received)
+}
+
+// Adds the result of a probe. A negative latency means it was lost.
+func (s *HopStats) add(latency time.Duration) {
+	s.Sent++
+	if latency < 0 {
+		return
+	}
+	if s.Received == 0 || latency < s.Min {
+		s.Min = latency
+	}
+	s.Max = max(s.Max, latency)
+	s.Last = latency
+	s.Total += latency
+	s.Received++
+}
+
+// Probe is the result of a single probe.
+type Probe struct {
+	// Pos is the position in the path that was probed.
+	Pos int
+
+	// Host is the host at this position. It's the last host that answered if
+	// the probe was lost, or nil if no host here has answered yet.
+	Host net.Addr
+
+	// Seq counts the probes sent to Host at this position, starting from
+	// zero.
+	Seq int
+
+	// Time is when the probe was sent.
+	Time time.Time
+
+	// Lost is true if no reply arrived in time.
+	Lost bool
+
+	// Latency is the round trip time. Zero if the probe was lost.
+	Latency time.Duration
+
+	// Extensions holds any ICMP extensions sent with the reply.
+	Extensions *backend.Extensions
+
+	// ReturnHops is the estimated length of the path the reply took back, as
+	// in [Step].
+	ReturnHops int
+
+	// Stats holds the statistics for this position, including this probe.
+	Stats HopStats
+}
+
+// Tracks the host and statistics for each position in the path, and reports
+// probes.
+type hopTracker struct {
+	opts  *Options
+	hosts map[int]net.Addr
+	stats map[int]*HopStats
+}
+
+func newHopTracker(opts *Options) *hopTracker {
+	return &hopTracker{
+		opts:  opts,
+		hosts: make(map[int]net.Addr),
+		stats: make(map[int]*HopStats),
+	}
+}
+
+// Returns the host last seen at a position, or nil.
+func (h *hopTracker) host(pos int) net.Addr {
+	return h.hosts[pos]
+}
+
+// Sets the host at a position, and starts its statistics over if it changed.
+// A nil host forgets the position.
+func (h *hopTracker) setHost(pos int, host net.Addr) {
+	if host == nil {
+		delete(h.hosts, pos)
+		delete(h.stats, pos)
+		return
+	}
+	if prev := h.hosts[pos]; prev == nil || !util.IP(prev).Equal(util.IP(host)) {
+		h.hosts[pos] = host
+		h.stats[pos] = &HopStats{}
+	}
+}
+
+// Records and reports a probe to a position. A nil peer means it was lost.
+func (h *hopTracker) record(pos int, peer net.Addr, ext *backend.Extensions, returnHops int, sent time.Time, latency time.Duration) {
+	if peer != nil {
+		h.setHost(pos, peer)
+	}
+	st := h.stats[pos]
+	if st == nil {
+		st = &HopStats{}
+		h.stats[pos] = st
+	}
+	p := Probe{Pos: pos, Host: h.hosts[pos], Seq: st.Sent, Time: sent}
+	if peer == nil {
+		p.Lost = true
+		st.add(-1)
+	} else {
+		p.Latency = latency
+		p.Extensions = ext
+		p.ReturnHops = returnHops
+		st.add(latency)
+	}
+	p.Stats = *st
+	h.opts.onProbe(p)
 }
 
 // TraceRoute finds the path to a host. Steps in the path will be returned one
 // at a time over the channel. The channel will be closed when the trace
-// completes. Steps may be returned in any order or not at all.
-func TraceRoute(name backend.Name, ipVer util.IPVersion, dest net.Addr, res chan<- Step, opts *Options) error {
+// completes. Steps may be returned in any order or not at all. Canceling ctx
+// stops the trace, and returns ctx's error.
+func TraceRoute(ctx context.Context, name backend.Name, ipVer util.IPVersion, dest net.Addr, res chan<- Step, opts *Options) error {
 	defer close(res)
-	conn, err := backend.New(name, ipVer)
+	conn, err := openConn(name, ipVer, opts)
 	if err != nil {
 		return fmt.Errorf("error creating connection: %v", err)
 	}
-	pkt := &backend.Packet{}
-	seen := make(map[string]bool)
-	tick := immediateTick(opts.interval())
-	var nextBasePort int
-	if conn, ok := conn.(backend.PortConn); ok {
-		nextBasePort = conn.SeqBasePort()
+	defer conn.Close()
+	if opts.continuous() {
+		return traceContinuous(ctx, conn, dest, res, opts)
 	}
+	pr := newProber(opts)
+	seen := make(map[string]bool)
+	hops := newHopTracker(opts)
+	clk := opts.clock()
+	tick, stop := immediateTick(clk, opts.interval())
+	defer stop()
+	ports := newPortRange(conn, opts, opts.fixedPort())
 	for tryNum := 0; tryNum < opts.probesPerHop(); tryNum++ {
 		done := false
 		for ttl := 1; !done && ttl < opts.maxTTL(); ttl++ {
-			<-tick
-			nextBasePort++
-			pkt.Seq = ttl - 1
-			if err := conn.WriteTo(pkt, dest, backend.TTLOption{TTL: ttl}); err != nil {
-				return fmt.Errorf("error sending ping: %v", err)
+			if err := wait(ctx, tick); err != nil {
+				return err
 			}
-			recvPkt, peer, err := readSeq(conn, pkt.Seq)
+			ports.sent()
+			sent := clk.Now()
+			recvPkt, peer, err := probe(ctx, conn, pr, pr.packet(ttl), dest, ttl)
+			latency := clk.Since(sent)
 			if err != nil {
-				if errors.Is(err, backend.ErrTimeout) {
-					continue
-				}
-				return fmt.Errorf("read error: %v", err)
+				return err
+			}
+			if recvPkt == nil {
+				hops.record(ttl, nil, nil, 0, sent, 0)
+				continue
 			}
 			if recvPkt.Type == backend.PacketDestinationUnreachable {
 				return fmt.Errorf("destination unreachable: %v", peer)
@@ -113,45 +498,183 @@ func TraceRoute(name backend.Name, ipVer util.IPVersion, dest net.Addr, res chan
 				done = true
 			}
 
+			returnHops := opts.returnHops(recvPkt)
 			k := fmt.Sprintf("%d:%v", ttl, peer.String())
-			if seen[k] {
-				continue
+			if !seen[k] {
+				seen[k] = true
+				if err := send(ctx, res, Step{Pos: ttl, Host: peer, Extensions: recvPkt.Extensions, ReturnHops: returnHops}); err != nil {
+					return err
+				}
 			}
-			seen[k] = true
-			res <- Step{Pos: ttl, Host: peer}
+			hops.record(ttl, peer, recvPkt.Extensions, returnHops, sent, latency)
+		}
+		if !pr.paris {
+			ports.advance()
 		}
-		if conn, ok := conn.(backend.PortConn); ok {
-			conn.SetSeqBasePort(nextBasePort)
+		if err := sendEvent(ctx, res, opts, Step{Type: PassComplete, Pass: tryNum, ReachedDest: done}); err != nil {
+			return err
 		}
 		if !done {
+			if err := sendEvent(ctx, res, opts, Step{Type: TraceComplete, Pass: tryNum}); err != nil {
+				return err
+			}
 			return ErrMaxTTL
 		}
 	}
-	return nil
+	return sendEvent(ctx, res, opts, Step{Type: TraceComplete, Pass: opts.probesPerHop() - 1, ReachedDest: true})
+}
+
+// Probes the path until told to stop, and sends a Step whenever the host at a
+// position changes. Each position is probed once per round; rounds take the
+// place of [Options.ProbesPerHop].
+func traceContinuous(ctx context.Context, conn backend.Conn, dest net.Addr, res chan<- Step, opts *Options) error {
+	pr := newProber(opts)
+	clk := opts.clock()
+	tick, stop := immediateTick(clk, opts.interval())
+	defer stop()
+	ports := newPortRange(conn, opts, opts.fixedPort())
+	hops := newHopTracker(opts)
+	last := Step{Type: TraceComplete}
+	for round := range opts.rounds() {
+		reached := false
+		for ttl := 1; ttl < opts.maxTTL(); ttl++ {
+			if err := wait(ctx, tick); err != nil {
+				return err
+			}
+			ports.sent()
+			sent := clk.Now()
+			recvPkt, peer, err := probe(ctx, conn, pr, pr.packet(ttl), dest, ttl)
+			latency := clk.Since(sent)
+			if err != nil {
+				return err
+			}
+			if recvPkt == nil {
+				// A hop that doesn't answer once hasn't necessarily gone
+				// anywhere.
+				hops.record(ttl, nil, nil, 0, sent, 0)
+				continue
+			}
+			if recvPkt.Type == backend.PacketDestinationUnreachable {
+				return fmt.Errorf("destination unreachable: %v", peer)
+			}
+
+			returnHops := opts.returnHops(recvPkt)
+			if prev := hops.host(ttl); prev == nil || !util.IP(prev).Equal(util.IP(peer)) {
+				step := Step{Pos: ttl, Host: peer, Prev: prev, Extensions: recvPkt.Extensions, ReturnHops: returnHops}
+				if err := send(ctx, res, step); err != nil {
+					return err
+				}
+			}
+			hops.record(ttl, peer, recvPkt.Extensions, returnHops, sent, latency)
+
+			if recvPkt.Type == backend.PacketReply {
+				reached = true
+				// The path may have gotten shorter.
+				for _, pos := range slices.Sorted(maps.Keys(hops.hosts)) {
+					if pos > ttl {
+						if err := send(ctx, res, Step{Pos: pos, Prev: hops.host(pos)}); err != nil {
+							return err
+						}
+						hops.setHost(pos, nil)
+					}
+				}
+				break
+			}
+		}
+		if !pr.paris {
+			ports.advance()
+		}
+		if err := sendEvent(ctx, res, opts, Step{Type: PassComplete, Pass: round, ReachedDest: reached}); err != nil {
+			return err
+		}
+		last.Pass, last.ReachedDest = round, reached
+	}
+	return sendEvent(ctx, res, opts, last)
+}
+
+// Waits for the next tick. Returns ctx's error if it's canceled first.
+func wait(ctx context.Context, tick <-chan time.Time) error {
+	select {
+	case <-tick:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sends a step. Returns ctx's error if it's canceled first.
+func send(ctx context.Context, res chan<- Step, step Step) error {
+	select {
+	case res <- step:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sends a [PassComplete] or [TraceComplete] step, if they're wanted.
+func sendEvent(ctx context.Context, res chan<- Step, opts *Options, step Step) error {
+	if !opts.passEvents() {
+		return nil
+	}
+	return send(ctx, res, step)
+}
+
+// Sends a probe with a given TTL and reads the response. Returns a nil packet
+// if no response arrives in time.
+func probe(ctx context.Context, conn backend.Conn, pr *prober, pkt *backend.Packet, dest net.Addr, ttl int) (*backend.Packet, net.Addr, error) {
+	if err := conn.WriteTo(pkt, dest, backend.TTLOption{TTL: ttl}); err != nil {
+		return nil, nil, fmt.Errorf("error sending ping: %v", err)
+	}
+	recvPkt, peer, err := readReply(ctx, conn, pr, pkt)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		if errors.Is(err, backend.ErrTimeout) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("read error: %v", err)
+	}
+	return recvPkt, peer, nil
 }
 
 // Like time.Tick, but the first tick occurs immediately rather than after d.
-func immediateTick(d time.Duration) <-chan time.Time {
+// Call stop when finished with the ticks.
+func immediateTick(c clock.Clock, d time.Duration) (ticks <-chan time.Time, stop func()) {
 	ch := make(chan time.Time, 1)
 	if d == 0 {
 		close(ch) // No delays.
-		return ch
+		return ch, func() {}
 	}
-	ch <- time.Now()
+	ch <- c.Now()
+	ticker := c.NewTicker(d)
+	done := make(chan any)
 	go func() {
-		for t := range time.Tick(d) {
-			ch <- t
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C():
+				select {
+				case ch <- t:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
 		}
 	}()
-	return ch
+	return ch, func() { close(done) }
 }
 
-func readSeq(conn backend.Conn, seq int) (*backend.Packet, net.Addr, error) {
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+// Reads the reply to a probe, skipping any others.
+func readReply(ctx context.Context, conn backend.Conn, pr *prober, sent *backend.Packet) (*backend.Packet, net.Addr, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	for {
 		pkt, peer, err := conn.ReadFrom(ctx)
-		if pkt != nil && (pkt.Seq != seq || pkt.Type == backend.PacketRequest) {
+		if pkt != nil && !pr.matches(sent, pkt) {
 			continue
 		}
 		return pkt, peer, err
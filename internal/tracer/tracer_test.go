@@ -2,11 +2,14 @@ package tracer
 
 import (
 	"context"
+	"errors"
 	"net"
 	"testing"
 	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/pcekm/vasily/internal/backend"
 	"github.com/pcekm/vasily/internal/backend/test"
 	"github.com/pcekm/vasily/internal/util"
@@ -37,7 +40,7 @@ func checkTrace(t *testing.T, name backend.Name, dest net.Addr, opts *Options, w
 	}
 	opts.Interval = noInterval
 	go func() {
-		if err := TraceRoute(name, util.IPv4, dest, ch, opts); err != nil {
+		if err := TraceRoute(context.Background(), name, util.IPv4, dest, ch, opts); err != nil {
 			errs <- err
 		}
 		close(errs)
@@ -53,7 +56,7 @@ loop:
 			if !ok {
 				break loop
 			}
-			if r.Pos == 0 {
+			if r.Pos == 0 && r.Type == HopFound {
 				t.Errorf("Invalid Step received: %+v", r)
 				break
 			}
@@ -83,6 +86,7 @@ func TestTraceRoute(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	conn := test.NewMockConn(ctrl)
 	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
 
 	for try := 0; try < nTries; try++ {
 		for ttl := 0; ttl < pathLen; ttl++ {
@@ -114,6 +118,47 @@ func TestTraceRoute(t *testing.T) {
 	ctrl.Finish()
 }
 
+func TestTraceRoute_Cancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	conn.EXPECT().WriteTo(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil)
+	reading := make(chan struct{})
+	conn.EXPECT().ReadFrom(gomock.Any()).DoAndReturn(func(ctx context.Context) (*backend.Packet, net.Addr, error) {
+		close(reading)
+		<-ctx.Done()
+		return nil, nil, backend.ErrTimeout
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan Step)
+	errs := make(chan error)
+	go func() { errs <- TraceRoute(ctx, name, util.IPv4, hopAddr(1), ch, &Options{Interval: noInterval}) }()
+	<-reading
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("TraceRoute error = %v (want %v)", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for TraceRoute to return.")
+	}
+	if _, ok := <-ch; ok {
+		t.Error("Result channel not closed.")
+	}
+}
+
+func TestTraceRoute_BadMaxTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	name := test.RegisterMock(test.NewMockConn(ctrl))
+	ch := make(chan Step)
+	if err := TraceRoute(context.Background(), name, util.IPv4, hopAddr(1), ch, &Options{MaxTTL: MaxTTL + 1}); err == nil {
+		t.Errorf("TraceRoute with max TTL %d succeeded (want error)", MaxTTL+1)
+	}
+}
+
 func TestTraceRouteUnreachablePacket(t *testing.T) {
 	const pathLen = 2
 
@@ -122,6 +167,7 @@ func TestTraceRouteUnreachablePacket(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	conn := test.NewMockConn(ctrl)
 	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
 	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
 	opts := traceExchange(2, dest, dest)
 	opts.RecvPkt.Type = backend.PacketDestinationUnreachable
@@ -145,6 +191,7 @@ func TestTraceRouteDroppedPacket(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	conn := test.NewMockConn(ctrl)
 	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
 	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
 
 	opts := traceExchange(2, hopAddr(2), dest)
@@ -166,6 +213,70 @@ func TestTraceRouteDroppedPacket(t *testing.T) {
 	ctrl.Finish()
 }
 
+func TestTraceRouteExtensions(t *testing.T) {
+	dest := hopAddr(2)
+	ext := &backend.Extensions{MPLSLabels: []backend.MPLSLabel{{Label: 16, S: true, TTL: 1}}}
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	opts := traceExchange(1, hopAddr(1), dest)
+	opts.RecvPkt.Extensions = ext
+	conn.MockPingExchange(opts)
+	opts = traceExchange(2, dest, dest)
+	opts.RecvPkt.Type = backend.PacketReply
+	conn.MockPingExchange(opts)
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1), Extensions: ext},
+		{Pos: 2, Host: dest},
+	}
+	if err := checkTrace(t, name, dest, &Options{ProbesPerHop: 1}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTraceRouteReturnPath(t *testing.T) {
+	dest := hopAddr(2)
+
+	for _, returnPath := range []bool{false, true} {
+		ctrl := gomock.NewController(t)
+		conn := test.NewMockConn(ctrl)
+		name := test.RegisterMock(conn)
+		conn.EXPECT().Close().Return(nil)
+		opts := traceExchange(1, hopAddr(1), dest)
+		opts.RecvPkt.TTL = 250
+		conn.MockPingExchange(opts)
+		opts = traceExchange(2, dest, dest)
+		opts.RecvPkt.Type = backend.PacketReply
+		opts.RecvPkt.TTL = 63
+		conn.MockPingExchange(opts)
+
+		want := []Step{
+			{Pos: 1, Host: hopAddr(1)},
+			{Pos: 2, Host: dest},
+		}
+		if returnPath {
+			want[0].ReturnHops = 6
+			want[1].ReturnHops = 2
+		}
+		if err := checkTrace(t, name, dest, &Options{ProbesPerHop: 1, ReturnPath: returnPath}, want); err != nil {
+			t.Errorf("TraceRoute error: %v", err)
+		}
+		if got := want[0].Asymmetric(); got != returnPath {
+			t.Errorf("Hop 1 Asymmetric() = %v with ReturnPath=%v", got, returnPath)
+		}
+		if want[1].Asymmetric() {
+			t.Errorf("Hop 2 asymmetric with ReturnPath=%v", returnPath)
+		}
+
+		ctrl.Finish()
+	}
+}
+
 func TestTraceRouteDeduplication(t *testing.T) {
 	const pathLen = 3
 
@@ -174,6 +285,7 @@ func TestTraceRouteDeduplication(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	conn := test.NewMockConn(ctrl)
 	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
 	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
 	conn.MockPingExchange(traceExchange(2, hopAddr(2), dest))
 	opt := traceExchange(3, hopAddr(5), dest)
@@ -205,3 +317,286 @@ func TestTraceRouteDeduplication(t *testing.T) {
 
 	ctrl.Finish()
 }
+
+func TestTraceRouteContinuous(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+
+	reply := func(ttl int) *test.PingExchangeOpts {
+		return traceExchange(ttl, dest, dest).SetRespType(backend.PacketReply)
+	}
+
+	// Round 1: 1 -> 2 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(traceExchange(2, hopAddr(2), dest))
+	conn.MockPingExchange(reply(3))
+	// Round 2: 1 -> 3 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(traceExchange(2, hopAddr(3), dest))
+	conn.MockPingExchange(reply(3))
+	// Round 3: 1 -> (no reply) -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	opts := traceExchange(2, hopAddr(3), dest)
+	opts.RecvErr = backend.ErrTimeout
+	conn.MockPingExchange(opts)
+	conn.MockPingExchange(reply(3))
+	// Round 4: 1 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(reply(2))
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: hopAddr(2)},
+		{Pos: 3, Host: dest},
+		{Pos: 2, Host: hopAddr(3), Prev: hopAddr(2)},
+		{Pos: 2, Host: dest, Prev: hopAddr(3)},
+		{Pos: 3, Prev: dest},
+	}
+	if err := checkTrace(t, name, dest, &Options{Continuous: true, Rounds: 4}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+// Continuous mode probes each position once per round, whatever
+// ProbesPerHop says, even when a hop doesn't answer.
+func TestTraceRouteContinuous_IgnoresProbesPerHop(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	opts := traceExchange(2, hopAddr(2), dest)
+	opts.RecvErr = backend.ErrTimeout
+	conn.MockPingExchange(opts)
+	conn.MockPingExchange(traceExchange(3, dest, dest).SetRespType(backend.PacketReply))
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 3, Host: dest},
+	}
+	if err := checkTrace(t, name, dest, &Options{Continuous: true, Rounds: 1, ProbesPerHop: 3}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTraceRoutePassEvents(t *testing.T) {
+	dest := hopAddr(2)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	for range 2 {
+		conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+		conn.MockPingExchange(traceExchange(2, dest, dest).SetRespType(backend.PacketReply))
+	}
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: dest},
+		{Type: PassComplete, Pass: 0, ReachedDest: true},
+		{Type: PassComplete, Pass: 1, ReachedDest: true},
+		{Type: TraceComplete, Pass: 1, ReachedDest: true},
+	}
+	if err := checkTrace(t, name, dest, &Options{ProbesPerHop: 2, PassEvents: true}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTraceRoutePassEvents_MaxTTL(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Type: PassComplete},
+		{Type: TraceComplete},
+	}
+	err := checkTrace(t, name, dest, &Options{ProbesPerHop: 1, MaxTTL: 2, PassEvents: true}, want)
+	if !errors.Is(err, ErrMaxTTL) {
+		t.Errorf("TraceRoute error = %v (want %v)", err, ErrMaxTTL)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTraceRouteContinuous_PassEvents(t *testing.T) {
+	dest := hopAddr(2)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	// Round 1 reaches the destination, and round 2 doesn't.
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(traceExchange(2, dest, dest).SetRespType(backend.PacketReply))
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	opts := traceExchange(2, dest, dest)
+	opts.RecvErr = backend.ErrTimeout
+	conn.MockPingExchange(opts)
+
+	want := []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: dest},
+		{Type: PassComplete, Pass: 0, ReachedDest: true},
+		{Type: PassComplete, Pass: 1},
+		{Type: TraceComplete, Pass: 1},
+	}
+	if err := checkTrace(t, name, dest, &Options{Continuous: true, Rounds: 2, MaxTTL: 3, PassEvents: true}, want); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTraceRouteContinuous_Probes(t *testing.T) {
+	dest := hopAddr(9)
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+
+	reply := func(ttl int) *test.PingExchangeOpts {
+		return traceExchange(ttl, dest, dest).SetRespType(backend.PacketReply)
+	}
+
+	// Round 1: 1 -> 2 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(traceExchange(2, hopAddr(2), dest))
+	conn.MockPingExchange(reply(3))
+	// Round 2: 1 -> 3 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(traceExchange(2, hopAddr(3), dest))
+	conn.MockPingExchange(reply(3))
+	// Round 3: 1 -> (no reply) -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	opts := traceExchange(2, hopAddr(3), dest)
+	opts.RecvErr = backend.ErrTimeout
+	conn.MockPingExchange(opts)
+	conn.MockPingExchange(reply(3))
+	// Round 4: 1 -> dest
+	conn.MockPingExchange(traceExchange(1, hopAddr(1), dest))
+	conn.MockPingExchange(reply(2))
+
+	var probes []Probe
+	traceOpts := &Options{
+		Continuous: true,
+		Rounds:     4,
+		OnProbe:    func(p Probe) { probes = append(probes, p) },
+	}
+	if err := checkTrace(t, name, dest, traceOpts, []Step{
+		{Pos: 1, Host: hopAddr(1)},
+		{Pos: 2, Host: hopAddr(2)},
+		{Pos: 3, Host: dest},
+		{Pos: 2, Host: hopAddr(3), Prev: hopAddr(2)},
+		{Pos: 2, Host: dest, Prev: hopAddr(3)},
+		{Pos: 3, Prev: dest},
+	}); err != nil {
+		t.Errorf("TraceRoute error: %v", err)
+	}
+
+	want := []Probe{
+		{Pos: 1, Host: hopAddr(1), Seq: 0, Stats: HopStats{Sent: 1, Received: 1}},
+		{Pos: 2, Host: hopAddr(2), Seq: 0, Stats: HopStats{Sent: 1, Received: 1}},
+		{Pos: 3, Host: dest, Seq: 0, Stats: HopStats{Sent: 1, Received: 1}},
+
+		{Pos: 1, Host: hopAddr(1), Seq: 1, Stats: HopStats{Sent: 2, Received: 2}},
+		{Pos: 2, Host: hopAddr(3), Seq: 0, Stats: HopStats{Sent: 1, Received: 1}},
+		{Pos: 3, Host: dest, Seq: 1, Stats: HopStats{Sent: 2, Received: 2}},
+
+		{Pos: 1, Host: hopAddr(1), Seq: 2, Stats: HopStats{Sent: 3, Received: 3}},
+		{Pos: 2, Host: hopAddr(3), Seq: 1, Lost: true, Stats: HopStats{Sent: 2, Received: 1}},
+		{Pos: 3, Host: dest, Seq: 2, Stats: HopStats{Sent: 3, Received: 3}},
+
+		{Pos: 1, Host: hopAddr(1), Seq: 3, Stats: HopStats{Sent: 4, Received: 4}},
+		{Pos: 2, Host: dest, Seq: 0, Stats: HopStats{Sent: 1, Received: 1}},
+	}
+	ignoreTimes := cmp.Options{
+		cmpopts.IgnoreFields(Probe{}, "Time", "Latency"),
+		cmpopts.IgnoreFields(HopStats{}, "Last", "Min", "Max", "Total"),
+	}
+	if diff := cmp.Diff(want, probes, ignoreTimes); diff != "" {
+		t.Errorf("Wrong probes (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestHopStats(t *testing.T) {
+	var s HopStats
+	for _, l := range []time.Duration{30 * time.Millisecond, -1, 10 * time.Millisecond, -1, 20 * time.Millisecond} {
+		s.add(l)
+	}
+	want := HopStats{
+		Sent:     5,
+		Received: 3,
+		Last:     20 * time.Millisecond,
+		Min:      10 * time.Millisecond,
+		Max:      30 * time.Millisecond,
+		Total:    60 * time.Millisecond,
+	}
+	if diff := cmp.Diff(want, s); diff != "" {
+		t.Errorf("Wrong stats (-want, +got):\n%v", diff)
+	}
+	if s.Lost() != 2 {
+		t.Errorf("Lost() = %d (want 2)", s.Lost())
+	}
+	if s.Loss() != 0.4 {
+		t.Errorf("Loss() = %v (want 0.4)", s.Loss())
+	}
+	if s.Mean() != 20*time.Millisecond {
+		t.Errorf("Mean() = %v (want 20ms)", s.Mean())
+	}
+	if (HopStats{}).Mean() != 0 || (HopStats{}).Loss() != 0 {
+		t.Errorf("Empty stats have nonzero mean or loss.")
+	}
+}
+
+func TestImmediateTick(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clk := fakeclock.NewFakeClock(start)
+	tick, stop := immediateTick(clk, time.Second)
+	defer stop()
+	next := func() time.Time {
+		t.Helper()
+		select {
+		case tm := <-tick:
+			return tm
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for tick")
+			return time.Time{}
+		}
+	}
+
+	if got := next(); !got.Equal(start) {
+		t.Errorf("First tick at %v (want %v)", got, start)
+	}
+	select {
+	case tm := <-tick:
+		t.Errorf("Unexpected tick at %v before the interval", tm)
+	default:
+	}
+	clk.WaitForWatcherAndIncrement(time.Second)
+	if got, want := next(), start.Add(time.Second); !got.Equal(want) {
+		t.Errorf("Second tick at %v (want %v)", got, want)
+	}
+}
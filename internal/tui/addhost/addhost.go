@@ -0,0 +1,132 @@
+// Package addhost implements a screen for adding a new host to ping.
+package addhost
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/tui/help"
+	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+type keyMap struct {
+	Accept key.Binding
+	Esc    key.Binding
+}
+
+func (k *keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Accept, k.Esc}
+}
+
+func (k *keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Accept, k.Esc}}
+}
+
+var defaultKeyMap = keyMap{
+	Accept: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "add"),
+	),
+	Esc: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "cancel"),
+	),
+}
+
+// AddHostMsg is sent when the user has entered a new host.
+type AddHostMsg struct {
+	// Host is the hostname or IP address entered.
+	Host string
+}
+
+// Model prompts the user for a host to add.
+type Model struct {
+	theme         *theme.Theme
+	input         textinput.Model
+	help          *help.Model
+	width, height int
+}
+
+// New creates a new Model.
+func New(theme *theme.Theme) *Model {
+	input := textinput.New()
+	input.Prompt = "Host: "
+	input.Placeholder = "hostname or IP address"
+	m := &Model{
+		input: input,
+		help:  help.New(theme, &defaultKeyMap),
+	}
+	m.SetTheme(theme)
+	return m
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	m.input.PromptStyle = theme.Text.Important
+	m.input.TextStyle = theme.Text.Normal
+	m.input.PlaceholderStyle = theme.Text.Unimportant
+	m.help.SetTheme(theme)
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.help.SetWidth(m.width)
+	case nav.GoMsg:
+		if msg.Screen == nav.AddHost {
+			m.input.Reset()
+			return m.input.Focus()
+		}
+		m.input.Blur()
+	case tea.KeyMsg:
+		return m.handleKeyMsg(msg)
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+func (m *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, defaultKeyMap.Accept):
+		host := strings.TrimSpace(m.input.Value())
+		if host == "" {
+			return nav.Go(nav.Main)
+		}
+		return tea.Sequence(
+			func() tea.Msg { return AddHostMsg{Host: host} },
+			nav.Go(nav.Main),
+		)
+	case key.Matches(msg, defaultKeyMap.Esc):
+		return nav.Go(nav.Main)
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+func (m *Model) titleStyle() lipgloss.Style {
+	return m.theme.Text.Important.
+		Padding(0, 1).
+		Width(m.width).
+		Foreground(m.theme.Colors.OnPrimary).
+		Background(m.theme.Colors.Primary)
+}
+
+func (m *Model) View() string {
+	title := m.titleStyle().Render("Add Host")
+	input := m.theme.Base.Padding(1, 1).Render(m.input.View())
+	body := lipgloss.JoinVertical(lipgloss.Top, title, input)
+	body = lipgloss.PlaceVertical(m.height-m.help.GetHeight(), lipgloss.Top, body)
+	return lipgloss.JoinVertical(lipgloss.Top, body, m.help.View())
+}
@@ -0,0 +1,25 @@
+package tui
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// DefaultLookupURL is the web page about an IP address opened by default.
+const DefaultLookupURL = "https://bgp.tools/prefix/%s"
+
+// Opens a URL in the user's web browser.
+func openURL(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v: %s", cmd.Args[0], err, out)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package columnselect implements a screen for choosing which table columns
+// are shown and in what order.
+package columnselect
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/tui/help"
+	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+type keyMap struct {
+	list.KeyMap
+	Toggle   key.Binding
+	MoveUp   key.Binding
+	MoveDown key.Binding
+	Accept   key.Binding
+	Esc      key.Binding
+	Reset    key.Binding
+}
+
+func (k *keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Toggle, k.MoveUp, k.MoveDown, k.Accept, k.Esc, k.ShowFullHelp}
+}
+
+func (k *keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{k.CursorUp, k.CursorDown, k.NextPage, k.PrevPage, k.GoToStart, k.GoToEnd},
+		{k.Toggle, k.MoveUp, k.MoveDown, k.Reset, k.Accept, k.Esc, k.CloseFullHelp}}
+}
+
+var defaultKeyMap = keyMap{
+	KeyMap: list.DefaultKeyMap(),
+	Toggle: key.NewBinding(
+		key.WithKeys("x", " "),
+		key.WithHelp("x/space", "show/hide"),
+	),
+	MoveUp: key.NewBinding(
+		key.WithKeys("K", "shift+up"),
+		key.WithHelp("K", "move up"),
+	),
+	MoveDown: key.NewBinding(
+		key.WithKeys("J", "shift+down"),
+		key.WithHelp("J", "move down"),
+	),
+	Accept: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "accept"),
+	),
+	Esc: key.NewBinding(
+		key.WithKeys("esc", "q"),
+		key.WithHelp("esc/q", "cancel"),
+	),
+	Reset: key.NewBinding(
+		key.WithKeys("ctrl+d"),
+		key.WithHelp("ctrl+d", "reset"),
+	),
+}
+
+type listItem struct {
+	table.ColumnSetting
+}
+
+func (i listItem) FilterValue() string {
+	return i.Display()
+}
+
+type delegate struct {
+	maxItemWidth int
+	normal       lipgloss.Style
+	highlighted  lipgloss.Style
+}
+
+func (d delegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	it := item.(*listItem)
+	style := d.normal
+	if m.Index() == index {
+		style = d.highlighted
+	}
+	sel := "x"
+	if it.Hidden {
+		sel = " "
+	}
+	width := 4 + d.maxItemWidth
+	line := fmt.Sprintf("[%s] %s", sel, it.Display())
+	fmt.Fprint(w, style.Render(lipgloss.PlaceHorizontal(width, lipgloss.Left, line, lipgloss.WithWhitespaceChars(" "))))
+}
+
+func (d delegate) Height() int {
+	return 1
+}
+
+func (d delegate) Spacing() int {
+	return 0
+}
+
+func (d delegate) Update(msg tea.Msg, m *list.Model) tea.Cmd {
+	return nil
+}
+
+// ChangedMsg is sent when the user accepts new column settings.
+type ChangedMsg struct {
+	// Visible are the columns now shown, in display order.
+	Visible []table.ColumnID
+}
+
+// Model gets the user to choose the table's columns.
+type Model struct {
+	theme         *theme.Theme
+	list          list.Model
+	table         *table.Model
+	help          *help.Model
+	width, height int
+	maxItemWidth  int
+}
+
+// New creates a new Model.
+func New(theme *theme.Theme, tbl *table.Model) *Model {
+	lst := list.New(nil, delegate{}, 0, 0)
+	lst.Title = "Columns"
+	lst.DisableQuitKeybindings()
+	lst.SetFilteringEnabled(false)
+	lst.SetShowStatusBar(false)
+	lst.SetShowHelp(false)
+
+	s := &Model{
+		list:  lst,
+		table: tbl,
+		help:  help.New(theme, &defaultKeyMap),
+	}
+	s.load(tbl.Columns())
+	s.SetTheme(theme)
+	return s
+}
+
+// SetTable sets the table whose columns are chosen.
+func (s *Model) SetTable(tbl *table.Model) {
+	s.table = tbl
+}
+
+// Fills the list with column settings.
+func (s *Model) load(cols []table.ColumnSetting) {
+	items := make([]list.Item, len(cols))
+	s.maxItemWidth = 0
+	for i, c := range cols {
+		items[i] = &listItem{ColumnSetting: c}
+		s.maxItemWidth = max(s.maxItemWidth, len(c.Display()))
+	}
+	s.list.SetItems(items)
+}
+
+// SetTheme changes the theme.
+func (s *Model) SetTheme(theme *theme.Theme) {
+	s.theme = theme
+	s.list.SetDelegate(delegate{
+		maxItemWidth: s.maxItemWidth,
+		normal:       theme.Text.Normal.Padding(0, 1),
+		highlighted: theme.Text.Normal.
+			Foreground(theme.Colors.OnSecondary).
+			Background(theme.Colors.Secondary).
+			Padding(0, 1),
+	})
+	s.list.Styles.Title = theme.Text.Important.
+		Padding(0, 1).
+		Foreground(theme.Colors.OnPrimary).
+		Background(theme.Colors.Primary)
+	s.help.SetTheme(theme)
+	if s.width > 0 {
+		s.updateSizes()
+	}
+}
+
+func (s *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (s *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		s.resize(msg.Width, msg.Height)
+	case tea.KeyMsg:
+		return s.handleKeyMsg(msg)
+	case nav.GoMsg:
+		// Starts from the table's current columns each time.
+		if msg.Screen == nav.ColumnSelect {
+			s.load(s.table.Columns())
+			s.list.Select(0)
+		}
+	}
+	var cmd tea.Cmd
+	s.list, cmd = s.list.Update(msg)
+	return cmd
+}
+
+func (s *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	origHelp := s.help.FullHelp()
+	s.help.SetFullHelp(false)
+	s.updateSizes()
+
+	switch {
+	case key.Matches(msg, defaultKeyMap.ShowFullHelp, defaultKeyMap.CloseFullHelp):
+		s.help.SetFullHelp(!origHelp)
+		s.updateSizes()
+		return nil
+	case key.Matches(msg, defaultKeyMap.Toggle):
+		item := s.list.SelectedItem().(*listItem)
+		item.Hidden = !item.Hidden
+		return nil
+	case key.Matches(msg, defaultKeyMap.MoveUp):
+		s.move(-1)
+		return nil
+	case key.Matches(msg, defaultKeyMap.MoveDown):
+		s.move(1)
+		return nil
+	case key.Matches(msg, defaultKeyMap.Reset):
+		s.load(table.DefaultColumns())
+		return nil
+	case key.Matches(msg, defaultKeyMap.Accept):
+		return s.handleKeyAccept()
+	case key.Matches(msg, defaultKeyMap.Esc):
+		return nav.Go(nav.Main)
+	}
+
+	var cmd tea.Cmd
+	s.list, cmd = s.list.Update(msg)
+	return cmd
+}
+
+// Moves the selected column up or down by n places.
+func (s *Model) move(n int) {
+	i := s.list.Index()
+	j := i + n
+	items := s.list.Items()
+	if j < 0 || j >= len(items) {
+		return
+	}
+	items[i], items[j] = items[j], items[i]
+	s.list.SetItems(items)
+	s.list.Select(j)
+}
+
+func (s *Model) handleKeyAccept() tea.Cmd {
+	var cols []table.ColumnSetting
+	for _, item := range s.list.Items() {
+		cols = append(cols, item.(*listItem).ColumnSetting)
+	}
+	s.table.SetColumns(cols)
+	visible := s.table.VisibleColumns()
+	return tea.Batch(nav.Go(nav.Main), func() tea.Msg { return ChangedMsg{Visible: visible} })
+}
+
+func (s *Model) resize(width, height int) {
+	s.width = width
+	s.height = height
+	s.updateSizes()
+}
+
+func (s *Model) updateSizes() {
+	s.list.Styles.TitleBar = s.theme.Text.Normal.
+		Foreground(s.theme.Colors.OnPrimary).
+		Background(s.theme.Colors.Primary).
+		Width(s.width)
+	s.help.SetWidth(s.width)
+	hh := s.help.GetHeight()
+	s.list.SetSize(s.width, s.height-hh)
+}
+
+func (s *Model) View() string {
+	return lipgloss.JoinVertical(lipgloss.Top, s.list.View(), s.help.View())
+}
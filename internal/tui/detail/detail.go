@@ -0,0 +1,254 @@
+// Package detail implements a screen showing the statistics, latency
+// histogram and outages of a single row.
+package detail
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/tui/help"
+	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+type keyMap struct {
+	Esc key.Binding
+}
+
+func (k *keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Esc}
+}
+
+func (k *keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Esc}}
+}
+
+var defaultKeyMap = keyMap{
+	Esc: key.NewBinding(
+		key.WithKeys("esc", "q"),
+		key.WithHelp("esc/q", "back"),
+	),
+}
+
+// Model shows the details of a single row. The details are read from the
+// table each time the view is rendered.
+type Model struct {
+	theme         *theme.Theme
+	table         *table.Model
+	help          *help.Model
+	key           table.RowKey
+	width, height int
+}
+
+// New creates a new Model.
+func New(theme *theme.Theme, tbl *table.Model) *Model {
+	m := &Model{
+		table: tbl,
+		help:  help.New(theme, &defaultKeyMap),
+	}
+	m.SetTheme(theme)
+	return m
+}
+
+// SetRow sets the row to show, and the table it's in.
+func (m *Model) SetRow(tbl *table.Model, k table.RowKey) {
+	m.table = tbl
+	m.key = k
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	m.help.SetTheme(theme)
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.help.SetWidth(m.width)
+	case tea.KeyMsg:
+		if key.Matches(msg, defaultKeyMap.Esc) {
+			return nav.Go(nav.Main)
+		}
+	}
+	return nil
+}
+
+func (m *Model) titleStyle() lipgloss.Style {
+	return m.theme.Text.Important.
+		Padding(0, 1).
+		Width(m.width).
+		Foreground(m.theme.Colors.OnPrimary).
+		Background(m.theme.Colors.Primary)
+}
+
+func (m *Model) View() string {
+	var title, body string
+	if r, ok := m.table.Row(m.key); ok {
+		title = r.DisplayHost
+		body = m.rowView(r)
+	} else {
+		title = "Details"
+		body = m.theme.Text.Unimportant.Render("The row has been removed.")
+	}
+	body = lipgloss.JoinVertical(lipgloss.Top,
+		m.titleStyle().Render(title),
+		m.theme.Base.Padding(1, 1).Render(body))
+	body = lipgloss.PlaceVertical(m.height-m.help.GetHeight(), lipgloss.Top, body)
+	return lipgloss.JoinVertical(lipgloss.Top, body, m.help.View())
+}
+
+// Renders the details of a row.
+func (m *Model) rowView(r table.Row) string {
+	st := r.Pinger.Stats()
+	var lines [][2]string
+	add := func(label, format string, args ...any) {
+		lines = append(lines, [2]string{label, fmt.Sprintf(format, args...)})
+	}
+	add("Address", "%v", util.IP(r.Addr))
+	if prefix := lookup.NAT64Prefix; prefix != nil {
+		add("Path", "%v", nat64Path(prefix, util.IP(r.Addr)))
+	}
+	add("Pings", "%d sent, %d lost (%.1f%%)", st.N, st.Failures, 100*st.PacketLoss())
+	if st.Duplicates > 0 {
+		add("Duplicates", "%d (%.1f%%)", st.Duplicates, 100*st.DuplicateRate())
+	}
+	if st.Late > 0 {
+		add("Late replies", "%d", st.Late)
+	}
+	if st.Corrupted > 0 {
+		add("Corrupted", "%d", st.Corrupted)
+	}
+	if n := r.Pinger.SocketDrops(); n > 0 {
+		add("Socket drops", "%d replies dropped by the kernel before vasily read them", n)
+	}
+	add("Latency", "avg %v, min %v, max %v, last %v", ms(st.AvgLatency), ms(st.MinLatency), ms(st.MaxLatency), ms(st.LastLatency))
+	add("Percentiles", "p50 %v, p95 %v, p99 %v", ms(st.P50), ms(st.P95), ms(st.P99))
+	add("Jitter", "%v (std dev %v)", ms(st.Jitter), ms(st.StdDev))
+
+	events := r.Pinger.Events()
+	if len(events) == 0 {
+		add("Last outage", "none")
+	} else {
+		add("Last outage", "%v", outageString(events[len(events)-1]))
+		add("Outages", "%d", len(events))
+	}
+
+	if ext := r.Extensions; ext != nil {
+		for _, l := range ext.MPLSLabels {
+			add("MPLS", "%v", l)
+		}
+		for _, info := range ext.Interfaces {
+			add("Interface", "%v", info)
+		}
+	}
+
+	if r.ReturnHops != 0 {
+		s := fmt.Sprintf("about %d hops back, %d out", r.ReturnHops, r.Index)
+		if tracer.Asymmetric(r.Index, r.ReturnHops) {
+			s += " (asymmetric)"
+		}
+		add("Return path", "%s", s)
+	}
+
+	labelStyle := m.theme.Text.Important.Width(14)
+	var sb strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(labelStyle.Render(l[0]))
+		sb.WriteString(m.theme.Text.Normal.Render(l[1]))
+	}
+	if hist := r.Pinger.Histogram(); hist.Total() > 0 {
+		sb.WriteString("\n\n")
+		sb.WriteString(m.theme.Text.Important.Render("Latency histogram"))
+		sb.WriteString("\n")
+		sb.WriteString(m.histogramView(hist))
+	}
+	return sb.String()
+}
+
+// Renders a histogram as a bar chart, one bucket per line, from the lowest
+// bucket with any latencies in it to the highest.
+func (m *Model) histogramView(h pinger.Histogram) string {
+	first, last := -1, 0
+	for i, c := range h.Counts {
+		if c > 0 {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	maxCount := slices.Max(h.Counts)
+	countWidth := len(fmt.Sprint(maxCount))
+	const labelWidth = 9
+	// Two for the padding, and two for the spaces around the bars.
+	barWidth := max(1, m.width-labelWidth-countWidth-4)
+	bar := termcap.Glyph("█", "#")
+
+	var sb strings.Builder
+	for i := first; i <= last; i++ {
+		if i > first {
+			sb.WriteString("\n")
+		}
+		bounds := pinger.HistogramBounds
+		label := fmt.Sprintf("> %v", bounds[len(bounds)-1])
+		if i < len(bounds) {
+			label = fmt.Sprintf("%s%v", termcap.Glyph("≤ ", "<="), bounds[i])
+		}
+		n := h.Counts[i] * barWidth / maxCount
+		if h.Counts[i] > 0 {
+			n = max(1, n)
+		}
+		fmt.Fprintf(&sb, "%*s ", labelWidth, label)
+		sb.WriteString(m.theme.Text.Normal.
+			Foreground(m.theme.Heatmap.At(float64(i) / float64(len(bounds)))).
+			Render(strings.Repeat(bar, n)))
+		fmt.Fprintf(&sb, "%*s %*d", barWidth-n, "", countWidth, h.Counts[i])
+	}
+	return sb.String()
+}
+
+// Describes how a host is reached when NAT64 is in use.
+func nat64Path(prefix *net.IPNet, ip net.IP) string {
+	if ip4 := lookup.NAT64Embedded(prefix, ip); ip4 != nil {
+		return fmt.Sprintf("NAT64 to %v via %v", ip4, prefix)
+	}
+	if ip.To4() != nil {
+		return "IPv4, through the local CLAT if there's no IPv4 route"
+	}
+	return "native IPv6"
+}
+
+// Describes an outage.
+func outageString(e pinger.Event) string {
+	start := e.Start.Format(time.DateTime)
+	if e.Ongoing() {
+		return fmt.Sprintf("ongoing since %s, %d pings lost", start, e.Lost)
+	}
+	return fmt.Sprintf("%s for %v, %d pings lost", start, e.Duration().Round(time.Second), e.Lost)
+}
+
+// Rounds a latency for display.
+func ms(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
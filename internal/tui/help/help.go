@@ -2,12 +2,18 @@
 package help
 
 import (
+	"strings"
+
 	"github.com/charmbracelet/bubbles/help"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/tui/termcap"
 	"github.com/pcekm/vasily/internal/tui/theme"
 )
 
+// Arrows in key names, and their ASCII replacements.
+var asciiArrows = strings.NewReplacer("↑", "^", "↓", "v", "←", "<", "→", ">")
+
 type Model struct {
 	keyMap  help.KeyMap
 	keyHelp help.Model
@@ -19,25 +25,32 @@ func New(theme *theme.Theme, km help.KeyMap) *Model {
 	m := &Model{
 		keyMap:  km,
 		keyHelp: help.New(),
-		theme:   theme,
 	}
+	m.SetTheme(theme)
+	return m
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	def := help.New().Styles
+
 	m.keyHelp.Styles.Ellipsis = theme.Text.Unimportant.
-		Inherit(m.keyHelp.Styles.Ellipsis)
+		Inherit(def.Ellipsis)
 
 	m.keyHelp.Styles.FullKey = theme.Text.Important.
-		Inherit(m.keyHelp.Styles.FullKey)
+		Inherit(def.FullKey)
 	m.keyHelp.Styles.FullDesc = theme.Text.Normal.
-		Inherit(m.keyHelp.Styles.FullDesc)
+		Inherit(def.FullDesc)
 	m.keyHelp.Styles.FullSeparator = theme.Text.Unimportant.
-		Inherit(m.keyHelp.Styles.FullSeparator)
+		Inherit(def.FullSeparator)
 
 	m.keyHelp.Styles.ShortKey = theme.Text.Normal.
-		Inherit(m.keyHelp.Styles.ShortKey)
+		Inherit(def.ShortKey)
 	m.keyHelp.Styles.ShortDesc = theme.Text.Unimportant.
-		Inherit(m.keyHelp.Styles.ShortDesc)
+		Inherit(def.ShortDesc)
 	m.keyHelp.Styles.ShortSeparator = theme.Text.Unimportant.
-		Inherit(m.keyHelp.Styles.ShortSeparator)
-	return m
+		Inherit(def.ShortSeparator)
 }
 
 // FullHelp determines if the full help is displayed.
@@ -52,7 +65,19 @@ func (m *Model) SetFullHelp(b bool) {
 
 // GetHeight returns the natural height of the help display.
 func (m *Model) GetHeight() int {
-	return lipgloss.Height(m.keyHelp.View(m.keyMap)) + m.style().GetVerticalFrameSize()
+	return lipgloss.Height(m.keyView()) + m.style().GetVerticalFrameSize()
+}
+
+// Renders the keys, in ASCII if need be. The arrows are replaced after
+// rendering, which works since their replacements are the same width.
+func (m *Model) keyView() string {
+	m.keyHelp.ShortSeparator = termcap.Glyph(" • ", " | ")
+	m.keyHelp.Ellipsis = termcap.Ellipsis()
+	v := m.keyHelp.View(m.keyMap)
+	if termcap.Current().ASCII {
+		v = asciiArrows.Replace(v)
+	}
+	return v
 }
 
 // Sets the width of the display.
@@ -64,7 +89,7 @@ func (m *Model) SetWidth(width int) {
 func (m *Model) style() lipgloss.Style {
 	if m.keyHelp.ShowAll {
 		return m.theme.Base.
-			Border(lipgloss.NormalBorder(), true, false, false, false).
+			Border(termcap.Border(), true, false, false, false).
 			BorderForeground(m.theme.Colors.OnSurfaceVariant).
 			Padding(0, 1)
 	}
@@ -88,5 +113,5 @@ func (m *Model) View() string {
 	style := m.style()
 	return style.
 		Width(m.width - style.GetHorizontalBorderSize()).
-		Render(m.keyHelp.View(m.keyMap))
+		Render(m.keyView())
 }
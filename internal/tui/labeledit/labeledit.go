@@ -0,0 +1,143 @@
+// Package labeledit implements a screen for editing the label of a host.
+package labeledit
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/tui/help"
+	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+type keyMap struct {
+	Accept key.Binding
+	Esc    key.Binding
+}
+
+func (k *keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Accept, k.Esc}
+}
+
+func (k *keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{k.Accept, k.Esc}}
+}
+
+var defaultKeyMap = keyMap{
+	Accept: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "save"),
+	),
+	Esc: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "cancel"),
+	),
+}
+
+// SetLabelMsg is sent when the user has entered a label.
+type SetLabelMsg struct {
+	// Addr is the address being labeled.
+	Addr net.Addr
+
+	// Label is the label entered. It's empty if the label was cleared.
+	Label string
+}
+
+// Model prompts the user for the label of an address.
+type Model struct {
+	theme         *theme.Theme
+	input         textinput.Model
+	help          *help.Model
+	addr          net.Addr
+	width, height int
+}
+
+// New creates a new Model.
+func New(theme *theme.Theme) *Model {
+	input := textinput.New()
+	input.Prompt = "Label: "
+	input.Placeholder = "free text; empty to clear"
+	m := &Model{
+		input: input,
+		help:  help.New(theme, &defaultKeyMap),
+	}
+	m.SetTheme(theme)
+	return m
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	m.input.PromptStyle = theme.Text.Important
+	m.input.TextStyle = theme.Text.Normal
+	m.input.PlaceholderStyle = theme.Text.Unimportant
+	m.help.SetTheme(theme)
+}
+
+// Edit sets the address to label, and its current label. Call before going
+// to the screen.
+func (m *Model) Edit(addr net.Addr, label string) {
+	m.addr = addr
+	m.input.SetValue(label)
+	m.input.CursorEnd()
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.help.SetWidth(m.width)
+	case nav.GoMsg:
+		if msg.Screen == nav.EditLabel {
+			return m.input.Focus()
+		}
+		m.input.Blur()
+	case tea.KeyMsg:
+		return m.handleKeyMsg(msg)
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+func (m *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, defaultKeyMap.Accept):
+		set := SetLabelMsg{Addr: m.addr, Label: strings.TrimSpace(m.input.Value())}
+		return tea.Sequence(
+			func() tea.Msg { return set },
+			nav.Go(nav.Main),
+		)
+	case key.Matches(msg, defaultKeyMap.Esc):
+		return nav.Go(nav.Main)
+	}
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+	return cmd
+}
+
+func (m *Model) titleStyle() lipgloss.Style {
+	return m.theme.Text.Important.
+		Padding(0, 1).
+		Width(m.width).
+		Foreground(m.theme.Colors.OnPrimary).
+		Background(m.theme.Colors.Primary)
+}
+
+func (m *Model) View() string {
+	title := m.titleStyle().Render(fmt.Sprintf("Label for %v", util.IP(m.addr)))
+	input := m.theme.Base.Padding(1, 1).Render(m.input.View())
+	body := lipgloss.JoinVertical(lipgloss.Top, title, input)
+	body = lipgloss.PlaceVertical(m.height-m.help.GetHeight(), lipgloss.Top, body)
+	return lipgloss.JoinVertical(lipgloss.Top, body, m.help.View())
+}
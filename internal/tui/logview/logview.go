@@ -0,0 +1,173 @@
+// Package logview implements a screen showing the most recent log entries.
+package logview
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/logging"
+	"github.com/pcekm/vasily/internal/tui/help"
+	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+type keyMap struct {
+	Up   key.Binding
+	Down key.Binding
+	PgUp key.Binding
+	PgDn key.Binding
+	End  key.Binding
+	Esc  key.Binding
+}
+
+func (k *keyMap) ShortHelp() []key.Binding {
+	return []key.Binding{k.Up, k.Down, k.PgUp, k.PgDn, k.End, k.Esc}
+}
+
+func (k *keyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{k.ShortHelp()}
+}
+
+var defaultKeyMap = keyMap{
+	Up: key.NewBinding(
+		key.WithKeys("up", "k"),
+		key.WithHelp("↑/k", "older"),
+	),
+	Down: key.NewBinding(
+		key.WithKeys("down", "j"),
+		key.WithHelp("↓/j", "newer"),
+	),
+	PgUp: key.NewBinding(
+		key.WithKeys("pgup", "left", "h"),
+		key.WithHelp("←/h/pgup", "prev page"),
+	),
+	PgDn: key.NewBinding(
+		key.WithKeys("pgdn", "right", "l"),
+		key.WithHelp("→/l/pgdn", "next page"),
+	),
+	End: key.NewBinding(
+		key.WithKeys("end", "G"),
+		key.WithHelp("G/end", "follow"),
+	),
+	Esc: key.NewBinding(
+		key.WithKeys("esc", "q"),
+		key.WithHelp("esc/q", "back"),
+	),
+}
+
+// Model shows the recent log entries, newest at the bottom. The entries are
+// read from the log each time the view is rendered. It follows new entries
+// unless scrolled back.
+type Model struct {
+	theme         *theme.Theme
+	help          *help.Model
+	width, height int
+
+	// Entries scrolled back from the newest.
+	back int
+}
+
+// New creates a new Model.
+func New(theme *theme.Theme) *Model {
+	m := &Model{
+		help: help.New(theme, &defaultKeyMap),
+	}
+	m.SetTheme(theme)
+	return m
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	m.help.SetTheme(theme)
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.help.SetWidth(m.width)
+	case tea.KeyMsg:
+		switch {
+		case key.Matches(msg, defaultKeyMap.Esc):
+			m.back = 0
+			return nav.Go(nav.Main)
+		case key.Matches(msg, defaultKeyMap.Up):
+			m.scroll(1)
+		case key.Matches(msg, defaultKeyMap.Down):
+			m.scroll(-1)
+		case key.Matches(msg, defaultKeyMap.PgUp):
+			m.scroll(m.pageSize())
+		case key.Matches(msg, defaultKeyMap.PgDn):
+			m.scroll(-m.pageSize())
+		case key.Matches(msg, defaultKeyMap.End):
+			m.back = 0
+		}
+	}
+	return nil
+}
+
+// Scrolls back by n entries, or forward if n is negative.
+func (m *Model) scroll(n int) {
+	m.back = max(0, min(m.back+n, len(logging.Recent())-m.pageSize()))
+}
+
+// Returns the number of entries that fit on the screen.
+func (m *Model) pageSize() int {
+	return max(1, m.height-m.help.GetHeight()-1)
+}
+
+func (m *Model) titleStyle() lipgloss.Style {
+	return m.theme.Text.Important.
+		Padding(0, 1).
+		Width(m.width).
+		Foreground(m.theme.Colors.OnPrimary).
+		Background(m.theme.Colors.Primary)
+}
+
+// Returns the style for an entry.
+func (m *Model) entryStyle(e logging.Entry) lipgloss.Style {
+	switch e.Level {
+	case logging.Debug:
+		return m.theme.Text.Unimportant
+	case logging.Warn:
+		return m.theme.Text.Important
+	case logging.Error:
+		return m.theme.Text.Important.Foreground(m.theme.Colors.Error)
+	default:
+		return m.theme.Text.Normal
+	}
+}
+
+func (m *Model) View() string {
+	entries := logging.Recent()
+	title := "Log"
+	if m.back > 0 {
+		title += " (scrolled back; G to follow)"
+	}
+	end := max(0, len(entries)-m.back)
+	entries = entries[max(0, end-m.pageSize()):end]
+
+	var body string
+	if len(entries) == 0 {
+		body = m.theme.Text.Unimportant.Render("Nothing has been logged.")
+	} else {
+		var sb strings.Builder
+		for i, e := range entries {
+			if i > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(m.entryStyle(e).MaxWidth(m.width).Render(e.String()))
+		}
+		body = sb.String()
+	}
+	body = lipgloss.JoinVertical(lipgloss.Top, m.titleStyle().Render(title), body)
+	body = lipgloss.PlaceVertical(m.height-m.help.GetHeight(), lipgloss.Top, body)
+	return lipgloss.JoinVertical(lipgloss.Top, body, m.help.View())
+}
@@ -11,6 +11,11 @@ const (
 	_ Screen = iota
 	Main
 	SortSelect
+	AddHost
+	Detail
+	ColumnSelect
+	Log
+	EditLabel
 )
 
 // GoMsg is a message to go to a given model.
@@ -12,6 +12,7 @@ import (
 	"github.com/pcekm/vasily/internal/tui/help"
 	"github.com/pcekm/vasily/internal/tui/nav"
 	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/termcap"
 	"github.com/pcekm/vasily/internal/tui/theme"
 )
 
@@ -105,9 +106,9 @@ func (d delegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
 	}
 	rev := " "
 	if it.Selected() > 0 {
-		rev = "▲"
+		rev = termcap.Glyph("▲", "^")
 		if it.Reversed() {
-			rev = "▼"
+			rev = termcap.Glyph("▼", "v")
 		}
 	}
 	width := 6 + d.maxItemWidth
@@ -138,12 +139,37 @@ type Model struct {
 	help          *help.Model
 	width, height int
 	nSelected     int
+	maxItemWidth  int
 }
 
 // New creates a new Model.
 func New(theme *theme.Theme, tbl *table.Model) *Model {
-	curSelected := tbl.Sort()
 	maxWidth := 0
+	for _, col := range table.AvailColumns() {
+		if n := len(col.Display()); n > maxWidth {
+			maxWidth = n
+		}
+	}
+
+	lst := list.New(nil, delegate{maxItemWidth: maxWidth}, 0, 0)
+	lst.DisableQuitKeybindings()
+	lst.SetFilteringEnabled(false)
+	lst.SetShowStatusBar(false)
+	lst.SetShowHelp(false)
+
+	s := &Model{
+		list:         lst,
+		help:         help.New(theme, &defaultKeyMap),
+		maxItemWidth: maxWidth,
+	}
+	s.SetTable(tbl)
+	s.SetTheme(theme)
+	return s
+}
+
+// SetTable sets the table to sort, and starts from its current sort order.
+func (s *Model) SetTable(tbl *table.Model) {
+	curSelected := tbl.Sort()
 	var items []list.Item
 	for _, col := range table.AvailColumns() {
 		j := slices.IndexFunc(curSelected, func(c table.SortColumn) bool { return c.ColumnID == col })
@@ -152,35 +178,30 @@ func New(theme *theme.Theme, tbl *table.Model) *Model {
 		} else {
 			items = append(items, &listItem{Col: table.SortColumn{ColumnID: col}})
 		}
-		if n := len(col.Display()); n > maxWidth {
-			maxWidth = n
-		}
 	}
+	s.list.SetItems(items)
+	s.table = tbl
+	s.nSelected = len(curSelected)
+}
 
-	delegate := delegate{
-		maxItemWidth: maxWidth,
+// SetTheme changes the theme.
+func (s *Model) SetTheme(theme *theme.Theme) {
+	s.theme = theme
+	s.list.SetDelegate(delegate{
+		maxItemWidth: s.maxItemWidth,
 		normal:       theme.Text.Normal.Padding(0, 1),
 		highlighted: theme.Text.Normal.
 			Foreground(theme.Colors.OnSecondary).
 			Background(theme.Colors.Secondary).
 			Padding(0, 1),
-	}
-	lst := list.New(items, delegate, 0, 0)
-	lst.DisableQuitKeybindings()
-	lst.SetFilteringEnabled(false)
-	lst.SetShowStatusBar(false)
-	lst.SetShowHelp(false)
-	lst.Styles.Title = theme.Text.Important.
+	})
+	s.list.Styles.Title = theme.Text.Important.
 		Padding(0, 1).
 		Foreground(theme.Colors.OnPrimary).
 		Background(theme.Colors.Primary)
-
-	return &Model{
-		theme:     theme,
-		list:      lst,
-		table:     tbl,
-		help:      help.New(theme, &defaultKeyMap),
-		nSelected: len(curSelected),
+	s.help.SetTheme(theme)
+	if s.width > 0 {
+		s.updateSizes()
 	}
 }
 
@@ -0,0 +1,125 @@
+// Package statusbar implements a line summarizing all the targets: how many
+// there are, how fast results are coming in, the overall loss, how long the
+// program has been running, and the state of the privileged helper.
+package statusbar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/pcekm/vasily/internal/privsep"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+// How often the rate is worked out. It's averaged over this long, so that it
+// doesn't flicker with every refresh.
+const rateInterval = time.Second
+
+// Model is the status bar. It's one line high.
+type Model struct {
+	theme    *theme.Theme
+	targets  *targets.Manager
+	width    int
+	start    time.Time
+	finished time.Time // Zero until the run has finished.
+
+	// Totals over all the targets as of the last refresh.
+	nTargets    int
+	sent, lost  int
+	rate        float64 // Results per second.
+	rateSent    int     // Sent when the rate was last worked out.
+	rateUpdated time.Time
+}
+
+// New creates a status bar for the targets in m.
+func New(theme *theme.Theme, m *targets.Manager) *Model {
+	now := time.Now()
+	return &Model{
+		theme:       theme,
+		targets:     m,
+		start:       now,
+		rateUpdated: now,
+	}
+}
+
+// SetTheme changes the theme.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+}
+
+// Refresh totals the statistics of all the targets. It's called on every
+// screen update.
+func (m *Model) Refresh(now time.Time) {
+	m.nTargets, m.sent, m.lost = 0, 0, 0
+	for _, t := range m.targets.Targets() {
+		st := t.Pinger.Stats()
+		m.nTargets++
+		m.sent += st.N
+		m.lost += st.Failures
+	}
+	// Targets that go away take their counts with them.
+	m.rateSent = min(m.rateSent, m.sent)
+	if d := now.Sub(m.rateUpdated); d >= rateInterval {
+		m.rate = float64(m.sent-m.rateSent) / d.Seconds()
+		m.rateSent = m.sent
+		m.rateUpdated = now
+	}
+}
+
+// SetFinished shows that the run finished at t, in place of how long it's
+// been running.
+func (m *Model) SetFinished(t time.Time) {
+	m.finished = t
+}
+
+func (m *Model) Init() tea.Cmd {
+	return nil
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	if msg, ok := msg.(tea.WindowSizeMsg); ok {
+		m.width = msg.Width
+	}
+	return nil
+}
+
+// Height returns the number of lines in the view.
+func (m *Model) Height() int {
+	return 1
+}
+
+func (m *Model) style() lipgloss.Style {
+	return m.theme.Text.Unimportant.
+		Padding(0, 1).
+		Width(m.width).
+		MaxWidth(m.width)
+}
+
+func (m *Model) View() string {
+	loss := 0.0
+	if m.sent > 0 {
+		loss = 100 * float64(m.lost) / float64(m.sent)
+	}
+	targets := "targets"
+	if m.nTargets == 1 {
+		targets = "target"
+	}
+	up := fmt.Sprintf("up %v", time.Since(m.start).Truncate(time.Second))
+	if !m.finished.IsZero() {
+		up = m.theme.Text.Important.Render(
+			fmt.Sprintf("finished after %v; q to quit", m.finished.Sub(m.start).Truncate(time.Second)))
+	}
+	parts := []string{
+		fmt.Sprintf("%d %s", m.nTargets, targets),
+		fmt.Sprintf("%.1f pps", m.rate),
+		fmt.Sprintf("%.1f%% loss", loss),
+		up,
+		privsep.CurrentStatus().String(),
+	}
+	return m.style().Render(strings.Join(parts, termcap.Glyph(" │ ", " | ")))
+}
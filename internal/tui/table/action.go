@@ -0,0 +1,77 @@
+package table
+
+import (
+	"net"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// CopyMsg is a request to copy text to the clipboard.
+type CopyMsg struct {
+	Text string
+}
+
+// LookupMsg is a request to open a web page about an address.
+type LookupMsg struct {
+	Addr net.Addr
+}
+
+// LabelMsg is a request to edit the label for an address.
+type LabelMsg struct {
+	Addr  net.Addr
+	Label string
+}
+
+// An action done to the selected row when its key is pressed. The action
+// returns a message for the program to act on, or nil if there's nothing to
+// do for the row.
+type rowAction struct {
+	binding *key.Binding
+	do      func(r Row) tea.Msg
+}
+
+var rowActions = []rowAction{
+	{&defaultKeyMap.Remove, func(r Row) tea.Msg { return RemoveRowMsg{RowKey: r.RowKey} }},
+	{&defaultKeyMap.Pause, func(r Row) tea.Msg { return PauseRowMsg{RowKey: r.RowKey} }},
+	{&defaultKeyMap.Detail, func(r Row) tea.Msg { return DetailMsg{RowKey: r.RowKey} }},
+	{&defaultKeyMap.CopyAddr, func(r Row) tea.Msg {
+		if ip := util.IP(r.Addr); ip != nil {
+			return CopyMsg{Text: ip.String()}
+		}
+		return nil
+	}},
+	{&defaultKeyMap.CopyHost, func(r Row) tea.Msg { return CopyMsg{Text: r.DisplayHost} }},
+	{&defaultKeyMap.Lookup, func(r Row) tea.Msg {
+		if util.IP(r.Addr) != nil {
+			return LookupMsg{Addr: r.Addr}
+		}
+		return nil
+	}},
+	{&defaultKeyMap.Label, func(r Row) tea.Msg {
+		if util.IP(r.Addr) != nil {
+			return LabelMsg{Addr: r.Addr, Label: r.Label}
+		}
+		return nil
+	}},
+}
+
+// Returns a command for the row action bound to a key, if there is one. The
+// command does nothing if there's no row selected.
+func (t *Model) rowAction(msg tea.KeyMsg) (tea.Cmd, bool) {
+	for _, a := range rowActions {
+		if !key.Matches(msg, *a.binding) {
+			continue
+		}
+		r, ok := t.Selected()
+		if !ok {
+			return nil, true
+		}
+		if m := a.do(r); m != nil {
+			return func() tea.Msg { return m }, true
+		}
+		return nil, true
+	}
+	return nil, false
+}
@@ -0,0 +1,45 @@
+package table
+
+import (
+	"net"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestRowActions(t *testing.T) {
+	tbl := newTestTable(t)
+	tbl.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+
+	press := func(r rune) tea.Msg {
+		cmd := tbl.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		if cmd == nil {
+			return nil
+		}
+		return cmd()
+	}
+
+	// The first line is a group header, which has no actions.
+	if msg := press('y'); msg != nil {
+		t.Errorf("Copy on a group header = %#v (want nil)", msg)
+	}
+	tbl.Update(tea.KeyMsg{Type: tea.KeyDown})
+	cases := []struct {
+		key  rune
+		want tea.Msg
+	}{
+		{key: 'y', want: CopyMsg{Text: "192.0.2.1"}},
+		{key: 'Y', want: CopyMsg{Text: "192.0.2.1"}},
+		{key: 'o', want: LookupMsg{Addr: addr}},
+		{key: 'i', want: DetailMsg{RowKey: RowKey{Group: "192.0.2.1", Index: 1}}},
+		{key: 'e', want: LabelMsg{Addr: addr}},
+	}
+	for _, c := range cases {
+		if diff := cmp.Diff(c.want, press(c.key), cmpopts.IgnoreUnexported(net.UDPAddr{})); diff != "" {
+			t.Errorf("Key %q wrong message (-want, +got):\n%v", c.key, diff)
+		}
+	}
+}
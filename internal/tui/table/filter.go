@@ -0,0 +1,105 @@
+package table
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Returns true if a host matches a filter. A filter that parses as a CIDR
+// prefix matches addresses in it. Anything else matches host names and
+// addresses containing it, ignoring case.
+func matchFilter(filter, host string, addr net.Addr) bool {
+	if _, pfx, err := net.ParseCIDR(filter); err == nil {
+		ip := util.IP(addr)
+		return ip != nil && pfx.Contains(ip)
+	}
+	filter = strings.ToLower(filter)
+	if strings.Contains(strings.ToLower(host), filter) {
+		return true
+	}
+	ip := util.IP(addr)
+	return ip != nil && strings.Contains(ip.String(), filter)
+}
+
+// Returns true if a row's label contains a filter, ignoring case.
+func matchLabel(filter, label string) bool {
+	return label != "" && strings.Contains(strings.ToLower(label), strings.ToLower(filter))
+}
+
+// Returns the filter being applied, or an empty string if there isn't one.
+func (t *Model) filterValue() string {
+	return strings.TrimSpace(t.filter.Value())
+}
+
+// Returns true if a row passes the filter. Hops also pass if the path's
+// destination does, so that filtering for a destination shows its whole path.
+func (t *Model) matches(r Row) bool {
+	f := t.filterValue()
+	if f == "" || matchFilter(f, r.DisplayHost, r.Addr) || matchLabel(f, r.Label) {
+		return true
+	}
+	return r.Index > 0 && matchFilter(f, r.Group, &net.IPAddr{IP: net.ParseIP(r.Group)})
+}
+
+// Filtering returns true while the filter input is open and taking keys.
+func (t *Model) Filtering() bool {
+	return t.filtering
+}
+
+// Opens the filter input.
+func (t *Model) startFilter() tea.Cmd {
+	t.filtering = true
+	t.updateSizes()
+	return t.filter.Focus()
+}
+
+// Handles keys while the filter input is open. Enter closes the input and
+// keeps the filter. Esc clears it.
+func (t *Model) handleFilterKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, defaultKeyMap.ClearFilter):
+		t.clearFilter()
+		return nil
+	case msg.Type == tea.KeyEnter:
+		t.filtering = false
+		t.filter.Blur()
+		t.updateSizes()
+		t.UpdateRows()
+		return nil
+	}
+	var cmd tea.Cmd
+	t.filter, cmd = t.filter.Update(msg)
+	t.UpdateRows()
+	return cmd
+}
+
+// Closes the filter input and shows all the rows again.
+func (t *Model) clearFilter() {
+	t.filtering = false
+	t.filter.Blur()
+	t.filter.Reset()
+	t.updateSizes()
+	t.UpdateRows()
+}
+
+// Returns true if the filter line is shown above the column headers.
+func (t *Model) showFilter() bool {
+	return t.filtering || t.filterValue() != ""
+}
+
+// Renders the filter input and the number of rows that match.
+func (t *Model) filterView() string {
+	n := 0
+	for _, r := range t.rows {
+		if t.matches(r) {
+			n++
+		}
+	}
+	count := t.theme.Text.Unimportant.Render(fmt.Sprintf("  %d of %d rows", n, len(t.rows)))
+	return t.cellStyle().MaxWidth(t.width).Render(t.filter.View() + count)
+}
@@ -0,0 +1,114 @@
+package table
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+func TestMatchFilter(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.10")}
+	cases := []struct {
+		filter string
+		host   string
+		addr   net.Addr
+		want   bool
+	}{
+		{filter: "example", host: "www.Example.com", want: true},
+		{filter: "EXAMPLE", host: "www.example.com", want: true},
+		{filter: "other", host: "www.example.com"},
+		{filter: "2.10", host: "router", addr: addr, want: true},
+		{filter: "192.0.2.0/24", host: "router", addr: addr, want: true},
+		{filter: "198.51.100.0/24", host: "router", addr: addr},
+		{filter: "192.0.2.0/24", host: "192.0.2.10"},
+	}
+	for _, c := range cases {
+		if got := matchFilter(c.filter, c.host, c.addr); got != c.want {
+			t.Errorf("matchFilter(%q, %q, %v) = %v (want %v)", c.filter, c.host, c.addr, got, c.want)
+		}
+	}
+}
+
+// Types a string into a table.
+func typeKeys(tbl *Model, s string) {
+	for _, r := range s {
+		tbl.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	res := pinger.PingResult{Type: pinger.Success, Latency: time.Millisecond}
+	m.Feed(targets.Key{Group: "a.example"}, &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}, 0, res)
+	m.Feed(targets.Key{Group: "b.example"}, &net.UDPAddr{IP: net.ParseIP("198.51.100.1")}, 0, res)
+	m.Feed(targets.Key{Group: "203.0.113.9", Index: 1}, &net.UDPAddr{IP: net.ParseIP("192.0.2.254")}, 0, res)
+	m.Feed(targets.Key{Group: "203.0.113.9", Index: 2}, &net.UDPAddr{IP: net.ParseIP("203.0.113.9")}, 0, res)
+	tbl := New(theme.Builtin()[0])
+	for _, tg := range m.Targets() {
+		host := tg.Key.Group
+		if tg.Key.Index > 0 {
+			host = tg.Addr.String()
+		}
+		tbl.AddRow(Row{RowKey: tg.Key, DisplayHost: host, Addr: tg.Addr, Pinger: tg.Pinger})
+	}
+	tbl.SetLabel(targets.Key{Group: "a.example"}, "Office router")
+	tbl.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+
+	lineKeys := func() []RowKey {
+		var keys []RowKey
+		for _, r := range tbl.lines {
+			keys = append(keys, r.RowKey)
+		}
+		return keys
+	}
+	cases := []struct {
+		filter string
+		want   []RowKey
+		count  string
+	}{
+		{
+			filter: "b.ex",
+			want:   []RowKey{{Group: "b.example"}},
+			count:  "1 of 4 rows",
+		},
+		{
+			filter: "office",
+			want:   []RowKey{{Group: "a.example"}},
+			count:  "1 of 4 rows",
+		},
+		{
+			filter: "192.0.2.0/24",
+			want:   []RowKey{{Group: "203.0.113.9", Index: headerIndex}, {Group: "203.0.113.9", Index: 1}, {Group: "a.example"}},
+			count:  "2 of 4 rows",
+		},
+		{
+			filter: "203.0.113",
+			want:   []RowKey{{Group: "203.0.113.9", Index: headerIndex}, {Group: "203.0.113.9", Index: 1}, {Group: "203.0.113.9", Index: 2}},
+			count:  "2 of 4 rows",
+		},
+	}
+	for _, c := range cases {
+		tbl.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+		typeKeys(tbl, c.filter)
+		tbl.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		if diff := cmp.Diff(c.want, lineKeys()); diff != "" {
+			t.Errorf("Filter %q wrong lines (-want, +got):\n%v", c.filter, diff)
+		}
+		if header := ansi.Strip(tbl.headerView()); !strings.Contains(header, c.count) {
+			t.Errorf("Filter %q header = %q (want it to contain %q)", c.filter, header, c.count)
+		}
+		tbl.Update(tea.KeyMsg{Type: tea.KeyEsc})
+		if len(tbl.lines) != 5 {
+			t.Errorf("After clearing filter %q, %d lines (want 5)", c.filter, len(tbl.lines))
+		}
+	}
+}
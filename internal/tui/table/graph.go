@@ -0,0 +1,140 @@
+package table
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+)
+
+// GraphStyle is the set of characters used to draw the latency graph.
+type GraphStyle int
+
+// GraphStyle values.
+const (
+	// GraphBars draws one sample per character as a vertical bar.
+	GraphBars GraphStyle = iota
+
+	// GraphBraille packs two samples per character as columns of braille
+	// dots.
+	GraphBraille
+
+	// GraphQuadrant packs two samples per character as columns of quadrant
+	// blocks.
+	GraphQuadrant
+
+	numGraphStyles
+)
+
+var (
+	bars = []string{"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
+
+	// Bars for terminals limited to ASCII, from the bottom up as best they
+	// can be.
+	asciiBars = []string{"_", ".", "-", "~", "=", "+", "*", "#"}
+
+	// Braille dot bits for the left and right columns, from the bottom up.
+	brailleDots = [2][]rune{
+		{0x40, 0x04, 0x02, 0x01},
+		{0x80, 0x20, 0x10, 0x08},
+	}
+
+	// Quadrant bits for the left and right columns, from the bottom up.
+	quadrantBits = [2][]int{
+		{4, 1},
+		{8, 2},
+	}
+
+	// Quadrant blocks indexed by upper left (1), upper right (2), lower left
+	// (4) and lower right (8) bits.
+	quadrants = []string{
+		" ", "▘", "▝", "▀", "▖", "▌", "▞", "▛",
+		"▗", "▚", "▐", "▜", "▄", "▙", "▟", "█",
+	}
+)
+
+// ParseGraphStyle parses a graph style name as returned by
+// [GraphStyle.String].
+func ParseGraphStyle(s string) (GraphStyle, error) {
+	for g := range numGraphStyles {
+		if g.String() == s {
+			return g, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown graph style %q", s)
+}
+
+func (g GraphStyle) String() string {
+	switch g {
+	case GraphBars:
+		return "bars"
+	case GraphBraille:
+		return "braille"
+	case GraphQuadrant:
+		return "quadrant"
+	default:
+		return fmt.Sprintf("(unknown:%d)", g)
+	}
+}
+
+// next returns the next graph style, wrapping around after the last one.
+func (g GraphStyle) next() GraphStyle {
+	return (g + 1) % numGraphStyles
+}
+
+// samplesPerCell returns the number of samples drawn in each character cell.
+func (g GraphStyle) samplesPerCell() int {
+	if g == GraphBars {
+		return 1
+	}
+	return 2
+}
+
+// cellWidth returns the number of terminal columns taken by each glyph.
+func (g GraphStyle) cellWidth() int {
+	return max(1, ansi.StringWidth(g.glyph([]float64{1, 1})))
+}
+
+// glyph returns the character for up to samplesPerCell fractions of the
+// graph height, newest first. The newest goes in the right column. Negative
+// fractions leave their column empty.
+func (g GraphStyle) glyph(fracs []float64) string {
+	switch g {
+	case GraphBraille:
+		r := rune(0x2800)
+		for i, f := range fracs {
+			dots := brailleDots[1-i]
+			for _, d := range dots[:level(f, len(dots))] {
+				r |= d
+			}
+		}
+		return string(r)
+	case GraphQuadrant:
+		var q int
+		for i, f := range fracs {
+			bits := quadrantBits[1-i]
+			for _, b := range bits[:level(f, len(bits))] {
+				q |= b
+			}
+		}
+		return quadrants[q]
+	default:
+		if fracs[0] < 0 {
+			return " "
+		}
+		b := bars
+		if termcap.Current().ASCII {
+			b = asciiBars
+		}
+		return b[int(fracs[0]*float64(len(b)-1))]
+	}
+}
+
+// Returns the number of levels out of n to fill for a fraction of the graph
+// height. Any latency fills at least one.
+func level(frac float64, n int) int {
+	if frac < 0 {
+		return 0
+	}
+	return 1 + min(int(frac*float64(n)), n-1)
+}
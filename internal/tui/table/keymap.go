@@ -27,10 +27,82 @@ var defaultKeyMap = keyMap{
 		key.WithKeys("end", "G"),
 		key.WithHelp("G/end", "go to end"),
 	),
+	Add: key.NewBinding(
+		key.WithKeys("a"),
+		key.WithHelp("a", "add host"),
+	),
+	Remove: key.NewBinding(
+		key.WithKeys("d", "delete"),
+		key.WithHelp("d/del", "remove row"),
+	),
+	Pause: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "pause/resume row"),
+	),
+	Detail: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "row details"),
+	),
+	CopyAddr: key.NewBinding(
+		key.WithKeys("y"),
+		key.WithHelp("y", "copy address"),
+	),
+	CopyHost: key.NewBinding(
+		key.WithKeys("Y"),
+		key.WithHelp("Y", "copy host name"),
+	),
+	Lookup: key.NewBinding(
+		key.WithKeys("o"),
+		key.WithHelp("o", "look up address in browser"),
+	),
+	Label: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "edit label"),
+	),
+	Collapse: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "collapse/expand path"),
+	),
 	Sort: key.NewBinding(
 		key.WithKeys("s"),
 		key.WithHelp("s", "sorting"),
 	),
+	Columns: key.NewBinding(
+		key.WithKeys("v"),
+		key.WithHelp("v", "columns"),
+	),
+	Filter: key.NewBinding(
+		key.WithKeys("/"),
+		key.WithHelp("/", "filter"),
+	),
+	ClearFilter: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "clear filter"),
+	),
+	Scale: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("c", "cycle graph scale"),
+	),
+	GraphStyle: key.NewBinding(
+		key.WithKeys("b"),
+		key.WithHelp("b", "cycle graph style"),
+	),
+	Window: key.NewBinding(
+		key.WithKeys("w"),
+		key.WithHelp("w", "cycle stats window"),
+	),
+	Hosts: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "cycle names/addresses"),
+	),
+	Log: key.NewBinding(
+		key.WithKeys("L"),
+		key.WithHelp("L", "log"),
+	),
+	Theme: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "cycle theme"),
+	),
 	Quit: key.NewBinding(
 		key.WithKeys("q"),
 		key.WithHelp("q", "quit"),
@@ -43,21 +115,39 @@ var defaultKeyMap = keyMap{
 }
 
 type keyMap struct {
-	Up   key.Binding
-	Down key.Binding
-	PgUp key.Binding
-	PgDn key.Binding
-	Home key.Binding
-	End  key.Binding
-	Sort key.Binding
-	Quit key.Binding
-	Help key.Binding
+	Up          key.Binding
+	Down        key.Binding
+	PgUp        key.Binding
+	PgDn        key.Binding
+	Home        key.Binding
+	End         key.Binding
+	Add         key.Binding
+	Remove      key.Binding
+	Pause       key.Binding
+	Detail      key.Binding
+	CopyAddr    key.Binding
+	CopyHost    key.Binding
+	Lookup      key.Binding
+	Label       key.Binding
+	Collapse    key.Binding
+	Sort        key.Binding
+	Columns     key.Binding
+	Filter      key.Binding
+	ClearFilter key.Binding
+	Scale       key.Binding
+	GraphStyle  key.Binding
+	Window      key.Binding
+	Hosts       key.Binding
+	Log         key.Binding
+	Theme       key.Binding
+	Quit        key.Binding
+	Help        key.Binding
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{
 		{k.Up, k.Down, k.PgUp, k.PgDn, k.Home, k.End},
-		{k.Sort, k.Help, k.Quit},
+		{k.Add, k.Remove, k.Pause, k.Detail, k.CopyAddr, k.CopyHost, k.Lookup, k.Label, k.Collapse, k.Sort, k.Columns, k.Filter, k.ClearFilter, k.Scale, k.GraphStyle, k.Window, k.Hosts, k.Log, k.Theme, k.Help, k.Quit},
 	}
 }
 
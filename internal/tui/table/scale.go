@@ -0,0 +1,102 @@
+package table
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+// DefaultGraphMax is the default latency at which a ping displays at maximum
+// height in the results graph.
+const DefaultGraphMax = 250 * time.Millisecond
+
+// Scale is a scaling mode for the latency graph.
+type Scale int
+
+// Scale values.
+const (
+	// ScaleLinear scales linearly from zero to the global graph max.
+	ScaleLinear Scale = iota
+
+	// ScaleLog scales logarithmically from zero to the global graph max.
+	ScaleLog
+
+	// ScaleAuto scales each row linearly from zero to that row's own maximum
+	// latency.
+	ScaleAuto
+
+	numScales
+)
+
+// ParseScale parses a scale name as returned by [Scale.String].
+func ParseScale(s string) (Scale, error) {
+	for sc := range numScales {
+		if sc.String() == s {
+			return sc, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scale %q", s)
+}
+
+func (s Scale) String() string {
+	switch s {
+	case ScaleLinear:
+		return "linear"
+	case ScaleLog:
+		return "log"
+	case ScaleAuto:
+		return "auto"
+	default:
+		return fmt.Sprintf("(unknown:%d)", s)
+	}
+}
+
+// next returns the next scale mode, wrapping around after the last one.
+func (s Scale) next() Scale {
+	return (s + 1) % numScales
+}
+
+// scaler maps latencies to the range [0, 1] for graphing.
+type scaler struct {
+	scale Scale
+	max   time.Duration
+}
+
+// forRow returns a scaler adjusted for a row's results. Only [ScaleAuto]
+// depends on the results.
+func (s scaler) forRow(res []pinger.PingResult) scaler {
+	if s.scale != ScaleAuto {
+		return s
+	}
+	var rowMax time.Duration
+	for _, r := range res {
+		if r.Type == pinger.Success {
+			rowMax = max(rowMax, r.Latency)
+		}
+	}
+	// Avoid dividing by zero, and keep sub-millisecond noise on very fast
+	// hosts from filling the graph.
+	s.max = max(rowMax, time.Millisecond)
+	return s
+}
+
+// Frac returns the fraction of the maximum graph height for a latency.
+func (s scaler) Frac(d time.Duration) float64 {
+	var frac float64
+	switch s.scale {
+	case ScaleLog:
+		// Log base is 1ms, so latencies under that are close to zero.
+		ms := float64(time.Millisecond)
+		frac = math.Log1p(float64(d)/ms) / math.Log1p(float64(s.max)/ms)
+	default:
+		frac = float64(d) / float64(s.max)
+	}
+	// A max of zero or less makes no sense, and NaN would pass through
+	// Max and Min.
+	if math.IsNaN(frac) {
+		return 0
+	}
+	return math.Max(0, math.Min(1, frac))
+}
@@ -0,0 +1,74 @@
+package table
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pcekm/vasily/internal/pinger"
+)
+
+func TestParseScale(t *testing.T) {
+	for sc := range numScales {
+		got, err := ParseScale(sc.String())
+		if err != nil || got != sc {
+			t.Errorf("ParseScale(%q) = %v, %v (want %v, nil)", sc.String(), got, err, sc)
+		}
+	}
+	if _, err := ParseScale("cubic"); err == nil {
+		t.Errorf("ParseScale(\"cubic\") succeeded")
+	}
+}
+
+func TestScaler_Frac(t *testing.T) {
+	cases := []struct {
+		name string
+		s    scaler
+		d    time.Duration
+		want float64
+	}{
+		{name: "LinearZero", s: scaler{ScaleLinear, 100 * time.Millisecond}, d: 0, want: 0},
+		{name: "LinearHalf", s: scaler{ScaleLinear, 100 * time.Millisecond}, d: 50 * time.Millisecond, want: 0.5},
+		{name: "LinearOver", s: scaler{ScaleLinear, 100 * time.Millisecond}, d: time.Second, want: 1},
+		{name: "LinearNegative", s: scaler{ScaleLinear, 100 * time.Millisecond}, d: -time.Millisecond, want: 0},
+		{name: "LinearZeroMax", s: scaler{ScaleLinear, 0}, d: 0, want: 0},
+		{name: "LogMax", s: scaler{ScaleLog, 100 * time.Millisecond}, d: 100 * time.Millisecond, want: 1},
+		{name: "LogZero", s: scaler{ScaleLog, 100 * time.Millisecond}, d: 0, want: 0},
+		{name: "LogNegativeMax", s: scaler{ScaleLog, -time.Second}, d: 10 * time.Millisecond, want: 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.s.Frac(c.d); got != c.want {
+				t.Errorf("Frac(%v) = %v (want %v)", c.d, got, c.want)
+			}
+		})
+	}
+}
+
+func TestScaler_FracLogMidpoint(t *testing.T) {
+	s := scaler{ScaleLog, 100 * time.Millisecond}
+	// Logarithmic scaling puts 10ms well above the linear 0.1.
+	if got := s.Frac(10 * time.Millisecond); got <= 0.1 || got >= 1 {
+		t.Errorf("Frac(10ms) = %v (want between 0.1 and 1)", got)
+	}
+}
+
+func TestScaler_ForRow(t *testing.T) {
+	res := []pinger.PingResult{
+		{Type: pinger.Success, Latency: 20 * time.Millisecond},
+		{Type: pinger.Success, Latency: 40 * time.Millisecond},
+		{Type: pinger.Dropped, Latency: time.Second},
+	}
+	s := scaler{ScaleAuto, DefaultGraphMax}.forRow(res)
+	if s.max != 40*time.Millisecond {
+		t.Errorf("Auto max = %v (want 40ms)", s.max)
+	}
+	if got := s.Frac(20 * time.Millisecond); got != 0.5 {
+		t.Errorf("Frac(20ms) = %v (want 0.5)", got)
+	}
+	if s := (scaler{ScaleAuto, DefaultGraphMax}).forRow(nil); s.max != time.Millisecond {
+		t.Errorf("Auto max with no results = %v (want 1ms)", s.max)
+	}
+	if s := (scaler{ScaleLinear, DefaultGraphMax}).forRow(res); s.max != DefaultGraphMax {
+		t.Errorf("Linear max = %v (want %v)", s.max, DefaultGraphMax)
+	}
+}
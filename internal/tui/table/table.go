@@ -8,30 +8,46 @@ import (
 	"io"
 	"log"
 	"math"
+	"net"
 	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/baseline"
 	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
 	"github.com/pcekm/vasily/internal/tui/help"
 	"github.com/pcekm/vasily/internal/tui/nav"
+	"github.com/pcekm/vasily/internal/tui/termcap"
 	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
 
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 const (
 	// Minimum width for columns determined fractionally.
 	minColWidth = 10
 
-	// Duration at which a ping latency displays at maximum height.
-	graphMax = 250 * time.Millisecond
+	// Columns with priorities below this are dropped before the host moves
+	// to a line of its own in a narrow terminal.
+	stackPriority = 5
 
 	horizontalPadding = 1
+
+	// Number of lines scrolled by each turn of the mouse wheel.
+	mouseWheelLines = 3
+
+	// The index given to group header lines.
+	headerIndex = -1
 )
 
 var (
@@ -40,7 +56,7 @@ var (
 		{ColumnID: ColHost},
 	}
 
-	availSortColumns = []ColumnID{ColIndex, ColHost, ColAvgMs, ColJitter, ColPctLoss}
+	availSortColumns = []ColumnID{ColIndex, ColHost, ColLabel, ColASN, ColLast, ColAvgMs, ColMinMs, ColMaxMs, ColP95, ColJitter, ColStdDev, ColPctLoss, ColPctDup, ColHops, ColPathMTU, ColDelta}
 )
 
 // SortColumn identifies a column to sort by.
@@ -52,14 +68,25 @@ type SortColumn struct {
 // ColumnID identifies a column.
 type ColumnID int
 
-// ColumnID values specified in the order they should appear in the table.
+// ColumnID values specified in the order they appear in the table by default.
 const (
 	ColIndex ColumnID = iota
 	ColHost
+	ColLabel
+	ColASN
 	ColResults
+	ColLast
 	ColAvgMs
+	ColMinMs
+	ColMaxMs
+	ColP95
 	ColJitter
+	ColStdDev
 	ColPctLoss
+	ColPctDup
+	ColHops
+	ColPathMTU
+	ColDelta
 )
 
 func (c ColumnID) String() string {
@@ -68,14 +95,36 @@ func (c ColumnID) String() string {
 		return "ColIndex"
 	case ColHost:
 		return "ColHost"
+	case ColLabel:
+		return "ColLabel"
+	case ColASN:
+		return "ColASN"
 	case ColResults:
 		return "ColResults"
+	case ColLast:
+		return "ColLast"
 	case ColAvgMs:
 		return "ColAvgMs"
+	case ColMinMs:
+		return "ColMinMs"
+	case ColMaxMs:
+		return "ColMaxMs"
+	case ColP95:
+		return "ColP95"
 	case ColJitter:
 		return "ColJitter"
+	case ColStdDev:
+		return "ColStdDev"
 	case ColPctLoss:
 		return "ColPctLoss"
+	case ColPctDup:
+		return "ColPctDup"
+	case ColHops:
+		return "ColHops"
+	case ColPathMTU:
+		return "ColPathMTU"
+	case ColDelta:
+		return "ColDelta"
 	default:
 		return fmt.Sprintf("(unknown:%d)", c)
 	}
@@ -87,6 +136,39 @@ func (c ColumnID) Display() string {
 	return strings.TrimSpace(spec.Title)
 }
 
+// ParseColumns parses a list of columns named by their titles, ignoring case.
+func ParseColumns(names []string) ([]ColumnID, error) {
+	var cols []ColumnID
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		i := slices.IndexFunc(columnSpecs, func(c columnSpec) bool { return strings.EqualFold(c.ID.Display(), name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		if slices.Contains(cols, columnSpecs[i].ID) {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		cols = append(cols, columnSpecs[i].ID)
+	}
+	return cols, nil
+}
+
+// ColumnSetting is the visibility of a column.
+type ColumnSetting struct {
+	ColumnID
+	Hidden bool
+}
+
+// DefaultColumns returns the settings of all the columns in their default
+// order, with optional columns hidden.
+func DefaultColumns() []ColumnSetting {
+	cols := make([]ColumnSetting, len(columnSpecs))
+	for i, c := range columnSpecs {
+		cols[i] = ColumnSetting{ColumnID: c.ID, Hidden: c.Optional}
+	}
+	return cols
+}
+
 // AvailColumns are the columns available for sorting.
 func AvailColumns() []ColumnID {
 	return append([]ColumnID{}, availSortColumns...)
@@ -106,25 +188,51 @@ type columnSpec struct {
 	// ProportionalWidth, if nonzero, is the proportion of the available space
 	// this column will use. (Minus the fixed width columns.)
 	ProportionalWidth float64
+
+	// Optional columns are hidden unless explicitly shown.
+	Optional bool
+
+	// Priority decides which columns are dropped when the terminal is too
+	// narrow to show them all. Lower priorities go first. Columns with zero
+	// priority are never dropped.
+	Priority int
 }
 
 var (
 	columnSpecs = []columnSpec{
-		{ID: ColIndex, Title: "Hop", FixedWidth: 3},
+		{ID: ColIndex, Title: "Hop", FixedWidth: 3, Priority: 5},
 		{ID: ColHost, Title: "Host", ProportionalWidth: 2},
+		{ID: ColLabel, Title: "Label", ProportionalWidth: 1, Optional: true, Priority: 1},
+		{ID: ColASN, Title: "AS", ProportionalWidth: 1, Optional: true, Priority: 1},
 		{ID: ColResults, Title: "Results", ProportionalWidth: 3},
-		{ID: ColAvgMs, Title: "AvgMs", FixedWidth: 5},
-		{ID: ColJitter, Title: "Jitter", FixedWidth: 6},
-		{ID: ColPctLoss, Title: " Loss", FixedWidth: 5},
+		{ID: ColLast, Title: " Last", FixedWidth: 5, Optional: true, Priority: 2},
+		{ID: ColAvgMs, Title: "AvgMs", FixedWidth: 5, Priority: 7},
+		{ID: ColMinMs, Title: "MinMs", FixedWidth: 5, Optional: true, Priority: 2},
+		{ID: ColMaxMs, Title: "MaxMs", FixedWidth: 5, Optional: true, Priority: 2},
+		{ID: ColP95, Title: "  P95", FixedWidth: 5, Optional: true, Priority: 3},
+		{ID: ColJitter, Title: "Jitter", FixedWidth: 6, Priority: 4},
+		{ID: ColStdDev, Title: "StdDev", FixedWidth: 6, Optional: true, Priority: 1},
+		{ID: ColPctLoss, Title: " Loss", FixedWidth: 5, Priority: 6},
+		{ID: ColPctDup, Title: "  Dup", FixedWidth: 5, Optional: true, Priority: 2},
+		{ID: ColHops, Title: "Dist", FixedWidth: 4, Optional: true, Priority: 2},
+		{ID: ColPathMTU, Title: " PMTU", FixedWidth: 5, Optional: true, Priority: 2},
+		{ID: ColDelta, Title: "Delta", FixedWidth: 5, Optional: true, Priority: 3},
 	}
 
-	bars     = []string{"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"}
 	statuses = map[pinger.ResultType]string{
 		pinger.Waiting:     " ",
 		pinger.Dropped:     "?",
 		pinger.Duplicate:   "D",
 		pinger.TTLExceeded: "T",
 		pinger.Unreachable: "X",
+		pinger.Gap:         "·",
+		pinger.Late:        "L",
+		pinger.Corrupted:   "C",
+	}
+
+	// Replacements in statuses for terminals limited to ASCII.
+	asciiStatuses = map[pinger.ResultType]string{
+		pinger.Gap: ".",
 	}
 )
 
@@ -135,65 +243,345 @@ type Row struct {
 	// DisplayHost is the hostname or IP address to display.
 	DisplayHost string
 
+	// Addr is the address being pinged.
+	Addr net.Addr
+
+	// Label is the free-text label for this host, or empty if it has none.
+	Label string
+
 	// Pinger is the pinger for this host.
 	Pinger *pinger.Pinger
+
+	// PathMTU is the path MTU to this host, or zero if unknown.
+	PathMTU int
+
+	// ASN describes the autonomous system this host is in, or is empty if
+	// unknown.
+	ASN string
+
+	// Alerting is true while an alert rule for this host is firing.
+	Alerting bool
+
+	// Extensions holds the ICMP extensions last seen from a traced hop, or
+	// nil if there were none.
+	Extensions *backend.Extensions
+
+	// ReturnHops is the estimated length of a traced hop's return path, or
+	// zero if unknown.
+	ReturnHops int
+
+	// Baseline is the saved latency this row is compared against, or nil if
+	// there isn't one.
+	Baseline *baseline.Entry
 }
 
-func (r Row) cells() map[ColumnID]any {
-	st := r.Pinger.Stats()
+// Returns the host as shown. Group headers always show the group.
+func (r Row) host(hosts view.HostDisplay) string {
+	if r.Index == headerIndex {
+		return r.DisplayHost
+	}
+	var addr string
+	if ip := util.IP(r.Addr); ip != nil {
+		addr = ip.String()
+	}
+	return hosts.Format(r.DisplayHost, addr)
+}
+
+func (r Row) cells(window time.Duration, hosts view.HostDisplay) map[ColumnID]any {
+	st := r.Pinger.WindowStats(window)
+	host := r.host(hosts)
+	if r.Pinger.Paused() {
+		host += " (paused)"
+	}
+	var hops any = ""
+	if h, ok := view.HopDistance(r.Pinger); ok {
+		hops = h
+	}
 	return map[ColumnID]any{
 		ColIndex:   r.Index,
-		ColHost:    r.DisplayHost,
+		ColHost:    host,
+		ColLabel:   r.Label,
+		ColASN:     r.ASN,
 		ColResults: r.Pinger,
+		ColLast:    st.LastLatency,
 		ColAvgMs:   st.AvgLatency,
-		ColJitter:  st.StdDev,
+		ColMinMs:   st.MinLatency,
+		ColMaxMs:   st.MaxLatency,
+		ColP95:     st.P95,
+		ColJitter:  st.Jitter,
+		ColStdDev:  st.StdDev,
 		ColPctLoss: 100 * st.PacketLoss(),
+		ColPctDup:  100 * st.DuplicateRate(),
+		ColHops:    hops,
+		ColPathMTU: r.PathMTU,
+		ColDelta:   "",
 	}
 }
 
-func (r Row) sortKeys() map[ColumnID]any {
-	st := r.Pinger.Stats()
+func (r Row) sortKeys(window time.Duration, hosts view.HostDisplay) map[ColumnID]any {
+	st := r.Pinger.WindowStats(window)
+	hops, ok := view.HopDistance(r.Pinger)
+	if !ok {
+		hops = -1
+	}
+	// Baselines are compared with the cumulative stats.
+	var delta time.Duration
+	if c, ok := baseline.Compare(r.Baseline, r.Pinger.Stats(), 0); ok {
+		delta = c.AvgDelta
+	}
 	return map[ColumnID]any{
 		ColIndex: r.Index,
-		ColHost:  r.DisplayHost,
+		ColHost:  r.host(hosts),
+		ColLabel: r.Label,
+		ColASN:   r.ASN,
 		// Not sortable:
 		// ColResults: r.Pinger,
+		ColLast:    st.LastLatency,
 		ColAvgMs:   st.AvgLatency,
-		ColJitter:  st.StdDev,
+		ColMinMs:   st.MinLatency,
+		ColMaxMs:   st.MaxLatency,
+		ColP95:     st.P95,
+		ColJitter:  st.Jitter,
+		ColStdDev:  st.StdDev,
 		ColPctLoss: 100 * st.PacketLoss(),
+		ColPctDup:  100 * st.DuplicateRate(),
+		ColHops:    hops,
+		ColPathMTU: r.PathMTU,
+		ColDelta:   delta,
 	}
 }
 
-// RowKey uniquely identifies a row.
-// TODO: Is this necessary now? Can it be rolled into Row?
-type RowKey struct {
-	// Group is used to group related pings, such as all the hosts in a path.
-	Group string
+// RowKey uniquely identifies a row. It's the key of the row's target.
+type RowKey = targets.Key
+
+// RemoveRowMsg is a request to remove a row and stop its pinger.
+type RemoveRowMsg struct {
+	RowKey
+}
+
+// PauseRowMsg is a request to pause a row's pinger, or resume it if it's
+// already paused.
+type PauseRowMsg struct {
+	RowKey
+}
 
-	// Index is the numeric index of the row.
-	Index int
+// DetailMsg is a request to show the details of a row.
+type DetailMsg struct {
+	RowKey
 }
 
+// CycleThemeMsg is a request to switch to the next theme.
+type CycleThemeMsg struct{}
+
+// CycleHostsMsg is a request to switch to the next way of showing hosts.
+type CycleHostsMsg struct{}
+
 // Model contains the table information.
 type Model struct {
 	theme         *theme.Theme
 	ready         bool
 	width, height int
 	vp            viewport.Model
-	colWidths     []int
+	cols          []columnSpec // In display order.
+	colWidths     []int        // Indexed like cols.
 	rows          []Row
+	lines         []Row
+	selected      RowKey
+	collapsed     map[string]bool
+	unreached     map[string]bool // Traced groups that didn't reach the destination.
 	sortCols      []SortColumn
+	filter        textinput.Model
+	filtering     bool // The filter input is open.
+	hidden        map[ColumnID]bool
+	dropped       map[ColumnID]bool // Columns that don't fit the terminal.
+	stacked       bool              // Hosts are on lines of their own.
+	threshold     float64
+	window        time.Duration // Of the stats shown, or zero for all time.
+	scaler        scaler
+	graphStyle    GraphStyle
+	smoke         bool // Color the graph by the spread of each burst.
+	hosts         view.HostDisplay
 	help          *help.Model
 }
 
 // New makes an empty ping result table with headers.
 func New(theme *theme.Theme) *Model {
-	return &Model{
+	hidden := make(map[ColumnID]bool)
+	for _, c := range DefaultColumns() {
+		hidden[c.ColumnID] = c.Hidden
+	}
+	filter := textinput.New()
+	filter.Prompt = "/"
+	filter.Placeholder = "host, label, address or CIDR prefix"
+	t := &Model{
 		theme:     theme,
+		cols:      slices.Clone(columnSpecs),
 		colWidths: make([]int, len(columnSpecs)),
 		sortCols:  append([]SortColumn{}, defaultSort...),
+		hidden:    hidden,
+		dropped:   make(map[ColumnID]bool),
+		collapsed: make(map[string]bool),
+		unreached: make(map[string]bool),
+		scaler:    scaler{scale: ScaleLinear, max: DefaultGraphMax},
+		threshold: baseline.DefaultThreshold,
+		filter:    filter,
 		help:      help.New(theme, defaultKeyMap),
 	}
+	t.setFilterTheme()
+	return t
+}
+
+// SetColumnVisible shows or hides a column.
+func (t *Model) SetColumnVisible(id ColumnID, visible bool) {
+	t.hidden[id] = !visible
+	t.recalcColumnWidths()
+	t.UpdateRows()
+}
+
+// Columns returns the settings of all the columns in display order, including
+// hidden ones.
+func (t *Model) Columns() []ColumnSetting {
+	cols := make([]ColumnSetting, len(t.cols))
+	for i, c := range t.cols {
+		cols[i] = ColumnSetting{ColumnID: c.ID, Hidden: t.hidden[c.ID]}
+	}
+	return cols
+}
+
+// SetColumns sets the order and visibility of the columns. Columns that
+// aren't in cols are hidden and go after the rest in their default order.
+func (t *Model) SetColumns(cols []ColumnSetting) {
+	var specs []columnSpec
+	for _, c := range cols {
+		i := slices.IndexFunc(columnSpecs, func(s columnSpec) bool { return s.ID == c.ColumnID })
+		if i < 0 || slices.ContainsFunc(specs, func(s columnSpec) bool { return s.ID == c.ColumnID }) {
+			continue
+		}
+		specs = append(specs, columnSpecs[i])
+		t.hidden[c.ColumnID] = c.Hidden
+	}
+	for _, c := range columnSpecs {
+		if !slices.ContainsFunc(specs, func(s columnSpec) bool { return s.ID == c.ID }) {
+			specs = append(specs, c)
+			t.hidden[c.ID] = true
+		}
+	}
+	t.cols = specs
+	t.recalcColumnWidths()
+	t.UpdateRows()
+}
+
+// VisibleColumns returns the columns that aren't hidden, in display order.
+func (t *Model) VisibleColumns() []ColumnID {
+	var cols []ColumnID
+	for _, c := range t.cols {
+		if !t.hidden[c.ID] {
+			cols = append(cols, c.ID)
+		}
+	}
+	return cols
+}
+
+// SetScale sets the latency graph's scaling mode and the latency that
+// displays at full height. The max is ignored by [ScaleAuto].
+func (t *Model) SetScale(s Scale, max time.Duration) {
+	t.scaler = scaler{scale: s, max: max}
+	t.UpdateRows()
+}
+
+// SetStatsWindow sets how far back the statistics shown go. The window must be
+// one of [pinger.StatsWindows], or zero for the cumulative statistics.
+func (t *Model) SetStatsWindow(d time.Duration) {
+	t.window = d
+	t.UpdateRows()
+}
+
+// StatsWindow returns how far back the statistics shown go, or zero if
+// they're cumulative.
+func (t *Model) StatsWindow() time.Duration {
+	return t.window
+}
+
+// Returns the stats window after the current one, going back to cumulative
+// stats after the longest.
+func (t *Model) nextStatsWindow() time.Duration {
+	i := slices.Index(pinger.StatsWindows, t.window)
+	if i+1 < len(pinger.StatsWindows) {
+		return pinger.StatsWindows[i+1]
+	}
+	return 0
+}
+
+// Returns a short name for a stats window, like 5m.
+func windowName(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// SetBaselineThreshold sets the fraction a row's latency must rise above its
+// baseline by to be highlighted as a regression.
+func (t *Model) SetBaselineThreshold(threshold float64) {
+	t.threshold = threshold
+	t.UpdateRows()
+}
+
+// SetGraphStyle sets the characters used to draw the latency graph.
+func (t *Model) SetGraphStyle(g GraphStyle) {
+	t.graphStyle = g
+	t.growHistories()
+	t.UpdateRows()
+}
+
+// GraphStyle returns the characters used to draw the latency graph.
+func (t *Model) GraphStyle() GraphStyle {
+	return t.graphStyle
+}
+
+// SetSmoke sets whether the latency graph is colored by the spread of each
+// burst's replies, like Smokeping, rather than by latency. The bars still
+// show each burst's latency, which should be its median.
+func (t *Model) SetSmoke(smoke bool) {
+	t.smoke = smoke
+	t.UpdateRows()
+}
+
+// SetHostDisplay sets whether hosts are shown by name, address or both.
+func (t *Model) SetHostDisplay(d view.HostDisplay) {
+	t.hosts = d
+	t.UpdateRows()
+}
+
+// Returns the graph style to draw with. Only bars can be drawn in ASCII.
+func (t *Model) drawnGraphStyle() GraphStyle {
+	if termcap.Current().ASCII {
+		return GraphBars
+	}
+	return t.graphStyle
+}
+
+// SetTheme changes the theme.
+func (t *Model) SetTheme(theme *theme.Theme) {
+	t.theme = theme
+	t.help.SetTheme(theme)
+	t.setFilterTheme()
+	t.UpdateRows()
+}
+
+func (t *Model) setFilterTheme() {
+	t.filter.PromptStyle = t.theme.Text.Important
+	t.filter.TextStyle = t.theme.Text.Normal
+	t.filter.PlaceholderStyle = t.theme.Text.Unimportant
+}
+
+// Scale returns the latency graph's scaling mode.
+func (t *Model) Scale() Scale {
+	return t.scaler.scale
 }
 
 func (t *Model) Update(msg tea.Msg) tea.Cmd {
@@ -202,8 +590,15 @@ func (t *Model) Update(msg tea.Msg) tea.Cmd {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		cmd = t.handleKeyMsg(msg)
+	case tea.MouseMsg:
+		t.handleMouseMsg(msg)
 	case tea.WindowSizeMsg:
 		cmd = t.handleWindowSizeMsg(msg)
+	default:
+		// Keeps the cursor blinking.
+		if t.filtering {
+			t.filter, cmd = t.filter.Update(msg)
+		}
 	}
 
 	var vpCmd tea.Cmd
@@ -212,30 +607,62 @@ func (t *Model) Update(msg tea.Msg) tea.Cmd {
 }
 
 func (t *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	if t.filtering {
+		return t.handleFilterKeyMsg(msg)
+	}
+
 	// Reset full help display after any keypress.
 	origHelp := t.help.FullHelp()
 	t.help.SetFullHelp(false)
 	t.updateSizes()
 
+	if cmd, ok := t.rowAction(msg); ok {
+		return cmd
+	}
+
 	var cmd tea.Cmd
 	switch {
 	case key.Matches(msg, defaultKeyMap.Sort):
 		cmd = nav.Go(nav.SortSelect)
+	case key.Matches(msg, defaultKeyMap.Columns):
+		cmd = nav.Go(nav.ColumnSelect)
+	case key.Matches(msg, defaultKeyMap.Log):
+		cmd = nav.Go(nav.Log)
+	case key.Matches(msg, defaultKeyMap.Filter):
+		cmd = t.startFilter()
+	case key.Matches(msg, defaultKeyMap.ClearFilter):
+		if t.filterValue() != "" {
+			t.clearFilter()
+		}
 	case key.Matches(msg, defaultKeyMap.Help):
 		t.help.SetFullHelp(!origHelp)
 		t.updateSizes()
 	case key.Matches(msg, defaultKeyMap.Up):
-		t.vp.LineUp(1)
+		t.moveSelection(-1)
 	case key.Matches(msg, defaultKeyMap.Down):
-		t.vp.LineDown(1)
+		t.moveSelection(1)
 	case key.Matches(msg, defaultKeyMap.PgUp):
-		t.vp.LineUp(t.vp.VisibleLineCount())
+		t.moveSelection(-t.pageSize())
 	case key.Matches(msg, defaultKeyMap.PgDn):
-		t.vp.LineDown(t.vp.VisibleLineCount())
+		t.moveSelection(t.pageSize())
 	case key.Matches(msg, defaultKeyMap.Home):
-		t.vp.GotoTop()
+		t.moveSelection(-len(t.rows))
 	case key.Matches(msg, defaultKeyMap.End):
-		t.vp.GotoBottom()
+		t.moveSelection(len(t.rows))
+	case key.Matches(msg, defaultKeyMap.Scale):
+		t.SetScale(t.scaler.scale.next(), t.scaler.max)
+	case key.Matches(msg, defaultKeyMap.GraphStyle):
+		t.SetGraphStyle(t.graphStyle.next())
+	case key.Matches(msg, defaultKeyMap.Window):
+		t.SetStatsWindow(t.nextStatsWindow())
+	case key.Matches(msg, defaultKeyMap.Theme):
+		cmd = func() tea.Msg { return CycleThemeMsg{} }
+	case key.Matches(msg, defaultKeyMap.Hosts):
+		cmd = func() tea.Msg { return CycleHostsMsg{} }
+	case key.Matches(msg, defaultKeyMap.Add):
+		cmd = nav.Go(nav.AddHost)
+	case key.Matches(msg, defaultKeyMap.Collapse):
+		t.toggleCollapsed()
 	case key.Matches(msg, defaultKeyMap.Quit):
 		cmd = tea.Quit
 	}
@@ -243,6 +670,20 @@ func (t *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 	return cmd
 }
 
+func (t *Model) handleMouseMsg(msg tea.MouseMsg) {
+	if msg.Action != tea.MouseActionPress {
+		return
+	}
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		t.scroll(-mouseWheelLines)
+	case tea.MouseButtonWheelDown:
+		t.scroll(mouseWheelLines)
+	case tea.MouseButtonLeft:
+		t.selectLine(msg.Y - lipgloss.Height(t.headerView()))
+	}
+}
+
 func (t *Model) handleWindowSizeMsg(msg tea.WindowSizeMsg) tea.Cmd {
 	t.width, t.height = msg.Width, msg.Height
 	t.updateSizes()
@@ -253,14 +694,25 @@ func (t *Model) updateSizes() {
 	t.help.SetWidth(t.width)
 	hh := t.help.GetHeight()
 	if !t.ready {
-		t.vp = viewport.New(t.width, t.height-hh-1)
+		t.vp = viewport.New(t.width, max(0, t.height-hh-t.headerHeight()))
+		// Keys are handled in handleKeyMsg, and the mouse in handleMouseMsg.
+		t.vp.KeyMap = viewport.KeyMap{}
+		t.vp.MouseWheelEnabled = false
 		t.ready = true
 	}
 	t.vp.Width = t.width
-	t.vp.Height = t.height - hh - 1
+	t.vp.Height = max(0, t.height-hh-t.headerHeight())
 	t.recalcColumnWidths()
 }
 
+// Returns the number of lines above the rows.
+func (t *Model) headerHeight() int {
+	if t.showFilter() {
+		return 2
+	}
+	return 1
+}
+
 // Sort returns the current sort columns.
 func (t *Model) Sort() []SortColumn {
 	return append([]SortColumn{}, t.sortCols...)
@@ -276,6 +728,43 @@ func (t *Model) SetSort(cols ...SortColumn) {
 	t.sortCols = cols
 }
 
+// ParseSort parses a comma-separated list of columns to sort by, such as
+// "loss,-avgms". Columns are named by their titles, ignoring case, and a
+// leading "-" reverses the order. An empty string means the default order.
+func ParseSort(s string) ([]SortColumn, error) {
+	var cols []SortColumn
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		var col SortColumn
+		name = strings.TrimSpace(name)
+		if n, ok := strings.CutPrefix(name, "-"); ok {
+			col.Reverse = true
+			name = n
+		}
+		i := slices.IndexFunc(availSortColumns, func(c ColumnID) bool { return strings.EqualFold(c.Display(), name) })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown sort column %q", name)
+		}
+		col.ColumnID = availSortColumns[i]
+		cols = append(cols, col)
+	}
+	return cols, nil
+}
+
+// FormatSort formats sort columns the way [ParseSort] reads them.
+func FormatSort(cols []SortColumn) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = strings.ToLower(c.Display())
+		if c.Reverse {
+			names[i] = "-" + names[i]
+		}
+	}
+	return strings.Join(names, ",")
+}
+
 func cmpKey(a, b any, reverse bool) (res int) {
 	defer func() {
 		if reverse {
@@ -302,8 +791,8 @@ func cmpKey(a, b any, reverse bool) (res int) {
 
 func (t *Model) cmpRows(a, b Row) int {
 	for _, col := range t.sortCols {
-		keyA := a.sortKeys()[col.ColumnID]
-		keyB := b.sortKeys()[col.ColumnID]
+		keyA := a.sortKeys(t.window, t.hosts)[col.ColumnID]
+		keyB := b.sortKeys(t.window, t.hosts)[col.ColumnID]
 		if res := cmpKey(keyA, keyB, col.Reverse); res != 0 {
 			return res
 		}
@@ -312,9 +801,13 @@ func (t *Model) cmpRows(a, b Row) int {
 }
 
 func (t *Model) recalcColumnWidths() {
+	t.fitColumns()
 	fixedTot := 0
 	propTot := 0.0
-	for _, c := range columnSpecs {
+	for _, c := range t.cols {
+		if !t.shown(c.ID) {
+			continue
+		}
 		fixedTot += t.cellStyle().GetHorizontalPadding()
 		if c.FixedWidth != 0 {
 			fixedTot += c.FixedWidth
@@ -322,9 +815,11 @@ func (t *Model) recalcColumnWidths() {
 			propTot += c.ProportionalWidth
 		}
 	}
-	avail := float64(t.vp.Width - fixedTot)
-	for i, c := range columnSpecs {
-		if c.FixedWidth != 0 {
+	avail := float64(max(0, t.vp.Width-fixedTot))
+	for i, c := range t.cols {
+		if !t.shown(c.ID) {
+			t.colWidths[i] = 0
+		} else if c.FixedWidth != 0 {
 			t.colWidths[i] = c.FixedWidth
 		} else {
 			t.colWidths[i] = int(math.Round(c.ProportionalWidth / propTot * avail))
@@ -332,64 +827,472 @@ func (t *Model) recalcColumnWidths() {
 	}
 }
 
+// Chooses the columns that fit the terminal. Low priority columns are
+// dropped first, then the host moves to a line of its own above the rest of
+// its row, and then more columns are dropped until the row fits or only the
+// results are left.
+func (t *Model) fitColumns() {
+	clear(t.dropped)
+	t.stacked = false
+	var order []columnSpec
+	for _, c := range t.cols {
+		if !t.hidden[c.ID] && c.Priority > 0 {
+			order = append(order, c)
+		}
+	}
+	// Rightmost columns go first among those of equal priority.
+	slices.Reverse(order)
+	slices.SortStableFunc(order, func(a, b columnSpec) int { return cmp.Compare(a.Priority, b.Priority) })
+	for _, c := range order {
+		if t.minWidth() <= t.vp.Width {
+			return
+		}
+		if c.Priority >= stackPriority && !t.stacked && !t.hidden[ColHost] {
+			t.stacked = true
+			if t.minWidth() <= t.vp.Width {
+				return
+			}
+		}
+		t.dropped[c.ID] = true
+	}
+	if t.minWidth() > t.vp.Width && !t.hidden[ColHost] {
+		t.stacked = true
+	}
+}
+
+// Returns the narrowest a line of the table can be with the columns that are
+// currently shown.
+func (t *Model) minWidth() int {
+	w := 0
+	for _, c := range t.cols {
+		if !t.shown(c.ID) {
+			continue
+		}
+		w += t.cellStyle().GetHorizontalPadding()
+		if c.FixedWidth != 0 {
+			w += c.FixedWidth
+		} else {
+			w += minColWidth
+		}
+	}
+	return w
+}
+
+// Returns true if a column is shown on the main line of each row. The host is
+// left out when it's on a line of its own.
+func (t *Model) shown(id ColumnID) bool {
+	return !t.hidden[id] && !t.dropped[id] && !(t.stacked && id == ColHost)
+}
+
+// Returns the number of terminal lines each row takes.
+func (t *Model) rowHeight() int {
+	if t.stacked {
+		return 2
+	}
+	return 1
+}
+
+// Returns the number of rows that fit in the viewport.
+func (t *Model) pageSize() int {
+	return max(1, t.vp.Height/t.rowHeight())
+}
+
 // AddRow adds a new row.
 func (t *Model) AddRow(r Row) {
 	t.rows = append(t.rows, r)
 	t.UpdateRows()
 }
 
+// RemoveRow removes the row with the given key and returns it. Returns false
+// if there is no such row. Stopping the row's pinger is up to the caller.
+func (t *Model) RemoveRow(k RowKey) (Row, bool) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return Row{}, false
+	}
+	r := t.rows[i]
+	t.rows = slices.Delete(t.rows, i, i+1)
+	if k == t.selected && len(t.rows) > 0 {
+		t.selected = t.rows[min(i, len(t.rows)-1)].RowKey
+	}
+	t.UpdateRows()
+	return r, true
+}
+
+// SetPathMTU sets the path MTU for a row. Does nothing if the row doesn't
+// exist.
+func (t *Model) SetPathMTU(k RowKey, mtu int) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return
+	}
+	t.rows[i].PathMTU = mtu
+	t.UpdateRows()
+}
+
+// SetASN sets the autonomous system description for a row.
+func (t *Model) SetASN(k RowKey, asn string) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return
+	}
+	t.rows[i].ASN = asn
+	t.UpdateRows()
+}
+
+// SetLabel sets the label for a row.
+func (t *Model) SetLabel(k RowKey, label string) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 || t.rows[i].Label == label {
+		return
+	}
+	t.rows[i].Label = label
+	t.UpdateRows()
+}
+
+// SetAlerting sets whether a row is highlighted as alerting.
+func (t *Model) SetAlerting(k RowKey, alerting bool) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 || t.rows[i].Alerting == alerting {
+		return
+	}
+	t.rows[i].Alerting = alerting
+	t.UpdateRows()
+}
+
+// SetExtensions sets the ICMP extensions for a row. They aren't shown in the
+// table itself.
+func (t *Model) SetExtensions(k RowKey, ext *backend.Extensions) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return
+	}
+	t.rows[i].Extensions = ext
+}
+
+// SetUnreached sets whether the last pass of a traced group failed to reach
+// its destination, which is shown on the group's header line.
+func (t *Model) SetUnreached(group string, unreached bool) {
+	if t.unreached[group] == unreached {
+		return
+	}
+	if unreached {
+		t.unreached[group] = true
+	} else {
+		delete(t.unreached, group)
+	}
+	t.UpdateRows()
+}
+
+// SetReturnHops sets the estimated return path length for a row. It isn't
+// shown in the table itself.
+func (t *Model) SetReturnHops(k RowKey, n int) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return
+	}
+	t.rows[i].ReturnHops = n
+}
+
+// SetDisplayHosts sets the hostname displayed for every row to the one name
+// returns for it. The rows are only re-sorted once, and only if a name
+// changed.
+func (t *Model) SetDisplayHosts(name func(Row) string) {
+	changed := false
+	for i, r := range t.rows {
+		if n := name(r); n != r.DisplayHost {
+			t.rows[i].DisplayHost = n
+			changed = true
+		}
+	}
+	if changed {
+		t.UpdateRows()
+	}
+}
+
+// Row returns the row with the given key. Returns false if there is no such
+// row.
+func (t *Model) Row(k RowKey) (Row, bool) {
+	i := slices.IndexFunc(t.rows, func(r Row) bool { return r.RowKey == k })
+	if i < 0 {
+		return Row{}, false
+	}
+	return t.rows[i], true
+}
+
+// Rows returns a copy of the table's rows in display order.
+func (t *Model) Rows() []Row {
+	return slices.Clone(t.rows)
+}
+
+// Selected returns the currently selected row. Returns false if the table is
+// empty, or if a group header is selected.
+func (t *Model) Selected() (Row, bool) {
+	i := t.selectedIndex()
+	if i < 0 || t.lines[i].Index == headerIndex {
+		return Row{}, false
+	}
+	return t.lines[i], true
+}
+
+// Returns the index of the selected line in t.lines, or -1 if there are no
+// lines. If the selected row is in a collapsed group, its header is selected
+// instead. Selects the first line if the selected row no longer exists.
+func (t *Model) selectedIndex() int {
+	if len(t.lines) == 0 {
+		return -1
+	}
+	i := slices.IndexFunc(t.lines, func(r Row) bool { return r.RowKey == t.selected })
+	if i < 0 && t.collapsed[t.selected.Group] {
+		i = slices.IndexFunc(t.lines, func(r Row) bool { return r.RowKey == groupKey(t.selected.Group) })
+	}
+	if i < 0 {
+		i = 0
+	}
+	t.selected = t.lines[i].RowKey
+	return i
+}
+
+// Moves the selection up or down by n lines.
+func (t *Model) moveSelection(n int) {
+	i := t.selectedIndex()
+	if i < 0 {
+		return
+	}
+	i = min(max(i+n, 0), len(t.lines)-1)
+	t.selected = t.lines[i].RowKey
+	t.UpdateRows()
+}
+
+// Collapses the selected line's group, or expands it if it's already
+// collapsed. Does nothing for rows that aren't in a group.
+func (t *Model) toggleCollapsed() {
+	i := t.selectedIndex()
+	if i < 0 || t.lines[i].Index == 0 {
+		return
+	}
+	g := t.lines[i].Group
+	t.collapsed[g] = !t.collapsed[g]
+	if t.collapsed[g] {
+		t.selected = groupKey(g)
+	}
+	t.UpdateRows()
+}
+
+// Scrolls the viewport by n lines. The selection moves along if it would
+// otherwise go out of view.
+func (t *Model) scroll(n int) {
+	if n < 0 {
+		t.vp.LineUp(-n)
+	} else {
+		t.vp.LineDown(n)
+	}
+	i := t.selectedIndex()
+	if i < 0 {
+		return
+	}
+	h := t.rowHeight()
+	first := (t.vp.YOffset + h - 1) / h
+	last := (t.vp.YOffset+t.vp.Height)/h - 1
+	i = max(min(max(i, first), last, len(t.lines)-1), 0)
+	t.selected = t.lines[i].RowKey
+	t.UpdateRows()
+}
+
+// Selects the row or group header on the given line of the viewport. Does
+// nothing if there isn't one.
+func (t *Model) selectLine(line int) {
+	i := (t.vp.YOffset + line) / t.rowHeight()
+	if line < 0 || line >= t.vp.Height || i >= len(t.lines) {
+		return
+	}
+	t.selected = t.lines[i].RowKey
+	t.UpdateRows()
+}
+
+// Scrolls the viewport so that line i is visible. The top of the line is
+// kept in view if the viewport is too short for all of it.
+func (t *Model) scrollTo(i int) {
+	h := t.rowHeight()
+	top, bottom := i*h, (i+1)*h-1
+	if bottom >= t.vp.YOffset+t.vp.Height {
+		t.vp.SetYOffset(bottom - t.vp.Height + 1)
+	}
+	if top < t.vp.YOffset {
+		t.vp.SetYOffset(top)
+	}
+}
+
 // UpdateRows updates all of the rows in the table with the latest ping data.
 func (t *Model) UpdateRows() {
+	slices.SortStableFunc(t.rows, t.cmpRows)
+	t.lines = t.layout()
 	if !t.ready {
 		return
 	}
-	slices.SortStableFunc(t.rows, t.cmpRows)
-	lines := make([]string, len(t.rows))
-	for i, r := range t.rows {
+	t.growHistories()
+	sel := t.selectedIndex()
+	lines := make([]string, len(t.lines))
+	for i, r := range t.lines {
 		// Collapse index numbers.
-		if i > 0 && r.Index == t.rows[i-1].Index {
+		if i > 0 && r.Index == t.lines[i-1].Index {
 			r.Index = 0
 		}
-		lines[i] = t.renderRow(r)
+		lines[i] = t.renderRow(r, i == sel)
 	}
 	t.vp.SetContent(strings.Join(lines, "\n"))
+	t.scrollTo(sel)
+}
+
+// Arranges the sorted rows into display lines. The hops of a traced path are
+// gathered under a header line for their group, and hidden if the group is
+// collapsed. Groups are ordered by their headers. Rows that aren't hops stand
+// on their own. Rows that don't pass the filter are left out, along with the
+// headers of groups with none left.
+func (t *Model) layout() []Row {
+	var top []Row
+	groups := make(map[string][]Row)
+	for _, r := range t.rows {
+		if !t.matches(r) {
+			continue
+		}
+		if r.Index == 0 {
+			top = append(top, r)
+			continue
+		}
+		if _, ok := groups[r.Group]; !ok {
+			top = append(top, Row{RowKey: groupKey(r.Group)})
+		}
+		groups[r.Group] = append(groups[r.Group], r)
+	}
+	for i, r := range top {
+		if r.Index == headerIndex {
+			top[i] = groupHeader(r.Group, groups[r.Group])
+		}
+	}
+	slices.SortStableFunc(top, t.cmpRows)
+
+	lines := make([]Row, 0, len(t.rows)+len(groups))
+	for _, r := range top {
+		lines = append(lines, r)
+		if r.Index == headerIndex && !t.collapsed[r.Group] {
+			lines = append(lines, groups[r.Group]...)
+		}
+	}
+	return lines
+}
+
+// Returns the key of a group's header line.
+func groupKey(group string) RowKey {
+	return RowKey{Group: group, Index: headerIndex}
+}
+
+// Makes the header line for a group of hops. Its stats are those of the last
+// hop, which is the end-to-end loss and latency once the trace reaches the
+// destination.
+func groupHeader(group string, hops []Row) Row {
+	last := slices.MaxFunc(hops, func(a, b Row) int { return cmp.Compare(a.Index, b.Index) })
+	return Row{
+		RowKey:      groupKey(group),
+		DisplayHost: group,
+		Addr:        last.Addr,
+		Label:       last.Label,
+		Pinger:      last.Pinger,
+		PathMTU:     last.PathMTU,
+		Baseline:    last.Baseline,
+		Alerting:    slices.ContainsFunc(hops, func(r Row) bool { return r.Alerting }),
+	}
+}
+
+// Grows the history of each row's pinger to fill the results column, for
+// example after the terminal gets wider. Histories never shrink here, so that
+// narrowing the terminal doesn't lose anything.
+func (t *Model) growHistories() {
+	i := slices.IndexFunc(t.cols, func(c columnSpec) bool { return c.ID == ColResults })
+	g := t.drawnGraphStyle()
+	size := t.colWidths[i] / g.cellWidth() * g.samplesPerCell()
+	for _, r := range t.rows {
+		if r.Pinger != nil && r.Pinger.HistorySize() < size {
+			r.Pinger.SetHistorySize(size)
+		}
+	}
 }
 
 // Left-pads s out to i spaces. Enough spaces will be added to the left of s to make
-// it at least length i.
+// it at least length i. Lengths are in terminal columns, so wide runes count
+// twice. Longer strings are truncated, and nothing fits in a width of zero or
+// less.
 func lpad(i int, s string) string {
-	n := i - len(s)
+	if i <= 0 {
+		return ""
+	}
+	n := i - ansi.StringWidth(s)
 	if n < 0 {
-		return s[:i-1] + "…"
+		return ansi.Truncate(s, i, termcap.Ellipsis())
 	}
 	return strings.Repeat(" ", n) + s
 }
 
 // Right-pads s out to i spaces. Enough spaces will be added to the left of s to make
-// it at least length i.
+// it at least length i. Truncates like [lpad].
 func rpad(i int, s string) string {
-	n := i - len(s)
+	if i <= 0 {
+		return ""
+	}
+	n := i - ansi.StringWidth(s)
 	if n < 0 {
-		return s[:i-1] + "…"
+		return ansi.Truncate(s, i, termcap.Ellipsis())
 	}
 	return s + strings.Repeat(" ", n)
 }
 
-func (t *Model) renderRow(r Row) string {
-	cells := r.cells()
+func (t *Model) renderRow(r Row, selected bool) string {
+	style := t.cellStyle()
+	switch {
+	case selected && r.Alerting:
+		style = t.alertStyle().Bold(true)
+	case selected:
+		style = t.selectedStyle()
+	case r.Alerting:
+		style = t.alertStyle()
+	}
+	cells := r.cells(t.window, t.hosts)
+	if r.Index == headerIndex {
+		style = style.Bold(true)
+		cells[ColIndex] = 0
+		marker := termcap.Glyph("▾", "v")
+		if t.collapsed[r.Group] {
+			marker = termcap.Glyph("▸", ">")
+		}
+		cells[ColHost] = fmt.Sprintf("%s %s", marker, cells[ColHost])
+		if t.unreached[r.Group] {
+			cells[ColHost] = cells[ColHost].(string) + " (not reached)"
+		}
+	}
 	var sb strings.Builder
-	for i, c := range columnSpecs {
-		// A special case for zero index numbers.
-		if c.ID == ColIndex && cells[c.ID] == 0 {
-			t.renderCell("", t.colWidths[i], &sb)
+	if t.stacked {
+		t.renderCell(cells[ColHost], max(0, t.vp.Width-style.GetHorizontalPadding()), style, &sb)
+		sb.WriteString("\n")
+	}
+	for i, c := range t.cols {
+		if !t.shown(c.ID) {
 			continue
 		}
-		t.renderCell(cells[c.ID], t.colWidths[i], &sb)
+		// A special case for zero index numbers and unknown MTUs.
+		if (c.ID == ColIndex || c.ID == ColPathMTU) && cells[c.ID] == 0 {
+			t.renderCell("", t.colWidths[i], style, &sb)
+			continue
+		}
+		if c.ID == ColDelta {
+			t.renderDelta(r, t.colWidths[i], style, &sb)
+			continue
+		}
+		t.renderCell(cells[c.ID], t.colWidths[i], style, &sb)
 	}
 	return sb.String()
 }
 
-func (t *Model) renderCell(v any, width int, out io.StringWriter) {
+func (t *Model) renderCell(v any, width int, style lipgloss.Style, out io.StringWriter) {
 	var s string
 	switch v := v.(type) {
 	case string:
@@ -403,39 +1306,119 @@ func (t *Model) renderCell(v any, width int, out io.StringWriter) {
 	case *pinger.Pinger:
 		s = t.renderLatencies(width, v)
 	}
-	out.WriteString(t.cellStyle().Width(width + t.cellStyle().GetHorizontalPadding()).Render(s))
+	out.WriteString(style.Width(width + style.GetHorizontalPadding()).Render(s))
+}
+
+// Renders the change in a row's average latency since its baseline, in
+// milliseconds. Regressions are shown in the error color.
+func (t *Model) renderDelta(r Row, width int, style lipgloss.Style, out io.StringWriter) {
+	c, ok := baseline.Compare(r.Baseline, r.Pinger.Stats(), t.threshold)
+	if !ok {
+		t.renderCell("", width, style, out)
+		return
+	}
+	if c.Regressed && !r.Alerting {
+		style = style.Foreground(t.theme.Colors.Error)
+	}
+	s := lpad(width, fmt.Sprintf("%+d", c.AvgDelta.Milliseconds()))
+	out.WriteString(style.Width(width + style.GetHorizontalPadding()).Render(s))
 }
 
 func (t *Model) renderLatencies(width int, p *pinger.Pinger) string {
-	chars := slices.Repeat([]string{" "}, width)
-	i := 0
+	perCell := t.drawnGraphStyle().samplesPerCell()
+	cellWidth := t.drawnGraphStyle().cellWidth()
+	nCells := width / cellWidth
+	if nCells <= 0 {
+		return strings.Repeat(" ", max(0, width))
+	}
+	res := make([]pinger.PingResult, 0, nCells*perCell)
 	for _, r := range p.RevResults() {
-		frac := math.Min(1, float64(r.Latency)/float64(graphMax))
-		barIdx := int(frac * float64(len(bars)-1))
-		c := t.theme.Text.Normal.
-			Foreground(t.theme.Heatmap.At(frac)).
-			Render(bars[barIdx])
-		if r.Type != pinger.Success {
-			c = statuses[r.Type]
-			if r.Type != pinger.Waiting {
-				c = t.errStyle().Render(c)
-			}
-		}
-		charIdx := width - i - 1
-		if charIdx < 0 {
+		if len(res) == cap(res) {
 			break
 		}
-		chars[charIdx] = c
-		i++
+		res = append(res, r)
+	}
+	sc := t.scaler.forRow(res)
+	cells := slices.Repeat([]string{strings.Repeat(" ", cellWidth)}, nCells)
+	for i := 0; i*perCell < len(res); i++ {
+		samples := res[i*perCell : min((i+1)*perCell, len(res))]
+		cells[nCells-i-1] = t.renderGraphCell(samples, sc, cellWidth)
+	}
+	// Any leftover width goes on the left so the newest results stay at the
+	// right edge.
+	return strings.Repeat(" ", width-nCells*cellWidth) + strings.Join(cells, "")
+}
+
+// Returns the character for a result that isn't drawn as a bar.
+func status(tp pinger.ResultType) string {
+	if s, ok := asciiStatuses[tp]; ok && termcap.Current().ASCII {
+		return s
+	}
+	return statuses[tp]
+}
+
+// Renders one character cell of the latency graph from samples, newest first.
+// The cell is colored by the highest latency, or in smoke mode by the widest
+// spread. Failures take over the whole
+// cell so they stand out. Late replies do too, in a color of their own.
+func (t *Model) renderGraphCell(samples []pinger.PingResult, sc scaler, width int) string {
+	for _, r := range samples {
+		switch r.Type {
+		case pinger.Success, pinger.Waiting, pinger.Gap:
+		case pinger.Late:
+			return t.lateStyle().Render(rpad(width, status(r.Type)))
+		default:
+			return t.errStyle().Render(rpad(width, status(r.Type)))
+		}
+	}
+	fracs := make([]float64, len(samples))
+	maxFrac := -1.0
+	for i, r := range samples {
+		fracs[i] = -1
+		if r.Type == pinger.Success {
+			fracs[i] = sc.Frac(r.Latency)
+			maxFrac = max(maxFrac, fracs[i])
+		}
+	}
+	if maxFrac < 0 {
+		return rpad(width, status(samples[0].Type))
+	}
+	return t.theme.Text.Normal.
+		Foreground(t.graphColor(samples, sc, maxFrac)).
+		Render(t.drawnGraphStyle().glyph(fracs))
+}
+
+// Returns the color of a graph cell whose highest latency is at maxFrac. In
+// smoke mode, the more the replies to the cell's bursts were spread out, the
+// brighter it is. Steady latencies fade into the background.
+func (t *Model) graphColor(samples []pinger.PingResult, sc scaler, maxFrac float64) lipgloss.TerminalColor {
+	if !t.smoke {
+		return t.theme.Heatmap.At(maxFrac)
+	}
+	var spread float64
+	for _, r := range samples {
+		if r.Type == pinger.Success {
+			spread = max(spread, sc.Frac(r.Spread))
+		}
 	}
-	return strings.Join(chars, "")
+	return theme.Grayscale{}.At(spread)
 }
 
 func (t *Model) headerView() string {
 	var sb strings.Builder
-	for i, c := range columnSpecs {
+	if t.showFilter() {
+		sb.WriteString(t.filterView() + "\n")
+	}
+	for i, c := range t.cols {
+		if !t.shown(c.ID) {
+			continue
+		}
 		width := t.colWidths[i]
-		sb.WriteString(t.headerStyle().Width(width + 2*horizontalPadding).Render(rpad(width, c.Title)))
+		title := c.Title
+		if c.ID == ColHost && t.window != 0 {
+			title += " (last " + windowName(t.window) + ")"
+		}
+		sb.WriteString(t.headerStyle().Width(width + 2*horizontalPadding).Render(rpad(width, title)))
 	}
 	return sb.String()
 }
@@ -452,12 +1435,38 @@ func (t *Model) cellStyle() lipgloss.Style {
 		Padding(0, horizontalPadding)
 }
 
+// Without colors, the selection is shown in reverse video and alerts are
+// underlined.
+func (t *Model) selectedStyle() lipgloss.Style {
+	if !termcap.Current().Color() {
+		return t.cellStyle().Reverse(true)
+	}
+	return t.cellStyle().
+		Foreground(t.theme.Colors.OnSecondary).
+		Background(t.theme.Colors.Secondary)
+}
+
+func (t *Model) alertStyle() lipgloss.Style {
+	if !termcap.Current().Color() {
+		return t.cellStyle().Underline(true)
+	}
+	return t.cellStyle().
+		Foreground(t.theme.Colors.OnError).
+		Background(t.theme.Colors.Error)
+}
+
 func (t *Model) errStyle() lipgloss.Style {
 	return t.theme.Text.Normal.
 		Foreground(t.theme.Colors.OnError).
 		Background(t.theme.Colors.Error)
 }
 
+// Late replies are drawn in the color of the slowest latencies.
+func (t *Model) lateStyle() lipgloss.Style {
+	return t.theme.Text.Important.
+		Foreground(t.theme.Heatmap.At(1))
+}
+
 func (t *Model) View() string {
 	if !t.ready {
 		return ""
@@ -0,0 +1,286 @@
+package table
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/view"
+)
+
+// Makes a table with a standalone host and a traced path.
+func newTestTable(t *testing.T) *Model {
+	t.Helper()
+	m := targets.New(nil)
+	t.Cleanup(func() { m.Close() })
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	keys := []targets.Key{
+		{Group: "a-rather-long-host-name.example.com"},
+		{Group: "192.0.2.1", Index: 1},
+		{Group: "192.0.2.1", Index: 2},
+	}
+	for _, k := range keys {
+		m.Feed(k, addr, 0, pinger.PingResult{Type: pinger.Success, Latency: 25 * time.Millisecond})
+		m.Feed(k, addr, 1, pinger.PingResult{Type: pinger.Dropped})
+	}
+	tbl := New(theme.Builtin()[0])
+	for _, tg := range m.Targets() {
+		tbl.AddRow(Row{RowKey: tg.Key, DisplayHost: tg.Key.Group, Addr: tg.Addr, Pinger: tg.Pinger})
+	}
+	return tbl
+}
+
+func TestNarrowWidths(t *testing.T) {
+	for width := 20; width <= 60; width++ {
+		tbl := newTestTable(t)
+		tbl.Update(tea.WindowSizeMsg{Width: width, Height: 24})
+		for i, line := range strings.Split(tbl.View(), "\n") {
+			if w := ansi.StringWidth(line); w > width {
+				t.Errorf("Width %d: line %d is %d columns wide: %q", width, i, w, ansi.Strip(line))
+			}
+		}
+		if !tbl.shown(ColResults) {
+			t.Errorf("Width %d: results not shown", width)
+		}
+	}
+}
+
+func TestNarrowLayout(t *testing.T) {
+	cases := []struct {
+		width       int
+		wantStacked bool
+		wantDropped []ColumnID
+	}{
+		{width: 80},
+		{width: 51},
+		{width: 50, wantDropped: []ColumnID{ColJitter}},
+		{width: 40, wantStacked: true, wantDropped: []ColumnID{ColJitter}},
+		{width: 30, wantStacked: true, wantDropped: []ColumnID{ColJitter, ColIndex}},
+		{width: 20, wantStacked: true, wantDropped: []ColumnID{ColJitter, ColIndex, ColPctLoss}},
+	}
+	for _, c := range cases {
+		tbl := newTestTable(t)
+		tbl.Update(tea.WindowSizeMsg{Width: c.width, Height: 24})
+		if tbl.stacked != c.wantStacked {
+			t.Errorf("Width %d: stacked = %v (want %v)", c.width, tbl.stacked, c.wantStacked)
+		}
+		for _, cs := range columnSpecs {
+			want := cs.Priority > 0 && slices.Contains(c.wantDropped, cs.ID)
+			if tbl.dropped[cs.ID] != want {
+				t.Errorf("Width %d: %v dropped = %v (want %v)", c.width, cs.Title, tbl.dropped[cs.ID], want)
+			}
+		}
+	}
+}
+
+// Checks that a tiny or empty terminal doesn't panic.
+func TestTinyTerminal(t *testing.T) {
+	for _, size := range []tea.WindowSizeMsg{{}, {Width: 1, Height: 1}, {Width: 5, Height: 2}} {
+		tbl := newTestTable(t)
+		tbl.Update(size)
+		tbl.View()
+		tbl.moveSelection(1)
+		tbl.scroll(1)
+		tbl.selectLine(0)
+	}
+}
+
+func TestPad(t *testing.T) {
+	cases := []struct {
+		width       int
+		s           string
+		left, right string
+	}{
+		{width: 4, s: "ab", left: "  ab", right: "ab  "},
+		{width: 3, s: "abcd", left: "ab…", right: "ab…"},
+		{width: 0, s: "ab", left: "", right: ""},
+		{width: -3, s: "ab", left: "", right: ""},
+	}
+	for _, c := range cases {
+		if got := lpad(c.width, c.s); got != c.left {
+			t.Errorf("lpad(%d, %q) = %q (want %q)", c.width, c.s, got, c.left)
+		}
+		if got := rpad(c.width, c.s); got != c.right {
+			t.Errorf("rpad(%d, %q) = %q (want %q)", c.width, c.s, got, c.right)
+		}
+	}
+}
+
+func TestSetColumns(t *testing.T) {
+	tbl := New(theme.Builtin()[0])
+	tbl.SetColumns([]ColumnSetting{{ColumnID: ColResults}, {ColumnID: ColHost}, {ColumnID: ColJitter, Hidden: true}})
+	want := []ColumnID{ColResults, ColHost}
+	if diff := cmp.Diff(want, tbl.VisibleColumns()); diff != "" {
+		t.Errorf("Wrong visible columns (-want, +got):\n%v", diff)
+	}
+	cols := tbl.Columns()
+	if len(cols) != len(columnSpecs) || cols[2].ColumnID != ColJitter || cols[3].ColumnID != ColIndex {
+		t.Errorf("Columns() = %v (want the rest after the given ones in default order)", cols)
+	}
+}
+
+func TestStatsWindow(t *testing.T) {
+	tbl := newTestTable(t)
+	tbl.Update(tea.WindowSizeMsg{Width: 120, Height: 24})
+	var got []time.Duration
+	for range len(pinger.StatsWindows) + 1 {
+		tbl.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'w'}})
+		got = append(got, tbl.StatsWindow())
+	}
+	want := append(slices.Clone(pinger.StatsWindows), 0)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong windows (-want, +got):\n%v", diff)
+	}
+
+	tbl.SetStatsWindow(5 * time.Minute)
+	if header := ansi.Strip(tbl.headerView()); !strings.Contains(header, "(last 5m)") {
+		t.Errorf("Header = %q (want it to contain the window)", header)
+	}
+}
+
+func TestWindowName(t *testing.T) {
+	cases := map[time.Duration]string{
+		time.Minute:      "1m",
+		10 * time.Minute: "10m",
+		time.Hour:        "1h",
+		90 * time.Second: "1m30s",
+	}
+	for d, want := range cases {
+		if got := windowName(d); got != want {
+			t.Errorf("windowName(%v) = %q (want %q)", d, got, want)
+		}
+	}
+}
+
+func TestFormatSort(t *testing.T) {
+	cols := []SortColumn{{ColumnID: ColPctLoss}, {ColumnID: ColAvgMs, Reverse: true}}
+	s := FormatSort(cols)
+	if s != "loss,-avgms" {
+		t.Errorf("FormatSort(%v) = %q (want %q)", cols, s, "loss,-avgms")
+	}
+	got, err := ParseSort(s)
+	if err != nil {
+		t.Fatalf("ParseSort(%q) error: %v", s, err)
+	}
+	if diff := cmp.Diff(cols, got); diff != "" {
+		t.Errorf("Sort didn't round trip (-want, +got):\n%v", diff)
+	}
+}
+
+func TestASCII(t *testing.T) {
+	old := termcap.Current()
+	termcap.Set(termcap.Caps{Profile: old.Profile, ASCII: true})
+	t.Cleanup(func() { termcap.Set(old) })
+
+	tbl := newTestTable(t)
+	tbl.SetGraphStyle(GraphBraille)
+	tbl.Update(tea.WindowSizeMsg{Width: 60, Height: 10})
+	tbl.toggleCollapsed()
+	for i, line := range strings.Split(ansi.Strip(tbl.View()), "\n") {
+		if j := strings.IndexFunc(line, func(r rune) bool { return r > unicode.MaxASCII }); j >= 0 {
+			t.Errorf("Line %d not ASCII at %d: %q", i, j, line)
+		}
+	}
+}
+
+func TestSmokeColor(t *testing.T) {
+	tbl := New(theme.Builtin()[0])
+	sc := scaler{scale: ScaleLinear, max: 100 * time.Millisecond}
+	samples := []pinger.PingResult{
+		{Type: pinger.Success, Latency: 50 * time.Millisecond, Spread: 10 * time.Millisecond},
+		{Type: pinger.Waiting},
+		{Type: pinger.Success, Latency: 20 * time.Millisecond, Spread: 40 * time.Millisecond},
+	}
+	if diff := cmp.Diff(tbl.theme.Heatmap.At(0.5), tbl.graphColor(samples, sc, 0.5)); diff != "" {
+		t.Errorf("Wrong color (-want, +got):\n%v", diff)
+	}
+	tbl.SetSmoke(true)
+	if diff := cmp.Diff(theme.Grayscale{}.At(0.4), tbl.graphColor(samples, sc, 0.5)); diff != "" {
+		t.Errorf("Wrong smoke color (-want, +got):\n%v", diff)
+	}
+}
+
+func TestUnreached(t *testing.T) {
+	tbl := newTestTable(t)
+	tbl.Update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	if strings.Contains(ansi.Strip(tbl.View()), "not reached") {
+		t.Errorf("Group shown as not reached before SetUnreached.")
+	}
+	tbl.SetUnreached("192.0.2.1", true)
+	if !strings.Contains(ansi.Strip(tbl.View()), "192.0.2.1 (not reached)") {
+		t.Errorf("Group not shown as not reached:\n%v", ansi.Strip(tbl.View()))
+	}
+	tbl.SetUnreached("192.0.2.1", false)
+	if strings.Contains(ansi.Strip(tbl.View()), "not reached") {
+		t.Errorf("Group still shown as not reached.")
+	}
+}
+
+func TestRowHost(t *testing.T) {
+	r := Row{
+		RowKey:      RowKey{Group: "192.0.2.1", Index: 1},
+		DisplayHost: "router.example",
+		Addr:        &net.UDPAddr{IP: net.ParseIP("192.0.2.1")},
+	}
+	header := r
+	header.Index = headerIndex
+	cases := []struct {
+		r     Row
+		hosts view.HostDisplay
+		want  string
+	}{
+		{r: r, hosts: view.HostName, want: "router.example"},
+		{r: r, hosts: view.HostAddr, want: "192.0.2.1"},
+		{r: r, hosts: view.HostBoth, want: "router.example (192.0.2.1)"},
+		{r: header, hosts: view.HostAddr, want: "router.example"},
+	}
+	for _, c := range cases {
+		if got := c.r.host(c.hosts); got != c.want {
+			t.Errorf("Row %v host(%v) = %q (want %q)", c.r.RowKey, c.hosts, got, c.want)
+		}
+	}
+}
+
+func TestSetDisplayHosts(t *testing.T) {
+	m := targets.New(nil)
+	t.Cleanup(func() { m.Close() })
+	tbl := New(theme.Builtin()[0])
+	tbl.SetSort(SortColumn{ColumnID: ColHost})
+	for i, group := range []string{"a", "b", "c"} {
+		k := targets.Key{Group: group}
+		addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i+1))}
+		m.Feed(k, addr, 0, pinger.PingResult{Type: pinger.Success})
+		tg, _ := m.Target(k)
+		tbl.AddRow(Row{RowKey: k, DisplayHost: group, Addr: addr, Pinger: tg.Pinger})
+	}
+
+	// Renames a to z, so that it sorts last.
+	var calls int
+	tbl.SetDisplayHosts(func(r Row) string {
+		calls++
+		if r.Group == "a" {
+			return "z"
+		}
+		return r.DisplayHost
+	})
+	if calls != 3 {
+		t.Errorf("Name function called %d times (want 3)", calls)
+	}
+	var got []string
+	for _, r := range tbl.Rows() {
+		got = append(got, r.DisplayHost)
+	}
+	if diff := cmp.Diff([]string{"b", "c", "z"}, got); diff != "" {
+		t.Errorf("Wrong rows (-want, +got):\n%v", diff)
+	}
+}
@@ -0,0 +1,209 @@
+// Package tabs implements a set of named tabs, each with a table of its own.
+// A bar across the top shows the tabs when there's more than one.
+package tabs
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/termcap"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+// Most tabs that can be chosen with number keys.
+const maxNumbered = 9
+
+type keyMap struct {
+	Next key.Binding
+	Prev key.Binding
+}
+
+var defaultKeyMap = keyMap{
+	Next: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "next tab"),
+	),
+	Prev: key.NewBinding(
+		key.WithKeys("shift+tab"),
+		key.WithHelp("shift+tab", "previous tab"),
+	),
+}
+
+type tab struct {
+	name  string
+	table *table.Model
+}
+
+// Model holds the tabs.
+type Model struct {
+	theme         *theme.Theme
+	newTable      func() *table.Model
+	tabs          []*tab
+	active        int
+	width, height int
+}
+
+// New creates a set of tabs with a single tab. Tables for new tabs are made by
+// newTable.
+func New(theme *theme.Theme, name string, newTable func() *table.Model) *Model {
+	return &Model{
+		theme:    theme,
+		newTable: newTable,
+		tabs:     []*tab{{name: name, table: newTable()}},
+	}
+}
+
+// Active returns the table of the tab being shown.
+func (m *Model) Active() *table.Model {
+	return m.tabs[m.active].table
+}
+
+// Tables returns the tables of all the tabs.
+func (m *Model) Tables() []*table.Model {
+	tables := make([]*table.Model, len(m.tabs))
+	for i, t := range m.tabs {
+		tables[i] = t.table
+	}
+	return tables
+}
+
+// Table returns the table of the named tab, adding the tab if there isn't one.
+func (m *Model) Table(name string) *table.Model {
+	if i := m.index(name); i >= 0 {
+		return m.tabs[i].table
+	}
+	// Every table shrinks if this adds the tab bar.
+	m.tabs = append(m.tabs, &tab{name: name, table: m.newTable()})
+	m.resize()
+	return m.tabs[len(m.tabs)-1].table
+}
+
+// Returns the index of the named tab, or -1 if there isn't one.
+func (m *Model) index(name string) int {
+	return slices.IndexFunc(m.tabs, func(t *tab) bool { return t.name == name })
+}
+
+// Prune removes the tabs with empty tables, except for the last one left.
+func (m *Model) Prune() {
+	n := len(m.tabs)
+	active := m.tabs[m.active]
+	m.tabs = slices.DeleteFunc(m.tabs, func(t *tab) bool { return len(t.table.Rows()) == 0 })
+	if len(m.tabs) == 0 {
+		m.tabs = []*tab{active}
+	}
+	// Goes back to the first tab if the one being shown was removed.
+	m.active = max(0, slices.Index(m.tabs, active))
+	if len(m.tabs) != n {
+		m.resize()
+	}
+}
+
+// Select shows the tab at index i. Does nothing if there isn't one.
+func (m *Model) Select(i int) {
+	if i >= 0 && i < len(m.tabs) {
+		m.active = i
+		m.Active().UpdateRows()
+	}
+}
+
+// SetTheme changes the theme of the tabs and their tables.
+func (m *Model) SetTheme(theme *theme.Theme) {
+	m.theme = theme
+	for _, t := range m.tabs {
+		t.table.SetTheme(theme)
+	}
+}
+
+// Returns the height of the tab bar.
+func (m *Model) barHeight() int {
+	if len(m.tabs) > 1 {
+		return 1
+	}
+	return 0
+}
+
+// Tells the tables how much room they have below the tab bar.
+func (m *Model) resize() {
+	if m.width == 0 && m.height == 0 {
+		return
+	}
+	msg := tea.WindowSizeMsg{Width: m.width, Height: m.height - m.barHeight()}
+	for _, t := range m.tabs {
+		t.table.Update(msg)
+	}
+}
+
+func (m *Model) Update(msg tea.Msg) tea.Cmd {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.resize()
+		return nil
+	case tea.KeyMsg:
+		return m.handleKeyMsg(msg)
+	case tea.MouseMsg:
+		msg.Y -= m.barHeight()
+		if msg.Y < 0 {
+			return nil
+		}
+		return m.Active().Update(msg)
+	}
+	var cmds []tea.Cmd
+	for _, t := range m.tabs {
+		cmds = append(cmds, t.table.Update(msg))
+	}
+	return tea.Batch(cmds...)
+}
+
+func (m *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
+	// Keys typed into the filter are left alone.
+	if len(m.tabs) < 2 || m.Active().Filtering() {
+		return m.Active().Update(msg)
+	}
+	switch {
+	case key.Matches(msg, defaultKeyMap.Next):
+		m.Select((m.active + 1) % len(m.tabs))
+		return nil
+	case key.Matches(msg, defaultKeyMap.Prev):
+		m.Select((m.active + len(m.tabs) - 1) % len(m.tabs))
+		return nil
+	}
+	if s := msg.String(); len(s) == 1 && s[0] >= '1' && s[0] <= '0'+maxNumbered {
+		m.Select(int(s[0] - '1'))
+		return nil
+	}
+	return m.Active().Update(msg)
+}
+
+// Renders the bar of tab names. Tabs that can be chosen with number keys are
+// numbered.
+func (m *Model) barView() string {
+	var sb strings.Builder
+	for i, t := range m.tabs {
+		style := m.theme.Text.Normal.Padding(0, 1)
+		if i == m.active {
+			style = m.theme.Text.Important.
+				Padding(0, 1).
+				Foreground(m.theme.Colors.OnPrimary).
+				Background(m.theme.Colors.Primary)
+		}
+		name := t.name
+		if i < maxNumbered {
+			name = fmt.Sprintf("%d %s", i+1, name)
+		}
+		sb.WriteString(style.Render(name))
+	}
+	return ansi.Truncate(sb.String(), m.width, termcap.Ellipsis())
+}
+
+func (m *Model) View() string {
+	if m.barHeight() == 0 {
+		return m.Active().View()
+	}
+	return m.barView() + "\n" + m.Active().View()
+}
@@ -0,0 +1,81 @@
+// Package termcap describes what the terminal can display: how many colors,
+// and whether it's limited to ASCII. The renderers consult it for the glyphs
+// and styles they draw with. It's set once at startup, before the UI runs.
+package termcap
+
+import (
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// Caps are the capabilities of a terminal.
+type Caps struct {
+	// Profile is the colors the terminal supports. termenv.Ascii means
+	// none.
+	Profile termenv.Profile
+
+	// ASCII limits output to ASCII characters, for terminals and logs that
+	// can't show anything else.
+	ASCII bool
+}
+
+// Color returns true if the terminal shows colors.
+func (c Caps) Color() bool {
+	return c.Profile != termenv.Ascii
+}
+
+var current = Caps{Profile: termenv.TrueColor}
+
+// Detect finds the capabilities of the terminal on stdout. Colors are worked
+// out from $TERM and $COLORTERM, and turned off by $NO_COLOR or when stdout
+// isn't a terminal. $CLICOLOR_FORCE turns them back on. Nothing is detected
+// for ASCII, which is left off.
+func Detect() Caps {
+	return Caps{Profile: termenv.NewOutput(os.Stdout).EnvColorProfile()}
+}
+
+// Set sets the capabilities the renderers use.
+func Set(c Caps) {
+	current = c
+	lipgloss.SetColorProfile(c.Profile)
+}
+
+// Current returns the capabilities the renderers use.
+func Current() Caps {
+	return current
+}
+
+// Glyph returns s, or ascii if output is limited to ASCII.
+func Glyph(s, ascii string) string {
+	if current.ASCII {
+		return ascii
+	}
+	return s
+}
+
+// Ellipsis returns the string that marks truncated text.
+func Ellipsis() string {
+	return Glyph("…", "...")
+}
+
+// A border drawn in ASCII.
+var asciiBorder = lipgloss.Border{
+	Top:         "-",
+	Bottom:      "-",
+	Left:        "|",
+	Right:       "|",
+	TopLeft:     "+",
+	TopRight:    "+",
+	BottomLeft:  "+",
+	BottomRight: "+",
+}
+
+// Border returns a plain line border.
+func Border() lipgloss.Border {
+	if current.ASCII {
+		return asciiBorder
+	}
+	return lipgloss.NormalBorder()
+}
@@ -0,0 +1,178 @@
+package theme
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/charmbracelet/lipgloss"
+	colorful "github.com/lucasb-eyer/go-colorful"
+)
+
+// Dark is the default theme's dark variant, for terminals where the
+// background can't be detected.
+var Dark = New("dark", Colors{
+	Surface:          lipgloss.NoColor{},
+	OnSurface:        lipgloss.Color("#BBBBBB"),
+	OnSurfaceVariant: lipgloss.Color("#888888"),
+	Primary: lipgloss.CompleteColor{
+		TrueColor: "#1c3965",
+		ANSI256:   "18",
+		ANSI:      "4",
+	},
+	OnPrimary: lipgloss.Color("#CCCCCC"),
+	Secondary: lipgloss.CompleteColor{
+		TrueColor: "#323a47",
+		ANSI256:   "237",
+		ANSI:      "8",
+	},
+	OnSecondary: lipgloss.Color("#CCCCCC"),
+	Error: lipgloss.CompleteColor{
+		TrueColor: "#a8242a",
+		ANSI256:   "124",
+		ANSI:      "1",
+	},
+	OnError: lipgloss.CompleteColor{
+		TrueColor: "#CCCCCC",
+		ANSI256:   "252",
+		ANSI:      "7",
+	},
+}, Gradient{
+	LightLow:  "#3fa423",
+	LightHigh: "#a8242a",
+	DarkLow:   "#3fa423",
+	DarkHigh:  "#a8242a",
+})
+
+// Light is the default theme's light variant, for terminals where the
+// background can't be detected.
+var Light = New("light", Colors{
+	Surface:          lipgloss.NoColor{},
+	OnSurface:        lipgloss.Color("#222222"),
+	OnSurfaceVariant: lipgloss.Color("#444444"),
+	Primary: lipgloss.CompleteColor{
+		TrueColor: "#68a3ff",
+		ANSI256:   "33",
+		ANSI:      "12",
+	},
+	OnPrimary: lipgloss.Color("#111111"),
+	Secondary: lipgloss.CompleteColor{
+		TrueColor: "#9cc3ff",
+		ANSI256:   "251",
+		ANSI:      "7",
+	},
+	OnSecondary: lipgloss.Color("#111111"),
+	Error:       lipgloss.NoColor{},
+	OnError: lipgloss.CompleteColor{
+		TrueColor: "#d22f37",
+		ANSI256:   "124",
+		ANSI:      "1",
+	},
+}, Gradient{
+	LightLow:  "#5ad02d",
+	LightHigh: "#d22f37",
+	DarkLow:   "#5ad02d",
+	DarkHigh:  "#d22f37",
+})
+
+// HighContrast uses the terminal's foreground at full strength and saturated
+// highlights.
+var HighContrast = New("high-contrast", Colors{
+	Surface: lipgloss.NoColor{},
+	OnSurface: lipgloss.AdaptiveColor{
+		Light: "#000000",
+		Dark:  "#FFFFFF",
+	},
+	OnSurfaceVariant: lipgloss.AdaptiveColor{
+		Light: "#303030",
+		Dark:  "#D0D0D0",
+	},
+	Primary:     lipgloss.Color("#FFD700"),
+	OnPrimary:   lipgloss.Color("#000000"),
+	Secondary:   lipgloss.Color("#00FFFF"),
+	OnSecondary: lipgloss.Color("#000000"),
+	Error:       lipgloss.Color("#FF0000"),
+	OnError:     lipgloss.Color("#FFFFFF"),
+}, Gradient{
+	LightLow:  "#00A000",
+	LightHigh: "#FF0000",
+	DarkLow:   "#00FF00",
+	DarkHigh:  "#FF0000",
+})
+
+// Mono uses only shades of gray.
+var Mono = New("mono", Colors{
+	Surface: lipgloss.NoColor{},
+	OnSurface: lipgloss.AdaptiveColor{
+		Light: "#222222",
+		Dark:  "#BBBBBB",
+	},
+	OnSurfaceVariant: lipgloss.AdaptiveColor{
+		Light: "#666666",
+		Dark:  "#777777",
+	},
+	Primary: lipgloss.AdaptiveColor{
+		Light: "#222222",
+		Dark:  "#DDDDDD",
+	},
+	OnPrimary: lipgloss.AdaptiveColor{
+		Light: "#FFFFFF",
+		Dark:  "#000000",
+	},
+	Secondary: lipgloss.AdaptiveColor{
+		Light: "#BBBBBB",
+		Dark:  "#444444",
+	},
+	OnSecondary: lipgloss.AdaptiveColor{
+		Light: "#000000",
+		Dark:  "#FFFFFF",
+	},
+	Error: lipgloss.AdaptiveColor{
+		Light: "#000000",
+		Dark:  "#FFFFFF",
+	},
+	OnError: lipgloss.AdaptiveColor{
+		Light: "#FFFFFF",
+		Dark:  "#000000",
+	},
+}, Grayscale{})
+
+// Built-in themes in the order they're cycled through.
+var builtin = []*Theme{&Default, &Dark, &Light, &HighContrast, &Mono}
+
+// Builtin returns the built-in themes.
+func Builtin() []*Theme {
+	return append([]*Theme{}, builtin...)
+}
+
+// Named returns the built-in theme with the given name.
+func Named(name string) (*Theme, error) {
+	for _, t := range builtin {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown theme %q", name)
+}
+
+// Grayscale is a heatmap that goes from dim to bright on dark backgrounds, and
+// from light to dark on light ones.
+type Grayscale struct{}
+
+// At returns the color for the given value. The value must be in the interval
+// [0, 1].
+func (Grayscale) At(v float64) lipgloss.TerminalColor {
+	dark := 0.4 + 0.6*v
+	light := 0.6 - 0.6*v
+	return lipgloss.CompleteAdaptiveColor{
+		Light: lipgloss.CompleteColor{
+			TrueColor: colorful.Color{R: light, G: light, B: light}.Hex(),
+			ANSI256:   fmt.Sprint(249 - int(math.Round(v*17))),
+			ANSI:      []string{"7", "8", "0"}[int(math.Round(v*2))],
+		},
+		Dark: lipgloss.CompleteColor{
+			TrueColor: colorful.Color{R: dark, G: dark, B: dark}.Hex(),
+			ANSI256:   fmt.Sprint(241 + int(math.Round(v*14))),
+			ANSI:      []string{"8", "7", "15"}[int(math.Round(v*2))],
+		},
+	}
+}
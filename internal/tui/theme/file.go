@@ -0,0 +1,177 @@
+package theme
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	colorful "github.com/lucasb-eyer/go-colorful"
+
+	"github.com/pcekm/vasily/internal/config/toml"
+)
+
+// Find returns the built-in theme with the given name or, if the argument
+// looks like a path, loads a theme file.
+func Find(nameOrPath string) (*Theme, error) {
+	if t, err := Named(nameOrPath); err == nil {
+		return t, nil
+	}
+	if !strings.ContainsRune(nameOrPath, filepath.Separator) && filepath.Ext(nameOrPath) != ".toml" {
+		var names []string
+		for _, t := range builtin {
+			names = append(names, t.Name)
+		}
+		return nil, fmt.Errorf("unknown theme %q (not one of %s, or a path to a theme file)", nameOrPath, strings.Join(names, ", "))
+	}
+	return Load(nameOrPath)
+}
+
+// Load reads a theme file. Theme files are in the same restricted subset of
+// TOML as the config file (see package toml). Each color is a hex string, an
+// ANSI color number, or a [light, dark] pair of those for light and dark
+// backgrounds. For example:
+//
+//	extends = "dark"        # Built-in theme for unset colors. Default "default".
+//	name = "solarized"      # Defaults to the file's base name.
+//	surface = "#002b36"
+//	on_surface = ["#073642", "#93a1a1"]
+//	primary = 4
+//	heatmap = ["#859900", "#dc322f"]  # Low and high hex colors.
+//
+// The other colors are on_surface_variant, on_primary, secondary,
+// on_secondary, error and on_error.
+func Load(path string) (*Theme, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	doc, err := toml.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	t, err := fromDoc(doc, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return t, nil
+}
+
+// Builds a theme from a parsed theme file.
+func fromDoc(doc *toml.Document, name string) (*Theme, error) {
+	for n := range doc.Arrays {
+		return nil, fmt.Errorf("unknown table [[%s]]", n)
+	}
+
+	base := &Default
+	if v, ok := doc.Top["extends"]; ok {
+		s, err := v.AsString()
+		if err == nil {
+			base, err = Named(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: extends: %v", v.Line, err)
+		}
+	}
+
+	colors := base.Colors
+	heatmap := base.Heatmap
+	fields := colorFields(&colors)
+	for key, v := range doc.Top {
+		var err error
+		switch key {
+		case "extends":
+		case "name":
+			name, err = v.AsString()
+		case "heatmap":
+			heatmap, err = parseGradient(v)
+		default:
+			field, ok := fields[key]
+			if !ok {
+				err = errors.New("unknown setting")
+				break
+			}
+			*field, err = parseColor(v.Val)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %v", v.Line, key, err)
+		}
+	}
+
+	t := New(name, colors, heatmap)
+	return &t, nil
+}
+
+// Returns the colors by their names in theme files.
+func colorFields(c *Colors) map[string]*lipgloss.TerminalColor {
+	return map[string]*lipgloss.TerminalColor{
+		"surface":            &c.Surface,
+		"on_surface":         &c.OnSurface,
+		"on_surface_variant": &c.OnSurfaceVariant,
+		"primary":            &c.Primary,
+		"on_primary":         &c.OnPrimary,
+		"secondary":          &c.Secondary,
+		"on_secondary":       &c.OnSecondary,
+		"error":              &c.Error,
+		"on_error":           &c.OnError,
+	}
+}
+
+// Parses a color, or a [light, dark] pair of colors.
+func parseColor(v any) (lipgloss.TerminalColor, error) {
+	if arr, ok := v.([]any); ok {
+		if len(arr) != 2 {
+			return nil, errors.New("expected a [light, dark] pair of colors")
+		}
+		light, err := parseSingleColor(arr[0])
+		if err != nil {
+			return nil, err
+		}
+		dark, err := parseSingleColor(arr[1])
+		if err != nil {
+			return nil, err
+		}
+		return lipgloss.AdaptiveColor{Light: string(light), Dark: string(dark)}, nil
+	}
+	return parseSingleColor(v)
+}
+
+// Parses a hex color or an ANSI color number.
+func parseSingleColor(v any) (lipgloss.Color, error) {
+	switch v := v.(type) {
+	case string:
+		if _, err := colorful.Hex(v); err != nil {
+			return "", fmt.Errorf("bad hex color %q", v)
+		}
+		return lipgloss.Color(v), nil
+	case int64:
+		if v < 0 || v > 255 {
+			return "", fmt.Errorf("ANSI color %d out of range", v)
+		}
+		return lipgloss.Color(strconv.FormatInt(v, 10)), nil
+	}
+	return "", fmt.Errorf("expected a color (got %v)", v)
+}
+
+// Parses a [low, high] pair of hex colors.
+func parseGradient(v toml.Value) (Gradient, error) {
+	cols, err := v.AsStrings()
+	if err != nil || len(cols) != 2 {
+		return Gradient{}, errors.New("expected a [low, high] pair of hex colors")
+	}
+	for _, c := range cols {
+		if _, err := colorful.Hex(c); err != nil {
+			return Gradient{}, fmt.Errorf("bad hex color %q", c)
+		}
+	}
+	return Gradient{
+		LightLow:  cols[0],
+		LightHigh: cols[1],
+		DarkLow:   cols[0],
+		DarkHigh:  cols[1],
+	}, nil
+}
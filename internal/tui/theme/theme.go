@@ -83,37 +83,39 @@ var (
 
 	ansiGradient    = []string{"2", "3", "1"}
 	ansi256Gradient = []string{"119", "112", "148", "142", "136", "130", "124"}
-
-	base = lipgloss.NewStyle().
-		Foreground(lipgloss.AdaptiveColor{
-			Light: "#333333",
-			Dark:  "#AAAAAA",
-		}).
-		Foreground(defaultColors.OnSurface).
-		Background(defaultColors.Surface)
 )
 
 // Default contains the default theme.
-var Default = Theme{
-	Base: base,
-	Text: Text{
-		Normal: base,
-		Important: base.
-			Bold(true),
-		Unimportant: base.
-			Foreground(defaultColors.OnSurfaceVariant),
-	},
-	Colors: defaultColors,
-	Heatmap: Gradient{
-		LightLow:  "#5ad02d",
-		LightHigh: "#d22f37",
-		DarkLow:   "#3fa423",
-		DarkHigh:  "#a8242a",
-	},
+var Default = New("default", defaultColors, Gradient{
+	LightLow:  "#5ad02d",
+	LightHigh: "#d22f37",
+	DarkLow:   "#3fa423",
+	DarkHigh:  "#a8242a",
+})
+
+// New creates a theme from a set of colors.
+func New(name string, colors Colors, heatmap Heatmap) Theme {
+	base := lipgloss.NewStyle().
+		Foreground(colors.OnSurface).
+		Background(colors.Surface)
+	return Theme{
+		Name: name,
+		Base: base,
+		Text: Text{
+			Normal: base,
+			Important: base.
+				Bold(true),
+			Unimportant: base.
+				Foreground(colors.OnSurfaceVariant),
+		},
+		Colors:  colors,
+		Heatmap: heatmap,
+	}
 }
 
 // Theme contains common styles for use throughout the program.
 type Theme struct {
+	Name    string         // Name to show the user
 	Base    lipgloss.Style // Base style that everything else inherits from
 	Text    Text
 	Colors  Colors
@@ -4,25 +4,50 @@ package tui
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"maps"
 	"net"
+	"os"
+	"slices"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
 
+	"github.com/pcekm/vasily/internal/asn"
 	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/baseline"
 	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pathmtu"
 	"github.com/pcekm/vasily/internal/pinger"
-	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/targetlist"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/tui/addhost"
+	"github.com/pcekm/vasily/internal/tui/columnselect"
+	"github.com/pcekm/vasily/internal/tui/detail"
+	"github.com/pcekm/vasily/internal/tui/labeledit"
+	"github.com/pcekm/vasily/internal/tui/logview"
 	"github.com/pcekm/vasily/internal/tui/nav"
 	"github.com/pcekm/vasily/internal/tui/sortselect"
+	"github.com/pcekm/vasily/internal/tui/statusbar"
 	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/tabs"
 	"github.com/pcekm/vasily/internal/tui/theme"
+	"github.com/pcekm/vasily/internal/uistate"
 	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
 )
 
 const (
 	screenUpdateInterval = 100 * time.Millisecond
+
+	// How often to pick up names resolved elsewhere and refresh expired ones.
+	// Cached names don't expire any sooner.
+	nameRefreshInterval = time.Minute
+
+	// The tab for rows that don't go anywhere else.
+	defaultTab = "hosts"
 )
 
 // Options contain main program options.
@@ -30,27 +55,74 @@ type Options struct {
 	// Theme contains a UI theme.
 	Theme *theme.Theme
 
-	// Trace activates traceroute mode. Traces the path to each host and pings
-	// each step in the path.
-	Trace bool
+	// Targets manages the pingers and traces being displayed. Defaults to a
+	// manager with default options.
+	Targets *targets.Manager
+
+	// PathMTU enables path MTU discovery for each host.
+	PathMTU bool
+
+	// ASN enables autonomous system lookups for each host.
+	ASN bool
+
+	// Columns lists the table columns to display, in order. Defaults to the
+	// table's default.
+	Columns []table.ColumnID
+
+	// ShowColumns lists optional table columns to display in addition to
+	// Columns.
+	ShowColumns []table.ColumnID
+
+	// SaveColumns, if set, is called to save the columns the user chooses on
+	// the column screen.
+	SaveColumns func([]table.ColumnID) error
+
+	// Sort is the initial sort order. Defaults to the table's default.
+	Sort []table.SortColumn
+
+	// GraphScale is the initial scaling mode for the latency graph.
+	GraphScale table.Scale
+
+	// GraphMax is the latency that displays at full height in the latency
+	// graph. Not used by table.ScaleAuto.
+	GraphMax time.Duration
 
-	// PingInterval is the interval that pings are sent.
-	PingInterval time.Duration
+	// GraphStyle is the initial set of characters for the latency graph.
+	GraphStyle table.GraphStyle
 
-	// PingBackend is the backend to use for pings.
-	PingBackend backend.Name
+	// Smoke colors the latency graph by the spread of each burst's replies.
+	// Bursts should show their median latency.
+	Smoke bool
 
-	// TraceInterval is the interval between route trace probes.
-	TraceInterval time.Duration
+	// Baseline holds saved latencies to compare each target against, or nil
+	// for none.
+	Baseline *baseline.Baseline
 
-	// TraceBackend is the backend to use for traces.
-	TraceBackend backend.Name
+	// BaselineThreshold is the fraction a target's latency must rise above
+	// its baseline by to be highlighted as a regression. Defaults to
+	// baseline.DefaultThreshold.
+	BaselineThreshold float64
 
-	// TraceMaxTTL is the maximum ttl to trace.
-	TraceMaxTTL int
+	// StatsWindow is how far back the statistics shown go at first: one of
+	// pinger.StatsWindows, or zero for all time.
+	StatsWindow time.Duration
 
-	// ProbesPerHop is the number of times to probe for responses at each ttl.
-	ProbesPerHop int
+	// LookupURL is the web page opened to look up a row's address, with %s
+	// where the address goes. Defaults to DefaultLookupURL.
+	LookupURL string
+
+	// Output is the terminal, for escape sequences sent outside of the view
+	// such as clipboard updates. Defaults to os.Stdout.
+	Output io.Writer
+
+	// TabPerTrace shows the path of each trace in a tab of its own. Hosts
+	// with a tab option go in that tab instead.
+	TabPerTrace bool
+
+	// Labels are the labels edited in the UI in earlier runs, keyed by
+	// address. They should already be set on Targets. They're returned by
+	// [Model.State] along with any edited in this run.
+	Labels map[string]string
 }
 
 func setOptionDefaults(o *Options) *Options {
@@ -58,165 +130,606 @@ func setOptionDefaults(o *Options) *Options {
 		o = &Options{}
 	}
 	util.MaybeSetDefault(&o.Theme, &theme.Default)
-	util.MaybeSetDefault(&o.PingInterval, time.Second)
-	util.MaybeSetDefault(&o.PingBackend, "icmp")
-	util.MaybeSetDefault(&o.TraceInterval, time.Second)
-	util.MaybeSetDefault(&o.TraceBackend, "udp")
-	util.MaybeSetDefault(&o.TraceMaxTTL, 64)
-	util.MaybeSetDefault(&o.ProbesPerHop, 3)
+	util.MaybeSetDefault(&o.GraphMax, table.DefaultGraphMax)
+	util.MaybeSetDefault(&o.BaselineThreshold, baseline.DefaultThreshold)
+	util.MaybeSetDefault(&o.LookupURL, DefaultLookupURL)
+	if o.Output == nil {
+		o.Output = os.Stdout
+	}
+	if o.Targets == nil {
+		o.Targets = targets.New(nil)
+	}
 
 	return o
 }
 
 type updateRows struct{}
 
-type traceStepMsg struct {
-	step tracer.Step
-	host string
-	next <-chan tracer.Step
+// Sent when it's time to refresh the names of all rows.
+type refreshNames struct{}
+
+// Sent when a host added at runtime has been resolved.
+type hostResolvedMsg struct {
+	host targetlist.Entry
+	addr net.Addr
+}
+
+// Sent when path MTU discovery for a row completes.
+type pathMTUMsg struct {
+	key table.RowKey
+	mtu int
+}
+
+// Sent when the AS lookup for a row completes.
+type asnMsg struct {
+	key table.RowKey
+	asn string
 }
 
+// Sent when a reverse DNS lookup for an address completes.
+type hostNameMsg struct {
+	addr net.Addr
+	name string
+}
+
+// Sent when a target is added or removed.
+type targetMsg struct {
+	event targets.Event
+}
+
+// Sent when a run with a count or deadline has finished.
+type finishedMsg struct{}
+
 // Model is the main text UI model.
 type Model struct {
-	focus nav.Screen
-	table *table.Model
-	sort  *sortselect.Model
-	hosts []string
-	opts  *Options
+	focus   nav.Screen
+	tabs    *tabs.Model
+	sort    *sortselect.Model
+	columns *columnselect.Model
+	addHost *addhost.Model
+	label   *labeledit.Model
+	detail  *detail.Model
+	logView *logview.Model
+	status  *statusbar.Model
+	hosts   []targetlist.Entry
+	opts    *Options
+	theme   *theme.Theme
+
+	// The columns of every tab's table.
+	colSettings []table.ColumnSetting
+
+	// The tabs of groups whose hosts were given a tab.
+	tabNames map[string]string
+
+	// Traced groups whose last pass didn't reach the destination.
+	unreached map[string]bool
+
+	// Labels edited in the UI, keyed by address.
+	labels map[string]string
+
+	// The sort order the tables started with, to tell if it's been changed.
+	initialSort []table.SortColumn
+
+	// How every tab shows hosts, and whether the user has changed it.
+	hostDisplay        view.HostDisplay
+	hostDisplayChanged bool
+
+	// Changes to the targets, which are added to and removed from the
+	// table.
+	targetEvents *targets.Subscription
+
+	// Hosts passed to New that couldn't be resolved.
+	unresolved []string
 }
 
 // New creates a new model.
-func New(hosts []string, opts *Options) (*Model, error) {
+func New(hosts []targetlist.Entry, opts *Options) (*Model, error) {
 	opts = setOptionDefaults(opts)
-	tbl := table.New(opts.Theme)
 	m := &Model{
-		focus: nav.Main,
-		table: tbl,
-		sort:  sortselect.New(opts.Theme, tbl),
-		hosts: hosts,
-		opts:  opts,
+		focus:       nav.Main,
+		addHost:     addhost.New(opts.Theme),
+		label:       labeledit.New(opts.Theme),
+		hosts:       hosts,
+		opts:        opts,
+		theme:       opts.Theme,
+		colSettings: initialColumns(opts),
+		tabNames:    make(map[string]string),
+		unreached:   make(map[string]bool),
+		labels:      maps.Clone(opts.Labels),
+
+		targetEvents: opts.Targets.Subscribe(),
 	}
+	m.tabs = tabs.New(opts.Theme, defaultTab, m.newTable)
+	m.initialSort = m.tabs.Active().Sort()
+	m.sort = sortselect.New(opts.Theme, m.tabs.Active())
+	m.columns = columnselect.New(opts.Theme, m.tabs.Active())
+	m.detail = detail.New(opts.Theme, m.tabs.Active())
+	m.logView = logview.New(opts.Theme)
+	m.status = statusbar.New(opts.Theme, opts.Targets)
 	return m, nil
 }
 
+// Returns the column settings from the options.
+func initialColumns(opts *Options) []table.ColumnSetting {
+	cols := table.DefaultColumns()
+	if len(opts.Columns) > 0 {
+		cols = make([]table.ColumnSetting, len(opts.Columns))
+		for i, c := range opts.Columns {
+			cols[i] = table.ColumnSetting{ColumnID: c}
+		}
+	}
+	for _, c := range opts.ShowColumns {
+		i := slices.IndexFunc(cols, func(s table.ColumnSetting) bool { return s.ColumnID == c })
+		if i < 0 {
+			cols = append(cols, table.ColumnSetting{ColumnID: c})
+		} else {
+			cols[i].Hidden = false
+		}
+	}
+	return cols
+}
+
+// Makes the table for a new tab.
+func (m *Model) newTable() *table.Model {
+	tbl := table.New(m.theme)
+	tbl.SetColumns(m.colSettings)
+	tbl.SetScale(m.opts.GraphScale, m.opts.GraphMax)
+	tbl.SetGraphStyle(m.opts.GraphStyle)
+	tbl.SetSmoke(m.opts.Smoke)
+	tbl.SetSort(m.opts.Sort...)
+	tbl.SetBaselineThreshold(m.opts.BaselineThreshold)
+	tbl.SetStatsWindow(m.opts.StatsWindow)
+	tbl.SetHostDisplay(m.hostDisplay)
+	for g := range m.unreached {
+		tbl.SetUnreached(g, true)
+	}
+	return tbl
+}
+
+// Returns the name of the tab a row goes in.
+func (m *Model) tabFor(k table.RowKey) string {
+	if name, ok := m.tabNames[k.Group]; ok {
+		return name
+	}
+	if m.opts.TabPerTrace && k.Index > 0 {
+		return k.Group
+	}
+	return defaultTab
+}
+
 // Init initializes the model.
 func (m *Model) Init() tea.Cmd {
 	cmds := []tea.Cmd{
 		m.updateRows(updateRows{}),
+		m.refreshNamesCmd(),
 		m.sort.Init(),
+		m.columns.Init(),
+		m.addHost.Init(),
+		m.label.Init(),
+		m.detail.Init(),
+		m.logView.Init(),
+		m.status.Init(),
+		m.nextTargetCmd(),
+		m.finishedCmd(),
 	}
 	for _, h := range m.hosts {
-		addr, err := lookup.String(h)
+		addr, err := lookup.String(h.Host)
 		if err != nil {
-			log.Printf("Error looking up %q: %v", h, err)
+			log.Printf("Error looking up %q: %v", h.Host, err)
+			m.unresolved = append(m.unresolved, h.Host)
+			continue
 		}
-		if m.opts.Trace {
-			cmds = append(cmds, m.startTraceCmd(addr))
+		cmds = append(cmds, m.addHostCmd(h, addr))
+	}
+	m.opts.Targets.FinishIfIdle()
+	return tea.Batch(cmds...)
+}
+
+// Starts pinging or tracing a host, depending on the mode. Returns a command
+// that reports any error other than the host already being there, or targets
+// coming from a replay.
+func (m *Model) addHostCmd(host targetlist.Entry, addr net.Addr) tea.Cmd {
+	group, err := m.opts.Targets.AddHost(host.Host, addr, host.Options)
+	if err == nil && host.Tab != "" {
+		m.tabNames[group] = host.Tab
+	}
+	switch {
+	case errors.Is(err, targets.ErrExists), errors.Is(err, targets.ErrReplaying):
+		log.Printf("Not adding %q: %v", host.Host, err)
+	case err != nil:
+		return func() tea.Msg { return err }
+	}
+	return nil
+}
+
+// Returns a command that waits for the next change to the targets.
+func (m *Model) nextTargetCmd() tea.Cmd {
+	return func() tea.Msg {
+		ev, ok := m.targetEvents.Next()
+		if !ok {
+			return nil
+		}
+		return targetMsg{event: ev}
+	}
+}
+
+// Returns a command that waits for the run to finish. It waits forever if the
+// run has no count or deadline.
+func (m *Model) finishedCmd() tea.Cmd {
+	finished := m.opts.Targets.Finished()
+	return func() tea.Msg {
+		<-finished
+		return finishedMsg{}
+	}
+}
+
+// Updates the table for a change to the targets.
+func (m *Model) updateTarget(ev targets.Event) tea.Cmd {
+	next := m.nextTargetCmd()
+	t := ev.Target
+	switch ev.Type {
+	case targets.Added:
+		cmd := m.addRowCmd(t.Key, t.Addr, t.Pinger)
+		if m.opts.PathMTU && !t.Replayed {
+			cmd = tea.Batch(cmd, m.discoverPathMTUCmd(t.Key, t.Addr, t.Backend, t.Source))
+		}
+		return tea.Batch(cmd, next)
+	case targets.Removed:
+		// Only remove the row if it hasn't already been replaced.
+		for _, tbl := range m.tabs.Tables() {
+			if r, ok := tbl.Row(t.Key); ok && r.Pinger == t.Pinger {
+				tbl.RemoveRow(t.Key)
+			}
+		}
+		m.tabs.Prune()
+	case targets.Failed:
+		return tea.Batch(func() tea.Msg { return ev.Err }, next)
+	case targets.PassComplete:
+		if ev.Reached {
+			delete(m.unreached, t.Key.Group)
 		} else {
-			cmds = append(cmds, m.startPingerCmd(table.RowKey{Group: h}, addr))
+			m.unreached[t.Key.Group] = true
+		}
+		for _, tbl := range m.tabs.Tables() {
+			tbl.SetUnreached(t.Key.Group, !ev.Reached)
 		}
 	}
-	return tea.Batch(cmds...)
+	return next
+}
+
+// Returns a command that resolves a host spec entered at runtime.
+func (m *Model) resolveHostCmd(spec string) tea.Cmd {
+	return func() tea.Msg {
+		host, err := targetlist.ParseSpec(spec)
+		if err != nil {
+			return err
+		}
+		addr, err := lookup.String(host.Host)
+		if err != nil {
+			log.Printf("Error looking up %q: %v", host.Host, err)
+			return nil
+		}
+		return hostResolvedMsg{host: host, addr: addr}
+	}
+}
+
+// Pauses or resumes a row's pinger.
+func (m *Model) togglePause(k table.RowKey) {
+	m.opts.Targets.TogglePause(k)
+	m.tabs.Active().UpdateRows()
 }
 
 // Update process an update message.
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
-	case traceStepMsg:
-		cmd = m.updateTraceStep(msg)
+	case targetMsg:
+		cmd = m.updateTarget(msg.event)
 	case updateRows:
 		cmd = m.updateRows(msg)
+	case refreshNames:
+		cmd = m.refreshNames()
+	case addhost.AddHostMsg:
+		cmd = m.resolveHostCmd(msg.Host)
+	case hostResolvedMsg:
+		cmd = m.addHostCmd(msg.host, msg.addr)
+	case table.RemoveRowMsg:
+		m.opts.Targets.Remove(msg.RowKey)
+	case table.PauseRowMsg:
+		m.togglePause(msg.RowKey)
+	case table.DetailMsg:
+		m.detail.SetRow(m.tabs.Active(), msg.RowKey)
+		cmd = nav.Go(nav.Detail)
+	case table.CycleThemeMsg:
+		m.cycleTheme()
+	case table.CycleHostsMsg:
+		m.cycleHostDisplay()
+	case columnselect.ChangedMsg:
+		cmd = m.saveColumnsCmd(msg.Visible)
+	case table.CopyMsg:
+		m.copy(msg.Text)
+	case table.LookupMsg:
+		cmd = m.lookupCmd(msg.Addr)
+	case table.LabelMsg:
+		m.label.Edit(msg.Addr, msg.Label)
+		cmd = nav.Go(nav.EditLabel)
+	case labeledit.SetLabelMsg:
+		m.setLabel(msg.Addr, msg.Label)
+	case pathMTUMsg:
+		m.tableFor(msg.key).SetPathMTU(msg.key, msg.mtu)
+	case asnMsg:
+		m.tableFor(msg.key).SetASN(msg.key, msg.asn)
+	case hostNameMsg:
+		for _, tbl := range m.tabs.Tables() {
+			tbl.SetDisplayHosts(func(r table.Row) string {
+				if util.IP(r.Addr).Equal(util.IP(msg.addr)) {
+					return msg.name
+				}
+				return r.DisplayHost
+			})
+		}
+	case finishedMsg:
+		log.Printf("Finished")
+		m.status.SetFinished(time.Now())
 	case tea.KeyMsg:
 		// Key messages are conditionally passed on by handleKeyMsg, so return
 		// here instead of unconditionally passing them on below.
 		return m, m.handleKeyMsg(msg)
+	case tea.MouseMsg:
+		// Only the table uses the mouse.
+		if m.focus != nav.Main {
+			return m, nil
+		}
+		return m, m.tabs.Update(msg)
 	case nav.GoMsg:
 		m.focus = msg.Screen
+		// The other screens work on the tab being shown.
+		m.sort.SetTable(m.tabs.Active())
+		m.columns.SetTable(m.tabs.Active())
 	case error:
 		cmd = m.handleError(msg)
 	}
 
 	cmds := append([]tea.Cmd{cmd},
-		m.table.Update(msg),
+		m.tabs.Update(m.tabsMsg(msg)),
 		m.sort.Update(msg),
+		m.columns.Update(msg),
+		m.addHost.Update(msg),
+		m.label.Update(msg),
+		m.detail.Update(msg),
+		m.logView.Update(msg),
+		m.status.Update(msg),
 	)
 	return m, tea.Batch(cmds...)
 }
 
+// Returns msg as the tabs should see it. They share the main screen with the
+// status bar.
+func (m *Model) tabsMsg(msg tea.Msg) tea.Msg {
+	if msg, ok := msg.(tea.WindowSizeMsg); ok {
+		msg.Height = max(0, msg.Height-m.status.Height())
+		return msg
+	}
+	return msg
+}
+
+// Switches to the next built-in theme. A theme loaded from a file comes
+// first in the cycle.
+func (m *Model) cycleTheme() {
+	themes := theme.Builtin()
+	if !slices.Contains(themes, m.opts.Theme) {
+		themes = append([]*theme.Theme{m.opts.Theme}, themes...)
+	}
+	i := slices.Index(themes, m.theme)
+	m.theme = themes[(i+1)%len(themes)]
+	m.tabs.SetTheme(m.theme)
+	m.sort.SetTheme(m.theme)
+	m.columns.SetTheme(m.theme)
+	m.addHost.SetTheme(m.theme)
+	m.label.SetTheme(m.theme)
+	m.detail.SetTheme(m.theme)
+	m.logView.SetTheme(m.theme)
+	m.status.SetTheme(m.theme)
+}
+
+// Switches every tab to the next way of showing hosts.
+func (m *Model) cycleHostDisplay() {
+	m.hostDisplay = m.hostDisplay.Next()
+	m.hostDisplayChanged = true
+	for _, tbl := range m.tabs.Tables() {
+		tbl.SetHostDisplay(m.hostDisplay)
+	}
+}
+
+// HostDisplay returns how hosts are being shown, for reports written after
+// the UI exits, and whether the user changed it from the default.
+func (m *Model) HostDisplay() (view.HostDisplay, bool) {
+	return m.hostDisplay, m.hostDisplayChanged
+}
+
+// Unresolved returns the hosts passed to [New] whose names couldn't be
+// resolved. Hosts added later aren't included.
+func (m *Model) Unresolved() []string {
+	return m.unresolved
+}
+
+// State returns the settings changed in the UI, to be restored next time.
+// Settings left as they were given in [Options] are unset, so that one-off
+// flags aren't saved. The sort order is the one on the tab being shown.
+// Columns aren't included, since [Options.SaveColumns] saves them.
+func (m *Model) State() *uistate.State {
+	s := &uistate.State{}
+	if sort := m.tabs.Active().Sort(); !slices.Equal(sort, m.initialSort) {
+		s.Sort = sort
+	}
+	if m.theme != m.opts.Theme && slices.Contains(theme.Builtin(), m.theme) {
+		s.Theme = m.theme.Name
+	}
+	if len(m.labels) > 0 {
+		s.Labels = m.labels
+	}
+	return s
+}
+
+// Labels the targets for an address. An empty label clears it. The rows pick
+// it up on the next update.
+func (m *Model) setLabel(addr net.Addr, label string) {
+	ip := util.IP(addr).String()
+	m.opts.Targets.SetLabel(ip, label)
+	if m.labels == nil {
+		m.labels = make(map[string]string)
+	}
+	m.labels[ip] = label
+}
+
+// Returns the table of the tab a row goes in.
+func (m *Model) tableFor(k table.RowKey) *table.Model {
+	return m.tabs.Table(m.tabFor(k))
+}
+
+// Gives every tab the columns chosen for the one being shown. Returns a
+// command that saves them, if there's somewhere to save them.
+func (m *Model) saveColumnsCmd(cols []table.ColumnID) tea.Cmd {
+	m.colSettings = m.tabs.Active().Columns()
+	for _, tbl := range m.tabs.Tables() {
+		tbl.SetColumns(m.colSettings)
+	}
+	if m.opts.SaveColumns == nil {
+		return nil
+	}
+	return func() tea.Msg {
+		if err := m.opts.SaveColumns(cols); err != nil {
+			log.Printf("Error saving columns: %v", err)
+		}
+		return nil
+	}
+}
+
+// Copies text to the clipboard with an OSC 52 escape sequence, which the
+// terminal handles, so this works over SSH too.
+func (m *Model) copy(text string) {
+	if _, err := io.WriteString(m.opts.Output, ansi.SetSystemClipboard(text)); err != nil {
+		log.Printf("Error copying to clipboard: %v", err)
+	}
+}
+
+// Returns a command that opens a web page about an address.
+func (m *Model) lookupCmd(addr net.Addr) tea.Cmd {
+	return func() tea.Msg {
+		url := fmt.Sprintf(m.opts.LookupURL, util.IP(addr))
+		if err := openURL(url); err != nil {
+			log.Printf("Error opening %v: %v", url, err)
+		}
+		return nil
+	}
+}
+
 func (m *Model) handleError(err error) tea.Cmd {
 	log.Panic(err)
 	return nil
 }
 
-// Returns a command that starts running a new ping.
-func (m *Model) startPingerCmd(key table.RowKey, target net.Addr) tea.Cmd {
-	ping, err := pinger.New(m.opts.PingBackend, util.AddrVersion(target), target, &pinger.Options{
-		Interval: m.opts.PingInterval,
-	})
-	if err != nil {
-		return func() tea.Msg { return err }
-	}
-	go ping.Run()
-	m.table.AddRow(
+// Adds a row for a pinger. Returns a command that fills in the row's details.
+func (m *Model) addRowCmd(key table.RowKey, target net.Addr, ping *pinger.Pinger) tea.Cmd {
+	name, refresh := lookup.Cached(target)
+	m.tableFor(key).AddRow(
 		table.Row{
 			RowKey:      key,
-			DisplayHost: lookup.Addr(target),
+			DisplayHost: name,
+			Addr:        target,
 			Pinger:      ping,
+			Baseline:    m.opts.Baseline.Lookup(key),
 		})
-	return nil
+	var cmds []tea.Cmd
+	if refresh {
+		cmds = append(cmds, m.lookupHostCmd(target))
+	}
+	if m.opts.ASN {
+		cmds = append(cmds, m.lookupASNCmd(key, target))
+	}
+	return tea.Batch(cmds...)
 }
 
-func (m *Model) startTraceCmd(addr net.Addr) tea.Cmd {
-	ch := make(chan tracer.Step)
-	return tea.Batch(
-		func() tea.Msg {
-			opts := &tracer.Options{
-				Interval:     m.opts.TraceInterval,
-				ProbesPerHop: m.opts.ProbesPerHop,
-				MaxTTL:       m.opts.TraceMaxTTL,
-			}
-			err := tracer.TraceRoute(m.opts.TraceBackend, util.AddrVersion(addr), addr, ch, opts)
-			if err != nil {
-				if errors.Is(err, tracer.ErrMaxTTL) {
-					log.Printf("Maximum TTL reached for %v", addr)
-					return nil
-				}
-				return fmt.Errorf("traceroute: %v: %v", addr, err)
+// Returns a command that finds the autonomous system a target is in.
+func (m *Model) lookupASNCmd(key table.RowKey, target net.Addr) tea.Cmd {
+	return func() tea.Msg {
+		info, err := asn.Lookup(target)
+		if err != nil {
+			if !errors.Is(err, asn.ErrNotFound) {
+				log.Printf("AS lookup for %v failed: %v", target, err)
 			}
 			return nil
-		},
-		m.nextTraceCmd(addr.String(), ch),
-	)
+		}
+		return asnMsg{key: key, asn: info.String()}
+	}
 }
 
-func (m *Model) nextTraceCmd(dest string, ch <-chan tracer.Step) tea.Cmd {
+// Returns a command that looks up the hostname for an address. This may be
+// slow, so rows display the IP address (or an older cached name) in the
+// meantime.
+func (m *Model) lookupHostCmd(target net.Addr) tea.Cmd {
 	return func() tea.Msg {
-		step, ok := <-ch
-		if !ok {
-			return nil
-		}
-		return traceStepMsg{
-			step: step,
-			host: dest,
-			next: ch,
-		}
+		return hostNameMsg{addr: target, name: lookup.Refresh(target)}
 	}
 }
 
-func (m *Model) updateTraceStep(msg traceStepMsg) tea.Cmd {
-	tea.Batch()
-	return tea.Batch(
-		m.startPingerCmd(table.RowKey{Index: msg.step.Pos, Group: msg.host}, msg.step.Host),
-		m.nextTraceCmd(msg.host, msg.next),
-	)
+// Returns a command that refreshes the names of all rows after
+// nameRefreshInterval.
+func (m *Model) refreshNamesCmd() tea.Cmd {
+	return tea.Tick(nameRefreshInterval, func(time.Time) tea.Msg {
+		return refreshNames{}
+	})
+}
+
+// Picks up names resolved elsewhere, and starts lookups for expired ones.
+func (m *Model) refreshNames() tea.Cmd {
+	cmds := []tea.Cmd{m.refreshNamesCmd()}
+	for _, tbl := range m.tabs.Tables() {
+		tbl.SetDisplayHosts(func(r table.Row) string {
+			name, refresh := lookup.Cached(r.Addr)
+			if refresh {
+				cmds = append(cmds, m.lookupHostCmd(r.Addr))
+			}
+			return name
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// Returns a command that finds the path MTU to a target with the backend and
+// source it's pinged with.
+func (m *Model) discoverPathMTUCmd(key table.RowKey, target net.Addr, be backend.Name, src backend.SourceOption) tea.Cmd {
+	return func() tea.Msg {
+		opts := &pathmtu.Options{Source: src}
+		mtu, err := pathmtu.Discover(be, util.AddrVersion(target), target, opts)
+		if err != nil {
+			log.Printf("Path MTU discovery for %v failed: %v", target, err)
+			return nil
+		}
+		return pathMTUMsg{key: key, mtu: mtu}
+	}
 }
 
 func (m *Model) updateRows(updateRows) tea.Cmd {
-	m.table.UpdateRows()
-	return tea.Tick(screenUpdateInterval, func(time.Time) tea.Msg {
+	var cmds []tea.Cmd
+	for _, tbl := range m.tabs.Tables() {
+		for _, r := range tbl.Rows() {
+			if t, ok := m.opts.Targets.Target(r.RowKey); ok {
+				if t.Alert != nil {
+					tbl.SetAlerting(r.RowKey, t.Alert.Firing())
+				}
+				tbl.SetLabel(r.RowKey, t.Label)
+				tbl.SetExtensions(r.RowKey, t.Extensions)
+				tbl.SetReturnHops(r.RowKey, t.ReturnHops)
+			}
+		}
+	}
+	m.tabs.Active().UpdateRows()
+	m.status.Refresh(time.Now())
+	cmds = append(cmds, tea.Tick(screenUpdateInterval, func(time.Time) tea.Msg {
 		return updateRows{}
-	})
+	}))
+	return tea.Batch(cmds...)
 }
 
 // Global key definitions. These apply to everything everywhere all the time.
@@ -228,9 +741,19 @@ func (m *Model) handleKeyMsg(msg tea.KeyMsg) tea.Cmd {
 
 	switch m.focus {
 	case nav.Main:
-		add(m.table.Update(msg))
+		add(m.tabs.Update(msg))
 	case nav.SortSelect:
 		add(m.sort.Update(msg))
+	case nav.ColumnSelect:
+		add(m.columns.Update(msg))
+	case nav.AddHost:
+		add(m.addHost.Update(msg))
+	case nav.EditLabel:
+		add(m.label.Update(msg))
+	case nav.Detail:
+		add(m.detail.Update(msg))
+	case nav.Log:
+		add(m.logView.Update(msg))
 	}
 
 	switch msg.String() {
@@ -250,11 +773,21 @@ func (m *Model) View() string {
 	var view string
 	switch m.focus {
 	case nav.Main:
-		view = m.table.View()
+		view = m.tabs.View() + "\n" + m.status.View()
 	case nav.SortSelect:
 		view = m.sort.View()
+	case nav.ColumnSelect:
+		view = m.columns.View()
+	case nav.AddHost:
+		view = m.addHost.View()
+	case nav.EditLabel:
+		view = m.label.View()
+	case nav.Detail:
+		view = m.detail.View()
+	case nav.Log:
+		view = m.logView.View()
 	default:
 		log.Panicf("Unhandled focus: %v", m.focus)
 	}
-	return m.opts.Theme.Base.Render(view)
+	return m.theme.Base.Render(view)
 }
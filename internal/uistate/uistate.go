@@ -0,0 +1,116 @@
+// Package uistate keeps the settings chosen in the text UI between runs: the
+// sort order, the theme and target labels. Only settings changed in the UI
+// are saved, on quit, and they're restored on launch. Command-line flags and
+// the config file both take precedence over the saved state. The columns
+// chosen in the UI are saved to the config file instead.
+//
+// The state is a JSON file, and isn't meant to be edited. Settings that belong
+// in every run go in the config file instead.
+package uistate
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pcekm/vasily/internal/tui/table"
+	"github.com/pcekm/vasily/internal/tui/theme"
+)
+
+// State holds the saved settings. Zero values are unset.
+type State struct {
+	// Sort is the sort order of the table.
+	Sort []table.SortColumn
+
+	// Theme is the name of a built-in theme. Themes loaded from files aren't
+	// saved.
+	Theme string
+
+	// Labels are the target labels edited in the UI, keyed by address. They
+	// take precedence over labels in the config file, and an empty one hides
+	// a label from there.
+	Labels map[string]string
+}
+
+// Update sets the settings that are set in changes, keeping the rest.
+func (s *State) Update(changes *State) {
+	if len(changes.Sort) > 0 {
+		s.Sort = changes.Sort
+	}
+	if changes.Theme != "" {
+		s.Theme = changes.Theme
+	}
+	if changes.Labels != nil {
+		s.Labels = changes.Labels
+	}
+}
+
+// The file format.
+type stateFile struct {
+	Sort   string            `json:"sort,omitempty"`
+	Theme  string            `json:"theme,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DefaultPath returns the path of the state file: state.json in the vasily
+// directory under $XDG_STATE_HOME, or ~/.local/state if that isn't set.
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "vasily", "state.json"), nil
+}
+
+// Load reads a state file. Returns an empty state if there isn't one.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &State{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	s := &State{Theme: f.Theme, Labels: f.Labels}
+	if s.Theme != "" {
+		if _, err := theme.Named(s.Theme); err != nil {
+			return nil, err
+		}
+	}
+	if s.Sort, err = table.ParseSort(f.Sort); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save writes a state file, replacing any that's there.
+func Save(path string, s *State) error {
+	f := stateFile{
+		Sort:   table.FormatSort(s.Sort),
+		Theme:  s.Theme,
+		Labels: s.Labels,
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Written to a temporary file first so an interrupted save doesn't
+	// clobber the old state.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,90 @@
+package uistate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/tui/table"
+)
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vasily", "state.json")
+	want := &State{
+		Sort:   []table.SortColumn{{ColumnID: table.ColPctLoss}, {ColumnID: table.ColAvgMs, Reverse: true}},
+		Theme:  "dark",
+		Labels: map[string]string{"192.0.2.1": "office router", "192.0.2.2": ""},
+	}
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong state (-want, +got):\n%v", diff)
+	}
+}
+
+func TestLoad_Missing(t *testing.T) {
+	got, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if diff := cmp.Diff(&State{}, got); diff != "" {
+		t.Errorf("Wrong state (-want, +got):\n%v", diff)
+	}
+}
+
+func TestLoad_Bad(t *testing.T) {
+	cases := map[string]string{
+		"json":  `{"sort": `,
+		"sort":  `{"sort": "nope"}`,
+		"theme": `{"theme": "nope"}`,
+	}
+	for name, data := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(path); err == nil {
+				t.Errorf("Load(%q) succeeded (want error)", data)
+			}
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s := &State{
+		Sort:   []table.SortColumn{{ColumnID: table.ColPctLoss}},
+		Theme:  "dark",
+		Labels: map[string]string{"192.0.2.1": "old"},
+	}
+	s.Update(&State{Theme: "light", Labels: map[string]string{"192.0.2.1": "new"}})
+	want := &State{
+		Sort:   []table.SortColumn{{ColumnID: table.ColPctLoss}},
+		Theme:  "light",
+		Labels: map[string]string{"192.0.2.1": "new"},
+	}
+	if diff := cmp.Diff(want, s); diff != "" {
+		t.Errorf("Wrong state (-want, +got):\n%v", diff)
+	}
+}
+
+func TestLoad_OldColumns(t *testing.T) {
+	// Columns used to be saved here, but now go in the config file.
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"columns": ["host"], "theme": "dark"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if diff := cmp.Diff(&State{Theme: "dark"}, got); diff != "" {
+		t.Errorf("Wrong state (-want, +got):\n%v", diff)
+	}
+}
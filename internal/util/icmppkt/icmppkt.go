@@ -3,8 +3,11 @@
 package icmppkt
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"syscall"
 
 	"github.com/pcekm/vasily/internal/backend"
@@ -18,8 +21,72 @@ import (
 const (
 	codePortUnreachableV4 = 3
 	codePortUnreachableV6 = 4
+	codeFragNeededV4      = 4
+
+	timestampLen   = 16
+	addressMaskLen = 8
 )
 
+var errUnhandledType = errors.New("unhandled ICMP type")
+
+// ICMPv4 address mask request and reply types (RFC 950). They're deprecated,
+// so x/net doesn't define them.
+const (
+	ICMPTypeAddressMask      ipv4.ICMPType = 17
+	ICMPTypeAddressMaskReply ipv4.ICMPType = 18
+)
+
+// Timestamp is the body of an ICMP timestamp request or reply. It implements
+// [icmp.MessageBody].
+type Timestamp struct {
+	ID  int
+	Seq int
+	backend.Timestamps
+}
+
+// Len implements [icmp.MessageBody].
+func (t *Timestamp) Len(int) int {
+	return timestampLen
+}
+
+// Marshal implements [icmp.MessageBody].
+func (t *Timestamp) Marshal(int) ([]byte, error) {
+	b := make([]byte, timestampLen)
+	binary.BigEndian.PutUint16(b[0:], uint16(t.ID))
+	binary.BigEndian.PutUint16(b[2:], uint16(t.Seq))
+	binary.BigEndian.PutUint32(b[4:], t.Originate)
+	binary.BigEndian.PutUint32(b[8:], t.Receive)
+	binary.BigEndian.PutUint32(b[12:], t.Transmit)
+	return b, nil
+}
+
+// AddressMask is the body of an ICMP address mask request or reply. It
+// implements [icmp.MessageBody].
+type AddressMask struct {
+	ID   int
+	Seq  int
+	Mask net.IPMask
+}
+
+// Len implements [icmp.MessageBody].
+func (a *AddressMask) Len(int) int {
+	return addressMaskLen
+}
+
+// Marshal implements [icmp.MessageBody]. A nil mask is sent as zeros.
+func (a *AddressMask) Marshal(int) ([]byte, error) {
+	b := make([]byte, addressMaskLen)
+	binary.BigEndian.PutUint16(b[0:], uint16(a.ID))
+	binary.BigEndian.PutUint16(b[2:], uint16(a.Seq))
+	if a.Mask != nil {
+		if len(a.Mask) != net.IPv4len {
+			return nil, fmt.Errorf("invalid address mask: %v", a.Mask)
+		}
+		copy(b[4:], a.Mask)
+	}
+	return b, nil
+}
+
 // Parse parses an ICMP packet.
 func Parse(ipVer util.IPVersion, buf []byte) (pkt *backend.Packet, id, proto int, err error) {
 	rm, err := icmp.ParseMessage(ipVer.ICMPProtoNum(), buf)
@@ -34,8 +101,14 @@ func Parse(ipVer util.IPVersion, buf []byte) (pkt *backend.Packet, id, proto int
 		return destUnreachableToPacket(ipVer, rm)
 	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
 		return timeExceededToPacket(ipVer, rm)
+	case ipv6.ICMPTypePacketTooBig:
+		return packetTooBigToPacket(ipVer, rm)
+	case ipv4.ICMPTypeTimestamp, ipv4.ICMPTypeTimestampReply:
+		return timestampToPacket(rm)
+	case ICMPTypeAddressMask, ICMPTypeAddressMaskReply:
+		return addressMaskToPacket(rm)
 	default:
-		return nil, -1, -1, fmt.Errorf("unhandled ICMP type: %v", rm.Type)
+		return nil, -1, -1, fmt.Errorf("%w: %v", errUnhandledType, rm.Type)
 	}
 }
 
@@ -57,6 +130,55 @@ func echoToPacket(msg *icmp.Message) (*backend.Packet, int, int, error) {
 	}, body.ID, msg.Type.Protocol(), nil
 }
 
+// Returns the data of a message that x/net/icmp doesn't know how to parse. It
+// must be exactly n bytes long.
+func rawBody(msg *icmp.Message, n int) ([]byte, error) {
+	body, ok := msg.Body.(*icmp.RawBody)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %v body: %T", msg.Type, msg.Body)
+	}
+	if len(body.Data) != n {
+		return nil, fmt.Errorf("wrong %v body length: %d (want %d)", msg.Type, len(body.Data), n)
+	}
+	return body.Data, nil
+}
+
+func timestampToPacket(msg *icmp.Message) (*backend.Packet, int, int, error) {
+	b, err := rawBody(msg, timestampLen)
+	if err != nil {
+		return nil, -1, -1, err
+	}
+	packetType := backend.PacketTimestampRequest
+	if msg.Type == ipv4.ICMPTypeTimestampReply {
+		packetType = backend.PacketTimestampReply
+	}
+	return &backend.Packet{
+		Type: packetType,
+		Seq:  int(binary.BigEndian.Uint16(b[2:])),
+		Timestamps: backend.Timestamps{
+			Originate: binary.BigEndian.Uint32(b[4:]),
+			Receive:   binary.BigEndian.Uint32(b[8:]),
+			Transmit:  binary.BigEndian.Uint32(b[12:]),
+		},
+	}, int(binary.BigEndian.Uint16(b[0:])), msg.Type.Protocol(), nil
+}
+
+func addressMaskToPacket(msg *icmp.Message) (*backend.Packet, int, int, error) {
+	b, err := rawBody(msg, addressMaskLen)
+	if err != nil {
+		return nil, -1, -1, err
+	}
+	packetType := backend.PacketAddressMaskRequest
+	if msg.Type == ICMPTypeAddressMaskReply {
+		packetType = backend.PacketAddressMaskReply
+	}
+	return &backend.Packet{
+		Type:        packetType,
+		Seq:         int(binary.BigEndian.Uint16(b[2:])),
+		AddressMask: net.IPMask(b[4:8]),
+	}, int(binary.BigEndian.Uint16(b[0:])), msg.Type.Protocol(), nil
+}
+
 func destUnreachableToPacket(ipVer util.IPVersion, msg *icmp.Message) (*backend.Packet, int, int, error) {
 	body := msg.Body.(*icmp.DstUnreach)
 	pkt, id, proto, err := ipBodyToPacket(ipVer, body.Data)
@@ -69,12 +191,44 @@ func destUnreachableToPacket(ipVer util.IPVersion, msg *icmp.Message) (*backend.
 		// destination, so this is a successful reply from a ping standpoint.
 		// The host was there and it answered.
 		pkt.Type = backend.PacketReply
+	} else if ipVer == util.IPv4 && msg.Code == codeFragNeededV4 {
+		pkt.Type = backend.PacketTooBig
 	} else {
 		pkt.Type = backend.PacketDestinationUnreachable
 	}
+	pkt.Extensions = convertExtensions(body.Extensions)
 	return pkt, id, proto, err
 }
 
+// Converts the extensions parsed by x/net/icmp. Returns nil if there are none
+// that are understood.
+func convertExtensions(exts []icmp.Extension) *backend.Extensions {
+	var res backend.Extensions
+	for _, ext := range exts {
+		switch ext := ext.(type) {
+		case *icmp.MPLSLabelStack:
+			for _, l := range ext.Labels {
+				res.MPLSLabels = append(res.MPLSLabels, backend.MPLSLabel(l))
+			}
+		case *icmp.InterfaceInfo:
+			info := backend.InterfaceInfo{Role: backend.InterfaceRole(ext.Type >> 6)}
+			if ifi := ext.Interface; ifi != nil {
+				info.Index = ifi.Index
+				info.Name = ifi.Name
+				info.MTU = ifi.MTU
+			}
+			if ext.Addr != nil {
+				info.Addr = ext.Addr.IP
+			}
+			res.Interfaces = append(res.Interfaces, info)
+		}
+	}
+	if res.MPLSLabels == nil && res.Interfaces == nil {
+		return nil
+	}
+	return &res
+}
+
 func timeExceededToPacket(ipVer util.IPVersion, msg *icmp.Message) (*backend.Packet, int, int, error) {
 	body := msg.Body.(*icmp.TimeExceeded)
 	pkt, id, proto, err := ipBodyToPacket(ipVer, body.Data)
@@ -82,6 +236,17 @@ func timeExceededToPacket(ipVer util.IPVersion, msg *icmp.Message) (*backend.Pac
 		return nil, -1, -1, err
 	}
 	pkt.Type = backend.PacketTimeExceeded
+	pkt.Extensions = convertExtensions(body.Extensions)
+	return pkt, id, proto, err
+}
+
+func packetTooBigToPacket(ipVer util.IPVersion, msg *icmp.Message) (*backend.Packet, int, int, error) {
+	body := msg.Body.(*icmp.PacketTooBig)
+	pkt, id, proto, err := ipBodyToPacket(ipVer, body.Data)
+	if err != nil {
+		return nil, -1, -1, err
+	}
+	pkt.Type = backend.PacketTooBig
 	return pkt, id, proto, err
 }
 
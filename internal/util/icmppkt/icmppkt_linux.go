@@ -31,49 +31,70 @@ import (
 
 // OOBBytes allocates enough bytes to fit a struct msghdr, struct
 // sock_extended_err, and struct sockaddr returned in the oob field of
-// [unix.Recvmsg].
+// [unix.Recvmsg], followed by the TTL or hop limit if the socket has
+// IP_RECVTTL or IPV6_RECVHOPLIMIT set, and a timestamp if it has
+// SO_TIMESTAMPNS set.
 func OOBBytes(ipVer util.IPVersion) []byte {
 	saSize := util.Choose(ipVer, C.sizeof_struct_sockaddr_in, C.sizeof_struct_sockaddr_in6)
-	return make([]byte, unix.CmsgSpace(int(C.sizeof_struct_sock_extended_err+saSize)))
+	return make([]byte, unix.CmsgSpace(int(C.sizeof_struct_sock_extended_err+saSize))+unix.CmsgSpace(4)+unix.CmsgSpace(C.sizeof_struct_timespec))
 }
 
 // ParseLinuxEE parses a linux struct sock_extended_err obtained with the
-// MSG_ERRQUEUE flag.
+// MSG_ERRQUEUE flag. The returned address is nil for locally-generated errors,
+// since there's no remote host responsible for them. The TTL is that of the
+// ICMP error, or zero if the socket wasn't set to receive it.
 //
 // Example:
 //
 //	buf := make([]byte, 1500)
 //	oob := OOBBytes(util.IPv4)
 //	n, oobn, _, _ err := unix.Recvmsg(fd, buf, oob, unix.MSG_ERRQUEUE)
-//	packet, peer, err := ParseLinuxEE(util.IPv4, buf[:n], oob[:oobn])
-func ParseLinuxEE(oob []byte) (backend.PacketType, net.Addr, error) {
+//	packet, peer, ttl, err := ParseLinuxEE(oob[:oobn])
+func ParseLinuxEE(oob []byte) (backend.PacketType, net.Addr, int, error) {
 	scms, err := unix.ParseSocketControlMessage(oob)
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, 0, err
 	}
-	if len(scms) != 1 {
-		return -1, nil, fmt.Errorf("expected exactly 1 control message (got %d)", len(scms))
+	var errMsg *unix.SocketControlMessage
+	var ttl int
+	for i, scm := range scms {
+		switch {
+		case isRecvErrMessage(scm):
+			if errMsg != nil {
+				return -1, nil, 0, fmt.Errorf("more than one error control message")
+			}
+			errMsg = &scms[i]
+		case isTTLMessage(scm):
+			ttl = decodeTTL(scm)
+		case isTimestampMessage(scm):
+			// Read separately by those who want it.
+		default:
+			return -1, nil, 0, fmt.Errorf("unexpected control header: %#v", scm.Header)
+		}
 	}
-	if !isRecvErrMessage(scms) {
-		return -1, nil, fmt.Errorf("unexpected control header: %#v", scms[0].Header)
+	if errMsg == nil {
+		return -1, nil, 0, fmt.Errorf("no error control message")
 	}
 
 	var extErr unix.SockExtendedErr
-	if _, err := binary.Decode(scms[0].Data, binary.NativeEndian, &extErr); err != nil {
-		return -1, nil, err
+	if _, err := binary.Decode(errMsg.Data, binary.NativeEndian, &extErr); err != nil {
+		return -1, nil, 0, err
 	}
 
 	pktType, err := packetType(extErr)
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, 0, err
+	}
+	if extErr.Origin == unix.SO_EE_ORIGIN_LOCAL {
+		return pktType, nil, 0, nil
 	}
 
-	peer, err := soEEOffender(scms[0].Data)
+	peer, err := soEEOffender(errMsg.Data)
 	if err != nil {
-		return -1, nil, err
+		return -1, nil, 0, err
 	}
 
-	return pktType, peer, nil
+	return pktType, peer, ttl, nil
 }
 
 // Extracts a sockaddr of what generated the error. This should be part of
@@ -103,14 +124,53 @@ func soEEOffender(b []byte) (net.Addr, error) {
 	return &addr, nil
 }
 
-func isRecvErrMessage(scms []unix.SocketControlMessage) bool {
-	h := scms[0].Header
+func isRecvErrMessage(scm unix.SocketControlMessage) bool {
+	h := scm.Header
 	return (h.Type == unix.IP_RECVERR && h.Level == unix.IPPROTO_IP) ||
 		(h.Type == unix.IPV6_RECVERR && h.Level == unix.IPPROTO_IPV6)
 }
 
+// ParseTTL returns the TTL or hop limit from control messages received on a
+// socket with IP_RECVTTL or IPV6_RECVHOPLIMIT set. Returns zero if there isn't
+// one.
+func ParseTTL(oob []byte) int {
+	scms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, scm := range scms {
+		if isTTLMessage(scm) {
+			return decodeTTL(scm)
+		}
+	}
+	return 0
+}
+
+func decodeTTL(scm unix.SocketControlMessage) int {
+	if len(scm.Data) < 4 {
+		return 0
+	}
+	return int(int32(binary.NativeEndian.Uint32(scm.Data)))
+}
+
+func isTimestampMessage(scm unix.SocketControlMessage) bool {
+	return scm.Header.Level == unix.SOL_SOCKET && scm.Header.Type == unix.SCM_TIMESTAMPNS
+}
+
+func isTTLMessage(scm unix.SocketControlMessage) bool {
+	h := scm.Header
+	return (h.Type == unix.IP_TTL && h.Level == unix.IPPROTO_IP) ||
+		(h.Type == unix.IPV6_HOPLIMIT && h.Level == unix.IPPROTO_IPV6)
+}
+
 func packetType(extErr unix.SockExtendedErr) (backend.PacketType, error) {
 	switch extErr.Origin {
+	case unix.SO_EE_ORIGIN_LOCAL:
+		// Sent with the don't fragment bit, and too big for the outgoing
+		// interface.
+		if unix.Errno(extErr.Errno) == unix.EMSGSIZE {
+			return backend.PacketTooBig, nil
+		}
 	case unix.SO_EE_ORIGIN_ICMP:
 		switch extErr.Type {
 		case byte(ipv4.ICMPTypeTimeExceeded):
@@ -118,6 +178,8 @@ func packetType(extErr unix.SockExtendedErr) (backend.PacketType, error) {
 		case byte(ipv4.ICMPTypeDestinationUnreachable):
 			if extErr.Code == codePortUnreachableV4 {
 				return backend.PacketReply, nil
+			} else if extErr.Code == codeFragNeededV4 {
+				return backend.PacketTooBig, nil
 			} else {
 				return backend.PacketDestinationUnreachable, nil
 			}
@@ -126,6 +188,8 @@ func packetType(extErr unix.SockExtendedErr) (backend.PacketType, error) {
 		switch extErr.Type {
 		case byte(ipv6.ICMPTypeTimeExceeded):
 			return backend.PacketTimeExceeded, nil
+		case byte(ipv6.ICMPTypePacketTooBig):
+			return backend.PacketTooBig, nil
 		case byte(ipv6.ICMPTypeDestinationUnreachable):
 			if extErr.Code == codePortUnreachableV6 {
 				return backend.PacketReply, nil
@@ -1,6 +1,7 @@
 package icmppkt
 
 import (
+	"encoding/binary"
 	"net"
 	"runtime"
 	"testing"
@@ -43,6 +44,16 @@ func makeOOB(origin byte, typ icmp.Type, code byte) []byte {
 	return nil
 }
 
+// Appends a TTL control message to oob. This has the same layout assumptions
+// as makeOOB.
+func withTTL(oob []byte, level, typ int32, ttl int32) []byte {
+	oob = binary.NativeEndian.AppendUint64(oob, uint64(unix.CmsgLen(4)))
+	oob = binary.NativeEndian.AppendUint32(oob, uint32(level))
+	oob = binary.NativeEndian.AppendUint32(oob, uint32(typ))
+	oob = binary.NativeEndian.AppendUint32(oob, uint32(ttl))
+	return append(oob, 0, 0, 0, 0)
+}
+
 // TODO: This test is brittle. It assumes a specific C struct layout, which may
 // or may not work on different hardware. (It definitely _won't_ work on 32-bit
 // or big-endian machines.
@@ -55,6 +66,7 @@ func TestParseLinuxEE(t *testing.T) {
 		In       []byte
 		WantType backend.PacketType
 		WantAddr net.IP
+		WantTTL  int
 	}{
 		{
 			Name:     "TimeExceeded/IPv4",
@@ -80,6 +92,18 @@ func TestParseLinuxEE(t *testing.T) {
 			WantType: backend.PacketReply,
 			WantAddr: net.ParseIP("2001:558:1014:6e3c::2"),
 		},
+		{
+			Name:     "FragmentationNeeded/IPv4",
+			In:       makeOOB(unix.SO_EE_ORIGIN_ICMP, ipv4.ICMPTypeDestinationUnreachable, codeFragNeededV4),
+			WantType: backend.PacketTooBig,
+			WantAddr: net.ParseIP("142.251.224.175"),
+		},
+		{
+			Name:     "PacketTooBig/IPv6",
+			In:       makeOOB(unix.SO_EE_ORIGIN_ICMP6, ipv6.ICMPTypePacketTooBig, 0),
+			WantType: backend.PacketTooBig,
+			WantAddr: net.ParseIP("2001:558:1014:6e3c::2"),
+		},
 		{
 			Name:     "HostUnreachable/IPv4",
 			In:       makeOOB(unix.SO_EE_ORIGIN_ICMP, ipv4.ICMPTypeDestinationUnreachable, 1),
@@ -92,10 +116,24 @@ func TestParseLinuxEE(t *testing.T) {
 			WantType: backend.PacketDestinationUnreachable,
 			WantAddr: net.ParseIP("2001:558:1014:6e3c::2"),
 		},
+		{
+			Name:     "TTL/IPv4",
+			In:       withTTL(makeOOB(unix.SO_EE_ORIGIN_ICMP, ipv4.ICMPTypeTimeExceeded, 0), unix.IPPROTO_IP, unix.IP_TTL, 250),
+			WantType: backend.PacketTimeExceeded,
+			WantAddr: net.ParseIP("142.251.224.175"),
+			WantTTL:  250,
+		},
+		{
+			Name:     "TTL/IPv6",
+			In:       withTTL(makeOOB(unix.SO_EE_ORIGIN_ICMP6, ipv6.ICMPTypeTimeExceeded, 0), unix.IPPROTO_IPV6, unix.IPV6_HOPLIMIT, 61),
+			WantType: backend.PacketTimeExceeded,
+			WantAddr: net.ParseIP("2001:558:1014:6e3c::2"),
+			WantTTL:  61,
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
-			pktType, peer, err := ParseLinuxEE(c.In)
+			pktType, peer, ttl, err := ParseLinuxEE(c.In)
 			if err != nil {
 				t.Fatalf("ParseLinuxEE error: %v", err)
 			}
@@ -105,6 +143,9 @@ func TestParseLinuxEE(t *testing.T) {
 			if !util.IP(peer).Equal(c.WantAddr) {
 				t.Errorf("Wrong address: %v (want %v)", peer, c.WantAddr)
 			}
+			if ttl != c.WantTTL {
+				t.Errorf("Wrong TTL: %d (want %d)", ttl, c.WantTTL)
+			}
 		})
 	}
 }
@@ -131,6 +131,38 @@ func TestPackets(t *testing.T) {
 			WantId:    1,
 			WantProto: syscall.IPPROTO_ICMPV6,
 		},
+		{
+			Name:      "ICMP/TimestampRequest",
+			IPVersion: util.IPv4,
+			In:        &icmp.Message{Type: ipv4.ICMPTypeTimestamp, Body: &Timestamp{ID: 1, Seq: 2, Timestamps: backend.Timestamps{Originate: 3}}},
+			WantPkt:   &backend.Packet{Type: backend.PacketTimestampRequest, Seq: 2, Timestamps: backend.Timestamps{Originate: 3}},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMP,
+		},
+		{
+			Name:      "ICMP/TimestampReply",
+			IPVersion: util.IPv4,
+			In:        &icmp.Message{Type: ipv4.ICMPTypeTimestampReply, Body: &Timestamp{ID: 1, Seq: 2, Timestamps: backend.Timestamps{Originate: 3, Receive: 4, Transmit: 0x80000005}}},
+			WantPkt:   &backend.Packet{Type: backend.PacketTimestampReply, Seq: 2, Timestamps: backend.Timestamps{Originate: 3, Receive: 4, Transmit: 0x80000005}},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMP,
+		},
+		{
+			Name:      "ICMP/AddressMaskRequest",
+			IPVersion: util.IPv4,
+			In:        &icmp.Message{Type: ICMPTypeAddressMask, Body: &AddressMask{ID: 1, Seq: 2}},
+			WantPkt:   &backend.Packet{Type: backend.PacketAddressMaskRequest, Seq: 2, AddressMask: net.IPv4Mask(0, 0, 0, 0)},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMP,
+		},
+		{
+			Name:      "ICMP/AddressMaskReply",
+			IPVersion: util.IPv4,
+			In:        &icmp.Message{Type: ICMPTypeAddressMaskReply, Body: &AddressMask{ID: 1, Seq: 2, Mask: net.CIDRMask(24, 32)}},
+			WantPkt:   &backend.Packet{Type: backend.PacketAddressMaskReply, Seq: 2, AddressMask: net.CIDRMask(24, 32)},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMP,
+		},
 		{
 			Name:      "ICMP/TimeExceeded",
 			IPVersion: util.IPv4,
@@ -163,6 +195,22 @@ func TestPackets(t *testing.T) {
 			WantId:    1,
 			WantProto: syscall.IPPROTO_ICMPV6,
 		},
+		{
+			Name:      "ICMP/FragmentationNeeded",
+			IPVersion: util.IPv4,
+			In:        &icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Code: codeFragNeededV4, Body: &icmp.DstUnreach{Data: echoReply(t, util.IPv4, 1, 2, []byte{3, 4, 5})}},
+			WantPkt:   &backend.Packet{Type: backend.PacketTooBig, Seq: 2, Payload: []byte{3, 4, 5}},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMP,
+		},
+		{
+			Name:      "ICMP/PacketTooBig",
+			IPVersion: util.IPv6,
+			In:        &icmp.Message{Type: ipv6.ICMPTypePacketTooBig, Body: &icmp.PacketTooBig{MTU: 1280, Data: echoReply(t, util.IPv6, 1, 2, []byte{3, 4, 5})}},
+			WantPkt:   &backend.Packet{Type: backend.PacketTooBig, Seq: 2, Payload: []byte{3, 4, 5}},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_ICMPV6,
+		},
 		{
 			Name:      "UDP/TimeExceeded",
 			IPVersion: util.IPv4,
@@ -179,6 +227,49 @@ func TestPackets(t *testing.T) {
 			WantId:    1,
 			WantProto: syscall.IPPROTO_UDP,
 		},
+		{
+			Name:      "UDP/TimeExceeded/Extensions",
+			IPVersion: util.IPv4,
+			In: &icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{
+				Data: udpPing(t, util.IPv4, 1, 2, make([]byte, 100)),
+				Extensions: []icmp.Extension{
+					&icmp.MPLSLabelStack{Labels: []icmp.MPLSLabel{{Label: 24001, TTL: 1}, {Label: 3, TC: 5, S: true, TTL: 1}}},
+					&icmp.InterfaceInfo{
+						Class:     2,
+						Type:      0x8f, // Outgoing interface with all attributes.
+						Interface: &net.Interface{Index: 7, Name: "xe-0/0/1", MTU: 9000},
+						Addr:      &net.IPAddr{IP: net.IPv4(192, 0, 2, 9).To4()},
+					},
+				},
+			}},
+			WantPkt: &backend.Packet{
+				Type:    backend.PacketTimeExceeded,
+				Seq:     2,
+				Payload: make([]byte, 100),
+				Extensions: &backend.Extensions{
+					MPLSLabels: []backend.MPLSLabel{{Label: 24001, TTL: 1}, {Label: 3, TC: 5, S: true, TTL: 1}},
+					Interfaces: []backend.InterfaceInfo{{Role: backend.OutgoingInterface, Index: 7, Addr: net.IPv4(192, 0, 2, 9).To4(), Name: "xe-0/0/1", MTU: 9000}},
+				},
+			},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_UDP,
+		},
+		{
+			Name:      "UDP/TimeExceeded/Extensions",
+			IPVersion: util.IPv6,
+			In: &icmp.Message{Type: ipv6.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{
+				Data:       udpPing(t, util.IPv6, 1, 2, make([]byte, 80)),
+				Extensions: []icmp.Extension{&icmp.MPLSLabelStack{Labels: []icmp.MPLSLabel{{Label: 16, S: true, TTL: 254}}}},
+			}},
+			WantPkt: &backend.Packet{
+				Type:       backend.PacketTimeExceeded,
+				Seq:        2,
+				Payload:    make([]byte, 80),
+				Extensions: &backend.Extensions{MPLSLabels: []backend.MPLSLabel{{Label: 16, S: true, TTL: 254}}},
+			},
+			WantId:    1,
+			WantProto: syscall.IPPROTO_UDP,
+		},
 		{
 			Name:      "UDP/DestinationUnreachable",
 			IPVersion: util.IPv4,
@@ -235,3 +326,25 @@ func TestPackets(t *testing.T) {
 	}
 
 }
+
+func TestParseShortBody(t *testing.T) {
+	cases := []struct {
+		Name string
+		Type ipv4.ICMPType
+	}{
+		{Name: "Timestamp", Type: ipv4.ICMPTypeTimestampReply},
+		{Name: "AddressMask", Type: ICMPTypeAddressMaskReply},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			msg := &icmp.Message{Type: c.Type, Body: &icmp.RawBody{Data: []byte{0, 1, 0, 2, 3}}}
+			buf, err := msg.Marshal(nil)
+			if err != nil {
+				t.Fatalf("Marshal error: %v", err)
+			}
+			if pkt, _, _, err := Parse(util.IPv4, buf); err == nil {
+				t.Errorf("Parse succeeded: %v (want error)", pkt)
+			}
+		})
+	}
+}
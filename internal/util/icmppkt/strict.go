@@ -0,0 +1,173 @@
+package icmppkt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// The least of the original datagram's payload that an ICMP error must quote.
+// RFC 792 asks for 64 bits. RFC 4443 asks for as much as fits, which is never
+// less.
+const minQuotedPayload = 8
+
+// ParseErrorReason is why [ParseStrict] rejected a packet.
+type ParseErrorReason int
+
+// Values for ParseErrorReason.
+const (
+	// BadChecksum means the ICMP checksum was wrong.
+	BadChecksum ParseErrorReason = iota
+
+	// ShortQuote means an ICMP error quoted less of the original datagram
+	// than the RFCs require.
+	ShortQuote
+
+	// Unsupported means the packet was a type that isn't handled.
+	Unsupported
+
+	// Malformed means the packet couldn't be parsed.
+	Malformed
+
+	numParseErrorReasons
+)
+
+func (r ParseErrorReason) String() string {
+	switch r {
+	case BadChecksum:
+		return "bad checksum"
+	case ShortQuote:
+		return "short quote"
+	case Unsupported:
+		return "unsupported"
+	case Malformed:
+		return "malformed"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(r))
+	}
+}
+
+// ParseError is returned by [ParseStrict] for a packet it rejects.
+type ParseError struct {
+	Reason ParseErrorReason
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v ICMP packet: %v", e.Reason, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Packets rejected by ParseStrict, indexed by reason.
+var parseErrors [numParseErrorReasons]atomic.Int64
+
+// ParseErrorCounts returns how many packets [ParseStrict] has rejected, by
+// reason. Reasons that haven't come up are left out.
+func ParseErrorCounts() map[ParseErrorReason]int64 {
+	res := make(map[ParseErrorReason]int64)
+	for r := range numParseErrorReasons {
+		if n := parseErrors[r].Load(); n > 0 {
+			res[r] = n
+		}
+	}
+	return res
+}
+
+// Counts and returns a rejection.
+func reject(reason ParseErrorReason, err error) *ParseError {
+	parseErrors[reason].Add(1)
+	return &ParseError{Reason: reason, Err: err}
+}
+
+// ParseStrict is like [Parse], but checks the packet more carefully first.
+// ICMPv4 checksums must be right. (ICMPv6 checksums cover a pseudo-header with
+// addresses that aren't available here. The kernel checks them anyway.) ICMP
+// errors must quote the whole IP header of the original datagram and at least
+// 8 bytes of what followed it. Rejected packets return a [*ParseError], and are
+// counted rather than logged, since a misbehaving network could send a lot of
+// them. See [ParseErrorCounts].
+func ParseStrict(ipVer util.IPVersion, buf []byte) (pkt *backend.Packet, id, proto int, err error) {
+	if err := checkStrict(ipVer, buf); err != nil {
+		return nil, -1, -1, err
+	}
+	pkt, id, proto, err = Parse(ipVer, buf)
+	if errors.Is(err, errUnhandledType) {
+		return nil, -1, -1, reject(Unsupported, err)
+	}
+	if err != nil {
+		return nil, -1, -1, reject(Malformed, err)
+	}
+	return pkt, id, proto, nil
+}
+
+func checkStrict(ipVer util.IPVersion, buf []byte) *ParseError {
+	// Type, code, checksum, and four bytes that depend on the type.
+	if len(buf) < 8 {
+		return reject(Malformed, fmt.Errorf("too short: %d bytes", len(buf)))
+	}
+	if ipVer == util.IPv4 && checksum(buf) != 0 {
+		return reject(BadChecksum, fmt.Errorf("checksum %#04x", binary.BigEndian.Uint16(buf[2:])))
+	}
+
+	var quoteLen, ipHeaderLen int
+	switch ipVer {
+	case util.IPv4:
+		switch ipv4.ICMPType(buf[0]) {
+		case ipv4.ICMPTypeDestinationUnreachable, ipv4.ICMPTypeTimeExceeded:
+		default:
+			return nil
+		}
+		// RFC 4884 puts the quote's length in 32-bit words here when
+		// extensions follow it.
+		quoteLen = 4 * int(buf[5])
+		ipHeaderLen = ipv4.HeaderLen
+		if len(buf) > 8 {
+			ipHeaderLen = max(ipHeaderLen, 4*int(buf[8]&0x0f))
+		}
+	case util.IPv6:
+		switch ipv6.ICMPType(buf[0]) {
+		case ipv6.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeTimeExceeded:
+			quoteLen = 8 * int(buf[4]) // In 64-bit words for ICMPv6.
+		case ipv6.ICMPTypePacketTooBig:
+		default:
+			return nil
+		}
+		ipHeaderLen = ipv6.HeaderLen
+	}
+	quote := buf[8:]
+	if quoteLen > 0 {
+		if quoteLen > len(quote) {
+			return reject(Malformed, fmt.Errorf("quote length %d exceeds packet", quoteLen))
+		}
+		quote = quote[:quoteLen]
+	}
+	if len(quote) < ipHeaderLen+minQuotedPayload {
+		return reject(ShortQuote, fmt.Errorf("quoted %d bytes (want at least %d)", len(quote), ipHeaderLen+minQuotedPayload))
+	}
+	return nil
+}
+
+// Returns the ones' complement of the ones' complement sum of b, which is
+// zero if b holds a correct checksum.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,94 @@
+package icmppkt
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pcekm/vasily/internal/util"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestParseStrict(t *testing.T) {
+	marshal := func(msg *icmp.Message) []byte {
+		t.Helper()
+		buf, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatalf("Marshal error: %v", err)
+		}
+		return buf
+	}
+	echo := marshal(&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: 2, Data: []byte{3, 4, 5}}})
+	badChecksum := append([]byte{}, echo...)
+	badChecksum[2]++
+	quoteV4 := echoReply(t, util.IPv4, 1, 2, nil)
+	quoteV6 := echoReply(t, util.IPv6, 1, 2, nil)
+
+	cases := []struct {
+		Name string
+		util.IPVersion
+		In   []byte
+		Want ParseErrorReason
+		OK   bool
+	}{
+		{Name: "Echo", IPVersion: util.IPv4, In: echo, OK: true},
+		{Name: "BadChecksum", IPVersion: util.IPv4, In: badChecksum, Want: BadChecksum},
+		{Name: "TooShort", IPVersion: util.IPv4, In: echo[:6], Want: Malformed},
+		{
+			Name:      "TimeExceeded",
+			IPVersion: util.IPv4,
+			In:        marshal(&icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoteV4}}),
+			OK:        true,
+		},
+		{
+			Name:      "TimeExceeded/ShortQuote",
+			IPVersion: util.IPv4,
+			In:        marshal(&icmp.Message{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoteV4[:ipv4.HeaderLen+4]}}),
+			Want:      ShortQuote,
+		},
+		{
+			Name:      "TimeExceeded",
+			IPVersion: util.IPv6,
+			In:        marshal(&icmp.Message{Type: ipv6.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: quoteV6}}),
+			OK:        true,
+		},
+		{
+			Name:      "PacketTooBig/ShortQuote",
+			IPVersion: util.IPv6,
+			In:        marshal(&icmp.Message{Type: ipv6.ICMPTypePacketTooBig, Body: &icmp.PacketTooBig{MTU: 1280, Data: quoteV6[:ipv6.HeaderLen]}}),
+			Want:      ShortQuote,
+		},
+		{
+			Name:      "Unsupported",
+			IPVersion: util.IPv4,
+			In:        marshal(&icmp.Message{Type: ipv4.ICMPTypeRedirect, Body: &icmp.RawBody{Data: make([]byte, 32)}}),
+			Want:      Unsupported,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name+"/"+c.IPVersion.String(), func(t *testing.T) {
+			before := ParseErrorCounts()
+			_, _, _, err := ParseStrict(c.IPVersion, c.In)
+			if c.OK {
+				if err != nil {
+					t.Errorf("ParseStrict error: %v", err)
+				}
+			} else {
+				var perr *ParseError
+				if !errors.As(err, &perr) || perr.Reason != c.Want {
+					t.Errorf("ParseStrict error: %v (want %v)", err, c.Want)
+				}
+			}
+
+			want := before
+			if !c.OK {
+				want[c.Want]++
+			}
+			if diff := cmp.Diff(want, ParseErrorCounts()); diff != "" {
+				t.Errorf("Wrong error counts (-want, +got):\n%v", diff)
+			}
+		})
+	}
+}
@@ -37,7 +37,7 @@ func (g *idGen) GenID() int {
 	g.Lock()
 	defer g.Unlock()
 	defer func() {
-		g.nextID++
+		g.nextID = (g.nextID + 1) % numSequenceNos
 	}()
 	return g.nextID
 }
@@ -139,3 +139,22 @@ func Port(addr net.Addr) int {
 	}
 	return 0
 }
+
+// Initial TTLs commonly used by operating systems, in increasing order.
+var initialTTLs = []int{32, 64, 128, 255}
+
+// HopDistance estimates how many hops away the sender of a packet that
+// arrived with the given TTL or hop limit is. It assumes the packet started
+// with the smallest common initial TTL that's at least the received one.
+// Returns false if the TTL is unknown.
+func HopDistance(ttl int) (int, bool) {
+	if ttl <= 0 {
+		return 0, false
+	}
+	for _, init := range initialTTLs {
+		if ttl <= init {
+			return init - ttl, true
+		}
+	}
+	return 0, false
+}
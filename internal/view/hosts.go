@@ -0,0 +1,56 @@
+package view
+
+import "fmt"
+
+// HostDisplay is how hosts are shown: by name, by address, or both.
+type HostDisplay int
+
+// Values for HostDisplay.
+const (
+	// HostName shows a host's name, or its address if it has none.
+	HostName HostDisplay = iota
+
+	// HostAddr shows a host's address.
+	HostAddr
+
+	// HostBoth shows a host's name followed by its address.
+	HostBoth
+)
+
+func (d HostDisplay) String() string {
+	switch d {
+	case HostName:
+		return "name"
+	case HostAddr:
+		return "address"
+	case HostBoth:
+		return "both"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(d))
+	}
+}
+
+// Next returns the display after d, cycling back to the first.
+func (d HostDisplay) Next() HostDisplay {
+	return (d + 1) % (HostBoth + 1)
+}
+
+// Format returns what's shown for a host with a name and an address. Either
+// may be empty if it's unknown, and a name that's just the address is shown
+// once.
+func (d HostDisplay) Format(name, addr string) string {
+	switch {
+	case name == "":
+		return addr
+	case addr == "" || name == addr:
+		return name
+	}
+	switch d {
+	case HostAddr:
+		return addr
+	case HostBoth:
+		return fmt.Sprintf("%s (%s)", name, addr)
+	default:
+		return name
+	}
+}
@@ -0,0 +1,41 @@
+package view
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHostDisplay_Format(t *testing.T) {
+	cases := []struct {
+		d          HostDisplay
+		name, addr string
+		want       string
+	}{
+		{d: HostName, name: "router.example", addr: "192.0.2.1", want: "router.example"},
+		{d: HostAddr, name: "router.example", addr: "192.0.2.1", want: "192.0.2.1"},
+		{d: HostBoth, name: "router.example", addr: "192.0.2.1", want: "router.example (192.0.2.1)"},
+		{d: HostName, name: "192.0.2.1", addr: "192.0.2.1", want: "192.0.2.1"},
+		{d: HostBoth, name: "192.0.2.1", addr: "192.0.2.1", want: "192.0.2.1"},
+		{d: HostBoth, addr: "192.0.2.1", want: "192.0.2.1"},
+		{d: HostAddr, name: "router.example", want: "router.example"},
+	}
+	for _, c := range cases {
+		if got := c.d.Format(c.name, c.addr); got != c.want {
+			t.Errorf("%v.Format(%q, %q) = %q (want %q)", c.d, c.name, c.addr, got, c.want)
+		}
+	}
+}
+
+func TestHostDisplay_Next(t *testing.T) {
+	var got []HostDisplay
+	d := HostName
+	for range 4 {
+		d = d.Next()
+		got = append(got, d)
+	}
+	want := []HostDisplay{HostAddr, HostBoth, HostName, HostAddr}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrong cycle (-want, +got):\n%v", diff)
+	}
+}
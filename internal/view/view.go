@@ -0,0 +1,107 @@
+// Package view derives what's displayed about each target from its results,
+// so that the text UI, the control API and the web dashboard all show the
+// same thing.
+package view
+
+import (
+	"net"
+
+	"github.com/pcekm/vasily/internal/baseline"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+// Options control how rows are derived.
+type Options struct {
+	// Baseline holds saved latencies to compare each target against, or nil
+	// for none.
+	Baseline *baseline.Baseline
+
+	// BaselineThreshold is the fraction a target's latency must rise above
+	// its baseline by to count as a regression.
+	BaselineThreshold float64
+}
+
+// Row is a snapshot of a single target.
+type Row struct {
+	targets.Key
+
+	// Host is the name to display for the target: its cached host name, or
+	// its address if there isn't one.
+	Host string
+
+	// Addr is the address being pinged.
+	Addr net.Addr
+
+	// Label is the target's free-text label, or empty if it has none.
+	Label string
+
+	// Paused is true if pings to the target are paused.
+	Paused bool
+
+	// Alerting is true while an alert rule for the target is firing.
+	Alerting bool
+
+	// Stats are the target's ping statistics.
+	Stats pinger.Stats
+
+	// Hops is the target's estimated distance in hops, from the most recent
+	// successful reply. It's -1 if unknown.
+	Hops int
+
+	// Outages is the number of outages recorded for the target.
+	Outages int
+
+	// Baseline compares the target's latency to its saved baseline. Nil if
+	// there's no baseline for it, or no successful pings yet.
+	Baseline *baseline.Comparison
+}
+
+// FromTarget takes a snapshot of a target. Opts may be nil.
+func FromTarget(t targets.Target, opts *Options) Row {
+	if opts == nil {
+		opts = &Options{}
+	}
+	name, _ := lookup.Cached(t.Addr)
+	r := Row{
+		Key:     t.Key,
+		Host:    name,
+		Addr:    t.Addr,
+		Label:   t.Label,
+		Paused:  t.Pinger.Paused(),
+		Stats:   t.Pinger.Stats(),
+		Outages: len(t.Pinger.Events()),
+	}
+	if t.Alert != nil {
+		r.Alerting = t.Alert.Firing()
+	}
+	var ok bool
+	if r.Hops, ok = HopDistance(t.Pinger); !ok {
+		r.Hops = -1
+	}
+	if c, ok := baseline.Compare(opts.Baseline.Lookup(t.Key), r.Stats, opts.BaselineThreshold); ok {
+		r.Baseline = &c
+	}
+	return r
+}
+
+// FromTargets takes a snapshot of each target, in the same order.
+func FromTargets(ts []targets.Target, opts *Options) []Row {
+	res := make([]Row, 0, len(ts))
+	for _, t := range ts {
+		res = append(res, FromTarget(t, opts))
+	}
+	return res
+}
+
+// HopDistance returns the estimated hop distance from the most recent
+// successful reply. Returns false if it's unknown.
+func HopDistance(p *pinger.Pinger) (int, bool) {
+	for _, r := range p.RevResults() {
+		if r.Type == pinger.Success {
+			return r.HopDistance()
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+package view
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pcekm/vasily/internal/baseline"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+func TestFromTargets(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	a := targets.Key{Group: "a.example"}
+	b := targets.Key{Group: "b.example"}
+	m.Feed(a, addr, 0, pinger.PingResult{Type: pinger.Success, Latency: 30 * time.Millisecond, TTL: 60})
+	m.Feed(a, addr, 1, pinger.PingResult{Type: pinger.Dropped})
+	m.Feed(b, addr, 0, pinger.PingResult{Type: pinger.Success, Latency: 10 * time.Millisecond})
+	opts := &Options{
+		Baseline:          &baseline.Baseline{Entries: []baseline.Entry{{Group: "a.example", Avg: 20 * time.Millisecond, P95: 30 * time.Millisecond}}},
+		BaselineThreshold: 0.2,
+	}
+
+	got := FromTargets(m.Targets(), opts)
+	want := []Row{
+		{
+			Key:      a,
+			Host:     "192.0.2.1",
+			Addr:     addr,
+			Hops:     4,
+			Baseline: &baseline.Comparison{AvgDelta: 10 * time.Millisecond, Regressed: true},
+		},
+		{Key: b, Host: "192.0.2.1", Addr: addr, Hops: -1},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Row{}, "Stats", "Outages"), cmpopts.IgnoreUnexported(net.UDPAddr{})); diff != "" {
+		t.Errorf("Wrong rows (-want, +got):\n%v", diff)
+	}
+	if len(got) > 0 && (got[0].Stats.N != 2 || got[0].Stats.Failures != 1) {
+		t.Errorf("Stats = %+v (want 2 pings with 1 failure)", got[0].Stats)
+	}
+}
@@ -0,0 +1,140 @@
+// Draws the table from the updates sent over the websocket, reconnecting if
+// the connection drops.
+"use strict";
+
+const reconnectDelay = 2000;
+
+const status = document.getElementById("status");
+const tbody = document.getElementById("rows");
+
+function connect() {
+  const proto = location.protocol === "https:" ? "wss:" : "ws:";
+  const ws = new WebSocket(`${proto}//${location.host}/ws`);
+  ws.onopen = () => setStatus("Connected", false);
+  ws.onmessage = (ev) => render(JSON.parse(ev.data));
+  ws.onclose = () => {
+    setStatus("Disconnected. Reconnecting…", true);
+    setTimeout(connect, reconnectDelay);
+  };
+}
+
+function setStatus(text, error) {
+  status.textContent = text;
+  status.classList.toggle("error", error);
+}
+
+// Gathers the hops of each traced path under a header line, like the text
+// UI. The header shows the last hop's stats.
+function layout(rows) {
+  const lines = [];
+  const groups = new Map();
+  for (const r of rows) {
+    if (r.index === 0) {
+      lines.push(r);
+      continue;
+    }
+    if (!groups.has(r.group)) {
+      const header = { header: true, group: r.group, hops: [] };
+      groups.set(r.group, header);
+      lines.push(header);
+    }
+    groups.get(r.group).hops.push(r);
+  }
+  const res = [];
+  for (const l of lines) {
+    if (!l.header) {
+      res.push(l);
+      continue;
+    }
+    const last = l.hops[l.hops.length - 1];
+    res.push({ ...last, header: true, index: 0, host: l.group, addr: "" });
+    res.push(...l.hops);
+  }
+  return res;
+}
+
+function render(update) {
+  const showDelta = update.rows.some((r) => r.delta_ms !== undefined);
+  for (const el of document.querySelectorAll(".delta")) {
+    el.classList.toggle("hidden", !showDelta);
+  }
+  const max = Math.max(1, ...update.rows.flatMap((r) => (r.results || []).filter((v) => v !== null)));
+  tbody.replaceChildren(...layout(update.rows).map((r) => renderRow(r, max, showDelta)));
+}
+
+function cell(text, cls) {
+  const td = document.createElement("td");
+  td.textContent = text;
+  if (cls) {
+    td.className = cls;
+  }
+  return td;
+}
+
+function renderRow(r, max, showDelta) {
+  const tr = document.createElement("tr");
+  if (r.header) {
+    tr.className = "group";
+  }
+  if (r.alerting) {
+    tr.classList.add("alerting");
+  }
+  let host = r.host;
+  if (r.addr && r.addr !== r.host) {
+    host += ` (${r.addr})`;
+  }
+  if (r.label) {
+    host += ` [${r.label}]`;
+  }
+  if (r.paused) {
+    host += " (paused)";
+  }
+  tr.append(
+    cell(r.index > 0 ? r.index : "", "num"),
+    cell(host),
+    graphCell(r.results || [], max),
+    cell(r.avg_ms.toFixed(0), "num"),
+    cell(r.p95_ms.toFixed(0), "num"),
+    cell(r.jitter_ms.toFixed(0), "num"),
+    cell(`${(100 * r.loss).toFixed(0)}%`, "num"),
+  );
+  if (showDelta) {
+    let delta = "";
+    if (r.delta_ms !== undefined) {
+      delta = (r.delta_ms >= 0 ? "+" : "") + r.delta_ms.toFixed(0);
+    }
+    tr.append(cell(delta, r.regressed ? "num delta regressed" : "num delta"));
+  }
+  return tr;
+}
+
+// Draws a bar for each result, scaled to the highest latency in the table.
+// Failures are full-height bars in the error color.
+function graphCell(results, max) {
+  const ns = "http://www.w3.org/2000/svg";
+  const svg = document.createElementNS(ns, "svg");
+  svg.setAttribute("class", "graph");
+  const width = Math.max(60, results.length);
+  svg.setAttribute("viewBox", `0 0 ${width} 10`);
+  svg.setAttribute("preserveAspectRatio", "none");
+  const offset = width - results.length;
+  results.forEach((v, i) => {
+    const bar = document.createElementNS(ns, "rect");
+    const h = v === null ? 10 : Math.max(0.5, (10 * v) / max);
+    bar.setAttribute("x", offset + i);
+    bar.setAttribute("y", 10 - h);
+    bar.setAttribute("width", 0.8);
+    bar.setAttribute("height", h);
+    if (v === null) {
+      bar.setAttribute("class", "fail");
+    } else {
+      bar.setAttribute("fill", `hsl(${120 - 120 * Math.min(1, v / max)}, 70%, 60%)`);
+    }
+    svg.append(bar);
+  });
+  const td = document.createElement("td");
+  td.append(svg);
+  return td;
+}
+
+connect();
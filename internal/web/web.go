@@ -0,0 +1,262 @@
+// Package web serves a live dashboard of the targets being pinged. The page
+// itself is static. It gets a snapshot of every target from a websocket at
+// /ws, once a second, and draws a table like the text UI's.
+package web
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+	"github.com/pcekm/vasily/internal/util"
+	"github.com/pcekm/vasily/internal/view"
+)
+
+const (
+	// DefaultInterval is the default time between updates.
+	DefaultInterval = time.Second
+
+	// DefaultHistory is the default number of recent results sent for each
+	// target's latency graph.
+	DefaultHistory = 60
+)
+
+//go:embed static
+var static embed.FS
+
+// Options control the dashboard.
+type Options struct {
+	// Interval is the time between updates. Defaults to DefaultInterval.
+	Interval time.Duration
+
+	// History is the number of recent results sent for each target.
+	// Defaults to DefaultHistory.
+	History int
+
+	// View controls how the rows are derived.
+	View *view.Options
+}
+
+func setOptionDefaults(o *Options) *Options {
+	if o == nil {
+		o = &Options{}
+	}
+	util.MaybeSetDefault(&o.Interval, DefaultInterval)
+	util.MaybeSetDefault(&o.History, DefaultHistory)
+	return o
+}
+
+// Update is the message sent over the websocket.
+type Update struct {
+	Time time.Time `json:"time"`
+	Rows []Row     `json:"rows"`
+}
+
+// Row is a target in an update. Latencies are in milliseconds.
+type Row struct {
+	Group     string     `json:"group"`
+	Index     int        `json:"index"`
+	Host      string     `json:"host"`
+	Addr      string     `json:"addr"`
+	Label     string     `json:"label,omitempty"`
+	Paused    bool       `json:"paused"`
+	Alerting  bool       `json:"alerting"`
+	Sent      int        `json:"sent"`
+	Loss      float64    `json:"loss"` // Fraction of pings lost.
+	LastMs    float64    `json:"last_ms"`
+	AvgMs     float64    `json:"avg_ms"`
+	MinMs     float64    `json:"min_ms"`
+	MaxMs     float64    `json:"max_ms"`
+	P95Ms     float64    `json:"p95_ms"`
+	JitterMs  float64    `json:"jitter_ms"`
+	StdDevMs  float64    `json:"stddev_ms"`
+	Hops      int        `json:"hops"`               // -1 if unknown.
+	DeltaMs   *float64   `json:"delta_ms,omitempty"` // Change in average from the baseline.
+	Regressed bool       `json:"regressed,omitempty"`
+	Results   []*float64 `json:"results"` // Oldest first. Null for failures.
+}
+
+// Server serves the dashboard.
+type Server struct {
+	m    *targets.Manager
+	opts *Options
+	ln   net.Listener
+	srv  *http.Server
+
+	// Closed to stop the websocket streams, which the http.Server doesn't
+	// track once they've been hijacked.
+	done   chan any
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listen starts listening for connections on a TCP address.
+func Listen(addr string, m *targets.Manager, opts *Options) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		m:    m,
+		opts: setOptionDefaults(opts),
+		ln:   ln,
+		done: make(chan any),
+	}
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(files))
+	mux.Handle("/ws", websocket.Server{Handshake: checkOrigin, Handler: s.stream})
+	s.srv = &http.Server{Handler: mux}
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Serve serves requests until the server is closed, and then returns nil.
+func (s *Server) Serve() error {
+	err := s.srv.Serve(s.ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the server and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+	s.mu.Unlock()
+	err := s.srv.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Only accepts websockets opened by the dashboard itself, so that other
+// sites can't read the results through the user's browser.
+func checkOrigin(cfg *websocket.Config, req *http.Request) error {
+	origin, err := url.Parse(req.Header.Get("Origin"))
+	if err != nil || origin.Host != req.Host {
+		return errors.New("cross-origin websocket")
+	}
+	cfg.Origin = origin
+	return nil
+}
+
+// Sends updates over a websocket until it fails or the server is closed.
+func (s *Server) stream(ws *websocket.Conn) {
+	if !s.track() {
+		return
+	}
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := websocket.JSON.Send(ws, s.update(time.Now())); err != nil {
+			log.Printf("Dashboard connection from %v closed: %v", ws.Request().RemoteAddr, err)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Records a stream, which must call s.wg.Done when it's finished. Returns
+// false if the server has been closed.
+func (s *Server) track() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+// Takes a snapshot of the targets.
+func (s *Server) update(now time.Time) Update {
+	u := Update{Time: now, Rows: []Row{}}
+	for _, t := range s.m.Targets() {
+		r := view.FromTarget(t, s.opts.View)
+		st := r.Stats
+		row := Row{
+			Group:    r.Group,
+			Index:    r.Index,
+			Host:     r.Host,
+			Addr:     util.IP(r.Addr).String(),
+			Label:    r.Label,
+			Paused:   r.Paused,
+			Alerting: r.Alerting,
+			Sent:     st.N,
+			LastMs:   toMs(st.LastLatency),
+			AvgMs:    toMs(st.AvgLatency),
+			MinMs:    toMs(st.MinLatency),
+			MaxMs:    toMs(st.MaxLatency),
+			P95Ms:    toMs(st.P95),
+			JitterMs: toMs(st.Jitter),
+			StdDevMs: toMs(st.StdDev),
+			Hops:     r.Hops,
+			Results:  s.results(t.Pinger),
+		}
+		if st.N > 0 {
+			row.Loss = st.PacketLoss()
+		}
+		if r.Baseline != nil {
+			d := toMs(r.Baseline.AvgDelta)
+			row.DeltaMs = &d
+			row.Regressed = r.Baseline.Regressed
+		}
+		u.Rows = append(u.Rows, row)
+	}
+	return u
+}
+
+// Returns the latencies of a pinger's recent results, oldest first. Pings
+// still waiting for a reply, and gaps, are left out.
+func (s *Server) results(p *pinger.Pinger) []*float64 {
+	res := make([]*float64, 0, s.opts.History)
+	for _, r := range p.RevResults() {
+		if len(res) == s.opts.History {
+			break
+		}
+		switch r.Type {
+		case pinger.Waiting, pinger.Gap:
+			continue
+		case pinger.Success:
+			ms := toMs(r.Latency)
+			res = append(res, &ms)
+		default:
+			res = append(res, nil)
+		}
+	}
+	slices.Reverse(res)
+	return res
+}
+
+func toMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"golang.org/x/net/websocket"
+
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/targets"
+)
+
+// Starts a server for m, which is closed when the test finishes.
+func startServer(t *testing.T, m *targets.Manager) *Server {
+	t.Helper()
+	srv, err := Listen("127.0.0.1:0", m, &Options{Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- srv.Serve() }()
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Errorf("Close error: %v", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Serve error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for Serve to return")
+		}
+	})
+	return srv
+}
+
+func TestServer_Page(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	srv := startServer(t, m)
+	for _, path := range []string{"/", "/app.js", "/style.css"} {
+		resp, err := http.Get("http://" + srv.Addr().String() + path)
+		if err != nil {
+			t.Fatalf("Get(%v) error: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || len(body) == 0 {
+			t.Errorf("Get(%v) = %v with %d bytes (want 200 OK with content)", path, resp.Status, len(body))
+		}
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1")}
+	key := targets.Key{Group: "path", Index: 1}
+	m.Feed(key, addr, 0, pinger.PingResult{Type: pinger.Success, Latency: 1500 * time.Microsecond})
+	m.Feed(key, addr, 1, pinger.PingResult{Type: pinger.Dropped})
+	srv := startServer(t, m)
+
+	base := "http://" + srv.Addr().String()
+	ws, err := websocket.Dial("ws://"+srv.Addr().String()+"/ws", "", base)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer ws.Close()
+	ws.SetDeadline(time.Now().Add(5 * time.Second))
+	var u Update
+	for range 2 {
+		if err := websocket.JSON.Receive(ws, &u); err != nil {
+			t.Fatalf("Receive error: %v", err)
+		}
+	}
+	lat := 1.5
+	want := []Row{{
+		Group:   "path",
+		Index:   1,
+		Host:    "192.0.2.1",
+		Addr:    "192.0.2.1",
+		Sent:    2,
+		Loss:    0.5,
+		LastMs:  1.5,
+		AvgMs:   1.5,
+		MinMs:   1.5,
+		MaxMs:   1.5,
+		Hops:    -1,
+		Results: []*float64{&lat, nil},
+	}}
+	if diff := cmp.Diff(want, u.Rows, cmpopts.IgnoreFields(Row{}, "P95Ms", "JitterMs", "StdDevMs")); diff != "" {
+		t.Errorf("Wrong rows (-want, +got):\n%v", diff)
+	}
+}
+
+func TestServer_CrossOrigin(t *testing.T) {
+	m := targets.New(nil)
+	defer m.Close()
+	srv := startServer(t, m)
+	_, err := websocket.Dial("ws://"+srv.Addr().String()+"/ws", "", "http://evil.example")
+	if err == nil || !strings.Contains(err.Error(), "bad status") {
+		t.Errorf("Dial from another origin = %v (want bad status)", err)
+	}
+}
@@ -0,0 +1,36 @@
+package ping_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/pcekm/vasily/pkg/ping"
+)
+
+func ExamplePing() {
+	st, err := ping.Ping(context.Background(), "example.com", &ping.Options{
+		Count: 5,
+		OnResult: func(r ping.Result) {
+			fmt.Printf("seq=%d %v %v\n", r.Seq, r.Status, r.Latency)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d sent, %.0f%% lost, avg %v\n", st.Sent, 100*st.Loss(), st.Avg)
+}
+
+func ExampleTrace() {
+	addr, err := ping.Resolve("example.com")
+	if err != nil {
+		log.Fatal(err)
+	}
+	hops, err := ping.Trace(context.Background(), addr, &ping.TraceOptions{Protocol: ping.ICMP})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, h := range hops {
+		fmt.Println(h.Pos, h.Addr)
+	}
+}
@@ -0,0 +1,324 @@
+// Package ping lets other Go programs ping and trace hosts with vasily's
+// probing engine.
+//
+// Unlike vasily's internal packages, this package's API is stable: it only
+// changes in backward compatible ways within a major version of the module.
+// It uses its own types rather than the internal ones, so that the internals
+// can keep changing underneath it.
+//
+// On Linux and macOS, the [ICMP] and [UDP] protocols work without special
+// privileges, though Linux only allows unprivileged ICMP for the groups in the
+// net.ipv4.ping_group_range sysctl. Other systems, and builds with the rawsock
+// tag, need raw sockets, and so root.
+package ping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	_ "github.com/pcekm/vasily/internal/backend/dns"
+	_ "github.com/pcekm/vasily/internal/backend/http"
+	_ "github.com/pcekm/vasily/internal/backend/icmp"
+	_ "github.com/pcekm/vasily/internal/backend/udp"
+	"github.com/pcekm/vasily/internal/lookup"
+	"github.com/pcekm/vasily/internal/pinger"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// Protocol is a way of sending pings.
+type Protocol string
+
+// Protocols.
+const (
+	// ICMP sends ICMP echo requests, like ping.
+	ICMP Protocol = "icmp"
+
+	// UDP sends UDP datagrams to unused ports, like traceroute. Hosts
+	// answer with ICMP port unreachable errors.
+	UDP Protocol = "udp"
+
+	// DNS sends DNS queries for each host's reverse DNS name.
+	DNS Protocol = "dns"
+
+	// HTTP sends GET requests to each host, timing the responses.
+	HTTP Protocol = "http"
+)
+
+// Status is the outcome of a ping.
+type Status int
+
+// Statuses. New ones may be added at the end.
+const (
+	// Replied means the host replied.
+	Replied Status = iota
+
+	// Lost means no reply arrived before the timeout.
+	Lost
+
+	// Duplicate is an extra reply to a ping that was already answered.
+	Duplicate
+
+	// TTLExceeded means a router dropped the ping for having gone too many
+	// hops.
+	TTLExceeded
+
+	// Unreachable means a router reported the host unreachable.
+	Unreachable
+
+	// Late is a reply that arrived after its ping was reported [Lost].
+	Late
+
+	// Corrupted is a reply whose payload had been changed along the way.
+	Corrupted
+)
+
+func (s Status) String() string {
+	switch s {
+	case Replied:
+		return "Replied"
+	case Lost:
+		return "Lost"
+	case Duplicate:
+		return "Duplicate"
+	case TTLExceeded:
+		return "TTLExceeded"
+	case Unreachable:
+		return "Unreachable"
+	case Late:
+		return "Late"
+	case Corrupted:
+		return "Corrupted"
+	default:
+		return fmt.Sprintf("(unknown:%d)", int(s))
+	}
+}
+
+// Maps the pinger's result types to statuses. Waiting and Gap results aren't
+// outcomes, and aren't reported.
+var statuses = map[pinger.ResultType]Status{
+	pinger.Success:     Replied,
+	pinger.Dropped:     Lost,
+	pinger.Duplicate:   Duplicate,
+	pinger.TTLExceeded: TTLExceeded,
+	pinger.Unreachable: Unreachable,
+	pinger.Late:        Late,
+	pinger.Corrupted:   Corrupted,
+}
+
+// Result is the outcome of a single ping.
+type Result struct {
+	// Seq counts the pings sent, starting from zero.
+	Seq int
+
+	// Status is the outcome.
+	Status Status
+
+	// Time is when the ping was sent.
+	Time time.Time
+
+	// Latency is the round trip time. Zero unless a reply arrived.
+	Latency time.Duration
+
+	// Peer is the address that replied, which may be a router reporting
+	// an error. Nil if there was no reply.
+	Peer net.IP
+
+	// TTL is the TTL or hop limit the reply arrived with, or zero if
+	// unknown.
+	TTL int
+}
+
+// Stats summarizes the pings completed so far.
+type Stats struct {
+	// Sent is the number of pings completed, either by a reply or by timing
+	// out. Pings still waiting for a reply aren't counted until they do one
+	// or the other.
+	Sent int
+
+	// Lost is the number of completed pings without a reply.
+	Lost int
+
+	// Duplicates is the number of extra replies.
+	Duplicates int
+
+	// Min, Avg and Max are the lowest, average and highest latencies of the
+	// replies.
+	Min, Avg, Max time.Duration
+
+	// StdDev is the standard deviation of the latencies.
+	StdDev time.Duration
+}
+
+// Loss returns the fraction of completed pings that were lost.
+func (s Stats) Loss() float64 {
+	if s.Sent == 0 {
+		return 0
+	}
+	return float64(s.Lost) / float64(s.Sent)
+}
+
+// Source binds pings to a local interface or address. The zero value
+// doesn't bind to anything.
+type Source struct {
+	// Interface is the name of the interface to send from. Empty for any.
+	Interface string
+
+	// Addr is the address to send from. Nil for any. It must be of the same
+	// IP version as the destination.
+	Addr net.IP
+}
+
+func (s Source) option() backend.SourceOption {
+	return backend.SourceOption{Interface: s.Interface, Addr: s.Addr}
+}
+
+// Options holds options for [NewPinger] and [Ping]. A nil Options uses the
+// defaults.
+type Options struct {
+	// Protocol is the protocol to ping with. Defaults to [ICMP].
+	Protocol Protocol
+
+	// Count is the number of pings to send. Zero pings until the context
+	// passed to [Pinger.Run] is canceled.
+	Count int
+
+	// Interval is the time between pings. Defaults to 1s, which is also the
+	// shortest the ICMP and UDP protocols allow.
+	Interval time.Duration
+
+	// Timeout is how long to wait for each reply. Defaults to 1s.
+	Timeout time.Duration
+
+	// PayloadSize is the number of data bytes in each ping. Defaults to
+	// the protocol's usual size.
+	PayloadSize int
+
+	// Source binds the pings to a local interface or address.
+	Source Source
+
+	// OnResult, if set, is called with the outcome of each ping, one at a
+	// time, in the order they're known. A lost ping is only known once it
+	// times out. The pinger waits for it if it falls behind, so it should
+	// return quickly. [Pinger.Run] returns after the last call.
+	OnResult func(Result)
+}
+
+func (o *Options) protocol() Protocol {
+	if o == nil || o.Protocol == "" {
+		return ICMP
+	}
+	return o.Protocol
+}
+
+// Converts the options to the pinger's.
+func (o *Options) pingerOptions() *pinger.Options {
+	if o == nil {
+		return nil
+	}
+	opts := &pinger.Options{
+		NPings:      o.Count,
+		Interval:    o.Interval,
+		Timeout:     o.Timeout,
+		PayloadSize: o.PayloadSize,
+		Source:      o.Source.option(),
+	}
+	if o.OnResult != nil {
+		opts.BlockOnResult = true
+		opts.OnResult = func(seq int, res pinger.PingResult) {
+			if r, ok := result(seq, res); ok {
+				o.OnResult(r)
+			}
+		}
+	}
+	return opts
+}
+
+// Converts a pinger result. Returns false if it isn't an outcome.
+func result(seq int, res pinger.PingResult) (Result, bool) {
+	st, ok := statuses[res.Type]
+	if !ok {
+		return Result{}, false
+	}
+	r := Result{Seq: seq, Status: st, Time: res.Time, TTL: res.TTL}
+	if res.Peer != nil {
+		r.Peer = util.IP(res.Peer)
+	}
+	switch st {
+	case Replied, Duplicate, Late:
+		r.Latency = res.Latency
+	}
+	return r, true
+}
+
+// Pinger pings a single host. It's safe for concurrent use.
+type Pinger struct {
+	p *pinger.Pinger
+}
+
+// NewPinger creates a pinger for a host's address. Close it when done.
+func NewPinger(addr net.IP, opts *Options) (*Pinger, error) {
+	if addr == nil {
+		return nil, errors.New("no address to ping")
+	}
+	dest := &net.UDPAddr{IP: addr}
+	p, err := pinger.New(backend.Name(opts.protocol()), util.AddrVersion(dest), dest, opts.pingerOptions())
+	if err != nil {
+		return nil, err
+	}
+	return &Pinger{p: p}, nil
+}
+
+// Run pings until [Options.Count] pings have been sent and answered or timed
+// out, or until ctx is canceled.
+func (p *Pinger) Run(ctx context.Context) {
+	p.p.Run(ctx)
+}
+
+// Stats returns statistics for the pings completed so far. See [Stats.Sent].
+func (p *Pinger) Stats() Stats {
+	st := p.p.Stats()
+	return Stats{
+		Sent:       st.N,
+		Lost:       st.Failures,
+		Duplicates: st.Duplicates,
+		Min:        st.MinLatency,
+		Avg:        st.AvgLatency,
+		Max:        st.MaxLatency,
+		StdDev:     st.StdDev,
+	}
+}
+
+// Close releases the pinger's connection.
+func (p *Pinger) Close() error {
+	return p.p.Close()
+}
+
+// Resolve looks up a host name or parses an address, preferring IPv4 if a
+// name has both.
+func Resolve(host string) (net.IP, error) {
+	addr, err := lookup.String(host)
+	if err != nil {
+		return nil, err
+	}
+	return addr.IP, nil
+}
+
+// Ping pings a host name or address until [Options.Count] pings have been
+// sent, or until ctx is canceled, and returns the statistics.
+func Ping(ctx context.Context, host string, opts *Options) (Stats, error) {
+	addr, err := Resolve(host)
+	if err != nil {
+		return Stats{}, err
+	}
+	p, err := NewPinger(addr, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer p.Close()
+	p.Run(ctx)
+	return p.Stats(), nil
+}
@@ -0,0 +1,141 @@
+package ping
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	gocmp "github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/backend/test"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPinger(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	conn.MockPingExchange(test.NewPingExchange(0).SetNoReply(true))
+	conn.MockPingExchange(test.NewPingExchange(1))
+	conn.MockClose()
+	name := test.RegisterMock(conn)
+
+	var mu sync.Mutex
+	var got []Result
+	p, err := NewPinger(test.LoopbackV4.IP, &Options{
+		Protocol: Protocol(name),
+		Count:    2,
+		Interval: time.Microsecond,
+		Timeout:  time.Millisecond,
+		OnResult: func(r Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, r)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPinger error: %v", err)
+	}
+	if !test.WithTimeout(func() { p.Run(context.Background()) }, time.Second) {
+		t.Error("Timed out waiting for pinger completion.")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("Error closing pinger: %v", err)
+	}
+
+	want := []Result{
+		{Seq: 0, Status: Lost},
+		{Seq: 1, Status: Replied, Peer: test.LoopbackV4.IP},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	slices.SortFunc(got, func(a, b Result) int { return cmp.Compare(a.Seq, b.Seq) })
+	if diff := gocmp.Diff(want, got, cmpopts.IgnoreFields(Result{}, "Time", "Latency")); diff != "" {
+		t.Errorf("Wrong results (-want, +got):\n%v", diff)
+	}
+	st := p.Stats()
+	if st.Sent != 2 || st.Lost != 1 || st.Loss() != 0.5 {
+		t.Errorf("Wrong stats: %+v", st)
+	}
+
+	ctrl.Finish()
+}
+
+func TestNewPinger_NoAddr(t *testing.T) {
+	if _, err := NewPinger(nil, nil); err == nil {
+		t.Errorf("NewPinger(nil) succeeded.")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	hop := func(n int) *net.UDPAddr { return &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(n))} }
+	dest := hop(2)
+	exchange := func(ttl int, peer *net.UDPAddr, tp backend.PacketType) *test.PingExchangeOpts {
+		opts := test.NewPingExchange(ttl - 1).SetTTL(ttl).SetPeer(peer).SetRespType(tp)
+		opts.Dest = dest
+		return opts
+	}
+
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	for range 2 {
+		conn.MockPingExchange(exchange(1, hop(1), backend.PacketTimeExceeded))
+		conn.MockPingExchange(exchange(2, dest, backend.PacketReply))
+	}
+
+	var probes []Probe
+	hops, err := Trace(context.Background(), dest.IP, &TraceOptions{
+		Protocol: Protocol(name),
+		Queries:  2,
+		Interval: time.Microsecond,
+		OnProbe:  func(p Probe) { probes = append(probes, p) },
+	})
+	if err != nil {
+		t.Errorf("Trace error: %v", err)
+	}
+	wantHops := []Hop{{Pos: 1, Addr: hop(1).IP}, {Pos: 2, Addr: dest.IP}}
+	if diff := gocmp.Diff(wantHops, hops); diff != "" {
+		t.Errorf("Wrong hops (-want, +got):\n%v", diff)
+	}
+	wantProbes := []Probe{
+		{Pos: 1, Addr: hop(1).IP},
+		{Pos: 2, Addr: dest.IP},
+		{Pos: 1, Addr: hop(1).IP},
+		{Pos: 2, Addr: dest.IP},
+	}
+	if diff := gocmp.Diff(wantProbes, probes, cmpopts.IgnoreFields(Probe{}, "Latency")); diff != "" {
+		t.Errorf("Wrong probes (-want, +got):\n%v", diff)
+	}
+
+	ctrl.Finish()
+}
+
+func TestTrace_MaxTTL(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	conn := test.NewMockConn(ctrl)
+	name := test.RegisterMock(conn)
+	conn.EXPECT().Close().Return(nil)
+	dest := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 9)}
+	opts := test.NewPingExchange(0).SetTTL(1).SetRespType(backend.PacketTimeExceeded)
+	opts.Dest = dest
+	conn.MockPingExchange(opts)
+
+	_, err := Trace(context.Background(), dest.IP, &TraceOptions{
+		Protocol: Protocol(name),
+		MaxTTL:   2,
+		Queries:  1,
+		Interval: time.Microsecond,
+	})
+	if !errors.Is(err, ErrMaxTTL) {
+		t.Errorf("Trace error = %v (want %v)", err, ErrMaxTTL)
+	}
+
+	ctrl.Finish()
+}
@@ -0,0 +1,140 @@
+package ping
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/pcekm/vasily/internal/backend"
+	"github.com/pcekm/vasily/internal/tracer"
+	"github.com/pcekm/vasily/internal/util"
+)
+
+// ErrMaxTTL is returned by [Trace] when it runs out of hops before reaching
+// the host.
+var ErrMaxTTL = errors.New("maximum TTL reached")
+
+// TraceOptions holds options for [Trace]. A nil TraceOptions uses the
+// defaults.
+type TraceOptions struct {
+	// Protocol is the protocol to trace with. Defaults to [UDP]. Only [ICMP]
+	// and [UDP] can trace.
+	Protocol Protocol
+
+	// MaxTTL is the maximum path length to trace. Defaults to 64.
+	MaxTTL int
+
+	// Queries is the number of probes sent to each hop. Defaults to 3.
+	Queries int
+
+	// Interval is the time between probes. Defaults to 1s.
+	Interval time.Duration
+
+	// Paris keeps the probes in a single flow, like Paris traceroute, so
+	// that load balancers send them all along the same path.
+	Paris bool
+
+	// Port is the destination port of the first [UDP] probe. Later probes go
+	// to the ports after it, like traceroute's. Defaults to 33434.
+	Port int
+
+	// FixedPort sends every [UDP] probe to Port, for getting through
+	// firewalls that only let one port through. It implies Paris.
+	FixedPort bool
+
+	// Source binds the probes to a local interface or address.
+	Source Source
+
+	// OnProbe, if set, is called with the result of every probe, from the
+	// goroutine running the trace. It should return quickly.
+	OnProbe func(Probe)
+}
+
+func (o *TraceOptions) protocol() Protocol {
+	if o == nil || o.Protocol == "" {
+		return UDP
+	}
+	return o.Protocol
+}
+
+// Converts the options to the tracer's.
+func (o *TraceOptions) tracerOptions() *tracer.Options {
+	if o == nil {
+		return nil
+	}
+	opts := &tracer.Options{
+		Interval:     o.Interval,
+		ProbesPerHop: o.Queries,
+		MaxTTL:       o.MaxTTL,
+		Paris:        o.Paris,
+		BasePort:     o.Port,
+		FixedPort:    o.FixedPort,
+		Source:       o.Source.option(),
+	}
+	if o.OnProbe != nil {
+		opts.OnProbe = func(p tracer.Probe) { o.OnProbe(probe(p)) }
+	}
+	return opts
+}
+
+// Hop is a host in the path to another.
+type Hop struct {
+	// Pos is the hop's position in the path, starting from 1.
+	Pos int
+
+	// Addr is the hop's address.
+	Addr net.IP
+}
+
+// Probe is the result of a single trace probe.
+type Probe struct {
+	// Pos is the position in the path that was probed.
+	Pos int
+
+	// Addr is the address that replied. If the probe was lost, it's the
+	// last address that replied at this position, or nil if none has.
+	Addr net.IP
+
+	// Lost is true if no reply arrived in time.
+	Lost bool
+
+	// Latency is the round trip time. Zero if the probe was lost.
+	Latency time.Duration
+}
+
+// Converts a tracer probe.
+func probe(p tracer.Probe) Probe {
+	return Probe{Pos: p.Pos, Addr: util.IP(p.Host), Lost: p.Lost, Latency: p.Latency}
+}
+
+// Trace finds the path to a host's address. Returns the hops found, in order
+// of position, along with any error. A position can have more than one hop if
+// the path changed, or load balancers sent probes different ways. Positions
+// where nothing replied are left out. Canceling ctx stops the trace, and
+// returns ctx's error.
+func Trace(ctx context.Context, addr net.IP, opts *TraceOptions) ([]Hop, error) {
+	if addr == nil {
+		return nil, errors.New("no address to trace")
+	}
+	dest := &net.UDPAddr{IP: addr}
+	steps := make(chan tracer.Step)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tracer.TraceRoute(ctx, backend.Name(opts.protocol()), util.AddrVersion(dest), dest, steps, opts.tracerOptions())
+	}()
+	var hops []Hop
+	for s := range steps {
+		hops = append(hops, Hop{Pos: s.Pos, Addr: util.IP(s.Host)})
+	}
+	// Steps arrive in the order they're found, which goes through the path
+	// once for each query.
+	slices.SortStableFunc(hops, func(a, b Hop) int { return cmp.Compare(a.Pos, b.Pos) })
+	err := <-errs
+	if errors.Is(err, tracer.ErrMaxTTL) {
+		err = ErrMaxTTL
+	}
+	return hops, err
+}